@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+var (
+	awsMetadataURL = "http://169.254.169.254/latest"
+	gcpMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+	metadataClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+func readMetadata(req *http.Request) (string, error) {
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", req.URL, resp.Status)
+	}
+	return string(body), nil
+}
+
+// getAWSCredentials fetches the signed instance identity document using
+// IMDSv2.
+func getAWSCredentials() (url.Values, error) {
+	req, err := http.NewRequest("PUT", awsMetadataURL+"/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := readMetadata(req)
+	if err != nil {
+		return nil, err
+	}
+	get := func(path string) (string, error) {
+		req, err := http.NewRequest("GET",
+			awsMetadataURL+"/dynamic/instance-identity/"+path, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+		return readMetadata(req)
+	}
+	document, err := get("document")
+	if err != nil {
+		return nil, err
+	}
+	signature, err := get("signature")
+	if err != nil {
+		return nil, err
+	}
+	return url.Values{
+		"aws_identity_document":  {document},
+		"aws_identity_signature": {signature},
+	}, nil
+}
+
+func getGCPCredentials() (url.Values, error) {
+	audience := *gcpAudience
+	if audience == "" {
+		audience = *keymasterURL
+	}
+	req, err := http.NewRequest("GET", gcpMetadataURL+
+		"/instance/service-accounts/default/identity?format=full&audience="+
+		url.QueryEscape(audience), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	token, err := readMetadata(req)
+	if err != nil {
+		return nil, err
+	}
+	return url.Values{"gcp_identity_token": {strings.TrimSpace(token)}}, nil
+}
+
+func getBootstrapCredentials() (url.Values, error) {
+	token, err := ioutil.ReadFile(*bootstrapTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	names := splitList(*hostnames)
+	if len(names) < 1 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		names = []string{hostname}
+	}
+	return url.Values{
+		"bootstrap_token": {strings.TrimSpace(string(token))},
+		"hostname":        names,
+	}, nil
+}
+
+// getCredentials returns the form values which authenticate the host.
+func getCredentials(logger log.DebugLogger) (url.Values, error) {
+	if *bootstrapTokenFile != "" {
+		return getBootstrapCredentials()
+	}
+	switch *identityProvider {
+	case "aws":
+		return getAWSCredentials()
+	case "gcp":
+		return getGCPCredentials()
+	case "auto":
+		credentials, awsErr := getAWSCredentials()
+		if awsErr == nil {
+			return credentials, nil
+		}
+		logger.Debugf(1, "not on AWS: %s\n", awsErr)
+		credentials, gcpErr := getGCPCredentials()
+		if gcpErr == nil {
+			return credentials, nil
+		}
+		logger.Debugf(1, "not on GCP: %s\n", gcpErr)
+		return nil, errors.New("no instance identity available")
+	default:
+		return nil, fmt.Errorf("unknown identity provider: %s",
+			*identityProvider)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"github.com/Cloud-Foundations/keymaster/lib/fsutil"
+	"golang.org/x/crypto/ssh"
+)
+
+const hostCertgenPath = "/api/v0/hostCertgen"
+
+// requestCertificate requests a certificate for the host public key.
+func requestCertificate(client *http.Client, credentials url.Values,
+	hostPubKey []byte) ([]byte, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fileWriter, err := writer.CreateFormFile("pubkeyfile", "host.pub")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fileWriter.Write(hostPubKey); err != nil {
+		return nil, err
+	}
+	for name, values := range credentials {
+		for _, value := range values {
+			if err := writer.WriteField(name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	resp, err := client.Post(strings.TrimSuffix(*keymasterURL, "/")+
+		hostCertgenPath, writer.FormDataContentType(), body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting certificate: %s: %s",
+			resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// getCertFilename returns the name sshd and ssh-keygen use for the
+// certificate of a public key file.
+func getCertFilename(pubKeyFilename string) string {
+	return strings.TrimSuffix(pubKeyFilename, ".pub") + "-cert.pub"
+}
+
+// installCertificates gets and writes certificates for the host key files
+// which exist and updates the sshd configuration to use them. It returns the
+// earliest expiry time.
+func installCertificates(client *http.Client, credentials url.Values,
+	pubKeyFilenames []string, logger log.DebugLogger) (time.Time, error) {
+	var expiresAt time.Time
+	var sshdConfig bytes.Buffer
+	fmt.Fprintln(&sshdConfig, "# Written by keymaster-hostagent.")
+	for _, pubKeyFilename := range pubKeyFilenames {
+		hostPubKey, err := ioutil.ReadFile(pubKeyFilename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				logger.Debugf(1, "skipping missing key: %s\n", pubKeyFilename)
+				continue
+			}
+			return time.Time{}, err
+		}
+		rotate, err := keyNeedsRotation(pubKeyFilename)
+		if err != nil {
+			return time.Time{}, err
+		}
+		var newKey *newHostKey
+		if rotate {
+			newKey, err = generateHostKey(pubKeyFilename, hostPubKey)
+			if err != nil {
+				return time.Time{}, err
+			}
+			hostPubKey = newKey.pubKey
+		}
+		certData, err := requestCertificate(client, credentials, hostPubKey)
+		if err != nil {
+			newKey.discard()
+			return time.Time{}, err
+		}
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey(certData)
+		if err != nil {
+			newKey.discard()
+			return time.Time{}, err
+		}
+		cert, ok := pubKey.(*ssh.Certificate)
+		if !ok || cert.CertType != ssh.HostCert {
+			newKey.discard()
+			return time.Time{}, errors.New("response is not a host certificate")
+		}
+		// Replace the key just before its certificate, so that sshd serves
+		// a mismatched pair for as short a time as possible.
+		if err := newKey.install(); err != nil {
+			return time.Time{}, err
+		}
+		if newKey != nil {
+			logger.Printf("rotated host key: %s\n", pubKeyFilename)
+		}
+		certFilename := getCertFilename(pubKeyFilename)
+		err = fsutil.WriteFileAtomically(certFilename, certData, 0644)
+		if err != nil {
+			return time.Time{}, err
+		}
+		certExpiresAt := time.Unix(int64(cert.ValidBefore), 0)
+		if expiresAt.IsZero() || certExpiresAt.Before(expiresAt) {
+			expiresAt = certExpiresAt
+		}
+		absFilename, err := filepath.Abs(certFilename)
+		if err != nil {
+			return time.Time{}, err
+		}
+		fmt.Fprintf(&sshdConfig, "HostCertificate %s\n", absFilename)
+		logger.Printf("installed %s, principals: %s, serial: %d\n",
+			certFilename, strings.Join(cert.ValidPrincipals, ","), cert.Serial)
+	}
+	if expiresAt.IsZero() {
+		return time.Time{}, errors.New("no host keys found")
+	}
+	if *sshdConfigFile != "" {
+		oldConfig, _ := ioutil.ReadFile(*sshdConfigFile)
+		if !bytes.Equal(oldConfig, sshdConfig.Bytes()) {
+			err := os.MkdirAll(filepath.Dir(*sshdConfigFile), 0755)
+			if err != nil {
+				return time.Time{}, err
+			}
+			err = fsutil.WriteFileAtomically(*sshdConfigFile,
+				sshdConfig.Bytes(), 0644)
+			if err != nil {
+				return time.Time{}, err
+			}
+		}
+	}
+	return expiresAt, nil
+}
+
+// reloadSshd runs the reload command, since sshd only reads host
+// certificates when it starts or reloads.
+func reloadSshd() error {
+	fields := strings.Fields(*reloadCommand)
+	if len(fields) < 1 {
+		return nil
+	}
+	output, err := exec.Command(fields[0], fields[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running %s: %s: %s", *reloadCommand, err,
+			strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// keymaster-hostagent requests SSH host certificates from keymaster for the
+// host keys of the machine it runs on, installs them for sshd and renews them
+// before they expire. It authenticates with the cloud instance identity
+// (AWS or GCP) or with a bootstrap token, so it can run from cloud-init.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/Dominator/lib/log/cmdlogger"
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+var (
+	Version            = "No version provided"
+	bootstrapTokenFile = flag.String("bootstrapTokenFile", "",
+		"File containing a host bootstrap token (default: use instance identity)")
+	gcpAudience = flag.String("gcpAudience", "",
+		"Audience for GCP identity tokens (default: keymasterURL)")
+	hostKeyFiles = flag.String("hostKeyFiles",
+		"/etc/ssh/ssh_host_ed25519_key.pub,/etc/ssh/ssh_host_rsa_key.pub",
+		"Comma separated list of host public key files")
+	hostnames = flag.String("hostnames", "",
+		"Comma separated hostnames to request with a bootstrap token (default: hostname)")
+	identityProvider = flag.String("identityProvider", "auto",
+		"Instance identity provider: auto, aws or gcp")
+	keyRotationInterval = flag.Duration("keyRotationInterval", 0,
+		"Replace host keys older than this with new ones (0: never)")
+	keymasterURL = flag.String("keymasterURL", "",
+		"The keymaster URL, e.g. https://keymaster.example.com")
+	once = flag.Bool("once", false,
+		"Exit after installing the certificates instead of renewing them")
+	reloadCommand = flag.String("reloadCommand", "systemctl reload sshd",
+		"Command to make sshd load new certificates (empty: none)")
+	reportStatusToKeymaster = flag.Bool("reportStatus", true,
+		"Report the outcome of renewals to keymaster")
+	retryInterval = flag.Duration("retryInterval", 5*time.Minute,
+		"Interval between attempts when requesting certificates fails")
+	rootCAFile = flag.String("rootCAFile", "",
+		"PEM file with the CA certificates for keymaster (default: system)")
+	sshdConfigFile = flag.String("sshdConfigFile",
+		"/etc/ssh/sshd_config.d/keymaster-hostcert.conf",
+		"sshd configuration file to write HostCertificate directives to")
+	sshKeygenCommand = flag.String("sshKeygenCommand", "ssh-keygen",
+		"Command used to generate host keys when rotating them")
+)
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s (version %s):\n", os.Args[0], Version)
+	flag.PrintDefaults()
+}
+
+func makeHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if *rootCAFile != "" {
+		caData, err := ioutil.ReadFile(*rootCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caData) {
+			return nil, errors.New("no certificates in: " + *rootCAFile)
+		}
+	}
+	return &http.Client{
+		Timeout:   time.Minute,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// renew rotates the host keys which are due, requests and installs
+// certificates, and returns the earliest expiry. The outcome is reported to
+// keymaster if the host could authenticate.
+func renew(client *http.Client, logger log.DebugLogger) (time.Time, error) {
+	credentials, err := getCredentials(logger)
+	if err != nil {
+		return time.Time{}, err
+	}
+	pubKeyFilenames := splitList(*hostKeyFiles)
+	expiresAt, err := installCertificates(client, credentials,
+		pubKeyFilenames, logger)
+	if err == nil {
+		err = reloadSshd()
+	}
+	if *reportStatusToKeymaster {
+		reportErr := reportStatus(client, credentials, expiresAt,
+			getOldestKeyTime(pubKeyFilenames), err)
+		if reportErr != nil {
+			logger.Printf("%s\n", reportErr)
+		}
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expiresAt, nil
+}
+
+func main() {
+	flag.Usage = Usage
+	flag.Parse()
+	logger := cmdlogger.New()
+	if *keymasterURL == "" {
+		logger.Fatal("keymasterURL parameter is required")
+	}
+	client, err := makeHTTPClient()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	for {
+		expiresAt, err := renew(client, logger)
+		if err != nil {
+			if *once {
+				logger.Fatal(err)
+			}
+			logger.Printf("error renewing host certificates: %s\n", err)
+			time.Sleep(*retryInterval)
+			continue
+		}
+		if *once {
+			return
+		}
+		// Renew half way through the remaining validity, or when a key is
+		// due for rotation.
+		sleepTime := time.Until(expiresAt) / 2
+		nextRotation := getNextKeyRotation(splitList(*hostKeyFiles))
+		if !nextRotation.IsZero() && time.Until(nextRotation) < sleepTime {
+			sleepTime = time.Until(nextRotation)
+		}
+		if sleepTime < *retryInterval {
+			sleepTime = *retryInterval
+		}
+		logger.Printf("certificates expire at %s, renewing in %s\n",
+			expiresAt.Format(time.RFC3339), sleepTime.Round(time.Second))
+		time.Sleep(sleepTime)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGetAWSCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/latest/api/token" {
+				if r.Method != "PUT" {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				w.Write([]byte("imds-token"))
+				return
+			}
+			if r.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(filepath.Base(r.URL.Path)))
+		}))
+	defer server.Close()
+	awsMetadataURL = server.URL + "/latest"
+	credentials, err := getAWSCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.Get("aws_identity_document") != "document" ||
+		credentials.Get("aws_identity_signature") != "signature" {
+		t.Fatalf("unexpected credentials: %v", credentials)
+	}
+}
+
+func TestInstallCertificates(t *testing.T) {
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.NewSignerFromSigner(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSSHPub, err := ssh.NewPublicKey(hostPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != hostCertgenPath ||
+				r.FormValue("bootstrap_token") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			cert := &ssh.Certificate{
+				Key:             hostSSHPub,
+				CertType:        ssh.HostCert,
+				ValidPrincipals: r.Form["hostname"],
+				ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+			}
+			if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write(ssh.MarshalAuthorizedKey(cert))
+		}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "hostagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pubKeyFilename := filepath.Join(dir, "ssh_host_ed25519_key.pub")
+	err = ioutil.WriteFile(pubKeyFilename, ssh.MarshalAuthorizedKey(hostSSHPub),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	*keymasterURL = server.URL
+	*sshdConfigFile = filepath.Join(dir, "sshd_config.d", "keymaster.conf")
+	credentials := map[string][]string{
+		"bootstrap_token": {"secret"},
+		"hostname":        {"web-1.example.com"},
+	}
+	expiresAt, err := installCertificates(server.Client(), credentials,
+		[]string{pubKeyFilename, filepath.Join(dir, "missing.pub")},
+		testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(expiresAt) < 59*time.Minute {
+		t.Fatalf("unexpected expiry: %s", expiresAt)
+	}
+	certFilename := filepath.Join(dir, "ssh_host_ed25519_key-cert.pub")
+	certData, err := ioutil.ReadFile(certFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(certData, []byte("ssh-ed25519-cert-v01@openssh.com ")) {
+		t.Fatalf("unexpected certificate file: %s", certData)
+	}
+	sshdConfig, err := ioutil.ReadFile(*sshdConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(sshdConfig), "HostCertificate "+certFilename) {
+		t.Fatalf("unexpected sshd config: %s", sshdConfig)
+	}
+	credentials["bootstrap_token"] = []string{"wrong"}
+	_, err = installCertificates(server.Client(), credentials,
+		[]string{pubKeyFilename}, testlogger.New(t))
+	if err == nil {
+		t.Fatal("no error for rejected request")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.NewSignerFromSigner(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			file, _, err := r.FormFile("pubkeyfile")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data, _ := ioutil.ReadAll(file)
+			hostKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			cert := &ssh.Certificate{
+				Key:             hostKey,
+				CertType:        ssh.HostCert,
+				ValidPrincipals: []string{"web-1.example.com"},
+				ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+			}
+			if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write(ssh.MarshalAuthorizedKey(cert))
+		}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "hostagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFilename := filepath.Join(dir, "ssh_host_ed25519_key")
+	output, err := exec.Command("ssh-keygen", "-q", "-N", "", "-t", "ed25519",
+		"-f", keyFilename).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, output)
+	}
+	oldPubKey, err := ioutil.ReadFile(keyFilename + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*keymasterURL = server.URL
+	*sshdConfigFile = ""
+	*keyRotationInterval = time.Hour
+	defer func() { *keyRotationInterval = 0 }()
+	install := func() {
+		_, err := installCertificates(server.Client(), nil,
+			[]string{keyFilename + ".pub"}, testlogger.New(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A new key is not rotated.
+	install()
+	pubKey, err := ioutil.ReadFile(keyFilename + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pubKey, oldPubKey) {
+		t.Fatal("new key rotated")
+	}
+	oldTime := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(keyFilename, oldTime, oldTime); err != nil {
+		t.Fatal(err)
+	}
+	next := getNextKeyRotation([]string{keyFilename + ".pub"})
+	if time.Until(next) > 0 {
+		t.Fatalf("rotation not due: %s", next)
+	}
+	install()
+	pubKey, err = ioutil.ReadFile(keyFilename + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(pubKey, oldPubKey) {
+		t.Fatal("old key not rotated")
+	}
+	certData, err := ioutil.ReadFile(keyFilename + "-cert.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certKey, _, _, _, err := ssh.ParseAuthorizedKey(certData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey(pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(certKey.(*ssh.Certificate).Key.Marshal(),
+		hostKey.Marshal()) {
+		t.Fatal("certificate is not for the new key")
+	}
+	if _, err := os.Stat(keyFilename + ".new"); !os.IsNotExist(err) {
+		t.Fatalf("temporary key left behind: %v", err)
+	}
+}
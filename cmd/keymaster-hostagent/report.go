@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	hostCertStatusPath = "/api/v0/hostCertStatus"
+	maxReportedError   = 1024
+)
+
+// reportStatus tells keymaster the outcome of the latest renewal, so that
+// the expiry of host certificates can be followed across the fleet.
+func reportStatus(client *http.Client, credentials url.Values,
+	expiresAt time.Time, keyTime time.Time, renewErr error) error {
+	form := url.Values{}
+	for name, values := range credentials {
+		form[name] = values
+	}
+	if renewErr == nil {
+		form.Set("status", "ok")
+	} else {
+		form.Set("status", "error")
+		message := renewErr.Error()
+		if len(message) > maxReportedError {
+			message = message[:maxReportedError]
+		}
+		form.Set("error", message)
+	}
+	if !expiresAt.IsZero() {
+		form.Set("expires_at", strconv.FormatInt(expiresAt.Unix(), 10))
+	}
+	if !keyTime.IsZero() {
+		form.Set("key_created_at", strconv.FormatInt(keyTime.Unix(), 10))
+	}
+	resp, err := client.PostForm(strings.TrimSuffix(*keymasterURL, "/")+
+		hostCertStatusPath, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error reporting status: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshKeygenArguments maps public key types to the ssh-keygen arguments which
+// generate a new key of the same type.
+var sshKeygenArguments = map[string][]string{
+	ssh.KeyAlgoED25519:  {"-t", "ed25519"},
+	ssh.KeyAlgoRSA:      {"-t", "rsa", "-b", "3072"},
+	ssh.KeyAlgoECDSA256: {"-t", "ecdsa", "-b", "256"},
+	ssh.KeyAlgoECDSA384: {"-t", "ecdsa", "-b", "384"},
+	ssh.KeyAlgoECDSA521: {"-t", "ecdsa", "-b", "521"},
+}
+
+// newHostKey is a generated host key which has not replaced the current one
+// yet. The methods may be called on a nil *newHostKey, which does nothing.
+type newHostKey struct {
+	keyFilename    string // Where the key is installed.
+	tmpKeyFilename string
+	pubKey         []byte
+}
+
+func getKeyFilename(pubKeyFilename string) string {
+	return strings.TrimSuffix(pubKeyFilename, ".pub")
+}
+
+// getKeyTime returns when the private key of pubKeyFilename was written.
+func getKeyTime(pubKeyFilename string) (time.Time, error) {
+	fi, err := os.Stat(getKeyFilename(pubKeyFilename))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// keyNeedsRotation returns true if key rotation is enabled and the private
+// key of pubKeyFilename is older than the rotation interval.
+func keyNeedsRotation(pubKeyFilename string) (bool, error) {
+	if *keyRotationInterval <= 0 {
+		return false, nil
+	}
+	keyTime, err := getKeyTime(pubKeyFilename)
+	if err != nil {
+		return false, err
+	}
+	return time.Since(keyTime) >= *keyRotationInterval, nil
+}
+
+// getNextKeyRotation returns when the first of the keys is due for rotation,
+// or the zero time if rotation is disabled.
+func getNextKeyRotation(pubKeyFilenames []string) time.Time {
+	if *keyRotationInterval <= 0 {
+		return time.Time{}
+	}
+	var next time.Time
+	for _, pubKeyFilename := range pubKeyFilenames {
+		keyTime, err := getKeyTime(pubKeyFilename)
+		if err != nil {
+			continue
+		}
+		due := keyTime.Add(*keyRotationInterval)
+		if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	return next
+}
+
+// getOldestKeyTime returns when the oldest of the keys was written.
+func getOldestKeyTime(pubKeyFilenames []string) time.Time {
+	var oldest time.Time
+	for _, pubKeyFilename := range pubKeyFilenames {
+		keyTime, err := getKeyTime(pubKeyFilename)
+		if err != nil {
+			continue
+		}
+		if oldest.IsZero() || keyTime.Before(oldest) {
+			oldest = keyTime
+		}
+	}
+	return oldest
+}
+
+// generateHostKey generates a key of the same type as the current public key
+// next to the key of pubKeyFilename.
+func generateHostKey(pubKeyFilename string, currentPubKey []byte) (
+	*newHostKey, error) {
+	if !strings.HasSuffix(pubKeyFilename, ".pub") {
+		return nil, fmt.Errorf("cannot rotate key without .pub suffix: %s",
+			pubKeyFilename)
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(currentPubKey)
+	if err != nil {
+		return nil, err
+	}
+	keygenArguments, ok := sshKeygenArguments[pubKey.Type()]
+	if !ok {
+		return nil, fmt.Errorf("cannot rotate %s key: %s", pubKey.Type(),
+			pubKeyFilename)
+	}
+	keyFilename := getKeyFilename(pubKeyFilename)
+	newKey := &newHostKey{
+		keyFilename:    keyFilename,
+		tmpKeyFilename: keyFilename + ".new",
+	}
+	newKey.discard()
+	args := append([]string{"-q", "-N", "", "-f", newKey.tmpKeyFilename},
+		keygenArguments...)
+	output, err := exec.Command(*sshKeygenCommand, args...).CombinedOutput()
+	if err != nil {
+		newKey.discard()
+		return nil, fmt.Errorf("error running %s: %s: %s", *sshKeygenCommand,
+			err, strings.TrimSpace(string(output)))
+	}
+	newKey.pubKey, err = ioutil.ReadFile(newKey.tmpKeyFilename + ".pub")
+	if err != nil {
+		newKey.discard()
+		return nil, err
+	}
+	return newKey, nil
+}
+
+// discard removes the generated key files.
+func (newKey *newHostKey) discard() {
+	if newKey == nil {
+		return
+	}
+	os.Remove(newKey.tmpKeyFilename)
+	os.Remove(newKey.tmpKeyFilename + ".pub")
+}
+
+// install replaces the current key with the generated one.
+func (newKey *newHostKey) install() error {
+	if newKey == nil {
+		return nil
+	}
+	if err := os.Rename(newKey.tmpKeyFilename,
+		newKey.keyFilename); err != nil {
+		newKey.discard()
+		return err
+	}
+	return os.Rename(newKey.tmpKeyFilename+".pub", newKey.keyFilename+".pub")
+}
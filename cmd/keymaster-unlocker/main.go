@@ -30,6 +30,10 @@ var (
 	keymasterPort = flag.Int("keymasterPort", 6920,
 		"The keymaster control port")
 	retryInterval = flag.Duration("retryInterval", 0, "If > 0: retry")
+	socketPath    = flag.String("socket", "",
+		"If set: unlock the local keymaster through this admin socket")
+	tenant = flag.String("tenant", "",
+		"If set: unlock this tenant rather than the main keymaster")
 )
 
 func Usage() {
@@ -39,7 +43,11 @@ func Usage() {
 
 func getPassword(password string) (string, error) {
 	if password == "" {
-		fmt.Printf("Password for unlocking %s: ", *keymasterHostname)
+		name := *keymasterHostname
+		if *socketPath != "" {
+			name = *socketPath
+		}
+		fmt.Printf("Password for unlocking %s: ", name)
 		passwd, err := gopass.GetPasswd()
 		if err != nil {
 			return "", err
@@ -53,6 +61,12 @@ func getPassword(password string) (string, error) {
 func main() {
 	flag.Parse()
 	logger := cmdlogger.New()
+	if *socketPath != "" {
+		addrs := []string{*socketPath}
+		clients := []*http.Client{makeSocketClient(*socketPath)}
+		run(addrs, clients, logger)
+		return
+	}
 	if len(*keymasterHostname) < 1 {
 		logger.Fatal("keymasterHostname paramteter  is required")
 	}
@@ -72,7 +86,12 @@ func main() {
 	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
 	tlsConfig.BuildNameToCertificate()
 	clients := makeClients(addrs, tlsConfig)
+	run(addrs, clients, logger)
+}
+
+func run(addrs []string, clients []*http.Client, logger log.Logger) {
 	var password string
+	var err error
 	if *retryInterval > 0 {
 		if password, err = getPassword(password); err != nil {
 			logger.Fatal(err)
@@ -110,9 +129,35 @@ func makeClients(addrs []string, tlsConfig *tls.Config) []*http.Client {
 	return clients
 }
 
+// makeSocketClient returns a client which sends all requests to the admin
+// socket. The socket permissions take the place of a client certificate.
+func makeSocketClient(socketPath string) *http.Client {
+	dialer := &net.Dialer{}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (
+			net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &http.Client{Transport: transport}
+}
+
+func baseURL() string {
+	if *socketPath != "" {
+		return "http://localhost"
+	}
+	return "https://" + *keymasterHostname + ":" + strconv.Itoa(*keymasterPort)
+}
+
+func tenantSuffix() string {
+	if *tenant == "" {
+		return ""
+	}
+	return "/" + *tenant
+}
+
 func testReady(client *http.Client) (bool, error) {
-	resp, err := client.Get("https://" + *keymasterHostname + ":" +
-		strconv.Itoa(*keymasterPort) + "/readyz")
+	resp, err := client.Get(baseURL() + "/readyz" + tenantSuffix())
 	if err != nil {
 		return false, err
 	}
@@ -137,8 +182,7 @@ func unseal(addrs []string, clients []*http.Client, password string,
 		if err != nil {
 			logger.Fatal(err)
 		}
-		resp, err := client.PostForm("https://"+*keymasterHostname+":"+
-			strconv.Itoa(*keymasterPort)+"/admin/inject",
+		resp, err := client.PostForm(baseURL()+"/admin/inject"+tenantSuffix(),
 			url.Values{"ssh_ca_password": {password}})
 		if err != nil {
 			logger.Printf("%s: %s\n", addrs[index], err)
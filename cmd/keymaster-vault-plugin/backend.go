@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	configCAPath = "config/ca"
+
+	defaultMaxTTL = 16 * time.Hour
+	// Applied to SSH and X.509 keys alike, as keymasterd does by default.
+	minRSAKeyBits = certgen.DefaultMinRSAKeyBits
+)
+
+const backendHelp = `
+The keymaster secrets engine issues short lived SSH and X.509 user
+certificates signed by a keymaster CA. Configure the CA key with config/ca,
+then sign keys with ssh/sign/<username> and x509/sign/<username>. Use
+templated policies to restrict users to their own username.
+`
+
+var errNotConfigured = errors.New("CA is not configured")
+
+// caConfig is stored (seal wrapped) at configCAPath.
+type caConfig struct {
+	PrivateKey    string        `json:"private_key"`
+	HostIdentity  string        `json:"host_identity"`
+	KerberosRealm string        `json:"kerberos_realm,omitempty"`
+	MaxTTL        time.Duration `json:"max_ttl"`
+	CACertificate []byte        `json:"ca_certificate"` // DER encoded.
+	// Default: rsa-sha2-512.
+	SSHSignatureAlgorithm string `json:"ssh_signature_algorithm,omitempty"`
+	// Default: depends on the key.
+	X509SignatureAlgorithm string `json:"x509_signature_algorithm,omitempty"`
+}
+
+// caState is the parsed configuration.
+type caState struct {
+	config    caConfig
+	signer    crypto.Signer
+	sshSigner ssh.Signer
+	caCert    *x509.Certificate
+}
+
+type backend struct {
+	*framework.Backend
+	mutex sync.Mutex
+	ca    *caState // nil if not loaded yet.
+}
+
+func factory(ctx context.Context, conf *logical.BackendConfig) (
+	logical.Backend, error) {
+	b := newBackend()
+	if err := b.Setup(ctx, conf); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func newBackend() *backend {
+	b := &backend{}
+	b.Backend = &framework.Backend{
+		Help:        strings.TrimSpace(backendHelp),
+		BackendType: logical.TypeLogical,
+		PathsSpecial: &logical.Paths{
+			SealWrapStorage: []string{configCAPath},
+		},
+		Paths: []*framework.Path{
+			b.pathConfigCA(),
+			b.pathSignSSH(),
+			b.pathSignX509(),
+		},
+		Invalidate: b.invalidate,
+	}
+	return b
+}
+
+func (b *backend) invalidate(ctx context.Context, key string) {
+	if key == configCAPath {
+		b.mutex.Lock()
+		b.ca = nil
+		b.mutex.Unlock()
+	}
+}
+
+func newCAState(config caConfig) (*caState, error) {
+	signer, err := certgen.GetSignerFromPEMBytes([]byte(config.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	if err != nil {
+		return nil, err
+	}
+	sshSigner, err = certgen.NewSSHSigner(sshSigner,
+		config.SSHSignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(config.CACertificate)
+	if err != nil {
+		return nil, err
+	}
+	return &caState{
+		config:    config,
+		signer:    signer,
+		sshSigner: sshSigner,
+		caCert:    caCert,
+	}, nil
+}
+
+// getCA returns the CA, loading it from storage if needed.
+func (b *backend) getCA(ctx context.Context, storage logical.Storage) (
+	*caState, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.ca != nil {
+		return b.ca, nil
+	}
+	entry, err := storage.Get(ctx, configCAPath)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, errNotConfigured
+	}
+	var config caConfig
+	if err := entry.DecodeJSON(&config); err != nil {
+		return nil, err
+	}
+	ca, err := newCAState(config)
+	if err != nil {
+		return nil, err
+	}
+	b.ca = ca
+	return ca, nil
+}
+
+// getTTL returns the requested lifetime, capped by the configured maximum.
+func (ca *caState) getTTL(data *framework.FieldData) time.Duration {
+	ttl := time.Duration(data.Get("ttl").(int)) * time.Second
+	if ttl <= 0 || ttl > ca.config.MaxTTL {
+		return ca.config.MaxTTL
+	}
+	return ttl
+}
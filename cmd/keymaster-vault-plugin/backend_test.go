@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestBackend(t *testing.T) (*backend, logical.Storage) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b, err := factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.(*backend), config.StorageView
+}
+
+func doRequest(t *testing.T, b *backend, storage logical.Storage,
+	operation logical.Operation, path string,
+	data map[string]interface{}) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: operation,
+		Path:      path,
+		Storage:   storage,
+		Data:      data,
+	})
+	if err != nil {
+		t.Fatalf("%s %s: %s", operation, path, err)
+	}
+	return resp
+}
+
+func makeCAKey(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(
+		&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+func makeRSAKey(t *testing.T, bits int) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func marshalSSHKey(t *testing.T, publicKey interface{}) string {
+	sshKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(ssh.MarshalAuthorizedKey(sshKey))
+}
+
+func marshalPKIXKey(t *testing.T, publicKey interface{}) string {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY",
+		Bytes: der}))
+}
+
+func configureCA(t *testing.T, b *backend, storage logical.Storage) {
+	resp := doRequest(t, b, storage, logical.UpdateOperation, configCAPath,
+		map[string]interface{}{
+			"private_key":   makeCAKey(t),
+			"host_identity": "keymaster.example.com",
+			"max_ttl":       "2h",
+		})
+	if resp != nil && resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+}
+
+func TestNotConfigured(t *testing.T) {
+	b, storage := newTestBackend(t)
+	if resp := doRequest(t, b, storage, logical.ReadOperation, configCAPath,
+		nil); resp != nil {
+		t.Fatalf("unexpected config: %v", resp.Data)
+	}
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := doRequest(t, b, storage, logical.UpdateOperation,
+		"ssh/sign/alice",
+		map[string]interface{}{"public_key": marshalSSHKey(t, publicKey)})
+	if resp == nil || !resp.IsError() ||
+		!strings.Contains(resp.Error().Error(), errNotConfigured.Error()) {
+		t.Fatalf("expected not configured error, got: %v", resp)
+	}
+	resp = doRequest(t, b, storage, logical.UpdateOperation, configCAPath,
+		map[string]interface{}{"host_identity": "keymaster.example.com"})
+	if resp == nil || !resp.IsError() {
+		t.Fatal("config without private_key accepted")
+	}
+}
+
+func TestConfigCA(t *testing.T) {
+	b, storage := newTestBackend(t)
+	configureCA(t, b, storage)
+	resp := doRequest(t, b, storage, logical.ReadOperation, configCAPath, nil)
+	if resp == nil || resp.IsError() {
+		t.Fatalf("cannot read config: %v", resp)
+	}
+	if _, ok := resp.Data["private_key"]; ok {
+		t.Fatal("private key returned")
+	}
+	if resp.Data["host_identity"] != "keymaster.example.com" ||
+		resp.Data["max_ttl"] != int64(7200) {
+		t.Fatalf("unexpected config: %v", resp.Data)
+	}
+	block, _ := pem.Decode([]byte(resp.Data["x509_ca_certificate"].(string)))
+	if block == nil {
+		t.Fatal("no CA certificate")
+	}
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caCert.Subject.CommonName != "keymaster.example.com" || !caCert.IsCA {
+		t.Fatalf("unexpected CA certificate: %v", caCert.Subject)
+	}
+	_, _, _, _, err = ssh.ParseAuthorizedKey(
+		[]byte(resp.Data["ssh_public_key"].(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignSSH(t *testing.T) {
+	b, storage := newTestBackend(t)
+	configureCA(t, b, storage)
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := doRequest(t, b, storage, logical.UpdateOperation,
+		"ssh/sign/alice", map[string]interface{}{
+			"public_key": marshalSSHKey(t, publicKey),
+			"ttl":        "1h",
+		})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("signing failed: %v", resp)
+	}
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey(
+		[]byte(resp.Data["certificate"].(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, ok := parsed.(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("not a certificate: %T", parsed)
+	}
+	if cert.CertType != ssh.UserCert || len(cert.ValidPrincipals) != 1 ||
+		cert.ValidPrincipals[0] != "alice" {
+		t.Fatalf("unexpected certificate: %+v", cert)
+	}
+	validity := time.Unix(int64(cert.ValidBefore), 0).Sub(time.Now())
+	if validity > time.Hour || validity < 50*time.Minute {
+		t.Fatalf("unexpected lifetime: %s", validity)
+	}
+	// RSA keys below the minimum size are refused, as for X.509.
+	resp = doRequest(t, b, storage, logical.UpdateOperation,
+		"ssh/sign/alice", map[string]interface{}{
+			"public_key": marshalSSHKey(t, &makeRSAKey(t, 1024).PublicKey),
+		})
+	if resp == nil || !resp.IsError() {
+		t.Fatal("weak RSA key accepted")
+	}
+	resp = doRequest(t, b, storage, logical.UpdateOperation,
+		"ssh/sign/alice", map[string]interface{}{"public_key": "garbage"})
+	if resp == nil || !resp.IsError() {
+		t.Fatal("bad public key accepted")
+	}
+}
+
+func TestSignX509(t *testing.T) {
+	b, storage := newTestBackend(t)
+	configureCA(t, b, storage)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := doRequest(t, b, storage, logical.UpdateOperation,
+		"x509/sign/alice", map[string]interface{}{
+			"public_key": marshalPKIXKey(t, &key.PublicKey),
+			"groups":     "ops,dev",
+		})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("signing failed: %v", resp)
+	}
+	block, _ := pem.Decode([]byte(resp.Data["certificate"].(string)))
+	if block == nil {
+		t.Fatal("no certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "alice" {
+		t.Fatalf("certificate issued for: %s", cert.Subject.CommonName)
+	}
+	// Without a ttl the maximum is used.
+	validity := cert.NotAfter.Sub(time.Now())
+	if validity > 2*time.Hour || validity < 110*time.Minute {
+		t.Fatalf("unexpected lifetime: %s", validity)
+	}
+	resp = doRequest(t, b, storage, logical.UpdateOperation,
+		"x509/sign/alice", map[string]interface{}{
+			"public_key": marshalPKIXKey(t, &makeRSAKey(t, 1024).PublicKey),
+		})
+	if resp == nil || !resp.IsError() {
+		t.Fatal("weak RSA key accepted")
+	}
+}
+
+func TestGetTTL(t *testing.T) {
+	ca := &caState{config: caConfig{MaxTTL: 2 * time.Hour}}
+	tests := map[string]time.Duration{
+		"":    2 * time.Hour,
+		"0":   2 * time.Hour,
+		"1h":  time.Hour,
+		"2h":  2 * time.Hour,
+		"3h":  2 * time.Hour,
+		"600": 10 * time.Minute,
+	}
+	for ttl, expected := range tests {
+		raw := map[string]interface{}{}
+		if ttl != "" {
+			raw["ttl"] = ttl
+		}
+		data := &framework.FieldData{Raw: raw, Schema: signFields}
+		if got := ca.getTTL(data); got != expected {
+			t.Errorf("ttl %q: expected %s, got %s", ttl, expected, got)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// keymaster-vault-plugin is a Vault secrets engine which issues SSH and X.509
+// user certificates the same way keymasterd does. It lets teams which manage
+// access with Vault policies use keymaster's CAs through Vault paths.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/plugin"
+)
+
+var Version = "No version provided"
+
+func main() {
+	apiClientMeta := &api.PluginAPIClientMeta{}
+	flags := apiClientMeta.FlagSet()
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+	tlsConfig := apiClientMeta.GetTLSConfig()
+	tlsProviderFunc := api.VaultPluginTLSProvider(tlsConfig)
+	err := plugin.Serve(&plugin.ServeOpts{
+		BackendFactoryFunc: factory,
+		TLSProviderFunc:    tlsProviderFunc,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+func (b *backend) pathConfigCA() *framework.Path {
+	return &framework.Path{
+		Pattern: configCAPath,
+		Fields: map[string]*framework.FieldSchema{
+			"private_key": {
+				Type:        framework.TypeString,
+				Description: "PEM encoded CA private key, as used by keymasterd.",
+			},
+			"host_identity": {
+				Type:        framework.TypeString,
+				Description: "Name of the CA, used in the key IDs of SSH certificates and as the CA common name.",
+			},
+			"kerberos_realm": {
+				Type:        framework.TypeString,
+				Description: "Optional Kerberos realm added to X.509 certificates for PKINIT.",
+			},
+			"max_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Maximum lifetime of issued certificates.",
+				Default:     int(defaultMaxTTL / time.Second),
+			},
+			"ssh_signature_algorithm": {
+				Type:        framework.TypeString,
+				Description: "Signature algorithm of SSH certificates for RSA keys: rsa-sha2-512 (the default), rsa-sha2-256 or ssh-rsa.",
+			},
+			"x509_signature_algorithm": {
+				Type:        framework.TypeString,
+				Description: "Signature algorithm of X.509 certificates, such as SHA384-RSAPSS or ECDSA-SHA384. The default depends on the key.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigCAWrite,
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigCARead,
+			},
+		},
+		HelpSynopsis: "Configure the CA used to sign certificates.",
+	}
+}
+
+func (b *backend) pathConfigCAWrite(ctx context.Context, req *logical.Request,
+	data *framework.FieldData) (*logical.Response, error) {
+	config := caConfig{
+		PrivateKey:    data.Get("private_key").(string),
+		HostIdentity:  data.Get("host_identity").(string),
+		KerberosRealm: data.Get("kerberos_realm").(string),
+		MaxTTL:        time.Duration(data.Get("max_ttl").(int)) * time.Second,
+		SSHSignatureAlgorithm: data.Get(
+			"ssh_signature_algorithm").(string),
+		X509SignatureAlgorithm: data.Get(
+			"x509_signature_algorithm").(string),
+	}
+	if config.PrivateKey == "" || config.HostIdentity == "" {
+		return logical.ErrorResponse(
+			"private_key and host_identity are required"), nil
+	}
+	if config.MaxTTL <= 0 {
+		return logical.ErrorResponse("max_ttl must be positive"), nil
+	}
+	err := certgen.CheckSSHSignatureAlgorithm(config.SSHSignatureAlgorithm)
+	if err != nil {
+		return logical.ErrorResponse("invalid ssh_signature_algorithm: %s",
+			err), nil
+	}
+	x509Algorithm, err := certgen.ParseX509SignatureAlgorithm(
+		config.X509SignatureAlgorithm)
+	if err != nil {
+		return logical.ErrorResponse("invalid x509_signature_algorithm: %s",
+			err), nil
+	}
+	signer, err := certgen.GetSignerFromPEMBytes([]byte(config.PrivateKey))
+	if err != nil {
+		return logical.ErrorResponse("invalid private_key: %s", err), nil
+	}
+	err = certgen.CheckX509SignatureAlgorithm(x509Algorithm, signer.Public())
+	if err != nil {
+		return logical.ErrorResponse("invalid x509_signature_algorithm: %s",
+			err), nil
+	}
+	// Same CA certificate as keymasterd generates for this key.
+	organizationName := config.HostIdentity
+	if config.KerberosRealm != "" {
+		organizationName = config.KerberosRealm
+	}
+	config.CACertificate, err = certgen.GenSelfSignedCACertWithAlgorithm(
+		config.HostIdentity, organizationName, signer, x509Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := logical.StorageEntryJSON(configCAPath, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+	b.invalidate(ctx, configCAPath)
+	return nil, nil
+}
+
+// pathConfigCARead returns the public parts of the CA, which are needed to
+// configure the systems which trust the certificates.
+func (b *backend) pathConfigCARead(ctx context.Context, req *logical.Request,
+	data *framework.FieldData) (*logical.Response, error) {
+	ca, err := b.getCA(ctx, req.Storage)
+	if err == errNotConfigured {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"host_identity":            ca.config.HostIdentity,
+			"kerberos_realm":           ca.config.KerberosRealm,
+			"max_ttl":                  int64(ca.config.MaxTTL / time.Second),
+			"ssh_signature_algorithm":  ca.config.SSHSignatureAlgorithm,
+			"x509_signature_algorithm": ca.config.X509SignatureAlgorithm,
+			"ssh_public_key": strings.TrimSpace(string(
+				ssh.MarshalAuthorizedKey(ca.sshSigner.PublicKey()))),
+			"x509_ca_certificate": string(pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: ca.config.CACertificate,
+			})),
+		},
+	}, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"strconv"
+	"strings"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var signFields = map[string]*framework.FieldSchema{
+	"username": {
+		Type:        framework.TypeString,
+		Description: "User to issue the certificate for.",
+	},
+	"ttl": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Requested lifetime, capped at the configured max_ttl.",
+	},
+}
+
+func (b *backend) pathSignSSH() *framework.Path {
+	fields := map[string]*framework.FieldSchema{
+		"public_key": {
+			Type:        framework.TypeString,
+			Description: "SSH public key in authorized_keys format.",
+		},
+	}
+	for name, schema := range signFields {
+		fields[name] = schema
+	}
+	return &framework.Path{
+		Pattern: "ssh/sign/" + framework.GenericNameRegex("username"),
+		Fields:  fields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSignSSHWrite,
+			},
+		},
+		HelpSynopsis: "Issue an SSH user certificate.",
+	}
+}
+
+func (b *backend) pathSignX509() *framework.Path {
+	fields := map[string]*framework.FieldSchema{
+		"public_key": {
+			Type:        framework.TypeString,
+			Description: "PEM encoded PKIX public key.",
+		},
+		"groups": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Groups to include in the certificate.",
+		},
+	}
+	for name, schema := range signFields {
+		fields[name] = schema
+	}
+	return &framework.Path{
+		Pattern: "x509/sign/" + framework.GenericNameRegex("username"),
+		Fields:  fields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSignX509Write,
+			},
+		},
+		HelpSynopsis: "Issue an X.509 user certificate.",
+	}
+}
+
+func (b *backend) pathSignSSHWrite(ctx context.Context, req *logical.Request,
+	data *framework.FieldData) (*logical.Response, error) {
+	ca, err := b.getCA(ctx, req.Storage)
+	if err == errNotConfigured {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	username := data.Get("username").(string)
+	publicKey := data.Get("public_key").(string)
+	_, err = certgen.ParseSSHPublicKey([]byte(publicKey), minRSAKeyBits)
+	if err != nil {
+		return logical.ErrorResponse("invalid public_key: %s", err), nil
+	}
+	certString, cert, err := certgen.GenSSHCertFileString(username,
+		publicKey, ca.sshSigner, ca.config.HostIdentity, ca.getTTL(data))
+	if err != nil {
+		return nil, err
+	}
+	b.Logger().Info("issued SSH certificate", "username", username,
+		"serial", cert.Serial, "display_name", req.DisplayName)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"certificate":   certString,
+			"serial_number": strconv.FormatUint(cert.Serial, 10),
+			"expiration":    int64(cert.ValidBefore),
+		},
+	}, nil
+}
+
+func (b *backend) pathSignX509Write(ctx context.Context, req *logical.Request,
+	data *framework.FieldData) (*logical.Response, error) {
+	ca, err := b.getCA(ctx, req.Storage)
+	if err == errNotConfigured {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	username := data.Get("username").(string)
+	block, _ := pem.Decode([]byte(data.Get("public_key").(string)))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return logical.ErrorResponse("public_key is not a PEM public key"),
+			nil
+	}
+	userPub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return logical.ErrorResponse("invalid public_key: %s", err), nil
+	}
+	if err := certgen.CheckPublicKeyStrength(userPub, minRSAKeyBits); err != nil {
+		return logical.ErrorResponse("public_key is too weak: %s", err), nil
+	}
+	var kerberosRealm *string
+	if ca.config.KerberosRealm != "" {
+		kerberosRealm = &ca.config.KerberosRealm
+	}
+	derCert, err := certgen.GenUserX509Cert(username, userPub, ca.caCert,
+		ca.signer, kerberosRealm, ca.getTTL(data),
+		data.Get("groups").([]string), nil)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		return nil, err
+	}
+	b.Logger().Info("issued X.509 certificate", "username", username,
+		"serial", cert.SerialNumber.String(), "display_name", req.DisplayName)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"certificate": strings.TrimSpace(string(pem.EncodeToMemory(
+				&pem.Block{Type: "CERTIFICATE", Bytes: derCert}))),
+			"serial_number": cert.SerialNumber.String(),
+			"expiration":    cert.NotAfter.Unix(),
+		},
+	}, nil
+}
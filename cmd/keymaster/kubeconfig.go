@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Cloud-Foundations/keymaster/lib/client/config"
+)
+
+const defaultKubernetesClusterName = "keymaster"
+
+const kubeconfigText = `apiVersion: v1
+kind: Config
+clusters:
+- name: %[1]s
+  cluster:
+    server: %[2]s
+%[3]scontexts:
+- name: %[1]s
+  context:
+    cluster: %[1]s
+    user: %[4]s
+current-context: %[1]s
+users:
+- name: %[4]s
+  user:
+    client-certificate: %[5]s
+    client-key: %[6]s
+`
+
+// writeKubeconfig writes a kubeconfig file for the configured cluster which
+// uses the x509-kubernetes certificate, so that it may be used with
+// KUBECONFIG=~/.kube/keymaster. It returns the path of the file, or an empty
+// string if no cluster is configured.
+func writeKubeconfig(kubernetesConfig config.KubernetesConfig,
+	homeDir string, userName string, certPath string, keyPath string) (
+	string, error) {
+	if kubernetesConfig.Server == "" {
+		return "", nil
+	}
+	clusterName := kubernetesConfig.ClusterName
+	if clusterName == "" {
+		clusterName = defaultKubernetesClusterName
+	}
+	caLine := ""
+	if kubernetesConfig.CAFilename != "" {
+		caLine = "    certificate-authority: " +
+			strconv.Quote(kubernetesConfig.CAFilename) + "\n"
+	}
+	kubeconfigPath := filepath.Join(homeDir, ".kube", FilePrefix)
+	if err := os.MkdirAll(filepath.Dir(kubeconfigPath), 0700); err != nil {
+		return "", err
+	}
+	kubeconfig := fmt.Sprintf(kubeconfigText, strconv.Quote(clusterName),
+		strconv.Quote(kubernetesConfig.Server), caLine,
+		strconv.Quote(userName), strconv.Quote(certPath),
+		strconv.Quote(keyPath))
+	return kubeconfigPath, ioutil.WriteFile(kubeconfigPath, []byte(kubeconfig),
+		0600)
+}
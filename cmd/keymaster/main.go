@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -52,6 +53,13 @@ var (
 	cliFilePrefix    = flag.String("fileprefix", "", "Prefix for the output files")
 	roundRobinDialer = flag.Bool("roundRobinDialer", false,
 		"If true, use the smart round-robin dialer")
+	autoUpdate = flag.Bool("autoUpdate", false,
+		"If true, install a newer client published by keymaster")
+	puttyKeys = flag.Bool("puttyKeys", false,
+		"If true, also write the SSH keys in PuTTY (.ppk) format")
+	cliProxy   = flag.String("proxy", "", "Proxy URL (http, https or socks5)")
+	cliNoProxy = flag.String("noProxy", "",
+		"Comma separated hosts, domains and CIDR blocks to reach directly")
 
 	FilePrefix = "keymaster"
 )
@@ -250,25 +258,50 @@ func setupCerts(
 	if err != nil {
 		return err
 	}
-	// Get user creds
-	password, err := util.GetUserCreds(userName)
-	if err != nil {
-		return err
+	useKeychain := !configContents.Base.DisableKeychain
+	var baseUrl string
+	var x509Cert []byte
+	if useKeychain {
+		baseUrl = loadSession(userName, targetURLs, client, logger)
 	}
-	if err := signers.Wait(); err != nil {
-		return err
+	if baseUrl != "" {
+		if err := signers.Wait(); err != nil {
+			return err
+		}
+		x509Cert, err = twofa.DoCertRequest(signers.X509Rsa, client,
+			userName, baseUrl, "x509", configContents.Base.AddGroups,
+			userAgentString, logger)
+		if err != nil {
+			logger.Debugf(0, "cached session not accepted, logging in")
+			forgetSession(userName, baseUrl, logger)
+			baseUrl = ""
+		}
 	}
-	baseUrl, err := twofa.AuthenticateToTargetUrls(userName, password,
-		targetURLs, false, client,
-		userAgentString, logger)
-	if err != nil {
-		return err
+	if baseUrl == "" {
+		// Get user creds
+		password, err := util.GetUserCreds(userName)
+		if err != nil {
+			return err
+		}
+		if err := signers.Wait(); err != nil {
+			return err
+		}
+		baseUrl, err = twofa.AuthenticateToTargetUrls(userName, password,
+			targetURLs, false, client,
+			userAgentString, logger)
+		if err != nil {
+			return err
 
-	}
-	x509Cert, err := twofa.DoCertRequest(signers.X509Rsa, client, userName,
-		baseUrl, "x509", configContents.Base.AddGroups, userAgentString, logger)
-	if err != nil {
-		return err
+		}
+		if useKeychain {
+			saveSession(userName, baseUrl, client, logger)
+		}
+		x509Cert, err = twofa.DoCertRequest(signers.X509Rsa, client,
+			userName, baseUrl, "x509", configContents.Base.AddGroups,
+			userAgentString, logger)
+		if err != nil {
+			return err
+		}
 	}
 	kubernetesCert, err := twofa.DoCertRequest(signers.X509Rsa, client,
 		userName, baseUrl, "x509-kubernetes", configContents.Base.AddGroups,
@@ -311,6 +344,21 @@ func setupCerts(
 			return err
 		}
 	}
+	if *puttyKeys || configContents.Base.PuTTYKeys {
+		err := writePuTTYKey(signers.SshRsa, sshRsaCert, sshKeyPath+"-rsa",
+			FilePrefix+"-rsa-"+userName)
+		if err != nil {
+			return err
+		}
+		if sshEd25519Cert != nil {
+			err := writePuTTYKey(signers.SshEd25519, sshEd25519Cert,
+				sshKeyPath+"-ed25519", FilePrefix+"-ed25519-"+userName)
+			if err != nil {
+				return err
+			}
+		}
+		logger.Debugf(0, "PuTTY keys written to %s-*.ppk", sshKeyPath)
+	}
 	// Now x509
 	encodedx509Signer, err := x509.MarshalPKCS8PrivateKey(signers.X509Rsa)
 	if err != nil {
@@ -336,6 +384,30 @@ func setupCerts(
 			err := errors.New("Could not write ssh cert")
 			logger.Fatal(err)
 		}
+		kubeconfigPath, err := writeKubeconfig(configContents.Kubernetes,
+			homeDir, userName, kubernetesCertPath, tlsKeyPath+".key")
+		if err != nil {
+			return err
+		}
+		if kubeconfigPath != "" {
+			logger.Debugf(0, "kubeconfig written to %s", kubeconfigPath)
+		}
+	}
+	if !configContents.Base.DisableTrustInstall {
+		err := installTrustBundle(client, baseUrl,
+			filepath.Join(sshConfigPath, "known_hosts"),
+			tlsKeyPath+"-ca.pem", logger)
+		if err != nil {
+			logger.Printf("Non fatal, cannot install trust bundle: %s", err)
+		}
+	}
+	if !configContents.Base.DisableUpdateCheck {
+		err := checkForClientUpdate(client, baseUrl,
+			*autoUpdate || configContents.Base.AutoUpdate, logger)
+		if err != nil {
+			logger.Printf("Non fatal, cannot check for client update: %s",
+				err)
+		}
 	}
 
 	return nil
@@ -372,9 +444,37 @@ func getHttpClient(rootCAs *x509.CertPool, logger log.DebugLogger) (*http.Client
 	return util.GetHttpClient(tlsConfig, dialer)
 }
 
+// getProxyURL prompts for the password of an authenticated proxy if proxyURL
+// does not include it.
+func getProxyURL(proxyURL string) (string, error) {
+	if proxyURL == "" {
+		return "", nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return "", err
+	}
+	if u.User == nil {
+		return proxyURL, nil
+	}
+	if _, ok := u.User.Password(); ok {
+		return proxyURL, nil
+	}
+	password, err := util.GetUserCreds(u.User.Username() + "@" + u.Host)
+	if err != nil {
+		return "", err
+	}
+	u.User = url.UserPassword(u.User.Username(), string(password))
+	return u.String(), nil
+}
+
 func Usage() {
 	fmt.Fprintf(
 		os.Stderr, "Usage of %s (version %s):\n", os.Args[0], Version)
+	fmt.Fprintf(os.Stderr, "  %s [flags]\tobtain certificates\n", os.Args[0])
+	fmt.Fprintf(os.Stderr,
+		"  %s [flags] show\tshow and verify the obtained certificates\n",
+		os.Args[0])
 	flag.PrintDefaults()
 }
 
@@ -401,7 +501,28 @@ func main() {
 	if err != nil {
 		logger.Fatal(err)
 	}
+	// Flags apply before loading the config so that -configHost uses them.
+	proxyURL, err := getProxyURL(*cliProxy)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err := util.SetProxy(client, proxyURL, *cliNoProxy); err != nil {
+		logger.Fatal(err)
+	}
 	config := loadConfigFile(client, logger)
+	if proxyURL == "" {
+		proxyURL, err = getProxyURL(config.Proxy.URL)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+	noProxy := config.Proxy.NoProxy
+	if *cliNoProxy != "" {
+		noProxy = *cliNoProxy
+	}
+	if err := util.SetProxy(client, proxyURL, noProxy); err != nil {
+		logger.Fatal(err)
+	}
 
 	// Adjust user name
 	if len(config.Base.Username) > 0 {
@@ -419,6 +540,18 @@ func main() {
 		FilePrefix = *cliFilePrefix
 	}
 
+	switch flag.Arg(0) {
+	case "":
+	case "show":
+		err := showCerts(os.Stdout, userName, homeDir, config, client, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		return
+	default:
+		Usage()
+		os.Exit(2)
+	}
 	err = setupCerts(userName, homeDir, config, client, logger)
 	if err != nil {
 		logger.Fatal(err)
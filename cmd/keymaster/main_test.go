@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v2"
 
 	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
 	"github.com/Cloud-Foundations/keymaster/lib/client/config"
@@ -271,3 +272,58 @@ func TestInsertSSHCertIntoAgentORWriteToFilesystem(t *testing.T) {
 	// TODO: on linux/macos create agent + unix socket and pass that
 
 }
+
+func TestWriteKubeconfig(t *testing.T) {
+	homeDir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(homeDir)
+	path, err := writeKubeconfig(config.KubernetesConfig{}, homeDir, "alice",
+		"cert", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "" {
+		t.Fatal("kubeconfig written without a server")
+	}
+	path, err = writeKubeconfig(config.KubernetesConfig{
+		Server:     "https://k8s.example.com:6443",
+		CAFilename: "/etc/k8s/ca.pem",
+	}, homeDir, "alice", "/home/alice/.ssl/keymaster-kubernetes.cert",
+		"/home/alice/.ssl/keymaster.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kubeconfig struct {
+		Clusters []struct {
+			Name    string
+			Cluster map[string]string
+		}
+		Users []struct {
+			Name string
+			User map[string]string
+		}
+		CurrentContext string `yaml:"current-context"`
+	}
+	if err := yaml.Unmarshal(data, &kubeconfig); err != nil {
+		t.Fatal(err)
+	}
+	if len(kubeconfig.Clusters) != 1 || len(kubeconfig.Users) != 1 {
+		t.Fatalf("bad kubeconfig: %s", data)
+	}
+	if kubeconfig.CurrentContext != defaultKubernetesClusterName ||
+		kubeconfig.Clusters[0].Cluster["server"] !=
+			"https://k8s.example.com:6443" ||
+		kubeconfig.Clusters[0].Cluster["certificate-authority"] !=
+			"/etc/k8s/ca.pem" ||
+		kubeconfig.Users[0].Name != "alice" ||
+		kubeconfig.Users[0].User["client-certificate"] !=
+			"/home/alice/.ssl/keymaster-kubernetes.cert" {
+		t.Fatalf("bad kubeconfig: %s", data)
+	}
+}
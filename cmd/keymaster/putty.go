@@ -0,0 +1,22 @@
+package main
+
+import (
+	"io/ioutil"
+
+	"github.com/Cloud-Foundations/keymaster/lib/client/putty"
+)
+
+// writePuTTYKey writes the private key to keyPath.ppk and the certificate to
+// keyPath-cert.pub, which is where PuTTY and WinSCP users point the
+// "Certificate to use with the private key" setting.
+func writePuTTYKey(privateKey interface{}, certText []byte, keyPath string,
+	comment string) error {
+	keyData, err := putty.MarshalPrivateKey(privateKey, comment)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyPath+".ppk", keyData, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyPath+"-cert.pub", certText, 0644)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWritePuTTYKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keymaster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "keymaster-ed25519")
+	certText := []byte("ssh-ed25519-cert-v01@openssh.com AAAA... alice\n")
+	err = writePuTTYKey(key, certText, keyPath, "keymaster-ed25519-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyData, err := ioutil.ReadFile(keyPath + ".ppk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(keyData),
+		"PuTTY-User-Key-File-3: ssh-ed25519\n") {
+		t.Fatalf("unexpected key file:\n%s", keyData)
+	}
+	if fi, err := os.Stat(keyPath + ".ppk"); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		t.Fatalf("key file readable by others: %s", fi.Mode())
+	}
+	writtenCert, err := ioutil.ReadFile(keyPath + "-cert.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(writtenCert) != string(certText) {
+		t.Fatalf("unexpected certificate: %s", writtenCert)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"github.com/Cloud-Foundations/keymaster/lib/client/keychain"
+)
+
+// The cookies of a keymaster session are cached in the OS keychain, so that
+// certificates can be renewed without logging in again while the session is
+// valid.
+const keychainService = "keymaster"
+
+type sessionCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func getSessionAccount(userName, baseUrl string) string {
+	return userName + "@" + baseUrl
+}
+
+func encodeSessionCookies(cookies []*http.Cookie) ([]byte, error) {
+	sessionCookies := make([]sessionCookie, 0, len(cookies))
+	for _, cookie := range cookies {
+		sessionCookies = append(sessionCookies,
+			sessionCookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	return json.Marshal(sessionCookies)
+}
+
+func decodeSessionCookies(data []byte) ([]*http.Cookie, error) {
+	var sessionCookies []sessionCookie
+	if err := json.Unmarshal(data, &sessionCookies); err != nil {
+		return nil, err
+	}
+	cookies := make([]*http.Cookie, 0, len(sessionCookies))
+	for _, cookie := range sessionCookies {
+		cookies = append(cookies,
+			&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	return cookies, nil
+}
+
+// loadSession adds the cached session cookies of userName to the cookie jar
+// of client and returns the URL they are for, or "" if no session is cached
+// for any of targetURLs.
+func loadSession(userName string, targetURLs []string, client *http.Client,
+	logger log.DebugLogger) string {
+	for _, baseUrl := range targetURLs {
+		u, err := url.Parse(baseUrl)
+		if err != nil {
+			continue
+		}
+		data, err := keychain.Get(keychainService,
+			getSessionAccount(userName, baseUrl))
+		if err != nil {
+			if err != keychain.ErrNotFound {
+				logger.Debugf(1, "Non fatal, cannot read keychain: %s", err)
+			}
+			continue
+		}
+		cookies, err := decodeSessionCookies(data)
+		if err != nil || len(cookies) < 1 {
+			continue
+		}
+		client.Jar.SetCookies(u, cookies)
+		logger.Debugf(1, "using cached session for %s", baseUrl)
+		return baseUrl
+	}
+	return ""
+}
+
+// saveSession caches the session cookies client holds for baseUrl.
+func saveSession(userName, baseUrl string, client *http.Client,
+	logger log.DebugLogger) {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return
+	}
+	data, err := encodeSessionCookies(client.Jar.Cookies(u))
+	if err == nil {
+		err = keychain.Set(keychainService,
+			getSessionAccount(userName, baseUrl), data)
+	}
+	if err != nil {
+		logger.Debugf(1, "Non fatal, cannot save session in keychain: %s",
+			err)
+	}
+}
+
+// forgetSession removes the cached session of userName for baseUrl, once it
+// is no longer accepted.
+func forgetSession(userName, baseUrl string, logger log.DebugLogger) {
+	err := keychain.Delete(keychainService,
+		getSessionAccount(userName, baseUrl))
+	if err != nil {
+		logger.Debugf(1, "Non fatal, cannot delete session from keychain: %s",
+			err)
+	}
+}
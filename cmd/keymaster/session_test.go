@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSessionCookies(t *testing.T) {
+	data, err := encodeSessionCookies([]*http.Cookie{
+		{Name: "auth_cookie", Value: "header.payload.signature"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies, err := decodeSessionCookies(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookies) != 1 || cookies[0].Name != "auth_cookie" ||
+		cookies[0].Value != "header.payload.signature" {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+	if _, err := decodeSessionCookies([]byte("not json")); err == nil {
+		t.Fatal("invalid session accepted")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"github.com/Cloud-Foundations/keymaster/lib/client/config"
+	"github.com/Cloud-Foundations/keymaster/lib/client/sshagent"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+// showCAs holds the published CAs which certificates are verified against.
+type showCAs struct {
+	sshKeys   []ssh.PublicKey
+	x509Roots *x509.CertPool
+}
+
+func parseTrustBundle(bundle *proto.ClientTrustBundle) (*showCAs, error) {
+	cas := &showCAs{x509Roots: x509.NewCertPool()}
+	rest := []byte(bundle.KnownHosts)
+	for len(rest) > 0 {
+		var marker string
+		var key ssh.PublicKey
+		var err error
+		marker, _, key, _, rest, err = ssh.ParseKnownHosts(rest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if marker == "cert-authority" {
+			cas.sshKeys = append(cas.sshKeys, key)
+		}
+	}
+	if bundle.X509CABundle != "" &&
+		!cas.x509Roots.AppendCertsFromPEM([]byte(bundle.X509CABundle)) {
+		return nil, fmt.Errorf("cannot parse X.509 CA bundle")
+	}
+	return cas, nil
+}
+
+// getShowCAs fetches the published CAs from the first keymaster which
+// responds.
+func getShowCAs(client *http.Client, targetURLs []string) (*showCAs, error) {
+	var lastErr error
+	for _, baseUrl := range targetURLs {
+		bundle, err := getTrustBundle(client, strings.TrimSuffix(baseUrl, "/"))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return parseTrustBundle(bundle)
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no keymaster servers configured")
+	}
+	return nil, lastErr
+}
+
+func formatValidity(notBefore, notAfter, now time.Time) string {
+	var status string
+	if now.Before(notBefore) {
+		status = "not yet valid"
+	} else if !now.Before(notAfter) {
+		status = "EXPIRED"
+	} else {
+		status = fmt.Sprintf("expires in %s",
+			notAfter.Sub(now).Truncate(time.Minute))
+	}
+	return fmt.Sprintf("%s to %s (%s)", notBefore.Format(time.RFC3339),
+		notAfter.Format(time.RFC3339), status)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// verifySSHCert returns nil if cert is a currently valid user certificate
+// signed by one of cas.
+func verifySSHCert(cert *ssh.Certificate, cas *showCAs) error {
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			for _, key := range cas.sshKeys {
+				if bytes.Equal(key.Marshal(), auth.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		SupportedCriticalOptions: []string{"force-command", "source-address"},
+	}
+	if cert.CertType != ssh.UserCert {
+		return fmt.Errorf("not a user certificate")
+	}
+	if !checker.IsUserAuthority(cert.SignatureKey) {
+		return fmt.Errorf("not signed by a published keymaster CA")
+	}
+	principal := ""
+	if len(cert.ValidPrincipals) > 0 {
+		principal = cert.ValidPrincipals[0]
+	}
+	return checker.CheckCert(principal, cert)
+}
+
+func writeSSHCertInfo(writer io.Writer, source string, cert *ssh.Certificate,
+	cas *showCAs, now time.Time) {
+	fmt.Fprintf(writer, "SSH certificate (%s):\n", source)
+	fmt.Fprintf(writer, "  Type:       %s\n", cert.Key.Type())
+	fmt.Fprintf(writer, "  Key ID:     %s\n", cert.KeyId)
+	fmt.Fprintf(writer, "  Serial:     %d\n", cert.Serial)
+	fmt.Fprintf(writer, "  Principals: %s\n",
+		strings.Join(cert.ValidPrincipals, ", "))
+	fmt.Fprintf(writer, "  Validity:   %s\n", formatValidity(
+		time.Unix(int64(cert.ValidAfter), 0),
+		time.Unix(int64(cert.ValidBefore), 0), now))
+	for _, name := range sortedKeys(cert.CriticalOptions) {
+		fmt.Fprintf(writer, "  Critical option: %s %s\n", name,
+			cert.CriticalOptions[name])
+	}
+	fmt.Fprintf(writer, "  Extensions: %s\n",
+		strings.Join(sortedKeys(cert.Extensions), ", "))
+	fmt.Fprintf(writer, "  CA:         %s\n",
+		ssh.FingerprintSHA256(cert.SignatureKey))
+	writeVerification(writer, cas, func() error {
+		return verifySSHCert(cert, cas)
+	})
+}
+
+// verifyX509Cert returns nil if cert chains to one of cas for client
+// authentication, using the other certificates sent with it.
+func verifyX509Cert(cert *x509.Certificate, chain []*x509.Certificate,
+	cas *showCAs, now time.Time) error {
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range chain {
+		intermediates.AddCert(intermediate)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         cas.x509Roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+func writeX509CertInfo(writer io.Writer, source string,
+	certs []*x509.Certificate, cas *showCAs, now time.Time) {
+	cert := certs[0]
+	fmt.Fprintf(writer, "X.509 certificate (%s):\n", source)
+	fmt.Fprintf(writer, "  Subject:    %s\n", cert.Subject)
+	if len(cert.Subject.Organization) > 0 {
+		fmt.Fprintf(writer, "  Groups:     %s\n",
+			strings.Join(cert.Subject.Organization, ", "))
+	}
+	if len(cert.EmailAddresses) > 0 {
+		fmt.Fprintf(writer, "  E-mail:     %s\n",
+			strings.Join(cert.EmailAddresses, ", "))
+	}
+	for _, uri := range cert.URIs {
+		fmt.Fprintf(writer, "  URI:        %s\n", uri)
+	}
+	fmt.Fprintf(writer, "  Serial:     %s\n", cert.SerialNumber)
+	fmt.Fprintf(writer, "  Validity:   %s\n",
+		formatValidity(cert.NotBefore, cert.NotAfter, now))
+	fmt.Fprintf(writer, "  Issuer:     %s\n", cert.Issuer)
+	if len(cert.AuthorityKeyId) > 0 {
+		fmt.Fprintf(writer, "  CA key ID:  %s\n",
+			hex.EncodeToString(cert.AuthorityKeyId))
+	}
+	for _, intermediate := range certs[1:] {
+		fingerprint := sha256.Sum256(intermediate.Raw)
+		fmt.Fprintf(writer, "  Chain:      %s (SHA256:%s)\n",
+			intermediate.Subject, hex.EncodeToString(fingerprint[:]))
+	}
+	writeVerification(writer, cas, func() error {
+		return verifyX509Cert(cert, certs[1:], cas, now)
+	})
+}
+
+func writeVerification(writer io.Writer, cas *showCAs, verify func() error) {
+	if cas == nil {
+		fmt.Fprintln(writer, "  Verified:   unknown (no published CAs)")
+	} else if err := verify(); err != nil {
+		fmt.Fprintf(writer, "  Verified:   FAILED: %s\n", err)
+	} else {
+		fmt.Fprintln(writer, "  Verified:   yes")
+	}
+}
+
+func parseX509CertsFile(filename string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) < 1 {
+		return nil, fmt.Errorf("no certificates in: %s", filename)
+	}
+	return certs, nil
+}
+
+func parseSSHCertFile(filename string) (*ssh.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", filename)
+	}
+	return cert, nil
+}
+
+// showCerts decodes the certificates obtained by a previous run, from the
+// SSH agent and the files written by setupCerts, and verifies them against
+// the CAs published by keymaster.
+func showCerts(writer io.Writer, userName string, homeDir string,
+	configContents config.AppConfigFile, client *http.Client,
+	logger log.DebugLogger) error {
+	cas, err := getShowCAs(client,
+		strings.Split(configContents.Base.Gen_Cert_URLS, ","))
+	if err != nil {
+		logger.Printf("cannot get published CAs: %s", err)
+	}
+	now := time.Now()
+	found := 0
+	sshKeyPath := filepath.Join(homeDir, DefaultSSHKeysLocation, FilePrefix)
+	for _, keyType := range []string{"rsa", "ed25519"} {
+		comment := FilePrefix + "-" + keyType + "-" + userName
+		certs, err := sshagent.ListCertsInAgent(comment)
+		if err != nil {
+			logger.Debugf(1, "cannot list SSH agent certificates: %s", err)
+		}
+		for _, cert := range certs {
+			writeSSHCertInfo(writer, "agent: "+comment, cert, cas, now)
+			found++
+		}
+		filename := sshKeyPath + "-" + keyType + ".pub"
+		cert, err := parseSSHCertFile(filename)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logger.Printf("%s: %s", filename, err)
+			}
+			continue
+		}
+		writeSSHCertInfo(writer, filename, cert, cas, now)
+		found++
+	}
+	tlsKeyPath := filepath.Join(homeDir, DefaultTLSKeysLocation, FilePrefix)
+	for _, filename := range []string{
+		tlsKeyPath + ".cert",
+		tlsKeyPath + "-kubernetes.cert",
+	} {
+		certs, err := parseX509CertsFile(filename)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logger.Printf("%s: %s", filename, err)
+			}
+			continue
+		}
+		writeX509CertInfo(writer, filename, certs, cas, now)
+		found++
+	}
+	if found < 1 {
+		return fmt.Errorf("no certificates found for: %s", userName)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSSHSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func newTestX509Cert(t *testing.T, template, parent *x509.Certificate,
+	pub ed25519.PublicKey, priv ed25519.PrivateKey) *x509.Certificate {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub,
+		priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestShowVerification(t *testing.T) {
+	now := time.Now()
+	caSigner := newTestSSHSigner(t)
+	otherSigner := newTestSSHSigner(t)
+	caPub, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "keymaster CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caCert := newTestX509Cert(t, caTemplate, caTemplate, caPub, caPriv)
+	cas, err := parseTrustBundle(&proto.ClientTrustBundle{
+		KnownHosts: "@cert-authority * " + string(
+			ssh.MarshalAuthorizedKey(caSigner.PublicKey())),
+		X509CABundle: string(pem.EncodeToMemory(
+			&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cas.sshKeys) != 1 {
+		t.Fatalf("expected one SSH CA, got: %d", len(cas.sshKeys))
+	}
+	userSigner := newTestSSHSigner(t)
+	newSSHCert := func(signer ssh.Signer) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:             userSigner.PublicKey(),
+			CertType:        ssh.UserCert,
+			KeyId:           "keymaster.example.com_alice",
+			ValidPrincipals: []string{"alice"},
+			ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+			ValidBefore:     uint64(now.Add(time.Hour).Unix()),
+			Permissions: ssh.Permissions{
+				Extensions: map[string]string{"permit-pty": ""},
+			},
+		}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	if err := verifySSHCert(newSSHCert(caSigner), cas); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySSHCert(newSSHCert(otherSigner), cas); err == nil {
+		t.Fatal("certificate from another CA verified")
+	}
+	var buffer bytes.Buffer
+	writeSSHCertInfo(&buffer, "test", newSSHCert(caSigner), cas, now)
+	for _, want := range []string{"Principals: alice", "permit-pty",
+		ssh.FingerprintSHA256(caSigner.PublicKey()), "Verified:   yes"} {
+		if !strings.Contains(buffer.String(), want) {
+			t.Errorf("missing %q in: %s", want, buffer.String())
+		}
+	}
+	userPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userCert := newTestX509Cert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			CommonName:   "alice",
+			Organization: []string{"group1"},
+		},
+		NotBefore:   now.Add(-time.Minute),
+		NotAfter:    now.Add(time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, userPub, caPriv)
+	if err := verifyX509Cert(userCert, nil, cas, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyX509Cert(userCert, nil, cas,
+		now.Add(2*time.Hour)); err == nil {
+		t.Fatal("expired certificate verified")
+	}
+	buffer.Reset()
+	writeX509CertInfo(&buffer, "test", []*x509.Certificate{userCert}, nil,
+		now)
+	for _, want := range []string{"CN=alice", "Groups:     group1",
+		"Verified:   unknown"} {
+		if !strings.Contains(buffer.String(), want) {
+			t.Errorf("missing %q in: %s", want, buffer.String())
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+)
+
+const maxTrustBundleSize = 1 << 20
+
+// replaceManagedBlock returns data with the lines between the begin and end
+// marker lines replaced by block. If there are no markers, the block is
+// appended.
+func replaceManagedBlock(data []byte, begin, end string,
+	block []byte) []byte {
+	var output bytes.Buffer
+	inBlock := false
+	written := false
+	writeBlock := func() {
+		fmt.Fprintln(&output, begin)
+		output.Write(block)
+		if len(block) > 0 && block[len(block)-1] != '\n' {
+			output.WriteByte('\n')
+		}
+		fmt.Fprintln(&output, end)
+		written = true
+	}
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		trimmed := string(bytes.TrimSpace(line))
+		switch {
+		case inBlock:
+			if trimmed == end {
+				inBlock = false
+			}
+		case trimmed == begin:
+			inBlock = true
+			if !written {
+				writeBlock()
+			}
+		default:
+			output.Write(line)
+		}
+	}
+	if !written {
+		if output.Len() > 0 && !bytes.HasSuffix(output.Bytes(), []byte("\n")) {
+			output.WriteByte('\n')
+		}
+		writeBlock()
+	}
+	return output.Bytes()
+}
+
+func getTrustBundle(client *http.Client, baseUrl string) (
+	*proto.ClientTrustBundle, error) {
+	req, err := http.NewRequest("GET", baseUrl+proto.ClientTrustPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgentString)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting trust bundle: %s", resp.Status)
+	}
+	var bundle proto.ClientTrustBundle
+	err = json.NewDecoder(
+		&io.LimitedReader{R: resp.Body, N: maxTrustBundleSize}).Decode(&bundle)
+	if err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+// installTrustBundle keeps the keymaster block of knownHostsPath and the CA
+// bundle in caBundlePath up to date, so that hosts with keymaster host
+// certificates and services with keymaster X.509 certificates are trusted.
+func installTrustBundle(client *http.Client, baseUrl string,
+	knownHostsPath string, caBundlePath string, logger log.DebugLogger) error {
+	bundle, err := getTrustBundle(client, baseUrl)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(knownHostsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	begin := "# BEGIN " + FilePrefix + " certificate authorities"
+	end := "# END " + FilePrefix + " certificate authorities"
+	newData := replaceManagedBlock(data, begin, end,
+		[]byte(bundle.KnownHosts))
+	if !bytes.Equal(data, newData) {
+		if err := ioutil.WriteFile(knownHostsPath, newData, 0644); err != nil {
+			return err
+		}
+		logger.Debugf(0, "updated %s", knownHostsPath)
+	}
+	if bundle.X509CABundle != "" {
+		err := ioutil.WriteFile(caBundlePath, []byte(bundle.X509CABundle),
+			0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
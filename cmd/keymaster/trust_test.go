@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReplaceManagedBlock(t *testing.T) {
+	const begin = "# BEGIN test"
+	const end = "# END test"
+	tests := []struct {
+		input, block, expected string
+	}{
+		{"", "@cert-authority * key1\n",
+			"# BEGIN test\n@cert-authority * key1\n# END test\n"},
+		{"host1 key0", "key1",
+			"host1 key0\n# BEGIN test\nkey1\n# END test\n"},
+		{"host1 key0\n# BEGIN test\nold1\nold2\n# END test\nhost2 key2\n",
+			"key1\n",
+			"host1 key0\n# BEGIN test\nkey1\n# END test\nhost2 key2\n"},
+		{"# BEGIN test\nold\n# END test\n", "",
+			"# BEGIN test\n# END test\n"},
+	}
+	for _, test := range tests {
+		output := string(replaceManagedBlock([]byte(test.input), begin, end,
+			[]byte(test.block)))
+		if output != test.expected {
+			t.Errorf("input %q: expected %q, got %q", test.input,
+				test.expected, output)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	maxClientUpdateManifestSize = 1 << 20
+	maxClientDownloadSize       = 256 << 20
+)
+
+var errNoClientUpdates = errors.New("no client updates published")
+
+func getPublicDocument(client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgentString)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNoClientUpdates
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(
+		&io.LimitedReader{R: resp.Body, N: maxClientUpdateManifestSize})
+}
+
+func parseCACerts(pemData string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	data := []byte(pemData)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// verifyDetachedJWS checks that signature, a JWS with detached payload, is
+// over document and was made by the key of one of caCerts.
+func verifyDetachedJWS(signature string, document []byte,
+	caCerts []*x509.Certificate) error {
+	parts := strings.Split(strings.TrimSpace(signature), ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return errors.New("not a detached JWS")
+	}
+	parts[1] = base64.RawURLEncoding.EncodeToString(document)
+	jws, err := jose.ParseSigned(strings.Join(parts, "."))
+	if err != nil {
+		return err
+	}
+	for _, caCert := range caCerts {
+		if _, err := jws.Verify(caCert.PublicKey); err == nil {
+			return nil
+		}
+	}
+	return errors.New("manifest not signed by a keymaster CA")
+}
+
+// getClientUpdateManifest fetches the client update manifest from baseUrl
+// and verifies its signature.
+func getClientUpdateManifest(client *http.Client, baseUrl string) (
+	*proto.ClientUpdateManifest, error) {
+	bundle, err := getTrustBundle(client, baseUrl)
+	if err != nil {
+		return nil, err
+	}
+	caCerts, err := parseCACerts(bundle.X509CABundle)
+	if err != nil {
+		return nil, err
+	}
+	document, err := getPublicDocument(client,
+		baseUrl+proto.ClientUpdatePath)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := getPublicDocument(client,
+		baseUrl+proto.ClientUpdatePath+".jws")
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyDetachedJWS(string(signature), document,
+		caCerts); err != nil {
+		return nil, err
+	}
+	var manifest proto.ClientUpdateManifest
+	if err := json.Unmarshal(document, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// compareVersions returns -1, 0 or 1 if version a is older than, the same
+// as or newer than version b. Numeric components are compared as numbers.
+func compareVersions(a, b string) int {
+	split := func(version string) []string {
+		return strings.FieldsFunc(strings.TrimPrefix(version, "v"),
+			func(r rune) bool { return r == '.' || r == '-' })
+	}
+	partsA := split(a)
+	partsB := split(b)
+	for index := 0; index < len(partsA) || index < len(partsB); index++ {
+		partA, partB := "0", "0"
+		if index < len(partsA) {
+			partA = partsA[index]
+		}
+		if index < len(partsB) {
+			partB = partsB[index]
+		}
+		numA, errA := strconv.ParseUint(partA, 10, 64)
+		numB, errB := strconv.ParseUint(partB, 10, 64)
+		if errA == nil && errB == nil {
+			if numA < numB {
+				return -1
+			}
+			if numA > numB {
+				return 1
+			}
+			continue
+		}
+		if cmp := strings.Compare(partA, partB); cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+func findClientDownload(manifest *proto.ClientUpdateManifest,
+	goos, goarch string) *proto.ClientDownload {
+	for index := range manifest.Downloads {
+		download := &manifest.Downloads[index]
+		if download.OS == goos && download.Arch == goarch {
+			return download
+		}
+	}
+	return nil
+}
+
+// downloadClient writes the client binary of download to writer, returning
+// an error if it does not match the published checksum.
+func downloadClient(client *http.Client, download *proto.ClientDownload,
+	writer io.Writer) error {
+	expectedSum, err := hex.DecodeString(download.SHA256)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", download.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgentString)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error downloading %s: %s", download.URL,
+			resp.Status)
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(writer, hasher),
+		&io.LimitedReader{R: resp.Body, N: maxClientDownloadSize})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hasher.Sum(nil), expectedSum) {
+		return fmt.Errorf("checksum mismatch for: %s", download.URL)
+	}
+	return nil
+}
+
+// updateExecutable replaces the running executable with the verified
+// download. The new binary is written next to it and renamed over it.
+func updateExecutable(client *http.Client, download *proto.ClientDownload) (
+	string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	executable, err = filepath.EvalSymlinks(executable)
+	if err != nil {
+		return "", err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(executable), ".keymaster-update-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	if err := downloadClient(client, download, file); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Chmod(0755); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	// Windows does not allow replacing a running executable, but allows
+	// renaming it.
+	if runtime.GOOS == "windows" {
+		oldExecutable := executable + ".old"
+		os.Remove(oldExecutable)
+		if err := os.Rename(executable, oldExecutable); err != nil {
+			return "", err
+		}
+	}
+	return executable, os.Rename(file.Name(), executable)
+}
+
+// checkForClientUpdate tells the user about a newer client published by the
+// keymaster at baseUrl and, if autoUpdate is true, installs it.
+func checkForClientUpdate(client *http.Client, baseUrl string,
+	autoUpdate bool, logger log.DebugLogger) error {
+	if Version == defaultVersionNumber {
+		logger.Debugf(1, "no client version, not checking for updates")
+		return nil
+	}
+	manifest, err := getClientUpdateManifest(client, baseUrl)
+	if err != nil {
+		if err == errNoClientUpdates {
+			logger.Debugf(1, "%s", err)
+			return nil
+		}
+		return err
+	}
+	if compareVersions(Version, manifest.Version) >= 0 {
+		logger.Debugf(1, "client is up to date")
+		return nil
+	}
+	if manifest.MinimumVersion != "" &&
+		compareVersions(Version, manifest.MinimumVersion) < 0 {
+		logger.Printf("Warning: keymaster %s is no longer supported, "+
+			"please update to %s", Version, manifest.Version)
+	} else {
+		logger.Printf("keymaster %s is available (running %s)",
+			manifest.Version, Version)
+	}
+	if manifest.ReleaseNotesURL != "" {
+		logger.Printf("Release notes: %s", manifest.ReleaseNotesURL)
+	}
+	download := findClientDownload(manifest, runtime.GOOS, runtime.GOARCH)
+	if download == nil {
+		return nil
+	}
+	if !autoUpdate {
+		logger.Printf("Download: %s (or run with -autoUpdate)", download.URL)
+		return nil
+	}
+	executable, err := updateExecutable(client, download)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Updated %s to %s", executable, manifest.Version)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.2.3", "1.10.0", -1},
+		{"1.10.0", "1.9.9", 1},
+		{"2.0.0", "1.99", 1},
+		{"1.2.3-rc1", "1.2.3-rc2", -1},
+	}
+	for _, test := range tests {
+		if got := compareVersions(test.a, test.b); got != test.expected {
+			t.Errorf("compareVersions(%q, %q): expected: %d, got: %d",
+				test.a, test.b, test.expected, got)
+		}
+	}
+}
+
+func newTestUpdateCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "keymaster CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestVerifyDetachedJWS(t *testing.T) {
+	caCert, caKey := newTestUpdateCA(t)
+	otherCACert, _ := newTestUpdateCA(t)
+	document := []byte(`{"version": "1.1.0"}`)
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: caKey}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := jws.DetachedCompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifyDetachedJWS(signature, document,
+		[]*x509.Certificate{otherCACert, caCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifyDetachedJWS(signature, document,
+		[]*x509.Certificate{otherCACert})
+	if err == nil {
+		t.Fatal("signature from another CA verified")
+	}
+	err = verifyDetachedJWS(signature, []byte(`{"version": "9.9.9"}`),
+		[]*x509.Certificate{caCert})
+	if err == nil {
+		t.Fatal("signature over another document verified")
+	}
+}
+
+func TestDownloadClient(t *testing.T) {
+	binary := []byte("new keymaster binary")
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write(binary)
+		}))
+	defer server.Close()
+	sum := sha256.Sum256(binary)
+	manifest := &proto.ClientUpdateManifest{
+		Version: "1.1.0",
+		Downloads: []proto.ClientDownload{{
+			OS:     "linux",
+			Arch:   "amd64",
+			URL:    server.URL + "/keymaster",
+			SHA256: hex.EncodeToString(sum[:]),
+		}},
+	}
+	if findClientDownload(manifest, "darwin", "arm64") != nil {
+		t.Fatal("found a download for another platform")
+	}
+	download := findClientDownload(manifest, "linux", "amd64")
+	if download == nil {
+		t.Fatal("no download found")
+	}
+	var buffer bytes.Buffer
+	if err := downloadClient(server.Client(), download, &buffer); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer.Bytes(), binary) {
+		t.Fatal("downloaded binary differs")
+	}
+	download.SHA256 = hex.EncodeToString(make([]byte, sha256.Size))
+	buffer.Reset()
+	if err := downloadClient(server.Client(), download, &buffer); err == nil {
+		t.Fatal("binary with the wrong checksum accepted")
+	}
+}
@@ -209,3 +209,52 @@ func TestBootstrapOtpAuthGoodOTP(t *testing.T) {
 			profile.BootstrapOTP.Sha512Hash)
 	}
 }
+
+func TestBootstrapOtpSessionIsEnrollmentOnly(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBootstrapOTP(t, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{proto.AuthTypeU2F}
+	checkPath := func(authType int, path string, expectedStatus int) {
+		cookieVal, err := state.setNewAuthCookie(nil, testBootstrapUser,
+			authType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", path, nil)
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		_, err = checkRequestHandlerCode(req,
+			func(w http.ResponseWriter, r *http.Request) {
+				state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+			}, expectedStatus)
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+		}
+	}
+	bootstrapAuth := AuthTypePassword | AuthTypeBootstrapOTP
+	checkPath(bootstrapAuth, profilePath, http.StatusOK)
+	checkPath(bootstrapAuth, u2fRegustisterRequestPath+testBootstrapUser,
+		http.StatusOK)
+	checkPath(bootstrapAuth, totpGeneratNewPath, http.StatusOK)
+	checkPath(bootstrapAuth, profilePath+"alice", http.StatusForbidden)
+	checkPath(bootstrapAuth, issuedCertsPath, http.StatusForbidden)
+	checkPath(bootstrapAuth, proto.APITokenPath, http.StatusForbidden)
+	checkPath(AuthTypeU2F|AuthTypeBootstrapOTP, issuedCertsPath, http.StatusOK)
+	// Once a second factor is registered, it must be used to log in.
+	profile, _, _, err := state.LoadUserProfile(testBootstrapUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.U2fAuthData == nil {
+		profile.U2fAuthData = make(map[int64]*u2fAuthData)
+	}
+	profile.U2fAuthData[0] = &u2fAuthData{Enabled: true}
+	if err := state.SaveUserProfile(testBootstrapUser, profile); err != nil {
+		t.Fatal(err)
+	}
+	checkPath(bootstrapAuth, u2fRegustisterRequestPath+testBootstrapUser,
+		http.StatusForbidden)
+	checkPath(bootstrapAuth, profilePath, http.StatusOK)
+}
@@ -13,12 +13,14 @@ import (
 	"image/png"
 	"math"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"time"
 
 	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
 	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 )
 
@@ -26,6 +28,13 @@ const labelRSA = "totp:rsa:"
 const totpGeneratNewPath = "/totp/GenerateNew/"
 const totpValidateNewPath = "/totp/ValidateNew/"
 
+// Steps of the TOTP enrollment wizard.
+const (
+	totpEnrollmentStepIntro = 1
+	totpEnrollmentStepScan  = 2
+	totpEnrollmentStepDone  = 3
+)
+
 func (state *RuntimeState) encryptWithPublicKeys(clearTextMessage []byte) ([][]byte, error) {
 	var cipherTexts [][]byte
 	for _, key := range state.KeymasterPublicKeys {
@@ -83,8 +92,31 @@ func (state *RuntimeState) GenerateNewTOTP(w http.ResponseWriter, r *http.Reques
 
 	// TODO: check if TOTP is even enabled.
 
-	// TODO: check for method, we should only allow POST requests
+	returnAcceptType := getPreferredAcceptType(r)
+	// Browsers are first shown an introduction, the secret is only generated
+	// when the user starts the enrollment.
+	if returnAcceptType == "text/html" && r.Method == "GET" {
+		state.writeNewTOTPPage(w, r, http.StatusOK, newTOTPPageTemplateData{
+			AuthUsername: authData.Username,
+			Title:        state.pageTitle("New TOTP Device"),
+			Step:         totpEnrollmentStepIntro,
+		})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	deviceName := r.Form.Get("name")
+	if deviceName != "" && !validDeviceNameRE.MatchString(deviceName) {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"invalid device name")
+		return
+	}
 
+	defer state.lockUserProfile(authData.Username)()
 	profile, _, fromCache, err := state.LoadUserProfile(authData.Username)
 	if err != nil {
 		logger.Printf("loading profile error: %v", err)
@@ -121,38 +153,76 @@ func (state *RuntimeState) GenerateNewTOTP(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	logger.Debugf(3, "Generate TOTP: profile=%+v", profile)
-	// Convert TOTP key into a PNG
-	var buf bytes.Buffer
-	img, err := key.Image(200, 200)
+	qrImage, err := totpQRCodeImage(key)
 	if err != nil {
-		panic(err)
+		logger.Printf("rendering QR code error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
 	}
-	png.Encode(&buf, img)
-	base64Image := base64.StdEncoding.EncodeToString(buf.Bytes())
-	logger.Debugf(10, "base64image=%s", base64Image)
-	// We need custom CSP policy to allow embedded images
-	w.Header().Set("Content-Security-Policy", "default-src 'self' ;img-src 'self'  data: ;style-src 'self' fonts.googleapis.com 'unsafe-inline'; font-src fonts.gstatic.com fonts.googleapis.com")
 	displayData := newTOTPPageTemplateData{
 		AuthUsername:    authData.Username,
-		Title:           "New TOTP Generation", //TODO: maybe include username?
+		Title:           state.pageTitle("New TOTP Device"),
+		Step:            totpEnrollmentStepScan,
+		DeviceName:      deviceName,
 		TOTPSecret:      key.Secret(),
-		TOTPBase64Image: template.HTML("<img src=\"data:image/png;base64," + base64Image + "\" alt=\"beastie.png\" scale=\"0\" />"),
+		TOTPBase64Image: qrImage,
 	}
-	returnAcceptType := getPreferredAcceptType(r)
 	switch returnAcceptType {
 	case "text/html":
-		err = state.htmlTemplate.ExecuteTemplate(w, "newTOTPage", displayData)
-		if err != nil {
-			logger.Printf("Failed to execute %v", err)
-			http.Error(w, "error", http.StatusInternalServerError)
-			return
-		}
+		state.writeNewTOTPPage(w, r, http.StatusOK, displayData)
 	default:
 		json.NewEncoder(w).Encode(displayData)
 	}
 	return
 }
 
+// pendingTOTPKey rebuilds the key being enrolled from its secret, so that the
+// QR code can be shown again if confirmation fails.
+func (state *RuntimeState) pendingTOTPKey(username string,
+	secret string) (*otp.Key, error) {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", state.HostIdentity)
+	keyURL := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + state.HostIdentity + ":" + username,
+		RawQuery: values.Encode(),
+	}
+	return otp.NewKeyFromURL(keyURL.String())
+}
+
+// totpQRCodeImage renders the key as an inline PNG, for scanning with an
+// authenticator app.
+func totpQRCodeImage(key *otp.Key) (template.HTML, error) {
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	base64Image := base64.StdEncoding.EncodeToString(buf.Bytes())
+	logger.Debugf(10, "base64image=%s", base64Image)
+	return template.HTML("<img src=\"data:image/png;base64," + base64Image +
+		"\" alt=\"TOTP QR code\" scale=\"0\" />"), nil
+}
+
+func (state *RuntimeState) writeNewTOTPPage(w http.ResponseWriter,
+	r *http.Request, statusCode int, displayData newTOTPPageTemplateData) {
+	if displayData.TOTPBase64Image != "" {
+		// We need custom CSP policy to allow embedded images
+		w.Header().Set("Content-Security-Policy", "default-src 'self' ;img-src 'self'  data: ;style-src 'self' fonts.googleapis.com 'unsafe-inline'; font-src fonts.gstatic.com fonts.googleapis.com")
+	}
+	w.WriteHeader(statusCode)
+	err := state.htmlTemplate.ExecuteTemplate(w, "newTOTPage", displayData)
+	if err != nil {
+		logger.Printf("Failed to execute %v", err)
+		return
+	}
+}
+
 func (state *RuntimeState) validateNewTOTP(w http.ResponseWriter, r *http.Request) {
 	authUser, _, otpValue, err := state.commonTOTPPostHandler(w, r, state.getRequiredWebUIAuthLevel())
 	if err != nil {
@@ -160,6 +230,7 @@ func (state *RuntimeState) validateNewTOTP(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	OTPString := fmt.Sprintf("%06d", otpValue)
+	defer state.lockUserProfile(authUser)()
 	profile, _, fromCache, err := state.LoadUserProfile(authUser)
 	if err != nil {
 		logger.Printf("loading profile error: %v", err)
@@ -185,26 +256,37 @@ func (state *RuntimeState) validateNewTOTP(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
+	deviceName := r.Form.Get("name")
+	if deviceName != "" && !validDeviceNameRE.MatchString(deviceName) {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"invalid device name")
+		return
+	}
+	returnAcceptType := getPreferredAcceptType(r)
 	valid := totp.Validate(OTPString, string(clearTextKey))
 	if !valid {
 		//render try again vailidate page, with an error message
 		logger.Printf("Invalid Entry")
-		w.WriteHeader(http.StatusBadRequest)
 		displayData := newTOTPPageTemplateData{
 			AuthUsername: authUser,
-			Title:        "Validate New TOTP Generation", //TODO: maybe include username?
+			Title:        state.pageTitle("New TOTP Device"),
+			Step:         totpEnrollmentStepScan,
+			DeviceName:   deviceName,
 			ErrorMessage: "Invalid TOTP value, please try again",
 		}
-		returnAcceptType := getPreferredAcceptType(r)
 		switch returnAcceptType {
 		case "text/html":
-			err = state.htmlTemplate.ExecuteTemplate(w, "newTOTPage", displayData)
+			key, err := state.pendingTOTPKey(authUser, string(clearTextKey))
+			if err == nil {
+				displayData.TOTPSecret = key.Secret()
+				displayData.TOTPBase64Image, err = totpQRCodeImage(key)
+			}
 			if err != nil {
-				logger.Printf("Failed to execute %v", err)
-				//http.Error(w, "error", http.StatusInternalServerError)
-				return
+				logger.Printf("rendering QR code error: %v", err)
 			}
+			state.writeNewTOTPPage(w, r, http.StatusBadRequest, displayData)
 		default:
+			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(displayData)
 		}
 		return
@@ -212,10 +294,12 @@ func (state *RuntimeState) validateNewTOTP(w http.ResponseWriter, r *http.Reques
 	}
 	// TODO: check if same secret already there
 	newTOTPAuthData := totpAuthData{
-		CreatedAt:       time.Now(),
-		EncryptedSecret: *profile.PendingTOTPSecret,
-		ValidatorAddr:   r.RemoteAddr,
-		Enabled:         true,
+		CreatedAt:        time.Now(),
+		Name:             deviceName,
+		EncryptedSecret:  *profile.PendingTOTPSecret,
+		ValidatorAddr:    r.RemoteAddr,
+		CreatorUserAgent: getTruncatedUserAgent(r),
+		Enabled:          true,
 	}
 	newIndex := newTOTPAuthData.CreatedAt.Unix()
 	profile.TOTPAuthData[newIndex] = &newTOTPAuthData
@@ -227,6 +311,17 @@ func (state *RuntimeState) validateNewTOTP(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
+	state.notifyDeviceRegistered(authUser, authUser,
+		fmt.Sprintf("The TOTP device %q", deviceName), r.RemoteAddr)
+	if returnAcceptType == "text/html" {
+		state.writeNewTOTPPage(w, r, http.StatusOK, newTOTPPageTemplateData{
+			AuthUsername: authUser,
+			Title:        state.pageTitle("New TOTP Device"),
+			Step:         totpEnrollmentStepDone,
+			DeviceName:   deviceName,
+		})
+		return
+	}
 	//redirect to profile page?
 	http.Redirect(w, r, profilePath, 302)
 }
@@ -286,6 +381,7 @@ func (state *RuntimeState) totpTokenManagerHandler(w http.ResponseWriter,
 	}
 
 	//Do a redirect
+	defer state.lockUserProfile(assumedUser)()
 	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
 	if err != nil {
 		logger.Printf("loading profile error: %v", err)
@@ -337,6 +433,9 @@ func (state *RuntimeState) totpTokenManagerHandler(w http.ResponseWriter,
 		return
 	}
 
+	state.notifyAdminImpersonation(authData.Username, assumedUser,
+		actionName+" TOTP token")
+
 	// Success!
 	returnAcceptType := getPreferredAcceptType(r)
 	switch returnAcceptType {
@@ -357,9 +456,12 @@ const numFailedTOTPChecksForTimeoutIncrease = 5
 // This function is the one actually validating the TOTP values, returns err non nil
 // if there is a problem with the internal state. Returns true if the previous OTP success
 // for this user is NOT on this period AND one of the otp values matches the one of the user's
-// registered keys.
-func (state *RuntimeState) validateUserTOTP(username string, OTPValue int, t time.Time) (bool, error) {
+// registered keys. The sourceAddr is recorded as the last use of the matching
+// device.
+func (state *RuntimeState) validateUserTOTP(username string, OTPValue int,
+	t time.Time, sourceAddr string) (bool, error) {
 	//Do a redirect
+	defer state.lockUserProfile(username)()
 	profile, _, fromCache, err := state.LoadUserProfile(username)
 	if err != nil {
 		logger.Printf("validateUserTOTP: loading profile error: %v", err)
@@ -420,6 +522,8 @@ func (state *RuntimeState) validateUserTOTP(username string, OTPValue int, t tim
 		}
 		if !fromCache {
 			profile.LastSuccessfullTOTPCounter = counter
+			deviceInfo.LastUsedAt = time.Now()
+			deviceInfo.LastUsedAddr = sourceAddr
 			err = state.SaveUserProfile(username, profile)
 			if err != nil {
 				logger.Printf("Saving profile error: %v", err)
@@ -499,7 +603,8 @@ func (state *RuntimeState) verifyTOTPHandler(w http.ResponseWriter, r *http.Requ
 		logger.Printf("Error in common Handler")
 		return
 	}
-	valid, err := state.validateUserTOTP(authUser, otpValue, time.Now())
+	valid, err := state.validateUserTOTP(authUser, otpValue, time.Now(),
+		r.RemoteAddr)
 	if err != nil {
 		logger.Printf("Error validating UserTOTP. Err: %s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
@@ -529,7 +634,8 @@ func (state *RuntimeState) TOTPAuthHandler(w http.ResponseWriter, r *http.Reques
 	return
 }
 func (state *RuntimeState) internalTOTPAuthHandler(w http.ResponseWriter, r *http.Request, authUser string, currentAuthLevel int, otpValue int) {
-	valid, err := state.validateUserTOTP(authUser, otpValue, time.Now())
+	valid, err := state.validateUserTOTP(authUser, otpValue, time.Now(),
+		r.RemoteAddr)
 	if err != nil {
 		logger.Printf("Error validating TOTP %s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when validating OTP token")
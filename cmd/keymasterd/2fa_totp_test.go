@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
@@ -112,7 +113,8 @@ func TestGenerateNewTOTPSuccess(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	valid, err := state.validateUserTOTP("username", otpValueInt, now)
+	valid, err := state.validateUserTOTP("username", otpValueInt, now,
+		"127.0.0.1:12345")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -120,7 +122,8 @@ func TestGenerateNewTOTPSuccess(t *testing.T) {
 		t.Fatal("should have been valid")
 	}
 	// now we retry with same value and should fail
-	valid, err = state.validateUserTOTP("username", otpValueInt, now)
+	valid, err = state.validateUserTOTP("username", otpValueInt, now,
+		"127.0.0.1:12345")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -130,6 +133,106 @@ func TestGenerateNewTOTPSuccess(t *testing.T) {
 
 }
 
+func TestTOTPEnrollmentWizard(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.signerPublicKeyToKeymasterKeys()
+	dir, err := ioutil.TempDir("", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.Config.Base.DataDirectory = dir
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	state.HostIdentity = "testHost"
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypePassword}
+	cookieVal, err := state.setNewAuthCookie(nil, "username",
+		AuthTypePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authCookie := http.Cookie{Name: authCookieName, Value: cookieVal}
+	newRequest := func(method, path string, data url.Values) *http.Request {
+		req, err := http.NewRequest(method, path,
+			strings.NewReader(data.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&authCookie)
+		return req
+	}
+	// Step 1: introduction, no secret is generated yet.
+	rr, err := checkRequestHandlerCode(newRequest("GET", totpGeneratNewPath,
+		nil), state.GenerateNewTOTP, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rr.Body.String(), `action="/totp/GenerateNew/"`) {
+		t.Fatal("introduction page missing")
+	}
+	// Step 2: generate the secret and show the QR code.
+	rr, err = checkRequestHandlerCode(newRequest("POST", totpGeneratNewPath,
+		url.Values{"name": {"phone"}}), state.GenerateNewTOTP, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := regexp.MustCompile(`<code>([A-Z2-7]+)</code>`).FindStringSubmatch(
+		rr.Body.String())
+	if len(matches) != 2 || !strings.Contains(rr.Body.String(),
+		"data:image/png;base64,") {
+		t.Fatal("QR code or secret missing")
+	}
+	secret := matches[1]
+	// A bad code shows the QR code again.
+	badValue, err := totp.GenerateCode(secret, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(newRequest("POST", totpValidateNewPath,
+		url.Values{"OTP": {badValue}, "name": {"phone"}}),
+		state.validateNewTOTP, http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rr.Body.String(), secret) {
+		t.Fatal("secret not shown again after failed confirmation")
+	}
+	// Step 3: confirm and save.
+	otpValue, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(newRequest("POST", totpValidateNewPath,
+		url.Values{"OTP": {otpValue}, "name": {"phone"}}),
+		state.validateNewTOTP, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, _, _, err := state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.TOTPAuthData) != 1 || profile.PendingTOTPSecret != nil {
+		t.Fatalf("TOTP device not saved: %+v", profile)
+	}
+	for _, device := range profile.TOTPAuthData {
+		if device.Name != "phone" {
+			t.Fatalf("bad device name: %s", device.Name)
+		}
+	}
+}
+
 func setupTestStateWithTOTPSecret(t *testing.T, state *RuntimeState, cookieAuth int) (*http.Cookie, string, error) {
 
 	authUser := "username"
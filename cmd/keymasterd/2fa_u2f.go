@@ -16,7 +16,7 @@ import (
 ////////////////////////////
 func getRegistrationArray(U2fAuthData map[int64]*u2fAuthData) (regArray []u2f.Registration) {
 	for _, data := range U2fAuthData {
-		if data.Enabled {
+		if data.Enabled && data.Registration != nil {
 			regArray = append(regArray, *data.Registration)
 		}
 	}
@@ -61,6 +61,7 @@ func (state *RuntimeState) u2fRegisterRequest(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	defer state.lockUserProfile(assumedUser)()
 	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
 	if err != nil {
 		logger.Printf("loading profile error: %v", err)
@@ -74,7 +75,7 @@ func (state *RuntimeState) u2fRegisterRequest(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	c, err := u2f.NewChallenge(u2fAppID, u2fTrustedFacets)
+	c, err := state.newU2FChallenge()
 	if err != nil {
 		logger.Printf("u2f.NewChallenge error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
@@ -136,6 +137,7 @@ func (state *RuntimeState) u2fRegisterResponse(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	defer state.lockUserProfile(assumedUser)()
 	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
 	if err != nil {
 		logger.Printf("loading profile error: %v", err)
@@ -164,10 +166,11 @@ func (state *RuntimeState) u2fRegisterResponse(w http.ResponseWriter, r *http.Re
 	}
 
 	newReg := u2fAuthData{Counter: 0,
-		Registration: reg,
-		Enabled:      true,
-		CreatedAt:    time.Now(),
-		CreatorAddr:  r.RemoteAddr,
+		Registration:     reg,
+		Enabled:          true,
+		CreatedAt:        time.Now(),
+		CreatorAddr:      r.RemoteAddr,
+		CreatorUserAgent: getTruncatedUserAgent(r),
 	}
 	if authData.Username != assumedUser {
 		newReg.Name = fmt.Sprintf("Registered by %s", authData.Username)
@@ -183,6 +186,10 @@ func (state *RuntimeState) u2fRegisterResponse(w http.ResponseWriter, r *http.Re
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
+	state.notifyAdminImpersonation(authData.Username, assumedUser,
+		"registered a U2F token")
+	state.notifyDeviceRegistered(authData.Username, assumedUser, "A U2F token",
+		r.RemoteAddr)
 
 	w.Write([]byte("success"))
 }
@@ -222,7 +229,7 @@ func (state *RuntimeState) u2fSignRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	c, err := u2f.NewChallenge(u2fAppID, u2fTrustedFacets)
+	c, err := state.newU2FChallenge()
 	if err != nil {
 		logger.Printf("u2f.NewChallenge error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
@@ -233,9 +240,9 @@ func (state *RuntimeState) u2fSignRequest(w http.ResponseWriter, r *http.Request
 	var localAuth localUserData
 	localAuth.U2fAuthChallenge = c
 	localAuth.ExpiresAt = time.Now().Add(maxAgeU2FVerifySeconds * time.Second)
-	state.Mutex.Lock()
+	state.cookieMutex.Lock()
 	state.localAuthData[authData.Username] = localAuth
-	state.Mutex.Unlock()
+	state.cookieMutex.Unlock()
 
 	req := c.SignRequest(registrations)
 	logger.Debugf(3, "Sign request: %+v", req)
@@ -273,6 +280,7 @@ func (state *RuntimeState) u2fSignResponse(w http.ResponseWriter, r *http.Reques
 
 	logger.Debugf(1, "signResponse: %+v", signResp)
 
+	defer state.lockUserProfile(authData.Username)()
 	profile, ok, _, err := state.LoadUserProfile(authData.Username)
 	if err != nil {
 		logger.Printf("loading profile error: %v", err)
@@ -296,9 +304,12 @@ func (state *RuntimeState) u2fSignResponse(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "registration missing", http.StatusBadRequest)
 		return
 	}
-	state.Mutex.Lock()
+	// The challenge may only be answered once, whether or not the answer is
+	// valid.
+	state.cookieMutex.Lock()
 	localAuth, ok := state.localAuthData[authData.Username]
-	state.Mutex.Unlock()
+	delete(state.localAuthData, authData.Username)
+	state.cookieMutex.Unlock()
 	if !ok {
 		http.Error(w, "challenge missing", http.StatusBadRequest)
 		return
@@ -306,6 +317,9 @@ func (state *RuntimeState) u2fSignResponse(w http.ResponseWriter, r *http.Reques
 
 	//var err error
 	for i, u2fReg := range profile.U2fAuthData {
+		if u2fReg.Registration == nil {
+			continue
+		}
 		//newCounter, authErr := u2fReg.Registration.Authenticate(signResp, *profile.U2fAuthChallenge, u2fReg.Counter)
 		newCounter, authErr := u2fReg.Registration.Authenticate(signResp, *localAuth.U2fAuthChallenge, u2fReg.Counter)
 		if authErr == nil {
@@ -316,9 +330,15 @@ func (state *RuntimeState) u2fSignResponse(w http.ResponseWriter, r *http.Reques
 			u2fReg.Counter = newCounter
 			//profile.U2fAuthData[i].Counter = newCounter
 			u2fReg.Counter = newCounter
+			u2fReg.LastUsedAt = time.Now()
+			u2fReg.LastUsedAddr = r.RemoteAddr
 			profile.U2fAuthData[i] = u2fReg
 			//profile.U2fAuthChallenge = nil
-			delete(state.localAuthData, authData.Username)
+			err = state.SaveUserProfile(authData.Username, profile)
+			if err != nil {
+				// Not fatal: the authentication itself succeeded.
+				logger.Printf("Saving profile error: %v", err)
+			}
 
 			eventNotifier.PublishAuthEvent(eventmon.AuthTypeU2F, authData.Username)
 			_, isXHR := r.Header["X-Requested-With"]
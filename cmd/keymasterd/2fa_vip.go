@@ -19,8 +19,8 @@ func (state *RuntimeState) startVIPPush(cookieVal string, username string) error
 		return err
 	}
 	newLocalData := pushPollTransaction{Username: username, TransactionID: transactionId, ExpiresAt: time.Now().Add(maxAgeSecondsVIPCookie * time.Second)}
-	state.Mutex.Lock()
-	defer state.Mutex.Unlock()
+	state.cookieMutex.Lock()
+	defer state.cookieMutex.Unlock()
 	state.vipPushCookie[cookieVal] = newLocalData
 
 	return nil
@@ -135,8 +135,8 @@ func (state *RuntimeState) VIPAuthHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (state *RuntimeState) getPushPollTransaction(cookieValue string) (pushPollTransaction, bool) {
-	state.Mutex.Lock()
-	defer state.Mutex.Unlock()
+	state.cookieMutex.Lock()
+	defer state.cookieMutex.Unlock()
 	value, ok := state.vipPushCookie[cookieValue]
 	return value, ok
 }
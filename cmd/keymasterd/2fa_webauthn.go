@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"github.com/Cloud-Foundations/keymaster/lib/webauthn"
+	"github.com/Cloud-Foundations/keymaster/proto/eventmon"
+)
+
+// The WebAuthn API is the successor of the U2F API and the only one
+// available in mobile browsers. Credentials registered through it are stored
+// alongside the U2F registrations and grant the same auth level. U2F
+// registrations can be used through the WebAuthn API, but not vice versa,
+// so the CLI (which speaks U2F) cannot use WebAuthn credentials.
+
+const (
+	webauthnRegisterRequestPath  = "/webauthn/RegisterRequest/"
+	webauthnRegisterResponsePath = "/webauthn/RegisterResponse/"
+	webauthnSignRequestPath      = "/webauthn/SignRequest"
+	webauthnSignResponsePath     = "/webauthn/SignResponse"
+
+	// Phones may need to be unlocked or held against an NFC key, so allow
+	// more time than for U2F.
+	webauthnTimeout = 60 * time.Second
+)
+
+type webauthnCredentialData struct {
+	ID           []byte
+	PublicKey    []byte // PKIX, ASN.1 DER form.
+	Discoverable bool   // A passkey, which may be used without a username.
+}
+
+type webauthnCredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type webauthnRelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type webauthnUserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type webauthnCredentialParameters struct {
+	Type      string `json:"type"`
+	Algorithm int    `json:"alg"`
+}
+
+type webauthnAuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey,omitempty"`
+	RequireResidentKey bool   `json:"requireResidentKey,omitempty"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// webauthnRegisterRequest holds the options for navigator.credentials.create.
+// Binary values are base64url encoded.
+type webauthnRegisterRequest struct {
+	Challenge              string                         `json:"challenge"`
+	RelyingParty           webauthnRelyingPartyEntity     `json:"rp"`
+	User                   webauthnUserEntity             `json:"user"`
+	PubKeyCredParams       []webauthnCredentialParameters `json:"pubKeyCredParams"`
+	Timeout                int64                          `json:"timeout"`
+	ExcludeCredentials     []webauthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection webauthnAuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                         `json:"attestation"`
+}
+
+type webauthnRegisterResponse struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+type webauthnSignExtensions struct {
+	AppID string `json:"appid,omitempty"`
+}
+
+// webauthnSignRequest holds the options for navigator.credentials.get.
+type webauthnSignRequest struct {
+	Challenge        string                         `json:"challenge"`
+	RelyingPartyID   string                         `json:"rpId"`
+	Timeout          int64                          `json:"timeout"`
+	AllowCredentials []webauthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+	Extensions       webauthnSignExtensions         `json:"extensions"`
+}
+
+type webauthnSignResponse struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	AppID             bool   `json:"appid"`
+}
+
+// isPasskeyRegistration returns true if the client asked to register a
+// passkey rather than a second factor.
+func (state *RuntimeState) isPasskeyRegistration(r *http.Request) bool {
+	return state.Config.U2F.PasskeyLogin &&
+		r.URL.Query().Get("passkey") == "true"
+}
+
+func encodeBase64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+func (state *RuntimeState) getWebAuthnRelyingParty() (
+	*webauthn.RelyingParty, error) {
+	// The relying party ID is only derived from the U2F config, as
+	// credentials cannot be used under another ID.
+	if state.u2fIdentity.relyingParty == nil {
+		return nil, errors.New("WebAuthn relying party not configured")
+	}
+	return state.u2fIdentity.relyingParty, nil
+}
+
+// webauthnCredential returns the device as a WebAuthn credential and whether
+// it was registered through the U2F API.
+func (data *u2fAuthData) webauthnCredential() (webauthn.Credential, bool,
+	error) {
+	if data.WebAuthn == nil {
+		if data.Registration == nil {
+			return webauthn.Credential{}, false,
+				errors.New("no registration data")
+		}
+		publicKey := data.Registration.PubKey
+		return webauthn.Credential{
+			ID:        data.Registration.KeyHandle,
+			PublicKey: &publicKey,
+			Counter:   data.Counter,
+		}, true, nil
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(data.WebAuthn.PublicKey)
+	if err != nil {
+		return webauthn.Credential{}, false, err
+	}
+	ecdsaKey, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return webauthn.Credential{}, false,
+			fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+	return webauthn.Credential{
+		ID:        data.WebAuthn.ID,
+		PublicKey: ecdsaKey,
+		Counter:   data.Counter,
+	}, false, nil
+}
+
+func getWebAuthnCredentialDescriptors(
+	U2fAuthData map[int64]*u2fAuthData) []webauthnCredentialDescriptor {
+	descriptors := make([]webauthnCredentialDescriptor, 0)
+	for _, data := range U2fAuthData {
+		if !data.Enabled {
+			continue
+		}
+		credential, _, err := data.webauthnCredential()
+		if err != nil {
+			continue
+		}
+		descriptors = append(descriptors, webauthnCredentialDescriptor{
+			Type: "public-key",
+			ID:   encodeBase64URL(credential.ID),
+		})
+	}
+	return descriptors
+}
+
+func hasWebAuthnCredentials(profile *userProfile) bool {
+	for _, data := range profile.U2fAuthData {
+		if data.Enabled && data.WebAuthn != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (state *RuntimeState) webauthnRegisterRequest(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	// /webauthn/RegisterRequest/<assumed user>
+	pieces := strings.Split(r.URL.Path, "/")
+	if len(pieces) < 4 || pieces[3] == "" {
+		http.Error(w, "error", http.StatusBadRequest)
+		return
+	}
+	assumedUser := pieces[3]
+	authData, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authData.Username)
+	if !state.IsAdminUserAndU2F(authData.Username, authData.AuthType) &&
+		authData.Username != assumedUser {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rp, err := state.getWebAuthnRelyingParty()
+	if err != nil {
+		logger.Printf("webauthn relying party error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	defer state.lockUserProfile(assumedUser)()
+	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if fromCache {
+		http.Error(w, "db backend is offline for writes",
+			http.StatusServiceUnavailable)
+		return
+	}
+	// The U2F challenge is just random bytes with a timestamp.
+	c, err := state.newU2FChallenge()
+	if err != nil {
+		logger.Printf("u2f.NewChallenge error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	profile.RegistrationChallenge = c
+	userHandle, err := getWebAuthnUserHandle(profile)
+	if err != nil {
+		logger.Printf("user handle error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if err := state.SaveUserProfile(assumedUser, profile); err != nil {
+		logger.Printf("Saving profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	req := webauthnRegisterRequest{
+		Challenge: encodeBase64URL(c.Challenge),
+		RelyingParty: webauthnRelyingPartyEntity{
+			ID:   rp.ID,
+			Name: state.productName(),
+		},
+		User: webauthnUserEntity{
+			ID:          encodeBase64URL(userHandle),
+			Name:        assumedUser,
+			DisplayName: assumedUser,
+		},
+		PubKeyCredParams: []webauthnCredentialParameters{
+			{Type: "public-key", Algorithm: webauthn.AlgorithmES256},
+		},
+		Timeout: int64(webauthnTimeout / time.Millisecond),
+		ExcludeCredentials: getWebAuthnCredentialDescriptors(
+			profile.U2fAuthData),
+		AuthenticatorSelection: webauthnAuthenticatorSelection{
+			UserVerification: "discouraged",
+		},
+		Attestation: "none",
+	}
+	if state.isPasskeyRegistration(r) {
+		req.AuthenticatorSelection = webauthnAuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(req); err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
+
+func (state *RuntimeState) webauthnRegisterResponse(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	// /webauthn/RegisterResponse/<assumed user>
+	pieces := strings.Split(r.URL.Path, "/")
+	if len(pieces) < 4 || pieces[3] == "" {
+		http.Error(w, "error", http.StatusBadRequest)
+		return
+	}
+	assumedUser := pieces[3]
+	authData, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authData.Username)
+	if !state.IsAdminUserAndU2F(authData.Username, authData.AuthType) &&
+		authData.Username != assumedUser {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var regResp webauthnRegisterResponse
+	if err := json.NewDecoder(r.Body).Decode(&regResp); err != nil {
+		http.Error(w, "invalid response: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientDataJSON, err := decodeBase64URL(regResp.ClientDataJSON)
+	if err != nil {
+		http.Error(w, "invalid clientDataJSON", http.StatusBadRequest)
+		return
+	}
+	attestationObject, err := decodeBase64URL(regResp.AttestationObject)
+	if err != nil {
+		http.Error(w, "invalid attestationObject", http.StatusBadRequest)
+		return
+	}
+	defer state.lockUserProfile(assumedUser)()
+	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if fromCache {
+		http.Error(w, "db backend is offline for writes",
+			http.StatusServiceUnavailable)
+		return
+	}
+	challenge := profile.RegistrationChallenge
+	if challenge == nil ||
+		time.Since(challenge.Timestamp) > webauthnTimeout {
+		http.Error(w, "challenge not found", http.StatusBadRequest)
+		return
+	}
+	rp, err := state.getWebAuthnRelyingParty()
+	if err != nil {
+		logger.Printf("webauthn relying party error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	credential, err := rp.VerifyRegistration(challenge.Challenge,
+		clientDataJSON, attestationObject)
+	if err != nil {
+		logger.Printf("webauthn registration error: %v", err)
+		http.Error(w, "error verifying response", http.StatusBadRequest)
+		return
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(credential.PublicKey)
+	if err != nil {
+		logger.Printf("error marshaling public key: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	newReg := u2fAuthData{
+		Counter:          credential.Counter,
+		Enabled:          true,
+		CreatedAt:        time.Now(),
+		CreatorAddr:      r.RemoteAddr,
+		CreatorUserAgent: getTruncatedUserAgent(r),
+		WebAuthn: &webauthnCredentialData{
+			ID:           credential.ID,
+			PublicKey:    publicKey,
+			Discoverable: state.isPasskeyRegistration(r),
+		},
+	}
+	if newReg.WebAuthn.Discoverable {
+		if len(profile.WebAuthnUserHandle) < 1 {
+			http.Error(w, "user handle not found", http.StatusBadRequest)
+			return
+		}
+		err := state.saveWebAuthnUserHandle(assumedUser,
+			profile.WebAuthnUserHandle)
+		if err != nil {
+			logger.Printf("Saving user handle error: %v", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if authData.Username != assumedUser {
+		newReg.Name = fmt.Sprintf("Registered by %s", authData.Username)
+	}
+	profile.U2fAuthData[newReg.CreatedAt.Unix()] = &newReg
+	profile.RegistrationChallenge = nil
+	profile.UserHasRegistered2ndFactor = true
+	if err := state.SaveUserProfile(assumedUser, profile); err != nil {
+		logger.Printf("Saving profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	logger.Printf("WebAuthn registration success for %s", assumedUser)
+	state.notifyAdminImpersonation(authData.Username, assumedUser,
+		"registered a security key")
+	state.notifyDeviceRegistered(authData.Username, assumedUser,
+		"A security key", r.RemoteAddr)
+	w.Write([]byte("success"))
+}
+
+func (state *RuntimeState) webauthnSignRequest(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authData, err := state.checkAuth(w, r, AuthTypeAny)
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authData.Username)
+	profile, ok, _, err := state.LoadUserProfile(authData.Username)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "No regstered data", http.StatusBadRequest)
+		return
+	}
+	credentials := getWebAuthnCredentialDescriptors(profile.U2fAuthData)
+	if len(credentials) < 1 {
+		http.Error(w, "registration missing", http.StatusBadRequest)
+		return
+	}
+	rp, err := state.getWebAuthnRelyingParty()
+	if err != nil {
+		logger.Printf("webauthn relying party error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	c, err := state.newU2FChallenge()
+	if err != nil {
+		logger.Printf("u2f.NewChallenge error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	state.cookieMutex.Lock()
+	state.localAuthData[authData.Username] = localUserData{
+		U2fAuthChallenge: c,
+		ExpiresAt:        time.Now().Add(webauthnTimeout),
+	}
+	state.cookieMutex.Unlock()
+	req := webauthnSignRequest{
+		Challenge:        encodeBase64URL(c.Challenge),
+		RelyingPartyID:   rp.ID,
+		Timeout:          int64(webauthnTimeout / time.Millisecond),
+		AllowCredentials: credentials,
+		UserVerification: "discouraged",
+		Extensions:       webauthnSignExtensions{AppID: rp.AppID},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(req); err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
+
+func (state *RuntimeState) webauthnSignResponse(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authData, err := state.checkAuth(w, r, AuthTypeAny)
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authData.Username)
+	var signResp webauthnSignResponse
+	if err := json.NewDecoder(r.Body).Decode(&signResp); err != nil {
+		http.Error(w, "invalid response: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var assertion webauthn.Assertion
+	credentialID, err := decodeBase64URL(signResp.ID)
+	if err == nil {
+		assertion.ClientDataJSON, err = decodeBase64URL(
+			signResp.ClientDataJSON)
+	}
+	if err == nil {
+		assertion.AuthenticatorData, err = decodeBase64URL(
+			signResp.AuthenticatorData)
+	}
+	if err == nil {
+		assertion.Signature, err = decodeBase64URL(signResp.Signature)
+	}
+	if err != nil {
+		http.Error(w, "invalid response encoding", http.StatusBadRequest)
+		return
+	}
+	assertion.UsedAppID = signResp.AppID
+	// The challenge may only be answered once, whether or not the answer is
+	// valid.
+	state.cookieMutex.Lock()
+	localAuth, ok := state.localAuthData[authData.Username]
+	delete(state.localAuthData, authData.Username)
+	state.cookieMutex.Unlock()
+	if !ok || localAuth.ExpiresAt.Before(time.Now()) {
+		http.Error(w, "challenge missing", http.StatusBadRequest)
+		return
+	}
+	defer state.lockUserProfile(authData.Username)()
+	profile, ok, _, err := state.LoadUserProfile(authData.Username)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "No regstered data", http.StatusBadRequest)
+		return
+	}
+	rp, err := state.getWebAuthnRelyingParty()
+	if err != nil {
+		logger.Printf("webauthn relying party error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	for index, device := range profile.U2fAuthData {
+		if !device.Enabled {
+			continue
+		}
+		credential, legacyU2F, err := device.webauthnCredential()
+		if err != nil || !bytes.Equal(credential.ID, credentialID) {
+			continue
+		}
+		newCounter, err := rp.VerifyAssertion(
+			localAuth.U2fAuthChallenge.Challenge, credential, legacyU2F,
+			assertion)
+		if err != nil {
+			metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, false)
+			logger.Printf("webauthn assertion error for %s: %v",
+				authData.Username, err)
+			http.Error(w, "error verifying response", http.StatusUnauthorized)
+			return
+		}
+		metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, true)
+		device.Counter = newCounter
+		device.LastUsedAt = time.Now()
+		device.LastUsedAddr = r.RemoteAddr
+		profile.U2fAuthData[index] = device
+		if err := state.SaveUserProfile(authData.Username, profile); err != nil {
+			// Not fatal: the authentication itself succeeded.
+			logger.Printf("Saving profile error: %v", err)
+		}
+		eventNotifier.PublishAuthEvent(eventmon.AuthTypeU2F, authData.Username)
+		if _, isXHR := r.Header["X-Requested-With"]; isXHR {
+			eventNotifier.PublishWebLoginEvent(authData.Username)
+		}
+		_, err = state.updateAuthCookieAuthlevel(w, r,
+			authData.AuthType|AuthTypeU2F)
+		if err != nil {
+			logger.Printf("Auth Cookie NOT found ? %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"Failure updating auth cookie")
+			return
+		}
+		w.Write([]byte("success"))
+		return
+	}
+	metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, false)
+	http.Error(w, "unknown credential", http.StatusBadRequest)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+
+	"github.com/tstranex/u2f"
+)
+
+func TestWebAuthnCredentials(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := map[int64]*u2fAuthData{
+		1: {
+			Enabled: true,
+			Registration: &u2f.Registration{
+				KeyHandle: []byte("u2f"),
+				PubKey:    privateKey.PublicKey,
+			},
+		},
+		2: {
+			Enabled: true,
+			WebAuthn: &webauthnCredentialData{
+				ID:        []byte("webauthn"),
+				PublicKey: publicKey,
+			},
+		},
+		3: {
+			Enabled: false,
+			WebAuthn: &webauthnCredentialData{
+				ID:        []byte("disabled"),
+				PublicKey: publicKey,
+			},
+		},
+	}
+	// The U2F API cannot use WebAuthn credentials.
+	if registrations := getRegistrationArray(devices); len(registrations) != 1 {
+		t.Fatalf("expected 1 U2F registration, got %d", len(registrations))
+	}
+	if descriptors := getWebAuthnCredentialDescriptors(devices); len(
+		descriptors) != 2 {
+		t.Fatalf("expected 2 WebAuthn credentials, got %d", len(descriptors))
+	}
+	credential, legacyU2F, err := devices[1].webauthnCredential()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !legacyU2F || !bytes.Equal(credential.ID, []byte("u2f")) {
+		t.Fatalf("bad U2F credential: %v", credential)
+	}
+	credential, legacyU2F, err = devices[2].webauthnCredential()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacyU2F || credential.PublicKey.X.Cmp(privateKey.X) != 0 {
+		t.Fatalf("bad WebAuthn credential: %v", credential)
+	}
+	if !hasWebAuthnCredentials(&userProfile{U2fAuthData: devices}) {
+		t.Fatal("WebAuthn credentials not found")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/authutil"
+)
+
+var errAccountNotActive = errors.New("account not active")
+
+const (
+	accountStatusActive   = ""
+	accountStatusDisabled = "disabled"
+	accountStatusExpired  = "expired"
+	accountStatusLocked   = "locked"
+	accountStatusUnknown  = "unknown"
+
+	adAccountDisable = 0x2
+	adLockout        = 0x10
+	// Seconds between the FILETIME epoch (1601) and the Unix epoch.
+	fileTimeEpochOffset = 11644473600
+)
+
+// The account control attributes of Active Directory, 389 Directory Server
+// and FreeIPA, OpenLDAP ppolicy and the shadow schema. Servers ignore the
+// ones they do not know.
+var accountStatusAttributes = []string{
+	"userAccountControl",
+	"msDS-User-Account-Control-Computed",
+	"accountExpires",
+	"nsAccountLock",
+	"pwdAccountLockedTime",
+	"shadowExpire",
+	"krbPrincipalExpiration",
+}
+
+// accountStatusConfig enables checking the account status of users in the
+// LDAP userinfo sources at login and certificate issuance.
+type accountStatusConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Refuse users whose status was never seen if the directory cannot be
+	// reached.
+	FailClosed bool `yaml:"fail_closed"`
+}
+
+// accountStatusCache remembers the last status seen for each user, for when
+// the directory cannot be reached.
+type accountStatusCache struct {
+	mutex    sync.Mutex
+	statuses map[string]string
+}
+
+func (cache *accountStatusCache) get(username string) (string, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	status, ok := cache.statuses[username]
+	return status, ok
+}
+
+func (cache *accountStatusCache) set(username, status string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.statuses == nil {
+		cache.statuses = make(map[string]string)
+	}
+	cache.statuses[username] = status
+}
+
+// getAccountStatus returns why the account with the attribute values may not
+// be used, or accountStatusActive.
+func getAccountStatus(values map[string][]string, now time.Time) string {
+	for _, attribute := range []string{"userAccountControl",
+		"msDS-User-Account-Control-Computed"} {
+		for _, value := range values[attribute] {
+			flags, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			if flags&adAccountDisable != 0 {
+				return accountStatusDisabled
+			}
+			if flags&adLockout != 0 {
+				return accountStatusLocked
+			}
+		}
+	}
+	for _, value := range values["nsAccountLock"] {
+		if strings.EqualFold(value, "true") {
+			return accountStatusDisabled
+		}
+	}
+	if len(values["pwdAccountLockedTime"]) > 0 {
+		return accountStatusLocked
+	}
+	for _, value := range values["accountExpires"] {
+		fileTime, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || fileTime <= 0 || fileTime == 0x7FFFFFFFFFFFFFFF {
+			continue
+		}
+		if now.Unix() >= fileTime/10000000-fileTimeEpochOffset {
+			return accountStatusExpired
+		}
+	}
+	for _, value := range values["shadowExpire"] {
+		days, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || days <= 0 {
+			continue
+		}
+		if now.Unix() >= days*24*3600 {
+			return accountStatusExpired
+		}
+	}
+	for _, value := range values["krbPrincipalExpiration"] {
+		expiration, err := time.Parse("20060102150405Z0700", value)
+		if err != nil {
+			continue
+		}
+		if !now.Before(expiration) {
+			return accountStatusExpired
+		}
+	}
+	return accountStatusActive
+}
+
+// getUserAccountStatus looks the account status of username up in the LDAP
+// userinfo sources. Users which are not in any directory are active. If no
+// directory can be reached, the last status seen is used.
+func (state *RuntimeState) getUserAccountStatus(username string) (
+	string, error) {
+	if !state.Config.AccountStatus.Enabled {
+		return accountStatusActive, nil
+	}
+	var lastErr error
+	for _, query := range state.getLdapUserInfoQueries(username) {
+		values, err := state.getLdapAttributesFromSource(query.source,
+			query.username, accountStatusAttributes)
+		if err == authutil.ErrLDAPUserNotFound {
+			continue
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		status := getAccountStatus(values, time.Now())
+		state.accountStatuses.set(username, status)
+		return status, nil
+	}
+	if lastErr == nil {
+		return accountStatusActive, nil
+	}
+	if status, ok := state.accountStatuses.get(username); ok {
+		return status, nil
+	}
+	if state.Config.AccountStatus.FailClosed {
+		return accountStatusUnknown, lastErr
+	}
+	return accountStatusActive, lastErr
+}
+
+// checkAccountStatus returns false, after writing a failure response, if the
+// account of username may not be used.
+func (state *RuntimeState) checkAccountStatus(w http.ResponseWriter,
+	r *http.Request, username string) bool {
+	status, err := state.getUserAccountStatus(username)
+	if err != nil {
+		logger.Printf("error getting account status of: %s: %s", username, err)
+	}
+	if status == accountStatusActive {
+		return true
+	}
+	logger.Printf("refusing %s: account %s", username, status)
+	if status == accountStatusUnknown {
+		state.writeFailureResponse(w, r, http.StatusServiceUnavailable,
+			"Account status could not be checked")
+		return false
+	}
+	state.writeFailureResponse(w, r, http.StatusForbidden,
+		"Account "+status)
+	return false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetAccountStatus(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		values map[string][]string
+		status string
+	}{
+		{nil, accountStatusActive},
+		{map[string][]string{"userAccountControl": {"512"}},
+			accountStatusActive},
+		{map[string][]string{"userAccountControl": {"514"}},
+			accountStatusDisabled},
+		{map[string][]string{"userAccountControl": {"512"},
+			"msDS-User-Account-Control-Computed": {"16"}},
+			accountStatusLocked},
+		{map[string][]string{"accountExpires": {"0"}}, accountStatusActive},
+		{map[string][]string{"accountExpires": {"9223372036854775807"}},
+			accountStatusActive},
+		// 2020-01-01.
+		{map[string][]string{"accountExpires": {"132223104000000000"}},
+			accountStatusExpired},
+		// 2021-01-01.
+		{map[string][]string{"accountExpires": {"132539328000000000"}},
+			accountStatusActive},
+		{map[string][]string{"nsAccountLock": {"TRUE"}},
+			accountStatusDisabled},
+		{map[string][]string{"nsAccountLock": {"false"}},
+			accountStatusActive},
+		{map[string][]string{"pwdAccountLockedTime": {"000001010000Z"}},
+			accountStatusLocked},
+		{map[string][]string{"shadowExpire": {"18000"}},
+			accountStatusExpired},
+		{map[string][]string{"shadowExpire": {"19000"}},
+			accountStatusActive},
+		{map[string][]string{"shadowExpire": {"-1"}}, accountStatusActive},
+		{map[string][]string{"krbPrincipalExpiration": {"20200101000000Z"}},
+			accountStatusExpired},
+		{map[string][]string{"krbPrincipalExpiration": {"20210101000000Z"}},
+			accountStatusActive},
+	}
+	for _, test := range tests {
+		if status := getAccountStatus(test.values, now); status != test.status {
+			t.Errorf("%v: got status: %q, expected: %q",
+				test.values, status, test.status)
+		}
+	}
+}
+
+func TestGetUserAccountStatusUnreachable(t *testing.T) {
+	state := &RuntimeState{}
+	state.Config.UserInfo.Ldap.LDAPTargetURLs = "ldaps://127.0.0.1:1"
+	status, err := state.getUserAccountStatus("user")
+	if err != nil || status != accountStatusActive {
+		t.Fatalf("disabled check returned: %q, %v", status, err)
+	}
+	state.Config.AccountStatus.Enabled = true
+	status, err = state.getUserAccountStatus("user")
+	if err == nil {
+		t.Fatal("unreachable directory did not fail")
+	}
+	if status != accountStatusActive {
+		t.Fatalf("fail open returned: %q", status)
+	}
+	state.Config.AccountStatus.FailClosed = true
+	status, _ = state.getUserAccountStatus("user")
+	if status != accountStatusUnknown {
+		t.Fatalf("fail closed returned: %q", status)
+	}
+	state.accountStatuses.set("user", accountStatusDisabled)
+	status, _ = state.getUserAccountStatus("user")
+	if status != accountStatusDisabled {
+		t.Fatalf("last known status not used, got: %q", status)
+	}
+}
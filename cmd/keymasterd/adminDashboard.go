@@ -51,6 +51,9 @@ func (dashboard *adminDashboardType) ServeHTTP(w http.ResponseWriter,
 		// hide this.
 		fmt.Fprintln(writer, "<a href=\"logs\">Logs:</a><br>")
 	}
+	fmt.Fprintln(writer, "<a href=\"debug/pprof/\">Profiles</a>")
+	fmt.Fprintln(writer, "<a href=\"debug/runtime\">Runtime stats</a>")
+	fmt.Fprintln(writer, "<a href=\"status\">Status</a><br>")
 	fmt.Fprintln(writer, "</h3>")
 	fmt.Fprintln(writer, "<hr>")
 	if Version != "" {
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"strings"
 	"time"
 
+	"github.com/Cloud-Foundations/keymaster/keymasterd/alerting"
 	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
 )
 
@@ -15,6 +17,13 @@ const usersPath = "/users/"
 const addUserPath = "/admin/addUser"
 const deleteUserPath = "/admin/deleteUser"
 const generateBoostrapOTPPath = "/admin/newBoostrapOTP"
+const adminUserPath = "/admin/user/"
+const resetDevicesPath = "/admin/resetDevices"
+const revokeSessionsPath = "/admin/revokeSessions"
+const revokeCertificatePath = "/admin/revokeCertificate"
+
+var validUsernameRE = regexp.MustCompile(`^[A-Za-z0-9-_.]+$`)
+var validSerialRE = regexp.MustCompile(`^[0-9]+$`)
 
 const defaultBootstrapOTPDuration = 6 * time.Hour
 const maximumBootstrapOTPDuration = 24 * time.Hour
@@ -67,18 +76,12 @@ func (state *RuntimeState) ensurePostAndGetUsername(w http.ResponseWriter,
 		return ""
 	}
 	username := formUsername[0]
-	matched, err := regexp.Match(`^[A-Za-z0-9-_.]+$`, []byte(username))
-	if err != nil {
-		state.logger.Printf("error parsing err=%s", err)
-		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-		return ""
-	}
-	if !matched {
+	if !validUsernameRE.MatchString(username) {
 		state.writeFailureResponse(w, r, http.StatusBadRequest,
 			"Invalid Username found")
 		return ""
 	}
-	return username
+	return state.resolveUsernameAlias(username)
 }
 
 func (state *RuntimeState) usersHandler(w http.ResponseWriter,
@@ -95,12 +98,18 @@ func (state *RuntimeState) usersHandler(w http.ResponseWriter,
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
+	search := r.URL.Query().Get("q")
+	if search != "" {
+		users = filterUsers(users, search)
+	}
 	JSSources := []string{"/static/jquery-3.5.1.min.js"}
 	displayData := usersPageTemplateData{
-		AuthUsername: authUser,
-		Title:        "Keymaster Users",
-		Users:        users,
-		JSSources:    JSSources}
+		AuthUsername:  authUser,
+		Title:         state.pageTitle("Users"),
+		Users:         users,
+		Search:        search,
+		SessionCounts: state.sessions.countByUser(),
+		JSSources:     JSSources}
 	err = state.htmlTemplate.ExecuteTemplate(w, "usersPage", displayData)
 	if err != nil {
 		state.logger.Printf("Failed to execute %v", err)
@@ -119,6 +128,7 @@ func (state *RuntimeState) addUserHandler(w http.ResponseWriter,
 		return
 	}
 	// Check if username already exists.
+	defer state.lockUserProfile(username)()
 	profile, existing, fromCache, err := state.LoadUserProfile(username)
 	if err != nil {
 		state.logger.Printf("error parsing err=%s", err)
@@ -185,6 +195,7 @@ func (state *RuntimeState) generateBootstrapOTP(w http.ResponseWriter,
 	if username == "" {
 		return
 	}
+	defer state.lockUserProfile(username)()
 	profile, existing, fromCache, err := state.LoadUserProfile(username)
 	if err != nil {
 		state.logger.Printf("error parsing err=%s", err)
@@ -291,3 +302,265 @@ func (state *RuntimeState) generateBootstrapOTP(w http.ResponseWriter,
 	}
 	return
 }
+
+// filterUsers returns the users whose name contains search, ignoring case.
+func filterUsers(users []string, search string) []string {
+	search = strings.ToLower(search)
+	var matches []string
+	for _, user := range users {
+		if strings.Contains(strings.ToLower(user), search) {
+			matches = append(matches, user)
+		}
+	}
+	return matches
+}
+
+// writeAdminActionResponse redirects browsers to the admin page of username
+// (or the users page if there is none) and returns OK to everyone else.
+func writeAdminActionResponse(w http.ResponseWriter, r *http.Request,
+	username string) {
+	switch getPreferredAcceptType(r) {
+	case "text/html":
+		destination := usersPath
+		if username != "" {
+			destination = adminUserPath + username
+		}
+		http.Redirect(w, r, destination, http.StatusFound)
+	default:
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "OK\n")
+	}
+}
+
+// adminUserInfo is the admin view of a user, for API clients.
+type adminUserInfo struct {
+	Username     string             `json:"username"`
+	Devices      []userDeviceInfo   `json:"devices"`
+	Sessions     []sessionInfo      `json:"sessions"`
+	Certificates []issuedCertRecord `json:"certificates"`
+}
+
+// adminUserHandler renders the admin view of a user: devices (including
+// where they were registered and last used from), sessions and recently
+// issued certificates. Clients which do not accept HTML get JSON.
+func (state *RuntimeState) adminUserHandler(w http.ResponseWriter,
+	r *http.Request) {
+	failure, authUser := state.sendFailureToClientIfNonAdmin(w, r)
+	if failure {
+		return
+	}
+	username := strings.TrimPrefix(r.URL.Path, adminUserPath)
+	if !validUsernameRE.MatchString(username) {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid Username found")
+		return
+	}
+	profile, existing, fromCache, err := state.LoadUserProfile(username)
+	if err != nil {
+		state.logger.Printf("loading profile error: %v", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if !existing {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"User does not exist in DB")
+		return
+	}
+	certificates, err := state.getIssuedCertificates(username,
+		maxIssuedCertsPerRequest)
+	if err == nil {
+		err = state.markRevokedCertificates(username, certificates)
+	}
+	if err != nil {
+		state.logger.Printf("error getting issued certificates: %s", err)
+	}
+	if getPreferredAcceptType(r) != "text/html" {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(adminUserInfo{
+			Username:     username,
+			Devices:      getDeviceList(profile),
+			Sessions:     state.sessions.list(username),
+			Certificates: certificates,
+		})
+		if err != nil {
+			state.logger.Printf("json encoding error: %v", err)
+		}
+		return
+	}
+	displayData := adminUserPageTemplateData{
+		Title:        state.pageTitle("User " + username),
+		AuthUsername: authUser,
+		Username:     username,
+		Devices:      getDeviceList(profile),
+		Sessions:     state.sessions.list(username),
+		Certificates: certificates,
+	}
+	if fromCache {
+		displayData.ReadOnlyMsg = "The active keymaster is running disconnected from its DB backend. Devices cannot be reset."
+	}
+	err = state.htmlTemplate.ExecuteTemplate(w, "adminUserPage", displayData)
+	if err != nil {
+		state.logger.Printf("Failed to execute %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// notifyAdminImpersonation sends a notification when an admin changed the
+// second factors of another user.
+func (state *RuntimeState) notifyAdminImpersonation(authUser, targetUser,
+	action string) {
+	if authUser == targetUser {
+		return
+	}
+	state.alerter.Send(alerting.Event{
+		Type: alerting.EventAdminImpersonation,
+		Summary: fmt.Sprintf("%s acted as %s: %s", authUser, targetUser,
+			action),
+		Actor:  authUser,
+		Target: targetUser,
+	})
+}
+
+// resetDevicesHandler removes all the second factor devices of a user, for
+// example after the user lost them. The user will need a bootstrap OTP or
+// password-only access to register new ones.
+func (state *RuntimeState) resetDevicesHandler(w http.ResponseWriter,
+	r *http.Request) {
+	failure, authUser := state.sendFailureToClientIfNonAdmin(w, r)
+	if failure {
+		return
+	}
+	username := state.ensurePostAndGetUsername(w, r)
+	if username == "" {
+		return
+	}
+	defer state.lockUserProfile(username)()
+	profile, existing, fromCache, err := state.LoadUserProfile(username)
+	if err != nil {
+		state.logger.Printf("error loading profile err=%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if !existing {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"User does not exist in DB")
+		return
+	}
+	if fromCache {
+		state.writeFailureResponse(w, r, http.StatusServiceUnavailable,
+			"Working in db disconnected mode, try again later")
+		return
+	}
+	profile.U2fAuthData = make(map[int64]*u2fAuthData)
+	profile.TOTPAuthData = make(map[int64]*totpAuthData)
+	profile.TrustedDevices = nil
+	profile.PendingTOTPSecret = nil
+	profile.UserHasRegistered2ndFactor = false
+	if err := state.SaveUserProfile(username, profile); err != nil {
+		state.logger.Printf("error saving profile err=%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	state.logger.Printf("%s: reset devices of: %s\n", authUser, username)
+	state.alerter.Send(alerting.Event{
+		Type:    alerting.EventDevicesReset,
+		Summary: fmt.Sprintf("%s reset the devices of %s", authUser, username),
+		Actor:   authUser,
+		Target:  username,
+	})
+	state.notifyDevicesReset(authUser, username)
+	writeAdminActionResponse(w, r, username)
+}
+
+// revokeSessionsHandler revokes the session given by the session_id form
+// value, or all the sessions of the user if it is absent.
+func (state *RuntimeState) revokeSessionsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	failure, authUser := state.sendFailureToClientIfNonAdmin(w, r)
+	if failure {
+		return
+	}
+	username := state.ensurePostAndGetUsername(w, r)
+	if username == "" {
+		return
+	}
+	if sessionID := r.Form.Get("session_id"); sessionID != "" {
+		if !state.sessions.revoke(username, sessionID) {
+			state.writeFailureResponse(w, r, http.StatusNotFound,
+				"Unknown session")
+			return
+		}
+		state.logger.Printf("%s: revoked a session of: %s\n", authUser,
+			username)
+	} else {
+		count := state.sessions.revokeUser(username)
+		state.logger.Printf("%s: revoked %d sessions of: %s\n", authUser,
+			count, username)
+	}
+	err := state.recordSessionRevocation(username,
+		r.Form.Get("session_id"), authUser)
+	if err != nil {
+		state.logger.Printf("error recording session revocation: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	writeAdminActionResponse(w, r, username)
+}
+
+// revokeCertificateHandler records the revocation of a certificate, given
+// by the cert_type ("ssh" or "x509") and serial form values.
+func (state *RuntimeState) revokeCertificateHandler(w http.ResponseWriter,
+	r *http.Request) {
+	failure, authUser := state.sendFailureToClientIfNonAdmin(w, r)
+	if failure {
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		state.logger.Printf("error parsing err=%s", err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "")
+		return
+	}
+	serial := strings.TrimSpace(r.Form.Get("serial"))
+	if !validSerialRE.MatchString(serial) {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid serial number")
+		return
+	}
+	certType := r.Form.Get("cert_type")
+	if certType != revocationTypeSSH && certType != revocationTypeX509 {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid certificate type")
+		return
+	}
+	record, err := state.revokeCertificate(revokedCertRecord{
+		CertType:  certType,
+		Serial:    serial,
+		Reason:    r.Form.Get("reason"),
+		RevokedBy: authUser,
+	})
+	if err != nil {
+		state.logger.Printf("error revoking certificate err=%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	state.logger.Printf("%s: revoked %s certificate: %s of: %s\n", authUser,
+		certType, serial, record.Username)
+	state.alerter.Send(alerting.Event{
+		Type: alerting.EventCertificateRevoked,
+		Summary: fmt.Sprintf("%s revoked %s certificate %s", authUser,
+			certType, serial),
+		Actor:  authUser,
+		Target: record.Username,
+		Details: map[string]string{
+			"cert_type": certType,
+			"serial":    serial,
+			"reason":    record.Reason,
+		},
+	})
+	writeAdminActionResponse(w, r, record.Username)
+}
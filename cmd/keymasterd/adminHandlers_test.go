@@ -45,9 +45,7 @@ func testCreateRuntimeStateWithBothCAs(t *testing.T) (
 	if err != nil {
 		return nil, "", err
 	}
-	state.Signer = signer
-	state.caCertDer, err = generateCADer(state, state.Signer)
-	if err != nil {
+	if err := state.setSigners(signer, nil); err != nil {
 		return nil, "", err
 	}
 	state.signerPublicKeyToKeymasterKeys()
@@ -301,3 +299,102 @@ func TestGenerateBootstrapOtpAdminUser(t *testing.T) {
 		t.Error("got empty Bootstrap OTP hash")
 	}
 }
+
+func TestRevokeSessionsAdminUser(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	cookieValues := make([]string, 2)
+	for index := range cookieValues {
+		cookieValues[index], err = state.setNewAuthCookie(nil, "target",
+			AuthTypePassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions := state.sessions.list("target")
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got: %d", len(sessions))
+	}
+	info, err := state.getAuthInfoFromAuthJWT(cookieValues[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Revoke one session.
+	recorder := httptest.NewRecorder()
+	w := &instrumentedwriter.LoggingWriter{ResponseWriter: recorder}
+	req := httptest.NewRequest("POST", revokeSessionsPath, nil)
+	req.TLS, err = testMakeConnectionState("testdata/alice.pem",
+		"testdata/KeymasterCA.pem")
+	req.Form = make(url.Values)
+	req.Form.Add("username", "target")
+	req.Form.Add("session_id", info.SessionID)
+	state.revokeSessionsHandler(w, req)
+	if resp := recorder.Result(); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	}
+	if _, err := state.getAuthInfoFromAuthJWT(cookieValues[0]); err == nil {
+		t.Fatal("revoked session still accepted")
+	}
+	if _, err := state.getAuthInfoFromAuthJWT(cookieValues[1]); err != nil {
+		t.Fatal(err)
+	}
+	// Revoke the rest.
+	recorder = httptest.NewRecorder()
+	w = &instrumentedwriter.LoggingWriter{ResponseWriter: recorder}
+	req.Form.Del("session_id")
+	state.revokeSessionsHandler(w, req)
+	if resp := recorder.Result(); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	}
+	if _, err := state.getAuthInfoFromAuthJWT(cookieValues[1]); err == nil {
+		t.Fatal("revoked session still accepted")
+	}
+	if len(state.sessions.list("target")) != 0 {
+		t.Fatal("revoked sessions still listed")
+	}
+}
+
+func TestRevokeCertificateAdminUser(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	state.recordIssuedCertificate(issuedCertRecord{
+		Username:  "target",
+		CertType:  "x509-kubernetes",
+		Serial:    "1234",
+		IssuedAt:  time.Now(),
+		ExpiresAt: expiresAt,
+	})
+	recorder := httptest.NewRecorder()
+	w := &instrumentedwriter.LoggingWriter{ResponseWriter: recorder}
+	req := httptest.NewRequest("POST", revokeCertificatePath, nil)
+	req.TLS, err = testMakeConnectionState("testdata/alice.pem",
+		"testdata/KeymasterCA.pem")
+	req.Form = make(url.Values)
+	req.Form.Add("cert_type", "x509")
+	req.Form.Add("serial", "1234")
+	req.Form.Add("reason", "key compromise")
+	state.revokeCertificateHandler(w, req)
+	resp := recorder.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d, status: %s, body: %s",
+			resp.StatusCode, resp.Status, string(body))
+	}
+	records, err := state.getIssuedCertificates("target", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.markRevokedCertificates("target", records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || !records[0].Revoked {
+		t.Fatalf("certificate not marked as revoked: %+v", records)
+	}
+}
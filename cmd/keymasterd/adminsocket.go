@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+const defaultAdminSocketMode = 0660
+
+// adminSocketConfig serves the admin handlers on a UNIX socket, for tooling
+// on the same host. Access is controlled by the permissions of the socket:
+// any local user who may connect to it is treated as an admin.
+type adminSocketConfig struct {
+	Path  string `yaml:"path"`
+	Mode  string `yaml:"mode"`  // Octal. Default: 0660.
+	Group string `yaml:"group"` // Default: the group of keymasterd.
+}
+
+type adminSocketPeerKey struct{}
+
+func (config *adminSocketConfig) getMode() (os.FileMode, error) {
+	if config.Mode == "" {
+		return defaultAdminSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(config.Mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("admin_socket: invalid mode: %s", config.Mode)
+	}
+	if mode&^0777 != 0 {
+		return 0, fmt.Errorf("admin_socket: invalid mode: %s", config.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+func (config *adminSocketConfig) check(adminAddress string) error {
+	if config.Path == "" {
+		if adminAddress == "" {
+			return fmt.Errorf("admin_address is required without admin_socket")
+		}
+		return nil
+	}
+	if _, err := config.getMode(); err != nil {
+		return err
+	}
+	if config.Group != "" {
+		if _, err := user.LookupGroup(config.Group); err != nil {
+			return fmt.Errorf("admin_socket: %s", err)
+		}
+	}
+	return nil
+}
+
+// getAdminClientName returns the name of the admin client of a request: the
+// local user for requests from the admin socket, else the common name of the
+// verified client certificate. It returns "" if neither is available.
+func getAdminClientName(r *http.Request) string {
+	if peer, ok := r.Context().Value(adminSocketPeerKey{}).(string); ok {
+		return "unix:" + peer
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) < 1 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+func isAdminSocketRequest(r *http.Request) bool {
+	_, ok := r.Context().Value(adminSocketPeerKey{}).(string)
+	return ok
+}
+
+// listen creates the admin socket with the configured ownership
+// and permissions, replacing a stale socket left by a previous run.
+func (config *adminSocketConfig) listen() (net.Listener, error) {
+	if fi, err := os.Lstat(config.Path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("admin_socket: %s exists and is not a socket",
+				config.Path)
+		}
+		if err := os.Remove(config.Path); err != nil {
+			return nil, err
+		}
+	}
+	mode, err := config.getMode()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", config.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(config.Path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if config.Group != "" {
+		group, err := user.LookupGroup(config.Group)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		gid, err := strconv.Atoi(group.Gid)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		if err := os.Chown(config.Path, -1, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// startAdminSocket serves the admin handlers on the admin socket, if one is
+// configured. Requests are marked with the local user at the other end, so
+// that the admin handlers accept them without a client certificate.
+func (state *RuntimeState) startAdminSocket(handler http.Handler) error {
+	config := state.Config.Base.AdminSocket
+	if config.Path == "" {
+		return nil
+	}
+	listener, err := config.listen()
+	if err != nil {
+		return err
+	}
+	server := state.newHTTPServer(config.Path, handler)
+	server.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		return context.WithValue(ctx, adminSocketPeerKey{},
+			getAdminSocketPeer(conn))
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			panic(err)
+		}
+	}()
+	state.logger.Printf("started admin socket on: %s\n", config.Path)
+	return nil
+}
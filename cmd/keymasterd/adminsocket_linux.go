@@ -0,0 +1,38 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// getAdminSocketPeer returns the name of the local user at the other end of
+// an admin socket connection, from the peer credentials of the socket.
+func getAdminSocketPeer(conn net.Conn) string {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return "unknown"
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return "unknown"
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET,
+			syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return "unknown"
+	}
+	uid := strconv.FormatUint(uint64(cred.Uid), 10)
+	if u, err := user.LookupId(uid); err == nil {
+		return u.Username
+	}
+	return "uid=" + uid
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"net"
+)
+
+// getAdminSocketPeer returns "unknown": peer credentials are only read on
+// Linux.
+func getAdminSocketPeer(conn net.Conn) string {
+	return "unknown"
+}
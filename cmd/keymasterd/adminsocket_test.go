@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+func TestAdminSocketConfigCheck(t *testing.T) {
+	if err := (&adminSocketConfig{}).check(":6920"); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&adminSocketConfig{}).check(""); err == nil {
+		t.Fatal("no error without admin_address or admin_socket")
+	}
+	config := adminSocketConfig{Path: "/run/keymasterd/admin.sock"}
+	if err := config.check(""); err != nil {
+		t.Fatal(err)
+	}
+	config.Mode = "0999"
+	if err := config.check(""); err == nil {
+		t.Fatal("no error for invalid mode")
+	}
+	config.Mode = "01777"
+	if err := config.check(""); err == nil {
+		t.Fatal("no error for mode with special bits")
+	}
+}
+
+func TestAdminSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adminsocket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "admin.sock")
+	// A stale socket from a previous run is replaced.
+	stale, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	state := &RuntimeState{logger: testlogger.New(t)}
+	state.Config.Base.AdminSocket = adminSocketConfig{
+		Path: socketPath,
+		Mode: "0600",
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state.sendFailureToClientIfNotAdminUserOrCA(w, r) {
+			return
+		}
+		w.Write([]byte(getAdminClientName(r)))
+	})
+	if err := state.startAdminSocket(handler); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Fatalf("expected mode: 0600, got: %o", perm)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (
+			net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	resp, err := client.Get("http://keymasterd/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected: %d, got: %d: %s",
+			http.StatusOK, resp.StatusCode, body)
+	}
+	if !strings.HasPrefix(string(body), "unix:") {
+		t.Fatalf("unexpected client name: %s", body)
+	}
+	// Without the socket, requests still need TLS.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected: %d, got: %d", http.StatusUnauthorized, w.Code)
+	}
+}
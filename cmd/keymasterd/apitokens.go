@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	apiTokenType               = "keymaster_api_token"
+	defaultAPITokenLifetime    = time.Hour
+	defaultMaxAPITokenLifetime = 8 * time.Hour
+
+	secondFactorAuthTypes = AuthTypeU2F | AuthTypeSymantecVIP | AuthTypeTOTP |
+		AuthTypeOkta2FA
+)
+
+// apiTokenScopes maps the scopes of API tokens to the paths they may be
+// used for. Paths ending in "/" include all the paths below them.
+var apiTokenScopes = map[string][]string{
+	"certgen":             {certgenPath},
+	"devices":             {devicesAPIPath},
+	"issued_certificates": {issuedCertsPath},
+}
+
+// apiTokenConfig configures the bearer tokens which users may obtain after
+// logging in with a second factor, for use by scripts.
+type apiTokenConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	MaxLifetime time.Duration `yaml:"max_lifetime"`
+}
+
+func (config *apiTokenConfig) getMaxLifetime() time.Duration {
+	if config.MaxLifetime > 0 {
+		return config.MaxLifetime
+	}
+	return defaultMaxAPITokenLifetime
+}
+
+func getBearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) <= len(prefix) ||
+		!strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[len(prefix):]), true
+}
+
+func apiTokenAllowsPath(scopes []string, path string) bool {
+	for _, scope := range scopes {
+		for _, pattern := range apiTokenScopes[scope] {
+			if pattern == path || (strings.HasSuffix(pattern, "/") &&
+				strings.HasPrefix(path, pattern)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateAPITokenScopes returns the sorted scopes, or an error for the
+// client if one is unknown.
+func validateAPITokenScopes(scopes []string) ([]string, error) {
+	if len(scopes) < 1 {
+		return nil, errors.New("No scope requested")
+	}
+	seen := make(map[string]struct{}, len(scopes))
+	result := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if _, ok := apiTokenScopes[scope]; !ok {
+			return nil, fmt.Errorf("Unknown scope: %s", scope)
+		}
+		if _, ok := seen[scope]; ok {
+			continue
+		}
+		seen[scope] = struct{}{}
+		result = append(result, scope)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// genNewSerializedAPIToken returns a token for the session in authData. The
+// token is revoked with the session.
+func (state *RuntimeState) genNewSerializedAPIToken(authData *authInfo,
+	scopes []string, expiresAt time.Time) (string, error) {
+	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256,
+		Key: state.Signer}, signerOptions)
+	if err != nil {
+		return "", err
+	}
+	issuer := state.idpGetIssuer()
+	token := authInfoJWT{
+		Issuer:     issuer,
+		Subject:    authData.Username,
+		Audience:   []string{issuer},
+		Expiration: expiresAt.Unix(),
+		NotBefore:  time.Now().Unix(),
+		TokenType:  apiTokenType,
+		AuthType:   authData.AuthType,
+		ID:         authData.SessionID,
+		AuthTime:   authData.IssuedAt.Unix(),
+		Scopes:     scopes,
+	}
+	token.IssuedAt = token.NotBefore
+	return jwt.Signed(signer).Claims(token).CompactSerialize()
+}
+
+// getAuthInfoFromAPIToken verifies the token and returns the authentication
+// data of the session it was issued for, and its scopes.
+func (state *RuntimeState) getAuthInfoFromAPIToken(serializedToken string) (
+	authInfo, []string, error) {
+	tok, err := jwt.ParseSigned(serializedToken)
+	if err != nil {
+		return authInfo{}, nil, err
+	}
+	inboundJWT := authInfoJWT{}
+	if err := state.JWTClaims(tok, &inboundJWT); err != nil {
+		return authInfo{}, nil, err
+	}
+	issuer := state.idpGetIssuer()
+	now := time.Now().Unix()
+	if inboundJWT.Issuer != issuer || inboundJWT.TokenType != apiTokenType ||
+		len(inboundJWT.Audience) < 1 || inboundJWT.Audience[0] != issuer ||
+		inboundJWT.NotBefore > now || inboundJWT.Expiration < now {
+		return authInfo{}, nil, errors.New("invalid JWT values")
+	}
+	if state.sessions.isSessionRevoked(inboundJWT.ID, inboundJWT.Subject,
+		time.Unix(inboundJWT.AuthTime, 0)) {
+		return authInfo{}, nil, errors.New("session has been revoked")
+	}
+	return authInfo{
+		AuthType:  inboundJWT.AuthType,
+		ExpiresAt: time.Unix(inboundJWT.Expiration, 0),
+		IssuedAt:  time.Unix(inboundJWT.AuthTime, 0),
+		Username:  inboundJWT.Subject,
+		SessionID: inboundJWT.ID,
+	}, inboundJWT.Scopes, nil
+}
+
+// checkAPITokenAuth is the bearer token part of checkAuth. The token must
+// have a scope for the requested path.
+func (state *RuntimeState) checkAPITokenAuth(w http.ResponseWriter,
+	r *http.Request, token string, requiredAuthType int) (*authInfo, error) {
+	if !state.Config.APITokens.Enabled {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+		return nil, errors.New("API tokens not enabled")
+	}
+	info, scopes, err := state.getAuthInfoFromAPIToken(token)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Invalid token")
+		return nil, err
+	}
+	if !apiTokenAllowsPath(scopes, r.URL.Path) {
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Token scope does not include this path")
+		return nil, fmt.Errorf("token of: %s not valid for: %s",
+			info.Username, r.URL.Path)
+	}
+	if (info.AuthType & requiredAuthType) == 0 {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+		return nil, errors.New("Insufficient Auth Level in API token")
+	}
+	return &info, nil
+}
+
+// apiTokenHandler issues a bearer token for the scope form values, valid for
+// the requested duration. The session must have been authenticated with a
+// second factor, and the token does not outlive it.
+func (state *RuntimeState) apiTokenHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if !state.Config.APITokens.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authData, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authData.Username)
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if authData.SessionID == "" ||
+		(authData.AuthType&secondFactorAuthTypes) == 0 {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Second factor authentication required")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	scopes, err := validateAPITokenScopes(r.Form["scope"])
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	duration := defaultAPITokenLifetime
+	if formDuration := r.Form.Get("duration"); formDuration != "" {
+		duration, err = time.ParseDuration(formDuration)
+		if err != nil || duration <= 0 {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Error parsing form (duration)")
+			return
+		}
+	}
+	maxLifetime := state.Config.APITokens.getMaxLifetime()
+	if duration > maxLifetime {
+		duration = maxLifetime
+	}
+	expiresAt := time.Now().Add(duration)
+	if expiresAt.After(authData.ExpiresAt) {
+		expiresAt = authData.ExpiresAt
+	}
+	token, err := state.genNewSerializedAPIToken(authData, scopes, expiresAt)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	logger.Printf("issued API token to: %s for: %s until: %s",
+		authData.Username, strings.Join(scopes, ","),
+		expiresAt.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(proto.APITokenResponse{
+		Token:     token,
+		ExpiresAt: expiresAt.Unix(),
+		Scopes:    scopes,
+	})
+	if err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+)
+
+func TestAPITokenScopes(t *testing.T) {
+	if !apiTokenAllowsPath([]string{"certgen"}, certgenPath+"alice") {
+		t.Error("certgen scope does not allow certgen")
+	}
+	if apiTokenAllowsPath([]string{"certgen"}, issuedCertsPath) {
+		t.Error("certgen scope allows issued certificates")
+	}
+	if apiTokenAllowsPath([]string{"devices"}, devicesAPIPath+"/x") {
+		t.Error("devices scope allows sub path")
+	}
+	scopes, err := validateAPITokenScopes([]string{"devices", "certgen",
+		"devices"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(scopes, ",") != "certgen,devices" {
+		t.Errorf("unexpected scopes: %v", scopes)
+	}
+	for _, bad := range [][]string{nil, {"certgen", "admin"}} {
+		if _, err := validateAPITokenScopes(bad); err == nil {
+			t.Errorf("scopes: %v accepted", bad)
+		}
+	}
+}
+
+func TestAPITokenHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	state.Config.APITokens.Enabled = true
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypePassword, proto.AuthTypeU2F}
+	newTokenRequest := func(authType int, scopes ...string) *http.Request {
+		cookieVal, err := state.setNewAuthCookie(nil, "username", authType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{"scope": scopes, "duration": {"30m"}}
+		req, err := http.NewRequest("POST", proto.APITokenPath,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		return req
+	}
+	// Password alone is not enough.
+	_, err = checkRequestHandlerCode(newTokenRequest(AuthTypePassword,
+		"certgen"), state.apiTokenHandler, http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(newTokenRequest(AuthTypeU2F, "admin"),
+		state.apiTokenHandler, http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(newTokenRequest(AuthTypeU2F,
+		"certgen"), state.apiTokenHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response proto.APITokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	checkToken := func(path string, expectedStatus int) {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+response.Token)
+		_, err = checkRequestHandlerCode(req,
+			func(w http.ResponseWriter, r *http.Request) {
+				authData, err := state.checkAuth(w, r, AuthTypeAny)
+				if err != nil {
+					return
+				}
+				if authData.Username != "username" {
+					t.Errorf("unexpected username: %s", authData.Username)
+				}
+			}, expectedStatus)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+	}
+	checkToken(certgenPath+"username", http.StatusOK)
+	checkToken(issuedCertsPath, http.StatusForbidden)
+	// A token cannot be used to get another token.
+	checkToken(proto.APITokenPath, http.StatusForbidden)
+	// Tokens are revoked with their session.
+	state.sessions.revokeUser("username")
+	checkToken(certgenPath+"username", http.StatusUnauthorized)
+}
@@ -17,7 +17,6 @@ import (
 	stdlog "log"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -39,9 +38,20 @@ import (
 	"github.com/Cloud-Foundations/golib/pkg/log"
 	"github.com/Cloud-Foundations/golib/pkg/watchdog"
 	"github.com/Cloud-Foundations/keymaster/keymasterd/admincache"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/alerting"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/captcha"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/certreloader"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/certstream"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/deviceposture"
 	"github.com/Cloud-Foundations/keymaster/keymasterd/eventnotifier"
-	"github.com/Cloud-Foundations/keymaster/lib/authenticators/okta"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/faultinjection"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/i18n"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/instanceidentity"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/publisher"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/requestlimiter"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/signingpool"
 	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+	"github.com/Cloud-Foundations/keymaster/lib/circuitbreaker"
 	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
 	"github.com/Cloud-Foundations/keymaster/lib/pwauth"
 	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
@@ -53,6 +63,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/tstranex/u2f"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
 )
 
 const (
@@ -66,6 +78,8 @@ const (
 	AuthTypeOkta2FA
 	AuthTypeBootstrapOTP
 	AuthTypeKeymasterX509
+	AuthTypeAutomationToken
+	AuthTypeTrustedDevice
 )
 
 const AuthTypeAny = 0xFFFF
@@ -75,6 +89,9 @@ type authInfo struct {
 	ExpiresAt time.Time
 	IssuedAt  time.Time
 	Username  string
+	SessionID string
+	// Set when authenticated with an automation token.
+	AutomationToken *automationToken
 }
 
 type authInfoJWT struct {
@@ -86,6 +103,9 @@ type authInfoJWT struct {
 	IssuedAt   int64    `json:"iat,omitempty"`
 	TokenType  string   `json:"token_type"`
 	AuthType   int      `json:"auth_type"`
+	ID         string   `json:"jti,omitempty"`
+	AuthTime   int64    `json:"auth_time,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
 }
 
 type storageStringDataJWT struct {
@@ -101,21 +121,28 @@ type storageStringDataJWT struct {
 }
 
 type u2fAuthData struct {
-	Enabled      bool
-	CreatedAt    time.Time
-	CreatorAddr  string
-	Counter      uint32
-	Name         string
-	Registration *u2f.Registration
+	Enabled          bool
+	CreatedAt        time.Time
+	CreatorAddr      string
+	CreatorUserAgent string
+	Counter          uint32
+	Name             string
+	Registration     *u2f.Registration // nil for WebAuthn credentials.
+	LastUsedAt       time.Time
+	LastUsedAddr     string
+	WebAuthn         *webauthnCredentialData
 }
 
 type totpAuthData struct {
-	Enabled         bool
-	CreatedAt       time.Time
-	Name            string
-	EncryptedSecret [][]byte
-	TOTPType        int
-	ValidatorAddr   string
+	Enabled          bool
+	CreatedAt        time.Time
+	Name             string
+	EncryptedSecret  [][]byte
+	TOTPType         int
+	ValidatorAddr    string // The address which registered the device.
+	CreatorUserAgent string
+	LastUsedAt       time.Time
+	LastUsedAddr     string
 }
 
 type bootstrapOTPData struct {
@@ -131,6 +158,11 @@ type userProfile struct {
 	TOTPAuthData               map[int64]*totpAuthData
 	BootstrapOTP               bootstrapOTPData
 	UserHasRegistered2ndFactor bool
+	KnownCertSourceAddrs       map[string]time.Time // IP: last seen.
+	TrustedDevices             map[int64]*trustedDeviceData
+	WebAuthnUserHandle         []byte // Random, created on first use.
+	SchemaVersion              uint32
+	readOnly                   bool // Written by a newer schema version.
 }
 
 type localUserData struct {
@@ -142,6 +174,8 @@ type pendingAuth2Request struct {
 	ExpiresAt time.Time
 	state     string
 	ctx       context.Context
+	// Where to send the browser once the login completes.
+	loginDestination string
 }
 
 type pushPollTransaction struct {
@@ -167,24 +201,54 @@ type RuntimeState struct {
 	HostIdentity         string
 	KerberosRealm        *string
 	caCertDer            []byte
+	caCert               *x509.Certificate
+	crossSignedCAs       []*x509.Certificate
+	sshSigner            ssh.Signer
+	ed25519SSHSigner     ssh.Signer
 	certManager          *certmanager.CertificateManager
+	certReloader         *certreloader.Reloader
+	trustedProxies       []*net.IPNet
 	vipPushCookie        map[string]pushPollTransaction
 	localAuthData        map[string]localUserData
 	SignerIsReady        chan bool
 	oktaUsernameFilterRE *regexp.Regexp
-	Mutex                sync.Mutex
+	usernameAliases      map[string]string
+	accountStatuses      accountStatusCache
+	userSeen             userSeenCache
+	honeytokenAlerts     honeytokenAlertCache
+	passkeyChallenges    passkeyChallengeCache
+	u2fIdentity          u2fIdentity
+	tenants              map[string]*tenant
+	Mutex                sync.RWMutex // Protects Config and the signers.
+	cookieMutex          sync.Mutex   // Protects the pending auth maps.
+	auditChainMutex      sync.Mutex   // Serialises issued_certificate inserts.
+	profileLocks         userLocks
 	gitDB                *gitdb.UserInfo
 	pendingOauth2        map[string]pendingAuth2Request
-	storageRWMutex       sync.RWMutex
 	db                   *sql.DB
 	dbType               string
 	cacheDB              *sql.DB
 	remoteDBQueryTimeout time.Duration
 	htmlTemplate         *htmltemplate.Template
+	messageCatalog       *i18n.Catalog
 	passwordChecker      pwauth.PasswordAuthenticator
 	KeymasterPublicKeys  []crypto.PublicKey
 	isAdminCache         *admincache.Cache
+	sessions             sessionRegistry
 	emailManager         configuredemail.EmailManager
+	alerter              *alerting.Notifier
+	certStream           *certstream.Streamer
+	instanceVerifier     *instanceidentity.Verifier
+	postureChecker       *deviceposture.Checker
+	revocationPublisher  *publisher.Publisher
+	signingPool          *signingpool.Pool
+	requestLimiter       *requestlimiter.Limiter
+	routeStats           routeStatsRecorder
+	faultInjector        *faultinjection.Injector
+	loginCaptcha         *captcha.Verifier
+	backendBreakers      *circuitbreaker.Set
+	dualControl          dualControlState
+	keyEscrowRecipients  openpgp.EntityList
 	textTemplates        *texttemplate.Template
 
 	totpLocalRateLimit      map[string]totpRateLimitInfo
@@ -199,12 +263,17 @@ const maxAgeU2FVerifySeconds = 30
 var (
 	Version        = ""
 	configFilename = flag.String("config", "/etc/keymaster/config.yml",
-		"The filename of the configuration")
+		"The filename, or HTTPS or S3 URL, of the configuration")
+	configSigningKey = flag.String("configSigningKey",
+		"/etc/keymaster/config-signing-key.pem",
+		"The PEM public key which a remote configuration is signed with")
+	remoteConfigSerialsFilename = flag.String("remoteConfigSerialsFile",
+		"/var/lib/keymaster/remote-config-serials.json",
+		"The file in which the highest accepted remote configuration serials are kept")
 	generateConfig = flag.Bool("generateConfig", false,
 		"Generate new valid configuration")
-	u2fAppID         = "https://www.example.com:33443"
-	u2fTrustedFacets = []string{}
-
+	devMode = flag.Bool("dev", false,
+		"Run with a throwaway configuration in a temporary directory (insecure)")
 	metricsMutex   = &sync.Mutex{}
 	certGenCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -303,12 +372,18 @@ func generateCADer(state *RuntimeState, keySigner crypto.Signer) ([]byte, error)
 	if state.KerberosRealm != nil {
 		organizationName = *state.KerberosRealm
 	}
-	return certgen.GenSelfSignedCACert(state.HostIdentity, organizationName, keySigner)
+	algorithm, err := certgen.ParseX509SignatureAlgorithm(
+		state.Config.Base.X509SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	return certgen.GenSelfSignedCACertWithAlgorithm(state.HostIdentity,
+		organizationName, keySigner, algorithm)
 }
 
 func (state *RuntimeState) performStateCleanup(secsBetweenCleanup int) {
 	for {
-		state.Mutex.Lock()
+		state.cookieMutex.Lock()
 		//
 		initPendingSize := len(state.pendingOauth2)
 		for key, oauth2Pending := range state.pendingOauth2 {
@@ -334,7 +409,8 @@ func (state *RuntimeState) performStateCleanup(secsBetweenCleanup int) {
 
 		}
 
-		state.Mutex.Unlock()
+		state.cookieMutex.Unlock()
+		state.sessions.cleanup()
 		logger.Debugf(3, "Pending Cookie sizes: before(%d) after(%d)",
 			initPendingSize, finalPendingSize)
 		logger.Debugf(3, "Pending Cookie sizes: before(%d) after(%d)",
@@ -348,38 +424,6 @@ func convertToBindDN(username string, bind_pattern string) string {
 	return fmt.Sprintf(bind_pattern, username)
 }
 
-func checkUserPassword(username string, password string, config AppConfigFile,
-	passwordChecker pwauth.PasswordAuthenticator,
-	r *http.Request) (bool, error) {
-	clientType := getClientType(r)
-	if passwordChecker != nil {
-		logger.Debugf(3, "checking auth with passwordChecker")
-		isLDAP := false
-		if len(config.Ldap.LDAPTargetURLs) > 0 {
-			isLDAP = true
-		}
-		start := time.Now()
-		valid, err := passwordChecker.PasswordAuthenticate(username,
-			[]byte(password))
-		if err != nil {
-			return false, err
-		}
-		// TODO: Replace these if's by a type switch
-		if isLDAP {
-			metricLogExternalServiceDuration("ldap", time.Since(start))
-		}
-		_, isOktaPwAuth := passwordChecker.(*okta.PasswordAuthenticator)
-		if isOktaPwAuth {
-			metricLogExternalServiceDuration("okta-passwd", time.Since(start))
-		}
-		logger.Debugf(3, "pwdChecker output = %d", valid)
-		metricLogAuthOperation(clientType, "password", valid)
-		return valid, nil
-	}
-	metricLogAuthOperation(clientType, "password", false)
-	return false, nil
-}
-
 // returns application/json or text/html depending on the request. By default we assume the requester wants json
 func getPreferredAcceptType(r *http.Request) string {
 	preferredAcceptType := "application/json"
@@ -414,6 +458,15 @@ func browserSupportsU2F(r *http.Request) bool {
 	return false
 }
 
+// browserSupportsWebAuthn returns true for the browsers which implement the
+// WebAuthn API, including the mobile ones which never supported U2F.
+func browserSupportsWebAuthn(r *http.Request) bool {
+	userAgent := r.UserAgent()
+	return strings.Contains(userAgent, "Safari/") ||
+		strings.Contains(userAgent, "Firefox/") ||
+		strings.Contains(userAgent, "FxiOS/")
+}
+
 func getClientType(r *http.Request) string {
 	if r == nil {
 		return "unknown"
@@ -437,9 +490,11 @@ func (state *RuntimeState) writeHTML2FAAuthPage(w http.ResponseWriter,
 	r *http.Request, loginDestination string, tryShowU2f bool,
 	showBootstrapOTP bool) error {
 	JSSources := []string{"/static/jquery-3.5.1.min.js", "/static/u2f-api.js"}
-	showU2F := browserSupportsU2F(r) && tryShowU2f
+	showU2F := (browserSupportsU2F(r) || browserSupportsWebAuthn(r)) &&
+		tryShowU2f
 	if showU2F {
-		JSSources = append(JSSources, "/static/webui-2fa-u2f.js")
+		JSSources = append(JSSources, "/static/keymaster-webauthn.js",
+			"/static/webui-2fa-u2f.js")
 	}
 	if state.Config.SymantecVIP.Enabled {
 		JSSources = append(JSSources, "/static/webui-2fa-symc-vip.js")
@@ -448,7 +503,7 @@ func (state *RuntimeState) writeHTML2FAAuthPage(w http.ResponseWriter,
 		JSSources = append(JSSources, "/static/webui-2fa-okta-push.js")
 	}
 	displayData := secondFactorAuthTemplateData{
-		Title:            "Keymaster 2FA Auth",
+		Title:            state.pageTitle("2FA Auth"),
 		JSSources:        JSSources,
 		ShowBootstrapOTP: showBootstrapOTP,
 		ShowVIP:          state.Config.SymantecVIP.Enabled,
@@ -470,15 +525,24 @@ func (state *RuntimeState) writeHTMLLoginPage(w http.ResponseWriter,
 	r *http.Request, statusCode int, loginDestination string,
 	errorMessage string) {
 	if state.passwordChecker == nil && state.Config.Oauth2.Enabled {
-		http.Redirect(w, r, "/auth/oauth2/login", http.StatusTemporaryRedirect)
+		http.Redirect(w, r, oauth2LoginURL(loginDestination),
+			http.StatusTemporaryRedirect)
 		return
 	}
-	w.WriteHeader(statusCode)
+	language := state.getLanguage(w, r)
 	displayData := loginPageTemplateData{
-		Title:            "Keymaster Login",
+		Title:            state.pageTitle(state.translate(language, "Login")),
+		Language:         language,
 		ShowOauth2:       state.Config.Oauth2.Enabled,
 		LoginDestination: loginDestination,
 		ErrorMessage:     errorMessage}
+	if state.Config.U2F.PasskeyLogin && browserSupportsWebAuthn(r) {
+		displayData.ShowPasskeyLogin = true
+		displayData.JSSources = append(displayData.JSSources,
+			"/static/keymaster-webauthn.js", "/static/keymaster-passkey.js")
+	}
+	state.setLoginCaptchaData(w, r, &displayData)
+	w.WriteHeader(statusCode)
 	err := state.htmlTemplate.ExecuteTemplate(w, "loginPage", displayData)
 	if err != nil {
 		logger.Printf("Failed to execute %v", err)
@@ -517,29 +581,20 @@ func (state *RuntimeState) writeFailureResponse(w http.ResponseWriter,
 				}
 				authCookie = cookie
 			}
-			loginDestnation := profilePath
-			if r.URL.Path == idpOpenIDCAuthorizationPath {
-				loginDestnation = r.URL.String()
-			}
-			if r.Method == "POST" {
-				/// assume it has been parsed... otherwise why are we here?
-				if r.Form.Get("login_destination") != "" {
-					loginDestnation = getLoginDestination(r)
-				}
-			}
+			loginDestnation := getRequestLoginDestination(r)
 			if authCookie == nil {
-				// TODO: change by a message followed by an HTTP redirection
-				state.writeHTMLLoginPage(w, r, code, loginDestnation, message)
+				state.redirectToLogin(w, r, loginDestnation, message)
 				return
 			}
 			info, err := state.getAuthInfoFromAuthJWT(authCookie.Value)
 			if err != nil {
 				logger.Debugf(3, "write failure state, error from getinfo authInfoJWT")
-				state.writeHTMLLoginPage(w, r, code, loginDestnation, "")
+				state.redirectToLogin(w, r, loginDestnation, "")
 				return
 			}
 			if info.ExpiresAt.Before(time.Now()) {
-				state.writeHTMLLoginPage(w, r, code, loginDestnation, "")
+				state.redirectToLogin(w, r, loginDestnation,
+					"Your session has expired, please log in again")
 				return
 			}
 			if (info.AuthType & AuthTypePassword) == AuthTypePassword {
@@ -550,13 +605,17 @@ func (state *RuntimeState) writeFailureResponse(w http.ResponseWriter,
 				state.writeHTML2FAAuthPage(w, r, loginDestnation, true, false)
 				return
 			}
-			state.writeHTMLLoginPage(w, r, code, loginDestnation, message)
+			state.redirectToLogin(w, r, loginDestnation, message)
 			return
 		default:
 			w.WriteHeader(code)
 			w.Write([]byte(publicErrorText))
 		}
 	default:
+		if returnAcceptType == "text/html" {
+			state.writeHTMLErrorPage(w, r, code, message)
+			return
+		}
 		w.WriteHeader(code)
 		w.Write([]byte(publicErrorText))
 	}
@@ -574,9 +633,9 @@ func setSecurityHeaders(w http.ResponseWriter) {
 func (state *RuntimeState) sendFailureToClientIfLocked(w http.ResponseWriter, r *http.Request) bool {
 	var signerIsNull bool
 
-	state.Mutex.Lock()
+	state.Mutex.RLock()
 	signerIsNull = (state.Signer == nil)
-	state.Mutex.Unlock()
+	state.Mutex.RUnlock()
 
 	setSecurityHeaders(w)
 
@@ -589,18 +648,23 @@ func (state *RuntimeState) sendFailureToClientIfLocked(w http.ResponseWriter, r
 }
 
 func (state *RuntimeState) setNewAuthCookie(w http.ResponseWriter, username string, authlevel int) (string, error) {
+	if state.isHoneytoken(username) {
+		state.tripHoneytoken(nil, username, honeytokenStageSession)
+		return "", errHoneytoken
+	}
 	cookieVal, err := state.genNewSerializedAuthJWT(username, authlevel)
 	if err != nil {
 		logger.Println(err)
 		return "", err
 	}
 	expiration := time.Now().Add(time.Duration(maxAgeSecondsAuthCookie) * time.Second)
-	authCookie := http.Cookie{Name: authCookieName, Value: cookieVal, Expires: expiration, Path: "/", HttpOnly: true, Secure: true, SameSite: http.SameSiteNoneMode}
+	authCookie := state.newAuthCookie(cookieVal, expiration)
 
 	//use handler with original request.
 	if w != nil {
-		http.SetCookie(w, &authCookie)
+		http.SetCookie(w, authCookie)
 	}
+	go state.recordUserSeen(username)
 	return cookieVal, nil
 }
 
@@ -623,9 +687,9 @@ func (state *RuntimeState) updateAuthCookieAuthlevel(w http.ResponseWriter, r *h
 		return "", err
 	}
 
-	updatedAuthCookie := http.Cookie{Name: authCookieName, Value: cookieVal, Expires: authCookie.Expires, Path: "/", HttpOnly: true, Secure: true, SameSite: http.SameSiteNoneMode}
+	updatedAuthCookie := state.newAuthCookie(cookieVal, authCookie.Expires)
 	logger.Debugf(3, "about to update authCookie")
-	http.SetCookie(w, &updatedAuthCookie)
+	http.SetCookie(w, updatedAuthCookie)
 	return authCookie.Value, nil
 }
 func (state *RuntimeState) isAutomationUser(username string) (bool, error) {
@@ -711,17 +775,17 @@ func (state *RuntimeState) getUsernameIfIPRestricted(VerifiedChains [][]*x509.Ce
 // Inspired by http://stackoverflow.com/questions/21936332/idiomatic-way-of-requiring-http-basic-auth-in-go
 func (state *RuntimeState) checkAuth(w http.ResponseWriter, r *http.Request, requiredAuthType int) (*authInfo, error) {
 	// Check csrf
-	if r.Method != "GET" {
-		referer := r.Referer()
-		if len(referer) > 0 && len(r.Host) > 0 {
-			state.logger.Debugf(3, "ref =%s, host=%s", referer, r.Host)
-			refererURL, err := url.Parse(referer)
+	if r.Method != "GET" && len(r.Host) > 0 {
+		for _, pageURL := range []string{r.Referer(), r.Header.Get("Origin")} {
+			if pageURL == "" {
+				continue
+			}
+			state.logger.Debugf(3, "ref =%s, host=%s", pageURL, r.Host)
+			allowed, err := state.isRequestFromAllowedOrigin(r, pageURL)
 			if err != nil {
 				return nil, err
 			}
-			state.logger.Debugf(3, "refHost =%s, host=%s",
-				refererURL.Host, r.Host)
-			if refererURL.Host != r.Host {
+			if !allowed {
 				state.logger.Printf("CSRF detected.... rejecting with a 400")
 				state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 				return nil, errors.New("CSRF detected... rejecting")
@@ -766,6 +830,14 @@ func (state *RuntimeState) checkAuth(w http.ResponseWriter, r *http.Request, req
 			}
 		}
 	}
+	// Next we check for API bearer tokens
+	if token, ok := getBearerToken(r); ok {
+		if strings.HasPrefix(token, automationTokenPrefix) {
+			return state.checkAutomationTokenAuth(w, r, token,
+				requiredAuthType)
+		}
+		return state.checkAPITokenAuth(w, r, token, requiredAuthType)
+	}
 	// Next we check for cookies
 	var authCookie *http.Cookie
 	for _, cookie := range r.Cookies() {
@@ -788,12 +860,13 @@ func (state *RuntimeState) checkAuth(w http.ResponseWriter, r *http.Request, req
 			err := errors.New("check_Auth, Invalid or no auth header")
 			return nil, err
 		}
-		state.Mutex.Lock()
-		config := state.Config
-		state.Mutex.Unlock()
 		user = state.reprocessUsername(user)
-		valid, err := checkUserPassword(user, pass, config,
-			state.passwordChecker, r)
+		release, ok := state.acquireRequestSlot(w, r, user, "password")
+		if !ok {
+			return nil, errors.New("too many concurrent requests")
+		}
+		valid, err := state.checkUserPassword(user, pass, r)
+		release()
 		if err != nil {
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 			return nil, err
@@ -804,6 +877,9 @@ func (state *RuntimeState) checkAuth(w http.ResponseWriter, r *http.Request, req
 			err := errors.New("Invalid Credentials")
 			return nil, err
 		}
+		if !state.checkAccountStatus(w, r, user) {
+			return nil, errAccountNotActive
+		}
 		return &authInfo{
 			AuthType: AuthTypePassword,
 			IssuedAt: time.Now(),
@@ -824,6 +900,9 @@ func (state *RuntimeState) checkAuth(w http.ResponseWriter, r *http.Request, req
 		err := errors.New("Expired Cookie")
 		return nil, err
 	}
+	if isEnrollmentOnlyAuth(info.AuthType, requiredAuthType) {
+		return state.checkEnrollmentAuth(w, r, &info)
+	}
 	if (info.AuthType & requiredAuthType) == 0 {
 		state.logger.Debugf(1, "info.AuthType: %v, requiredAuthType: %v\n",
 			info.AuthType, requiredAuthType)
@@ -862,10 +941,21 @@ func (state *RuntimeState) getRequiredWebUIAuthLevel() int {
 	return AuthLevel
 }
 
+// getWebUILoginAuthLevel returns the auth levels which are enough to log in
+// to the web UI and view its pages. Unlike getRequiredWebUIAuthLevel, they
+// include a password login from a trusted device, which must not be
+// accepted for registering factors, changing passwords or admin actions.
+func (state *RuntimeState) getWebUILoginAuthLevel() int {
+	authLevel := state.getRequiredWebUIAuthLevel()
+	if state.Config.TrustedDevices.Enabled &&
+		(authLevel&secondFactorAuthTypes) != 0 {
+		authLevel |= AuthTypeTrustedDevice
+	}
+	return authLevel
+}
+
 func (state *RuntimeState) reprocessUsername(username string) string {
-	if !state.Config.Base.DisableUsernameNormalization {
-		username = strings.ToLower(username)
-	}
+	username = state.normalizeUsername(username)
 	if state.oktaUsernameFilterRE != nil {
 		filteredUsername := string(state.oktaUsernameFilterRE.ReplaceAll(
 			[]byte(username), nil))
@@ -873,7 +963,7 @@ func (state *RuntimeState) reprocessUsername(username string) string {
 			username, filteredUsername)
 		username = filteredUsername
 	}
-	return username
+	return state.resolveUsernameAlias(username)
 }
 
 const secretInjectorPath = "/admin/inject"
@@ -887,9 +977,9 @@ func (state *RuntimeState) publicPathHandler(w http.ResponseWriter, r *http.Requ
 	var signerIsNull bool
 
 	// check if initialized(singer  not nil)
-	state.Mutex.Lock()
+	state.Mutex.RLock()
 	signerIsNull = (state.Signer == nil)
-	state.Mutex.Unlock()
+	state.Mutex.RUnlock()
 	if signerIsNull {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		logger.Printf("Signer not loaded")
@@ -900,9 +990,7 @@ func (state *RuntimeState) publicPathHandler(w http.ResponseWriter, r *http.Requ
 
 	switch target {
 	case "loginForm":
-		//fmt.Fprintf(w, "%s", loginFormText)
-		setSecurityHeaders(w)
-		state.writeHTMLLoginPage(w, r, 200, profilePath, "")
+		state.loginFormHandler(w, r)
 		return
 	case "x509ca":
 		pemCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: state.caCertDer}))
@@ -910,6 +998,22 @@ func (state *RuntimeState) publicPathHandler(w http.ResponseWriter, r *http.Requ
 		w.Header().Set("Content-Disposition", `attachment; filename="id_rsa-cert.pub"`)
 		w.WriteHeader(200)
 		fmt.Fprintf(w, "%s", pemCert)
+	case "x509CrossSigned":
+		state.writeCrossSignedCACerts(w, r)
+	case "sshca":
+		state.writeSSHCAPublicKeys(w, r)
+	case "sshRevokedKeys":
+		state.writeSSHRevokedKeys(w, r)
+	case "caMetadata":
+		state.writeCAMetadata(w, r, false)
+	case "caMetadata.jws":
+		state.writeCAMetadata(w, r, true)
+	case "clientTrust":
+		state.writeClientTrustBundle(w, r)
+	case "clientUpdate":
+		state.writeClientUpdateManifest(w, r, false)
+	case "clientUpdate.jws":
+		state.writeClientUpdateManifest(w, r, true)
 	default:
 		state.writeFailureResponse(w, r, http.StatusNotFound, "")
 		return
@@ -957,8 +1061,7 @@ func getLoginDestination(r *http.Request) string {
 	loginDestination := profilePath
 	if r.Form.Get("login_destination") != "" {
 		inboundLoginDestination := r.Form.Get("login_destination")
-		if strings.HasPrefix(inboundLoginDestination, "/") &&
-			!strings.HasPrefix(inboundLoginDestination, "//") {
+		if isSafeLoginDestination(inboundLoginDestination) {
 			loginDestination = inboundLoginDestination
 		}
 	}
@@ -1026,19 +1129,30 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter,
 			return
 		}
 	}
+	if !state.checkLoginCaptcha(w, r) {
+		return
+	}
 	username = state.reprocessUsername(username)
-	valid, err := checkUserPassword(username, password, state.Config,
-		state.passwordChecker, r)
+	release, ok := state.acquireRequestSlot(w, r, username, "password")
+	if !ok {
+		return
+	}
+	valid, err := state.checkUserPassword(username, password, r)
+	release()
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		return
 	}
+	state.recordLoginResult(r, valid)
 	if !valid {
 		state.writeFailureResponse(w, r, http.StatusUnauthorized,
 			"Invalid Username/Password")
 		logger.Printf("Invalid login for %s", username)
 		return
 	}
+	if !state.checkAccountStatus(w, r, username) {
+		return
+	}
 	// AUTHN has passed
 	logger.Debugf(1, "Valid passwd AUTH login for %s\n", username)
 	userHasU2FTokens, err := state.userHasU2FTokens(username)
@@ -1048,7 +1162,11 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter,
 		logger.Println(err)
 		return
 	}
-	_, err = state.setNewAuthCookie(w, username, AuthTypePassword)
+	authLevel := AuthTypePassword
+	if state.isTrustedDevice(r, username) {
+		authLevel |= AuthTypeTrustedDevice
+	}
+	_, err = state.setNewAuthCookie(w, username, authLevel)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError,
 			"error internal")
@@ -1066,8 +1184,10 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter,
 			}
 		}
 	}
+	unlockProfile := state.lockUserProfile(username)
 	profile, _, fromCache, err := state.LoadUserProfile(username)
 	if err != nil {
+		unlockProfile()
 		state.logger.Printf("error loading user profile err=%s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError,
 			"cannot load user profile")
@@ -1076,6 +1196,7 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter,
 	if !fromCache {
 		state.trySelfServiceGenerateBootstrapOTP(username, profile)
 	}
+	unlockProfile()
 	userHasBootstrapOTP := len(state.userBootstrapOtpHash(profile,
 		fromCache)) > 0
 	// Compute the cert prefs
@@ -1109,7 +1230,7 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter,
 	case "text/html":
 		loginDestination := getLoginDestination(r)
 		requiredAuth := state.getRequiredWebUIAuthLevel()
-		if (requiredAuth & AuthTypePassword) != 0 {
+		if (state.getWebUILoginAuthLevel() & authLevel) != 0 {
 			eventNotifier.PublishWebLoginEvent(username)
 			http.Redirect(w, r, loginDestination, 302)
 		} else {
@@ -1126,7 +1247,10 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter,
 					http.SetCookie(w, &vipPushCookie)
 				}
 			}
-			state.writeHTML2FAAuthPage(w, r, loginDestination, userHasU2FTokens,
+			// Browsers can also use WebAuthn credentials, which the CLI
+			// cannot.
+			state.writeHTML2FAAuthPage(w, r, loginDestination,
+				userHasU2FTokens || hasWebAuthnCredentials(profile),
 				userHasBootstrapOTP)
 		}
 	default:
@@ -1177,9 +1301,19 @@ func (state *RuntimeState) logoutHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	if authCookie != nil {
+		// The cookie may have been copied, so revoke it as well.
+		info, err := state.getAuthInfoFromAuthJWT(authCookie.Value)
+		if err == nil && info.SessionID != "" {
+			state.sessions.addRevocations(
+				map[string]time.Time{info.SessionID: info.ExpiresAt}, nil)
+			err := state.recordSessionRevocation(info.Username,
+				info.SessionID, info.Username)
+			if err != nil {
+				logger.Printf("error recording session revocation: %s", err)
+			}
+		}
 		expiration := time.Unix(0, 0)
-		updatedAuthCookie := http.Cookie{Name: authCookieName, Value: "", Expires: expiration, Path: "/", HttpOnly: true, Secure: true, SameSite: http.SameSiteNoneMode}
-		http.SetCookie(w, &updatedAuthCookie)
+		http.SetCookie(w, state.newAuthCookie("", expiration))
 	}
 	//redirect to login
 	http.Redirect(w, r, "/", 302)
@@ -1265,7 +1399,7 @@ func (state *RuntimeState) profileHandler(w http.ResponseWriter, r *http.Request
 	/*
 	 */
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
-	authData, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	authData, err := state.checkAuth(w, r, state.getWebUILoginAuthLevel())
 	if err != nil {
 		logger.Debugf(1, "%v", err)
 		return
@@ -1295,16 +1429,26 @@ func (state *RuntimeState) profileHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	JSSources := []string{"/static/jquery-3.5.1.min.js"}
-	showU2F := browserSupportsU2F(r)
+	showU2F := browserSupportsU2F(r) || browserSupportsWebAuthn(r)
+	showPasskey := state.Config.U2F.PasskeyLogin && browserSupportsWebAuthn(r)
 	if showU2F {
-		JSSources = append(JSSources, "/static/u2f-api.js", "/static/keymaster-u2f.js")
+		JSSources = append(JSSources, "/static/u2f-api.js",
+			"/static/keymaster-webauthn.js", "/static/keymaster-u2f.js")
 	}
 
 	// TODO: move deviceinfo mapping/sorting to its own function
 	var u2fdevices []registeredU2FTokenDisplayInfo
 	for i, tokenInfo := range profile.U2fAuthData {
+		deviceDescription := "WebAuthn credential"
+		if tokenInfo.WebAuthn != nil && tokenInfo.WebAuthn.Discoverable {
+			deviceDescription = "Passkey"
+		}
+		if tokenInfo.Registration != nil &&
+			tokenInfo.Registration.AttestationCert != nil {
+			deviceDescription = fmt.Sprintf("%+v", tokenInfo.Registration.AttestationCert.Subject.CommonName)
+		}
 		deviceData := registeredU2FTokenDisplayInfo{
-			DeviceData: fmt.Sprintf("%+v", tokenInfo.Registration.AttestationCert.Subject.CommonName),
+			DeviceData: deviceDescription,
 			Enabled:    tokenInfo.Enabled,
 			Name:       tokenInfo.Name,
 			Index:      i}
@@ -1329,18 +1473,29 @@ func (state *RuntimeState) profileHandler(w http.ResponseWriter, r *http.Request
 		totpdevices = append(totpdevices, deviceData)
 	}
 	showTOTP := state.Config.Base.EnableLocalTOTP
+	_, passwordChangeEnabled := state.getPasswordChanger()
+	recentCerts, err := state.getIssuedCertificates(assumedUser,
+		numIssuedCertsInProfile)
+	if err != nil {
+		logger.Printf("error getting issued certificates: %s", err)
+	}
 
+	language := state.getLanguage(w, r)
 	displayData := profilePageTemplateData{
 		Username:             assumedUser,
 		AuthUsername:         authData.Username,
-		Title:                "Keymaster User Profile",
+		Language:             language,
+		Title:                state.pageTitle(state.translate(language, "User Profile")),
 		ShowU2F:              showU2F,
+		ShowPasskey:          showPasskey,
 		JSSources:            JSSources,
 		ReadOnlyMsg:          readOnlyMsg,
 		UsersLink:            state.IsAdminUser(authData.Username),
+		PasswordLink:         passwordChangeEnabled,
 		RegisteredU2FToken:   u2fdevices,
 		ShowTOTP:             showTOTP,
 		RegisteredTOTPDevice: totpdevices,
+		RecentCertificates:   recentCerts,
 	}
 	if time.Until(profile.BootstrapOTP.ExpiresAt) > 0 &&
 		len(profile.BootstrapOTP.Sha512Hash) >= 4 {
@@ -1410,6 +1565,7 @@ func (state *RuntimeState) u2fTokenManagerHandler(w http.ResponseWriter, r *http
 	}
 
 	//Do a redirect
+	defer state.lockUserProfile(assumedUser)()
 	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
 	if err != nil {
 		logger.Printf("loading profile error: %v", err)
@@ -1461,6 +1617,9 @@ func (state *RuntimeState) u2fTokenManagerHandler(w http.ResponseWriter, r *http
 		return
 	}
 
+	state.notifyAdminImpersonation(authData.Username, assumedUser,
+		actionName+" U2F token")
+
 	// Success!
 	returnAcceptType := getPreferredAcceptType(r)
 	switch returnAcceptType {
@@ -1481,7 +1640,7 @@ const clientConfigText = `base:
 func (state *RuntimeState) serveClientConfHandler(w http.ResponseWriter, r *http.Request) {
 	//w.WriteHeader(200)
 	w.Header().Set("Content-Type", "text/yaml")
-	fmt.Fprintf(w, clientConfigText, u2fAppID)
+	fmt.Fprintf(w, clientConfigText, state.getU2FIdentity().publicURL())
 }
 
 func (state *RuntimeState) defaultPathHandler(w http.ResponseWriter, r *http.Request) {
@@ -1495,7 +1654,7 @@ func (state *RuntimeState) defaultPathHandler(w http.ResponseWriter, r *http.Req
 	if r.URL.Path[:] == "/" {
 		//landing page
 		if r.Method == "GET" && len(r.Cookies()) < 1 {
-			state.writeHTMLLoginPage(w, r, 200, profilePath, "")
+			http.Redirect(w, r, loginFormPath, http.StatusFound)
 			return
 		}
 
@@ -1524,6 +1683,10 @@ func Usage() {
 	}
 	fmt.Fprintf(os.Stderr, "Usage of %s (version %s):\n", os.Args[0], displayVersion)
 	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nSubcommands:\n")
+	fmt.Fprintf(os.Stderr, "  genca [flags]: generate an encrypted CA key\n")
+	fmt.Fprintf(os.Stderr,
+		"  signconfig [flags] file: sign a file of a remote configuration\n")
 }
 
 func init() {
@@ -1548,6 +1711,148 @@ func init() {
 		"Time for external Storage server to perform operation(ms)")
 }
 
+// newServiceTLSConfig returns the TLS configuration of the user facing
+// service.
+func (state *RuntimeState) newServiceTLSConfig() *tls.Config {
+	return state.applyFIPSTLSConfig(&tls.Config{
+		ClientCAs:                state.ClientCAPool,
+		ClientAuth:               tls.VerifyClientCertIfGiven,
+		GetCertificate:           state.certReloader.GetCertificate,
+		MinVersion:               tls.VersionTLS12,
+		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
+		PreferServerCipherSuites: true,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_AES_128_GCM_SHA256,
+			tls.TLS_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		},
+	})
+}
+
+// newServiceMux returns the handlers of the user facing service.
+func (state *RuntimeState) newServiceMux() *http.ServeMux {
+	serviceMux := http.NewServeMux()
+	serviceMux.HandleFunc(certgenPath, state.certGenHandler)
+	serviceMux.HandleFunc(hostCertgenPath, state.hostCertgenHandler)
+	serviceMux.HandleFunc(hostCertStatusPath,
+		state.hostCertStatusHandler)
+	serviceMux.HandleFunc(certRenewPath, state.certRenewHandler)
+	serviceMux.HandleFunc(proto.APITokenPath, state.apiTokenHandler)
+	serviceMux.HandleFunc(proto.ServiceTokenPath,
+		state.serviceTokenHandler)
+	serviceMux.HandleFunc(serviceTokenJWKSPath,
+		state.idpOpenIDCJWKSHandler)
+	serviceMux.HandleFunc(publicPath, state.publicPathHandler)
+	serviceMux.HandleFunc(proto.LoginPath, state.loginHandler)
+	serviceMux.HandleFunc(logoutPath, state.logoutHandler)
+	serviceMux.HandleFunc(profilePath, state.profileHandler)
+	serviceMux.HandleFunc(usersPath, state.usersHandler)
+	serviceMux.HandleFunc(addUserPath, state.addUserHandler)
+	serviceMux.HandleFunc(deleteUserPath, state.deleteUserHandler)
+	//TODO: should enable only if bootraptop is enabled
+	serviceMux.HandleFunc(generateBoostrapOTPPath,
+		state.generateBootstrapOTP)
+	serviceMux.HandleFunc(adminUserPath, state.adminUserHandler)
+	serviceMux.HandleFunc(resetDevicesPath, state.resetDevicesHandler)
+	serviceMux.HandleFunc(revokeSessionsPath,
+		state.revokeSessionsHandler)
+	serviceMux.HandleFunc(revokeCertificatePath,
+		state.revokeCertificateHandler)
+	serviceMux.HandleFunc(automationTokensPath,
+		state.automationTokensHandler)
+	serviceMux.HandleFunc(revokeAutomationTokenPath,
+		state.revokeAutomationTokenHandler)
+	serviceMux.HandleFunc(issuanceQuotasPath,
+		state.issuanceQuotasHandler)
+	serviceMux.HandleFunc(profileGCPath, state.profileGCHandler)
+	serviceMux.HandleFunc(deleteUserDataPath, state.deleteUserDataHandler)
+	serviceMux.HandleFunc(escrowedKeysPath, state.escrowedKeysHandler)
+	serviceMux.HandleFunc(hostCertificatesPath,
+		state.hostCertificatesHandler)
+
+	serviceMux.HandleFunc(idpOpenIDCConfigurationDocumentPath,
+		state.idpOpenIDCDiscoveryHandler)
+	serviceMux.HandleFunc(idpOpenIDCJWKSPath,
+		state.idpOpenIDCJWKSHandler)
+	serviceMux.HandleFunc(idpOpenIDCAuthorizationPath,
+		state.idpOpenIDCAuthorizationHandler)
+	serviceMux.HandleFunc(idpOpenIDCTokenPath,
+		state.idpOpenIDCTokenHandler)
+	serviceMux.HandleFunc(idpOpenIDCUserinfoPath,
+		state.idpOpenIDCUserinfoHandler)
+
+	staticFilesPath :=
+		filepath.Join(state.Config.Base.SharedDataDirectory,
+			"static_files")
+	serviceMux.Handle("/static/", http.StripPrefix("/static/",
+		http.FileServer(http.Dir(staticFilesPath))))
+	customWebResourcesPath :=
+		filepath.Join(state.Config.Base.SharedDataDirectory,
+			"customization_data", "web_resources")
+	if _, err := os.Stat(customWebResourcesPath); err == nil {
+		serviceMux.Handle("/custom_static/", http.StripPrefix("/custom_static/",
+			http.FileServer(http.Dir(customWebResourcesPath))))
+	}
+	serviceMux.HandleFunc(u2fRegustisterRequestPath,
+		state.u2fRegisterRequest)
+	serviceMux.HandleFunc(u2fRegisterRequesponsePath,
+		state.u2fRegisterResponse)
+	serviceMux.HandleFunc(u2fSignRequestPath, state.u2fSignRequest)
+	serviceMux.HandleFunc(u2fSignResponsePath, state.u2fSignResponse)
+	serviceMux.HandleFunc(webauthnRegisterRequestPath,
+		state.webauthnRegisterRequest)
+	serviceMux.HandleFunc(webauthnRegisterResponsePath,
+		state.webauthnRegisterResponse)
+	serviceMux.HandleFunc(webauthnSignRequestPath,
+		state.webauthnSignRequest)
+	serviceMux.HandleFunc(webauthnSignResponsePath,
+		state.webauthnSignResponse)
+	serviceMux.HandleFunc(webauthnPasskeyLoginRequestPath,
+		state.webauthnPasskeyLoginRequest)
+	serviceMux.HandleFunc(webauthnPasskeyLoginResponsePath,
+		state.webauthnPasskeyLoginResponse)
+	serviceMux.HandleFunc(vipAuthPath, state.VIPAuthHandler)
+	serviceMux.HandleFunc(u2fTokenManagementPath,
+		state.u2fTokenManagerHandler)
+	serviceMux.HandleFunc(devicesPath, state.devicesHandler)
+	serviceMux.HandleFunc(devicesAPIPath, state.devicesAPIHandler)
+	serviceMux.HandleFunc(passwordPath, state.passwordHandler)
+	serviceMux.HandleFunc(passwordAPIPath, state.passwordAPIHandler)
+	serviceMux.HandleFunc(issuedCertsPath, state.issuedCertsHandler)
+	serviceMux.HandleFunc(oauth2LoginBeginPath,
+		state.oauth2DoRedirectoToProviderHandler)
+	serviceMux.HandleFunc(redirectPath, state.oauth2RedirectPathHandler)
+	serviceMux.HandleFunc(clientConfHandlerPath,
+		state.serveClientConfHandler)
+	serviceMux.HandleFunc(vipPushStartPath, state.vipPushStartHandler)
+	serviceMux.HandleFunc(vipPollCheckPath, state.VIPPollCheckHandler)
+	serviceMux.HandleFunc(totpGeneratNewPath, state.GenerateNewTOTP)
+	serviceMux.HandleFunc(totpValidateNewPath, state.validateNewTOTP)
+	serviceMux.HandleFunc(totpTokenManagementPath,
+		state.totpTokenManagerHandler)
+	serviceMux.HandleFunc(totpVerifyHandlerPath, state.verifyTOTPHandler)
+	serviceMux.HandleFunc(totpAuthPath, state.TOTPAuthHandler)
+	if state.Config.Okta.Domain != "" {
+		serviceMux.HandleFunc(okta2FAauthPath, state.Okta2FAuthHandler)
+		serviceMux.HandleFunc(oktaPushStartPath,
+			state.oktaPushStartHandler)
+		serviceMux.HandleFunc(oktaPollCheckPath,
+			state.oktaPollCheckHandler)
+	}
+	// TODO(rgooch): Condition this on whether Bootstrap OTP is configured.
+	//               The inline calls to getRequiredWebUIAuthLevel() should be
+	//               moved to the config section and replaced with a simple
+	//               bitfield test.
+	serviceMux.HandleFunc(bootstrapOtpAuthPath,
+		state.BootstrapOtpAuthHandler)
+	serviceMux.HandleFunc("/", state.defaultPathHandler)
+	return serviceMux
+}
+
 func main() {
 	flag.Usage = Usage
 	flag.Parse()
@@ -1556,6 +1861,20 @@ func main() {
 	realLogger := serverlogger.New("")
 	logger = realLogger
 
+	if flag.Arg(0) == "genca" {
+		if err := genCA(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "signconfig" {
+		if err := signConfig(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	if *generateConfig {
 		err := generateNewConfig(*configFilename)
 		if err != nil {
@@ -1564,14 +1883,33 @@ func main() {
 		return
 	}
 
+	if *devMode {
+		devConfigFilename, err := setupDevMode()
+		if err != nil {
+			panic(err)
+		}
+		configFilename = &devConfigFilename
+	}
 	// TODO(rgooch): Pass this in rather than use a global variable.
 	eventNotifier = eventnotifier.New(logger)
-	runtimeState, err := loadVerifyConfigFile(*configFilename, logger)
+	var runtimeState *RuntimeState
+	var err error
+	if isRemoteConfig(*configFilename) {
+		runtimeState, err = loadVerifyRemoteConfig(*configFilename,
+			*configSigningKey, *remoteConfigSerialsFilename, logger)
+	} else {
+		runtimeState, err = loadVerifyConfigFile(*configFilename, logger)
+	}
 	if err != nil {
 		logger.Println(err)
 		os.Exit(1)
 	}
 	logger.Debugf(3, "After load verify")
+	if err := runtimeState.loadTenants(logger); err != nil {
+		logger.Println(err)
+		os.Exit(1)
+	}
+	runtimeState.startTenants()
 
 	publicLogs := runtimeState.Config.Base.PublicLogs
 	adminDashboard := newAdminDashboard(realLogger, publicLogs)
@@ -1594,87 +1932,23 @@ func main() {
 
 	// Expose the registered metrics via HTTP.
 	http.Handle("/", adminDashboard)
-	http.Handle("/prometheus_metrics", promhttp.Handler()) //lint:ignore SA1019 TODO: newer prometheus handler
+	http.Handle(prometheusMetricsPath, promhttp.Handler()) //lint:ignore SA1019 TODO: newer prometheus handler
 	http.HandleFunc(secretInjectorPath, runtimeState.secretInjectorHandler)
+	http.HandleFunc(crossSignCAPath, runtimeState.crossSignCAHandler)
+	http.HandleFunc(tenantSecretInjectorPath,
+		runtimeState.tenantSecretInjectorHandler)
 	http.HandleFunc(readyzPath, runtimeState.readyzHandler)
+	http.HandleFunc(tenantReadyzPath, runtimeState.tenantReadyzHandler)
+	http.HandleFunc(runtimeStatsPath, runtimeState.runtimeStatsHandler)
+	http.HandleFunc(statusPath, runtimeState.statusHandler)
+	http.HandleFunc(auditChainPath, runtimeState.auditChainHandler)
 
-	serviceMux := http.NewServeMux()
-	serviceMux.HandleFunc(certgenPath, runtimeState.certGenHandler)
-	serviceMux.HandleFunc(publicPath, runtimeState.publicPathHandler)
-	serviceMux.HandleFunc(proto.LoginPath, runtimeState.loginHandler)
-	serviceMux.HandleFunc(logoutPath, runtimeState.logoutHandler)
-	serviceMux.HandleFunc(profilePath, runtimeState.profileHandler)
-	serviceMux.HandleFunc(usersPath, runtimeState.usersHandler)
-	serviceMux.HandleFunc(addUserPath, runtimeState.addUserHandler)
-	serviceMux.HandleFunc(deleteUserPath, runtimeState.deleteUserHandler)
-	//TODO: should enable only if bootraptop is enabled
-	serviceMux.HandleFunc(generateBoostrapOTPPath,
-		runtimeState.generateBootstrapOTP)
-
-	serviceMux.HandleFunc(idpOpenIDCConfigurationDocumentPath,
-		runtimeState.idpOpenIDCDiscoveryHandler)
-	serviceMux.HandleFunc(idpOpenIDCJWKSPath,
-		runtimeState.idpOpenIDCJWKSHandler)
-	serviceMux.HandleFunc(idpOpenIDCAuthorizationPath,
-		runtimeState.idpOpenIDCAuthorizationHandler)
-	serviceMux.HandleFunc(idpOpenIDCTokenPath,
-		runtimeState.idpOpenIDCTokenHandler)
-	serviceMux.HandleFunc(idpOpenIDCUserinfoPath,
-		runtimeState.idpOpenIDCUserinfoHandler)
-
-	staticFilesPath :=
-		filepath.Join(runtimeState.Config.Base.SharedDataDirectory,
-			"static_files")
-	serviceMux.Handle("/static/", http.StripPrefix("/static/",
-		http.FileServer(http.Dir(staticFilesPath))))
-	customWebResourcesPath :=
-		filepath.Join(runtimeState.Config.Base.SharedDataDirectory,
-			"customization_data", "web_resources")
-	if _, err = os.Stat(customWebResourcesPath); err == nil {
-		serviceMux.Handle("/custom_static/", http.StripPrefix("/custom_static/",
-			http.FileServer(http.Dir(customWebResourcesPath))))
-	}
-	serviceMux.HandleFunc(u2fRegustisterRequestPath,
-		runtimeState.u2fRegisterRequest)
-	serviceMux.HandleFunc(u2fRegisterRequesponsePath,
-		runtimeState.u2fRegisterResponse)
-	serviceMux.HandleFunc(u2fSignRequestPath, runtimeState.u2fSignRequest)
-	serviceMux.HandleFunc(u2fSignResponsePath, runtimeState.u2fSignResponse)
-	serviceMux.HandleFunc(vipAuthPath, runtimeState.VIPAuthHandler)
-	serviceMux.HandleFunc(u2fTokenManagementPath,
-		runtimeState.u2fTokenManagerHandler)
-	serviceMux.HandleFunc(oauth2LoginBeginPath,
-		runtimeState.oauth2DoRedirectoToProviderHandler)
-	serviceMux.HandleFunc(redirectPath, runtimeState.oauth2RedirectPathHandler)
-	serviceMux.HandleFunc(clientConfHandlerPath,
-		runtimeState.serveClientConfHandler)
-	serviceMux.HandleFunc(vipPushStartPath, runtimeState.vipPushStartHandler)
-	serviceMux.HandleFunc(vipPollCheckPath, runtimeState.VIPPollCheckHandler)
-	serviceMux.HandleFunc(totpGeneratNewPath, runtimeState.GenerateNewTOTP)
-	serviceMux.HandleFunc(totpValidateNewPath, runtimeState.validateNewTOTP)
-	serviceMux.HandleFunc(totpTokenManagementPath,
-		runtimeState.totpTokenManagerHandler)
-	serviceMux.HandleFunc(totpVerifyHandlerPath, runtimeState.verifyTOTPHandler)
-	serviceMux.HandleFunc(totpAuthPath, runtimeState.TOTPAuthHandler)
-	if runtimeState.Config.Okta.Domain != "" {
-		serviceMux.HandleFunc(okta2FAauthPath, runtimeState.Okta2FAuthHandler)
-		serviceMux.HandleFunc(oktaPushStartPath,
-			runtimeState.oktaPushStartHandler)
-		serviceMux.HandleFunc(oktaPollCheckPath,
-			runtimeState.oktaPollCheckHandler)
-	}
-	// TODO(rgooch): Condition this on whether Bootstrap OTP is configured.
-	//               The inline calls to getRequiredWebUIAuthLevel() should be
-	//               moved to the config section and replaced with a simple
-	//               bitfield test.
-	serviceMux.HandleFunc(bootstrapOtpAuthPath,
-		runtimeState.BootstrapOtpAuthHandler)
-	serviceMux.HandleFunc("/", runtimeState.defaultPathHandler)
+	serviceMux := runtimeState.newServiceMux()
 
-	cfg := &tls.Config{
+	cfg := runtimeState.applyFIPSTLSConfig(&tls.Config{
 		ClientCAs:                runtimeState.ClientCAPool,
 		ClientAuth:               tls.VerifyClientCertIfGiven,
-		GetCertificate:           runtimeState.certManager.GetCertificate,
+		GetCertificate:           runtimeState.certReloader.GetCertificate,
 		MinVersion:               tls.VersionTLS12,
 		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 		PreferServerCipherSuites: true,
@@ -1687,41 +1961,63 @@ func main() {
 			tls.TLS_AES_256_GCM_SHA384,
 			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
 		},
-	}
-	logFilterHandler := NewLogFilterHandler(http.DefaultServeMux, publicLogs,
-		runtimeState)
+	})
+	logFilterHandler := runtimeState.newMiddlewareHandler(
+		NewLogFilterHandler(http.DefaultServeMux, publicLogs, runtimeState))
 	serviceHTTPLogger := httpLogger{AccessLogger: serviceAccessLogger}
 	adminHTTPLogger := httpLogger{AccessLogger: adminAccessLogger}
-	adminSrv := &http.Server{
-		Addr:         runtimeState.Config.Base.AdminAddress,
-		TLSConfig:    cfg,
-		Handler:      instrumentedwriter.NewLoggingHandler(logFilterHandler, adminHTTPLogger),
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
-	}
+	adminHandler := runtimeState.newForwardedForHandler(
+		runtimeState.newRouteMetricsHandler(handlerSetAdmin,
+			http.DefaultServeMux,
+			instrumentedwriter.NewLoggingHandler(logFilterHandler,
+				adminHTTPLogger)))
 	srpc.RegisterServerTlsConfig(
 		&tls.Config{ClientCAs: runtimeState.ClientCAPool},
 		true)
-	go func() {
-		err := adminSrv.ListenAndServeTLS("", "")
-		if err != nil {
-			panic(err)
-		}
+	if runtimeState.Config.Base.AdminAddress != "" {
+		adminSrv := runtimeState.newHTTPServer(
+			runtimeState.Config.Base.AdminAddress, adminHandler)
+		adminSrv.TLSConfig = cfg
+		go func() {
+			err := runtimeState.listenAndServe(adminSrv, true)
+			if err != nil {
+				panic(err)
+			}
 
-	}()
+		}()
+	}
+	if err := runtimeState.startAdminSocket(adminHandler); err != nil {
+		logger.Fatalln(err)
+	}
+	if err := runtimeState.startMetricsListener(); err != nil {
+		logger.Fatalln(err)
+	}
+	runtimeState.startRouteStatsLogger()
+	err = runtimeState.startListeners(map[string]http.Handler{
+		handlerSetAdmin:  adminHandler,
+		handlerSetHealth: runtimeState.newHealthHandler(),
+	}, cfg)
+	if err != nil {
+		logger.Fatalln(err)
+	}
 	if runtimeState.Config.Watchdog.CheckInterval > 0 {
 		_, err := watchdog.New(runtimeState.Config.Watchdog, logger)
 		if err != nil {
 			logger.Fatalln(err)
 		}
 	}
+	if err := runtimeState.startKubernetesSigner(); err != nil {
+		logger.Fatalln(err)
+	}
 	isReady := <-runtimeState.SignerIsReady
 	if isReady != true {
 		panic("got bad signer ready data")
 	}
+	if err := runtimeState.startRevocationPublisher(); err != nil {
+		logger.Fatalln(err)
+	}
 
-	if len(runtimeState.Config.Ldap.LDAPTargetURLs) > 0 && !runtimeState.Config.Ldap.DisablePasswordCache {
+	if runtimeState.passwordCheckerUsesStorage() {
 		err = runtimeState.passwordChecker.UpdateStorage(runtimeState)
 		if err != nil {
 			logger.Fatalf("Cannot update password checker")
@@ -1730,48 +2026,37 @@ func main() {
 	if runtimeState.ClientCAPool == nil {
 		runtimeState.ClientCAPool = x509.NewCertPool()
 	}
-	myCert, err := x509.ParseCertificate(runtimeState.caCertDer)
-	if err != nil {
-		panic(err)
-	}
-	runtimeState.ClientCAPool.AddCert(myCert)
+	runtimeState.ClientCAPool.AddCert(runtimeState.caCert)
 	// Safari in MacOS 10.12.x required a cert to be presented by the user even
 	// when optional.
 	// Our usage shows this is less than 1% of users so we are now mandating
 	// verification on issues we will need to update clientAuth back  to tls.RequestClientCert
-	serviceTLSConfig := &tls.Config{
-		ClientCAs:                runtimeState.ClientCAPool,
-		ClientAuth:               tls.VerifyClientCertIfGiven,
-		GetCertificate:           runtimeState.certManager.GetCertificate,
-		MinVersion:               tls.VersionTLS12,
-		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-		PreferServerCipherSuites: true,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_AES_128_GCM_SHA256,
-			tls.TLS_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-		},
-	}
-	serviceSrv := &http.Server{
-		Addr:         runtimeState.Config.Base.HttpAddress,
-		Handler:      instrumentedwriter.NewLoggingHandler(serviceMux, serviceHTTPLogger),
-		TLSConfig:    serviceTLSConfig,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
-	}
+	serviceTLSConfig := runtimeState.newServiceTLSConfig()
+	serviceTLSConfig.GetConfigForClient = runtimeState.getTenantTLSConfig
+	serviceHandler := runtimeState.newForwardedForHandler(
+		runtimeState.newRouteMetricsHandler(handlerSetService, serviceMux,
+			instrumentedwriter.NewLoggingHandler(
+				runtimeState.newMiddlewareHandler(
+					runtimeState.newCORSHandler(
+						runtimeState.newTenantHandler(serviceMux))),
+				serviceHTTPLogger)))
+	serviceSrv := runtimeState.newHTTPServer(runtimeState.Config.Base.HttpAddress,
+		serviceHandler)
+	serviceSrv.TLSConfig = serviceTLSConfig
 
 	http.Handle(eventmon.HttpPath, eventNotifier)
+	err = runtimeState.startListeners(map[string]http.Handler{
+		handlerSetService: serviceHandler,
+	}, serviceTLSConfig)
+	if err != nil {
+		logger.Fatalln(err)
+	}
 	go func() {
 		time.Sleep(time.Millisecond * 10)
 		healthserver.SetReady()
 		adminDashboard.setReady()
 	}()
-	err = serviceSrv.ListenAndServeTLS("", "")
+	err = runtimeState.listenAndServe(serviceSrv, true)
 	if err != nil {
 		panic(err)
 	}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -34,3 +38,111 @@ func TestOktaUsernameFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestGetRequestLoginDestination(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		form   string
+		output string
+	}{
+		{"GET", "/devices/?a=b", "", "/devices/?a=b"},
+		{"HEAD", "/devices/", "", "/devices/"},
+		{"POST", "/api/v0/login", "login_destination=%2Fdevices%2F", "/devices/"},
+		{"POST", "/api/v0/login", "login_destination=%2F%2Fevil.com", profilePath},
+		{"POST", "/api/v0/login", "login_destination=%2F%5Cevil.com", profilePath},
+		{"POST", "/api/v0/login", "login_destination=%2F%09%2Fevil.com", profilePath},
+		{"POST", "/api/v0/login", "login_destination=%2F%0D%0A%2Fevil.com", profilePath},
+		{"POST", "/api/v0/login", "login_destination=%2F%0A%2Fevil.com", profilePath},
+		{"POST", "/api/v0/login", "login_destination=%2Fdevices%2F%5C", profilePath},
+		{"POST", "/api/v0/login", "login_destination=%2Fdevices%7F", profilePath},
+		{"POST", "/api/v0/login", "login_destination=https%3A%2F%2Fevil.com", profilePath},
+		// Escaped characters are not stripped by browsers.
+		{"GET", "/devices/%09/evil.com", "", "/devices/%09/evil.com"},
+		{"POST", "/api/v0/login", "", profilePath},
+		{"PUT", "/devices/", "", profilePath},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.target,
+			strings.NewReader(test.form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if err := req.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		output := getRequestLoginDestination(req)
+		if output != test.output {
+			t.Errorf("%s %s (%s): expected %s, got %s", test.method,
+				test.target, test.form, test.output, output)
+		}
+	}
+}
+
+func TestUnauthenticatedBrowserRedirect(t *testing.T) {
+	state := &RuntimeState{}
+	req := httptest.NewRequest("GET", "/devices/?a=b", nil)
+	req.Header.Set("Accept", "text/html")
+	recorder := httptest.NewRecorder()
+	state.writeFailureResponse(recorder, req, http.StatusUnauthorized,
+		"Invalid Username/Password")
+	resp := recorder.Result()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected redirect, got %d", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Path != loginFormPath {
+		t.Fatalf("redirected to %s", location.Path)
+	}
+	destination := location.Query().Get("login_destination")
+	if destination != "/devices/?a=b" {
+		t.Fatalf("lost login destination, got %s", destination)
+	}
+	// The flash message must survive the redirect exactly once.
+	req = httptest.NewRequest("GET", location.String(), nil)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	recorder = httptest.NewRecorder()
+	if message := popFlashMessage(recorder, req); message !=
+		"Invalid Username/Password" {
+		t.Fatalf("bad flash message: %s", message)
+	}
+	cleared := recorder.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].Name != flashCookieName ||
+		cleared[0].Value != "" {
+		t.Fatalf("flash cookie not cleared: %v", cleared)
+	}
+}
+
+func TestLoginPageLanguage(t *testing.T) {
+	state := &RuntimeState{}
+	if err := state.loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	state.messageCatalog.AddMessages("fr", map[string]string{
+		"Username:": "Identifiant :",
+		"Login":     "Connexion",
+	})
+	req := httptest.NewRequest("GET", loginFormPath, nil)
+	req.Header.Set("Accept-Language", "fr-CH, fr;q=0.9, en;q=0.8")
+	recorder := httptest.NewRecorder()
+	state.loginFormHandler(recorder, req)
+	resp := recorder.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	if language := resp.Header.Get("Content-Language"); language != "fr" {
+		t.Fatalf("expected fr, got %s", language)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, "Identifiant :") ||
+		!strings.Contains(body, "Keymaster Connexion") {
+		t.Fatalf("login page not translated: %s", body)
+	}
+	// Untranslated messages fall back to English.
+	if !strings.Contains(body, "Password:") {
+		t.Fatalf("missing untranslated message: %s", body)
+	}
+}
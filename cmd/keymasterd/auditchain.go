@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	auditChainPath = "/auditChain"
+
+	defaultAuditAnchorInterval = time.Hour
+)
+
+// auditChainConfig configures how often the head of the audit chain is
+// signed with the CA key. Default: 1h.
+type auditChainConfig struct {
+	AnchorInterval time.Duration `yaml:"anchor_interval"`
+}
+
+// auditChainContent is the part of an issuedCertRecord covered by its chain
+// hash.
+type auditChainContent struct {
+	Username        string `json:"username"`
+	CertType        string `json:"cert_type"`
+	Serial          string `json:"serial"`
+	KeyID           string `json:"key_id"`
+	Fingerprint     string `json:"fingerprint"`
+	IssuedEpoch     int64  `json:"issued_epoch"`
+	ExpirationEpoch int64  `json:"expiration_epoch"`
+	SourceAddr      string `json:"source_address"`
+	AuditID         string `json:"audit_id"`
+	AuthMethod      string `json:"auth_method"`
+	UserAgent       string `json:"user_agent"`
+	ClientVersion   string `json:"client_version"`
+	Certificate     string `json:"certificate,omitempty"`
+}
+
+type auditAnchor struct {
+	AnchoredAt time.Time
+	RecordID   int64
+	ChainHash  string
+	Signature  []byte
+}
+
+// auditChainStatus is the result of verifying the audit chain.
+type auditChainStatus struct {
+	Records         int    `json:"records"`
+	Tombstoned      int    `json:"tombstoned,omitempty"`
+	Anchors         int    `json:"anchors"`
+	VerifiedAnchors int    `json:"verified_anchors"`
+	Error           string `json:"error,omitempty"`
+}
+
+var getAuditChainHeadStmt = map[string]string{
+	"sqlite":   "select id, chain_hash from issued_certificate order by id desc limit 1",
+	"postgres": "select id, chain_hash from issued_certificate order by id desc limit 1",
+}
+
+var getAuditChainStmt = map[string]string{
+	"sqlite":   "select id, username, cert_type, serial, key_id, fingerprint, issued_epoch, expiration_epoch, source_address, audit_id, auth_method, user_agent, client_version, certificate, chain_hash, tombstone from issued_certificate order by id",
+	"postgres": "select id, username, cert_type, serial, key_id, fingerprint, issued_epoch, expiration_epoch, source_address, audit_id, auth_method, user_agent, client_version, certificate, chain_hash, tombstone from issued_certificate order by id",
+}
+
+var insertAuditAnchorStmt = map[string]string{
+	"sqlite":   "insert into audit_anchor(anchored_epoch, record_id, chain_hash, signature) values(?, ?, ?, ?)",
+	"postgres": "insert into audit_anchor(anchored_epoch, record_id, chain_hash, signature) values($1, $2, $3, $4)",
+}
+
+var getAuditAnchorsStmt = map[string]string{
+	"sqlite":   "select anchored_epoch, record_id, chain_hash, signature from audit_anchor order by record_id",
+	"postgres": "select anchored_epoch, record_id, chain_hash, signature from audit_anchor order by record_id",
+}
+
+func (content auditChainContent) chainHash(previousHash string) string {
+	data, _ := json.Marshal(content)
+	hasher := sha256.New()
+	hasher.Write([]byte(previousHash))
+	hasher.Write([]byte{'\n'})
+	hasher.Write(data)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func newAuditChainContent(record issuedCertRecord) auditChainContent {
+	return auditChainContent{
+		Username:        record.Username,
+		CertType:        record.CertType,
+		Serial:          record.Serial,
+		KeyID:           record.KeyID,
+		Fingerprint:     record.Fingerprint,
+		IssuedEpoch:     record.IssuedAt.Unix(),
+		ExpirationEpoch: record.ExpiresAt.Unix(),
+		SourceAddr:      record.SourceAddr,
+		AuditID:         record.AuditID,
+		AuthMethod:      record.AuthMethod,
+		UserAgent:       record.UserAgent,
+		ClientVersion:   record.ClientVersion,
+		Certificate:     record.Certificate,
+	}
+}
+
+// insertChainedIssuedCert inserts record with the chain hash following the
+// last record. Other keymasterd instances sharing a PostgreSQL database are
+// excluded with a table lock, so that the chain does not fork.
+func (state *RuntimeState) insertChainedIssuedCert(
+	record issuedCertRecord) error {
+	state.auditChainMutex.Lock()
+	defer state.auditChainMutex.Unlock()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if state.dbType == "postgres" {
+		_, err := tx.Exec(
+			"lock table issued_certificate in share row exclusive mode")
+		if err != nil {
+			return err
+		}
+	}
+	var previousID int64
+	var previousHash string
+	err = tx.QueryRow(getAuditChainHeadStmt[state.dbType]).Scan(&previousID,
+		&previousHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	_, err = tx.Exec(insertIssuedCertStmt[state.dbType],
+		record.Username, record.CertType, record.Serial, record.KeyID,
+		record.Fingerprint, record.IssuedAt.Unix(), record.ExpiresAt.Unix(),
+		record.SourceAddr, record.AuditID, record.AuthMethod,
+		record.UserAgent, record.ClientVersion, record.Certificate,
+		newAuditChainContent(record).chainHash(previousHash))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func auditAnchorDigest(anchoredEpoch, recordID int64, chainHash string) []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf(
+		"keymaster audit anchor\n%d\n%d\n%s\n", anchoredEpoch, recordID,
+		chainHash)))
+	return digest[:]
+}
+
+// signDigest signs a SHA-256 digest with the CA key.
+func signDigest(signer crypto.Signer, digest []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, digest, crypto.Hash(0))
+	}
+	return signer.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+func verifyDigestSignature(pub crypto.PublicKey, digest,
+	signature []byte) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, signature) {
+			return errors.New("bad signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest, signature) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type: %T", pub)
+}
+
+func verifyAuditAnchor(pub crypto.PublicKey, anchor auditAnchor) error {
+	return verifyDigestSignature(pub, auditAnchorDigest(
+		anchor.AnchoredAt.Unix(), anchor.RecordID, anchor.ChainHash),
+		anchor.Signature)
+}
+
+// anchorAuditChain signs the head of the audit chain with the CA key, unless
+// it has not changed since lastRecordID. It returns the anchored record ID.
+func (state *RuntimeState) anchorAuditChain(lastRecordID int64) (
+	int64, error) {
+	state.Mutex.RLock()
+	signer := state.Signer
+	state.Mutex.RUnlock()
+	if signer == nil {
+		return lastRecordID, nil
+	}
+	var recordID int64
+	var chainHash string
+	err := state.db.QueryRow(getAuditChainHeadStmt[state.dbType]).Scan(
+		&recordID, &chainHash)
+	if err == sql.ErrNoRows || (err == nil && recordID == lastRecordID) {
+		return lastRecordID, nil
+	}
+	if err != nil {
+		return lastRecordID, err
+	}
+	anchoredEpoch := time.Now().Unix()
+	signature, err := signDigest(signer,
+		auditAnchorDigest(anchoredEpoch, recordID, chainHash))
+	if err != nil {
+		return lastRecordID, err
+	}
+	_, err = state.db.Exec(insertAuditAnchorStmt[state.dbType], anchoredEpoch,
+		recordID, chainHash, base64.StdEncoding.EncodeToString(signature))
+	if err != nil {
+		return lastRecordID, err
+	}
+	return recordID, nil
+}
+
+func (state *RuntimeState) auditAnchorLoop() {
+	interval := state.Config.AuditChain.AnchorInterval
+	if interval <= 0 {
+		interval = defaultAuditAnchorInterval
+	}
+	var lastRecordID int64
+	for {
+		time.Sleep(interval)
+		var err error
+		lastRecordID, err = state.anchorAuditChain(lastRecordID)
+		if err != nil {
+			logger.Printf("error anchoring audit chain: %s", err)
+		}
+	}
+}
+
+func (state *RuntimeState) getAuditAnchors() ([]auditAnchor, error) {
+	rows, err := state.db.Query(getAuditAnchorsStmt[state.dbType])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var anchors []auditAnchor
+	for rows.Next() {
+		var anchor auditAnchor
+		var anchoredEpoch int64
+		var signature string
+		err := rows.Scan(&anchoredEpoch, &anchor.RecordID, &anchor.ChainHash,
+			&signature)
+		if err != nil {
+			return nil, err
+		}
+		anchor.AnchoredAt = time.Unix(anchoredEpoch, 0)
+		anchor.Signature, err = base64.StdEncoding.DecodeString(signature)
+		if err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, anchor)
+	}
+	return anchors, rows.Err()
+}
+
+// verifyAuditChain recomputes the chain hashes of the issued certificate
+// records and checks the anchors against them, setting Error in the status
+// on the first mismatch. The oldest record is trusted as the start of the
+// chain, since older records are removed once expired. Records created
+// before the chain was introduced are skipped. The content of tombstoned
+// records was erased, so their stored hash is trusted.
+func (state *RuntimeState) verifyAuditChain() (auditChainStatus, error) {
+	var status auditChainStatus
+	rows, err := state.db.Query(getAuditChainStmt[state.dbType])
+	if err != nil {
+		return status, err
+	}
+	defer rows.Close()
+	chainHashes := make(map[int64]string)
+	var firstID, lastID int64
+	var previousHash string
+	for rows.Next() {
+		var id int64
+		var content auditChainContent
+		var chainHash, tombstone string
+		err := rows.Scan(&id, &content.Username, &content.CertType,
+			&content.Serial, &content.KeyID, &content.Fingerprint,
+			&content.IssuedEpoch, &content.ExpirationEpoch,
+			&content.SourceAddr, &content.AuditID, &content.AuthMethod,
+			&content.UserAgent, &content.ClientVersion, &content.Certificate,
+			&chainHash, &tombstone)
+		if err != nil {
+			return status, err
+		}
+		if chainHash == "" && status.Records < 1 {
+			continue
+		}
+		if tombstone != "" {
+			status.Tombstoned++
+		} else if status.Records > 0 &&
+			content.chainHash(previousHash) != chainHash {
+			status.Error = fmt.Sprintf("chain broken at record: %d", id)
+			return status, nil
+		}
+		if status.Records < 1 {
+			firstID = id
+		}
+		status.Records++
+		chainHashes[id] = chainHash
+		lastID = id
+		previousHash = chainHash
+	}
+	if err := rows.Err(); err != nil {
+		return status, err
+	}
+	anchors, err := state.getAuditAnchors()
+	if err != nil {
+		return status, err
+	}
+	status.Anchors = len(anchors)
+	state.Mutex.RLock()
+	signer := state.Signer
+	state.Mutex.RUnlock()
+	for _, anchor := range anchors {
+		if status.Records > 0 && anchor.RecordID < firstID {
+			continue // Removed by retention.
+		}
+		if status.Records < 1 || anchor.RecordID > lastID {
+			status.Error = fmt.Sprintf("chain truncated before record: %d",
+				anchor.RecordID)
+			return status, nil
+		}
+		if chainHashes[anchor.RecordID] != anchor.ChainHash {
+			status.Error = fmt.Sprintf("anchor mismatch at record: %d",
+				anchor.RecordID)
+			return status, nil
+		}
+		if signer != nil {
+			if err := verifyAuditAnchor(signer.Public(), anchor); err != nil {
+				status.Error = fmt.Sprintf("anchor at record: %d: %s",
+					anchor.RecordID, err)
+				return status, nil
+			}
+		}
+		status.VerifiedAnchors++
+	}
+	return status, nil
+}
+
+// auditChainHandler reports whether the audit chain verifies.
+func (state *RuntimeState) auditChainHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	status, err := state.verifyAuditChain()
+	if err != nil {
+		logger.Printf("error verifying audit chain: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if status.Error != "" {
+		w.WriteHeader(http.StatusConflict)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
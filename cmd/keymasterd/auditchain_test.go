@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testRecordChainedCertificates(state *RuntimeState, count int) {
+	now := time.Now()
+	for i := 0; i < count; i++ {
+		state.recordIssuedCertificate(issuedCertRecord{
+			Username:    "username",
+			CertType:    "ssh",
+			Serial:      strconv.Itoa(i),
+			Fingerprint: "SHA256:test",
+			IssuedAt:    now,
+			ExpiresAt:   now.Add(time.Hour),
+			SourceAddr:  "127.0.0.1:1234",
+		})
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	testRecordChainedCertificates(state, 3)
+	lastRecordID, err := state.anchorAuditChain(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anchoredID, err := state.anchorAuditChain(lastRecordID); err != nil {
+		t.Fatal(err)
+	} else if anchoredID != lastRecordID {
+		t.Fatal("unchanged chain anchored again")
+	}
+	testRecordChainedCertificates(state, 2)
+	status, err := state.verifyAuditChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Error != "" || status.Records != 5 ||
+		status.VerifiedAnchors != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	req, err := http.NewRequest("GET", auditChainPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.auditChainHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Altering a record breaks the chain.
+	_, err = state.db.Exec(
+		"update issued_certificate set username = 'other' where serial = '1'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, err := state.verifyAuditChain(); err != nil {
+		t.Fatal(err)
+	} else if status.Error == "" {
+		t.Fatal("altered record not detected")
+	}
+	_, err = checkRequestHandlerCode(req, state.auditChainHandler,
+		http.StatusConflict)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditChainTruncation(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	testRecordChainedCertificates(state, 3)
+	lastRecordID, err := state.anchorAuditChain(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec("delete from issued_certificate where id = ?",
+		lastRecordID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, err := state.verifyAuditChain(); err != nil {
+		t.Fatal(err)
+	} else if status.Error == "" {
+		t.Fatal("truncated chain not detected")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -16,6 +17,14 @@ const redirCookieName = "oauth2_redir"
 
 const oauth2LoginBeginPath = "/auth/oauth2/login"
 
+func oauth2LoginURL(loginDestination string) string {
+	if loginDestination == profilePath {
+		return oauth2LoginBeginPath
+	}
+	return oauth2LoginBeginPath + "?" + url.Values{
+		"login_destination": {loginDestination}}.Encode()
+}
+
 func (state *RuntimeState) oauth2DoRedirectoToProviderHandler(w http.ResponseWriter, r *http.Request) {
 
 	if state.Config.Oauth2.Config == nil {
@@ -28,6 +37,10 @@ func (state *RuntimeState) oauth2DoRedirectoToProviderHandler(w http.ResponseWri
 		logger.Println("asking for oauth2, but it is not enabled")
 		return
 	}
+	if err := r.ParseForm(); err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
+		return
+	}
 	cookieVal, err := genRandomString()
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
@@ -50,12 +63,13 @@ func (state *RuntimeState) oauth2DoRedirectoToProviderHandler(w http.ResponseWri
 	http.SetCookie(w, &cookie)
 
 	pending := pendingAuth2Request{
-		ExpiresAt: expiration,
-		state:     stateString,
-		ctx:       context.Background()}
-	state.Mutex.Lock()
+		ExpiresAt:        expiration,
+		state:            stateString,
+		ctx:              context.Background(),
+		loginDestination: getLoginDestination(r)}
+	state.cookieMutex.Lock()
 	state.pendingOauth2[cookieVal] = pending
-	state.Mutex.Unlock()
+	state.cookieMutex.Unlock()
 	http.Redirect(w, r, state.Config.Oauth2.Config.AuthCodeURL(stateString), http.StatusFound)
 }
 
@@ -107,9 +121,9 @@ func (state *RuntimeState) oauth2RedirectPathHandler(w http.ResponseWriter, r *h
 		return
 	}
 	index := redirCookie.Value
-	state.Mutex.Lock()
+	state.cookieMutex.Lock()
 	pending, ok := state.pendingOauth2[index]
-	state.Mutex.Unlock()
+	state.cookieMutex.Unlock()
 	if !ok {
 		// clear cookie here!!!!
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid setup cookie!")
@@ -173,6 +187,10 @@ func (state *RuntimeState) oauth2RedirectPathHandler(w http.ResponseWriter, r *h
 		}
 		username = strings.ToLower(components[0])
 	}
+	username = state.reprocessUsername(username)
+	if !state.checkHoneytoken(w, r, username, honeytokenStageSession) {
+		return
+	}
 
 	//Make new auth cookie
 	_, err = state.setNewAuthCookie(w, username, AuthTypeFederated)
@@ -183,11 +201,15 @@ func (state *RuntimeState) oauth2RedirectPathHandler(w http.ResponseWriter, r *h
 	}
 
 	// delete peding cookie
-	state.Mutex.Lock()
+	state.cookieMutex.Lock()
 	delete(state.pendingOauth2, index)
-	state.Mutex.Unlock()
+	state.cookieMutex.Unlock()
 
 	eventNotifier.PublishWebLoginEvent(username)
-	//and redirect to profile page
-	http.Redirect(w, r, profilePath, 302)
+	//and redirect to where the user was going
+	loginDestination := pending.loginDestination
+	if loginDestination == "" {
+		loginDestination = profilePath
+	}
+	http.Redirect(w, r, loginDestination, 302)
 }
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	automationTokensPath           = "/admin/automationTokens"
+	revokeAutomationTokenPath      = "/admin/revokeAutomationToken"
+	automationTokenPrefix          = "kmat_"
+	defaultAutomationTokenLifetime = 90 * 24 * time.Hour
+	maxAutomationTokenLifetime     = 366 * 24 * time.Hour
+	defaultAutomationCertLifetime  = time.Hour
+)
+
+var automationTokenCertTypes = map[string]struct{}{
+	"ssh":             {},
+	"x509":            {},
+	"x509-kubernetes": {},
+}
+
+// automationToken is a long-lived credential which lets a CI system get
+// certificates for a single principal from certgenPath, with restricted
+// certificate types and lifetimes, from restricted source addresses.
+type automationToken struct {
+	ID              string    `json:"id"`
+	Principal       string    `json:"principal"`
+	CertTypes       []string  `json:"cert_types"`
+	MaxCertLifetime int64     `json:"max_cert_lifetime_seconds"`
+	SourceCIDRs     []string  `json:"source_cidrs"`
+	Description     string    `json:"description,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	CreatedBy       string    `json:"created_by"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	RevokedBy       string    `json:"revoked_by,omitempty"`
+	hash            string
+}
+
+// newAutomationTokenResponse is returned when a token is created. This is the
+// only time the token value is available.
+type newAutomationTokenResponse struct {
+	automationToken
+	Token string `json:"token"`
+}
+
+var insertAutomationTokenStmt = map[string]string{
+	"sqlite":   "insert into automation_token(token_id, token_hash, principal, cert_types, max_lifetime_secs, source_cidrs, description, created_epoch, created_by, expiration_epoch, revoked_epoch, revoked_by) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, '')",
+	"postgres": "insert into automation_token(token_id, token_hash, principal, cert_types, max_lifetime_secs, source_cidrs, description, created_epoch, created_by, expiration_epoch, revoked_epoch, revoked_by) values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 0, '')",
+}
+
+var getAutomationTokenStmt = map[string]string{
+	"sqlite":   "select token_id, token_hash, principal, cert_types, max_lifetime_secs, source_cidrs, description, created_epoch, created_by, expiration_epoch, revoked_by from automation_token where token_id = ?",
+	"postgres": "select token_id, token_hash, principal, cert_types, max_lifetime_secs, source_cidrs, description, created_epoch, created_by, expiration_epoch, revoked_by from automation_token where token_id = $1",
+}
+
+var listAutomationTokensStmt = map[string]string{
+	"sqlite":   "select token_id, token_hash, principal, cert_types, max_lifetime_secs, source_cidrs, description, created_epoch, created_by, expiration_epoch, revoked_by from automation_token order by principal, created_epoch",
+	"postgres": "select token_id, token_hash, principal, cert_types, max_lifetime_secs, source_cidrs, description, created_epoch, created_by, expiration_epoch, revoked_by from automation_token order by principal, created_epoch",
+}
+
+var revokeAutomationTokenStmt = map[string]string{
+	"sqlite":   "update automation_token set revoked_epoch = ?, revoked_by = ? where token_id = ? and revoked_epoch = 0",
+	"postgres": "update automation_token set revoked_epoch = $1, revoked_by = $2 where token_id = $3 and revoked_epoch = 0",
+}
+
+func hashAutomationTokenSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// splitAutomationToken returns the ID and secret of token, which has the
+// form: automationTokenPrefix<ID>.<secret>.
+func splitAutomationToken(token string) (string, string, bool) {
+	if !strings.HasPrefix(token, automationTokenPrefix) {
+		return "", "", false
+	}
+	pieces := strings.SplitN(token[len(automationTokenPrefix):], ".", 2)
+	if len(pieces) != 2 || pieces[0] == "" || pieces[1] == "" {
+		return "", "", false
+	}
+	return pieces[0], pieces[1], true
+}
+
+func scanAutomationToken(scanner interface {
+	Scan(dest ...interface{}) error
+}) (*automationToken, error) {
+	var token automationToken
+	var certTypes, sourceCIDRs string
+	var createdEpoch, expirationEpoch int64
+	err := scanner.Scan(&token.ID, &token.hash, &token.Principal, &certTypes,
+		&token.MaxCertLifetime, &sourceCIDRs, &token.Description,
+		&createdEpoch, &token.CreatedBy, &expirationEpoch, &token.RevokedBy)
+	if err != nil {
+		return nil, err
+	}
+	token.CertTypes = strings.Split(certTypes, ",")
+	token.SourceCIDRs = strings.Split(sourceCIDRs, ",")
+	token.CreatedAt = time.Unix(createdEpoch, 0)
+	token.ExpiresAt = time.Unix(expirationEpoch, 0)
+	return &token, nil
+}
+
+func (state *RuntimeState) getAutomationToken(id string) (
+	*automationToken, error) {
+	return scanAutomationToken(
+		state.db.QueryRow(getAutomationTokenStmt[state.dbType], id))
+}
+
+func (state *RuntimeState) listAutomationTokens() ([]automationToken, error) {
+	rows, err := state.db.Query(listAutomationTokensStmt[state.dbType])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := make([]automationToken, 0)
+	for rows.Next() {
+		token, err := scanAutomationToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// createAutomationToken stores the token and returns its value.
+func (state *RuntimeState) createAutomationToken(token *automationToken) (
+	string, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	secret, err := genRandomString()
+	if err != nil {
+		return "", err
+	}
+	token.ID = hex.EncodeToString(idBytes)
+	token.hash = hashAutomationTokenSecret(secret)
+	_, err = state.db.Exec(insertAutomationTokenStmt[state.dbType],
+		token.ID, token.hash, token.Principal,
+		strings.Join(token.CertTypes, ","), token.MaxCertLifetime,
+		strings.Join(token.SourceCIDRs, ","), token.Description,
+		token.CreatedAt.Unix(), token.CreatedBy, token.ExpiresAt.Unix())
+	if err != nil {
+		return "", err
+	}
+	return automationTokenPrefix + token.ID + "." + secret, nil
+}
+
+// revokeAutomationToken returns false if there is no such unrevoked token.
+func (state *RuntimeState) revokeAutomationToken(id, revokedBy string) (
+	bool, error) {
+	result, err := state.db.Exec(revokeAutomationTokenStmt[state.dbType],
+		time.Now().Unix(), revokedBy, id)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// cleanupAutomationTokens forgets tokens which have expired.
+func cleanupAutomationTokens(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(
+		"DELETE from automation_token WHERE expiration_epoch < %d",
+		time.Now().Unix()))
+	return err
+}
+
+func addressInCIDRs(remoteAddr string, cidrs []string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAutomationTokenAuth is the automation token part of checkAuth. The
+// token may only be used for certgenPath from one of its source networks.
+func (state *RuntimeState) checkAutomationTokenAuth(w http.ResponseWriter,
+	r *http.Request, value string, requiredAuthType int) (*authInfo, error) {
+	if (requiredAuthType&AuthTypeAutomationToken) == 0 ||
+		!strings.HasPrefix(r.URL.Path, certgenPath) {
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Automation tokens may only be used to get certificates")
+		return nil, errors.New("automation token used for: " + r.URL.Path)
+	}
+	id, secret, _ := splitAutomationToken(value)
+	token, err := state.getAutomationToken(id)
+	if err != nil && err != sql.ErrNoRows {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return nil, err
+	}
+	if err == sql.ErrNoRows || subtle.ConstantTimeCompare(
+		[]byte(hashAutomationTokenSecret(secret)), []byte(token.hash)) != 1 {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Invalid token")
+		return nil, errors.New("invalid automation token")
+	}
+	if token.RevokedBy != "" || time.Now().After(token.ExpiresAt) {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Token expired or revoked")
+		return nil, fmt.Errorf("expired or revoked automation token: %s",
+			token.ID)
+	}
+	if !addressInCIDRs(r.RemoteAddr, token.SourceCIDRs) {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Source address not allowed for token")
+		return nil, fmt.Errorf("automation token: %s used from: %s",
+			token.ID, r.RemoteAddr)
+	}
+	state.logger.Printf("automation token: %s used for: %s from: %s",
+		token.ID, token.Principal, r.RemoteAddr)
+	return &authInfo{
+		AuthType:        AuthTypeAutomationToken,
+		ExpiresAt:       token.ExpiresAt,
+		IssuedAt:        time.Now(),
+		Username:        token.Principal,
+		AutomationToken: token,
+	}, nil
+}
+
+// parseAutomationTokenForm returns the token described by the form, or an
+// error for the client.
+func parseAutomationTokenForm(r *http.Request) (*automationToken, error) {
+	token := &automationToken{
+		Principal:   r.Form.Get("principal"),
+		Description: r.Form.Get("description"),
+		CreatedAt:   time.Now(),
+	}
+	if !validUsernameRE.MatchString(token.Principal) {
+		return nil, errors.New("Invalid principal")
+	}
+	for _, certType := range r.Form["cert_type"] {
+		if _, ok := automationTokenCertTypes[certType]; !ok {
+			return nil, fmt.Errorf("Invalid cert_type: %s", certType)
+		}
+		token.CertTypes = append(token.CertTypes, certType)
+	}
+	if len(token.CertTypes) < 1 {
+		return nil, errors.New("At least one cert_type required")
+	}
+	for _, cidr := range r.Form["source_cidr"] {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("Invalid source_cidr: %s", cidr)
+		}
+		token.SourceCIDRs = append(token.SourceCIDRs, cidr)
+	}
+	if len(token.SourceCIDRs) < 1 {
+		return nil, errors.New("At least one source_cidr required")
+	}
+	maxCertLifetime := defaultAutomationCertLifetime
+	if value := r.Form.Get("max_cert_lifetime"); value != "" {
+		var err error
+		maxCertLifetime, err = time.ParseDuration(value)
+		if err != nil || maxCertLifetime < time.Minute ||
+			maxCertLifetime > maxCertificateLifetime {
+			return nil, errors.New("Invalid max_cert_lifetime")
+		}
+	}
+	token.MaxCertLifetime = int64(maxCertLifetime.Seconds())
+	lifetime := defaultAutomationTokenLifetime
+	if value := r.Form.Get("expires_in"); value != "" {
+		var err error
+		lifetime, err = time.ParseDuration(value)
+		if err != nil || lifetime <= 0 ||
+			lifetime > maxAutomationTokenLifetime {
+			return nil, errors.New("Invalid expires_in")
+		}
+	}
+	token.ExpiresAt = token.CreatedAt.Add(lifetime)
+	return token, nil
+}
+
+// automationTokensHandler lists the automation tokens (GET) or creates one
+// (POST) and returns it with its value.
+func (state *RuntimeState) automationTokensHandler(w http.ResponseWriter,
+	r *http.Request) {
+	failure, authUser := state.sendFailureToClientIfNonAdmin(w, r)
+	if failure {
+		return
+	}
+	var response interface{}
+	switch r.Method {
+	case "GET":
+		tokens, err := state.listAutomationTokens()
+		if err != nil {
+			state.logger.Printf("error listing automation tokens: %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
+			return
+		}
+		response = tokens
+	case "POST":
+		if err := r.ParseForm(); err != nil {
+			state.logger.Printf("error parsing err=%s", err)
+			state.writeFailureResponse(w, r, http.StatusBadRequest, "")
+			return
+		}
+		token, err := parseAutomationTokenForm(r)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				err.Error())
+			return
+		}
+		token.CreatedBy = authUser
+		value, err := state.createAutomationToken(token)
+		if err != nil {
+			state.logger.Printf("error creating automation token: %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
+			return
+		}
+		state.logger.Printf("%s: created automation token: %s for: %s\n",
+			authUser, token.ID, token.Principal)
+		response = newAutomationTokenResponse{*token, value}
+	default:
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		state.logger.Printf("json encoding error: %v", err)
+	}
+}
+
+// revokeAutomationTokenHandler revokes the token given by the id form value.
+func (state *RuntimeState) revokeAutomationTokenHandler(w http.ResponseWriter,
+	r *http.Request) {
+	failure, authUser := state.sendFailureToClientIfNonAdmin(w, r)
+	if failure {
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		state.logger.Printf("error parsing err=%s", err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "")
+		return
+	}
+	id := r.Form.Get("id")
+	revoked, err := state.revokeAutomationToken(id, authUser)
+	if err != nil {
+		state.logger.Printf("error revoking automation token: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if !revoked {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"Unknown or revoked token")
+		return
+	}
+	state.logger.Printf("%s: revoked automation token: %s\n", authUser, id)
+	writeAdminActionResponse(w, r, "")
+}
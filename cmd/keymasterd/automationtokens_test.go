@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAddressInCIDRs(t *testing.T) {
+	cidrs := []string{"10.1.0.0/16", "2001:db8::/32"}
+	for _, addr := range []string{"10.1.2.3:443", "[2001:db8::1]:22",
+		"10.1.255.255"} {
+		if !addressInCIDRs(addr, cidrs) {
+			t.Errorf("%s not in %v", addr, cidrs)
+		}
+	}
+	for _, addr := range []string{"10.2.0.1:443", "", "garbage:1"} {
+		if addressInCIDRs(addr, cidrs) {
+			t.Errorf("%s in %v", addr, cidrs)
+		}
+	}
+}
+
+func TestAutomationTokens(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	createToken := func(form url.Values, expectedStatus int) string {
+		req, err := http.NewRequest("POST", automationTokensPath,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.TLS, err = testMakeConnectionState("testdata/alice.pem",
+			"testdata/KeymasterCA.pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := checkRequestHandlerCode(req, state.automationTokensHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expectedStatus != http.StatusOK {
+			return ""
+		}
+		var response newAutomationTokenResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Principal != "ci-bot" || response.CreatedBy != "alice" {
+			t.Fatalf("unexpected token: %+v", response)
+		}
+		return response.Token
+	}
+	form := url.Values{
+		"principal":   {"ci-bot"},
+		"cert_type":   {"ssh"},
+		"source_cidr": {"192.0.2.0/24"},
+	}
+	badForm := url.Values{"principal": {"ci-bot"}, "cert_type": {"ssh"}}
+	createToken(badForm, http.StatusBadRequest)
+	token := createToken(form, http.StatusOK)
+	getCert := func(token, certType, remoteAddr string, expectedStatus int) {
+		req, err := createKeyBodyRequest("POST",
+			certgenPath+"ci-bot?type="+certType, testUserSSHPublicKey, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if certType == "x509" {
+			req, err = createKeyBodyRequest("POST",
+				certgenPath+"ci-bot?type=x509", testUserPEMPublicKey, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = remoteAddr
+		_, err = checkRequestHandlerCode(req, state.certGenHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatalf("%s from %s: %s", certType, remoteAddr, err)
+		}
+	}
+	getCert(token, "ssh", "192.0.2.1:1234", http.StatusOK)
+	getCert(token, "x509", "192.0.2.1:1234", http.StatusForbidden)
+	getCert(token, "ssh", "198.51.100.1:1234", http.StatusUnauthorized)
+	getCert(token+"x", "ssh", "192.0.2.1:1234", http.StatusUnauthorized)
+	// Tokens are only good for certgen.
+	req, err := http.NewRequest("GET", profilePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.RemoteAddr = "192.0.2.1:1234"
+	_, err = checkRequestHandlerCode(req, state.profileHandler,
+		http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Revoked tokens are rejected.
+	id, _, _ := splitAutomationToken(token)
+	req, err = http.NewRequest("POST", revokeAutomationTokenPath,
+		strings.NewReader(url.Values{"id": {id}}.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.TLS, err = testMakeConnectionState("testdata/alice.pem",
+		"testdata/KeymasterCA.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.revokeAutomationTokenHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getCert(token, "ssh", "192.0.2.1:1234", http.StatusUnauthorized)
+}
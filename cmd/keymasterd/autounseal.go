@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/awsutil/metadata"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const autoUnsealTimeout = 30 * time.Second
+
+var (
+	gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/" +
+		"v1/instance/service-accounts/default/token"
+	gcpSecretManagerURL = "https://secretmanager.googleapis.com/v1/"
+
+	gcpSecretNameRE = regexp.MustCompile(
+		`^projects/[^/]+/secrets/[^/]+(/versions/[^/]+)?$`)
+)
+
+func (config *autoUnseal) enabled() bool {
+	return config.AwsSecretId != "" || config.AwsSSMParameter != "" ||
+		config.GcpSecret != ""
+}
+
+func (config *autoUnseal) check() error {
+	sources := 0
+	for _, source := range []string{config.AwsSecretId,
+		config.AwsSSMParameter, config.GcpSecret} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("auto_unseal: only one secret source allowed")
+	}
+	if config.GcpSecret != "" && !gcpSecretNameRE.MatchString(config.GcpSecret) {
+		return fmt.Errorf("auto_unseal: invalid gcp_secret: %s",
+			config.GcpSecret)
+	}
+	return nil
+}
+
+// tryAutoUnseal fetches the unseal password from the configured secret
+// store, using the credentials of the instance, and unseals the CA.
+func (state *RuntimeState) tryAutoUnseal() error {
+	config := state.Config.Base.AutoUnseal
+	switch {
+	case config.AwsSecretId != "":
+		metadataClient, err := metadata.GetMetadataClient()
+		if err != nil {
+			return err
+		}
+		return state.tryAwsUnseal(metadataClient)
+	case config.AwsSSMParameter != "":
+		password, err := getAwsSSMParameter(config.AwsSSMParameter)
+		if err != nil {
+			return err
+		}
+		return state.unsealCA(password, "AWS SSM Parameter Store")
+	case config.GcpSecret != "":
+		client := &http.Client{Timeout: autoUnsealTimeout}
+		password, err := getGcpSecret(client, config.GcpSecret)
+		if err != nil {
+			return err
+		}
+		return state.unsealCA(password, "GCP Secret Manager")
+	}
+	return errors.New("no auto unseal source configured")
+}
+
+// newAwsSession returns an AWS session. The region is taken from the
+// environment, else from the instance.
+func newAwsSession() (*session.Session, error) {
+	awsConfig := aws.NewConfig()
+	awsSession, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(awsSession.Config.Region) == "" {
+		metadataClient, err := metadata.GetMetadataClient()
+		if err != nil {
+			return nil, err
+		}
+		region, err := metadataClient.Region()
+		if err != nil {
+			return nil, err
+		}
+		awsSession.Config.Region = aws.String(region)
+	}
+	return awsSession, nil
+}
+
+// getAwsSSMParameter returns the decrypted value of a SecureString parameter.
+func getAwsSSMParameter(name string) ([]byte, error) {
+	awsSession, err := newAwsSession()
+	if err != nil {
+		return nil, err
+	}
+	output, err := ssm.New(awsSession).GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Parameter == nil || output.Parameter.Value == nil {
+		return nil, fmt.Errorf("parameter: %s has no value", name)
+	}
+	return []byte(*output.Parameter.Value), nil
+}
+
+func getGcpJSON(client *http.Client, req *http.Request,
+	value interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error getting %s: %s", req.URL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(value)
+}
+
+// getGcpSecret returns the payload of a GCP Secret Manager secret, accessed
+// with the token of the default service account of the instance. The latest
+// version is used unless name includes one.
+func getGcpSecret(client *http.Client, name string) ([]byte, error) {
+	req, err := http.NewRequest("GET", gcpMetadataTokenURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := getGcpJSON(client, req, &token); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	req, err = http.NewRequest("GET", gcpSecretManagerURL+name+":access", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	var secret struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := getGcpJSON(client, req, &secret); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(secret.Payload.Data)
+}
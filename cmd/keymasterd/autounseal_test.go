@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAutoUnsealCheck(t *testing.T) {
+	tests := []struct {
+		config autoUnseal
+		valid  bool
+	}{
+		{autoUnseal{}, true},
+		{autoUnseal{AwsSSMParameter: "/keymaster/unseal"}, true},
+		{autoUnseal{GcpSecret: "projects/p/secrets/unseal"}, true},
+		{autoUnseal{GcpSecret: "projects/p/secrets/unseal/versions/3"}, true},
+		{autoUnseal{GcpSecret: "unseal"}, false},
+		{autoUnseal{AwsSecretId: "keymaster/unsealer",
+			AwsSSMParameter: "/keymaster/unseal"}, false},
+	}
+	for _, test := range tests {
+		if err := test.config.check(); (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid: %v, got: %v", test.config,
+				test.valid, err)
+		}
+	}
+}
+
+func TestGetGcpSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/token":
+				if r.Header.Get("Metadata-Flavor") != "Google" {
+					http.Error(w, "missing header", http.StatusForbidden)
+					return
+				}
+				fmt.Fprint(w, `{"access_token": "token"}`)
+			case "/v1/projects/p/secrets/unseal/versions/latest:access":
+				if r.Header.Get("Authorization") != "Bearer token" {
+					http.Error(w, "bad token", http.StatusUnauthorized)
+					return
+				}
+				fmt.Fprintf(w, `{"payload": {"data": %q}}`,
+					base64.StdEncoding.EncodeToString([]byte("password")))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	defer server.Close()
+	savedTokenURL, savedSecretManagerURL :=
+		gcpMetadataTokenURL, gcpSecretManagerURL
+	defer func() {
+		gcpMetadataTokenURL = savedTokenURL
+		gcpSecretManagerURL = savedSecretManagerURL
+	}()
+	gcpMetadataTokenURL = server.URL + "/token"
+	gcpSecretManagerURL = server.URL + "/v1/"
+	password, err := getGcpSecret(server.Client(), "projects/p/secrets/unseal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(password) != "password" {
+		t.Fatalf("unexpected password: %s", password)
+	}
+	_, err = getGcpSecret(server.Client(), "projects/p/secrets/other")
+	if err == nil {
+		t.Fatal("missing secret returned")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	htmltemplate "html/template"
+	"net/http"
+)
+
+const defaultProductName = "Keymaster"
+
+func (state *RuntimeState) productName() string {
+	if state.Config.Branding.ProductName != "" {
+		return state.Config.Branding.ProductName
+	}
+	return defaultProductName
+}
+
+// pageTitle prefixes title with the configured product name.
+func (state *RuntimeState) pageTitle(title string) string {
+	return state.productName() + " " + title
+}
+
+// templateFuncs must be installed before any template is parsed.
+func (state *RuntimeState) templateFuncs() htmltemplate.FuncMap {
+	return htmltemplate.FuncMap{
+		"productName": state.productName,
+		"logoURL": func() string {
+			return state.Config.Branding.LogoURL
+		},
+		"footerText": func() string {
+			return state.Config.Branding.FooterText
+		},
+		"T": state.translate,
+	}
+}
+
+// getLanguage negotiates the language of the page from the Accept-Language
+// header and sets the response headers accordingly.
+func (state *RuntimeState) getLanguage(w http.ResponseWriter,
+	r *http.Request) string {
+	language := state.messageCatalog.Negotiate(r.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Language", language)
+	w.Header().Add("Vary", "Accept-Language")
+	return language
+}
+
+func (state *RuntimeState) translate(language, message string) string {
+	return state.messageCatalog.Translate(language, message)
+}
+
+func (state *RuntimeState) writeHTMLErrorPage(w http.ResponseWriter,
+	r *http.Request, code int, message string) {
+	if state.htmlTemplate == nil ||
+		state.htmlTemplate.Lookup("errorPage") == nil {
+		http.Error(w, message, code)
+		return
+	}
+	language := state.getLanguage(w, r)
+	title := state.translate(language, http.StatusText(code))
+	displayData := errorPageTemplateData{
+		Title:      state.pageTitle(title),
+		Language:   language,
+		Code:       code,
+		StatusText: http.StatusText(code),
+		Message:    message,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	err := state.htmlTemplate.ExecuteTemplate(w, "errorPage", displayData)
+	if err != nil {
+		logger.Printf("Failed to execute %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// TestShippedMessageCatalogs checks that every shipped translation is of a
+// message which is still used, and is plain text.
+func TestShippedMessageCatalogs(t *testing.T) {
+	sourceFilenames, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var source strings.Builder
+	for _, filename := range sourceFilenames {
+		if strings.HasSuffix(filename, "_test.go") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source.Write(data)
+	}
+	catalogFilenames, err := filepath.Glob(
+		filepath.Join("customization_data", "messages", "*.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalogFilenames) < 1 {
+		t.Fatal("no message catalogs shipped")
+	}
+	for _, filename := range catalogFilenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var messages map[string]string
+		if err := yaml.Unmarshal(data, &messages); err != nil {
+			t.Fatalf("%s: %s", filename, err)
+		}
+		for message, translation := range messages {
+			if !strings.Contains(source.String(), strconv.Quote(message)) {
+				t.Errorf("%s: unused message: %q", filename, message)
+			}
+			if translation == "" || strings.ContainsAny(translation, "%{}<>") {
+				t.Errorf("%s: bad translation of %q: %q", filename, message,
+					translation)
+			}
+		}
+	}
+}
+
+func TestLoginPageGerman(t *testing.T) {
+	state := &RuntimeState{}
+	if err := state.loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Branding.ProductName = "100% Keymaster"
+	req := httptest.NewRequest("GET", loginFormPath, nil)
+	req.Header.Set("Accept-Language", "de-DE, de;q=0.9")
+	recorder := httptest.NewRecorder()
+	state.loginFormHandler(recorder, req)
+	resp := recorder.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	if language := resp.Header.Get("Content-Language"); language != "de" {
+		t.Fatalf("expected de, got %s", language)
+	}
+	body := recorder.Body.String()
+	// The product name is inserted verbatim, next to the translated text.
+	for _, text := range []string{"100% Keymaster Anmeldung", "Benutzername:",
+		"Passwort:"} {
+		if !strings.Contains(body, text) {
+			t.Fatalf("missing %q in login page: %s", text, body)
+		}
+	}
+}
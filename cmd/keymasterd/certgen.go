@@ -7,8 +7,9 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"regexp"
 	"strings"
 	"time"
 
@@ -22,6 +23,7 @@ import (
 const (
 	certgenPath            = "/certgen/"
 	maxCertificateLifetime = time.Hour * 24
+	maxPublicKeyFileSize   = 16 << 10
 )
 
 func prependGroups(groups []string, prefix string) []string {
@@ -40,12 +42,12 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 	var keySigner crypto.Signer
 
 	// copy runtime singer if not nil
-	state.Mutex.Lock()
+	state.Mutex.RLock()
 	signerIsNull = (state.Signer == nil)
 	if !signerIsNull {
 		keySigner = state.Signer
 	}
-	state.Mutex.Unlock()
+	state.Mutex.RUnlock()
 
 	//local sanity tests
 	if signerIsNull {
@@ -96,6 +98,14 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 	if (authData.AuthType & AuthTypeU2F) == AuthTypeU2F {
 		sufficientAuthLevel = true
 	}
+	// Automation tokens carry their own restrictions.
+	maxDuration := time.Until(authData.IssuedAt.Add(maxCertificateLifetime))
+	var allowedCertTypes []string
+	if token := authData.AutomationToken; token != nil {
+		sufficientAuthLevel = true
+		maxDuration = time.Duration(token.MaxCertLifetime) * time.Second
+		allowedCertTypes = token.CertTypes
+	}
 
 	if !sufficientAuthLevel {
 		logger.Printf("Not enough auth level for getting certs")
@@ -117,11 +127,31 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	state.issueCertificate(w, r, targetUser, keySigner, maxDuration,
+		allowedCertTypes, getAuthMethod(authData.AuthType),
+		getAuthStrength(authData.AuthType), authData.IssuedAt)
+}
+
+// issueCertificate parses the certificate request form and issues a
+// certificate of the requested type to targetUser, who must already be
+// authenticated and authorised. The lifetime is limited to maxDuration and,
+// unless allowedCertTypes is nil, the type to one of allowedCertTypes. The
+// authMethod is recorded in the audit record of the certificate, and with
+// authStrength and authTime in the issuance context of X.509 certificates.
+// With preview=true, the certificate is described instead of issued.
+func (state *RuntimeState) issueCertificate(w http.ResponseWriter,
+	r *http.Request, targetUser string, keySigner crypto.Signer,
+	maxDuration time.Duration, allowedCertTypes []string,
+	authMethod string, authStrength int, authTime time.Time) {
 	logger.Debugf(3, "Got client POST connection")
-	err = r.ParseMultipartForm(1e7)
+	release, ok := state.acquireRequestSlot(w, r, targetUser, "certgen")
+	if !ok {
+		return
+	}
+	defer release()
+	publicKey, err := parseCertRequest(r)
 	if err != nil {
-		logger.Println(err)
-		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 	duration := maxCertificateLifetime
@@ -141,7 +171,6 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 		}
 		duration = newDuration
 	}
-	maxDuration := time.Until(authData.IssuedAt.Add(maxCertificateLifetime))
 	if duration > maxDuration {
 		duration = maxDuration
 	}
@@ -151,16 +180,71 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 		certType = val[0]
 	}
 	logger.Printf("cert type =%s", certType)
+	if allowedCertTypes != nil {
+		allowed := false
+		for _, allowedCertType := range allowedCertTypes {
+			if certType == allowedCertType {
+				allowed = true
+			}
+		}
+		if !allowed {
+			state.writeFailureResponse(w, r, http.StatusForbidden,
+				"Cert type not allowed")
+			return
+		}
+	}
+	keyProfile := r.Form.Get("key_profile")
+	if keyProfile != "" && certType != "x509" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"key_profile is only supported for x509 certificates")
+		return
+	}
+	if !state.checkHoneytoken(w, r, targetUser, honeytokenStageCertificate) {
+		return
+	}
+	if !state.checkAccountStatus(w, r, targetUser) {
+		return
+	}
+	if !state.checkDevicePosture(w, r, targetUser, certType) {
+		return
+	}
+	issuance, err := newIssuanceContext(r, authMethod)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	issuance.preview = r.Form.Get("preview") == "true"
+	issuance.publicKey = publicKey
+	issuance.keyProfile = keyProfile
+	if issuance.preview && keyProfile != "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"preview is not supported with key_profile")
+		return
+	}
+	issuance.authStrength = authStrength
+	issuance.authTime = authTime
+	if !issuance.preview && !state.checkIssuanceQuota(w, r, targetUser) {
+		return
+	}
+	go state.recordUserSeen(targetUser)
 
 	switch certType {
 	case "ssh":
-		state.postAuthSSHCertHandler(w, r, targetUser, duration)
+		state.postAuthSSHCertHandler(w, r, targetUser, duration, issuance)
 		return
 	case "x509":
-		state.postAuthX509CertHandler(w, r, targetUser, keySigner, duration, false)
+		if keyProfile != "" {
+			state.postAuthEscrowedX509CertHandler(w, r, targetUser, keySigner,
+				duration, issuance)
+			return
+		}
+		state.postAuthX509CertHandler(w, r, targetUser, keySigner, duration,
+			false, issuance)
 		return
 	case "x509-kubernetes":
-		state.postAuthX509CertHandler(w, r, targetUser, keySigner, duration, true)
+		state.postAuthX509CertHandler(w, r, targetUser, keySigner, duration,
+			true, issuance)
 		return
 	default:
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Unrecognized cert type")
@@ -168,58 +252,84 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
-// returns 3 values, if the key is valid, if the key is not valid, the text reason why and and error if it was an internal error
-func getValidSSHPublicKey(userPubKey string) (ssh.PublicKey, error, error) {
-	validKey, err := regexp.MatchString("^(ssh-rsa|ssh-dss|ecdsa-sha2-nistp256|ssh-ed25519) [a-zA-Z0-9/+]+=?=? ?.{0,512}\n?$", userPubKey)
+// readPublicKeyFile reads the uploaded public key file. Files larger than
+// maxPublicKeyFileSize are rejected without reading them completely. The
+// first error is for the client, the second is an internal error.
+func readPublicKeyFile(r *http.Request) ([]byte, error, error) {
+	file, _, err := r.FormFile("pubkeyfile")
+	if err != nil {
+		logger.Println(err)
+		return nil, errors.New("Missing public key file"), nil
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxPublicKeyFileSize+1))
 	if err != nil {
 		return nil, nil, err
 	}
-	if !validKey {
-		return nil, fmt.Errorf("Invalid File, bad re"), nil
+	if len(data) > maxPublicKeyFileSize {
+		return nil, errors.New("Invalid File, too large"), nil
 	}
-	userSSH, _, _, _, err := ssh.ParseAuthorizedKey([]byte(userPubKey))
+	return data, nil, nil
+}
+
+// getValidSSHPublicKey parses a single public key in authorized_keys format
+// with certgen.ParseSSHPublicKey.
+func getValidSSHPublicKey(userPubKey string, minRSABits int) (
+	ssh.PublicKey, error, error) {
+	userSSH, err := certgen.ParseSSHPublicKey([]byte(userPubKey), minRSABits)
 	if err != nil {
-		return nil, fmt.Errorf("invalid file, unparseable"), nil
+		return nil, fmt.Errorf("Invalid File, %s", err), nil
 	}
-	// The next check should never fail, as all of our supported keys are ssh.CryptoPublicKey's but
-	// to prevent potential future panics we check anyway
-	cryptoPubKey, ok := userSSH.(ssh.CryptoPublicKey)
-	if !ok {
-		return nil, nil, fmt.Errorf("Cannot transform ssh key into crypto key, inbound=%s", userPubKey)
+	return userSSH, nil, nil
+}
+
+// getValidX509PublicKey parses a single PEM or DER encoded PKIX public key,
+// provided the key is strong enough.
+func getValidX509PublicKey(data []byte, minRSABits int) (
+	interface{}, error, error) {
+	der := data
+	if block, rest := pem.Decode(data); block != nil {
+		if block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("Invalid File, Unable to decode pem"), nil
+		}
+		if len(bytes.TrimSpace(rest)) > 0 {
+			return nil, fmt.Errorf("Invalid File, more than one key"), nil
+		}
+		der = block.Bytes
 	}
-	validKey, err = certgen.ValidatePublicKeyStrength(cryptoPubKey.CryptoPublicKey())
+	userPub, err := x509.ParsePKIXPublicKey(der)
 	if err != nil {
-		return nil, nil, err
+		return nil, fmt.Errorf("Cannot parse public key"), nil
 	}
-	if !validKey {
-		return nil, fmt.Errorf("Invalid File, Check Key strength/key type"), nil
+	if err := certgen.CheckPublicKeyStrength(userPub, minRSABits); err != nil {
+		return nil, fmt.Errorf("Invalid File, weak key: %s, generate a new key",
+			err), nil
 	}
-	return userSSH, nil, nil
+	return userPub, nil, nil
 }
 
 func (state *RuntimeState) postAuthSSHCertHandler(
 	w http.ResponseWriter, r *http.Request, targetUser string,
-	duration time.Duration) {
+	duration time.Duration, issuance issuanceContext) {
 
-	var certString string
-	var cert ssh.Certificate
 	if r.Method != "POST" {
 		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
 		return
 	}
 
-	file, _, err := r.FormFile("pubkeyfile")
+	pubKeyData, userErr, err := readRequestPublicKey(r, issuance)
 	if err != nil {
 		logger.Println(err)
-		state.writeFailureResponse(w, r, http.StatusBadRequest, "Missing public key file")
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		return
 	}
-	defer file.Close()
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(file)
-	userPubKey := buf.String()
-
-	sshUserPublicKey, userErr, err := getValidSSHPublicKey(userPubKey)
+	if userErr != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, userErr.Error())
+		return
+	}
+	userPubKey := string(pubKeyData)
+	sshUserPublicKey, userErr, err := getValidSSHPublicKey(userPubKey,
+		state.Config.Base.MinRSAKeyBits)
 	if err != nil {
 		logger.Println(err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
@@ -230,39 +340,80 @@ func (state *RuntimeState) postAuthSSHCertHandler(
 		state.writeFailureResponse(w, r, http.StatusBadRequest, userErr.Error())
 		return
 	}
-	var cryptoSigner crypto.Signer
+	state.writeSSHCertificate(w, r, targetUser, userPubKey, sshUserPublicKey,
+		duration, issuance)
+}
+
+// writeSSHCertificate issues and writes an SSH certificate for the validated
+// public key of targetUser.
+func (state *RuntimeState) writeSSHCertificate(w http.ResponseWriter,
+	r *http.Request, targetUser string, userPubKey string,
+	sshUserPublicKey ssh.PublicKey, duration time.Duration,
+	issuance issuanceContext) {
+	if err := state.checkFIPSUserKey(sshUserPublicKey); err != nil {
+		state.writeFailureResponse(w, r, http.StatusUnprocessableEntity,
+			err.Error())
+		return
+	}
+	var signer ssh.Signer
+	state.Mutex.RLock()
 	switch sshUserPublicKey.Type() {
 	case ssh.KeyAlgoED25519:
-		if state.Ed25519Signer == nil {
+		signer = state.ed25519SSHSigner
+	default:
+		signer = state.sshSigner
+	}
+	state.Mutex.RUnlock()
+	if signer == nil {
+		if sshUserPublicKey.Type() == ssh.KeyAlgoED25519 {
 			logger.Printf("requesting an Ed25519 cert, but no such ca defined")
 			state.writeFailureResponse(w, r, http.StatusUnprocessableEntity, "key type not allowed")
 			return
 		}
-		cryptoSigner = state.Ed25519Signer
-	default:
-		cryptoSigner = state.Signer
-	}
-	signer, err := ssh.NewSignerFromSigner(cryptoSigner)
-	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-		logger.Printf("Signer failed to load")
+		logger.Printf("Signer not loaded")
 		return
 	}
 
-	certString, cert, err = certgen.GenSSHCertFileString(targetUser, userPubKey, signer, state.HostIdentity, duration)
+	identity, err := state.getCertificateIdentity(targetUser)
 	if err != nil {
+		logger.Printf("error getting certificate identity: %s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-		logger.Printf("signUserPubkey Err")
+		return
+	}
+	identity = state.addSSHSourceAddress(identity, r)
+	if issuance.preview {
+		state.writeSSHCertificatePreview(w, r, identity, userPubKey, signer,
+			duration, issuance)
+		return
+	}
+	start := time.Now()
+	certString, cert, err := certgen.GenSSHCertFileStringForIdentity(identity,
+		userPubKey, signer, state.HostIdentity, issuance.AuditID, duration)
+	observeSigning(operationSSHSign, start, err)
+	if err != nil {
+		state.writeSigningFailureResponse(w, r, err)
+		logger.Printf("signUserPubkey Err: %s", err)
 		return
 	}
 
 	eventNotifier.PublishSSH(cert.Marshal())
+	state.streamSSHCertificate(targetUser, &cert, r, issuance)
+	go state.recordIssuedCertificate(newSSHIssuedCertRecord(targetUser, &cert,
+		r, issuance))
+	go state.recordCertSourceAddress(targetUser, "ssh", r.RemoteAddr)
 	metricLogCertDuration("ssh", "granted", float64(duration.Seconds()))
 
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+cert.Type()+"-cert.pub\"")
-	w.WriteHeader(200)
-	fmt.Fprintf(w, "%s", certString)
-	logger.Printf("Generated SSH Certifcate for %s. Serial:%d", targetUser, cert.Serial)
+	if wantsJSONResponse(r) {
+		writeCertgenResponse(w, newSSHCertgenResponse(&cert, certString,
+			issuance))
+	} else {
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+cert.Type()+"-cert.pub\"")
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "%s", certString)
+	}
+	logger.Printf("Generated SSH Certifcate for %s. Serial:%d AuditID:%s",
+		targetUser, cert.Serial, issuance.AuditID)
 	go func(username string, certType string) {
 		metricsMutex.Lock()
 		defer metricsMutex.Unlock()
@@ -286,12 +437,24 @@ func (state *RuntimeState) getGitDbUserGroups(username string) (
 
 func (state *RuntimeState) getLdapUserGroups(username string) (
 	bool, []string, error) {
-	ldapConfig := state.Config.UserInfo.Ldap
-	var timeoutSecs uint
-	timeoutSecs = 2
-	if ldapConfig.LDAPTargetURLs == "" {
+	queries := state.getLdapUserInfoQueries(username)
+	if len(queries) < 1 {
 		return false, nil, nil
 	}
+	for _, query := range queries {
+		groups, err := state.getLdapUserGroupsFromSource(query.source,
+			query.username)
+		if err == nil {
+			return true, groups, nil
+		}
+	}
+	return true, nil, errors.New("error getting the groups")
+}
+
+func (state *RuntimeState) getLdapUserGroupsFromSource(
+	ldapConfig UserInfoLDAPSource, username string) ([]string, error) {
+	var timeoutSecs uint
+	timeoutSecs = 2
 	for _, ldapUrl := range strings.Split(ldapConfig.LDAPTargetURLs, ",") {
 		if len(ldapUrl) < 1 {
 			continue
@@ -301,18 +464,24 @@ func (state *RuntimeState) getLdapUserGroups(username string) (
 			logger.Printf("Failed to parse ldapurl '%s'", ldapUrl)
 			continue
 		}
+		breaker := state.backendBreakers.Get(u.Host)
+		if !breaker.Allow() {
+			continue
+		}
 		groups, err := authutil.GetLDAPUserGroups(*u,
 			ldapConfig.BindUsername, ldapConfig.BindPassword,
-			timeoutSecs, nil, username,
+			timeoutSecs, ldapConfig.getTLSConfig(), username,
 			ldapConfig.UserSearchBaseDNs, ldapConfig.UserSearchFilter,
 			ldapConfig.GroupSearchBaseDNs, ldapConfig.GroupSearchFilter)
 		if err != nil {
+			breaker.Failure()
 			continue
 		}
-		return true, prependGroups(groups, ldapConfig.GroupPrepend), nil
+		breaker.Success()
+		return prependGroups(groups, ldapConfig.GroupPrepend), nil
 
 	}
-	return true, nil, errors.New("error getting the groups")
+	return nil, errors.New("error getting the groups")
 }
 
 func (state *RuntimeState) getUserGroups(username string) ([]string, error) {
@@ -328,12 +497,54 @@ func (state *RuntimeState) getUserGroups(username string) ([]string, error) {
 func (state *RuntimeState) postAuthX509CertHandler(
 	w http.ResponseWriter, r *http.Request, targetUser string,
 	keySigner crypto.Signer, duration time.Duration,
-	kubernetesHack bool) {
+	kubernetesHack bool, issuance issuanceContext) {
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	pubKeyData, userErr, err := readRequestPublicKey(r, issuance)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if userErr != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			userErr.Error())
+		return
+	}
+	userPub, userErr, err := getValidX509PublicKey(pubKeyData,
+		state.Config.Base.MinRSAKeyBits)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if userErr != nil {
+		logger.Printf("validating Error err: %s", userErr)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			userErr.Error())
+		return
+	}
+	state.writeX509Certificate(w, r, targetUser, keySigner, userPub, duration,
+		kubernetesHack, r.Form.Get("addGroups") == "true", issuance)
+}
 
+// writeX509Certificate issues and writes an X.509 certificate for the
+// validated public key of targetUser.
+func (state *RuntimeState) writeX509Certificate(w http.ResponseWriter,
+	r *http.Request, targetUser string, keySigner crypto.Signer,
+	userPub interface{}, duration time.Duration, kubernetesHack bool,
+	addGroups bool, issuance issuanceContext) {
+	if err := state.checkFIPSUserKey(userPub); err != nil {
+		state.writeFailureResponse(w, r, http.StatusUnprocessableEntity,
+			err.Error())
+		return
+	}
 	var userGroups, groups []string
 	// Getting user groups can be a failure, in this case we dont want to
 	// abort if we are not explicitly asking for groups in our cert.
-	if kubernetesHack || r.Form.Get("addGroups") == "true" {
+	if kubernetesHack || addGroups {
 		var err error
 		logger.Debugf(2, "Groups needed for cert")
 		userGroups, err = state.getUserGroups(targetUser)
@@ -343,80 +554,105 @@ func (state *RuntimeState) postAuthX509CertHandler(
 			return
 		}
 	}
-	if r.Form.Get("addGroups") == "true" {
+	if addGroups {
 		groups = userGroups
 	}
 	organizations := []string{"keymaster"}
 	if kubernetesHack {
 		organizations = userGroups
 	}
-	var cert string
-	switch r.Method {
-	case "POST":
-		file, _, err := r.FormFile("pubkeyfile")
-		if err != nil {
-			logger.Println(err)
-			state.writeFailureResponse(w, r, http.StatusBadRequest,
-				"Missing public key file")
-			return
-		}
-		defer file.Close()
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(file)
-
-		block, _ := pem.Decode(buf.Bytes())
-		if block == nil || block.Type != "PUBLIC KEY" {
-			state.writeFailureResponse(w, r, http.StatusBadRequest,
-				"Invalid File, Unable to decode pem")
-			logger.Printf("invalid file, unable to decode pem")
-			return
+	identity, err := state.getCertificateIdentity(targetUser)
+	if err != nil {
+		logger.Printf("error getting certificate identity: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	identity.X509IssuanceContext = state.getX509IssuanceContext(issuance)
+	state.Mutex.RLock()
+	caCert := state.caCert
+	state.Mutex.RUnlock()
+	if issuance.preview {
+		certType := "x509"
+		if kubernetesHack {
+			certType = "x509-kubernetes"
 		}
-		userPub, err := x509.ParsePKIXPublicKey(block.Bytes)
-		if err != nil {
-			state.writeFailureResponse(w, r, http.StatusBadRequest,
-				"Cannot parse public key")
-			logger.Printf("Cannot parse public key")
+		state.writeX509CertificatePreview(w, r, identity, userPub, caCert,
+			certType, duration, groups, organizations, issuance)
+		return
+	}
+	start := time.Now()
+	derCert, err := certgen.GenUserX509CertForIdentity(identity, userPub,
+		caCert, state.getRequestSigner(r, keySigner), state.KerberosRealm,
+		duration, groups, organizations, issuance.AuditID)
+	observeSigning(operationX509Sign, start, err)
+	if err != nil {
+		state.writeSigningFailureResponse(w, r, err)
+		logger.Printf("Cannot Generate x509cert: %s", err)
+		return
+	}
+	eventNotifier.PublishX509(derCert)
+	certType := "x509"
+	if kubernetesHack {
+		certType = "x509-kubernetes"
+	}
+	parsedCert, err := x509.ParseCertificate(derCert)
+	if err == nil {
+		go state.recordIssuedCertificate(newX509IssuedCertRecord(
+			targetUser, certType, parsedCert, r, issuance))
+		go state.recordCertSourceAddress(targetUser, certType,
+			r.RemoteAddr)
+	}
+	var privateKey string
+	if issuance.escrowedKey != nil {
+		if parsedCert == nil {
+			logger.Printf("cannot parse issued certificate: %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
 			return
 		}
-		validKey, err := certgen.ValidatePublicKeyStrength(userPub)
+		err := state.recordEscrowedKey(targetUser, parsedCert, issuance)
 		if err != nil {
-			logger.Println(err)
-			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			logger.Printf("cannot escrow key: %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
 			return
 		}
-		if !validKey {
-			state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid File, Check Key strength/key type")
-			logger.Printf("Invalid File, Check Key strength/key type")
+		logger.Printf("escrowed %s key for %s. Serial:%s AuditID:%s",
+			issuance.escrowedKey.profile, targetUser,
+			parsedCert.SerialNumber, issuance.AuditID)
+		privateKey = string(pem.EncodeToMemory(
+			issuance.escrowedKey.privateKey))
+	}
+	cert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+		Bytes: derCert}))
+	var caChain string
+	if state.wantCrossSignedChain(r) {
+		caChain = encodeCertificatesPEM(state.getCrossSignedCACerts(caCert))
+	}
+	metricLogCertDuration("x509", "granted", float64(duration.Seconds()))
+
+	if wantsJSONResponse(r) {
+		if parsedCert == nil {
+			logger.Printf("cannot parse issued certificate: %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
 			return
 		}
-		caCert, err := x509.ParseCertificate(state.caCertDer)
-		if err != nil {
-			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-			logger.Printf("Cannot parse CA Der data")
-			return
+		response := newX509CertgenResponse(certType, parsedCert, caCert,
+			caChain, issuance)
+		response.PrivateKey = privateKey
+		writeCertgenResponse(w, response)
+	} else {
+		w.Header().Set("Content-Disposition",
+			`attachment; filename="userCert.pem"`)
+		if privateKey != "" {
+			w.Header().Set("Cache-Control", "no-store")
 		}
-		derCert, err := certgen.GenUserX509Cert(targetUser, userPub, caCert,
-			keySigner, state.KerberosRealm, duration, groups, organizations)
-		if err != nil {
-			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-			logger.Printf("Cannot Generate x509cert")
-			return
-		}
-		eventNotifier.PublishX509(derCert)
-		cert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
-			Bytes: derCert}))
-
-	default:
-		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
-		return
-
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "%s", cert+caChain+privateKey)
 	}
-	metricLogCertDuration("x509", "granted", float64(duration.Seconds()))
-
-	w.Header().Set("Content-Disposition", `attachment; filename="userCert.pem"`)
-	w.WriteHeader(200)
-	fmt.Fprintf(w, "%s", cert)
-	logger.Printf("Generated x509 Certifcate for %s", targetUser)
+	logger.Printf("Generated x509 Certifcate for %s. AuditID:%s", targetUser,
+		issuance.AuditID)
 	go func(username string, certType string) {
 		metricsMutex.Lock()
 		defer metricsMutex.Unlock()
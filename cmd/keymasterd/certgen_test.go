@@ -2,8 +2,13 @@ package main
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -17,6 +22,7 @@ import (
 
 	"github.com/Cloud-Foundations/keymaster/lib/certgen"
 	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
 )
 
 const testSignerX509Cert = `-----BEGIN CERTIFICATE-----
@@ -157,7 +163,7 @@ func TestSuccessFullSigningX509IPCert(t *testing.T) {
 func TestGetValidSSHPublicKey(t *testing.T) {
 	//testUserSSHPublicKey
 	//valid key:
-	userSSH, _, err := getValidSSHPublicKey(testUserSSHPublicKey)
+	userSSH, _, err := getValidSSHPublicKey(testUserSSHPublicKey, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -167,7 +173,7 @@ func TestGetValidSSHPublicKey(t *testing.T) {
 	//invalid key
 	invalidKeys := []string{invalidSSHFileBadKeyData, dsaPublicSSH, testSignerX509Cert}
 	for _, badKey := range invalidKeys {
-		userSSH, _, err = getValidSSHPublicKey(badKey)
+		userSSH, _, err = getValidSSHPublicKey(badKey, 0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -178,6 +184,110 @@ func TestGetValidSSHPublicKey(t *testing.T) {
 
 }
 
+func TestGetValidSSHPublicKeyTypes(t *testing.T) {
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384PublicKey, err := ssh.NewPublicKey(&p384Key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skKeyBlob := ssh.Marshal(struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, edPublic, "ssh:"})
+	skKey := ssh.KeyAlgoSKED25519 + " " +
+		base64.StdEncoding.EncodeToString(skKeyBlob) + " user@host\n"
+	caSigner, err := ssh.NewSignerFromKey(edPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:         p384PublicKey,
+		CertType:    ssh.UserCert,
+		ValidBefore: ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	validKeys := []string{
+		string(ssh.MarshalAuthorizedKey(p384PublicKey)),
+		skKey,
+		"# comment\n" + testEd25519PublicSSH + "\n\n",
+	}
+	for _, key := range validKeys {
+		userSSH, userErr, err := getValidSSHPublicKey(key, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userErr != nil {
+			t.Fatalf("key: %q rejected: %s", key, userErr)
+		}
+		if userSSH == nil {
+			t.Fatalf("no key returned for: %q", key)
+		}
+	}
+	invalidKeys := []string{
+		testUserSSHPublicKey + "\n" + testEd25519PublicSSH,
+		`command="/bin/sh" ` + testEd25519PublicSSH,
+		string(ssh.MarshalAuthorizedKey(cert)),
+		"",
+	}
+	for _, key := range invalidKeys {
+		userSSH, userErr, err := getValidSSHPublicKey(key, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userErr == nil || userSSH != nil {
+			t.Fatalf("key: %q was not rejected", key)
+		}
+	}
+}
+
+func TestGetValidX509PublicKey(t *testing.T) {
+	rsaKey, err := getPubKeyFromPem(testUserPEMPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{[]byte(testUserPEMPublicKey), der} {
+		userPub, userErr, err := getValidX509PublicKey(data, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userErr != nil {
+			t.Fatal(userErr)
+		}
+		if userPub == nil {
+			t.Fatal("no key returned")
+		}
+	}
+	invalidData := [][]byte{
+		[]byte(testUserPEMPublicKey + testUserPEMPublicKey),
+		[]byte(testSignerX509Cert),
+		[]byte(testUserSSHPublicKey),
+		der[:len(der)-1],
+	}
+	for _, data := range invalidData {
+		userPub, userErr, err := getValidX509PublicKey(data, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userErr == nil || userPub != nil {
+			t.Fatalf("data: %q was not rejected", data)
+		}
+	}
+}
+
 func TestGenSSHEd25519(t *testing.T) {
 	state, passwdFile, err := setupValidRuntimeStateSigner(t)
 	if err != nil {
@@ -219,10 +329,29 @@ func TestGenSSHEd25519(t *testing.T) {
 		t.Fatal("Return valued does not look like ed25519 cert")
 	}
 	// Now we disable the Ed signer and it should fail
-	state.Ed25519Signer = nil
+	if err := state.setSigners(state.Signer, nil); err != nil {
+		t.Fatal(err)
+	}
 	_, err = checkRequestHandlerCode(req, state.certGenHandler, http.StatusUnprocessableEntity)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 }
+
+func TestGetValidPublicKeyMinRSAKeyBits(t *testing.T) {
+	_, userErr, err := getValidSSHPublicKey(testUserSSHPublicKey, 8192)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userErr == nil || !strings.Contains(userErr.Error(), "8192") {
+		t.Fatalf("expected a minimum size error, got: %v", userErr)
+	}
+	_, userErr, err = getValidX509PublicKey([]byte(testUserPEMPublicKey), 8192)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userErr == nil || !strings.Contains(userErr.Error(), "8192") {
+		t.Fatalf("expected a minimum size error, got: %v", userErr)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// certRequest is the JSON alternative to the multipart certgen form, for
+// programmatic clients. The fields mirror the form fields; the public key is
+// given inline instead of as an uploaded file.
+type certRequest struct {
+	Type       string `json:"type,omitempty"`     // Default: ssh.
+	Duration   string `json:"duration,omitempty"` // Go duration syntax.
+	PublicKey  string `json:"public_key"`
+	KeyProfile string `json:"key_profile,omitempty"` // Instead of PublicKey.
+	AddGroups  bool   `json:"add_groups,omitempty"`
+	Preview    bool   `json:"preview,omitempty"`
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// parseCertRequest parses the multipart form or JSON body of a certificate
+// request. The parameters of JSON requests are merged into r.Form with the
+// query parameters, so that they are handled like the form fields, and the
+// public key is returned. Errors are for the client.
+func parseCertRequest(r *http.Request) ([]byte, error) {
+	if !isJSONRequest(r) {
+		if err := r.ParseMultipartForm(1e7); err != nil {
+			logger.Println(err)
+			return nil, errors.New("Error parsing form")
+		}
+		return nil, nil
+	}
+	var request certRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		logger.Debugf(1, "error decoding certificate request: %s", err)
+		return nil, errors.New("Error parsing JSON request")
+	}
+	if request.KeyProfile != "" {
+		if request.PublicKey != "" {
+			return nil, errors.New("Public key not allowed with key profile")
+		}
+	} else if request.PublicKey == "" {
+		return nil, errors.New("Missing public key")
+	}
+	if len(request.PublicKey) > maxPublicKeyFileSize {
+		return nil, errors.New("Invalid File, too large")
+	}
+	form := r.URL.Query()
+	if request.Type != "" {
+		form.Set("type", request.Type)
+	}
+	if request.Duration != "" {
+		form.Set("duration", request.Duration)
+	}
+	if request.KeyProfile != "" {
+		form.Set("key_profile", request.KeyProfile)
+	}
+	if request.AddGroups {
+		form.Set("addGroups", "true")
+	}
+	if request.Preview {
+		form.Set("preview", "true")
+	}
+	r.Form = form
+	r.PostForm = make(url.Values)
+	return []byte(request.PublicKey), nil
+}
+
+// readRequestPublicKey returns the public key of a JSON request, else the
+// uploaded public key file.
+func readRequestPublicKey(r *http.Request, issuance issuanceContext) (
+	[]byte, error, error) {
+	if issuance.publicKey != nil {
+		return issuance.publicKey, nil, nil
+	}
+	return readPublicKeyFile(r)
+}
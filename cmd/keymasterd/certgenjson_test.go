@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCertRequestJSON(t *testing.T) {
+	req := httptest.NewRequest("POST", "/certgen/username",
+		strings.NewReader(`{"type": "x509", "duration": "1h",
+			"public_key": "key data", "add_groups": true}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	publicKey, err := parseCertRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(publicKey) != "key data" {
+		t.Fatalf("unexpected public key: %s", publicKey)
+	}
+	expected := map[string]string{
+		"type":      "x509",
+		"duration":  "1h",
+		"addGroups": "true",
+		"preview":   "",
+	}
+	for key, value := range expected {
+		if req.Form.Get(key) != value {
+			t.Errorf("%s: expected: %s, got: %s", key, value,
+				req.Form.Get(key))
+		}
+	}
+	issuance := issuanceContext{publicKey: publicKey}
+	data, userErr, err := readRequestPublicKey(req, issuance)
+	if err != nil || userErr != nil || string(data) != "key data" {
+		t.Fatalf("unexpected public key: %s, %v, %v", data, userErr, err)
+	}
+	// Query parameters are kept, unless given in the body.
+	req = httptest.NewRequest("POST", "/certgen/username?type=x509&preview=true",
+		strings.NewReader(`{"public_key": "key data"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := parseCertRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Form.Get("type") != "x509" || req.Form.Get("preview") != "true" {
+		t.Fatalf("unexpected form: %v", req.Form)
+	}
+}
+
+func TestParseCertRequestJSONInvalid(t *testing.T) {
+	for _, body := range []string{
+		`{"type": "x509"}`,
+		`{"public_key": "key data", "unknown": true}`,
+		`{"public_key": "` + strings.Repeat("a", maxPublicKeyFileSize+1) + `"}`,
+		`not json`,
+	} {
+		req := httptest.NewRequest("POST", "/certgen/username",
+			strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if _, err := parseCertRequest(req); err == nil {
+			t.Errorf("request: %.40s not rejected", body)
+		}
+	}
+}
+
+func TestParseCertRequestForm(t *testing.T) {
+	req, err := createKeyBodyRequest("POST", "/certgen/username?type=x509",
+		testUserPEMPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := parseCertRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKey != nil {
+		t.Fatal("unexpected public key from form")
+	}
+	if req.Form.Get("type") != "x509" {
+		t.Fatalf("unexpected type: %s", req.Form.Get("type"))
+	}
+	data, userErr, err := readRequestPublicKey(req, issuanceContext{})
+	if err != nil || userErr != nil {
+		t.Fatalf("cannot read public key file: %v, %v", userErr, err)
+	}
+	if string(data) != testUserPEMPublicKey {
+		t.Fatalf("unexpected public key: %s", data)
+	}
+}
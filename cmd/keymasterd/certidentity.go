@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/Cloud-Foundations/keymaster/lib/authutil"
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+)
+
+// Directory values are not trusted to be sane certificate names.
+var validCertificateNameRE = regexp.MustCompile(`^[A-Za-z0-9-_.@+]+$`)
+
+// Longer claim values are dropped rather than bloating every certificate.
+const maxCertificateClaimLength = 256
+
+// certificateClaimConfig embeds an LDAP attribute of the user into
+// certificates, for authorization systems which cannot query the directory.
+// X.509 certificates get it as a claim in the certgen.ClaimsOID extension,
+// SSH certificates as an extension with the values separated by commas.
+type certificateClaimConfig struct {
+	Attribute    string `yaml:"attribute"`
+	Name         string `yaml:"name"`          // Default: Attribute.
+	SSHExtension string `yaml:"ssh_extension"` // Not added to SSH if empty.
+	X509         bool   `yaml:"x509"`
+}
+
+func (claim certificateClaimConfig) getName() string {
+	if claim.Name != "" {
+		return claim.Name
+	}
+	return claim.Attribute
+}
+
+func (attributes *LDAPCertificateAttributes) checkClaims() error {
+	names := make(map[string]struct{})
+	sshExtensions := make(map[string]struct{})
+	for _, claim := range attributes.Claims {
+		if claim.Attribute == "" {
+			return errors.New("certificate claim without attribute")
+		}
+		if !claim.X509 && claim.SSHExtension == "" {
+			return fmt.Errorf(
+				"certificate claim: %s: neither x509 nor ssh_extension",
+				claim.Attribute)
+		}
+		if claim.X509 {
+			if _, ok := names[claim.getName()]; ok {
+				return fmt.Errorf("duplicate certificate claim: %s",
+					claim.getName())
+			}
+			names[claim.getName()] = struct{}{}
+		}
+		if claim.SSHExtension != "" {
+			// The standard extensions have no domain, so this also prevents
+			// claims from granting permissions.
+			if !strings.Contains(claim.SSHExtension, "@") {
+				return fmt.Errorf(
+					"certificate claim: %s: ssh_extension must be name@domain",
+					claim.Attribute)
+			}
+			if _, ok := sshExtensions[claim.SSHExtension]; ok {
+				return fmt.Errorf("duplicate ssh_extension: %s",
+					claim.SSHExtension)
+			}
+			sshExtensions[claim.SSHExtension] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// getCertificateIdentity returns the names to put in the certificates of
+// username. Unless LDAP certificate attributes or X.509 SANs are configured,
+// the username is used throughout.
+func (state *RuntimeState) getCertificateIdentity(username string) (
+	certgen.UserIdentity, error) {
+	identity := certgen.UserIdentity{Username: username}
+	attributes := state.Config.UserInfo.Ldap.CertificateAttributes
+	if attributeList := attributes.list(); len(attributeList) > 0 {
+		values, err := state.getLdapCertificateAttributes(username,
+			attributeList)
+		if err != nil {
+			return identity, err
+		}
+		identity, err = newCertificateIdentity(username, attributes, values)
+		if err != nil {
+			return identity, err
+		}
+	}
+	return state.addX509SANs(identity)
+}
+
+func newCertificateIdentity(username string,
+	attributes LDAPCertificateAttributes, values map[string][]string) (
+	certgen.UserIdentity, error) {
+	identity := certgen.UserIdentity{Username: username}
+	for _, attribute := range attributes.SSHPrincipals {
+		for _, principal := range getValidCertificateNames(username,
+			attribute, values[attribute]) {
+			if !stringInList(principal, identity.SSHPrincipals) {
+				identity.SSHPrincipals = append(identity.SSHPrincipals,
+					principal)
+			}
+		}
+	}
+	if len(attributes.SSHPrincipals) > 0 && len(identity.SSHPrincipals) < 1 {
+		return identity, fmt.Errorf("no SSH principals for: %s", username)
+	}
+	if attribute := attributes.X509EmailAddresses; attribute != "" {
+		identity.EmailAddresses = getValidCertificateNames(username,
+			attribute, values[attribute])
+	}
+	if attribute := attributes.X509KerberosPrincipal; attribute != "" {
+		principals := getValidCertificateNames(username, attribute,
+			values[attribute])
+		if len(principals) < 1 {
+			return identity, fmt.Errorf("no Kerberos principal for: %s",
+				username)
+		}
+		identity.KerberosPrincipal = principals[0]
+	}
+	if attribute := attributes.X509UserPrincipalName; attribute != "" {
+		names := getValidCertificateNames(username, attribute,
+			values[attribute])
+		if len(names) > 0 {
+			identity.UserPrincipalName = names[0]
+		}
+	}
+	for _, claim := range attributes.Claims {
+		claimValues := getValidCertificateClaimValues(username,
+			claim.Attribute, values[claim.Attribute])
+		if len(claimValues) < 1 {
+			continue
+		}
+		if claim.X509 {
+			identity.X509Claims = append(identity.X509Claims,
+				certgen.Claim{Name: claim.getName(), Values: claimValues})
+		}
+		if claim.SSHExtension != "" {
+			if identity.SSHExtensions == nil {
+				identity.SSHExtensions = make(map[string]string)
+			}
+			identity.SSHExtensions[claim.SSHExtension] =
+				strings.Join(claimValues, ",")
+		}
+	}
+	return identity, nil
+}
+
+// getValidCertificateClaimValues drops values which are too long, contain
+// control characters, or would be ambiguous in an SSH extension.
+func getValidCertificateClaimValues(username, attribute string,
+	values []string) []string {
+	var claimValues []string
+	for _, value := range values {
+		if value == "" || len(value) > maxCertificateClaimLength ||
+			strings.Contains(value, ",") ||
+			strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			logger.Printf("ignoring invalid %s: %q of: %s",
+				attribute, value, username)
+			continue
+		}
+		claimValues = append(claimValues, value)
+	}
+	return claimValues
+}
+
+func getValidCertificateNames(username, attribute string,
+	values []string) []string {
+	var names []string
+	for _, value := range values {
+		if !validCertificateNameRE.MatchString(value) {
+			logger.Printf("ignoring invalid %s: %q of: %s",
+				attribute, value, username)
+			continue
+		}
+		names = append(names, value)
+	}
+	return names
+}
+
+func stringInList(value string, list []string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+// getLdapCertificateAttributes is like getLdapUserAttributes, but skips the
+// group lookup.
+func (state *RuntimeState) getLdapCertificateAttributes(username string,
+	attributes []string) (map[string][]string, error) {
+	queries := state.getLdapUserInfoQueries(username)
+	if len(queries) < 1 {
+		return nil, errors.New("no LDAP userinfo source")
+	}
+	for _, query := range queries {
+		attributeMap, err := state.getLdapAttributesFromSource(
+			query.source, query.username, attributes)
+		if err == nil {
+			return attributeMap, nil
+		}
+	}
+	return nil, errors.New("error getting the certificate attributes")
+}
+
+// getLdapAttributesFromSource returns authutil.ErrLDAPUserNotFound if the
+// directory does not have username.
+func (state *RuntimeState) getLdapAttributesFromSource(
+	ldapConfig UserInfoLDAPSource, username string, attributes []string) (
+	map[string][]string, error) {
+	var timeoutSecs uint
+	timeoutSecs = 2
+	for _, ldapUrl := range strings.Split(ldapConfig.LDAPTargetURLs, ",") {
+		if len(ldapUrl) < 1 {
+			continue
+		}
+		u, err := authutil.ParseLDAPURL(ldapUrl)
+		if err != nil {
+			logger.Printf("Failed to parse ldapurl '%s'", ldapUrl)
+			continue
+		}
+		breaker := state.backendBreakers.Get(u.Host)
+		if !breaker.Allow() {
+			continue
+		}
+		attributeMap, err := authutil.GetLDAPUserAttributes(*u,
+			ldapConfig.BindUsername, ldapConfig.BindPassword,
+			timeoutSecs, ldapConfig.getTLSConfig(), username,
+			ldapConfig.UserSearchBaseDNs, ldapConfig.UserSearchFilter,
+			attributes)
+		if err == authutil.ErrLDAPUserNotFound {
+			breaker.Success()
+			return nil, err
+		}
+		if err != nil {
+			breaker.Failure()
+			logger.Debugf(1, "error getting attributes of: %s from: %s: %s",
+				username, ldapUrl, err)
+			continue
+		}
+		breaker.Success()
+		return attributeMap, nil
+	}
+	return nil, errors.New("error getting the certificate attributes")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewCertificateIdentity(t *testing.T) {
+	attributes := LDAPCertificateAttributes{
+		SSHPrincipals:         []string{"uid", "sAMAccountName"},
+		X509EmailAddresses:    "mail",
+		X509KerberosPrincipal: "sAMAccountName",
+	}
+	identity, err := newCertificateIdentity("jsmith", attributes,
+		map[string][]string{
+			"uid":            {"jsmith"},
+			"sAMAccountName": {"JSmith", "jsmith", "bad name"},
+			"mail":           {"jsmith@example.com", "bad,mail"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "jsmith" ||
+		len(identity.SSHPrincipals) != 2 ||
+		identity.SSHPrincipals[0] != "jsmith" ||
+		identity.SSHPrincipals[1] != "JSmith" {
+		t.Fatalf("unexpected principals: %+v", identity)
+	}
+	if len(identity.EmailAddresses) != 1 ||
+		identity.EmailAddresses[0] != "jsmith@example.com" {
+		t.Fatalf("unexpected email addresses: %+v", identity)
+	}
+	if identity.KerberosPrincipal != "JSmith" {
+		t.Fatalf("unexpected Kerberos principal: %+v", identity)
+	}
+	// Users without principals must not get certificates for their username.
+	_, err = newCertificateIdentity("jsmith", attributes,
+		map[string][]string{"sAMAccountName": {"bad name"}})
+	if err == nil {
+		t.Fatal("no error for user without principals")
+	}
+}
+
+func TestGetCertificateIdentityDefault(t *testing.T) {
+	state := &RuntimeState{}
+	identity, err := state.getCertificateIdentity("jsmith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "jsmith" || len(identity.SSHPrincipals) != 0 {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+}
+
+func TestNewCertificateIdentityClaims(t *testing.T) {
+	attributes := LDAPCertificateAttributes{
+		Claims: []certificateClaimConfig{
+			{Attribute: "employeeNumber", Name: "employee-id", X509: true,
+				SSHExtension: "employee-id@example.com"},
+			{Attribute: "departmentNumber", X509: true},
+			{Attribute: "mail", SSHExtension: "mail@example.com"},
+			{Attribute: "missing", X509: true},
+		},
+	}
+	identity, err := newCertificateIdentity("jsmith", attributes,
+		map[string][]string{
+			"employeeNumber":   {"1234"},
+			"departmentNumber": {"R&D 42", "bad\nvalue", "bad,value"},
+			"mail":             {"a@example.com", "b@example.com"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identity.X509Claims) != 2 ||
+		identity.X509Claims[0].Name != "employee-id" ||
+		len(identity.X509Claims[0].Values) != 1 ||
+		identity.X509Claims[0].Values[0] != "1234" ||
+		identity.X509Claims[1].Name != "departmentNumber" ||
+		len(identity.X509Claims[1].Values) != 1 ||
+		identity.X509Claims[1].Values[0] != "R&D 42" {
+		t.Fatalf("unexpected X.509 claims: %+v", identity.X509Claims)
+	}
+	if len(identity.SSHExtensions) != 2 ||
+		identity.SSHExtensions["employee-id@example.com"] != "1234" ||
+		identity.SSHExtensions["mail@example.com"] !=
+			"a@example.com,b@example.com" {
+		t.Fatalf("unexpected SSH extensions: %+v", identity.SSHExtensions)
+	}
+}
+
+func TestCheckClaims(t *testing.T) {
+	invalidClaims := [][]certificateClaimConfig{
+		{{X509: true}},
+		{{Attribute: "employeeNumber"}},
+		{{Attribute: "employeeNumber", SSHExtension: "permit-pty"}},
+		{{Attribute: "mail", X509: true}, {Attribute: "mail", X509: true}},
+		{{Attribute: "uid", SSHExtension: "id@example.com"},
+			{Attribute: "mail", SSHExtension: "id@example.com"}},
+	}
+	for _, claims := range invalidClaims {
+		attributes := LDAPCertificateAttributes{Claims: claims}
+		if err := attributes.checkClaims(); err == nil {
+			t.Errorf("invalid claims accepted: %+v", claims)
+		}
+	}
+	attributes := LDAPCertificateAttributes{
+		Claims: []certificateClaimConfig{
+			{Attribute: "mail", X509: true},
+			{Attribute: "mail", Name: "email", X509: true,
+				SSHExtension: "email@example.com"},
+		},
+	}
+	if err := attributes.checkClaims(); err != nil {
+		t.Fatal(err)
+	}
+}
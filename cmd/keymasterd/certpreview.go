@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+	"golang.org/x/crypto/ssh"
+)
+
+// certificatePreview describes the certificate which would be issued for a
+// request with preview=true. Nothing is signed or recorded.
+type certificatePreview struct {
+	CertType              string            `json:"cert_type"`
+	AuditID               string            `json:"audit_id"`
+	KeyID                 string            `json:"key_id,omitempty"`
+	Principals            []string          `json:"principals,omitempty"`
+	Subject               string            `json:"subject,omitempty"`
+	Issuer                string            `json:"issuer,omitempty"`
+	EmailAddresses        []string          `json:"email_addresses,omitempty"`
+	UserPrincipalName     string            `json:"user_principal_name,omitempty"`
+	DNSNames              []string          `json:"dns_names,omitempty"`
+	URIs                  []string          `json:"uris,omitempty"`
+	Groups                []string          `json:"groups,omitempty"`
+	Claims                map[string]string `json:"claims,omitempty"`
+	Extensions            []string          `json:"extensions,omitempty"`
+	CriticalOptions       map[string]string `json:"critical_options,omitempty"`
+	ValidAfter            time.Time         `json:"valid_after"`
+	ValidBefore           time.Time         `json:"valid_before"`
+	PublicKeyFingerprint  string            `json:"public_key_fingerprint"`
+	SigningKeyFingerprint string            `json:"signing_key_fingerprint,omitempty"`
+}
+
+// writeSSHCertificatePreview writes the preview of the SSH certificate
+// writeSSHCertificate would issue.
+func (state *RuntimeState) writeSSHCertificatePreview(w http.ResponseWriter,
+	r *http.Request, identity certgen.UserIdentity, userPubKey string,
+	signer ssh.Signer, duration time.Duration, issuance issuanceContext) {
+	cert, err := certgen.PreviewSSHCertForIdentity(identity, userPubKey,
+		signer.PublicKey(), state.HostIdentity, issuance.AuditID, duration)
+	if err != nil {
+		logger.Printf("error previewing SSH certificate: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	preview := certificatePreview{
+		CertType:              "ssh",
+		AuditID:               issuance.AuditID,
+		KeyID:                 cert.KeyId,
+		Principals:            cert.ValidPrincipals,
+		CriticalOptions:       cert.CriticalOptions,
+		ValidAfter:            time.Unix(int64(cert.ValidAfter), 0),
+		ValidBefore:           time.Unix(int64(cert.ValidBefore), 0),
+		PublicKeyFingerprint:  ssh.FingerprintSHA256(cert.Key),
+		SigningKeyFingerprint: ssh.FingerprintSHA256(cert.SignatureKey),
+	}
+	for extension := range cert.Extensions {
+		preview.Extensions = append(preview.Extensions, extension)
+	}
+	if len(identity.SSHExtensions) > 0 {
+		preview.Claims = identity.SSHExtensions
+	}
+	sort.Strings(preview.Extensions)
+	state.writeCertificatePreview(w, preview)
+}
+
+// writeX509CertificatePreview writes the preview of the X.509 certificate
+// writeX509Certificate would issue.
+func (state *RuntimeState) writeX509CertificatePreview(w http.ResponseWriter,
+	r *http.Request, identity certgen.UserIdentity, userPub interface{},
+	caCert *x509.Certificate, certType string, duration time.Duration,
+	groups, organizations []string, issuance issuanceContext) {
+	template, err := certgen.PreviewUserX509CertForIdentity(identity, userPub,
+		caCert, state.KerberosRealm, duration, groups, organizations,
+		issuance.AuditID)
+	if err != nil {
+		logger.Printf("error previewing X.509 certificate: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	preview := certificatePreview{
+		CertType:             certType,
+		AuditID:              issuance.AuditID,
+		Subject:              template.Subject.String(),
+		Issuer:               template.Issuer.String(),
+		EmailAddresses:       identity.EmailAddresses,
+		UserPrincipalName:    identity.UserPrincipalName,
+		DNSNames:             identity.DNSNames,
+		Groups:               groups,
+		ValidAfter:           template.NotBefore,
+		ValidBefore:          template.NotAfter,
+		PublicKeyFingerprint: publicKeyFingerprint(userPub),
+	}
+	for _, extension := range template.ExtraExtensions {
+		preview.Extensions = append(preview.Extensions,
+			extension.Id.String())
+	}
+	for _, uri := range identity.URIs {
+		preview.URIs = append(preview.URIs, uri.String())
+	}
+	for _, claim := range identity.X509Claims {
+		if preview.Claims == nil {
+			preview.Claims = make(map[string]string)
+		}
+		preview.Claims[claim.Name] = strings.Join(claim.Values, ",")
+	}
+	state.writeCertificatePreview(w, preview)
+}
+
+func (state *RuntimeState) writeCertificatePreview(w http.ResponseWriter,
+	preview certificatePreview) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(preview); err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestCertificatePreview(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		query     string
+		publicKey string
+		certType  string
+	}{
+		{"?preview=true", testUserSSHPublicKey, "ssh"},
+		{"?type=x509&preview=true", testUserPEMPublicKey, "x509"},
+	} {
+		req, err := createKeyBodyRequest("POST", "/certgen/username"+
+			test.query, test.publicKey, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var preview certificatePreview
+		if err := json.NewDecoder(rr.Body).Decode(&preview); err != nil {
+			t.Fatalf("%s: %s", test.certType, err)
+		}
+		if preview.CertType != test.certType || preview.AuditID == "" ||
+			preview.PublicKeyFingerprint == "" ||
+			!preview.ValidBefore.After(preview.ValidAfter) {
+			t.Fatalf("unexpected preview: %+v", preview)
+		}
+		switch test.certType {
+		case "ssh":
+			if len(preview.Principals) != 1 ||
+				preview.Principals[0] != "username" ||
+				len(preview.Extensions) < 1 {
+				t.Fatalf("unexpected SSH preview: %+v", preview)
+			}
+		case "x509":
+			if preview.Subject != "CN=username,O=keymaster" {
+				t.Fatalf("unexpected X.509 preview: %+v", preview)
+			}
+		}
+	}
+}
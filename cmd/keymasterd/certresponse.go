@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// certgenResponse is the response to a certificate request from a client
+// which accepts JSON, rather than only the certificate as an attachment.
+type certgenResponse struct {
+	CertType      string    `json:"cert_type"`
+	Certificate   string    `json:"certificate"`
+	CAChain       string    `json:"ca_chain,omitempty"` // Cross-signed CAs.
+	Serial        string    `json:"serial"`
+	KeyID         string    `json:"key_id,omitempty"`
+	AuditID       string    `json:"audit_id"`
+	Fingerprint   string    `json:"fingerprint"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	CAFingerprint string    `json:"ca_fingerprint"`
+	PrivateKey    string    `json:"private_key,omitempty"` // Escrowed keys.
+}
+
+// wantsJSONResponse returns true if the client explicitly accepts JSON. Unlike
+// getPreferredAcceptType, a missing Accept header does not select JSON, so
+// existing clients keep getting the bare certificate.
+func wantsJSONResponse(r *http.Request) bool {
+	for _, acceptValue := range r.Header["Accept"] {
+		for _, mediaRange := range strings.Split(acceptValue, ",") {
+			mediaType, _, err := mime.ParseMediaType(mediaRange)
+			if err == nil && mediaType == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newSSHCertgenResponse describes cert, which is in authorized_keys format
+// in certString. Fingerprints are of the public keys, in ssh-keygen format,
+// like in the issued certificate records.
+func newSSHCertgenResponse(cert *ssh.Certificate, certString string,
+	issuance issuanceContext) certgenResponse {
+	return certgenResponse{
+		CertType:      "ssh",
+		Certificate:   certString,
+		Serial:        strconv.FormatUint(cert.Serial, 10),
+		KeyID:         cert.KeyId,
+		AuditID:       issuance.AuditID,
+		Fingerprint:   ssh.FingerprintSHA256(cert.Key),
+		NotBefore:     time.Unix(int64(cert.ValidAfter), 0),
+		NotAfter:      time.Unix(int64(cert.ValidBefore), 0),
+		CAFingerprint: ssh.FingerprintSHA256(cert.SignatureKey),
+	}
+}
+
+func newX509CertgenResponse(certType string, cert *x509.Certificate,
+	caCert *x509.Certificate, caChain string,
+	issuance issuanceContext) certgenResponse {
+	return certgenResponse{
+		CertType:      certType,
+		Certificate:   encodeCertificatePEM(cert.Raw),
+		CAChain:       caChain,
+		Serial:        cert.SerialNumber.String(),
+		AuditID:       issuance.AuditID,
+		Fingerprint:   publicKeyFingerprint(cert.PublicKey),
+		NotBefore:     cert.NotBefore,
+		NotAfter:      cert.NotAfter,
+		CAFingerprint: publicKeyFingerprint(caCert.PublicKey),
+	}
+}
+
+func writeCertgenResponse(w http.ResponseWriter, response certgenResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+)
+
+func TestWantsJSONResponse(t *testing.T) {
+	tests := map[string]bool{
+		"":                                   false,
+		"*/*":                                false,
+		"text/html,application/xhtml+xml":    false,
+		"application/json":                   true,
+		"text/plain, application/json;q=0.9": true,
+	}
+	for accept, expected := range tests {
+		req := httptest.NewRequest("POST", "/certgen/username", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if wantsJSONResponse(req) != expected {
+			t.Errorf("%q: expected: %v", accept, expected)
+		}
+	}
+}
+
+func TestCertgenJSONResponse(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	err = state.loadSignersFromPemData([]byte(testSignerPrivateKey),
+		[]byte(pkcs8Ed25519PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.AllowedAuthBackendsForCerts = append(
+		state.Config.Base.AllowedAuthBackendsForCerts, proto.AuthTypePassword)
+	cookieVal, err := state.setNewAuthCookie(nil, "username",
+		AuthTypePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(certType, publicKey string) certgenResponse {
+		body, err := json.Marshal(certRequest{
+			Type:      certType,
+			Duration:  "1h",
+			PublicKey: publicKey,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", "/certgen/username",
+			strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType := rr.Header().Get("Content-Type"); contentType !=
+			"application/json" {
+			t.Fatalf("unexpected Content-Type: %s", contentType)
+		}
+		var response certgenResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.CertType != certType || response.Serial == "" ||
+			response.AuditID == "" || response.Fingerprint == "" ||
+			response.CAFingerprint == "" {
+			t.Fatalf("incomplete response: %+v", response)
+		}
+		if lifetime := response.NotAfter.Sub(response.NotBefore); lifetime <
+			59*time.Minute || lifetime > 2*time.Hour {
+			t.Fatalf("unexpected validity: %s to %s", response.NotBefore,
+				response.NotAfter)
+		}
+		return response
+	}
+	response := request("ssh", testEd25519PublicSSH)
+	if !strings.HasPrefix(response.Certificate,
+		"ssh-ed25519-cert-v01@openssh.com ") || response.KeyID == "" {
+		t.Fatalf("unexpected SSH response: %+v", response)
+	}
+	response = request("x509", testUserPEMPublicKey)
+	if block, _ := pem.Decode([]byte(response.Certificate)); block == nil ||
+		block.Type != "CERTIFICATE" {
+		t.Fatalf("unexpected X.509 response: %+v", response)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/certstream"
+	"golang.org/x/crypto/ssh"
+)
+
+// streamSSHCertificate sends the event for cert, issued for username, to
+// the certificate stream, if one is configured.
+func (state *RuntimeState) streamSSHCertificate(username string,
+	cert *ssh.Certificate, r *http.Request, issuance issuanceContext) {
+	event := certstream.NewSSHEvent(username, cert)
+	event.SourceAddr = r.RemoteAddr
+	event.AuditID = issuance.AuditID
+	state.certStream.Emit(event)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/Cloud-Foundations/keymaster/lib/circuitbreaker"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// storageBackendName names the circuit breaker of the primary database.
+const storageBackendName = "storage"
+
+var backendCircuitOpenGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "keymaster_backend_circuit_open",
+		Help: "1 if requests to the backend are skipped after repeated failures.",
+	},
+	[]string{"backend"},
+)
+
+func init() {
+	prometheus.MustRegister(backendCircuitOpenGauge)
+}
+
+// setupBackendBreakers creates the circuit breakers shared by the LDAP
+// servers and the primary database.
+func (state *RuntimeState) setupBackendBreakers() {
+	state.backendBreakers = circuitbreaker.New(
+		state.Config.CircuitBreaker, state.logger,
+		func(name string, open bool) {
+			if open {
+				state.logger.Printf("backend %s failing, circuit opened\n",
+					name)
+				backendCircuitOpenGauge.WithLabelValues(name).Set(1)
+			} else {
+				state.logger.Printf("backend %s recovered, circuit closed\n",
+					name)
+				backendCircuitOpenGauge.WithLabelValues(name).Set(0)
+			}
+		})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+)
+
+// clientUpdateConfig describes the latest keymaster client release, which
+// clients check for after obtaining their certificates.
+type clientUpdateConfig struct {
+	Version string `yaml:"version"`
+	// Clients older than this warn that they must be updated.
+	MinimumVersion  string                 `yaml:"minimum_version"`
+	ReleaseNotesURL string                 `yaml:"release_notes_url"`
+	Downloads       []clientDownloadConfig `yaml:"downloads"`
+}
+
+type clientDownloadConfig struct {
+	OS     string `yaml:"os"`
+	Arch   string `yaml:"arch"`
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
+}
+
+func (config *clientUpdateConfig) check() error {
+	if config.Version == "" {
+		if len(config.Downloads) > 0 || config.MinimumVersion != "" {
+			return errors.New("client_updates: missing version")
+		}
+		return nil
+	}
+	for _, download := range config.Downloads {
+		if download.OS == "" || download.Arch == "" {
+			return errors.New("client_updates: download missing os or arch")
+		}
+		u, err := url.Parse(download.URL)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("client_updates: download URL not https: %s",
+				download.URL)
+		}
+		if sum, err := hex.DecodeString(download.SHA256); err != nil ||
+			len(sum) != 32 {
+			return fmt.Errorf("client_updates: bad sha256 for: %s",
+				download.URL)
+		}
+	}
+	return nil
+}
+
+func (state *RuntimeState) getClientUpdateManifestJSON() ([]byte, error) {
+	config := state.Config.ClientUpdates
+	manifest := proto.ClientUpdateManifest{
+		Version:         config.Version,
+		MinimumVersion:  config.MinimumVersion,
+		ReleaseNotesURL: config.ReleaseNotesURL,
+		Downloads:       make([]proto.ClientDownload, 0, len(config.Downloads)),
+	}
+	for _, download := range config.Downloads {
+		manifest.Downloads = append(manifest.Downloads, proto.ClientDownload{
+			OS:     download.OS,
+			Arch:   download.Arch,
+			URL:    download.URL,
+			SHA256: download.SHA256,
+		})
+	}
+	return json.MarshalIndent(manifest, "", "  ")
+}
+
+// writeClientUpdateManifest writes the client update manifest, or its
+// detached signature, so that clients can check for new releases.
+func (state *RuntimeState) writeClientUpdateManifest(w http.ResponseWriter,
+	r *http.Request, signature bool) {
+	if state.Config.ClientUpdates.Version == "" {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	document, err := state.getClientUpdateManifestJSON()
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if !signature {
+		writeWithETag(w, r, "application/json", document)
+		return
+	}
+	jws, err := state.signDetached(document)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/jose")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, jws)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const testClientSHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestClientUpdateConfigCheck(t *testing.T) {
+	download := clientDownloadConfig{
+		OS:     "linux",
+		Arch:   "amd64",
+		URL:    "https://downloads.example.com/keymaster",
+		SHA256: testClientSHA256,
+	}
+	badURL := download
+	badURL.URL = "http://downloads.example.com/keymaster"
+	badSum := download
+	badSum.SHA256 = "e3b0"
+	tests := []struct {
+		config clientUpdateConfig
+		valid  bool
+	}{
+		{clientUpdateConfig{}, true},
+		{clientUpdateConfig{MinimumVersion: "1.0.0"}, false},
+		{clientUpdateConfig{Version: "1.1.0",
+			Downloads: []clientDownloadConfig{download}}, true},
+		{clientUpdateConfig{Version: "1.1.0",
+			Downloads: []clientDownloadConfig{badURL}}, false},
+		{clientUpdateConfig{Version: "1.1.0",
+			Downloads: []clientDownloadConfig{badSum}}, false},
+	}
+	for index, test := range tests {
+		if err := test.config.check(); (err == nil) != test.valid {
+			t.Errorf("%d: expected valid: %v, got: %v", index, test.valid, err)
+		}
+	}
+}
+
+func TestClientUpdateManifest(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	req, err := http.NewRequest("GET", proto.ClientUpdatePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.ClientUpdates = clientUpdateConfig{
+		Version:        "1.1.0",
+		MinimumVersion: "1.0.0",
+		Downloads: []clientDownloadConfig{{
+			OS:     "linux",
+			Arch:   "amd64",
+			URL:    "https://downloads.example.com/keymaster",
+			SHA256: testClientSHA256,
+		}},
+	}
+	rr, err := checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := rr.Body.Bytes()
+	var manifest proto.ClientUpdateManifest
+	if err := json.Unmarshal(document, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Version != "1.1.0" || len(manifest.Downloads) != 1 ||
+		manifest.Downloads[0].SHA256 != testClientSHA256 {
+		t.Fatalf("unexpected manifest: %s", document)
+	}
+	req, err = http.NewRequest("GET", proto.ClientUpdatePath+".jws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimSpace(rr.Body.String()), ".")
+	if len(parts) != 3 || parts[1] != "" {
+		t.Fatalf("not a detached JWS: %s", rr.Body)
+	}
+	parts[1] = base64.RawURLEncoding.EncodeToString(document)
+	jws, err := jose.ParseSigned(strings.Join(parts, "."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jws.Verify(state.Signer.Public()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
@@ -32,7 +35,21 @@ import (
 	"github.com/Cloud-Foundations/golib/pkg/log"
 	"github.com/Cloud-Foundations/golib/pkg/watchdog"
 	"github.com/Cloud-Foundations/keymaster/keymasterd/admincache"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/alerting"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/captcha"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/certreloader"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/certstream"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/deviceposture"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/faultinjection"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/i18n"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/instanceidentity"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/kubesigner"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/publisher"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/requestlimiter"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/signingpool"
 	"github.com/Cloud-Foundations/keymaster/lib/authenticators/okta"
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+	"github.com/Cloud-Foundations/keymaster/lib/circuitbreaker"
 	"github.com/Cloud-Foundations/keymaster/lib/pwauth/command"
 	"github.com/Cloud-Foundations/keymaster/lib/pwauth/htpassword"
 	"github.com/Cloud-Foundations/keymaster/lib/pwauth/ldap"
@@ -48,14 +65,21 @@ import (
 type autoUnseal struct {
 	AwsSecretId  string `yaml:"aws_secret_id"`
 	AwsSecretKey string `yaml:"aws_secret_key"`
+	// SecureString parameter, decrypted with its KMS key.
+	AwsSSMParameter string `yaml:"aws_ssm_parameter"`
+	// projects/PROJECT/secrets/SECRET[/versions/VERSION]
+	GcpSecret string `yaml:"gcp_secret"`
 }
 
 type baseConfig struct {
-	HttpAddress                  string `yaml:"http_address"`
-	AdminAddress                 string `yaml:"admin_address"`
-	HttpRedirectPort             uint16 `yaml:"http_redirect_port"`
-	TLSCertFilename              string `yaml:"tls_cert_filename"`
-	TLSKeyFilename               string `yaml:"tls_key_filename"`
+	HttpAddress                  string            `yaml:"http_address"`
+	AdminAddress                 string            `yaml:"admin_address"`
+	AdminSocket                  adminSocketConfig `yaml:"admin_socket"`
+	MetricsAddress               string            `yaml:"metrics_address"`
+	DisableMetricsListener       bool              `yaml:"disable_metrics_listener"`
+	HttpRedirectPort             uint16            `yaml:"http_redirect_port"`
+	TLSCertFilename              string            `yaml:"tls_cert_filename"`
+	TLSKeyFilename               string            `yaml:"tls_key_filename"`
 	ACME                         acmecfg.AcmeConfig
 	SSHCAFilename                string     `yaml:"ssh_ca_filename"`
 	Ed25519CAFilename            string     `yaml:"ed25519_ca_keyfilename"`
@@ -80,11 +104,28 @@ type baseConfig struct {
 	DisableUsernameNormalization bool       `yaml:"disable_username_normalization"`
 	EnableLocalTOTP              bool       `yaml:"enable_local_totp"`
 	EnableBootstrapOTP           bool       `yaml:"enable_bootstrapotp"`
+	TrustedProxies               []string   `yaml:"trusted_proxies"`
+	EnableProxyProtocol          bool       `yaml:"enable_proxy_protocol"`
+	DisableHTTP2                 bool       `yaml:"disable_http2"`
+	MaxSessions                  int        `yaml:"max_sessions"`
+	AllowCertRenewal             bool       `yaml:"allow_cert_renewal"`
+	MinRSAKeyBits                int        `yaml:"min_rsa_key_bits"`
+	SSHSignatureAlgorithm        string     `yaml:"ssh_signature_algorithm"`  // Default: rsa-sha2-512.
+	X509SignatureAlgorithm       string     `yaml:"x509_signature_algorithm"` // Default: depends on CA key.
+}
+
+type BrandingConfig struct {
+	ProductName     string `yaml:"product_name"`
+	LogoURL         string `yaml:"logo_url"`
+	FooterText      string `yaml:"footer_text"`
+	DefaultLanguage string `yaml:"default_language"`
 }
 
 type emailConfig struct {
 	configuredemail.EmailConfig `yaml:",inline"`
 	Domain                      string
+	From                        string   `yaml:"from"`
+	NotifyUsersOf               []string `yaml:"notify_users_of"`
 }
 
 type GitDatabaseConfig struct {
@@ -93,9 +134,27 @@ type GitDatabaseConfig struct {
 }
 
 type LdapConfig struct {
-	BindPattern          string `yaml:"bind_pattern"`
-	LDAPTargetURLs       string `yaml:"ldap_target_urls"`
-	DisablePasswordCache bool   `yaml:"disable_password_cache"`
+	BindPattern          string            `yaml:"bind_pattern"`
+	LDAPTargetURLs       string            `yaml:"ldap_target_urls"`
+	DisablePasswordCache bool              `yaml:"disable_password_cache"`
+	Realms               []LdapRealmConfig `yaml:"realms"`
+	LDAPTLSConfig        `yaml:",inline"`
+}
+
+// LdapRealmConfig is an additional directory, for organizations with
+// several. Users are selected by username suffix, else each realm is tried
+// in order, after the default one.
+type LdapRealmConfig struct {
+	Name             string             `yaml:"name"`
+	BindPattern      string             `yaml:"bind_pattern"`
+	LDAPTargetURLs   string             `yaml:"ldap_target_urls"`
+	UsernameSuffixes []string           `yaml:"username_suffixes"`
+	UserInfo         UserInfoLDAPSource `yaml:"userinfo"`
+	LDAPTLSConfig    `yaml:",inline"`
+}
+
+func (config *LdapConfig) enabled() bool {
+	return len(config.LDAPTargetURLs) > 0 || len(config.Realms) > 0
 }
 
 type OktaConfig struct {
@@ -114,6 +173,51 @@ type UserInfoLDAPSource struct {
 	UserSearchFilter   string   `yaml:"user_search_filter"`
 	GroupSearchBaseDNs []string `yaml:"group_search_base_dns"`
 	GroupSearchFilter  string   `yaml:"group_search_filter"`
+	// Where certificate names come from, if not the username.
+	CertificateAttributes LDAPCertificateAttributes `yaml:"certificate_attributes"`
+	LDAPTLSConfig         `yaml:",inline"`
+}
+
+// LDAPTLSConfig is how the servers of a directory are trusted, and how
+// keymaster authenticates itself to directories requiring mutual TLS.
+// ldap:// URLs are always upgraded with StartTLS.
+type LDAPTLSConfig struct {
+	CAFilename         string   `yaml:"ca_filename"`
+	PinnedPublicKeys   []string `yaml:"pinned_public_keys"`
+	ClientCertFilename string   `yaml:"client_cert_filename"`
+	ClientKeyFilename  string   `yaml:"client_key_filename"`
+	tlsConfig          *tls.Config
+}
+
+// LDAPCertificateAttributes names the LDAP attributes of the user which
+// certificate names are taken from. All values of the attributes are used,
+// except for the Kerberos principal. The X.509 common name is always the
+// username, since keymaster identifies users by it. Claims are other
+// attributes which are embedded into the certificates.
+type LDAPCertificateAttributes struct {
+	SSHPrincipals         []string                 `yaml:"ssh_principals"`
+	X509EmailAddresses    string                   `yaml:"x509_email_addresses"`
+	X509KerberosPrincipal string                   `yaml:"x509_kerberos_principal"`
+	X509UserPrincipalName string                   `yaml:"x509_user_principal_name"`
+	Claims                []certificateClaimConfig `yaml:"claims"`
+}
+
+func (attributes *LDAPCertificateAttributes) list() []string {
+	var list []string
+	list = append(list, attributes.SSHPrincipals...)
+	if attributes.X509EmailAddresses != "" {
+		list = append(list, attributes.X509EmailAddresses)
+	}
+	if attributes.X509KerberosPrincipal != "" {
+		list = append(list, attributes.X509KerberosPrincipal)
+	}
+	if attributes.X509UserPrincipalName != "" {
+		list = append(list, attributes.X509UserPrincipalName)
+	}
+	for _, claim := range attributes.Claims {
+		list = append(list, claim.Attribute)
+	}
+	return list
 }
 
 type UserInfoSouces struct {
@@ -145,6 +249,19 @@ type OpenIDConnectIDPConfig struct {
 	Client             []OpenIDConnectClientConfig `yaml:"clients"`
 }
 
+type HostBootstrapTokenConfig struct {
+	Token              string `yaml:"token"`
+	AllowedHostnamesRE string `yaml:"allowed_hostnames_re"`
+	allowedHostnames   *regexp.Regexp
+}
+
+type HostCertificatesConfig struct {
+	BootstrapTokens   []HostBootstrapTokenConfig `yaml:"bootstrap_tokens"`
+	InstanceIdentity  instanceidentity.Config    `yaml:"instance_identity"`
+	Lifetime          time.Duration              `yaml:"lifetime"`
+	KnownHostsPattern string                     `yaml:"known_hosts_pattern"` // Default: "*".
+}
+
 type ProfileStorageConfig struct {
 	AwsSecretId         string        `yaml:"aws_secret_id"`
 	ConnectionLifetime  time.Duration `yaml:"connection_lifetime"`
@@ -163,25 +280,69 @@ type SymantecVIPConfig struct {
 }
 
 type AppConfigFile struct {
-	Base             baseConfig
-	DnsLoadBalancer  dnslbcfg.Config `yaml:"dns_load_balancer"`
-	Watchdog         watchdog.Config `yaml:"watchdog"`
-	Email            emailConfig
-	Ldap             LdapConfig
-	Okta             OktaConfig
-	UserInfo         UserInfoSouces `yaml:"userinfo_sources"`
-	Oauth2           Oauth2Config
-	OpenIDConnectIDP OpenIDConnectIDPConfig `yaml:"openid_connect_idp"`
-	SymantecVIP      SymantecVIPConfig
-	ProfileStorage   ProfileStorageConfig
+	Base                 baseConfig
+	Branding             BrandingConfig   `yaml:"branding"`
+	DnsLoadBalancer      dnslbcfg.Config  `yaml:"dns_load_balancer"`
+	Listeners            []listenerConfig `yaml:"listeners"`
+	HTTPServer           httpServerConfig `yaml:"http_server"`
+	Watchdog             watchdog.Config  `yaml:"watchdog"`
+	Email                emailConfig
+	Ldap                 LdapConfig
+	Okta                 OktaConfig
+	UserInfo             UserInfoSouces `yaml:"userinfo_sources"`
+	Oauth2               Oauth2Config
+	OpenIDConnectIDP     OpenIDConnectIDPConfig `yaml:"openid_connect_idp"`
+	SymantecVIP          SymantecVIPConfig
+	ProfileStorage       ProfileStorageConfig
+	KubernetesSigner     kubesigner.Config       `yaml:"kubernetes_signer"`
+	Notifications        alerting.Config         `yaml:"notifications"`
+	HostCertificates     HostCertificatesConfig  `yaml:"host_certificates"`
+	DevicePosture        deviceposture.Config    `yaml:"device_posture"`
+	RevocationPublishing publisher.Config        `yaml:"revocation_publishing"`
+	SigningPool          signingpool.Config      `yaml:"signing_pool"`
+	APITokens            apiTokenConfig          `yaml:"api_tokens"`
+	ServiceTokens        serviceTokenConfig      `yaml:"service_tokens"`
+	Usernames            usernameConfig          `yaml:"usernames"`
+	PasswordChange       passwordChangeConfig    `yaml:"password_change"`
+	AccountStatus        accountStatusConfig     `yaml:"account_status"`
+	TrustedDevices       trustedDevicesConfig    `yaml:"trusted_devices"`
+	U2F                  u2fConfig               `yaml:"u2f"`
+	Tenants              []tenantConfig          `yaml:"tenants"`
+	X509CrossSigning     x509CrossSigningConfig  `yaml:"x509_cross_signing"`
+	ClientUpdates        clientUpdateConfig      `yaml:"client_updates"`
+	FIPS                 fipsConfig              `yaml:"fips"`
+	RequestLimits        requestlimiter.Config   `yaml:"request_limits"`
+	DualControlUnseal    dualControlUnsealConfig `yaml:"dual_control_unseal"`
+	SealedAlert          sealedAlertConfig       `yaml:"sealed_alert"`
+	AuditChain           auditChainConfig        `yaml:"audit_chain"`
+	IssuedCertificates   issuedCertConfig        `yaml:"issued_certificates"`
+	CORS                 corsConfig              `yaml:"cors"`
+	Cookies              cookiesConfig           `yaml:"cookies"`
+	CircuitBreaker       circuitbreaker.Config   `yaml:"circuit_breaker"`
+	PasswordBackend      passwordBackendConfig   `yaml:"password_backend"`
+	X509UserSANs         x509SANConfig           `yaml:"x509_user_sans"`
+	X509KeyEscrow        keyEscrowConfig         `yaml:"x509_key_escrow"`
+	SSHSourceAddress     sshSourceAddressConfig  `yaml:"ssh_source_address"`
+	X509IssuanceContext  x509ContextConfig       `yaml:"x509_issuance_context"`
+	IssuanceQuota        issuanceQuotaConfig     `yaml:"issuance_quota"`
+	ProfileGC            profileGCConfig         `yaml:"profile_gc"`
+	LoginCaptcha         captcha.Config          `yaml:"login_captcha"`
+	Honeytokens          honeytokenConfig        `yaml:"honeytokens"`
+	FaultInjection       faultinjection.Config   `yaml:"fault_injection"`
+	CertificateStream    certstream.Config       `yaml:"certificate_stream"`
+	RemoteFiles          []remoteFileConfig      `yaml:"remote_files"`
 }
 
 const (
 	defaultRSAKeySize                  = 3072
 	defaultSecsBetweenDependencyChecks = 60
 	defaultOktaUsernameFilterRegexp    = "@.*"
+	defaultTLSCertFilename             = "server-cert.pem"
+	defaultTLSKeyFilename              = "server-key.pem"
 )
 
+var tlsCertReloadInterval = time.Minute
+
 func (state *RuntimeState) loadTemplates() (err error) {
 	templatesPath := filepath.Join(state.Config.Base.SharedDataDirectory,
 		"customization_data", "templates")
@@ -189,7 +350,7 @@ func (state *RuntimeState) loadTemplates() (err error) {
 		return err
 	}
 	// Load HTML template files.
-	state.htmlTemplate = htmltemplate.New("main")
+	state.htmlTemplate = htmltemplate.New("main").Funcs(state.templateFuncs())
 	htmlTemplateFiles := []string{"footer_extra.tmpl", "header_extra.tmpl",
 		"login_extra.tmpl"}
 	for _, templateFilename := range htmlTemplateFiles {
@@ -198,10 +359,20 @@ func (state *RuntimeState) loadTemplates() (err error) {
 			return err
 		}
 	}
+	// Load the translations of the built-in templates, if any.
+	state.messageCatalog = i18n.New(state.Config.Branding.DefaultLanguage)
+	messagesPath := filepath.Join(state.Config.Base.SharedDataDirectory,
+		"customization_data", "messages")
+	if _, err := os.Stat(messagesPath); err == nil {
+		if err := state.messageCatalog.LoadDirectory(messagesPath); err != nil {
+			return err
+		}
+	}
 	// Load the built-in HTML templates.
 	htmlTemplates := []string{footerTemplateText, loginFormText,
 		secondFactorAuthFormText, profileHTML, usersHTML, headerTemplateText,
-		newTOTPHTML, newBootstrapOTPPHTML,
+		newTOTPHTML, newBootstrapOTPPHTML, errorPageHTML, devicesHTML,
+		adminUserHTML, passwordHTML,
 	}
 	for _, templateString := range htmlTemplates {
 		_, err = state.htmlTemplate.Parse(templateString)
@@ -211,7 +382,8 @@ func (state *RuntimeState) loadTemplates() (err error) {
 	}
 	state.textTemplates = texttemplate.New("text")
 	// Load the built-in text templates.
-	textTemplates := []string{emailAdminTemplateData, emailUserTemplateData}
+	textTemplates := []string{emailAdminTemplateData, emailUserTemplateData,
+		emailSecurityTemplateData}
 	for _, templateString := range textTemplates {
 		_, err = state.textTemplates.Parse(templateString)
 		if err != nil {
@@ -219,7 +391,7 @@ func (state *RuntimeState) loadTemplates() (err error) {
 		}
 	}
 	// Load text template files, which may override the built-in templates.
-	textTemplateFiles := []string{"bootstrapOtpEmail.tmpl"}
+	textTemplateFiles := []string{"bootstrapOtpEmail.tmpl", "securityEmail.tmpl"}
 	for _, templateFilename := range textTemplateFiles {
 		templatePath := filepath.Join(templatesPath, templateFilename)
 		if _, err = state.textTemplates.ParseFiles(templatePath); err != nil {
@@ -273,8 +445,10 @@ func warnInsecureConfiguration(state *RuntimeState) {
 }
 
 func (state *RuntimeState) loadSignersFromPemData(signerPem, ed25519Pem []byte) error {
+	var edSigner crypto.Signer
 	if ed25519Pem != nil && len(ed25519Pem) > 0 {
-		edSigner, err := getSignerFromPEMBytes(ed25519Pem)
+		var err error
+		edSigner, err = getSignerFromPEMBytes(ed25519Pem)
 		if err != nil {
 			return err
 		}
@@ -284,7 +458,6 @@ func (state *RuntimeState) loadSignersFromPemData(signerPem, ed25519Pem []byte)
 		default:
 			return fmt.Errorf("Ed2559 configred file is not really an Ed25519 key. Type is %T!\n", v)
 		}
-		state.Ed25519Signer = edSigner
 	}
 	signer, err := getSignerFromPEMBytes(signerPem)
 	if err != nil {
@@ -299,11 +472,50 @@ func (state *RuntimeState) loadSignersFromPemData(signerPem, ed25519Pem []byte)
 	default:
 		return fmt.Errorf("Signer file is a valid Signer key. Type is %T!\n", v)
 	}
-	state.caCertDer, err = generateCADer(state, signer)
+	return state.setSigners(signer, edSigner)
+}
+
+// setSigners generates the CA certificate and the SSH signers for the CA
+// keys, so that they are not rebuilt for every request, and then installs
+// the keys. The SSH signers use the signing pool with its default timeout.
+func (state *RuntimeState) setSigners(signer, edSigner crypto.Signer) error {
+	if err := state.checkFIPSSigners(signer, edSigner); err != nil {
+		return err
+	}
+	caCertDer, err := generateCADer(state, signer)
 	if err != nil {
 		state.logger.Printf("Cannot generate CA DER")
 		return err
 	}
+	caCert, err := x509.ParseCertificate(caCertDer)
+	if err != nil {
+		return err
+	}
+	state.checkCrossSignedCACerts(caCert)
+	sshSigner, err := ssh.NewSignerFromSigner(state.signingPool.Signer(
+		context.Background(), state.faultInjector.Signer(signer)))
+	if err != nil {
+		return err
+	}
+	sshSigner, err = certgen.NewSSHSigner(sshSigner,
+		state.Config.Base.SSHSignatureAlgorithm)
+	if err != nil {
+		return err
+	}
+	var ed25519SSHSigner ssh.Signer
+	if edSigner != nil {
+		ed25519SSHSigner, err = ssh.NewSignerFromSigner(
+			state.signingPool.Signer(context.Background(),
+				state.faultInjector.Signer(edSigner)))
+		if err != nil {
+			return err
+		}
+	}
+	state.caCertDer = caCertDer
+	state.caCert = caCert
+	state.sshSigner = sshSigner
+	state.Ed25519Signer = edSigner
+	state.ed25519SSHSigner = ed25519SSHSigner
 	// Assignment of signer MUST be the last operation after
 	// all error checks
 	state.Signer = signer
@@ -351,12 +563,6 @@ func (state *RuntimeState) tryLoadAndVerifySigners() error {
 
 func loadVerifyConfigFile(configFilename string,
 	logger log.DebugLogger) (*RuntimeState, error) {
-	runtimeState := RuntimeState{
-		isAdminCache: admincache.New(5 * time.Minute),
-		logger:       logger,
-	}
-	runtimeState.initEmailDefaults()
-	runtimeState.Config.Watchdog.SetDefaults()
 	if _, err := os.Stat(configFilename); os.IsNotExist(err) {
 		err = errors.New("mising config file failure")
 		return nil, err
@@ -365,7 +571,18 @@ func loadVerifyConfigFile(configFilename string,
 	if err != nil {
 		return nil, fmt.Errorf("cannot read config file: %s", err)
 	}
-	err = yaml.Unmarshal(source, &runtimeState.Config)
+	return loadVerifyConfig(source, logger)
+}
+
+func loadVerifyConfig(source []byte,
+	logger log.DebugLogger) (*RuntimeState, error) {
+	runtimeState := RuntimeState{
+		isAdminCache: admincache.New(5 * time.Minute),
+		logger:       logger,
+	}
+	runtimeState.initEmailDefaults()
+	runtimeState.Config.Watchdog.SetDefaults()
+	err := yaml.Unmarshal(source, &runtimeState.Config)
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse config file: %s", err)
 	}
@@ -388,15 +605,15 @@ func loadVerifyConfigFile(configFilename string,
 		}
 	}
 	runtimeState.Config.Base.AutoUnseal.applyDefaults()
+	if err := runtimeState.Config.Base.AutoUnseal.check(); err != nil {
+		return nil, err
+	}
 	if err := runtimeState.expandStorageUrl(); err != nil {
 		logger.Println(err)
 	}
-	// TODO: This assumes httpAddress is just the port..
-	u2fAppID = "https://" + runtimeState.HostIdentity
-	if runtimeState.Config.Base.HttpAddress != ":443" {
-		u2fAppID = u2fAppID + runtimeState.Config.Base.HttpAddress
+	if err := runtimeState.setupU2FIdentity(); err != nil {
+		return nil, err
 	}
-	u2fTrustedFacets = append(u2fTrustedFacets, u2fAppID)
 
 	if len(runtimeState.Config.Base.KerberosRealm) > 0 {
 		runtimeState.KerberosRealm = &runtimeState.Config.Base.KerberosRealm
@@ -404,6 +621,27 @@ func loadVerifyConfigFile(configFilename string,
 	if err := runtimeState.setupCertificateManager(); err != nil {
 		return nil, err
 	}
+	if err := runtimeState.validateListeners(); err != nil {
+		return nil, err
+	}
+	err = runtimeState.Config.Base.AdminSocket.check(
+		runtimeState.Config.Base.AdminAddress)
+	if err != nil {
+		return nil, err
+	}
+	if err := runtimeState.validateHTTPServerConfig(); err != nil {
+		return nil, err
+	}
+	if err := runtimeState.setupUsernameNormalization(); err != nil {
+		return nil, err
+	}
+	if err := runtimeState.Config.setupLDAPTLS(); err != nil {
+		return nil, err
+	}
+	runtimeState.sessions.capacity = runtimeState.Config.Base.MaxSessions
+	if err := runtimeState.parseTrustedProxies(); err != nil {
+		return nil, err
+	}
 	sshCAFilename := runtimeState.Config.Base.SSHCAFilename
 	runtimeState.SSHCARawFileContent, err = exitsAndCanRead(sshCAFilename, "ssh CA File")
 	if err != nil {
@@ -466,6 +704,107 @@ func loadVerifyConfigFile(configFilename string,
 
 		}
 	}
+	if err := runtimeState.loadCrossSignedCACerts(); err != nil {
+		return nil, err
+	}
+	if err := runtimeState.Config.ClientUpdates.check(); err != nil {
+		return nil, err
+	}
+	if runtimeState.Config.SealedAlert.GracePeriod < 0 {
+		return nil, errors.New("sealed_alert: negative grace_period")
+	}
+	if err := runtimeState.Config.Cookies.check(); err != nil {
+		return nil, err
+	}
+	if err := runtimeState.Config.CORS.check(); err != nil {
+		return nil, err
+	}
+	err = runtimeState.Config.UserInfo.Ldap.CertificateAttributes.checkClaims()
+	if err != nil {
+		return nil, err
+	}
+	if err := runtimeState.Config.X509UserSANs.check(); err != nil {
+		return nil, err
+	}
+	if err := runtimeState.Config.X509KeyEscrow.check(); err != nil {
+		return nil, err
+	}
+	if err := runtimeState.loadKeyEscrowRecipients(); err != nil {
+		return nil, err
+	}
+	if err := runtimeState.Config.SSHSourceAddress.check(); err != nil {
+		return nil, err
+	}
+	if runtimeState.Config.IssuedCertificates.Retention < 0 {
+		return nil, errors.New("issued_certificates: negative retention")
+	}
+	issuanceQuota := runtimeState.Config.IssuanceQuota
+	if issuanceQuota.Window < 0 {
+		return nil, errors.New("issuance_quota: negative window")
+	}
+	if issuanceQuota.MaxCertificates > 0 && issuanceQuota.getWindow() >
+		runtimeState.Config.IssuedCertificates.getRetention() {
+		return nil, errors.New(
+			"issuance_quota: window longer than issued certificate retention")
+	}
+	if err := runtimeState.Config.ProfileGC.check(); err != nil {
+		return nil, err
+	}
+	if err := runtimeState.Config.Honeytokens.check(); err != nil {
+		return nil, err
+	}
+	if runtimeState.Config.AuditChain.AnchorInterval < 0 {
+		return nil, errors.New("audit_chain: negative anchor_interval")
+	}
+	err = runtimeState.Config.DualControlUnseal.check(
+		runtimeState.Config.Base.AutoUnseal)
+	if err != nil {
+		return nil, err
+	}
+	if minBits := runtimeState.Config.Base.MinRSAKeyBits; minBits != 0 &&
+		minBits < certgen.DefaultMinRSAKeyBits {
+		return nil, fmt.Errorf("min_rsa_key_bits: %d is below %d", minBits,
+			certgen.DefaultMinRSAKeyBits)
+	}
+	err = certgen.CheckSSHSignatureAlgorithm(
+		runtimeState.Config.Base.SSHSignatureAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("ssh_signature_algorithm: %s", err)
+	}
+	if err := runtimeState.checkFIPSSSHSignatureAlgorithm(); err != nil {
+		return nil, err
+	}
+	_, err = certgen.ParseX509SignatureAlgorithm(
+		runtimeState.Config.Base.X509SignatureAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("x509_signature_algorithm: %s", err)
+	}
+	if runtimeState.fipsMode() {
+		logger.Printf("FIPS mode enabled")
+	}
+	runtimeState.faultInjector, err = faultinjection.New(
+		runtimeState.Config.FaultInjection)
+	if err != nil {
+		return nil, fmt.Errorf("fault_injection: %s", err)
+	}
+	if runtimeState.faultInjector != nil {
+		if runtimeState.fipsMode() {
+			return nil, errors.New(
+				"fault_injection: refused in FIPS mode")
+		}
+		logger.Printf(
+			"WARNING: fault injection enabled, not for production: %s",
+			runtimeState.faultInjector)
+	}
+	runtimeState.signingPool = signingpool.New(runtimeState.Config.SigningPool)
+	runtimeState.requestLimiter = requestlimiter.New(
+		runtimeState.Config.RequestLimits)
+	runtimeState.loginCaptcha, err = captcha.New(
+		runtimeState.Config.LoginCaptcha)
+	if err != nil {
+		return nil, fmt.Errorf("login_captcha: %s", err)
+	}
+	runtimeState.setupBackendBreakers()
 	err = runtimeState.tryLoadAndVerifySigners()
 	if err != nil {
 		return nil, err
@@ -473,6 +812,45 @@ func loadVerifyConfigFile(configFilename string,
 	if err := runtimeState.setupEmail(); err != nil {
 		return nil, err
 	}
+	runtimeState.alerter, err = alerting.New(runtimeState.Config.Notifications,
+		runtimeState.HostIdentity, logger)
+	if err != nil {
+		return nil, err
+	}
+	runtimeState.certStream, err = certstream.New(
+		runtimeState.Config.CertificateStream, logger)
+	if err != nil {
+		return nil, fmt.Errorf("certificate_stream: %s", err)
+	}
+	runtimeState.postureChecker, err = deviceposture.New(
+		runtimeState.Config.DevicePosture, logger)
+	if err != nil {
+		return nil, err
+	}
+	for index := range runtimeState.Config.HostCertificates.BootstrapTokens {
+		bootstrapToken :=
+			&runtimeState.Config.HostCertificates.BootstrapTokens[index]
+		if len(bootstrapToken.Token) < 16 {
+			return nil, errors.New("host bootstrap token too short")
+		}
+		if bootstrapToken.AllowedHostnamesRE == "" {
+			return nil, errors.New(
+				"host bootstrap token has no allowed_hostnames_re")
+		}
+		bootstrapToken.allowedHostnames, err = regexp.Compile(
+			"^(?:" + bootstrapToken.AllowedHostnamesRE + ")$")
+		if err != nil {
+			return nil, err
+		}
+	}
+	instanceIdentity := runtimeState.Config.HostCertificates.InstanceIdentity
+	if instanceIdentity.Enabled() {
+		runtimeState.instanceVerifier, err = instanceidentity.New(
+			instanceIdentity, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
 	//create the oath2 config
 	if runtimeState.Config.Oauth2.Enabled == true {
 		logger.Printf("oath2 is enabled")
@@ -503,7 +881,7 @@ func loadVerifyConfigFile(configFilename string,
 		}
 		client.VipPushMessageText = "Keymaster Push Authentication Request"
 		client.VipPushDisplayMessageText = "Keymaster 2FA request from:"
-		client.VipPushDisplayMessageProfile = u2fAppID //TODO change this for host identity
+		client.VipPushDisplayMessageProfile = runtimeState.u2fIdentity.appID //TODO change this for host identity
 		client.RequireAppApproval = runtimeState.Config.SymantecVIP.RequireAppAproval
 		runtimeState.Config.SymantecVIP.Client = &client
 	}
@@ -551,22 +929,34 @@ func loadVerifyConfigFile(configFilename string,
 			return nil, err
 		}
 	}
-	if len(runtimeState.Config.Ldap.LDAPTargetURLs) > 0 {
+	if len(runtimeState.Config.Ldap.Realms) > 0 {
+		runtimeState.passwordChecker, err = runtimeState.newLdapRealmsChecker()
+		if err != nil {
+			return nil, err
+		}
+		logger.Debugf(1, "passwordChecker= %+v", runtimeState.passwordChecker)
+	} else if len(runtimeState.Config.Ldap.LDAPTargetURLs) > 0 {
 		const timeoutSecs = 3
 		pwdCache := &runtimeState
 		if runtimeState.Config.Ldap.DisablePasswordCache {
 			pwdCache = nil
 		}
-		runtimeState.passwordChecker, err = ldap.New(
+		ldapChecker, err := ldap.New(
 			strings.Split(runtimeState.Config.Ldap.LDAPTargetURLs, ","),
 			[]string{runtimeState.Config.Ldap.BindPattern},
-			timeoutSecs, nil, pwdCache,
+			timeoutSecs, runtimeState.Config.Ldap.getTLSConfig(), pwdCache,
 			logger)
 		if err != nil {
 			return nil, err
 		}
+		ldapChecker.SetCircuitBreakers(runtimeState.backendBreakers)
+		ldapChecker.SetBindObserver(observeLDAPBind)
+		runtimeState.passwordChecker = ldapChecker
 		logger.Debugf(1, "passwordChecker= %+v", runtimeState.passwordChecker)
 	}
+	if err := runtimeState.setupPasswordBackend(); err != nil {
+		return nil, err
+	}
 	// If not using an OAuth2 IDP for primary authentication, must have an
 	// alternative enabled.
 	if runtimeState.passwordChecker == nil &&
@@ -600,16 +990,42 @@ func loadVerifyConfigFile(configFilename string,
 		return nil, err
 	}
 
+	go runtimeState.syncSessionRevocations()
+
 	// and we start the cleanup
 	go runtimeState.performStateCleanup(secsBetweenCleanup)
 
 	//
 	go runtimeState.doDependencyMonitoring(runtimeState.Config.Base.SecsBetweenDependencyChecks)
 
+	go runtimeState.monitorSealedState()
+
+	go runtimeState.auditAnchorLoop()
+
+	if runtimeState.Config.ProfileGC.enabled() {
+		go runtimeState.profileGCLoop()
+	}
+
 	return &runtimeState, nil
 }
 
+// setDefaultTLSFilenames keeps the certificate files in the data directory
+// when using ACME, so that the operator does not have to manage them. Without
+// ACME, the files must be configured explicitly.
+func (config *baseConfig) setDefaultTLSFilenames() {
+	if config.ACME.ChallengeType == "" {
+		return
+	}
+	if config.TLSCertFilename == "" && config.TLSKeyFilename == "" {
+		config.TLSCertFilename = filepath.Join(config.DataDirectory,
+			defaultTLSCertFilename)
+		config.TLSKeyFilename = filepath.Join(config.DataDirectory,
+			defaultTLSKeyFilename)
+	}
+}
+
 func (state *RuntimeState) setupCertificateManager() error {
+	state.Config.Base.setDefaultTLSFilenames()
 	baseConfig := state.Config.Base
 	if len(baseConfig.ACME.DomainNames) < 1 {
 		baseConfig.ACME.DomainNames = []string{baseConfig.HostIdentity}
@@ -621,23 +1037,34 @@ func (state *RuntimeState) setupCertificateManager() error {
 		return err
 	}
 	state.certManager = cm
+	state.certReloader = certreloader.New(baseConfig.TLSCertFilename,
+		baseConfig.TLSKeyFilename, tlsCertReloadInterval, cm.GetCertificate,
+		state.logger)
 	return nil
 }
 
 func (state *RuntimeState) setupHA() error {
-	_, portString, err := net.SplitHostPort(state.Config.Base.AdminAddress)
-	if err != nil {
-		return err
-	}
-	adminPort, err := strconv.ParseUint(portString, 10, 16)
-	if err != nil {
-		return err
+	// Without a TCP admin listener, the health check ports must be configured.
+	var adminPort uint64
+	if state.Config.Base.AdminAddress != "" {
+		_, portString, err := net.SplitHostPort(state.Config.Base.AdminAddress)
+		if err != nil {
+			return err
+		}
+		adminPort, err = strconv.ParseUint(portString, 10, 16)
+		if err != nil {
+			return err
+		}
 	}
 	if hasDnsLB, err := state.Config.DnsLoadBalancer.Check(); err != nil {
 		return err
 	} else if hasDnsLB {
 		state.Config.DnsLoadBalancer.DoTLS = true
 		if state.Config.DnsLoadBalancer.TcpPort < 1 {
+			if adminPort < 1 {
+				return errors.New(
+					"dns_load_balancer: tcp_port is required without admin_address")
+			}
 			state.Config.DnsLoadBalancer.TcpPort = uint16(adminPort)
 			if state.Config.DnsLoadBalancer.FQDN == "" {
 				state.Config.DnsLoadBalancer.FQDN =
@@ -652,6 +1079,10 @@ func (state *RuntimeState) setupHA() error {
 	state.Config.Watchdog.DoTLS = true
 	if state.Config.Watchdog.CheckInterval > 0 &&
 		state.Config.Watchdog.TcpPort < 1 {
+		if adminPort < 1 {
+			return errors.New(
+				"watchdog: tcp_port is required without admin_address")
+		}
 		state.Config.Watchdog.TcpPort = uint16(adminPort)
 	}
 	return nil
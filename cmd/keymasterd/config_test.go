@@ -80,3 +80,31 @@ func TestLoadSignersFromPemData(t *testing.T) {
 	}
 
 }
+
+func TestSetDefaultTLSFilenames(t *testing.T) {
+	config := baseConfig{DataDirectory: "/var/lib/keymaster"}
+	config.setDefaultTLSFilenames()
+	if config.TLSCertFilename != "" || config.TLSKeyFilename != "" {
+		t.Fatalf("TLS filenames set without ACME: %s, %s",
+			config.TLSCertFilename, config.TLSKeyFilename)
+	}
+	config.ACME.ChallengeType = "http-01"
+	config.setDefaultTLSFilenames()
+	if config.TLSCertFilename != "/var/lib/keymaster/server-cert.pem" ||
+		config.TLSKeyFilename != "/var/lib/keymaster/server-key.pem" {
+		t.Fatalf("unexpected TLS filenames: %s, %s",
+			config.TLSCertFilename, config.TLSKeyFilename)
+	}
+	config = baseConfig{
+		DataDirectory:   "/var/lib/keymaster",
+		TLSCertFilename: "/etc/keymaster/server.pem",
+		TLSKeyFilename:  "/etc/keymaster/server.key",
+	}
+	config.ACME.ChallengeType = "dns-01"
+	config.setDefaultTLSFilenames()
+	if config.TLSCertFilename != "/etc/keymaster/server.pem" ||
+		config.TLSKeyFilename != "/etc/keymaster/server.key" {
+		t.Fatalf("configured TLS filenames replaced: %s, %s",
+			config.TLSCertFilename, config.TLSKeyFilename)
+	}
+}
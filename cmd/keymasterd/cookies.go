@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var sameSiteModes = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// cookiesConfig sets the attributes of the session (auth) and trusted device
+// cookies, for deployments behind reverse proxies which serve keymasterd
+// below a path or share cookies with other subdomains.
+type cookiesConfig struct {
+	Auth   cookieConfig `yaml:"auth"`   // Default same_site: none.
+	Device cookieConfig `yaml:"device"` // Default same_site: strict.
+}
+
+type cookieConfig struct {
+	SameSite string `yaml:"same_site"` // lax, strict or none.
+	// Also send the cookie over plain HTTP. Not compatible with same_site:
+	// none.
+	Insecure bool   `yaml:"insecure"`
+	Path     string `yaml:"path"`   // Default: "/".
+	Domain   string `yaml:"domain"` // Default: host only.
+}
+
+func (config *cookiesConfig) check() error {
+	if err := config.Auth.check(http.SameSiteNoneMode); err != nil {
+		return fmt.Errorf("cookies: auth: %s", err)
+	}
+	if err := config.Device.check(http.SameSiteStrictMode); err != nil {
+		return fmt.Errorf("cookies: device: %s", err)
+	}
+	return nil
+}
+
+func (config *cookieConfig) check(defaultSameSite http.SameSite) error {
+	sameSite := defaultSameSite
+	if config.SameSite != "" {
+		var ok bool
+		sameSite, ok = sameSiteModes[strings.ToLower(config.SameSite)]
+		if !ok {
+			return fmt.Errorf("unknown same_site: %s", config.SameSite)
+		}
+	}
+	if sameSite == http.SameSiteNoneMode && config.Insecure {
+		return fmt.Errorf("same_site: none requires secure cookies")
+	}
+	if config.Path != "" && !strings.HasPrefix(config.Path, "/") {
+		return fmt.Errorf("path: %s must be absolute", config.Path)
+	}
+	if strings.ContainsAny(config.Path, "; \t\r\n") ||
+		strings.ContainsAny(config.Domain, "; /:\t\r\n") {
+		return fmt.Errorf("invalid path or domain")
+	}
+	return nil
+}
+
+// newCookie returns an HttpOnly cookie with the configured attributes.
+func (config *cookieConfig) newCookie(name, value string, expires time.Time,
+	defaultSameSite http.SameSite) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Expires:  expires,
+		Path:     config.Path,
+		Domain:   config.Domain,
+		HttpOnly: true,
+		Secure:   !config.Insecure,
+		SameSite: defaultSameSite,
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if mode, ok := sameSiteModes[strings.ToLower(config.SameSite)]; ok {
+		cookie.SameSite = mode
+	}
+	return cookie
+}
+
+func (state *RuntimeState) newAuthCookie(value string,
+	expires time.Time) *http.Cookie {
+	return state.Config.Cookies.Auth.newCookie(authCookieName, value, expires,
+		http.SameSiteNoneMode)
+}
+
+func (state *RuntimeState) newTrustedDeviceCookie(value string,
+	expires time.Time) *http.Cookie {
+	return state.Config.Cookies.Device.newCookie(trustedDeviceCookieName,
+		value, expires, http.SameSiteStrictMode)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCookiesConfigCheck(t *testing.T) {
+	for _, config := range []cookiesConfig{
+		{Auth: cookieConfig{SameSite: "sometimes"}},
+		{Auth: cookieConfig{Insecure: true}},
+		{Device: cookieConfig{SameSite: "None", Insecure: true}},
+		{Auth: cookieConfig{Path: "keymaster"}},
+		{Device: cookieConfig{Domain: "example.com; Secure"}},
+	} {
+		if err := config.check(); err == nil {
+			t.Fatalf("bad config accepted: %+v", config)
+		}
+	}
+	config := cookiesConfig{
+		Auth:   cookieConfig{SameSite: "Lax", Path: "/keymaster"},
+		Device: cookieConfig{Insecure: true, Domain: "example.com"},
+	}
+	if err := config.check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewCookies(t *testing.T) {
+	state := RuntimeState{}
+	expires := time.Now().Add(time.Hour)
+	cookie := state.newAuthCookie("value", expires)
+	if cookie.Name != authCookieName || cookie.Path != "/" ||
+		!cookie.Secure || !cookie.HttpOnly ||
+		cookie.SameSite != http.SameSiteNoneMode {
+		t.Fatalf("unexpected default auth cookie: %+v", cookie)
+	}
+	cookie = state.newTrustedDeviceCookie("value", expires)
+	if cookie.Name != trustedDeviceCookieName ||
+		cookie.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("unexpected default device cookie: %+v", cookie)
+	}
+	state.Config.Cookies.Auth = cookieConfig{
+		SameSite: "strict",
+		Path:     "/keymaster",
+		Domain:   "example.com",
+	}
+	cookie = state.newAuthCookie("value", expires)
+	if cookie.Path != "/keymaster" || cookie.Domain != "example.com" ||
+		cookie.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("configuration not applied: %+v", cookie)
+	}
+	state.Config.Cookies.Device = cookieConfig{
+		SameSite: "lax",
+		Insecure: true,
+	}
+	cookie = state.newTrustedDeviceCookie("value", expires)
+	if cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("configuration not applied: %+v", cookie)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	corsAllowMethods  = "GET, POST, DELETE"
+	defaultCORSMaxAge = 10 * time.Minute
+)
+
+// The JSON API endpoints which browser front-ends on other origins may call.
+var corsPathPrefixes = []string{"/api/", certgenPath}
+
+// corsConfig allows browser front-ends served from other origins to call the
+// JSON API endpoints (those below /api/ and /certgen/). No origins are
+// allowed by default.
+type corsConfig struct {
+	// Origins in scheme://host[:port] form, or "*" for any origin.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// Allow requests with cookies and TLS client certificates. Incompatible
+	// with the "*" origin.
+	AllowCredentials bool `yaml:"allow_credentials"`
+	// Request headers allowed in addition to the CORS-safelisted ones.
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// How long browsers may cache preflight responses. Default: 10m.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+func (config *corsConfig) check() error {
+	if config.MaxAge < 0 {
+		return errors.New("cors: negative max_age")
+	}
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			if config.AllowCredentials {
+				return errors.New(
+					"cors: allow_credentials incompatible with \"*\" origin")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return fmt.Errorf("cors: bad origin: %s: %s", origin, err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" ||
+			u.User != nil {
+			return fmt.Errorf("cors: origin: %s not in scheme://host form",
+				origin)
+		}
+	}
+	for _, header := range config.AllowedHeaders {
+		if header == "" || strings.ContainsAny(header, ", \t\r\n") {
+			return fmt.Errorf("cors: bad header name: %q", header)
+		}
+	}
+	return nil
+}
+
+func (config *corsConfig) isOriginAllowed(origin string) bool {
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func isCORSPath(path string) bool {
+	for _, prefix := range corsPathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRequestFromAllowedOrigin returns true if pageURL (the Referer or Origin
+// of r) is on the same host as r, or is on an origin which may call the API
+// endpoint of r. The "*" origin only allows requests without the session
+// cookie, as browsers send it with cross-origin form posts.
+func (state *RuntimeState) isRequestFromAllowedOrigin(r *http.Request,
+	pageURL string) (bool, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return false, err
+	}
+	if u.Host == r.Host {
+		return true, nil
+	}
+	if u.Scheme == "" || u.Host == "" || !isCORSPath(r.URL.Path) {
+		return false, nil
+	}
+	origin := u.Scheme + "://" + u.Host
+	var anyOrigin bool
+	for _, allowed := range state.Config.CORS.AllowedOrigins {
+		if allowed == "*" {
+			anyOrigin = true
+		} else if strings.EqualFold(allowed, origin) {
+			return true, nil
+		}
+	}
+	if !anyOrigin {
+		return false, nil
+	}
+	_, err = r.Cookie(authCookieName)
+	return err == http.ErrNoCookie, nil
+}
+
+// newCORSHandler returns a handler which adds the CORS response headers for
+// allowed origins to API responses and answers preflight requests. When no
+// origins are configured handler is returned unchanged, so browsers refuse
+// cross-origin access.
+func (state *RuntimeState) newCORSHandler(handler http.Handler) http.Handler {
+	config := state.Config.CORS
+	if len(config.AllowedOrigins) < 1 {
+		return handler
+	}
+	maxAge := strconv.Itoa(
+		int(durationOrDefault(config.MaxAge, defaultCORSMaxAge).Seconds()))
+	allowedHeaders := strings.Join(config.AllowedHeaders, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !isCORSPath(r.URL.Path) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := config.isOriginAllowed(origin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if config.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		if r.Method != http.MethodOptions ||
+			r.Header.Get("Access-Control-Request-Method") == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if !allowed {
+			logger.Debugf(1, "CORS preflight from disallowed origin: %s",
+				origin)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+		if allowedHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+		}
+		w.Header().Set("Access-Control-Max-Age", maxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCORSConfigCheck(t *testing.T) {
+	for _, config := range []corsConfig{
+		{AllowedOrigins: []string{"https://app.example.com/"}},
+		{AllowedOrigins: []string{"app.example.com"}},
+		{AllowedOrigins: []string{"ftp://app.example.com"}},
+		{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		{AllowedHeaders: []string{"X-A, X-B"}},
+		{MaxAge: -1},
+	} {
+		if err := config.check(); err == nil {
+			t.Fatalf("bad config accepted: %+v", config)
+		}
+	}
+	config := corsConfig{
+		AllowedOrigins:   []string{"https://app.example.com:8443"},
+		AllowCredentials: true,
+		AllowedHeaders:   []string{"Content-Type"},
+	}
+	if err := config.check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCORSHandler(t *testing.T) {
+	var called bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	doRequest := func(handler http.Handler, method, path,
+		origin string) *httptest.ResponseRecorder {
+		called = false
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	// Deny all by default.
+	state := RuntimeState{}
+	rr := doRequest(state.newCORSHandler(next), "GET", issuedCertsPath,
+		"https://app.example.com")
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" || !called {
+		t.Fatal("CORS headers set without configuration")
+	}
+	state.Config.CORS = corsConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowCredentials: true,
+		AllowedHeaders:   []string{"Content-Type"},
+	}
+	handler := state.newCORSHandler(next)
+	rr = doRequest(handler, "GET", issuedCertsPath, "https://app.example.com")
+	if rr.Header().Get("Access-Control-Allow-Origin") !=
+		"https://app.example.com" ||
+		rr.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+		!called {
+		t.Fatalf("unexpected headers: %v", rr.Header())
+	}
+	rr = doRequest(handler, "GET", issuedCertsPath, "https://evil.example.com")
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("CORS headers set for disallowed origin")
+	}
+	rr = doRequest(handler, "POST", certgenPath+"username",
+		"https://app.example.com")
+	if rr.Header().Get("Access-Control-Allow-Origin") !=
+		"https://app.example.com" || !called {
+		t.Fatalf("unexpected certgen headers: %v", rr.Header())
+	}
+	rr = doRequest(handler, "GET", "/", "https://app.example.com")
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("CORS headers set for non-API path")
+	}
+	rr = doRequest(handler, "OPTIONS", issuedCertsPath,
+		"https://app.example.com")
+	if rr.Code != http.StatusNoContent || called ||
+		rr.Header().Get("Access-Control-Allow-Headers") != "Content-Type" ||
+		rr.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("bad preflight response: %d %v", rr.Code, rr.Header())
+	}
+	rr = doRequest(handler, "OPTIONS", issuedCertsPath,
+		"https://evil.example.com")
+	if rr.Code != http.StatusForbidden || called {
+		t.Fatalf("preflight from disallowed origin: %d", rr.Code)
+	}
+}
+
+func TestCheckAuthCrossOrigin(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	cookieVal, err := state.setNewAuthCookie(nil, "username",
+		AuthTypePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(path, header, pageURL string, withCookie bool,
+		expectedStatus int) {
+		req := httptest.NewRequest("POST",
+			"https://keymaster.example.com"+path, nil)
+		req.Header.Set(header, pageURL)
+		if withCookie {
+			req.AddCookie(&http.Cookie{Name: authCookieName,
+				Value: cookieVal})
+		}
+		rr := httptest.NewRecorder()
+		_, err := state.checkAuth(rr, req, AuthTypeAny)
+		if expectedStatus == http.StatusOK {
+			if err != nil {
+				t.Errorf("%s %s: %s: %s", path, header, pageURL, err)
+			}
+		} else if err == nil || rr.Code != expectedStatus {
+			t.Errorf("%s %s: %s: expected %d, got: %d", path, header,
+				pageURL, expectedStatus, rr.Code)
+		}
+	}
+	certgenURL := certgenPath + "username"
+	for _, header := range []string{"Referer", "Origin"} {
+		check(certgenURL, header, "https://keymaster.example.com/", true,
+			http.StatusOK)
+		check(certgenURL, header, "https://app.example.com/", true,
+			http.StatusUnauthorized)
+	}
+	state.Config.CORS.AllowedOrigins = []string{"https://app.example.com"}
+	for _, header := range []string{"Referer", "Origin"} {
+		check(certgenURL, header, "https://app.example.com/page", true,
+			http.StatusOK)
+		check(devicesAPIPath, header, "https://app.example.com", true,
+			http.StatusOK)
+		check(certgenURL, header, "https://evil.example.com/", true,
+			http.StatusUnauthorized)
+		// Only the JSON API may be called from other origins.
+		check(devicesPath, header, "https://app.example.com/", true,
+			http.StatusUnauthorized)
+	}
+	// Any origin may call endpoints, but not with the session cookie.
+	state.Config.CORS.AllowedOrigins = []string{"*"}
+	check(certgenURL, "Origin", "https://evil.example.com", true,
+		http.StatusUnauthorized)
+	req := httptest.NewRequest("POST",
+		"https://keymaster.example.com"+certgenURL, nil)
+	allowed, err := state.isRequestFromAllowedOrigin(req,
+		"https://evil.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Fatal("request without session cookie refused")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+)
+
+const crossSignCAPath = "/admin/crossSignCA"
+
+// x509CrossSigningConfig is used while migrating relying parties from a
+// previous X.509 CA to the current one. The previous CA signs the current
+// one (see crossSignCAPath), and clients which ask for it get that
+// certificate with theirs, so that it chains to either CA.
+type x509CrossSigningConfig struct {
+	// PEM file with the current CA certificate signed by previous CAs.
+	CertificatesFilename string `yaml:"certificates_filename"`
+	// Send the chain unless clients ask for their certificate only.
+	IncludeByDefault bool `yaml:"include_by_default"`
+}
+
+// loadCrossSignedCACerts reads the cross-signed CA certificates. Which ones
+// apply is only known once the CA is unsealed.
+func (state *RuntimeState) loadCrossSignedCACerts() error {
+	filename := state.Config.X509CrossSigning.CertificatesFilename
+	if filename == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("cannot parse cross-signed CA: %s", err)
+		}
+		if !cert.IsCA {
+			return fmt.Errorf("cross-signed certificate for: %s is not a CA",
+				cert.Subject)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) < 1 {
+		return fmt.Errorf("no certificates in: %s", filename)
+	}
+	state.crossSignedCAs = certs
+	return nil
+}
+
+// getCrossSignedCACerts returns the unexpired cross-signed certificates for
+// caCert.
+func (state *RuntimeState) getCrossSignedCACerts(
+	caCert *x509.Certificate) []*x509.Certificate {
+	if caCert == nil {
+		return nil
+	}
+	now := time.Now()
+	var certs []*x509.Certificate
+	for _, cert := range state.crossSignedCAs {
+		if bytes.Equal(cert.RawSubject, caCert.RawSubject) &&
+			bytes.Equal(cert.RawSubjectPublicKeyInfo,
+				caCert.RawSubjectPublicKeyInfo) &&
+			now.Before(cert.NotAfter) {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+// checkCrossSignedCACerts warns if none of the cross-signed certificates are
+// for caCert, typically because they were made for another CA key.
+func (state *RuntimeState) checkCrossSignedCACerts(caCert *x509.Certificate) {
+	if len(state.crossSignedCAs) < 1 {
+		return
+	}
+	if len(state.getCrossSignedCACerts(caCert)) < 1 {
+		state.logger.Printf(
+			"Warning: no unexpired cross-signed certificates for the CA\n")
+	}
+}
+
+// wantCrossSignedChain returns true if the cross-signed certificates should
+// be sent with the certificate issued for the request. Clients choose with
+// chain=cross or chain=leaf.
+func (state *RuntimeState) wantCrossSignedChain(r *http.Request) bool {
+	switch r.Form.Get("chain") {
+	case "cross":
+		return true
+	case "leaf":
+		return false
+	}
+	return state.Config.X509CrossSigning.IncludeByDefault
+}
+
+func encodeCertificatesPEM(certs []*x509.Certificate) string {
+	var buffer bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buffer, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buffer.String()
+}
+
+func (state *RuntimeState) writeCrossSignedCACerts(w http.ResponseWriter,
+	r *http.Request) {
+	state.Mutex.RLock()
+	caCert := state.caCert
+	state.Mutex.RUnlock()
+	certs := state.getCrossSignedCACerts(caCert)
+	if len(certs) < 1 {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	fmt.Fprint(w, encodeCertificatesPEM(certs))
+}
+
+// crossSignCAHandler signs the CA certificate in the request with this
+// keymaster's CA, for the keymaster which is replacing it. As for unsealing,
+// any verified client certificate or admin socket client is accepted.
+func (state *RuntimeState) crossSignCAHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	clientName := getAdminClientName(r)
+	if clientName == "" {
+		state.writeFailureResponse(w, r, http.StatusForbidden, "")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	block, _ := pem.Decode([]byte(r.Form.Get("ca_certificate")))
+	if block == nil || block.Type != "CERTIFICATE" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Missing ca_certificate")
+		return
+	}
+	newCACert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := newCACert.CheckSignatureFrom(newCACert); err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"ca_certificate is not a self-signed CA")
+		return
+	}
+	state.Mutex.RLock()
+	caCert := state.caCert
+	signer := state.Signer
+	state.Mutex.RUnlock()
+	if signer == nil {
+		state.writeFailureResponse(w, r, http.StatusServiceUnavailable,
+			"CA is sealed")
+		return
+	}
+	if bytes.Equal(newCACert.RawSubjectPublicKeyInfo,
+		caCert.RawSubjectPublicKeyInfo) {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"ca_certificate is for this CA")
+		return
+	}
+	crossDer, err := certgen.GenCrossSignedCACert(newCACert, caCert, signer)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Printf("%s: cross-signed CA: %s\n", clientName, newCACert.Subject)
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: crossDer})
+}
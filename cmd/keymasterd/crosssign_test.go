@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+)
+
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := certgen.GenSelfSignedCACert("other.example.com", "example",
+		priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, priv
+}
+
+func TestCrossSignedCACerts(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	oldCACert, oldPriv := newTestCA(t)
+	crossDer, err := certgen.GenCrossSignedCACert(state.caCert, oldCACert,
+		oldPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCACert, _ := newTestCA(t)
+	otherCrossDer, err := certgen.GenCrossSignedCACert(otherCACert, oldCACert,
+		oldPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile, err := ioutil.TempFile("", "cross_signed_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	pem.Encode(tmpfile, &pem.Block{Type: "CERTIFICATE", Bytes: otherCrossDer})
+	pem.Encode(tmpfile, &pem.Block{Type: "CERTIFICATE", Bytes: crossDer})
+	tmpfile.Close()
+	state.Config.X509CrossSigning.CertificatesFilename = tmpfile.Name()
+	if err := state.loadCrossSignedCACerts(); err != nil {
+		t.Fatal(err)
+	}
+	certs := state.getCrossSignedCACerts(state.caCert)
+	if len(certs) != 1 || string(certs[0].Raw) != string(crossDer) {
+		t.Fatalf("expected the cross-signed CA, got: %d certificates",
+			len(certs))
+	}
+	for _, test := range []struct {
+		query            string
+		includeByDefault bool
+		want             bool
+	}{
+		{"", false, false},
+		{"", true, true},
+		{"chain=cross", false, true},
+		{"chain=leaf", true, false},
+	} {
+		state.Config.X509CrossSigning.IncludeByDefault = test.includeByDefault
+		req := httptest.NewRequest("POST", "/certgen/username?"+test.query,
+			nil)
+		req.ParseForm()
+		if got := state.wantCrossSignedChain(req); got != test.want {
+			t.Errorf("%q (default: %v): got: %v", test.query,
+				test.includeByDefault, got)
+		}
+	}
+}
+
+func TestCrossSignCAHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	newCACert, _ := newTestCA(t)
+	form := url.Values{"ca_certificate": {string(pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: newCACert.Raw}))}}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", crossSignCAPath,
+			strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	req := newRequest()
+	req.TLS = &tls.ConnectionState{}
+	if _, err := checkRequestHandlerCode(req, state.crossSignCAHandler,
+		http.StatusForbidden); err != nil {
+		t.Fatal(err)
+	}
+	req = newRequest()
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{state.caCert}},
+	}
+	rr, err := checkRequestHandlerCode(req, state.crossSignCAHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(rr.Body.Bytes())
+	if block == nil {
+		t.Fatal("no certificate returned")
+	}
+	crossCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crossCert.CheckSignatureFrom(state.caCert); err != nil {
+		t.Fatal(err)
+	}
+	if string(crossCert.RawSubject) != string(newCACert.RawSubject) {
+		t.Fatal("cross-signed certificate has the wrong subject")
+	}
+}
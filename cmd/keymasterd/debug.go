@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	_ "net/http/pprof" // Registers /debug/pprof/ on http.DefaultServeMux.
+	"runtime"
+	"time"
+)
+
+// debugPath is the prefix of the profiling and runtime endpoints. They are
+// served by the metrics listener, and to admins by the admin listener.
+const (
+	debugPath        = "/debug/"
+	runtimeStatsPath = "/debug/runtime"
+)
+
+var processStartTime = time.Now()
+
+type runtimeStats struct {
+	Version       string        `json:"version,omitempty"`
+	GoVersion     string        `json:"go_version"`
+	Uptime        time.Duration `json:"uptime_ns"`
+	NumCPU        int           `json:"num_cpu"`
+	GOMAXPROCS    int           `json:"gomaxprocs"`
+	NumGoroutine  int           `json:"num_goroutine"`
+	HeapAlloc     uint64        `json:"heap_alloc_bytes"`
+	HeapInuse     uint64        `json:"heap_inuse_bytes"`
+	HeapObjects   uint64        `json:"heap_objects"`
+	Sys           uint64        `json:"sys_bytes"`
+	NumGC         uint32        `json:"num_gc"`
+	PauseTotal    time.Duration `json:"gc_pause_total_ns"`
+	LastGC        time.Time     `json:"last_gc"`
+	NextGC        uint64        `json:"next_gc_bytes"`
+	GCCPUFraction float64       `json:"gc_cpu_fraction"`
+}
+
+func getRuntimeStats() runtimeStats {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	return runtimeStats{
+		Version:       Version,
+		GoVersion:     runtime.Version(),
+		Uptime:        time.Since(processStartTime),
+		NumCPU:        runtime.NumCPU(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		NumGoroutine:  runtime.NumGoroutine(),
+		HeapAlloc:     memStats.HeapAlloc,
+		HeapInuse:     memStats.HeapInuse,
+		HeapObjects:   memStats.HeapObjects,
+		Sys:           memStats.Sys,
+		NumGC:         memStats.NumGC,
+		PauseTotal:    time.Duration(memStats.PauseTotalNs),
+		LastGC:        time.Unix(0, int64(memStats.LastGC)),
+		NextGC:        memStats.NextGC,
+		GCCPUFraction: memStats.GCCPUFraction,
+	}
+}
+
+// runtimeStatsHandler writes the runtime and memory statistics as JSON.
+// Authorisation is done by the log filter, which wraps the admin listener.
+func (state *RuntimeState) runtimeStatsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(getRuntimeStats()); err != nil {
+		state.logger.Println(err)
+	}
+}
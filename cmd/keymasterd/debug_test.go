@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestDebugHandlersRequireAdmin(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	mux := http.NewServeMux()
+	mux.Handle("/debug/pprof/", http.DefaultServeMux)
+	mux.HandleFunc(runtimeStatsPath, state.runtimeStatsHandler)
+	handler := NewLogFilterHandler(mux, true, state)
+	tests := []struct {
+		name           string
+		certs          []string
+		expectedStatus int
+	}{
+		{"admin", []string{"testdata/alice.pem", "testdata/KeymasterCA.pem"},
+			http.StatusOK},
+		{"plainUser", []string{"testdata/bob.pem", "testdata/KeymasterCA.pem"},
+			http.StatusUnauthorized},
+		{"noTLS", nil, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		for _, path := range []string{"/debug/pprof/", runtimeStatsPath} {
+			req := httptest.NewRequest("GET", path, nil)
+			if test.certs != nil {
+				req.TLS, err = testMakeConnectionState(test.certs...)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.expectedStatus {
+				t.Errorf("%s: %s: got status: %d, expected: %d",
+					test.name, path, recorder.Code, test.expectedStatus)
+			}
+		}
+	}
+}
+
+func TestRuntimeStatsHandler(t *testing.T) {
+	state := &RuntimeState{}
+	req := httptest.NewRequest("GET", runtimeStatsPath, nil)
+	rr, err := checkRequestHandlerCode(req, state.runtimeStatsHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats runtimeStats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.NumGoroutine < 1 || stats.HeapAlloc == 0 || stats.GoVersion == "" {
+		t.Fatalf("incomplete stats: %+v", stats)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"strings"
@@ -54,7 +55,7 @@ func init() {
 		"Time since last successful LDAP check for UserInfo(s)")
 }
 
-func checkLDAPURLs(ldapURLs string, name string, rootCAs *x509.CertPool) error {
+func checkLDAPURLs(ldapURLs string, name string, tlsConfig *tls.Config) error {
 	if len(ldapURLs) <= 0 {
 		return errors.New("No data to check")
 	}
@@ -65,7 +66,7 @@ func checkLDAPURLs(ldapURLs string, name string, rootCAs *x509.CertPool) error {
 			return err
 		}
 		startTime := time.Now()
-		err = authutil.CheckLDAPConnection(*url, timeoutSecs, rootCAs)
+		err = authutil.CheckLDAPConnection(*url, timeoutSecs, tlsConfig)
 		if err != nil {
 			continue
 		}
@@ -75,9 +76,23 @@ func checkLDAPURLs(ldapURLs string, name string, rootCAs *x509.CertPool) error {
 	return errors.New("Check Failed")
 }
 
+// getLDAPCheckTLSConfig returns the trust configuration of a directory,
+// falling back to rootCAs if it has none.
+func getLDAPCheckTLSConfig(config *LDAPTLSConfig,
+	rootCAs *x509.CertPool) *tls.Config {
+	if tlsConfig := config.getTLSConfig(); tlsConfig != nil {
+		return tlsConfig
+	}
+	if rootCAs == nil {
+		return nil
+	}
+	return &tls.Config{RootCAs: rootCAs}
+}
+
 func checkLDAPConfigs(config AppConfigFile, rootCAs *x509.CertPool) {
 	if len(config.Ldap.LDAPTargetURLs) > 0 {
-		err := checkLDAPURLs(config.Ldap.LDAPTargetURLs, "passwd", rootCAs)
+		err := checkLDAPURLs(config.Ldap.LDAPTargetURLs, "passwd",
+			getLDAPCheckTLSConfig(&config.Ldap.LDAPTLSConfig, rootCAs))
 		if err != nil {
 			logger.Debugf(1, "password LDAP check Failed %s", err)
 		} else {
@@ -86,9 +101,18 @@ func checkLDAPConfigs(config AppConfigFile, rootCAs *x509.CertPool) {
 		dependencyLastSuccessSecondsGauge.WithLabelValues("ldap", "passwd").
 			Set(time.Now().Sub(lastSuccessLDAPPasswordTime).Seconds())
 	}
+	for _, realm := range config.Ldap.Realms {
+		err := checkLDAPURLs(realm.LDAPTargetURLs, "passwd-"+realm.Name,
+			getLDAPCheckTLSConfig(&realm.LDAPTLSConfig, rootCAs))
+		if err != nil {
+			logger.Debugf(1, "password LDAP check Failed for realm %s: %s",
+				realm.Name, err)
+		}
+	}
 	ldapConfig := config.UserInfo.Ldap
 	if len(ldapConfig.LDAPTargetURLs) > 0 {
-		err := checkLDAPURLs(ldapConfig.LDAPTargetURLs, "userinfo", rootCAs)
+		err := checkLDAPURLs(ldapConfig.LDAPTargetURLs, "userinfo",
+			getLDAPCheckTLSConfig(&ldapConfig.LDAPTLSConfig, rootCAs))
 		if err != nil {
 			logger.Debugf(1, "userinfo LDAP check Failed %s", err)
 		} else {
@@ -52,7 +52,7 @@ func TestCheckLDAPURLsSuccess(t *testing.T) {
 	if !ok {
 		t.Fatal("cannot add certs to certpool")
 	}
-	err := checkLDAPURLs("ldaps://localhost:10638", "somename", certPool)
+	err := checkLDAPURLs("ldaps://localhost:10638", "somename", &tls.Config{RootCAs: certPool})
 	if err != nil {
 		t.Logf("Failed to check ldap url")
 		t.Fatal(err)
@@ -65,7 +65,7 @@ func TestCheckLDAPURLsFailNoValidTargets(t *testing.T) {
 	if !ok {
 		t.Fatal("cannot add certs to certpool")
 	}
-	err := checkLDAPURLs("ldap://localhost:10638", "somename", certPool)
+	err := checkLDAPURLs("ldap://localhost:10638", "somename", &tls.Config{RootCAs: certPool})
 	if err == nil {
 		t.Fatal("Should have failed")
 	}
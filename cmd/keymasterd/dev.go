@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	devHostIdentity = "localhost"
+	devHttpAddress  = ":33443"
+	devAdminAddress = ":33444"
+	devRSAKeySize   = 2048
+	// This DB has user 'username' with password 'password'.
+	devHtpasswdContent = `username:$2y$05$D4qQmZbWYqfgtGtez2EGdOkcNne40EdEznOqMvZegQypT8Jdz42Jy`
+)
+
+// generateDevConfig creates a throwaway configuration (unencrypted CA key,
+// self-signed TLS certificate, htpasswd file with a test user) under baseDir
+// and returns the name of the config file. It is meant for running the full
+// flow locally and must never be used in production.
+func generateDevConfig(baseDir string) (string, error) {
+	var config AppConfigFile
+	configDir := filepath.Join(baseDir, "etc")
+	config.Base.DataDirectory = filepath.Join(baseDir, "data")
+	for _, dir := range []string{configDir, config.Base.DataDirectory} {
+		if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+			return "", err
+		}
+	}
+	config.Base.HostIdentity = devHostIdentity
+	config.Base.HttpAddress = devHttpAddress
+	config.Base.AdminAddress = devAdminAddress
+	config.Base.SharedDataDirectory = "/usr/share/keymasterd"
+	// Prefer the resources in the source tree when run from it.
+	if _, err := os.Stat("customization_data"); err == nil {
+		if wd, err := os.Getwd(); err == nil {
+			config.Base.SharedDataDirectory = wd
+		}
+	}
+	config.Base.SSHCAFilename = filepath.Join(configDir, "masterKey.pem")
+	err := generateArmoredEncryptedCAPrivateKey(nil, config.Base.SSHCAFilename)
+	if err != nil {
+		return "", err
+	}
+	err = generateCerts(configDir, &config.Base, devRSAKeySize, false)
+	if err != nil {
+		return "", err
+	}
+	config.Base.HtpasswdFilename = filepath.Join(configDir, "passfile.htpass")
+	err = ioutil.WriteFile(config.Base.HtpasswdFilename,
+		[]byte(devHtpasswdContent), 0644)
+	if err != nil {
+		return "", err
+	}
+	config.Base.AllowedAuthBackendsForWebUI = []string{proto.AuthTypePassword}
+	config.Base.AllowedAuthBackendsForCerts = []string{proto.AuthTypePassword,
+		proto.AuthTypeU2F, proto.AuthTypeTOTP}
+	config.Base.EnableLocalTOTP = true
+	configText, err := yaml.Marshal(&config)
+	if err != nil {
+		return "", err
+	}
+	configFilename := filepath.Join(configDir, "config.yml")
+	if err := ioutil.WriteFile(configFilename, configText, 0640); err != nil {
+		return "", err
+	}
+	return configFilename, nil
+}
+
+func setupDevMode() (string, error) {
+	baseDir, err := ioutil.TempDir("", "keymasterd-dev")
+	if err != nil {
+		return "", err
+	}
+	configFilename, err := generateDevConfig(baseDir)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(os.Stderr, "Development mode: using throwaway config: %s\n",
+		configFilename)
+	fmt.Fprintf(os.Stderr,
+		"Log in at https://%s%s/ as user \"username\" with password \"password\"\n",
+		devHostIdentity, devHttpAddress)
+	return configFilename, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+func TestGenerateDevConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dev_config_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFilename, err := generateDevConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadVerifyConfigFile(configFilename, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Signer == nil {
+		t.Fatal("signer should be loaded in development mode")
+	}
+	valid, err := state.checkUserPassword("username", "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid {
+		t.Fatal("test user should be able to log in")
+	}
+}
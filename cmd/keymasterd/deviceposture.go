@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/deviceposture"
+)
+
+// deviceIDHeader may be set by the client, or by a proxy which knows the
+// device, to identify the device to the posture check.
+const deviceIDHeader = "X-Keymaster-Device-Id"
+
+// checkDevicePosture checks the posture of the device requesting a
+// certificate and logs the result. It returns false if the certificate must
+// not be issued, in which case it has written the failure response.
+func (state *RuntimeState) checkDevicePosture(w http.ResponseWriter,
+	r *http.Request, username string, certType string) bool {
+	refusal, err := state.getDevicePostureRefusal(deviceposture.Request{
+		Username:   username,
+		CertType:   certType,
+		DeviceID:   r.Header.Get(deviceIDHeader),
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	})
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusServiceUnavailable,
+			"Device posture check failed")
+		return false
+	}
+	if refusal != "" {
+		state.writeFailureResponse(w, r, http.StatusForbidden, refusal)
+		return false
+	}
+	return true
+}
+
+// getDevicePostureRefusal checks and logs the posture of the device of
+// request. It returns why the certificate must not be issued, or "" if it
+// may be.
+func (state *RuntimeState) getDevicePostureRefusal(
+	request deviceposture.Request) (string, error) {
+	if state.postureChecker == nil {
+		return "", nil
+	}
+	result, err := state.postureChecker.Check(request)
+	if err != nil {
+		logger.Printf("device posture check failed for %s: %s",
+			request.Username, err)
+		return "", err
+	}
+	if result == nil {
+		return "", nil
+	}
+	logger.Printf("device posture for %s (%s) from %s: %s",
+		request.Username, request.CertType, request.RemoteAddr, result)
+	if result.Compliant {
+		return "", nil
+	}
+	message := "Device does not meet posture requirements"
+	if result.Reason != "" {
+		message += ": " + result.Reason
+	}
+	return message, nil
+}
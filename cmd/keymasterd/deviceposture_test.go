@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/deviceposture"
+)
+
+func TestCheckDevicePosture(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var request deviceposture.Request
+			json.NewDecoder(r.Body).Decode(&request)
+			json.NewEncoder(w).Encode(deviceposture.Result{
+				Compliant: request.DeviceID == "managed-laptop",
+				Reason:    "disk not encrypted",
+			})
+		}))
+	defer webhook.Close()
+	state := &RuntimeState{}
+	var err error
+	state.postureChecker, err = deviceposture.New(
+		deviceposture.Config{WebhookURL: webhook.URL}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCode := func(deviceID string, expectedStatus int) {
+		req, err := http.NewRequest("POST", "/certgen/alice", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(deviceIDHeader, deviceID)
+		_, err = checkRequestHandlerCode(req,
+			func(w http.ResponseWriter, r *http.Request) {
+				if state.checkDevicePosture(w, r, "alice", "ssh") {
+					w.WriteHeader(http.StatusOK)
+				}
+			}, expectedStatus)
+		if err != nil {
+			t.Fatalf("%s: %s", deviceID, err)
+		}
+	}
+	checkCode("managed-laptop", http.StatusOK)
+	checkCode("personal-laptop", http.StatusForbidden)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+)
+
+const (
+	devicesPath    = "/devices/"
+	devicesAPIPath = "/api/v0/devices"
+
+	deviceTypeTOTP    = "totp"
+	deviceTypeTrusted = "trusted"
+	deviceTypeU2F     = "u2f"
+
+	maxUserAgentLength = 256
+)
+
+var validDeviceNameRE = regexp.MustCompile("^[-/.a-zA-Z0-9_ ]+$")
+
+type userDeviceInfo struct {
+	Type             string     `json:"type"`
+	Index            int64      `json:"index"`
+	Name             string     `json:"name"`
+	Enabled          bool       `json:"enabled"`
+	CreatedAt        time.Time  `json:"created_at"`
+	CreatorAddr      string     `json:"created_from,omitempty"`
+	CreatorUserAgent string     `json:"created_user_agent,omitempty"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty"`
+	LastUsedAddr     string     `json:"last_used_from,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+}
+
+// getTruncatedUserAgent returns the user agent of the client, limited to a
+// length which is reasonable to store in the profile.
+func getTruncatedUserAgent(r *http.Request) string {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		return userAgent[:maxUserAgentLength]
+	}
+	return userAgent
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func getDeviceList(profile *userProfile) []userDeviceInfo {
+	var devices []userDeviceInfo
+	for index, u2fData := range profile.U2fAuthData {
+		devices = append(devices, userDeviceInfo{
+			Type:             deviceTypeU2F,
+			Index:            index,
+			Name:             u2fData.Name,
+			Enabled:          u2fData.Enabled,
+			CreatedAt:        u2fData.CreatedAt,
+			CreatorAddr:      u2fData.CreatorAddr,
+			CreatorUserAgent: u2fData.CreatorUserAgent,
+			LastUsedAt:       optionalTime(u2fData.LastUsedAt),
+			LastUsedAddr:     u2fData.LastUsedAddr,
+		})
+	}
+	for index, totpData := range profile.TOTPAuthData {
+		devices = append(devices, userDeviceInfo{
+			Type:             deviceTypeTOTP,
+			Index:            index,
+			Name:             totpData.Name,
+			Enabled:          totpData.Enabled,
+			CreatedAt:        totpData.CreatedAt,
+			CreatorAddr:      totpData.ValidatorAddr,
+			CreatorUserAgent: totpData.CreatorUserAgent,
+			LastUsedAt:       optionalTime(totpData.LastUsedAt),
+			LastUsedAddr:     totpData.LastUsedAddr,
+		})
+	}
+	for index, trustedData := range profile.TrustedDevices {
+		devices = append(devices, userDeviceInfo{
+			Type:             deviceTypeTrusted,
+			Index:            index,
+			Name:             trustedData.Name,
+			Enabled:          trustedData.ExpiresAt.After(time.Now()),
+			CreatedAt:        trustedData.CreatedAt,
+			CreatorAddr:      trustedData.CreatorAddr,
+			CreatorUserAgent: trustedData.CreatorUserAgent,
+			LastUsedAt:       optionalTime(trustedData.LastUsedAt),
+			LastUsedAddr:     trustedData.LastUsedAddr,
+			ExpiresAt:        optionalTime(trustedData.ExpiresAt),
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Type != devices[j].Type {
+			return devices[i].Type > devices[j].Type
+		}
+		return devices[i].CreatedAt.Before(devices[j].CreatedAt)
+	})
+	return devices
+}
+
+// devicesHandler renders the page where users manage their own devices.
+func (state *RuntimeState) devicesHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authData, err := state.checkAuth(w, r, state.getWebUILoginAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authData.Username)
+	profile, _, fromCache, err := state.LoadUserProfile(authData.Username)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	displayData := devicesPageTemplateData{
+		Title:        state.pageTitle("Devices"),
+		AuthUsername: authData.Username,
+		Devices:      getDeviceList(profile),
+		ShowTrustDevice: state.canTrustDevices(authData.Username) &&
+			authData.SessionID != "" &&
+			(authData.AuthType&secondFactorAuthTypes) != 0,
+	}
+	if fromCache {
+		displayData.ReadOnlyMsg = "The active keymaster is running disconnected from its DB backend. Devices cannot be changed."
+	}
+	err = state.htmlTemplate.ExecuteTemplate(w, "devicesPage", displayData)
+	if err != nil {
+		logger.Printf("Failed to execute %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// devicesAPIHandler lists (GET) or changes (POST) the devices of the
+// authenticated user. Changes take the form values: type, index, action
+// ("Rename" or "Delete") and name. The action "Trust" (without other values)
+// makes the requesting browser a trusted device.
+func (state *RuntimeState) devicesAPIHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authData, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authData.Username)
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if r.FormValue("action") == "Trust" {
+			if !state.trustDevice(w, r, authData) {
+				return
+			}
+		} else if !state.updateDevice(w, r, authData.Username) {
+			return
+		}
+		if getPreferredAcceptType(r) == "text/html" {
+			http.Redirect(w, r, devicesPath, http.StatusFound)
+			return
+		}
+	default:
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	profile, _, _, err := state.LoadUserProfile(authData.Username)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(getDeviceList(profile)); err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
+
+// updateDevice applies the requested change. It returns false if a failure
+// response was written.
+func (state *RuntimeState) updateDevice(w http.ResponseWriter,
+	r *http.Request, username string) bool {
+	if err := r.ParseForm(); err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return false
+	}
+	index, err := strconv.ParseInt(r.Form.Get("index"), 10, 64)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"index is not a number")
+		return false
+	}
+	action := r.Form.Get("action")
+	name := r.Form.Get("name")
+	if action == "Rename" && !validDeviceNameRE.MatchString(name) {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"invalid device name")
+		return false
+	}
+	if action != "Rename" && action != "Delete" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid Operation")
+		return false
+	}
+	defer state.lockUserProfile(username)()
+	profile, _, fromCache, err := state.LoadUserProfile(username)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return false
+	}
+	if fromCache {
+		http.Error(w, "db backend is offline for writes",
+			http.StatusServiceUnavailable)
+		return false
+	}
+	switch r.Form.Get("type") {
+	case deviceTypeU2F:
+		u2fData, ok := profile.U2fAuthData[index]
+		if !ok {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"bad index Value")
+			return false
+		}
+		if action == "Rename" {
+			u2fData.Name = name
+		} else {
+			delete(profile.U2fAuthData, index)
+		}
+	case deviceTypeTOTP:
+		totpData, ok := profile.TOTPAuthData[index]
+		if !ok {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"bad index Value")
+			return false
+		}
+		if action == "Rename" {
+			totpData.Name = name
+		} else {
+			delete(profile.TOTPAuthData, index)
+		}
+	case deviceTypeTrusted:
+		trustedData, ok := profile.TrustedDevices[index]
+		if !ok {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"bad index Value")
+			return false
+		}
+		if action == "Rename" {
+			trustedData.Name = name
+		} else {
+			delete(profile.TrustedDevices, index)
+		}
+	default:
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"unknown device type")
+		return false
+	}
+	if err := state.SaveUserProfile(username, profile); err != nil {
+		logger.Printf("Saving profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return false
+	}
+	logger.Printf("%s: %s %s device %d", username, action,
+		r.Form.Get("type"), index)
+	return true
+}
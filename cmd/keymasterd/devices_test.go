@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetDeviceList(t *testing.T) {
+	now := time.Now()
+	profile := &userProfile{
+		U2fAuthData: map[int64]*u2fAuthData{
+			2: {Name: "newer key", Enabled: true, CreatedAt: now},
+			1: {Name: "older key", Enabled: true,
+				CreatedAt: now.Add(-time.Hour), LastUsedAt: now,
+				LastUsedAddr: "192.0.2.2:443"},
+		},
+		TOTPAuthData: map[int64]*totpAuthData{
+			3: {Name: "phone", CreatedAt: now.Add(-2 * time.Hour),
+				ValidatorAddr: "192.0.2.3:443", CreatorUserAgent: "Mobile"},
+		},
+	}
+	devices := getDeviceList(profile)
+	if len(devices) != 3 {
+		t.Fatalf("expected 3 devices, got %d", len(devices))
+	}
+	expectedOrder := []int64{1, 2, 3}
+	for i, device := range devices {
+		if device.Index != expectedOrder[i] {
+			t.Fatalf("unexpected order: %+v", devices)
+		}
+	}
+	if devices[0].LastUsedAt == nil || !devices[0].LastUsedAt.Equal(now) {
+		t.Fatalf("last used time not reported: %+v", devices[0])
+	}
+	if devices[1].LastUsedAt != nil {
+		t.Fatalf("unused device should not have a last used time")
+	}
+	if devices[0].LastUsedAddr != "192.0.2.2:443" {
+		t.Fatalf("last used address not reported: %+v", devices[0])
+	}
+	if devices[2].Type != deviceTypeTOTP || devices[2].Enabled {
+		t.Fatalf("bad TOTP device: %+v", devices[2])
+	}
+	if devices[2].CreatorAddr != "192.0.2.3:443" ||
+		devices[2].CreatorUserAgent != "Mobile" {
+		t.Fatalf("registration metadata not reported: %+v", devices[2])
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDualControlWindow = 15 * time.Minute
+
+// dualControlUnsealConfig requires one member of each group to submit their
+// part of the unseal password, over a TLS client certificate connection,
+// before the CA is unsealed. The password is the concatenation of the parts
+// in the order of the groups.
+type dualControlUnsealConfig struct {
+	Groups []dualControlGroup `yaml:"groups"`
+	// All parts must be submitted within Window of the first. Default: 15m.
+	Window time.Duration `yaml:"window"`
+}
+
+type dualControlGroup struct {
+	Name    string   `yaml:"name"`
+	Members []string `yaml:"members"` // Client certificate common names.
+}
+
+type unsealPart struct {
+	identity string
+	part     []byte
+}
+
+// dualControlState holds the parts submitted so far, by group.
+type dualControlState struct {
+	mutex     sync.Mutex
+	started   time.Time
+	submitted map[string]unsealPart
+}
+
+func (config *dualControlUnsealConfig) enabled() bool {
+	return len(config.Groups) > 0
+}
+
+func (config *dualControlUnsealConfig) check(autoUnseal autoUnseal) error {
+	if !config.enabled() {
+		return nil
+	}
+	if len(config.Groups) < 2 {
+		return errors.New("dual_control_unseal: at least 2 groups required")
+	}
+	if config.Window < 0 {
+		return errors.New("dual_control_unseal: negative window")
+	}
+	if autoUnseal.enabled() {
+		return errors.New(
+			"dual_control_unseal: incompatible with auto_unseal")
+	}
+	memberGroups := make(map[string]string)
+	groupNames := make(map[string]struct{})
+	for _, group := range config.Groups {
+		if group.Name == "" {
+			return errors.New("dual_control_unseal: group name missing")
+		}
+		if _, ok := groupNames[group.Name]; ok {
+			return fmt.Errorf("dual_control_unseal: duplicate group: %s",
+				group.Name)
+		}
+		groupNames[group.Name] = struct{}{}
+		if len(group.Members) < 1 {
+			return fmt.Errorf("dual_control_unseal: group %s has no members",
+				group.Name)
+		}
+		for _, member := range group.Members {
+			if otherGroup, ok := memberGroups[member]; ok {
+				return fmt.Errorf(
+					"dual_control_unseal: %s is in groups %s and %s",
+					member, otherGroup, group.Name)
+			}
+			memberGroups[member] = group.Name
+		}
+	}
+	return nil
+}
+
+func (config *dualControlUnsealConfig) getGroup(identity string) string {
+	for _, group := range config.Groups {
+		for _, member := range group.Members {
+			if member == identity {
+				return group.Name
+			}
+		}
+	}
+	return ""
+}
+
+// submitUnsealPart records the part of the unseal password submitted by
+// identity. Once every group has submitted a part within the window, the
+// parts are cleared and the password and the identities which submitted
+// them are returned. Otherwise the password is nil and the groups still
+// missing are returned.
+func (state *RuntimeState) submitUnsealPart(identity string, part []byte) (
+	[]byte, []string, error) {
+	config := &state.Config.DualControlUnseal
+	groupName := config.getGroup(identity)
+	if groupName == "" {
+		return nil, nil, fmt.Errorf("%s not in any unseal group", identity)
+	}
+	window := config.Window
+	if window <= 0 {
+		window = defaultDualControlWindow
+	}
+	dualControl := &state.dualControl
+	dualControl.mutex.Lock()
+	defer dualControl.mutex.Unlock()
+	if len(dualControl.submitted) > 0 &&
+		time.Since(dualControl.started) > window {
+		logger.Printf("unseal parts expired, discarding")
+		dualControl.submitted = nil
+	}
+	if len(dualControl.submitted) < 1 {
+		dualControl.started = time.Now()
+		dualControl.submitted = make(map[string]unsealPart)
+	}
+	dualControl.submitted[groupName] = unsealPart{identity, part}
+	var password []byte
+	var identities, missing []string
+	for _, group := range config.Groups {
+		if submitted, ok := dualControl.submitted[group.Name]; ok {
+			password = append(password, submitted.part...)
+			identities = append(identities, submitted.identity)
+		} else {
+			missing = append(missing, group.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, missing, nil
+	}
+	dualControl.submitted = nil
+	return password, identities, nil
+}
+
+// unsealWithPart unseals the CA once all the parts of the unseal password
+// have been submitted. It returns true if the CA was unsealed and a
+// description of the outcome.
+func (state *RuntimeState) unsealWithPart(identity string, part []byte) (
+	bool, string, error) {
+	password, names, err := state.submitUnsealPart(identity, part)
+	if err != nil {
+		return false, "", err
+	}
+	if password == nil {
+		logger.Printf("unseal part from %s accepted, waiting for: %s",
+			identity, strings.Join(names, ", "))
+		return false, "waiting for: " + strings.Join(names, ", "), nil
+	}
+	if err := state.unsealCA(password, strings.Join(names, "+")); err != nil {
+		return false, "", err
+	}
+	return true, "unsealed by " + strings.Join(names, ", "), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+var testDualControlConfig = dualControlUnsealConfig{
+	Groups: []dualControlGroup{
+		{Name: "security", Members: []string{"alice", "bob"}},
+		{Name: "operations", Members: []string{"carol"}},
+	},
+}
+
+func TestDualControlUnsealConfigCheck(t *testing.T) {
+	if err := testDualControlConfig.check(autoUnseal{}); err != nil {
+		t.Fatal(err)
+	}
+	config := dualControlUnsealConfig{Groups: testDualControlConfig.Groups[:1]}
+	if err := config.check(autoUnseal{}); err == nil {
+		t.Fatal("single group accepted")
+	}
+	config = dualControlUnsealConfig{Groups: []dualControlGroup{
+		{Name: "security", Members: []string{"alice"}},
+		{Name: "operations", Members: []string{"alice"}},
+	}}
+	if err := config.check(autoUnseal{}); err == nil {
+		t.Fatal("member of two groups accepted")
+	}
+	err := testDualControlConfig.check(autoUnseal{AwsSecretId: "unseal"})
+	if err == nil {
+		t.Fatal("auto unseal accepted")
+	}
+}
+
+func newUnsealPartRequest(t *testing.T, clientName,
+	part string) *http.Request {
+	form := url.Values{"ssh_ca_password": {part}}
+	req, err := http.NewRequest("POST", "/admin/inject?"+form.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cert x509.Certificate
+	cert.Subject.CommonName = clientName
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{&cert}},
+	}
+	return req
+}
+
+func TestDualControlUnseal(t *testing.T) {
+	state := RuntimeState{logger: testlogger.New(t)}
+	state.SSHCARawFileContent = []byte(encryptedTestSignerPrivateKey)
+	state.SignerIsReady = make(chan bool, 1)
+	state.Config.DualControlUnseal = testDualControlConfig
+	// The unseal password is "password".
+	tests := []struct {
+		clientName     string
+		part           string
+		expectedStatus int
+	}{
+		{"mallory", "pass", http.StatusForbidden},
+		{"alice", "pass", http.StatusAccepted},
+		{"bob", "pass", http.StatusAccepted}, // Replaces the part of alice.
+		{"carol", "word", http.StatusOK},
+	}
+	for _, test := range tests {
+		if state.Signer != nil {
+			t.Fatalf("unsealed before %s submitted", test.clientName)
+		}
+		_, err := checkRequestHandlerCode(
+			newUnsealPartRequest(t, test.clientName, test.part),
+			state.secretInjectorHandler, test.expectedStatus)
+		if err != nil {
+			t.Fatalf("%s: %s", test.clientName, err)
+		}
+	}
+	if state.Signer == nil {
+		t.Fatal("The signer should now be loaded")
+	}
+}
+
+func TestDualControlUnsealWindow(t *testing.T) {
+	state := RuntimeState{logger: testlogger.New(t)}
+	state.Config.DualControlUnseal = testDualControlConfig
+	password, missing, err := state.submitUnsealPart("alice", []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != nil {
+		t.Fatal("password returned with one part")
+	}
+	state.dualControl.started = time.Now().Add(-defaultDualControlWindow -
+		time.Second)
+	password, missing, err = state.submitUnsealPart("carol", []byte("word"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != nil {
+		t.Fatal("expired part used")
+	}
+	if len(missing) != 1 || missing[0] != "security" {
+		t.Fatalf("unexpected missing groups: %v", missing)
+	}
+	password, _, err = state.submitUnsealPart("bob", []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(password) != "password" {
+		t.Fatalf("unexpected password: %s", password)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/faultinjection"
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth/ldap/ldaptest"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"github.com/Cloud-Foundations/keymaster/lib/webauthn/webauthntest"
+)
+
+func TestEndToEndWebAuthn(t *testing.T) {
+	ts := newTestServer(t, func(config *AppConfigFile) {
+		config.Base.AllowedAuthBackendsForCerts = []string{
+			proto.AuthTypeU2F}
+	})
+	if code := ts.login("username", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password: %d", code)
+	}
+	if code := ts.login("username", "password"); code != http.StatusOK {
+		t.Fatalf("login failed: %d", code)
+	}
+	if cert, code := ts.getX509Cert("username"); cert != nil {
+		t.Fatal("certificate issued without second factor")
+	} else if code == http.StatusOK {
+		t.Fatal("expected an error")
+	}
+	rp, err := ts.state.getWebAuthnRelyingParty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := webauthntest.New(rp.Origins[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Register the authenticator.
+	var registerRequest webauthnRegisterRequest
+	ts.getJSON(webauthnRegisterRequestPath+"username", &registerRequest)
+	challenge, err := decodeBase64URL(registerRequest.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registration, err := authenticator.Register(registerRequest.RelyingParty.ID,
+		challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := ts.postJSON(webauthnRegisterResponsePath+"username",
+		webauthnRegisterResponse{
+			ID:                encodeBase64URL(registration.CredentialID),
+			ClientDataJSON:    encodeBase64URL(registration.ClientDataJSON),
+			AttestationObject: encodeBase64URL(registration.AttestationObject),
+		})
+	if code != http.StatusOK {
+		t.Fatalf("registration failed: %d", code)
+	}
+	// Authenticate with it.
+	sign := func() *webauthntest.Assertion {
+		var signRequest webauthnSignRequest
+		ts.getJSON(webauthnSignRequestPath, &signRequest)
+		challenge, err := decodeBase64URL(signRequest.Challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertion, err := authenticator.Sign(signRequest.RelyingPartyID,
+			challenge, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return assertion
+	}
+	postAssertion := func(assertion *webauthntest.Assertion,
+		signature []byte) int {
+		return ts.postJSON(webauthnSignResponsePath, webauthnSignResponse{
+			ID:                encodeBase64URL(assertion.CredentialID),
+			ClientDataJSON:    encodeBase64URL(assertion.ClientDataJSON),
+			AuthenticatorData: encodeBase64URL(assertion.AuthenticatorData),
+			Signature:         encodeBase64URL(signature),
+		})
+	}
+	// A failed attempt uses up the challenge.
+	assertion := sign()
+	badSignature := append([]byte(nil), assertion.Signature...)
+	badSignature[len(badSignature)-1] ^= 1
+	if code := postAssertion(assertion, badSignature); code !=
+		http.StatusUnauthorized {
+		t.Fatalf("bad signature: %d", code)
+	}
+	if code := postAssertion(assertion, assertion.Signature); code !=
+		http.StatusBadRequest {
+		t.Fatalf("challenge reused after failure: %d", code)
+	}
+	assertion = sign()
+	if code := postAssertion(assertion, assertion.Signature); code !=
+		http.StatusOK {
+		t.Fatalf("authentication failed: %d", code)
+	}
+	cert, code := ts.getX509Cert("username")
+	if cert == nil {
+		t.Fatalf("certificate request failed: %d", code)
+	}
+	if cert.Subject.CommonName != "username" {
+		t.Fatalf("certificate issued for: %s", cert.Subject.CommonName)
+	}
+}
+
+func TestEndToEndLDAP(t *testing.T) {
+	ldapServer, err := ldaptest.New(map[string]string{
+		"uid=alice,dc=example,dc=com": "ldap-password",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldapServer.Close()
+	ts := newTestServer(t, func(config *AppConfigFile) {
+		caFilename := filepath.Join(config.Base.DataDirectory, "ldap-ca.pem")
+		err := ioutil.WriteFile(caFilename, ldapServer.CACertificatePEM(),
+			0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Base.HtpasswdFilename = ""
+		config.Base.AllowedAuthBackendsForCerts = []string{
+			proto.AuthTypePassword}
+		config.Ldap.LDAPTargetURLs = ldapServer.URL()
+		config.Ldap.BindPattern = "uid=%s,dc=example,dc=com"
+		config.Ldap.CAFilename = caFilename
+	})
+	if code := ts.login("alice", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password: %d", code)
+	}
+	if code := ts.login("alice", "ldap-password"); code != http.StatusOK {
+		t.Fatalf("login failed: %d", code)
+	}
+	cert, code := ts.getX509Cert("alice")
+	if cert == nil {
+		t.Fatalf("certificate request failed: %d", code)
+	}
+	if cert.Subject.CommonName != "alice" {
+		t.Fatalf("certificate issued for: %s", cert.Subject.CommonName)
+	}
+}
+
+func TestEndToEndFaultInjection(t *testing.T) {
+	ts := newTestServer(t, func(config *AppConfigFile) {
+		config.Base.AllowedAuthBackendsForCerts = []string{
+			proto.AuthTypePassword}
+		config.FaultInjection = faultinjection.Config{
+			Enabled: true,
+			Signing: faultinjection.FaultConfig{ErrorRate: 1},
+		}
+	})
+	if code := ts.login("username", "password"); code != http.StatusOK {
+		t.Fatalf("login failed: %d", code)
+	}
+	cert, code := ts.getX509Cert("username")
+	if cert != nil {
+		t.Fatal("certificate issued despite signing faults")
+	}
+	if code != http.StatusInternalServerError {
+		t.Fatalf("expected %d, got: %d", http.StatusInternalServerError,
+			code)
+	}
+}
@@ -71,6 +71,9 @@ func (state *RuntimeState) initEmailDefaults() {
 }
 
 func (state *RuntimeState) setupEmail() error {
+	if err := state.checkSecurityEmailConfig(); err != nil {
+		return err
+	}
 	if state.Config.Email.Domain == "" {
 		return nil
 	}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// enrollmentPaths are the paths which a session authenticated with a
+// bootstrap OTP instead of a second factor may use: the profile page and the
+// registration of the first second factor. Paths ending in "/" are followed
+// by the username.
+var enrollmentPaths = []string{
+	profilePath,
+	totpGeneratNewPath,
+	totpValidateNewPath,
+	u2fRegustisterRequestPath,
+	u2fRegisterRequesponsePath,
+	webauthnRegisterRequestPath,
+	webauthnRegisterResponsePath,
+}
+
+// isEnrollmentPath returns true if path is one of enrollmentPaths, for
+// username.
+func isEnrollmentPath(path, username string) bool {
+	for _, enrollmentPath := range enrollmentPaths {
+		if path == enrollmentPath || path == enrollmentPath+username {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEnabledSecondFactor(profile *userProfile) bool {
+	for _, data := range profile.U2fAuthData {
+		if data.Enabled {
+			return true
+		}
+	}
+	for _, data := range profile.TOTPAuthData {
+		if data.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// isEnrollmentOnlyAuth returns true if authType only meets requiredAuthType
+// thanks to a bootstrap OTP.
+func isEnrollmentOnlyAuth(authType, requiredAuthType int) bool {
+	return (authType&AuthTypeBootstrapOTP) != 0 &&
+		(authType&requiredAuthType&^AuthTypeBootstrapOTP) == 0
+}
+
+// checkEnrollmentAuth is the part of checkAuth for sessions which are only
+// authenticated with a bootstrap OTP. These may only register the first
+// second factor of the user; once one is registered the user must log in
+// with it. This holds even if the web UI does not allow bootstrap OTPs.
+func (state *RuntimeState) checkEnrollmentAuth(w http.ResponseWriter,
+	r *http.Request, info *authInfo) (*authInfo, error) {
+	if !isEnrollmentPath(r.URL.Path, info.Username) {
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Register a second factor and log in with it first")
+		return nil, errors.New("bootstrap OTP session used for: " +
+			r.URL.Path)
+	}
+	if strings.HasPrefix(r.URL.Path, profilePath) {
+		return info, nil
+	}
+	profile, _, _, err := state.LoadUserProfile(info.Username)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return nil, err
+	}
+	if hasEnabledSecondFactor(profile) {
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Second factor already registered, log in with it")
+		return nil, errors.New("bootstrap OTP session of: " + info.Username +
+			" already registered a second factor")
+	}
+	return info, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+	"golang.org/x/crypto/ssh"
+)
+
+// fipsBuild is set when keymasterd is built with a FIPS validated crypto
+// module (see fips_boringcrypto.go), in which case FIPS mode is always on.
+var fipsBuild bool
+
+// fipsConfig enables FIPS mode at runtime: TLS, signature hashes and keys are
+// restricted to FIPS approved algorithms, and non-compliant CA keys are
+// refused.
+type fipsConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// fipsStatus is the FIPS section of the status document.
+type fipsStatus struct {
+	Enabled bool `json:"enabled"`
+	// Built with a FIPS validated crypto module.
+	ValidatedModule bool     `json:"validated_module"`
+	Compliant       bool     `json:"compliant"`
+	Issues          []string `json:"issues,omitempty"`
+}
+
+var (
+	fipsCipherSuites = []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	}
+	fipsCurvePreferences = []tls.CurveID{tls.CurveP384, tls.CurveP256}
+)
+
+func (state *RuntimeState) fipsMode() bool {
+	return fipsBuild || state.Config.FIPS.Enabled
+}
+
+// applyFIPSTLSConfig restricts config to FIPS approved cipher suites and
+// curves when in FIPS mode. TLS 1.3 is disabled since its cipher suites,
+// which include ChaCha20-Poly1305, cannot be configured.
+func (state *RuntimeState) applyFIPSTLSConfig(config *tls.Config) *tls.Config {
+	if !state.fipsMode() {
+		return config
+	}
+	config.MinVersion = tls.VersionTLS12
+	config.MaxVersion = tls.VersionTLS12
+	config.CipherSuites = fipsCipherSuites
+	config.CurvePreferences = fipsCurvePreferences
+	return config
+}
+
+// checkFIPSSigners returns an error if the CA keys may not be used in FIPS
+// mode.
+func (state *RuntimeState) checkFIPSSigners(signer,
+	edSigner crypto.Signer) error {
+	if !state.fipsMode() {
+		return nil
+	}
+	if err := certgen.CheckFIPSPublicKey(signer.Public()); err != nil {
+		return fmt.Errorf("CA key refused in FIPS mode: %s", err)
+	}
+	if edSigner != nil {
+		return errors.New("Ed25519 CA key refused in FIPS mode")
+	}
+	return nil
+}
+
+// checkFIPSUserKey returns an error if a certificate may not be issued for
+// pub in FIPS mode. pub may be an SSH or a crypto public key.
+func (state *RuntimeState) checkFIPSUserKey(pub interface{}) error {
+	if !state.fipsMode() {
+		return nil
+	}
+	if sshKey, ok := pub.(ssh.PublicKey); ok {
+		cryptoKey, ok := sshKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return fmt.Errorf("key type %s not FIPS approved", sshKey.Type())
+		}
+		pub = cryptoKey.CryptoPublicKey()
+	}
+	return certgen.CheckFIPSPublicKey(pub)
+}
+
+// checkFIPSSSHSignatureAlgorithm returns an error if SSH certificates would
+// be signed with SHA-1 in FIPS mode.
+func (state *RuntimeState) checkFIPSSSHSignatureAlgorithm() error {
+	if state.fipsMode() &&
+		state.Config.Base.SSHSignatureAlgorithm == ssh.SigAlgoRSA {
+		return errors.New(
+			"ssh_signature_algorithm: ssh-rsa (SHA-1) refused in FIPS mode")
+	}
+	return nil
+}
+
+func (state *RuntimeState) getFIPSStatus() fipsStatus {
+	status := fipsStatus{
+		Enabled:         state.fipsMode(),
+		ValidatedModule: fipsBuild,
+	}
+	if !status.Enabled {
+		status.Issues = append(status.Issues, "FIPS mode not enabled")
+	}
+	if !status.ValidatedModule {
+		status.Issues = append(status.Issues,
+			"not built with a FIPS validated crypto module")
+	}
+	state.Mutex.RLock()
+	signer := state.Signer
+	edSigner := state.Ed25519Signer
+	state.Mutex.RUnlock()
+	if signer != nil {
+		if err := certgen.CheckFIPSPublicKey(signer.Public()); err != nil {
+			status.Issues = append(status.Issues, "CA key: "+err.Error())
+		}
+	}
+	if edSigner != nil {
+		status.Issues = append(status.Issues, "Ed25519 CA key loaded")
+	}
+	if state.certReloader != nil {
+		cert, err := state.certReloader.GetCertificate(&tls.ClientHelloInfo{})
+		if err == nil && cert != nil && len(cert.Certificate) > 0 {
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err == nil {
+				err = certgen.CheckFIPSPublicKey(leaf.PublicKey)
+			}
+			if err != nil {
+				status.Issues = append(status.Issues,
+					"TLS certificate: "+err.Error())
+			}
+		}
+	}
+	status.Compliant = len(status.Issues) < 1
+	return status
+}
@@ -0,0 +1,10 @@
+//go:build boringcrypto
+// +build boringcrypto
+
+package main
+
+import _ "crypto/tls/fipsonly" // Restricts crypto/tls to FIPS settings.
+
+func init() {
+	fipsBuild = true
+}
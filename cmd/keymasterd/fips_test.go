@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestApplyFIPSTLSConfig(t *testing.T) {
+	state := &RuntimeState{}
+	config := state.applyFIPSTLSConfig(&tls.Config{})
+	if config.CipherSuites != nil || config.MaxVersion != 0 {
+		t.Fatal("TLS configuration restricted without FIPS mode")
+	}
+	state.Config.FIPS.Enabled = true
+	config = state.applyFIPSTLSConfig(&tls.Config{
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA},
+	})
+	if config.MaxVersion != tls.VersionTLS12 {
+		t.Fatal("TLS 1.3 not disabled")
+	}
+	for _, suite := range config.CipherSuites {
+		if suite == tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA {
+			t.Fatal("CBC cipher suite allowed in FIPS mode")
+		}
+	}
+}
+
+func TestFIPSKeys(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	state.Config.FIPS.Enabled = true
+	_, edSigner, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.checkFIPSSigners(state.Signer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.checkFIPSSigners(state.Signer, edSigner); err == nil {
+		t.Fatal("Ed25519 CA key accepted in FIPS mode")
+	}
+	if err := state.checkFIPSSigners(edSigner, nil); err == nil {
+		t.Fatal("Ed25519 X.509 CA key accepted in FIPS mode")
+	}
+	sshEdKey, err := ssh.NewPublicKey(edSigner.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.checkFIPSUserKey(sshEdKey); err == nil {
+		t.Fatal("Ed25519 user key accepted in FIPS mode")
+	}
+	if err := state.checkFIPSUserKey(state.Signer.Public()); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.FIPS.Enabled = false
+	if err := state.checkFIPSUserKey(sshEdKey); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFIPSSSHSigner(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	data := []byte("data to sign")
+	signature, err := state.sshSigner.Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature.Format != ssh.SigAlgoRSASHA2512 {
+		t.Fatalf("unexpected signature format: %s", signature.Format)
+	}
+	if err := state.sshSigner.PublicKey().Verify(data, signature); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.SSHSignatureAlgorithm = ssh.SigAlgoRSA
+	if err := state.checkFIPSSSHSignatureAlgorithm(); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.FIPS.Enabled = true
+	if err := state.checkFIPSSSHSignatureAlgorithm(); err == nil {
+		t.Fatal("SHA-1 signatures allowed in FIPS mode")
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	state.Config.FIPS.Enabled = true
+	req, err := http.NewRequest("GET", statusPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.statusHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status serverStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Ready || !status.FIPS.Enabled {
+		t.Fatalf("unexpected status: %s", rr.Body)
+	}
+	if status.FIPS.Compliant != fipsBuild {
+		t.Fatalf("unexpected compliance: %s", rr.Body)
+	}
+}
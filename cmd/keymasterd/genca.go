@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// The largest iteration count OpenPGP can encode, about 65 million bytes
+	// of hashing per passphrase guess.
+	genCAS2KCount         = 65011712
+	genCAMinRSABits       = 2048
+	genCAPassphraseLength = 32
+)
+
+// genCAKeyTypes maps the -type values of genca to the config field which
+// takes the key file: Ed25519 keys are an additional SSH CA key.
+var genCAKeyTypes = map[string]string{
+	"rsa":        "ssh_ca_filename",
+	"ecdsa-p256": "ssh_ca_filename",
+	"ecdsa-p384": "ssh_ca_filename",
+	"ecdsa-p521": "ssh_ca_filename",
+	"ed25519":    "ed25519_ca_keyfilename",
+}
+
+// genCAPGPConfig encrypts with AES-256 and the strongest iterated and salted
+// S2K that OpenPGP supports, rather than the library defaults.
+var genCAPGPConfig = &packet.Config{
+	DefaultCipher:          packet.CipherAES256,
+	DefaultHash:            crypto.SHA256,
+	DefaultCompressionAlgo: packet.CompressionNone,
+	S2KCount:               genCAS2KCount,
+}
+
+// generateCAKey returns a new CA key of keyType, PEM encoded in the form
+// keymasterd reads.
+func generateCAKey(keyType string, rsaBits int) (crypto.Signer, *pem.Block,
+	error) {
+	var curve elliptic.Curve
+	switch keyType {
+	case "rsa":
+		if rsaBits < genCAMinRSABits {
+			return nil, nil, fmt.Errorf("RSA keys need at least %d bits",
+				genCAMinRSABits)
+		}
+		key, err := rsa.GenerateKey(rand.Reader, rsaBits)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}, nil
+	case "ecdsa-p256":
+		curve = elliptic.P256()
+	case "ecdsa-p384":
+		curve = elliptic.P384()
+	case "ecdsa-p521":
+		curve = elliptic.P521()
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown key type: %s", keyType)
+	}
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+}
+
+// pgpEncrypt returns plaintext as an armored PGP message, encrypted to the
+// recipients if there are any, else with the passphrase.
+func pgpEncrypt(plaintext []byte, passphrase []byte,
+	recipients openpgp.EntityList) ([]byte, error) {
+	armoredBuf := new(bytes.Buffer)
+	armoredWriter, err := armor.Encode(armoredBuf, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+	var plaintextWriter io.WriteCloser
+	if len(recipients) > 0 {
+		plaintextWriter, err = openpgp.Encrypt(armoredWriter, recipients, nil,
+			nil, genCAPGPConfig)
+	} else {
+		plaintextWriter, err = openpgp.SymmetricallyEncrypt(armoredWriter,
+			passphrase, nil, genCAPGPConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := plaintextWriter.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := plaintextWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := armoredWriter.Close(); err != nil {
+		return nil, err
+	}
+	return armoredBuf.Bytes(), nil
+}
+
+// generateUnsealPassphrase returns a random passphrase which is easy to
+// paste into keymaster-unlocker.
+func generateUnsealPassphrase() ([]byte, error) {
+	buffer := make([]byte, genCAPassphraseLength)
+	if _, err := rand.Read(buffer); err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(buffer)), nil
+}
+
+func readPGPRecipients(filenames []string) (openpgp.EntityList, error) {
+	var recipients openpgp.EntityList
+	for _, filename := range filenames {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		entities, err := openpgp.ReadArmoredKeyRing(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		recipients = append(recipients, entities...)
+	}
+	return recipients, nil
+}
+
+func formatFingerprint(sum []byte) string {
+	hexSum := hex.EncodeToString(sum)
+	pairs := make([]string, 0, len(sum))
+	for i := 0; i < len(hexSum); i += 2 {
+		pairs = append(pairs, hexSum[i:i+2])
+	}
+	return strings.Join(pairs, ":")
+}
+
+// writeCAFingerprints writes the fingerprints of the CA key and of the
+// recipients, for the ceremony record.
+func writeCAFingerprints(writer io.Writer, signer crypto.Signer,
+	recipients openpgp.EntityList) error {
+	sshPublicKey, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return err
+	}
+	spkiSum := sha256.Sum256(spki)
+	fmt.Fprintf(writer, "SSH public key:       %s\n",
+		strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublicKey))))
+	fmt.Fprintf(writer, "SSH fingerprint:      %s\n",
+		ssh.FingerprintSHA256(sshPublicKey))
+	fmt.Fprintf(writer, "SPKI SHA-256:         %s\n",
+		formatFingerprint(spkiSum[:]))
+	for _, recipient := range recipients {
+		var name string
+		for name = range recipient.Identities {
+			break
+		}
+		fmt.Fprintf(writer, "Encrypted to:         %X %s\n",
+			recipient.PrimaryKey.Fingerprint, name)
+	}
+	return nil
+}
+
+// writeCAKeyFiles writes the files of files, keyed by name, refusing to
+// overwrite any existing file.
+func writeCAKeyFiles(files map[string][]byte) error {
+	for name := range files {
+		if _, err := os.Stat(name); err == nil {
+			return fmt.Errorf("%s already exists", name)
+		}
+	}
+	for name, data := range files {
+		mode := os.FileMode(0600)
+		if strings.HasSuffix(name, ".pub") {
+			mode = 0644
+		}
+		file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(data); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateCAKeyFiles generates a CA key and returns the files to write: the
+// key encrypted with the passphrase, its SSH public key and, if there are
+// recipients, a random passphrase encrypted to them.
+func generateCAKeyFiles(keyType string, rsaBits int, filename string,
+	passphrase []byte, recipients openpgp.EntityList) (
+	crypto.Signer, map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if len(recipients) > 0 {
+		var err error
+		passphrase, err = generateUnsealPassphrase()
+		if err != nil {
+			return nil, nil, err
+		}
+		files[filename+".passphrase.asc"], err = pgpEncrypt(passphrase, nil,
+			recipients)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(passphrase) < 1 {
+		return nil, nil, errors.New("empty passphrase")
+	}
+	signer, block, err := generateCAKey(keyType, rsaBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	files[filename], err = pgpEncrypt(pem.EncodeToMemory(block), passphrase,
+		nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	sshPublicKey, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	files[filename+".pub"] = ssh.MarshalAuthorizedKey(sshPublicKey)
+	return signer, files, nil
+}
+
+// genCA implements "keymasterd genca", which generates a CA key for a key
+// ceremony.
+func genCA(args []string) error {
+	flagSet := flag.NewFlagSet("genca", flag.ContinueOnError)
+	keyType := flagSet.String("type", "rsa",
+		"Key type: rsa, ecdsa-p256, ecdsa-p384, ecdsa-p521 or ed25519")
+	rsaBits := flagSet.Int("bits", 4096, "Size of RSA keys")
+	output := flagSet.String("output", "masterKey.asc",
+		"The encrypted key file to write. The public key is written to .pub")
+	passphraseFile := flagSet.String("passphraseFile", "",
+		"Read the passphrase from this file rather than prompting for it")
+	recipientsList := flagSet.String("recipients", "",
+		"Comma separated armored PGP public key files to encrypt a random passphrase to, rather than choosing one")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s",
+			strings.Join(flagSet.Args(), " "))
+	}
+	configField, ok := genCAKeyTypes[*keyType]
+	if !ok {
+		return fmt.Errorf("unknown key type: %s", *keyType)
+	}
+	var recipients openpgp.EntityList
+	var passphrase []byte
+	if *recipientsList != "" {
+		if *passphraseFile != "" {
+			return errors.New("-recipients and -passphraseFile are exclusive")
+		}
+		var err error
+		recipients, err = readPGPRecipients(
+			strings.Split(*recipientsList, ","))
+		if err != nil {
+			return err
+		}
+	} else if *passphraseFile != "" {
+		data, err := ioutil.ReadFile(*passphraseFile)
+		if err != nil {
+			return err
+		}
+		passphrase = bytes.TrimRight(data, "\r\n")
+	} else {
+		var err error
+		if passphrase, err = getPassphrase(); err != nil {
+			return err
+		}
+	}
+	signer, files, err := generateCAKeyFiles(*keyType, *rsaBits, *output,
+		passphrase, recipients)
+	if err != nil {
+		return err
+	}
+	if err := writeCAKeyFiles(files); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s key to: %s (set %s)\n", *keyType, *output,
+		configField)
+	if len(recipients) > 0 {
+		fmt.Printf("Wrote unseal passphrase to: %s.passphrase.asc\n", *output)
+	}
+	return writeCAFingerprints(os.Stdout, signer, recipients)
+}
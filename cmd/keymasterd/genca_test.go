@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func TestGenerateCAKeyFilesWithPassphrase(t *testing.T) {
+	for keyType, expected := range map[string]string{
+		"rsa":        "*rsa.PrivateKey",
+		"ecdsa-p256": "*ecdsa.PrivateKey",
+		"ed25519":    "ed25519.PrivateKey",
+	} {
+		signer, files, err := generateCAKeyFiles(keyType, 2048, "ca.asc",
+			[]byte("password"), nil)
+		if err != nil {
+			t.Fatalf("%s: %s", keyType, err)
+		}
+		if len(files) != 2 || files["ca.asc.pub"] == nil {
+			t.Fatalf("%s: unexpected files: %v", keyType, files)
+		}
+		keyPEM, err := pgpDecryptFileData(files["ca.asc"], []byte("password"))
+		if err != nil {
+			t.Fatalf("%s: %s", keyType, err)
+		}
+		parsed, err := getSignerFromPEMBytes(keyPEM)
+		if err != nil {
+			t.Fatalf("%s: %s", keyType, err)
+		}
+		if fmt.Sprintf("%T", parsed) != expected ||
+			fmt.Sprintf("%T", signer) != expected {
+			t.Fatalf("%s: unexpected key type: %T", keyType, parsed)
+		}
+	}
+	if _, _, err := generateCAKeyFiles("rsa", 1024, "ca.asc",
+		[]byte("password"), nil); err == nil {
+		t.Fatal("no error for short RSA key")
+	}
+	if _, _, err := generateCAKeyFiles("rsa", 2048, "ca.asc", nil,
+		nil); err == nil {
+		t.Fatal("no error for empty passphrase")
+	}
+}
+
+func TestGenerateCAKeyFilesWithRecipients(t *testing.T) {
+	admin, err := openpgp.NewEntity("admin", "", "admin@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, identity := range admin.Identities {
+		identity.SelfSignature.PreferredHash = []uint8{8}      // SHA256.
+		identity.SelfSignature.PreferredSymmetric = []uint8{9} // AES256.
+	}
+	_, files, err := generateCAKeyFiles("ecdsa-p384", 0, "ca.asc", nil,
+		openpgp.EntityList{admin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := armor.Decode(bytes.NewReader(files["ca.asc.passphrase.asc"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{admin}, nil,
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passphrase) != 2*genCAPassphraseLength {
+		t.Fatalf("unexpected passphrase length: %d", len(passphrase))
+	}
+	if _, err := pgpDecryptFileData(files["ca.asc"], passphrase); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteCAKeyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "genca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string][]byte{
+		filepath.Join(dir, "ca.asc"):     []byte("key"),
+		filepath.Join(dir, "ca.asc.pub"): []byte("public key"),
+	}
+	if err := writeCAKeyFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "ca.asc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Fatalf("expected mode: 0600, got: %o", perm)
+	}
+	if err := writeCAKeyFiles(files); err == nil {
+		t.Fatal("existing files overwritten")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/alerting"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultHoneytokenSeverity = "critical"
+	honeytokenAlertInterval   = 10 * time.Minute
+
+	honeytokenStagePasswordAttempt = "password_attempt"
+	honeytokenStagePasswordValid   = "password_valid"
+	honeytokenStageSession         = "session"
+	honeytokenStageCertificate     = "certificate"
+)
+
+var errHoneytoken = errors.New("honeytoken account")
+
+var honeytokenTripCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "keymaster_honeytoken_trips_total",
+		Help: "Uses of honeytoken accounts, by stage reached.",
+	},
+	[]string{"stage"},
+)
+
+func init() {
+	prometheus.MustRegister(honeytokenTripCounter)
+}
+
+// honeytokenConfig lists decoy usernames. They can never log in or be
+// issued a certificate, and any attempt to is alerted on.
+type honeytokenConfig struct {
+	Usernames []string `yaml:"usernames"`
+	Severity  string   `yaml:"severity"` // Default: critical.
+}
+
+// honeytokenAlertCache limits alerts to one per username, source address
+// and stage per honeytokenAlertInterval, so that a guessing attack does not
+// flood the notification queue. The zero value is ready to use.
+type honeytokenAlertCache struct {
+	mutex sync.Mutex
+	sent  map[string]time.Time
+}
+
+func (config *honeytokenConfig) check() error {
+	if config.Severity != "" && !alerting.ValidSeverity(config.Severity) {
+		return fmt.Errorf("honeytokens: invalid severity: %s", config.Severity)
+	}
+	return nil
+}
+
+func (config *honeytokenConfig) getSeverity() string {
+	if config.Severity != "" {
+		return config.Severity
+	}
+	return defaultHoneytokenSeverity
+}
+
+// shouldSend returns true if no alert for key was sent recently.
+func (cache *honeytokenAlertCache) shouldSend(key string,
+	now time.Time) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.sent == nil {
+		cache.sent = make(map[string]time.Time)
+	}
+	if last, ok := cache.sent[key]; ok &&
+		now.Sub(last) < honeytokenAlertInterval {
+		return false
+	}
+	for name, last := range cache.sent {
+		if now.Sub(last) >= honeytokenAlertInterval {
+			delete(cache.sent, name)
+		}
+	}
+	cache.sent[key] = now
+	return true
+}
+
+// isHoneytoken returns true if username is a decoy. Usernames are compared
+// without regard to case, so that variants are caught too.
+func (state *RuntimeState) isHoneytoken(username string) bool {
+	for _, honeytoken := range state.Config.Honeytokens.Usernames {
+		if strings.EqualFold(username, honeytoken) {
+			return true
+		}
+	}
+	return false
+}
+
+// tripHoneytoken records and alerts on a use of a honeytoken account.
+func (state *RuntimeState) tripHoneytoken(r *http.Request, username,
+	stage string) {
+	honeytokenTripCounter.WithLabelValues(stage).Inc()
+	sourceIP := ""
+	userAgent := ""
+	if r != nil {
+		sourceIP = getSourceIP(r.RemoteAddr)
+		userAgent = r.UserAgent()
+	}
+	logger.Printf("HONEYTOKEN: %s used by %s (%s), stage: %s",
+		username, sourceIP, userAgent, stage)
+	key := username + "\x00" + sourceIP + "\x00" + stage
+	if !state.honeytokenAlerts.shouldSend(key, time.Now()) {
+		return
+	}
+	state.alerter.Send(alerting.Event{
+		Type: alerting.EventHoneytoken,
+		Summary: fmt.Sprintf("honeytoken account %s used from %s: %s",
+			username, sourceIP, stage),
+		Target:   username,
+		Severity: state.Config.Honeytokens.getSeverity(),
+		Details: map[string]string{
+			"source_address": sourceIP,
+			"stage":          stage,
+			"user_agent":     userAgent,
+		},
+	})
+}
+
+// checkHoneytokenPassword checks the password of a honeytoken account only
+// to report whether it was guessed, and always refuses it.
+func (state *RuntimeState) checkHoneytokenPassword(username, password string,
+	r *http.Request) {
+	stage := honeytokenStagePasswordAttempt
+	if state.passwordChecker != nil {
+		valid, err := state.passwordChecker.PasswordAuthenticate(username,
+			[]byte(password))
+		if err == nil && valid {
+			stage = honeytokenStagePasswordValid
+		}
+	}
+	state.tripHoneytoken(r, username, stage)
+	metricLogAuthOperation(getClientType(r), "password", false)
+}
+
+// checkHoneytoken returns true if username is not a honeytoken. Otherwise
+// the use is alerted on and a response revealing nothing is written.
+func (state *RuntimeState) checkHoneytoken(w http.ResponseWriter,
+	r *http.Request, username, stage string) bool {
+	if !state.isHoneytoken(username) {
+		return true
+	}
+	state.tripHoneytoken(r, username, stage)
+	state.writeFailureResponse(w, r, http.StatusForbidden, "")
+	return false
+}
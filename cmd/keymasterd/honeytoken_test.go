@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/alerting"
+)
+
+func TestHoneytokenAlertCache(t *testing.T) {
+	var cache honeytokenAlertCache
+	now := time.Now()
+	if !cache.shouldSend("decoy", now) {
+		t.Fatal("first alert not sent")
+	}
+	if cache.shouldSend("decoy", now.Add(time.Minute)) {
+		t.Fatal("repeated alert sent")
+	}
+	if !cache.shouldSend("other", now) {
+		t.Fatal("other alert not sent")
+	}
+	if !cache.shouldSend("decoy", now.Add(honeytokenAlertInterval)) {
+		t.Fatal("alert not sent after interval")
+	}
+}
+
+func TestHoneytoken(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	messages := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			var message struct{ Text string }
+			json.Unmarshal(body, &message)
+			messages <- message.Text
+		}))
+	defer server.Close()
+	state.alerter, err = alerting.New(alerting.Config{
+		Slack: []alerting.SlackConfig{{WebhookURL: server.URL}},
+	}, "keymaster.example.com", testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Honeytokens.Usernames = []string{"decoy"}
+	state.passwordChecker = &testPasswordChanger{
+		passwords: map[string]string{"decoy": "secret"}}
+	waitForAlert := func(expected string) {
+		select {
+		case text := <-messages:
+			if !strings.Contains(text, expected) ||
+				!strings.Contains(text, "[critical]") {
+				t.Fatalf("unexpected alert: %s", text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no alert for: %s", expected)
+		}
+	}
+	req := httptest.NewRequest("POST", "/api/v0/login", nil)
+	for _, password := range []string{"wrong", "secret"} {
+		valid, err := state.checkUserPassword("decoy", password, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid {
+			t.Fatal("honeytoken password accepted")
+		}
+	}
+	waitForAlert(honeytokenStagePasswordAttempt)
+	waitForAlert(honeytokenStagePasswordValid)
+	if _, err := state.setNewAuthCookie(nil, "Decoy",
+		AuthTypePassword); err != errHoneytoken {
+		t.Fatalf("expected errHoneytoken, got: %v", err)
+	}
+	waitForAlert(honeytokenStageSession)
+	w := httptest.NewRecorder()
+	if state.checkHoneytoken(w, req, "decoy", honeytokenStageCertificate) {
+		t.Fatal("honeytoken not refused")
+	}
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected: %d, got: %d", http.StatusForbidden, w.Code)
+	}
+	waitForAlert(honeytokenStageCertificate)
+	if !state.checkHoneytoken(w, req, "username", honeytokenStageCertificate) {
+		t.Fatal("ordinary user refused")
+	}
+}
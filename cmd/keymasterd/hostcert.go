@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/instanceidentity"
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	hostCertgenPath                = "/api/v0/hostCertgen"
+	defaultHostCertificateLifetime = time.Hour * 24 * 7
+	providerBootstrapToken         = "bootstrap"
+)
+
+// authenticateBootstrapToken returns an identity for the hostnames in the
+// request if the bootstrap token is configured and allows all of them.
+func (state *RuntimeState) authenticateBootstrapToken(token string,
+	hostnames []string) (*instanceidentity.Identity, error) {
+	if len(hostnames) < 1 {
+		return nil, errors.New("no hostnames requested")
+	}
+	for _, bootstrapToken := range state.Config.HostCertificates.BootstrapTokens {
+		if subtle.ConstantTimeCompare([]byte(token),
+			[]byte(bootstrapToken.Token)) != 1 {
+			continue
+		}
+		for _, hostname := range hostnames {
+			if !bootstrapToken.allowedHostnames.MatchString(hostname) {
+				return nil, fmt.Errorf("hostname: %s not allowed", hostname)
+			}
+		}
+		return &instanceidentity.Identity{
+			Provider:   providerBootstrapToken,
+			InstanceID: hostnames[0],
+			Hostnames:  hostnames,
+		}, nil
+	}
+	return nil, errors.New("invalid bootstrap token")
+}
+
+// authenticateHost verifies the instance identity or bootstrap token in the
+// request form.
+func (state *RuntimeState) authenticateHost(r *http.Request) (
+	*instanceidentity.Identity, error) {
+	if token := r.Form.Get("bootstrap_token"); token != "" {
+		return state.authenticateBootstrapToken(token, r.Form["hostname"])
+	}
+	if state.instanceVerifier == nil {
+		return nil, errors.New("instance identities not enabled")
+	}
+	if document := r.Form.Get("aws_identity_document"); document != "" {
+		return state.instanceVerifier.VerifyAWS([]byte(document),
+			r.Form.Get("aws_identity_signature"))
+	}
+	if token := r.Form.Get("gcp_identity_token"); token != "" {
+		return state.instanceVerifier.VerifyGCP(token)
+	}
+	return nil, errors.New("no instance identity provided")
+}
+
+// hostCertgenHandler issues SSH host certificates to cloud instances which
+// authenticate with their instance identity, and to hosts which present a
+// bootstrap token. The certificate is only valid for the names the cloud
+// provider gives the instance or, for bootstrap tokens, the requested names
+// which the token allows.
+func (state *RuntimeState) hostCertgenHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if state.instanceVerifier == nil &&
+		len(state.Config.HostCertificates.BootstrapTokens) < 1 {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if err := r.ParseMultipartForm(1e6); err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	identity, err := state.authenticateHost(r)
+	if err != nil {
+		logger.Printf("host authentication failed from %s: %s",
+			r.RemoteAddr, err)
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+		return
+	}
+	hostName := identity.Provider + "/" + identity.InstanceID
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(hostName)
+	duration := state.Config.HostCertificates.Lifetime
+	if duration <= 0 {
+		duration = defaultHostCertificateLifetime
+	}
+	pubKeyData, userErr, err := readPublicKeyFile(r)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if userErr != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, userErr.Error())
+		return
+	}
+	hostPubKey := string(pubKeyData)
+	sshHostPublicKey, userErr, err := getValidSSHPublicKey(hostPubKey,
+		state.Config.Base.MinRSAKeyBits)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if userErr != nil {
+		logger.Printf("validating Error err: %s", userErr)
+		state.writeFailureResponse(w, r, http.StatusBadRequest, userErr.Error())
+		return
+	}
+	state.Mutex.RLock()
+	signer := state.sshSigner
+	if sshHostPublicKey.Type() == ssh.KeyAlgoED25519 &&
+		state.ed25519SSHSigner != nil {
+		signer = state.ed25519SSHSigner
+	}
+	state.Mutex.RUnlock()
+	if signer == nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		logger.Printf("Signer not loaded")
+		return
+	}
+	issuance, err := newIssuanceContext(r, "host:"+identity.Provider)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	start := time.Now()
+	certString, cert, err := certgen.GenSSHHostCertFileStringWithAuditID(
+		hostPubKey, signer, state.HostIdentity, identity.Hostnames,
+		issuance.AuditID, duration)
+	observeSigning(operationSSHHostSign, start, err)
+	if err != nil {
+		state.writeSigningFailureResponse(w, r, err)
+		logger.Printf("error signing host key: %s", err)
+		return
+	}
+	eventNotifier.PublishSSH(cert.Marshal())
+	state.streamSSHCertificate(hostName, &cert, r, issuance)
+	go state.recordIssuedCertificate(newSSHIssuedCertRecord(hostName, &cert,
+		r, issuance))
+	metricLogCertDuration("ssh-host", "granted", float64(duration.Seconds()))
+	w.Header().Set("Content-Disposition",
+		"attachment; filename=\""+cert.Type()+"-cert.pub\"")
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "%s", certString)
+	logger.Printf("Generated SSH host Certificate for %s (%s). Serial:%d",
+		hostName, identity.Account, cert.Serial)
+	go func(username string, certType string) {
+		metricsMutex.Lock()
+		defer metricsMutex.Unlock()
+		certGenCounter.WithLabelValues(username, certType).Inc()
+	}(hostName, "ssh-host")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/instanceidentity"
+	"golang.org/x/crypto/ssh"
+)
+
+const testAWSIdentityDocument = `{"accountId":"123456789012",` +
+	`"instanceId":"i-0123456789abcdef0","privateIp":"10.1.2.3",` +
+	`"region":"us-east-1"}`
+
+func createHostCertRequest(t *testing.T,
+	fields map[string]string) *http.Request {
+	bodyBuf := &bytes.Buffer{}
+	bodyWriter := multipart.NewWriter(bodyBuf)
+	fileWriter, err := bodyWriter.CreateFormFile("pubkeyfile", "host.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileWriter.Write([]byte(testUserSSHPublicKey))
+	for name, value := range fields {
+		if err := bodyWriter.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bodyWriter.Close()
+	req, err := http.NewRequest("POST", hostCertgenPath, bodyBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", bodyWriter.FormDataContentType())
+	return req
+}
+
+func TestHostCertgenAWS(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	// Pretend to be the AWS regional certificate.
+	awsKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Amazon Web Services LLC"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		&awsKey.PublicKey, awsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFilename := filepath.Join(tmpdir, "aws.pem")
+	err = ioutil.WriteFile(certFilename,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.instanceVerifier, err = instanceidentity.New(instanceidentity.Config{
+		AWS: instanceidentity.AWSConfig{
+			AllowedAccountIDs:   []string{"123456789012"},
+			CertificateFilename: certFilename,
+		}}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256([]byte(testAWSIdentityDocument))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, awsKey, crypto.SHA256,
+		hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := createHostCertRequest(t, map[string]string{
+		"aws_identity_document":  testAWSIdentityDocument,
+		"aws_identity_signature": base64.StdEncoding.EncodeToString(signature),
+	})
+	rr, err := checkRequestHandlerCode(req, state.hostCertgenHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		t.Fatal("response is not a certificate")
+	}
+	if cert.CertType != ssh.HostCert ||
+		cert.ValidPrincipals[0] != "ip-10-1-2-3.ec2.internal" {
+		t.Fatalf("unexpected certificate: %+v", cert)
+	}
+	// Requests without a valid identity must be rejected.
+	req = createHostCertRequest(t, map[string]string{
+		"aws_identity_document":  testAWSIdentityDocument,
+		"aws_identity_signature": base64.StdEncoding.EncodeToString([]byte("x")),
+	})
+	_, err = checkRequestHandlerCode(req, state.hostCertgenHandler,
+		http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = createHostCertRequest(t, nil)
+	_, err = checkRequestHandlerCode(req, state.hostCertgenHandler,
+		http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHostCertgenBootstrapToken(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	state.Config.HostCertificates.BootstrapTokens = []HostBootstrapTokenConfig{{
+		Token:            "0123456789abcdef",
+		allowedHostnames: regexp.MustCompile(`^(?:[a-z0-9-]+\.example\.com)$`),
+	}}
+	req := createHostCertRequest(t, map[string]string{
+		"bootstrap_token": "0123456789abcdef",
+		"hostname":        "web-1.example.com",
+	})
+	rr, err := checkRequestHandlerCode(req, state.hostCertgenHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok || len(cert.ValidPrincipals) != 1 ||
+		cert.ValidPrincipals[0] != "web-1.example.com" {
+		t.Fatalf("unexpected certificate: %+v", pubKey)
+	}
+	badRequests := []map[string]string{
+		{"bootstrap_token": "0123456789abcdef", "hostname": "web-1.evil.com"},
+		{"bootstrap_token": "0123456789abcdeX", "hostname": "web-1.example.com"},
+		{"bootstrap_token": "0123456789abcdef"},
+	}
+	for _, fields := range badRequests {
+		req := createHostCertRequest(t, fields)
+		_, err := checkRequestHandlerCode(req, state.hostCertgenHandler,
+			http.StatusUnauthorized)
+		if err != nil {
+			t.Fatalf("%v: %s", fields, err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+)
+
+const (
+	hostCertStatusPath      = "/api/v0/hostCertStatus"
+	hostCertificatesPath    = "/admin/hostCertificates"
+	hostCertStatusRetention = 30 * 24 * time.Hour
+	maxHostCertStatusError  = 1024
+)
+
+// hostCertStatus is the latest renewal outcome reported by a host agent.
+type hostCertStatus struct {
+	HostName     string    `json:"host_name"`
+	Hostnames    []string  `json:"hostnames"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+	KeyCreatedAt time.Time `json:"key_created_at,omitempty"`
+	ReportedAt   time.Time `json:"reported_at"`
+	SourceAddr   string    `json:"source_address"`
+}
+
+var upsertHostCertStatusStmt = map[string]string{
+	"sqlite":   "insert or replace into host_cert_status(host_name, hostnames, status, error, expiration_epoch, key_created_epoch, reported_epoch, source_address) values(?, ?, ?, ?, ?, ?, ?, ?)",
+	"postgres": "insert into host_cert_status(host_name, hostnames, status, error, expiration_epoch, key_created_epoch, reported_epoch, source_address) values($1, $2, $3, $4, $5, $6, $7, $8) on conflict (host_name) do update set hostnames = excluded.hostnames, status = excluded.status, error = excluded.error, expiration_epoch = excluded.expiration_epoch, key_created_epoch = excluded.key_created_epoch, reported_epoch = excluded.reported_epoch, source_address = excluded.source_address",
+}
+
+var listHostCertStatusStmt = map[string]string{
+	"sqlite":   "select host_name, hostnames, status, error, expiration_epoch, key_created_epoch, reported_epoch, source_address from host_cert_status",
+	"postgres": "select host_name, hostnames, status, error, expiration_epoch, key_created_epoch, reported_epoch, source_address from host_cert_status",
+}
+
+func epochOrZero(epoch int64) time.Time {
+	if epoch <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(epoch, 0)
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func (state *RuntimeState) recordHostCertStatus(status hostCertStatus) error {
+	_, err := state.db.Exec(upsertHostCertStatusStmt[state.dbType],
+		status.HostName, strings.Join(status.Hostnames, ","), status.Status,
+		status.Error, unixOrZero(status.ExpiresAt),
+		unixOrZero(status.KeyCreatedAt), status.ReportedAt.Unix(),
+		status.SourceAddr)
+	return err
+}
+
+// getHostCertStatuses returns the statuses of the hosts, soonest expiry
+// first.
+func (state *RuntimeState) getHostCertStatuses() ([]hostCertStatus, error) {
+	rows, err := state.db.Query(listHostCertStatusStmt[state.dbType])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	statuses := make([]hostCertStatus, 0)
+	for rows.Next() {
+		var status hostCertStatus
+		var hostnames string
+		var expirationEpoch, keyCreatedEpoch, reportedEpoch int64
+		err := rows.Scan(&status.HostName, &hostnames, &status.Status,
+			&status.Error, &expirationEpoch, &keyCreatedEpoch, &reportedEpoch,
+			&status.SourceAddr)
+		if err != nil {
+			return nil, err
+		}
+		if hostnames != "" {
+			status.Hostnames = strings.Split(hostnames, ",")
+		}
+		status.ExpiresAt = epochOrZero(expirationEpoch)
+		status.KeyCreatedAt = epochOrZero(keyCreatedEpoch)
+		status.ReportedAt = time.Unix(reportedEpoch, 0)
+		statuses = append(statuses, status)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].ExpiresAt.Before(statuses[j].ExpiresAt)
+	})
+	return statuses, nil
+}
+
+// cleanupHostCertStatus forgets hosts which have not reported for a while.
+func cleanupHostCertStatus(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(
+		"DELETE from host_cert_status WHERE reported_epoch < %d",
+		time.Now().Add(-hostCertStatusRetention).Unix()))
+	return err
+}
+
+// parseHostCertStatusForm returns the status described by the form, or an
+// error for the client.
+func parseHostCertStatusForm(r *http.Request) (hostCertStatus, error) {
+	status := hostCertStatus{
+		Status:     r.Form.Get("status"),
+		Error:      r.Form.Get("error"),
+		ReportedAt: time.Now(),
+		SourceAddr: r.RemoteAddr,
+	}
+	if status.Status != "ok" && status.Status != "error" {
+		return status, fmt.Errorf("invalid status: %s", status.Status)
+	}
+	if len(status.Error) > maxHostCertStatusError {
+		status.Error = status.Error[:maxHostCertStatusError]
+	}
+	for name, field := range map[string]*time.Time{
+		"expires_at":     &status.ExpiresAt,
+		"key_created_at": &status.KeyCreatedAt,
+	} {
+		value := r.Form.Get(name)
+		if value == "" {
+			continue
+		}
+		epoch, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return status, fmt.Errorf("invalid %s: %s", name, value)
+		}
+		*field = epochOrZero(epoch)
+	}
+	return status, nil
+}
+
+// hostCertStatusHandler records the renewal status reported by a host
+// agent, which authenticates like it does for hostCertgenPath.
+func (state *RuntimeState) hostCertStatusHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if state.instanceVerifier == nil &&
+		len(state.Config.HostCertificates.BootstrapTokens) < 1 {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	identity, err := state.authenticateHost(r)
+	if err != nil {
+		logger.Printf("host authentication failed from %s: %s",
+			r.RemoteAddr, err)
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+		return
+	}
+	hostName := identity.Provider + "/" + identity.InstanceID
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(hostName)
+	status, err := parseHostCertStatusForm(r)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	status.HostName = hostName
+	status.Hostnames = identity.Hostnames
+	if err := state.recordHostCertStatus(status); err != nil {
+		logger.Printf("error recording host certificate status: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if status.Status != "ok" {
+		logger.Printf("host: %s failed to renew certificates: %s", hostName,
+			status.Error)
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "OK\n")
+}
+
+// hostCertificatesHandler returns the statuses of the hosts to admins. With
+// the expiring_within parameter, only hosts whose certificates expire within
+// that duration are returned.
+func (state *RuntimeState) hostCertificatesHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if failure, _ := state.sendFailureToClientIfNonAdmin(w, r); failure {
+		return
+	}
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	var deadline time.Time
+	if value := r.URL.Query().Get("expiring_within"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Invalid expiring_within")
+			return
+		}
+		deadline = time.Now().Add(duration)
+	}
+	statuses, err := state.getHostCertStatuses()
+	if err != nil {
+		logger.Printf("error getting host certificate statuses: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if !deadline.IsZero() {
+		filtered := make([]hostCertStatus, 0, len(statuses))
+		for _, status := range statuses {
+			if status.ExpiresAt.Before(deadline) {
+				filtered = append(filtered, status)
+			}
+		}
+		statuses = filtered
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostCertStatus(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	state.Config.HostCertificates.BootstrapTokens = []HostBootstrapTokenConfig{{
+		Token:            "0123456789abcdef",
+		allowedHostnames: regexp.MustCompile(`^(?:[a-z0-9-]+\.example\.com)$`),
+	}}
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	report := func(form url.Values, expectedStatus int) {
+		req, err := http.NewRequest("POST", hostCertStatusPath,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		_, err = checkRequestHandlerCode(req, state.hostCertStatusHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatalf("%v: %s", form, err)
+		}
+	}
+	form := url.Values{
+		"bootstrap_token": {"0123456789abcdef"},
+		"hostname":        {"web-1.example.com"},
+		"status":          {"ok"},
+		"expires_at":      {strconv.FormatInt(expiresAt.Unix(), 10)},
+	}
+	report(form, http.StatusOK)
+	form.Set("hostname", "web-2.example.com")
+	form.Set("status", "error")
+	form.Set("error", "signing failed")
+	form.Del("expires_at")
+	report(form, http.StatusOK)
+	form.Set("status", "fine")
+	report(form, http.StatusBadRequest)
+	form.Set("bootstrap_token", "wrong")
+	report(form, http.StatusUnauthorized)
+	list := func(query string) []hostCertStatus {
+		req, err := http.NewRequest("GET", hostCertificatesPath+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.TLS, err = testMakeConnectionState("testdata/alice.pem",
+			"testdata/KeymasterCA.pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := checkRequestHandlerCode(req, state.hostCertificatesHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var statuses []hostCertStatus
+		if err := json.NewDecoder(rr.Body).Decode(&statuses); err != nil {
+			t.Fatal(err)
+		}
+		return statuses
+	}
+	statuses := list("")
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 hosts, got: %+v", statuses)
+	}
+	// Hosts without a certificate sort first.
+	if statuses[0].HostName != "bootstrap/web-2.example.com" ||
+		statuses[0].Error != "signing failed" {
+		t.Errorf("unexpected status: %+v", statuses[0])
+	}
+	if statuses[1].Status != "ok" || !statuses[1].ExpiresAt.Equal(expiresAt) {
+		t.Errorf("unexpected status: %+v", statuses[1])
+	}
+	if statuses := list("?expiring_within=10m"); len(statuses) != 1 {
+		t.Errorf("expected 1 expiring host, got: %+v", statuses)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// configureHTTP2 enables or disables HTTP/2 on a TLS server, as configured.
+// Clients negotiate HTTP/2 with ALPN, so HTTP/1.1 clients such as the
+// eventmon CONNECT clients are unaffected.
+func (state *RuntimeState) configureHTTP2(server *http.Server) {
+	if server.TLSConfig == nil {
+		server.TLSConfig = &tls.Config{}
+	} else {
+		server.TLSConfig = server.TLSConfig.Clone()
+	}
+	if state.Config.Base.DisableHTTP2 {
+		server.TLSConfig.NextProtos = []string{"http/1.1"}
+		server.TLSNextProto = make(
+			map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return
+	}
+	server.TLSConfig.NextProtos = []string{"h2", "http/1.1"}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"github.com/tstranex/u2f"
+)
+
+// TestHTTP2Multiplexing checks that concurrent requests to the JSON
+// endpoints, including U2F registration which updates the user profile,
+// work when multiplexed on a single HTTP/2 connection.
+func TestHTTP2Multiplexing(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypeU2F}
+	dir, err := ioutil.TempDir("", "http2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.Config.Base.DataDirectory = dir
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(u2fRegustisterRequestPath, state.u2fRegisterRequest)
+	mux.HandleFunc(devicesAPIPath, state.devicesAPIHandler)
+	server := httptest.NewUnstartedServer(
+		instrumentedwriter.NewLoggingHandler(mux, httpLogger{}))
+	state.configureHTTP2(server.Config)
+	server.TLS = server.Config.TLSConfig
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	client := server.Client()
+	paths := []string{u2fRegustisterRequestPath + "username", devicesAPIPath}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			req, err := http.NewRequest("GET", server.URL+path, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			if resp.ProtoMajor != 2 {
+				t.Errorf("%s: expected HTTP/2, got %s", path, resp.Proto)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%s: unexpected status: %s", path, resp.Status)
+				return
+			}
+			var response interface{}
+			if path == devicesAPIPath {
+				response = &[]userDeviceInfo{}
+			} else {
+				response = &u2f.WebRegisterRequest{}
+			}
+			if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+				t.Errorf("%s: %s", path, err)
+			}
+		}(paths[i%len(paths)])
+	}
+	wg.Wait()
+}
@@ -736,10 +736,23 @@ func (state *RuntimeState) getGitDbUserAttributes(username string,
 
 func (state *RuntimeState) getLdapUserAttributes(username string,
 	attributes []string) (bool, map[string][]string, error) {
-	ldapConfig := state.Config.UserInfo.Ldap
-	if ldapConfig.LDAPTargetURLs == "" {
+	queries := state.getLdapUserInfoQueries(username)
+	if len(queries) < 1 {
 		return false, nil, nil
 	}
+	for _, query := range queries {
+		attributeMap, err := state.getLdapUserAttributesFromSource(query.source,
+			query.username, attributes)
+		if err == nil {
+			return true, attributeMap, nil
+		}
+	}
+	return true, nil, errors.New("error getting the groups")
+}
+
+func (state *RuntimeState) getLdapUserAttributesFromSource(
+	ldapConfig UserInfoLDAPSource, username string, attributes []string) (
+	map[string][]string, error) {
 	var timeoutSecs uint
 	timeoutSecs = 2
 	for _, ldapUrl := range strings.Split(ldapConfig.LDAPTargetURLs, ",") {
@@ -751,17 +764,27 @@ func (state *RuntimeState) getLdapUserAttributes(username string,
 			logger.Printf("Failed to parse ldapurl '%s'", ldapUrl)
 			continue
 		}
+		breaker := state.backendBreakers.Get(u.Host)
+		if !breaker.Allow() {
+			continue
+		}
 		attributeMap, err := authutil.GetLDAPUserAttributes(*u,
 			ldapConfig.BindUsername, ldapConfig.BindPassword,
-			timeoutSecs, nil, username,
+			timeoutSecs, ldapConfig.getTLSConfig(), username,
 			ldapConfig.UserSearchBaseDNs, ldapConfig.UserSearchFilter,
 			attributes)
+		if err == authutil.ErrLDAPUserNotFound {
+			breaker.Success()
+			continue
+		}
 		if err != nil {
+			breaker.Failure()
 			continue
 		}
+		breaker.Success()
 		userGroups, err := authutil.GetLDAPUserGroups(*u,
 			ldapConfig.BindUsername, ldapConfig.BindPassword,
-			timeoutSecs, nil, username,
+			timeoutSecs, ldapConfig.getTLSConfig(), username,
 			ldapConfig.UserSearchBaseDNs, ldapConfig.UserSearchFilter,
 			ldapConfig.GroupSearchBaseDNs, ldapConfig.GroupSearchFilter)
 		if err != nil {
@@ -773,9 +796,9 @@ func (state *RuntimeState) getLdapUserAttributes(username string,
 				username, userGroups)
 			attributeMap["groups"] = userGroups
 		}
-		return true, attributeMap, nil
+		return attributeMap, nil
 	}
-	return true, nil, errors.New("error getting the groups")
+	return nil, errors.New("error getting the groups")
 }
 
 func (state *RuntimeState) getUserAttributes(username string,
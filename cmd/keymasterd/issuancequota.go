@@ -0,0 +1,321 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	issuanceQuotasPath = "/admin/issuanceQuotas"
+
+	defaultIssuanceQuotaWindow = 24 * time.Hour
+)
+
+// issuanceQuotaConfig limits how many certificates each user may be issued
+// in a rolling window. The issued certificate records are counted, so the
+// window must not be longer than their retention.
+type issuanceQuotaConfig struct {
+	MaxCertificates uint          `yaml:"max_certificates"` // 0: unlimited.
+	Window          time.Duration `yaml:"window"`           // Default: 24h.
+}
+
+// issuanceQuotaOverride is set by an admin during an incident. A non-zero
+// MaxCertificates replaces the configured quota and issuances before
+// ResetAt are not counted, until the override expires.
+type issuanceQuotaOverride struct {
+	Username        string    `json:"username"`
+	MaxCertificates uint      `json:"max_certificates,omitempty"`
+	ResetAt         time.Time `json:"reset_at,omitempty"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	SetBy           string    `json:"set_by"`
+}
+
+// issuanceQuotaStatus is the admin view of the quota of a user.
+type issuanceQuotaStatus struct {
+	Username        string                 `json:"username"`
+	MaxCertificates uint                   `json:"max_certificates"`
+	WindowSeconds   int64                  `json:"window_seconds"`
+	Issued          uint                   `json:"issued"`
+	Override        *issuanceQuotaOverride `json:"override,omitempty"`
+}
+
+var getIssuanceCountStmt = map[string]string{
+	"sqlite":   "select count(*), coalesce(min(issued_epoch), 0) from issued_certificate where username = ? and issued_epoch > ?",
+	"postgres": "select count(*), coalesce(min(issued_epoch), 0) from issued_certificate where username = $1 and issued_epoch > $2",
+}
+
+var getIssuanceQuotaOverrideStmt = map[string]string{
+	"sqlite":   "select username, max_certificates, reset_epoch, expiration_epoch, set_by from issuance_quota_override where username = ? and expiration_epoch > ?",
+	"postgres": "select username, max_certificates, reset_epoch, expiration_epoch, set_by from issuance_quota_override where username = $1 and expiration_epoch > $2",
+}
+
+var listIssuanceQuotaOverridesStmt = map[string]string{
+	"sqlite":   "select username, max_certificates, reset_epoch, expiration_epoch, set_by from issuance_quota_override where expiration_epoch > ? order by username",
+	"postgres": "select username, max_certificates, reset_epoch, expiration_epoch, set_by from issuance_quota_override where expiration_epoch > $1 order by username",
+}
+
+var setIssuanceQuotaOverrideStmt = map[string]string{
+	"sqlite":   "insert or replace into issuance_quota_override(username, max_certificates, reset_epoch, expiration_epoch, set_by) values(?, ?, ?, ?, ?)",
+	"postgres": "insert into issuance_quota_override(username, max_certificates, reset_epoch, expiration_epoch, set_by) values($1, $2, $3, $4, $5) on CONFLICT(username) DO UPDATE set max_certificates = excluded.max_certificates, reset_epoch = excluded.reset_epoch, expiration_epoch = excluded.expiration_epoch, set_by = excluded.set_by",
+}
+
+var deleteIssuanceQuotaOverrideStmt = map[string]string{
+	"sqlite":   "delete from issuance_quota_override where username = ?",
+	"postgres": "delete from issuance_quota_override where username = $1",
+}
+
+func (config *issuanceQuotaConfig) getWindow() time.Duration {
+	if config.Window > 0 {
+		return config.Window
+	}
+	return defaultIssuanceQuotaWindow
+}
+
+func scanIssuanceQuotaOverride(scanner interface {
+	Scan(dest ...interface{}) error
+}) (*issuanceQuotaOverride, error) {
+	var override issuanceQuotaOverride
+	var maxCertificates, resetEpoch, expirationEpoch int64
+	err := scanner.Scan(&override.Username, &maxCertificates, &resetEpoch,
+		&expirationEpoch, &override.SetBy)
+	if err != nil {
+		return nil, err
+	}
+	override.MaxCertificates = uint(maxCertificates)
+	if resetEpoch > 0 {
+		override.ResetAt = time.Unix(resetEpoch, 0)
+	}
+	override.ExpiresAt = time.Unix(expirationEpoch, 0)
+	return &override, nil
+}
+
+// getIssuanceQuotaOverride returns nil if username has no unexpired
+// override.
+func (state *RuntimeState) getIssuanceQuotaOverride(username string) (
+	*issuanceQuotaOverride, error) {
+	override, err := scanIssuanceQuotaOverride(state.db.QueryRow(
+		getIssuanceQuotaOverrideStmt[state.dbType], username,
+		time.Now().Unix()))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return override, err
+}
+
+func (state *RuntimeState) listIssuanceQuotaOverrides() (
+	[]issuanceQuotaOverride, error) {
+	rows, err := state.db.Query(listIssuanceQuotaOverridesStmt[state.dbType],
+		time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	overrides := make([]issuanceQuotaOverride, 0)
+	for rows.Next() {
+		override, err := scanIssuanceQuotaOverride(rows)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, *override)
+	}
+	return overrides, rows.Err()
+}
+
+func (state *RuntimeState) setIssuanceQuotaOverride(
+	override issuanceQuotaOverride) error {
+	var resetEpoch int64
+	if !override.ResetAt.IsZero() {
+		resetEpoch = override.ResetAt.Unix()
+	}
+	_, err := state.db.Exec(setIssuanceQuotaOverrideStmt[state.dbType],
+		override.Username, int64(override.MaxCertificates), resetEpoch,
+		override.ExpiresAt.Unix(), override.SetBy)
+	return err
+}
+
+// cleanupIssuanceQuotaOverrides forgets overrides which have expired.
+func cleanupIssuanceQuotaOverrides(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(
+		"DELETE from issuance_quota_override WHERE expiration_epoch < %d",
+		time.Now().Unix()))
+	return err
+}
+
+// getIssuanceQuotaStatus returns the quota of username, and when the oldest
+// counted issuance leaves the window.
+func (state *RuntimeState) getIssuanceQuotaStatus(username string) (
+	*issuanceQuotaStatus, time.Time, error) {
+	config := state.Config.IssuanceQuota
+	window := config.getWindow()
+	status := &issuanceQuotaStatus{
+		Username:        username,
+		MaxCertificates: config.MaxCertificates,
+		WindowSeconds:   int64(window.Seconds()),
+	}
+	override, err := state.getIssuanceQuotaOverride(username)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	since := time.Now().Add(-window)
+	if override != nil {
+		status.Override = override
+		if override.MaxCertificates > 0 {
+			status.MaxCertificates = override.MaxCertificates
+		}
+		if override.ResetAt.After(since) {
+			since = override.ResetAt
+		}
+	}
+	var count, oldestEpoch int64
+	err = state.db.QueryRow(getIssuanceCountStmt[state.dbType], username,
+		since.Unix()).Scan(&count, &oldestEpoch)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	status.Issued = uint(count)
+	return status, time.Unix(oldestEpoch, 0).Add(window), nil
+}
+
+// checkIssuanceQuota returns true if username may be issued another
+// certificate. Otherwise a 429 response is written. Quotas protect against
+// runaway clients, so they are not enforced if the database fails.
+func (state *RuntimeState) checkIssuanceQuota(w http.ResponseWriter,
+	r *http.Request, username string) bool {
+	if state.Config.IssuanceQuota.MaxCertificates < 1 {
+		return true
+	}
+	status, retryAt, err := state.getIssuanceQuotaStatus(username)
+	if err != nil {
+		logger.Printf("cannot check issuance quota of %s: %s", username, err)
+		return true
+	}
+	if status.Issued < status.MaxCertificates {
+		return true
+	}
+	requestLimitedCounter.WithLabelValues("certgen", "quota").Inc()
+	logger.Printf("issuance quota of %s exceeded: %d certificates in %s",
+		username, status.Issued, state.Config.IssuanceQuota.getWindow())
+	retryAfter := int64(time.Until(retryAt).Seconds()) + 1
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	state.writeFailureResponse(w, r, http.StatusTooManyRequests,
+		"Certificate issuance quota exceeded, retry later")
+	return false
+}
+
+func parseIssuanceQuotaOverrideForm(r *http.Request,
+	window time.Duration) (*issuanceQuotaOverride, error) {
+	override := &issuanceQuotaOverride{Username: r.Form.Get("username")}
+	if override.Username == "" {
+		return nil, errors.New("missing username")
+	}
+	if value := r.Form.Get("max_certificates"); value != "" {
+		maxCertificates, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_certificates: %s", value)
+		}
+		override.MaxCertificates = uint(maxCertificates)
+	}
+	now := time.Now()
+	if r.Form.Get("reset") == "true" {
+		override.ResetAt = now
+	}
+	if override.MaxCertificates < 1 && override.ResetAt.IsZero() {
+		return nil, errors.New("need max_certificates or reset=true")
+	}
+	lifetime := window
+	if value := r.Form.Get("duration"); value != "" {
+		var err error
+		lifetime, err = time.ParseDuration(value)
+		if err != nil || lifetime <= 0 {
+			return nil, fmt.Errorf("invalid duration: %s", value)
+		}
+	}
+	override.ExpiresAt = now.Add(lifetime)
+	return override, nil
+}
+
+// issuanceQuotasHandler shows the quota of the user given by the username
+// form value, or lists the overrides (GET), sets an override (POST) or
+// removes one (DELETE).
+func (state *RuntimeState) issuanceQuotasHandler(w http.ResponseWriter,
+	r *http.Request) {
+	failure, authUser := state.sendFailureToClientIfNonAdmin(w, r)
+	if failure {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		state.logger.Printf("error parsing err=%s", err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "")
+		return
+	}
+	var response interface{}
+	switch r.Method {
+	case "GET":
+		var err error
+		if username := r.Form.Get("username"); username != "" {
+			response, _, err = state.getIssuanceQuotaStatus(username)
+		} else {
+			response, err = state.listIssuanceQuotaOverrides()
+		}
+		if err != nil {
+			state.logger.Printf("error getting issuance quotas: %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
+			return
+		}
+	case "POST":
+		override, err := parseIssuanceQuotaOverrideForm(r,
+			state.Config.IssuanceQuota.getWindow())
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				err.Error())
+			return
+		}
+		override.SetBy = authUser
+		if err := state.setIssuanceQuotaOverride(*override); err != nil {
+			state.logger.Printf("error setting issuance quota: %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
+			return
+		}
+		state.logger.Printf(
+			"%s: set issuance quota of: %s to: %d, reset: %v, until: %s\n",
+			authUser, override.Username, override.MaxCertificates,
+			!override.ResetAt.IsZero(), override.ExpiresAt)
+		response = override
+	case "DELETE":
+		username := r.Form.Get("username")
+		if username == "" {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"missing username")
+			return
+		}
+		_, err := state.db.Exec(deleteIssuanceQuotaOverrideStmt[state.dbType],
+			username)
+		if err != nil {
+			state.logger.Printf("error removing issuance quota: %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
+			return
+		}
+		state.logger.Printf("%s: removed issuance quota override of: %s\n",
+			authUser, username)
+		writeAdminActionResponse(w, r, username)
+		return
+	default:
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		state.logger.Printf("json encoding error: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCheckIssuanceQuota(t *testing.T) {
+	state, tmpdir, err := newTestingState(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	checkQuota := func(username string, expected int) {
+		req := httptest.NewRequest("POST", "/certgen/"+username, nil)
+		w := httptest.NewRecorder()
+		ok := state.checkIssuanceQuota(w, req, username)
+		if expected == http.StatusOK {
+			if !ok {
+				t.Fatalf("%s: rejected: %d", username, w.Code)
+			}
+			return
+		}
+		if ok {
+			t.Fatalf("%s: not rejected", username)
+		}
+		if w.Code != expected {
+			t.Fatalf("%s: expected: %d, got: %d", username, expected, w.Code)
+		}
+	}
+	now := time.Now()
+	for _, issuedAt := range []time.Time{
+		now.Add(-25 * time.Hour), // Outside of the window.
+		now.Add(-2 * time.Hour),
+		now.Add(-time.Hour),
+	} {
+		err := state.insertChainedIssuedCert(issuedCertRecord{
+			Username:  "alice",
+			CertType:  "ssh",
+			Serial:    strconv.FormatInt(issuedAt.Unix(), 10),
+			IssuedAt:  issuedAt,
+			ExpiresAt: issuedAt.Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Disabled by default.
+	checkQuota("alice", http.StatusOK)
+	state.Config.IssuanceQuota.MaxCertificates = 3
+	checkQuota("alice", http.StatusOK)
+	state.Config.IssuanceQuota.MaxCertificates = 2
+	req := httptest.NewRequest("POST", "/certgen/alice", nil)
+	w := httptest.NewRecorder()
+	if state.checkIssuanceQuota(w, req, "alice") {
+		t.Fatal("over quota not rejected")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected: %d, got: %d", http.StatusTooManyRequests, w.Code)
+	}
+	// The oldest counted issuance leaves the window in 22 hours.
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retryAfter < 21*3600 || retryAfter > 22*3600+1 {
+		t.Fatalf("unexpected Retry-After: %d", retryAfter)
+	}
+	checkQuota("bob", http.StatusOK)
+	// Raise the quota.
+	err = state.setIssuanceQuotaOverride(issuanceQuotaOverride{
+		Username:        "alice",
+		MaxCertificates: 10,
+		ExpiresAt:       now.Add(time.Hour),
+		SetBy:           "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkQuota("alice", http.StatusOK)
+	// Clear the counts instead.
+	err = state.setIssuanceQuotaOverride(issuanceQuotaOverride{
+		Username:  "alice",
+		ResetAt:   now,
+		ExpiresAt: now.Add(time.Hour),
+		SetBy:     "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _, err := state.getIssuanceQuotaStatus("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Issued != 0 || status.MaxCertificates != 2 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	overrides, err := state.listIssuanceQuotaOverrides()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 1 || overrides[0].Username != "alice" {
+		t.Fatalf("unexpected overrides: %+v", overrides)
+	}
+	// Expired overrides are ignored.
+	err = state.setIssuanceQuotaOverride(issuanceQuotaOverride{
+		Username:  "alice",
+		ResetAt:   now,
+		ExpiresAt: now.Add(-time.Second),
+		SetBy:     "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkQuota("alice", http.StatusTooManyRequests)
+	if err := cleanupIssuanceQuotaOverrides(state.db); err != nil {
+		t.Fatal(err)
+	}
+	overrides, err = state.listIssuanceQuotaOverrides()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 0 {
+		t.Fatalf("unexpected overrides: %+v", overrides)
+	}
+}
+
+func TestParseIssuanceQuotaOverrideForm(t *testing.T) {
+	window := 24 * time.Hour
+	for _, form := range []url.Values{
+		{"max_certificates": {"5"}},
+		{"username": {"alice"}},
+		{"username": {"alice"}, "max_certificates": {"-1"}},
+		{"username": {"alice"}, "reset": {"true"}, "duration": {"-1h"}},
+	} {
+		req := &http.Request{Form: form}
+		if _, err := parseIssuanceQuotaOverrideForm(req, window); err == nil {
+			t.Fatalf("form: %v not rejected", form)
+		}
+	}
+	req := &http.Request{Form: url.Values{
+		"username":         {"alice"},
+		"max_certificates": {"50"},
+		"duration":         {"2h"},
+	}}
+	override, err := parseIssuanceQuotaOverrideForm(req, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if override.MaxCertificates != 50 || !override.ResetAt.IsZero() {
+		t.Fatalf("unexpected override: %+v", override)
+	}
+	if lifetime := time.Until(override.ExpiresAt); lifetime > 2*time.Hour ||
+		lifetime < time.Hour {
+		t.Fatalf("unexpected expiration: %s", override.ExpiresAt)
+	}
+	req = &http.Request{Form: url.Values{
+		"username": {"alice"},
+		"reset":    {"true"},
+	}}
+	override, err = parseIssuanceQuotaOverrideForm(req, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if override.ResetAt.IsZero() ||
+		time.Until(override.ExpiresAt) < window-time.Minute {
+		t.Fatalf("unexpected override: %+v", override)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	issuedCertsPath = "/api/v0/issuedCertificates"
+
+	issuedCertRetention      = 30 * 24 * time.Hour // Default.
+	maxIssuedCertsPerRequest = 100
+	numIssuedCertsInProfile  = 10
+)
+
+// issuedCertConfig configures how long issued certificate records are kept
+// after the certificates expire. Default: 30 days.
+type issuedCertConfig struct {
+	Retention time.Duration `yaml:"retention"`
+}
+
+// issuedCertFilter selects the records returned by an admin search. Empty
+// fields match all records.
+type issuedCertFilter struct {
+	Username     string
+	CertType     string
+	Serial       string
+	Fingerprint  string
+	IssuedAfter  time.Time
+	IssuedBefore time.Time
+}
+
+type issuedCertRecord struct {
+	Username    string    `json:"username"`
+	CertType    string    `json:"cert_type"`
+	Serial      string    `json:"serial"`
+	KeyID       string    `json:"key_id,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+	IssuedAt    time.Time `json:"issued_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	SourceAddr  string    `json:"source_address"`
+	Revoked     bool      `json:"revoked,omitempty"`
+	// SSH certificate in authorized_keys format, or X.509 certificate PEM.
+	Certificate string `json:"certificate,omitempty"`
+	issuanceContext
+}
+
+// issuanceContext describes the request a certificate was issued for. The
+// AuditID is embedded in the certificate, so that a certificate observed on
+// a host can be traced back to its issuedCertRecord.
+type issuanceContext struct {
+	AuditID       string `json:"audit_id,omitempty"`
+	AuthMethod    string `json:"auth_method,omitempty"`
+	UserAgent     string `json:"user_agent,omitempty"`
+	ClientVersion string `json:"client_version,omitempty"`
+	preview       bool   // Describe the certificate instead of issuing it.
+	publicKey     []byte // From a JSON request, instead of an uploaded file.
+	keyProfile    string // Generate and escrow the key, see keyescrow.go.
+	escrowedKey   *escrowedKey
+	// For the X.509 issuance context extension.
+	authStrength int
+	authTime     time.Time
+	deviceID     string
+}
+
+var insertIssuedCertStmt = map[string]string{
+	"sqlite":   "insert into issued_certificate(username, cert_type, serial, key_id, fingerprint, issued_epoch, expiration_epoch, source_address, audit_id, auth_method, user_agent, client_version, certificate, chain_hash) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	"postgres": "insert into issued_certificate(username, cert_type, serial, key_id, fingerprint, issued_epoch, expiration_epoch, source_address, audit_id, auth_method, user_agent, client_version, certificate, chain_hash) values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)",
+}
+
+var getIssuedCertsForUserStmt = map[string]string{
+	"sqlite":   "select username, cert_type, serial, key_id, fingerprint, issued_epoch, expiration_epoch, source_address, audit_id, auth_method, user_agent, client_version, certificate from issued_certificate where username = ? order by issued_epoch desc limit ?",
+	"postgres": "select username, cert_type, serial, key_id, fingerprint, issued_epoch, expiration_epoch, source_address, audit_id, auth_method, user_agent, client_version, certificate from issued_certificate where username = $1 order by issued_epoch desc limit $2",
+}
+
+var getIssuedCertsForAuditIDStmt = map[string]string{
+	"sqlite":   "select username, cert_type, serial, key_id, fingerprint, issued_epoch, expiration_epoch, source_address, audit_id, auth_method, user_agent, client_version, certificate from issued_certificate where audit_id = ? order by issued_epoch desc limit ?",
+	"postgres": "select username, cert_type, serial, key_id, fingerprint, issued_epoch, expiration_epoch, source_address, audit_id, auth_method, user_agent, client_version, certificate from issued_certificate where audit_id = $1 order by issued_epoch desc limit $2",
+}
+
+const searchIssuedCertsStmt = "select username, cert_type, serial, key_id, fingerprint, issued_epoch, expiration_epoch, source_address, audit_id, auth_method, user_agent, client_version, certificate from issued_certificate"
+
+// authMethodNames lists the names of the authentication methods in the
+// order they are reported in.
+var authMethodNames = []struct {
+	authType int
+	name     string
+}{
+	{AuthTypePassword, proto.AuthTypePassword},
+	{AuthTypeFederated, proto.AuthTypeFederated},
+	{AuthTypeU2F, proto.AuthTypeU2F},
+	{AuthTypeSymantecVIP, proto.AuthTypeSymantecVIP},
+	{AuthTypeIPCertificate, proto.AuthTypeIPCertificate},
+	{AuthTypeTOTP, proto.AuthTypeTOTP},
+	{AuthTypeOkta2FA, proto.AuthTypeOkta2FA},
+	{AuthTypeBootstrapOTP, proto.AuthTypeBootstrapOTP},
+	{AuthTypeKeymasterX509, "KeymasterX509"},
+	{AuthTypeAutomationToken, "AutomationToken"},
+	{AuthTypeTrustedDevice, "TrustedDevice"},
+}
+
+// getAuthMethod returns a human readable form of authType, such as
+// "password+U2F".
+func getAuthMethod(authType int) string {
+	var names []string
+	for _, method := range authMethodNames {
+		if authType&method.authType == method.authType {
+			names = append(names, method.name)
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// getClientVersion extracts the version from the User-Agent sent by the
+// keymaster client, which looks like "keymaster/1.2.3 (linux amd64)".
+func getClientVersion(userAgent string) string {
+	product := strings.SplitN(userAgent, " ", 2)[0]
+	if !strings.HasPrefix(product, "keymaster/") {
+		return ""
+	}
+	return strings.TrimPrefix(product, "keymaster/")
+}
+
+// newIssuanceContext returns the context for a certificate issued for r,
+// with a fresh audit ID. The authentication is taken to be a single factor,
+// now.
+func newIssuanceContext(r *http.Request, authMethod string) (
+	issuanceContext, error) {
+	auditID, err := certgen.NewAuditID()
+	if err != nil {
+		return issuanceContext{}, err
+	}
+	return issuanceContext{
+		AuditID:       auditID,
+		AuthMethod:    authMethod,
+		UserAgent:     r.UserAgent(),
+		ClientVersion: getClientVersion(r.UserAgent()),
+		authStrength:  certgen.AuthStrengthSingleFactor,
+		authTime:      time.Now(),
+		deviceID:      r.Header.Get(deviceIDHeader),
+	}, nil
+}
+
+// publicKeyFingerprint returns the fingerprint in the same format as
+// ssh-keygen -l, so that users can match it against their keys.
+func publicKeyFingerprint(pub crypto.PublicKey) string {
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(sshPub)
+}
+
+func encodeCertificatePEM(derCert []byte) string {
+	return string(pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: derCert}))
+}
+
+func newSSHIssuedCertRecord(username string, cert *ssh.Certificate,
+	r *http.Request, issuance issuanceContext) issuedCertRecord {
+	return issuedCertRecord{
+		Username:    username,
+		CertType:    "ssh",
+		Serial:      strconv.FormatUint(cert.Serial, 10),
+		KeyID:       cert.KeyId,
+		Fingerprint: ssh.FingerprintSHA256(cert.Key),
+		IssuedAt:    time.Now(),
+		ExpiresAt:   time.Unix(int64(cert.ValidBefore), 0),
+		SourceAddr:  r.RemoteAddr,
+		Certificate: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+
+		issuanceContext: issuance,
+	}
+}
+
+func newX509IssuedCertRecord(username string, certType string,
+	cert *x509.Certificate, r *http.Request,
+	issuance issuanceContext) issuedCertRecord {
+	return issuedCertRecord{
+		Username:    username,
+		CertType:    certType,
+		Serial:      cert.SerialNumber.String(),
+		Fingerprint: publicKeyFingerprint(cert.PublicKey),
+		IssuedAt:    time.Now(),
+		ExpiresAt:   cert.NotAfter,
+		SourceAddr:  r.RemoteAddr,
+		Certificate: encodeCertificatePEM(cert.Raw),
+
+		issuanceContext: issuance,
+	}
+}
+
+// recordIssuedCertificate saves the record in the profile DB. Failures are
+// logged but do not affect issuance, so this may be run in the background.
+func (state *RuntimeState) recordIssuedCertificate(record issuedCertRecord) {
+	start := time.Now()
+	if err := state.insertChainedIssuedCert(record); err != nil {
+		logger.Printf("error recording issued certificate for %s: %s",
+			record.Username, err)
+		return
+	}
+	metricLogExternalServiceDuration("storage-save", time.Since(start))
+}
+
+func (state *RuntimeState) getIssuedCertificates(username string,
+	limit int) ([]issuedCertRecord, error) {
+	return state.queryIssuedCertificates(
+		getIssuedCertsForUserStmt[state.dbType], username, limit)
+}
+
+// getIssuedCertificatesForAuditID normally returns a single record, but audit
+// IDs are short enough that a collision is not impossible.
+func (state *RuntimeState) getIssuedCertificatesForAuditID(auditID string,
+	limit int) ([]issuedCertRecord, error) {
+	return state.queryIssuedCertificates(
+		getIssuedCertsForAuditIDStmt[state.dbType], auditID, limit)
+}
+
+func (config issuedCertConfig) getRetention() time.Duration {
+	if config.Retention > 0 {
+		return config.Retention
+	}
+	return issuedCertRetention
+}
+
+// getIssuedCertFilter returns the search filter given by the query
+// parameters, and whether any search parameters were given. Times are in
+// RFC 3339 format.
+func getIssuedCertFilter(query url.Values) (issuedCertFilter, bool, error) {
+	filter := issuedCertFilter{
+		Username:    query.Get("username"),
+		CertType:    query.Get("cert_type"),
+		Serial:      query.Get("serial"),
+		Fingerprint: query.Get("fingerprint"),
+	}
+	for param, value := range map[string]*time.Time{
+		"issued_after":  &filter.IssuedAfter,
+		"issued_before": &filter.IssuedBefore,
+	} {
+		if query.Get(param) == "" {
+			continue
+		}
+		var err error
+		*value, err = time.Parse(time.RFC3339, query.Get(param))
+		if err != nil {
+			return filter, false, fmt.Errorf("invalid %s: %s", param, err)
+		}
+	}
+	search := filter.CertType != "" || filter.Serial != "" ||
+		filter.Fingerprint != "" || !filter.IssuedAfter.IsZero() ||
+		!filter.IssuedBefore.IsZero()
+	return filter, search, nil
+}
+
+// searchIssuedCertificates returns the most recent records matching filter.
+func (state *RuntimeState) searchIssuedCertificates(filter issuedCertFilter,
+	limit int) ([]issuedCertRecord, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		placeholder := "?"
+		if state.dbType == "postgres" {
+			placeholder = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, condition+" "+placeholder)
+	}
+	for column, value := range map[string]string{
+		"username":    filter.Username,
+		"cert_type":   filter.CertType,
+		"serial":      filter.Serial,
+		"fingerprint": filter.Fingerprint,
+	} {
+		if value != "" {
+			addCondition(column+" =", value)
+		}
+	}
+	if !filter.IssuedAfter.IsZero() {
+		addCondition("issued_epoch >=", filter.IssuedAfter.Unix())
+	}
+	if !filter.IssuedBefore.IsZero() {
+		addCondition("issued_epoch <", filter.IssuedBefore.Unix())
+	}
+	stmt := searchIssuedCertsStmt
+	if len(conditions) > 0 {
+		stmt += " where " + strings.Join(conditions, " and ")
+	}
+	args = append(args, limit)
+	if state.dbType == "postgres" {
+		stmt += fmt.Sprintf(" order by issued_epoch desc limit $%d", len(args))
+	} else {
+		stmt += " order by issued_epoch desc limit ?"
+	}
+	return state.queryIssuedCertificates(stmt, args...)
+}
+
+func (state *RuntimeState) queryIssuedCertificates(stmt string,
+	args ...interface{}) ([]issuedCertRecord, error) {
+	rows, err := state.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []issuedCertRecord
+	for rows.Next() {
+		var record issuedCertRecord
+		var issuedEpoch, expirationEpoch int64
+		err := rows.Scan(&record.Username, &record.CertType, &record.Serial,
+			&record.KeyID, &record.Fingerprint, &issuedEpoch,
+			&expirationEpoch, &record.SourceAddr, &record.AuditID,
+			&record.AuthMethod, &record.UserAgent, &record.ClientVersion,
+			&record.Certificate)
+		if err != nil {
+			return nil, err
+		}
+		record.IssuedAt = time.Unix(issuedEpoch, 0)
+		record.ExpiresAt = time.Unix(expirationEpoch, 0)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// cleanupIssuedCertificates removes the oldest records up to the first one
+// which expired less than retention ago, always keeping the newest, so that
+// the remaining records form an unbroken audit chain.
+func cleanupIssuedCertificates(db *sql.DB, retention time.Duration) error {
+	_, err := db.Exec(fmt.Sprintf(
+		"DELETE from issued_certificate WHERE id < (SELECT coalesce((SELECT min(id) from issued_certificate WHERE expiration_epoch >= %d), (SELECT max(id) from issued_certificate)))",
+		time.Now().Add(-retention).Unix()))
+	return err
+}
+
+// issuedCertsHandler returns the recent certificates of the authenticated
+// user. Admins may request those of another user with the username
+// parameter, look up the issuance of a certificate with the audit_id
+// parameter, or search by the cert_type, serial, fingerprint, issued_after
+// and issued_before parameters.
+func (state *RuntimeState) issuedCertsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authData, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authData.Username)
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if auditID := r.URL.Query().Get("audit_id"); auditID != "" {
+		if !state.IsAdminUserAndU2F(authData.Username, authData.AuthType) {
+			state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+			return
+		}
+		records, err := state.getIssuedCertificatesForAuditID(auditID,
+			maxIssuedCertsPerRequest)
+		if err != nil {
+			logger.Printf("error getting issued certificates: %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(records); err != nil {
+			logger.Printf("json encoding error: %v", err)
+		}
+		return
+	}
+	filter, search, err := getIssuedCertFilter(r.URL.Query())
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if search {
+		if !state.IsAdminUserAndU2F(authData.Username, authData.AuthType) {
+			state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+			return
+		}
+		records, err := state.searchIssuedCertificates(filter,
+			maxIssuedCertsPerRequest)
+		if err != nil {
+			logger.Printf("error searching issued certificates: %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(records); err != nil {
+			logger.Printf("json encoding error: %v", err)
+		}
+		return
+	}
+	username := authData.Username
+	if assumedUser := r.URL.Query().Get("username"); assumedUser != "" &&
+		assumedUser != username {
+		if !state.IsAdminUserAndU2F(authData.Username, authData.AuthType) {
+			state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+			return
+		}
+		username = assumedUser
+	}
+	records, err := state.getIssuedCertificates(username,
+		maxIssuedCertsPerRequest)
+	if err != nil {
+		logger.Printf("error getting issued certificates: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/admincache"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+)
+
+func TestIssuedCertsHandlerAdminNeedsU2F(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	state.isAdminCache = admincache.New(5 * time.Minute)
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypePassword, proto.AuthTypeU2F}
+	checkQuery := func(username string, authType int, query string,
+		expectedStatus int) {
+		cookieVal, err := state.setNewAuthCookie(nil, username, authType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", issuedCertsPath+query, nil)
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		_, err = checkRequestHandlerCode(req, state.issuedCertsHandler,
+			expectedStatus)
+		if err != nil {
+			t.Errorf("%s %s: %s", username, query, err)
+		}
+	}
+	passwordAuth := AuthTypePassword | AuthTypeTOTP
+	u2fAuth := AuthTypePassword | AuthTypeU2F
+	for _, query := range []string{
+		"?username=carol",
+		"?audit_id=1",
+		"?serial=1",
+	} {
+		checkQuery("alice", u2fAuth, query, http.StatusOK)
+		checkQuery("alice", passwordAuth, query, http.StatusUnauthorized)
+		checkQuery("bob", u2fAuth, query, http.StatusUnauthorized)
+	}
+	checkQuery("alice", passwordAuth, "", http.StatusOK)
+	checkQuery("bob", passwordAuth, "", http.StatusOK)
+}
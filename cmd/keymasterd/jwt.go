@@ -52,14 +52,30 @@ func (state *RuntimeState) genNewSerializedAuthJWT(username string, authLevel in
 	if err != nil {
 		return "", err
 	}
+	sessionID, err := genRandomString()
+	if err != nil {
+		return "", err
+	}
 	issuer := state.idpGetIssuer()
 	authToken := authInfoJWT{Issuer: issuer, Subject: username,
-		Audience: []string{issuer}, AuthType: authLevel, TokenType: "keymaster_auth"}
+		Audience: []string{issuer}, AuthType: authLevel, TokenType: "keymaster_auth",
+		ID: sessionID}
 	authToken.NotBefore = time.Now().Unix()
 	authToken.IssuedAt = authToken.NotBefore
 	authToken.Expiration = authToken.IssuedAt + maxAgeSecondsAuthCookie // TODO seek the actual duration
 
-	return jwt.Signed(signer).Claims(authToken).CompactSerialize()
+	serializedToken, err := jwt.Signed(signer).Claims(authToken).CompactSerialize()
+	if err != nil {
+		return "", err
+	}
+	state.sessions.add(sessionInfo{
+		ID:        sessionID,
+		Username:  username,
+		AuthType:  authLevel,
+		IssuedAt:  time.Unix(authToken.IssuedAt, 0),
+		ExpiresAt: time.Unix(authToken.Expiration, 0),
+	})
+	return serializedToken, nil
 }
 
 func (state *RuntimeState) getAuthInfoFromAuthJWT(serializedToken string) (rvalue authInfo, err error) {
@@ -80,10 +96,15 @@ func (state *RuntimeState) getAuthInfoFromAuthJWT(serializedToken string) (rvalu
 		err = errors.New("invalid JWT values")
 		return rvalue, err
 	}
+	if state.sessions.isSessionRevoked(inboundJWT.ID, inboundJWT.Subject,
+		time.Unix(inboundJWT.IssuedAt, 0)) {
+		return rvalue, errors.New("session has been revoked")
+	}
 	rvalue.AuthType = inboundJWT.AuthType
 	rvalue.ExpiresAt = time.Unix(inboundJWT.Expiration, 0)
 	rvalue.IssuedAt = time.Unix(inboundJWT.IssuedAt, 0)
 	rvalue.Username = inboundJWT.Subject
+	rvalue.SessionID = inboundJWT.ID
 	return rvalue, nil
 }
 
@@ -110,7 +131,12 @@ func (state *RuntimeState) updateAuthJWTWithNewAuthLevel(intoken string, newAuth
 		err = errors.New("invalid JWT values")
 		return "", err
 	}
+	if state.sessions.isSessionRevoked(parsedJWT.ID, parsedJWT.Subject,
+		time.Unix(parsedJWT.IssuedAt, 0)) {
+		return "", errors.New("session has been revoked")
+	}
 	parsedJWT.AuthType = newAuthLevel
+	state.sessions.updateAuthType(parsedJWT.ID, newAuthLevel)
 	return jwt.Signed(signer).Claims(parsedJWT).CompactSerialize()
 }
 
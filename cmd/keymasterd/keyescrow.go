@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	escrowedKeysPath        = "/admin/escrowedKeys"
+	defaultEscrowKeyType    = "rsa"
+	maxEscrowedKeysPerQuery = 100
+)
+
+var validKeyProfileRE = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// keyEscrowConfig enables X.509 certificates for keys which keymasterd
+// generates, for uses such as e-mail encryption where the private key must
+// be recoverable. Each generated key is stored encrypted to the offline
+// recovery keys before it is returned to the user. SSH certificates are never
+// issued for escrowed keys.
+type keyEscrowConfig struct {
+	// Armored PGP public keys of the holders of the recovery keys.
+	RecoveryKeyFilenames []string                 `yaml:"recovery_key_filenames"`
+	Profiles             []keyEscrowProfileConfig `yaml:"profiles"`
+}
+
+// keyEscrowProfileConfig is a kind of escrowed key, requested with the
+// key_profile parameter.
+type keyEscrowProfileConfig struct {
+	Name       string `yaml:"name"`
+	KeyType    string `yaml:"key_type"`     // Default: rsa.
+	RSAKeyBits int    `yaml:"rsa_key_bits"` // Default: 3072.
+}
+
+// escrowedKey is the key generated for a certificate request.
+type escrowedKey struct {
+	profile      string
+	publicKey    interface{}
+	privateKey   *pem.Block
+	encryptedKey []byte // The armored PGP message which is escrowed.
+}
+
+// escrowedKeyRecord is given to admins, to be decrypted offline by a holder
+// of a recovery key.
+type escrowedKeyRecord struct {
+	Username     string    `json:"username"`
+	Profile      string    `json:"profile"`
+	AuditID      string    `json:"audit_id"`
+	Serial       string    `json:"serial"`
+	Fingerprint  string    `json:"fingerprint"`
+	EscrowedAt   time.Time `json:"escrowed_at"`
+	ExpiresAt    time.Time `json:"expires_at"` // Of the certificate.
+	EncryptedKey string    `json:"encrypted_key"`
+}
+
+var insertEscrowedKeyStmt = map[string]string{
+	"sqlite":   "insert into escrowed_key(username, profile, audit_id, serial, fingerprint, escrowed_epoch, expiration_epoch, encrypted_key) values(?, ?, ?, ?, ?, ?, ?, ?)",
+	"postgres": "insert into escrowed_key(username, profile, audit_id, serial, fingerprint, escrowed_epoch, expiration_epoch, encrypted_key) values($1, $2, $3, $4, $5, $6, $7, $8)",
+}
+
+var getEscrowedKeysStmt = map[string]map[string]string{
+	"sqlite": {
+		"username": "select username, profile, audit_id, serial, fingerprint, escrowed_epoch, expiration_epoch, encrypted_key from escrowed_key where username = ? order by escrowed_epoch desc limit ?",
+		"serial":   "select username, profile, audit_id, serial, fingerprint, escrowed_epoch, expiration_epoch, encrypted_key from escrowed_key where serial = ? order by escrowed_epoch desc limit ?",
+		"audit_id": "select username, profile, audit_id, serial, fingerprint, escrowed_epoch, expiration_epoch, encrypted_key from escrowed_key where audit_id = ? order by escrowed_epoch desc limit ?",
+	},
+	"postgres": {
+		"username": "select username, profile, audit_id, serial, fingerprint, escrowed_epoch, expiration_epoch, encrypted_key from escrowed_key where username = $1 order by escrowed_epoch desc limit $2",
+		"serial":   "select username, profile, audit_id, serial, fingerprint, escrowed_epoch, expiration_epoch, encrypted_key from escrowed_key where serial = $1 order by escrowed_epoch desc limit $2",
+		"audit_id": "select username, profile, audit_id, serial, fingerprint, escrowed_epoch, expiration_epoch, encrypted_key from escrowed_key where audit_id = $1 order by escrowed_epoch desc limit $2",
+	},
+}
+
+func (config *keyEscrowConfig) check() error {
+	if len(config.Profiles) < 1 {
+		return nil
+	}
+	if len(config.RecoveryKeyFilenames) < 1 {
+		return errors.New("x509_key_escrow: no recovery_key_filenames")
+	}
+	names := make(map[string]struct{}, len(config.Profiles))
+	for _, profile := range config.Profiles {
+		if !validKeyProfileRE.MatchString(profile.Name) {
+			return fmt.Errorf("x509_key_escrow: invalid profile name: %q",
+				profile.Name)
+		}
+		if _, ok := names[profile.Name]; ok {
+			return fmt.Errorf("x509_key_escrow: duplicate profile: %s",
+				profile.Name)
+		}
+		names[profile.Name] = struct{}{}
+		switch profile.getKeyType() {
+		case "rsa":
+			if profile.RSAKeyBits != 0 &&
+				profile.RSAKeyBits < defaultRSAKeySize {
+				return fmt.Errorf(
+					"x509_key_escrow: profile: %s: rsa_key_bits below %d",
+					profile.Name, defaultRSAKeySize)
+			}
+		case "ecdsa-p256", "ecdsa-p384", "ecdsa-p521":
+		default:
+			// Ed25519 keys cannot be used for encryption.
+			return fmt.Errorf("x509_key_escrow: profile: %s: bad key_type: %s",
+				profile.Name, profile.KeyType)
+		}
+	}
+	return nil
+}
+
+func (config *keyEscrowConfig) getProfile(
+	name string) *keyEscrowProfileConfig {
+	for index := range config.Profiles {
+		if config.Profiles[index].Name == name {
+			return &config.Profiles[index]
+		}
+	}
+	return nil
+}
+
+func (profile *keyEscrowProfileConfig) getKeyType() string {
+	if profile.KeyType == "" {
+		return defaultEscrowKeyType
+	}
+	return profile.KeyType
+}
+
+func (profile *keyEscrowProfileConfig) getRSAKeyBits() int {
+	if profile.RSAKeyBits < 1 {
+		return defaultRSAKeySize
+	}
+	return profile.RSAKeyBits
+}
+
+// loadKeyEscrowRecipients reads the recovery keys, if key escrow is enabled.
+func (state *RuntimeState) loadKeyEscrowRecipients() error {
+	config := state.Config.X509KeyEscrow
+	if len(config.Profiles) < 1 {
+		return nil
+	}
+	recipients, err := readPGPRecipients(config.RecoveryKeyFilenames)
+	if err != nil {
+		return fmt.Errorf("x509_key_escrow: %s", err)
+	}
+	if len(recipients) < 1 {
+		return errors.New("x509_key_escrow: no recovery keys")
+	}
+	state.keyEscrowRecipients = recipients
+	return nil
+}
+
+// generateEscrowedKey generates a key for profileName and encrypts it to the
+// recovery keys. The first error is for the client, the second is an
+// internal error.
+func (state *RuntimeState) generateEscrowedKey(profileName string) (
+	*escrowedKey, error, error) {
+	profile := state.Config.X509KeyEscrow.getProfile(profileName)
+	if profile == nil {
+		return nil, fmt.Errorf("Unknown key profile: %s", profileName), nil
+	}
+	if len(state.keyEscrowRecipients) < 1 {
+		return nil, nil, errors.New("no key escrow recovery keys loaded")
+	}
+	signer, privateKey, err := generateCAKey(profile.getKeyType(),
+		profile.getRSAKeyBits())
+	if err != nil {
+		return nil, nil, err
+	}
+	encryptedKey, err := pgpEncrypt(pem.EncodeToMemory(privateKey), nil,
+		state.keyEscrowRecipients)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &escrowedKey{
+		profile:      profile.Name,
+		publicKey:    signer.Public(),
+		privateKey:   privateKey,
+		encryptedKey: encryptedKey,
+	}, nil, nil
+}
+
+// postAuthEscrowedX509CertHandler issues an X.509 certificate for a key
+// generated and escrowed for the user, instead of one the user uploaded.
+func (state *RuntimeState) postAuthEscrowedX509CertHandler(
+	w http.ResponseWriter, r *http.Request, targetUser string,
+	keySigner crypto.Signer, duration time.Duration,
+	issuance issuanceContext) {
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	key, userErr, err := state.generateEscrowedKey(issuance.keyProfile)
+	if err != nil {
+		logger.Printf("cannot generate escrowed key: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if userErr != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			userErr.Error())
+		return
+	}
+	issuance.escrowedKey = key
+	state.writeX509Certificate(w, r, targetUser, keySigner, key.publicKey,
+		duration, false, r.Form.Get("addGroups") == "true", issuance)
+}
+
+// recordEscrowedKey stores the encrypted key of cert. The key must not be
+// given to the user unless this succeeds.
+func (state *RuntimeState) recordEscrowedKey(username string,
+	cert *x509.Certificate, issuance issuanceContext) error {
+	if state.db == nil {
+		return errors.New("no database for escrowed keys")
+	}
+	_, err := state.db.Exec(insertEscrowedKeyStmt[state.dbType], username,
+		issuance.escrowedKey.profile, issuance.AuditID,
+		cert.SerialNumber.String(), publicKeyFingerprint(cert.PublicKey),
+		time.Now().Unix(), cert.NotAfter.Unix(),
+		string(issuance.escrowedKey.encryptedKey))
+	return err
+}
+
+// getEscrowedKeys returns the most recent escrowed keys whose column
+// (username, serial or audit_id) has value.
+func (state *RuntimeState) getEscrowedKeys(column, value string,
+	limit int) ([]escrowedKeyRecord, error) {
+	stmt, ok := getEscrowedKeysStmt[state.dbType][column]
+	if !ok {
+		return nil, fmt.Errorf("cannot look up escrowed keys by: %s", column)
+	}
+	rows, err := state.db.Query(stmt, value, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := make([]escrowedKeyRecord, 0)
+	for rows.Next() {
+		var record escrowedKeyRecord
+		var escrowedEpoch, expirationEpoch int64
+		err := rows.Scan(&record.Username, &record.Profile, &record.AuditID,
+			&record.Serial, &record.Fingerprint, &escrowedEpoch,
+			&expirationEpoch, &record.EncryptedKey)
+		if err != nil {
+			return nil, err
+		}
+		record.EscrowedAt = time.Unix(escrowedEpoch, 0)
+		record.ExpiresAt = time.Unix(expirationEpoch, 0)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// escrowedKeysHandler returns the encrypted escrowed keys to admins, looked
+// up by the username, serial or audit_id parameter. Only the holders of the
+// recovery keys can decrypt them.
+func (state *RuntimeState) escrowedKeysHandler(w http.ResponseWriter,
+	r *http.Request) {
+	failure, adminUser := state.sendFailureToClientIfNonAdmin(w, r)
+	if failure {
+		return
+	}
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	var column, value string
+	for _, name := range []string{"username", "serial", "audit_id"} {
+		if v := r.URL.Query().Get(name); v != "" {
+			if column != "" {
+				state.writeFailureResponse(w, r, http.StatusBadRequest,
+					"Only one of username, serial or audit_id allowed")
+				return
+			}
+			column, value = name, v
+		}
+	}
+	if column == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Missing username, serial or audit_id")
+		return
+	}
+	records, err := state.getEscrowedKeys(column, value,
+		maxEscrowedKeysPerQuery)
+	if err != nil {
+		logger.Printf("error getting escrowed keys: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	logger.Printf("admin: %s retrieved %d escrowed keys by %s: %s",
+		adminUser, len(records), column, value)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/admincache"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func TestKeyEscrowConfigCheck(t *testing.T) {
+	recoveryKeys := []string{"/etc/keymaster/recovery.asc"}
+	valid := []keyEscrowConfig{
+		{},
+		{
+			RecoveryKeyFilenames: recoveryKeys,
+			Profiles: []keyEscrowProfileConfig{
+				{Name: "encryption"},
+				{Name: "smime-p384", KeyType: "ecdsa-p384"},
+				{Name: "rsa-4096", KeyType: "rsa", RSAKeyBits: 4096},
+			},
+		},
+	}
+	for _, config := range valid {
+		if err := config.check(); err != nil {
+			t.Errorf("%+v: %s", config, err)
+		}
+	}
+	invalid := []keyEscrowConfig{
+		{Profiles: []keyEscrowProfileConfig{{Name: "encryption"}}},
+		{
+			RecoveryKeyFilenames: recoveryKeys,
+			Profiles:             []keyEscrowProfileConfig{{Name: "Bad Name"}},
+		},
+		{
+			RecoveryKeyFilenames: recoveryKeys,
+			Profiles: []keyEscrowProfileConfig{
+				{Name: "encryption"}, {Name: "encryption"}},
+		},
+		{
+			RecoveryKeyFilenames: recoveryKeys,
+			Profiles: []keyEscrowProfileConfig{
+				{Name: "encryption", KeyType: "ed25519"}},
+		},
+		{
+			RecoveryKeyFilenames: recoveryKeys,
+			Profiles: []keyEscrowProfileConfig{
+				{Name: "encryption", RSAKeyBits: 2048}},
+		},
+	}
+	for _, config := range invalid {
+		if err := config.check(); err == nil {
+			t.Errorf("%+v: not rejected", config)
+		}
+	}
+}
+
+func pgpDecryptTestMessage(t *testing.T, message string,
+	recipients openpgp.EntityList) []byte {
+	block, err := armor.Decode(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := openpgp.ReadMessage(block.Body, recipients, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plaintext
+}
+
+func TestEscrowedX509Certificate(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	tmpdir, err := ioutil.TempDir("", "keymasterd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	state.Config.Base.DataDirectory = tmpdir
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	recovery, err := openpgp.NewEntity("recovery", "", "recovery@example.com",
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.keyEscrowRecipients = openpgp.EntityList{recovery}
+	state.Config.X509KeyEscrow.Profiles = []keyEscrowProfileConfig{
+		{Name: "encryption", KeyType: "ecdsa-p256"},
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(body string,
+		expectedStatus int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/certgen/username",
+			strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		recorder, err := checkRequestHandlerCode(req, state.certGenHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatalf("%s: %s", body, err)
+		}
+		return recorder
+	}
+	// SSH certificates are never issued for escrowed keys.
+	request(`{"type": "ssh", "key_profile": "encryption"}`,
+		http.StatusBadRequest)
+	request(`{"type": "x509", "key_profile": "unknown"}`,
+		http.StatusBadRequest)
+	request(`{"type": "x509", "key_profile": "encryption", "preview": true}`,
+		http.StatusBadRequest)
+	recorder := request(`{"type": "x509", "key_profile": "encryption"}`,
+		http.StatusOK)
+	var response certgenResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode([]byte(response.PrivateKey))
+	if block == nil {
+		t.Fatalf("no private key in response: %+v", response)
+	}
+	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certBlock, _ := pem.Decode([]byte(response.Certificate))
+	if certBlock == nil {
+		t.Fatal("no certificate in response")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !privateKey.PublicKey.Equal(cert.PublicKey) {
+		t.Fatal("certificate is not for the returned key")
+	}
+	records, err := state.getEscrowedKeys("serial", response.Serial, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Username != "username" ||
+		records[0].Profile != "encryption" ||
+		records[0].AuditID != response.AuditID {
+		t.Fatalf("unexpected escrow records: %+v", records)
+	}
+	escrowed := pgpDecryptTestMessage(t, records[0].EncryptedKey,
+		state.keyEscrowRecipients)
+	if !bytes.Equal(escrowed, []byte(response.PrivateKey)) {
+		t.Fatal("escrowed key differs from the issued key")
+	}
+}
+
+func TestEscrowedKeysHandler(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	state.isAdminCache = admincache.New(5 * time.Minute)
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypeU2F}
+	_, err = state.db.Exec(insertEscrowedKeyStmt[state.dbType], "bob",
+		"encryption", "audit", "1234", "SHA256:test", time.Now().Unix(),
+		time.Now().Add(time.Hour).Unix(), "encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkQuery := func(username, query string,
+		expectedStatus int) *httptest.ResponseRecorder {
+		cookieVal, err := state.setNewAuthCookie(nil, username, AuthTypeU2F)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", escrowedKeysPath+query, nil)
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		recorder, err := checkRequestHandlerCode(req,
+			state.escrowedKeysHandler, expectedStatus)
+		if err != nil {
+			t.Fatalf("%s %s: %s", username, query, err)
+		}
+		return recorder
+	}
+	checkQuery("bob", "?username=bob", http.StatusUnauthorized)
+	checkQuery("alice", "", http.StatusBadRequest)
+	checkQuery("alice", "?username=bob&serial=1234", http.StatusBadRequest)
+	for _, query := range []string{
+		"?username=bob", "?serial=1234", "?audit_id=audit"} {
+		recorder := checkQuery("alice", query, http.StatusOK)
+		var records []escrowedKeyRecord
+		if err := json.NewDecoder(recorder.Body).Decode(&records); err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 || records[0].EncryptedKey != "encrypted" {
+			t.Fatalf("%s: unexpected records: %+v", query, records)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/deviceposture"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/kubesigner"
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+)
+
+const kubernetesCSRCertType = "x509-kubernetes-csr"
+
+func (state *RuntimeState) startKubernetesSigner() error {
+	if !state.Config.KubernetesSigner.Enabled() {
+		return nil
+	}
+	_, err := kubesigner.New(state.Config.KubernetesSigner,
+		state.signKubernetesRequest, logger)
+	return err
+}
+
+// signKubernetesRequest signs an approved Kubernetes CertificateSigningRequest
+// in the same way as x509-kubernetes certificates: the username is the common
+// name and the groups known to keymaster, not the ones in the request, are
+// the organizations.
+func (state *RuntimeState) signKubernetesRequest(
+	request kubesigner.Request) ([]byte, error) {
+	state.Mutex.RLock()
+	keySigner := state.Signer
+	caCert := state.caCert
+	state.Mutex.RUnlock()
+	if keySigner == nil {
+		return nil, errors.New("signer not loaded")
+	}
+	err := certgen.CheckPublicKeyStrength(request.PublicKey,
+		state.Config.Base.MinRSAKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	duration := request.Duration
+	if duration <= 0 || duration > maxCertificateLifetime {
+		duration = maxCertificateLifetime
+	}
+	if err := state.checkKubernetesRequestUser(request); err != nil {
+		return nil, err
+	}
+	groups, err := state.getUserGroups(request.Username)
+	if err != nil {
+		return nil, err
+	}
+	auditID, err := certgen.NewAuditID()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	derCert, err := certgen.GenUserX509CertWithAuditID(request.Username,
+		request.PublicKey, caCert,
+		state.signingPool.Signer(context.Background(),
+			state.faultInjector.Signer(keySigner)),
+		state.KerberosRealm, duration, nil, groups, auditID)
+	observeSigning(operationX509Sign, start, err)
+	if err != nil {
+		return nil, err
+	}
+	eventNotifier.PublishX509(derCert)
+	if parsedCert, err := x509.ParseCertificate(derCert); err == nil {
+		go state.recordIssuedCertificate(issuedCertRecord{
+			Username:    request.Username,
+			CertType:    kubernetesCSRCertType,
+			Serial:      parsedCert.SerialNumber.String(),
+			Fingerprint: publicKeyFingerprint(parsedCert.PublicKey),
+			IssuedAt:    time.Now(),
+			ExpiresAt:   parsedCert.NotAfter,
+			SourceAddr:  "kubernetes:" + request.Requestor,
+			Certificate: encodeCertificatePEM(derCert),
+			issuanceContext: issuanceContext{
+				AuditID:    auditID,
+				AuthMethod: "kubernetes",
+			},
+		})
+	}
+	metricLogCertDuration("x509", "granted", float64(duration.Seconds()))
+	go func(username string, certType string) {
+		metricsMutex.Lock()
+		defer metricsMutex.Unlock()
+		certGenCounter.WithLabelValues(username, certType).Inc()
+	}(request.Username, "x509")
+	return derCert, nil
+}
+
+// checkKubernetesRequestUser makes the honeytoken, account status and device
+// posture checks which certgenPath makes before issuing a certificate. There
+// is no device, so a posture checker which requires one refuses the request.
+func (state *RuntimeState) checkKubernetesRequestUser(
+	request kubesigner.Request) error {
+	if state.isHoneytoken(request.Username) {
+		state.tripHoneytoken(nil, request.Username,
+			honeytokenStageCertificate)
+		return kubesigner.ErrRefused
+	}
+	status, err := state.getUserAccountStatus(request.Username)
+	if err != nil {
+		logger.Printf("error getting account status of: %s: %s",
+			request.Username, err)
+	}
+	if status == accountStatusUnknown {
+		return fmt.Errorf("account status of: %s could not be checked",
+			request.Username)
+	}
+	if status != accountStatusActive {
+		return fmt.Errorf("account %s: %w", status, kubesigner.ErrRefused)
+	}
+	refusal, err := state.getDevicePostureRefusal(deviceposture.Request{
+		Username:   request.Username,
+		CertType:   kubernetesCSRCertType,
+		RemoteAddr: "kubernetes:" + request.Requestor,
+	})
+	if err != nil {
+		return err
+	}
+	if refusal != "" {
+		return fmt.Errorf("%s: %w", refusal, kubesigner.ErrRefused)
+	}
+	return nil
+}
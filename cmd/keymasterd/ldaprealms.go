@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth/ldap"
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth/realms"
+	"github.com/Cloud-Foundations/keymaster/lib/simplestorage"
+)
+
+// ldapUserInfoQuery is a directory to look a user up in, and the name of the
+// user in that directory.
+type ldapUserInfoQuery struct {
+	source   UserInfoLDAPSource
+	username string
+}
+
+// getLdapRealms returns the realms in the order they are tried, the default
+// one (if configured) first. The authenticators are not set.
+func (config *LdapConfig) getLdapRealms() []realms.Realm {
+	var ldapRealms []realms.Realm
+	if config.LDAPTargetURLs != "" {
+		ldapRealms = append(ldapRealms, realms.Realm{Name: "default"})
+	}
+	for _, realmConfig := range config.Realms {
+		ldapRealms = append(ldapRealms, realms.Realm{
+			Name:             realmConfig.Name,
+			UsernameSuffixes: realmConfig.UsernameSuffixes,
+		})
+	}
+	return ldapRealms
+}
+
+func (state *RuntimeState) newLdapRealmsChecker() (
+	*realms.PasswordAuthenticator, error) {
+	const timeoutSecs = 3
+	ldapConfig := state.Config.Ldap
+	var pwdCache simplestorage.SimpleStore
+	if !ldapConfig.DisablePasswordCache {
+		pwdCache = state
+	}
+	var urlLists, bindPatterns []string
+	var tlsConfigs []*tls.Config
+	if ldapConfig.LDAPTargetURLs != "" {
+		urlLists = append(urlLists, ldapConfig.LDAPTargetURLs)
+		bindPatterns = append(bindPatterns, ldapConfig.BindPattern)
+		tlsConfigs = append(tlsConfigs, ldapConfig.getTLSConfig())
+	}
+	for _, realmConfig := range ldapConfig.Realms {
+		if realmConfig.Name == "" || realmConfig.LDAPTargetURLs == "" ||
+			realmConfig.BindPattern == "" {
+			return nil, errors.New(
+				"LDAP realms need a name, URLs and a bind pattern")
+		}
+		urlLists = append(urlLists, realmConfig.LDAPTargetURLs)
+		bindPatterns = append(bindPatterns, realmConfig.BindPattern)
+		tlsConfigs = append(tlsConfigs, realmConfig.getTLSConfig())
+	}
+	ldapRealms := ldapConfig.getLdapRealms()
+	for index := range ldapRealms {
+		authenticator, err := ldap.New(
+			strings.Split(urlLists[index], ","),
+			[]string{bindPatterns[index]}, timeoutSecs, tlsConfigs[index],
+			pwdCache, logger)
+		if err != nil {
+			return nil, err
+		}
+		authenticator.SetCircuitBreakers(state.backendBreakers)
+		authenticator.SetBindObserver(observeLDAPBind)
+		ldapRealms[index].Authenticator = authenticator
+	}
+	return realms.New(ldapRealms, logger)
+}
+
+// getLdapUserInfoQueries returns the directories to look username up in, in
+// order. A user of a realm selected by username suffix is only looked up in
+// that realm, if it has a userinfo source.
+func (state *RuntimeState) getLdapUserInfoQueries(
+	username string) []ldapUserInfoQuery {
+	ldapConfig := state.Config.Ldap
+	offset := 0
+	if ldapConfig.LDAPTargetURLs != "" {
+		offset = 1
+	}
+	index, realmUsername := realms.SelectRealm(ldapConfig.getLdapRealms(),
+		username)
+	if index >= offset {
+		source := ldapConfig.Realms[index-offset].UserInfo
+		if source.LDAPTargetURLs != "" {
+			return []ldapUserInfoQuery{{source, realmUsername}}
+		}
+	}
+	var queries []ldapUserInfoQuery
+	if source := state.Config.UserInfo.Ldap; source.LDAPTargetURLs != "" {
+		queries = append(queries, ldapUserInfoQuery{source, username})
+	}
+	for _, realmConfig := range ldapConfig.Realms {
+		if realmConfig.UserInfo.LDAPTargetURLs != "" {
+			queries = append(queries,
+				ldapUserInfoQuery{realmConfig.UserInfo, username})
+		}
+	}
+	return queries
+}
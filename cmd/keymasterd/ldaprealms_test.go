@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetLdapUserInfoQueries(t *testing.T) {
+	state := &RuntimeState{}
+	state.Config.Ldap = LdapConfig{
+		LDAPTargetURLs: "ldaps://ldap.acme.com",
+		Realms: []LdapRealmConfig{
+			{
+				Name:             "widgets",
+				LDAPTargetURLs:   "ldaps://ldap.widgets.com",
+				UsernameSuffixes: []string{"@widgets"},
+				UserInfo: UserInfoLDAPSource{
+					LDAPTargetURLs: "ldaps://ldap.widgets.com",
+				},
+			},
+			{
+				Name:             "gadgets",
+				LDAPTargetURLs:   "ldaps://ldap.gadgets.com",
+				UsernameSuffixes: []string{"@gadgets"},
+			},
+		},
+	}
+	state.Config.UserInfo.Ldap.LDAPTargetURLs = "ldaps://ldap.acme.com"
+	queries := state.getLdapUserInfoQueries("bob@widgets")
+	if len(queries) != 1 || queries[0].username != "bob" ||
+		queries[0].source.LDAPTargetURLs != "ldaps://ldap.widgets.com" {
+		t.Fatalf("unexpected queries: %+v", queries)
+	}
+	// Without a realm userinfo source, all sources are tried in order.
+	for _, username := range []string{"alice", "carol@gadgets"} {
+		queries = state.getLdapUserInfoQueries(username)
+		if len(queries) != 2 || queries[0].username != username ||
+			queries[0].source.LDAPTargetURLs != "ldaps://ldap.acme.com" ||
+			queries[1].source.LDAPTargetURLs != "ldaps://ldap.widgets.com" {
+			t.Fatalf("unexpected queries: %+v", queries)
+		}
+	}
+	state.Config = AppConfigFile{}
+	if queries := state.getLdapUserInfoQueries("alice"); len(queries) != 0 {
+		t.Fatalf("unexpected queries: %+v", queries)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Cloud-Foundations/keymaster/lib/authutil"
+)
+
+// load builds the TLS configuration from the CA file, pins and client
+// certificate, if any are configured.
+func (config *LDAPTLSConfig) load() error {
+	if (config.ClientCertFilename == "") != (config.ClientKeyFilename == "") {
+		return errors.New(
+			"LDAP client_cert_filename and client_key_filename must be set together")
+	}
+	if config.CAFilename == "" && len(config.PinnedPublicKeys) < 1 &&
+		config.ClientCertFilename == "" {
+		return nil
+	}
+	var rootCAs *x509.CertPool
+	if config.CAFilename != "" {
+		caData, err := ioutil.ReadFile(config.CAFilename)
+		if err != nil {
+			return err
+		}
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caData) {
+			return fmt.Errorf("no certificates in LDAP CA file: %s",
+				config.CAFilename)
+		}
+	}
+	tlsConfig, err := authutil.NewLDAPTLSConfig(rootCAs,
+		config.PinnedPublicKeys)
+	if err != nil {
+		return err
+	}
+	if config.ClientCertFilename != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFilename,
+			config.ClientKeyFilename)
+		if err != nil {
+			return fmt.Errorf("cannot load LDAP client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	config.tlsConfig = tlsConfig
+	return nil
+}
+
+// getTLSConfig returns nil (the system trust store and no client
+// certificate) unless something is configured.
+func (config *LDAPTLSConfig) getTLSConfig() *tls.Config {
+	return config.tlsConfig
+}
+
+func (config *AppConfigFile) setupLDAPTLS() error {
+	if err := config.Ldap.LDAPTLSConfig.load(); err != nil {
+		return err
+	}
+	for index := range config.Ldap.Realms {
+		realmConfig := &config.Ldap.Realms[index]
+		if err := realmConfig.LDAPTLSConfig.load(); err != nil {
+			return fmt.Errorf("LDAP realm: %s: %s", realmConfig.Name, err)
+		}
+		if err := realmConfig.UserInfo.LDAPTLSConfig.load(); err != nil {
+			return fmt.Errorf("LDAP realm: %s userinfo: %s", realmConfig.Name,
+				err)
+		}
+	}
+	return config.UserInfo.Ldap.LDAPTLSConfig.load()
+}
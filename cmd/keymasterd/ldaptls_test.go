@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func writeLDAPTLSTestFile(t *testing.T, content string) string {
+	tmpfile, err := ioutil.TempFile("", "ldap_tls_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmpfile.Close()
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		os.Remove(tmpfile.Name())
+		t.Fatal(err)
+	}
+	return tmpfile.Name()
+}
+
+func TestSetupLDAPTLS(t *testing.T) {
+	caFilename := writeLDAPTLSTestFile(t, rootCAPem)
+	defer os.Remove(caFilename)
+	var config AppConfigFile
+	err := yaml.Unmarshal([]byte(`
+ldap:
+  ldap_target_urls: ldap://ldap.example.com
+  ca_filename: `+caFilename+`
+  realms:
+    - name: widgets
+      ldap_target_urls: ldaps://ldap.widgets.com
+      pinned_public_keys:
+        - sha256//AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=
+`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.setupLDAPTLS(); err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig := config.Ldap.getTLSConfig(); tlsConfig == nil ||
+		tlsConfig.RootCAs == nil {
+		t.Fatal("CA file not loaded")
+	}
+	tlsConfig := config.Ldap.Realms[0].getTLSConfig()
+	if tlsConfig == nil || tlsConfig.VerifyPeerCertificate == nil {
+		t.Fatal("public key pins not loaded")
+	}
+	if tlsConfig.RootCAs != nil {
+		t.Fatal("system trust store not used")
+	}
+	if config.UserInfo.Ldap.getTLSConfig() != nil {
+		t.Fatal("TLS configuration without CA file or pins")
+	}
+}
+
+func TestSetupLDAPTLSClientCertificate(t *testing.T) {
+	certFilename := writeLDAPTLSTestFile(t, localhostCertPem)
+	defer os.Remove(certFilename)
+	keyFilename := writeLDAPTLSTestFile(t, localhostKeyPem)
+	defer os.Remove(keyFilename)
+	var config AppConfigFile
+	config.UserInfo.Ldap.ClientCertFilename = certFilename
+	config.UserInfo.Ldap.ClientKeyFilename = keyFilename
+	if err := config.setupLDAPTLS(); err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := config.UserInfo.Ldap.getTLSConfig()
+	if tlsConfig == nil || len(tlsConfig.Certificates) != 1 {
+		t.Fatal("client certificate not loaded")
+	}
+	config = AppConfigFile{}
+	config.Ldap.ClientCertFilename = certFilename
+	if err := config.setupLDAPTLS(); err == nil {
+		t.Fatal("no error for client certificate without key")
+	}
+	config = AppConfigFile{}
+	config.Ldap.ClientCertFilename = keyFilename
+	config.Ldap.ClientKeyFilename = certFilename
+	if err := config.setupLDAPTLS(); err == nil {
+		t.Fatal("no error for bad client certificate")
+	}
+}
+
+func TestSetupLDAPTLSFail(t *testing.T) {
+	var config AppConfigFile
+	config.UserInfo.Ldap.CAFilename = "/nonexistent/ca.pem"
+	if err := config.setupLDAPTLS(); err == nil {
+		t.Fatal("no error for missing CA file")
+	}
+	config = AppConfigFile{}
+	config.Ldap.Realms = []LdapRealmConfig{{Name: "widgets"}}
+	config.Ldap.Realms[0].PinnedPublicKeys = []string{"sha1//AAAA"}
+	if err := config.setupLDAPTLS(); err == nil {
+		t.Fatal("no error for bad public key pin")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/certreloader"
+)
+
+const (
+	handlerSetAdmin   = "admin"
+	handlerSetHealth  = "health"
+	handlerSetService = "service"
+)
+
+type listenerConfig struct {
+	Address         string `yaml:"address"`
+	HandlerSet      string `yaml:"handler_set"`
+	DisableTLS      bool   `yaml:"disable_tls"`
+	TLSCertFilename string `yaml:"tls_cert_filename"`
+	TLSKeyFilename  string `yaml:"tls_key_filename"`
+}
+
+func (state *RuntimeState) validateListeners() error {
+	for _, listener := range state.Config.Listeners {
+		if listener.Address == "" {
+			return fmt.Errorf("listener is missing an address")
+		}
+		switch listener.HandlerSet {
+		case handlerSetAdmin, handlerSetHealth, handlerSetService:
+		default:
+			return fmt.Errorf("listener %s: unknown handler_set: \"%s\"",
+				listener.Address, listener.HandlerSet)
+		}
+		if listener.DisableTLS && listener.HandlerSet != handlerSetHealth {
+			return fmt.Errorf(
+				"listener %s: TLS may only be disabled for the health handlers",
+				listener.Address)
+		}
+		if (listener.TLSCertFilename == "") !=
+			(listener.TLSKeyFilename == "") {
+			return fmt.Errorf(
+				"listener %s: both tls_cert_filename and tls_key_filename are needed",
+				listener.Address)
+		}
+	}
+	return nil
+}
+
+// newHealthHandler returns the handlers which are safe to expose without
+// authentication or TLS, such as to a load balancer.
+func (state *RuntimeState) newHealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(readyzPath, state.readyzHandler)
+	return mux
+}
+
+// newListenerServer returns the server for listener, which will use handler.
+// Unless TLS is disabled, the server uses a copy of tlsConfig. If the listener
+// has its own certificate, it is served instead and is reloaded when the files
+// change, like the main certificate.
+func (state *RuntimeState) newListenerServer(listener listenerConfig,
+	handler http.Handler, tlsConfig *tls.Config) (*http.Server, error) {
+	server := state.newHTTPServer(listener.Address, handler)
+	if listener.DisableTLS {
+		return server, nil
+	}
+	server.TLSConfig = tlsConfig.Clone()
+	if listener.TLSCertFilename == "" {
+		return server, nil
+	}
+	cert, err := tls.LoadX509KeyPair(listener.TLSCertFilename,
+		listener.TLSKeyFilename)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s: %s", listener.Address, err)
+	}
+	reloader := certreloader.New(listener.TLSCertFilename,
+		listener.TLSKeyFilename, tlsCertReloadInterval,
+		func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		},
+		state.logger)
+	server.TLSConfig.Certificates = nil
+	server.TLSConfig.GetCertificate = reloader.GetCertificate
+	return server, nil
+}
+
+// startListeners starts the configured extra listeners whose handler set is
+// present in handlers. Listeners for other handler sets are left alone so
+// that they may be started later (the service handlers are only started once
+// the signer is ready).
+func (state *RuntimeState) startListeners(handlers map[string]http.Handler,
+	tlsConfig *tls.Config) error {
+	for _, listener := range state.Config.Listeners {
+		handler, ok := handlers[listener.HandlerSet]
+		if !ok {
+			continue
+		}
+		server, err := state.newListenerServer(listener, handler, tlsConfig)
+		if err != nil {
+			return err
+		}
+		useTLS := !listener.DisableTLS
+		go func() {
+			if err := state.listenAndServe(server, useTLS); err != nil {
+				panic(err)
+			}
+		}()
+		if useTLS {
+			state.logger.Printf("started %s listener on: %s\n",
+				listener.HandlerSet, listener.Address)
+		} else {
+			state.logger.Printf("started cleartext %s listener on: %s\n",
+				listener.HandlerSet, listener.Address)
+		}
+	}
+	return nil
+}
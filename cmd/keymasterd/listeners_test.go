@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+func TestValidateListeners(t *testing.T) {
+	valid := []listenerConfig{
+		{Address: ":8080", HandlerSet: handlerSetHealth, DisableTLS: true},
+		{Address: ":8443", HandlerSet: handlerSetService},
+		{Address: ":6920", HandlerSet: handlerSetAdmin,
+			TLSCertFilename: "admin-cert.pem",
+			TLSKeyFilename:  "admin-key.pem"},
+	}
+	state := &RuntimeState{logger: testlogger.New(t)}
+	state.Config.Listeners = valid
+	if err := state.validateListeners(); err != nil {
+		t.Fatal(err)
+	}
+	invalid := []listenerConfig{
+		{HandlerSet: handlerSetHealth},
+		{Address: ":8080"},
+		{Address: ":8080", HandlerSet: "metrics"},
+		{Address: ":8080", HandlerSet: handlerSetService, DisableTLS: true},
+		{Address: ":8080", HandlerSet: handlerSetAdmin, DisableTLS: true},
+		{Address: ":6920", HandlerSet: handlerSetAdmin,
+			TLSCertFilename: "admin-cert.pem"},
+		{Address: ":6920", HandlerSet: handlerSetAdmin,
+			TLSKeyFilename: "admin-key.pem"},
+	}
+	for _, listener := range invalid {
+		state.Config.Listeners = []listenerConfig{listener}
+		if err := state.validateListeners(); err == nil {
+			t.Errorf("%+v: not rejected", listener)
+		}
+	}
+}
+
+func writeListenerKeyPair(t *testing.T, certFilename, keyFilename string,
+	serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	derCert, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(certFilename,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derCert}),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFilename,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: derKey}),
+		0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{certFilename, keyFilename} {
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func getListenerSerial(t *testing.T, server *http.Server) int64 {
+	if server.TLSConfig.GetCertificate == nil {
+		t.Fatal("no GetCertificate function")
+	}
+	cert, err := server.TLSConfig.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.SerialNumber.Int64()
+}
+
+func TestNewListenerServer(t *testing.T) {
+	state := &RuntimeState{logger: testlogger.New(t)}
+	handler := http.NewServeMux()
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	server, err := state.newListenerServer(listenerConfig{
+		Address:    ":8080",
+		HandlerSet: handlerSetHealth,
+		DisableTLS: true,
+	}, handler, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Addr != ":8080" || server.TLSConfig != nil {
+		t.Fatalf("unexpected cleartext server: %+v", server)
+	}
+	server, err = state.newListenerServer(listenerConfig{
+		Address:    ":8443",
+		HandlerSet: handlerSetService,
+	}, handler, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The shared configuration must not be modified by any listener.
+	if server.TLSConfig == tlsConfig ||
+		server.TLSConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("unexpected TLS config: %+v", server.TLSConfig)
+	}
+	_, err = state.newListenerServer(listenerConfig{
+		Address:         ":6920",
+		HandlerSet:      handlerSetAdmin,
+		TLSCertFilename: "/nonexistent/cert.pem",
+		TLSKeyFilename:  "/nonexistent/key.pem",
+	}, handler, tlsConfig)
+	if err == nil {
+		t.Fatal("missing certificate accepted")
+	}
+}
+
+func TestListenerCertificateReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keymaster-listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(interval time.Duration) {
+		tlsCertReloadInterval = interval
+	}(tlsCertReloadInterval)
+	tlsCertReloadInterval = 0
+	certFilename := filepath.Join(dir, "cert.pem")
+	keyFilename := filepath.Join(dir, "key.pem")
+	startTime := time.Now().Add(-time.Hour)
+	writeListenerKeyPair(t, certFilename, keyFilename, 1, startTime)
+	state := &RuntimeState{logger: testlogger.New(t)}
+	tlsConfig := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			t.Fatal("main certificate used for listener")
+			return nil, nil
+		},
+	}
+	server, err := state.newListenerServer(listenerConfig{
+		Address:         ":6920",
+		HandlerSet:      handlerSetAdmin,
+		TLSCertFilename: certFilename,
+		TLSKeyFilename:  keyFilename,
+	}, http.NewServeMux(), tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial := getListenerSerial(t, server); serial != 1 {
+		t.Fatalf("expected serial 1, got: %d", serial)
+	}
+	writeListenerKeyPair(t, certFilename, keyFilename, 2,
+		startTime.Add(time.Minute))
+	if serial := getListenerSerial(t, server); serial != 2 {
+		t.Fatalf("expected serial 2, got: %d", serial)
+	}
+	// A broken update keeps the current certificate.
+	if err := ioutil.WriteFile(keyFilename, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if serial := getListenerSerial(t, server); serial != 2 {
+		t.Fatalf("expected serial 2, got: %d", serial)
+	}
+}
@@ -26,6 +26,9 @@ func NewLogFilterHandler(handler http.Handler, disableFilter bool,
 // CA.
 func (state *RuntimeState) sendFailureToClientIfNotAdminUserOrCA(
 	w http.ResponseWriter, r *http.Request) bool {
+	if isAdminSocketRequest(r) {
+		return false
+	}
 	if r.TLS == nil {
 		http.Error(w, "TLS mandatory", http.StatusUnauthorized)
 		return true
@@ -64,5 +67,14 @@ func (h *logFilterType) ServeHTTP(w http.ResponseWriter,
 			return
 		}
 	}
+	// Profiles and runtime stats are never public, and metrics are scraped
+	// from the metrics listener unless it is disabled.
+	if strings.HasPrefix(req.URL.Path, debugPath) ||
+		(isMetricsPath(req.URL.Path) &&
+			!h.state.Config.Base.DisableMetricsListener) {
+		if h.state.sendFailureToClientIfNotAdminUserOrCA(w, req) {
+			return
+		}
+	}
 	h.handler.ServeHTTP(w, req)
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	flashCookieName          = "flash_message"
+	maxAgeSecondsFlashCookie = 60
+	maxFlashMessageLength    = 256
+)
+
+// isSafeLoginDestination returns true if destination is a relative path on
+// this server. Browsers treat both // and /\ as the start of an authority,
+// and remove tabs and newlines from URLs, so backslashes and control
+// characters are refused anywhere to prevent an open redirect.
+func isSafeLoginDestination(destination string) bool {
+	if !strings.HasPrefix(destination, "/") ||
+		strings.HasPrefix(destination, "//") {
+		return false
+	}
+	for _, c := range destination {
+		if c < 0x20 || c == 0x7f || c == '\\' {
+			return false
+		}
+	}
+	u, err := url.Parse(destination)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// getRequestLoginDestination returns where the browser should be sent once
+// the user has authenticated. GET requests return to the page originally
+// requested, other requests to the destination they carried in the form.
+func getRequestLoginDestination(r *http.Request) string {
+	switch r.Method {
+	case "GET", "HEAD":
+		if destination := r.URL.RequestURI(); isSafeLoginDestination(
+			destination) {
+			return destination
+		}
+	case "POST":
+		// Assume the form has been parsed, otherwise why are we here?
+		if r.Form.Get("login_destination") != "" {
+			return getLoginDestination(r)
+		}
+	}
+	return profilePath
+}
+
+func loginFormURL(loginDestination string) string {
+	if loginDestination == profilePath {
+		return loginFormPath
+	}
+	return loginFormPath + "?" + url.Values{
+		"login_destination": {loginDestination}}.Encode()
+}
+
+// setFlashMessage stores a message to be shown on the next page rendered,
+// which allows failures to survive a redirect.
+func setFlashMessage(w http.ResponseWriter, message string) {
+	if message == "" {
+		return
+	}
+	if len(message) > maxFlashMessageLength {
+		message = message[:maxFlashMessageLength]
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(message)),
+		Expires:  time.Now().Add(maxAgeSecondsFlashCookie * time.Second),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// popFlashMessage returns the pending flash message (if any) and clears it.
+func popFlashMessage(w http.ResponseWriter, r *http.Request) string {
+	cookie, err := r.Cookie(flashCookieName)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookieName,
+		Value:    "",
+		Expires:  time.Unix(0, 0),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	message, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(message) > maxFlashMessageLength {
+		return ""
+	}
+	return string(message)
+}
+
+// redirectToLogin sends the browser to the login page, which will return it
+// to loginDestination after a successful login. The redirect uses 303 so that
+// a failed form submission is not re-posted when the page is reloaded.
+func (state *RuntimeState) redirectToLogin(w http.ResponseWriter,
+	r *http.Request, loginDestination string, message string) {
+	setFlashMessage(w, message)
+	http.Redirect(w, r, loginFormURL(loginDestination), http.StatusSeeOther)
+}
+
+// loginFormHandler renders the login page, including any flash message left
+// by a previous failure.
+func (state *RuntimeState) loginFormHandler(w http.ResponseWriter,
+	r *http.Request) {
+	setSecurityHeaders(w)
+	if err := r.ParseForm(); err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	state.writeHTMLLoginPage(w, r, http.StatusOK, getLoginDestination(r),
+		popFlashMessage(w, r))
+}
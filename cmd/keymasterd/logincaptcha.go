@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/captcha"
+)
+
+const loginCaptchaCSPFormat = "default-src 'self' ;script-src 'self' %[1]s; frame-src %[1]s; connect-src 'self' %[1]s; style-src 'self' fonts.googleapis.com 'unsafe-inline' %[1]s; font-src fonts.gstatic.com fonts.googleapis.com"
+
+// isHTMLLoginRequest returns true for logins submitted from the login form.
+// API clients use basic authentication or do not accept HTML, and are never
+// challenged.
+func isHTMLLoginRequest(r *http.Request) bool {
+	if _, _, ok := r.BasicAuth(); ok {
+		return false
+	}
+	return getPreferredAcceptType(r) == "text/html"
+}
+
+// checkLoginCaptcha returns true if the login may proceed. Otherwise the
+// client is sent back to the login form, which shows the CAPTCHA.
+func (state *RuntimeState) checkLoginCaptcha(w http.ResponseWriter,
+	r *http.Request) bool {
+	verifier := state.loginCaptcha
+	sourceIP := getSourceIP(r.RemoteAddr)
+	if !isHTMLLoginRequest(r) || !verifier.Required(sourceIP) {
+		return true
+	}
+	err := verifier.Verify(r.Context(), r.Form.Get(verifier.FormField()),
+		sourceIP)
+	if err == nil {
+		return true
+	}
+	requestLimitedCounter.WithLabelValues("login", "captcha").Inc()
+	if errors.Is(err, captcha.ErrMissingResponse) ||
+		errors.Is(err, captcha.ErrRejected) {
+		logger.Debugf(1, "CAPTCHA failed for %s: %s", sourceIP, err)
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Please complete the CAPTCHA")
+		return false
+	}
+	logger.Printf("cannot verify CAPTCHA for %s: %s", sourceIP, err)
+	state.writeFailureResponse(w, r, http.StatusUnauthorized,
+		"CAPTCHA verification failed, please try again later")
+	return false
+}
+
+// recordLoginResult counts password failures per source address, for
+// deciding when to challenge the login form. Failures from API clients count
+// too, since they share the password backend.
+func (state *RuntimeState) recordLoginResult(r *http.Request, valid bool) {
+	if valid {
+		state.loginCaptcha.RecordSuccess(getSourceIP(r.RemoteAddr))
+	} else {
+		state.loginCaptcha.RecordFailure(getSourceIP(r.RemoteAddr))
+	}
+}
+
+// setLoginCaptchaData adds the CAPTCHA widget to the login page if the
+// client must solve one, and allows the provider in the page policy.
+func (state *RuntimeState) setLoginCaptchaData(w http.ResponseWriter,
+	r *http.Request, displayData *loginPageTemplateData) {
+	verifier := state.loginCaptcha
+	if !verifier.Required(getSourceIP(r.RemoteAddr)) {
+		return
+	}
+	displayData.JSSources = append(displayData.JSSources,
+		verifier.ScriptURL())
+	displayData.CaptchaSiteKey = verifier.SiteKey()
+	displayData.CaptchaWidgetClass = verifier.WidgetClass()
+	w.Header().Set("Content-Security-Policy", fmt.Sprintf(
+		loginCaptchaCSPFormat, verifier.ContentSecurityPolicySources()))
+}
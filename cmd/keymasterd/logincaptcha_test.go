@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/captcha"
+)
+
+func TestLoginCaptcha(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"success": %v}`,
+				r.FormValue("response") == "solved")
+		}))
+	defer server.Close()
+	verifier, err := captcha.New(captcha.Config{
+		Provider:         captcha.ProviderHCaptcha,
+		SiteKey:          "site",
+		SecretKey:        "secret",
+		FailureThreshold: 2,
+		VerifyURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &RuntimeState{loginCaptcha: verifier}
+	newLoginRequest := func(form url.Values, accept string) *http.Request {
+		req := httptest.NewRequest("POST", "/api/v0/login",
+			strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", accept)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if err := req.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	form := url.Values{"username": {"user"}, "password": {"wrong"}}
+	for i := 0; i < 2; i++ {
+		req := newLoginRequest(form, "text/html")
+		if !state.checkLoginCaptcha(httptest.NewRecorder(), req) {
+			t.Fatalf("challenged after %d failures", i)
+		}
+		state.recordLoginResult(req, false)
+	}
+	req := newLoginRequest(form, "text/html")
+	w := httptest.NewRecorder()
+	if state.checkLoginCaptcha(w, req) {
+		t.Fatal("not challenged")
+	}
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect to login form, got: %d", w.Code)
+	}
+	// API clients are not challenged.
+	req = newLoginRequest(form, "application/json")
+	if !state.checkLoginCaptcha(httptest.NewRecorder(), req) {
+		t.Fatal("API client challenged")
+	}
+	req = newLoginRequest(form, "text/html")
+	req.SetBasicAuth("user", "wrong")
+	if !state.checkLoginCaptcha(httptest.NewRecorder(), req) {
+		t.Fatal("basic authentication challenged")
+	}
+	// The login form shows the widget.
+	req = newLoginRequest(form, "text/html")
+	w = httptest.NewRecorder()
+	var displayData loginPageTemplateData
+	state.setLoginCaptchaData(w, req, &displayData)
+	if displayData.CaptchaSiteKey != "site" ||
+		displayData.CaptchaWidgetClass != "h-captcha" ||
+		len(displayData.JSSources) != 1 {
+		t.Fatalf("unexpected display data: %+v", displayData)
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(
+		csp, "frame-src https://hcaptcha.com") {
+		t.Fatalf("unexpected Content-Security-Policy: %s", csp)
+	}
+	form.Set(verifier.FormField(), "solved")
+	req = newLoginRequest(form, "text/html")
+	if !state.checkLoginCaptcha(httptest.NewRecorder(), req) {
+		t.Fatal("solved CAPTCHA rejected")
+	}
+	state.recordLoginResult(req, true)
+	req = newLoginRequest(url.Values{}, "text/html")
+	if !state.checkLoginCaptcha(httptest.NewRecorder(), req) {
+		t.Fatal("challenged after successful login")
+	}
+}
@@ -176,14 +176,10 @@ func setupValidRuntimeStateSigner(t *testing.T) (
 		//log.Printf("Cannot parse Priave Key file")
 		return nil, nil, err
 	}
-	state.Signer = signer
-	state.signerPublicKeyToKeymasterKeys()
-
-	//for x509
-	state.caCertDer, err = generateCADer(&state, signer)
-	if err != nil {
+	if err := state.setSigners(signer, nil); err != nil {
 		return nil, nil, err
 	}
+	state.signerPublicKeyToKeymasterKeys()
 
 	passwdFile, err := setupPasswdFile()
 	if err != nil {
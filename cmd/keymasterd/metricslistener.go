@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	defaultMetricsAddress = "localhost:6930"
+	prometheusMetricsPath = "/prometheus_metrics"
+	tricorderMetricsPath  = "/metrics"
+)
+
+// isMetricsPath returns true for the operational endpoints which are served
+// without authentication by the metrics listener: metrics, profiles and
+// runtime stats.
+func isMetricsPath(path string) bool {
+	return strings.HasPrefix(path, prometheusMetricsPath) ||
+		strings.HasPrefix(path, tricorderMetricsPath) ||
+		strings.HasPrefix(path, debugPath)
+}
+
+func (state *RuntimeState) getMetricsAddress() string {
+	if state.Config.Base.MetricsAddress != "" {
+		return state.Config.Base.MetricsAddress
+	}
+	return defaultMetricsAddress
+}
+
+// newMetricsHandler returns the handlers of the metrics listener. The
+// tricorder and profiling handlers are registered on http.DefaultServeMux,
+// which also has the admin dashboard, so only their paths are passed on.
+func (state *RuntimeState) newMetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(prometheusMetricsPath, promhttp.Handler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if !isMetricsPath(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		http.DefaultServeMux.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+// startMetricsListener serves the metrics over cleartext HTTP, for
+// Prometheus and local debugging, unless it is disabled.
+func (state *RuntimeState) startMetricsListener() error {
+	if state.Config.Base.DisableMetricsListener {
+		return nil
+	}
+	address := state.getMetricsAddress()
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); host != "localhost" &&
+			(ip == nil || !ip.IsLoopback()) {
+			state.logger.Printf(
+				"warning: metrics and profiles are served without authentication on: %s\n",
+				address)
+		}
+	}
+	server := state.newHTTPServer(address, state.newMetricsHandler())
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			panic(err)
+		}
+	}()
+	state.logger.Printf("started metrics listener on: %s\n", address)
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	state := &RuntimeState{logger: testlogger.New(t)}
+	handler := state.newMetricsHandler()
+	tests := map[string]int{
+		"/prometheus_metrics": http.StatusOK,
+		"/debug/pprof/":       http.StatusOK,
+		"/":                   http.StatusNotFound,
+		"/logs":               http.StatusNotFound,
+	}
+	for path, expected := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != expected {
+			t.Errorf("%s: expected: %d, got: %d", path, expected, w.Code)
+		}
+	}
+}
+
+func TestLogFilterMetricsPaths(t *testing.T) {
+	state := &RuntimeState{logger: testlogger.New(t)}
+	handler := NewLogFilterHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}), true, state)
+	check := func(path string, expected int) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != expected {
+			t.Errorf("%s: expected: %d, got: %d", path, expected, w.Code)
+		}
+	}
+	check("/prometheus_metrics", http.StatusUnauthorized)
+	check("/metrics/", http.StatusUnauthorized)
+	check("/debug/pprof/", http.StatusUnauthorized)
+	check("/logs", http.StatusOK)
+	state.Config.Base.DisableMetricsListener = true
+	check("/prometheus_metrics", http.StatusOK)
+	check("/metrics/", http.StatusOK)
+	check("/debug/pprof/", http.StatusUnauthorized)
+}
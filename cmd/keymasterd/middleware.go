@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// newMiddlewareHandler wraps handler with the middleware common to all the
+// HTTP servers: panic recovery, request body limits and request deadlines.
+func (state *RuntimeState) newMiddlewareHandler(
+	handler http.Handler) http.Handler {
+	return state.newRecoveryHandler(
+		state.newBodyLimitHandler(
+			state.newDeadlineHandler(handler)))
+}
+
+// newRecoveryHandler returns a handler which turns a panic in handler into a
+// 500 response and logs the stack trace, rather than letting net/http drop
+// the connection.
+func (state *RuntimeState) newRecoveryHandler(
+	handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// Used by handlers to deliberately abort the response.
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			logger.Printf("panic serving %s %s from %s: %v\n%s",
+				r.Method, r.URL.Path, r.RemoteAddr, err, debug.Stack())
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
+		}()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// newDeadlineHandler returns a handler which sets the configured deadline on
+// the request context, so that backend calls made with it are abandoned once
+// the response can no longer be written. The debug endpoints are exempt, as
+// profiles and traces run for as long as their seconds parameter asks.
+func (state *RuntimeState) newDeadlineHandler(
+	handler http.Handler) http.Handler {
+	config := state.Config.HTTPServer
+	timeout := durationOrDefault(config.RequestTimeout,
+		durationOrDefault(config.WriteTimeout, defaultWriteTimeout))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, debugPath) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecoveryHandler(t *testing.T) {
+	state := RuntimeState{}
+	handler := state.newRecoveryHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			panic("handler bug")
+		}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected %d, got %d", http.StatusInternalServerError,
+			rr.Code)
+	}
+	handler = state.newRecoveryHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Fatalf("expected ErrAbortHandler panic, got: %v", err)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest("GET", "/", nil))
+}
+
+func TestDeadlineHandler(t *testing.T) {
+	state := RuntimeState{}
+	state.Config.HTTPServer.RequestTimeout = time.Minute
+	var deadline time.Time
+	handler := state.newMiddlewareHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var ok bool
+			if deadline, ok = r.Context().Deadline(); !ok {
+				t.Fatal("no deadline set on request context")
+			}
+		}))
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rr.Code)
+	}
+	if deadline.Before(start.Add(time.Minute)) ||
+		deadline.After(time.Now().Add(time.Minute)) {
+		t.Fatalf("unexpected deadline: %s", deadline)
+	}
+}
+
+func TestDeadlineHandlerDebugExempt(t *testing.T) {
+	state := RuntimeState{}
+	tests := map[string]bool{
+		"/debug/pprof/profile": false,
+		"/debug/pprof/trace":   false,
+		"/api/v0/certgen":      true,
+	}
+	for path, expectDeadline := range tests {
+		var deadline time.Time
+		var hasDeadline bool
+		handler := state.newMiddlewareHandler(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				deadline, hasDeadline = r.Context().Deadline()
+			}))
+		handler.ServeHTTP(httptest.NewRecorder(),
+			httptest.NewRequest("GET", path+"?seconds=30", nil))
+		if hasDeadline != expectDeadline {
+			t.Errorf("%s: deadline set: %t, expected: %t", path, hasDeadline,
+				expectDeadline)
+			continue
+		}
+		// Other requests keep the default deadline.
+		if hasDeadline && deadline.After(time.Now().Add(defaultWriteTimeout)) {
+			t.Errorf("%s: unexpected deadline: %s", path, deadline)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/signingpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Operations whose latency is recorded in operationDurationHistogram.
+const (
+	operationLDAPBind    = "ldap_bind"
+	operationProfileLoad = "profile_load"
+	operationProfileSave = "profile_save"
+	operationSSHHostSign = "ssh_host_sign"
+	operationSSHSign     = "ssh_sign"
+	operationX509Sign    = "x509_sign"
+)
+
+// Outcomes of operations.
+const (
+	outcomeCache      = "cache"
+	outcomeError      = "error"
+	outcomeNotFound   = "not_found"
+	outcomeOK         = "ok"
+	outcomeOverloaded = "overloaded"
+)
+
+var operationDurationHistogram = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "keymaster_operation_duration_seconds",
+		Help:    "Duration of signing, profile storage and LDAP bind operations.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	},
+	[]string{"operation", "outcome"},
+)
+
+func init() {
+	prometheus.MustRegister(operationDurationHistogram)
+}
+
+func observeOperation(operation, outcome string, start time.Time) {
+	operationDurationHistogram.WithLabelValues(operation, outcome).Observe(
+		time.Since(start).Seconds())
+}
+
+// observeSigning records a signing operation. Requests rejected by the
+// signing pool are counted separately as they never reached the signer.
+func observeSigning(operation string, start time.Time, err error) {
+	outcome := outcomeOK
+	if signingpool.IsOverloaded(err) {
+		outcome = outcomeOverloaded
+	} else if err != nil {
+		outcome = outcomeError
+	}
+	observeOperation(operation, outcome, start)
+}
+
+// observeProfileLoad records a profile load by where the profile came from.
+func observeProfileLoad(start time.Time, ok, fromCache bool, err error) {
+	outcome := outcomeOK
+	switch {
+	case err != nil:
+		outcome = outcomeError
+	case fromCache:
+		outcome = outcomeCache
+	case !ok:
+		outcome = outcomeNotFound
+	}
+	observeOperation(operationProfileLoad, outcome, start)
+}
+
+// observeLDAPBind is the bind observer of the LDAP password authenticators.
+// A bind with a wrong password is a successful operation.
+func observeLDAPBind(server string, duration time.Duration, err error) {
+	outcome := outcomeOK
+	if err != nil {
+		outcome = outcomeError
+	}
+	operationDurationHistogram.WithLabelValues(operationLDAPBind,
+		outcome).Observe(duration.Seconds())
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"github.com/Cloud-Foundations/keymaster/lib/webauthn"
+	"github.com/Cloud-Foundations/keymaster/proto/eventmon"
+)
+
+// Passkeys are WebAuthn credentials which are stored on the authenticator
+// (discoverable credentials) and which verify the user with a PIN or a
+// biometric. The authenticator returns the user handle, so the user can log
+// in without typing a username, and the passkey replaces both the password
+// and the second factor.
+
+const (
+	webauthnPasskeyLoginRequestPath  = "/webauthn/PasskeyLoginRequest"
+	webauthnPasskeyLoginResponsePath = "/webauthn/PasskeyLoginResponse"
+
+	// Login challenges are handed out before the user is known, so their
+	// number is bounded.
+	maxPasskeyChallenges = 10000
+
+	webauthnUserHandleSize = 32
+)
+
+var errTooManyPasskeyChallenges = errors.New("too many pending passkey logins")
+
+var getWebAuthnUserHandleStmt = map[string]string{
+	"sqlite":   "select username from webauthn_user_handle where user_handle = ?",
+	"postgres": "select username from webauthn_user_handle where user_handle = $1",
+}
+
+var setWebAuthnUserHandleStmt = map[string]string{
+	"sqlite":   "insert or replace into webauthn_user_handle(user_handle, username) values(?, ?)",
+	"postgres": "insert into webauthn_user_handle(user_handle, username) values($1, $2) on CONFLICT(user_handle) DO UPDATE set username = excluded.username",
+}
+
+var deleteWebAuthnUserHandleStmt = map[string]string{
+	"sqlite":   "delete from webauthn_user_handle where username = ?",
+	"postgres": "delete from webauthn_user_handle where username = $1",
+}
+
+// webauthnPasskeyLoginResponse is the assertion of a passkey login, along
+// with the challenge it answers and the user handle stored with the passkey.
+type webauthnPasskeyLoginResponse struct {
+	webauthnSignResponse
+	Challenge  string `json:"challenge"`
+	UserHandle string `json:"userHandle"`
+}
+
+// passkeyChallengeCache holds the outstanding passkey login challenges,
+// which are not tied to a user or session. The zero value is ready to use.
+type passkeyChallengeCache struct {
+	mutex      sync.Mutex
+	challenges map[string]time.Time // Key: challenge, value: expiration.
+}
+
+// add records a new challenge, unless too many are outstanding.
+func (cache *passkeyChallengeCache) add(challenge []byte,
+	now time.Time) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.challenges == nil {
+		cache.challenges = make(map[string]time.Time)
+	}
+	if len(cache.challenges) >= maxPasskeyChallenges {
+		for key, expiresAt := range cache.challenges {
+			if now.After(expiresAt) {
+				delete(cache.challenges, key)
+			}
+		}
+		if len(cache.challenges) >= maxPasskeyChallenges {
+			return errTooManyPasskeyChallenges
+		}
+	}
+	cache.challenges[string(challenge)] = now.Add(webauthnTimeout)
+	return nil
+}
+
+// take removes challenge and returns true if it was outstanding, so that
+// each challenge can be used only once.
+func (cache *passkeyChallengeCache) take(challenge []byte,
+	now time.Time) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	expiresAt, ok := cache.challenges[string(challenge)]
+	if !ok {
+		return false
+	}
+	delete(cache.challenges, string(challenge))
+	return !now.After(expiresAt)
+}
+
+// getWebAuthnUserHandle returns the user handle of the user of profile,
+// creating it if needed, in which case the profile must be saved. It is
+// random, since it must not reveal the username, which could be guessed from
+// a hash.
+func getWebAuthnUserHandle(profile *userProfile) ([]byte, error) {
+	if len(profile.WebAuthnUserHandle) < 1 {
+		userHandle := make([]byte, webauthnUserHandleSize)
+		if _, err := rand.Read(userHandle); err != nil {
+			return nil, err
+		}
+		profile.WebAuthnUserHandle = userHandle
+	}
+	return profile.WebAuthnUserHandle, nil
+}
+
+func (state *RuntimeState) saveWebAuthnUserHandle(username string,
+	userHandle []byte) error {
+	_, err := state.db.Exec(setWebAuthnUserHandleStmt[state.dbType],
+		hex.EncodeToString(userHandle), username)
+	return err
+}
+
+// getUsernameForWebAuthnUserHandle returns the user a passkey was
+// registered for, or "" if the user handle is unknown.
+func (state *RuntimeState) getUsernameForWebAuthnUserHandle(
+	userHandle []byte) (string, error) {
+	var username string
+	err := state.db.QueryRow(getWebAuthnUserHandleStmt[state.dbType],
+		hex.EncodeToString(userHandle)).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return username, err
+}
+
+func (state *RuntimeState) webauthnPasskeyLoginRequest(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if !state.Config.U2F.PasskeyLogin {
+		http.Error(w, "passkey login is not enabled", http.StatusNotFound)
+		return
+	}
+	rp, err := state.getWebAuthnRelyingParty()
+	if err != nil {
+		logger.Printf("webauthn relying party error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	c, err := state.newU2FChallenge()
+	if err != nil {
+		logger.Printf("u2f.NewChallenge error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if err := state.passkeyChallenges.add(c.Challenge, time.Now()); err != nil {
+		requestLimitedCounter.WithLabelValues("passkey", "total").Inc()
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	// No credentials are listed, so that the authenticator offers the
+	// passkeys it holds for the relying party.
+	req := webauthnSignRequest{
+		Challenge:        encodeBase64URL(c.Challenge),
+		RelyingPartyID:   rp.ID,
+		Timeout:          int64(webauthnTimeout / time.Millisecond),
+		AllowCredentials: make([]webauthnCredentialDescriptor, 0),
+		UserVerification: "required",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(req); err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
+
+func (state *RuntimeState) webauthnPasskeyLoginResponse(
+	w http.ResponseWriter, r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if !state.Config.U2F.PasskeyLogin {
+		http.Error(w, "passkey login is not enabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	var loginResp webauthnPasskeyLoginResponse
+	if err := json.NewDecoder(r.Body).Decode(&loginResp); err != nil {
+		http.Error(w, "invalid response: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var assertion webauthn.Assertion
+	challenge, err := decodeBase64URL(loginResp.Challenge)
+	var credentialID, userHandle []byte
+	if err == nil {
+		credentialID, err = decodeBase64URL(loginResp.ID)
+	}
+	if err == nil {
+		userHandle, err = decodeBase64URL(loginResp.UserHandle)
+	}
+	if err == nil {
+		assertion.ClientDataJSON, err = decodeBase64URL(
+			loginResp.ClientDataJSON)
+	}
+	if err == nil {
+		assertion.AuthenticatorData, err = decodeBase64URL(
+			loginResp.AuthenticatorData)
+	}
+	if err == nil {
+		assertion.Signature, err = decodeBase64URL(loginResp.Signature)
+	}
+	if err != nil {
+		http.Error(w, "invalid response encoding", http.StatusBadRequest)
+		return
+	}
+	if !state.passkeyChallenges.take(challenge, time.Now()) {
+		http.Error(w, "challenge missing", http.StatusBadRequest)
+		return
+	}
+	username, err := state.getUsernameForWebAuthnUserHandle(userHandle)
+	if err != nil {
+		logger.Printf("error looking up passkey user handle: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if username == "" {
+		metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, false)
+		http.Error(w, "unknown credential", http.StatusUnauthorized)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(username)
+	if !state.checkHoneytoken(w, r, username, honeytokenStageSession) {
+		return
+	}
+	rp, err := state.getWebAuthnRelyingParty()
+	if err != nil {
+		logger.Printf("webauthn relying party error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	unlockProfile := state.lockUserProfile(username)
+	profile, ok, fromCache, err := state.LoadUserProfile(username)
+	if err != nil {
+		unlockProfile()
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	var device *u2fAuthData
+	if ok {
+		for _, data := range profile.U2fAuthData {
+			if data.Enabled && data.WebAuthn != nil &&
+				data.WebAuthn.Discoverable &&
+				bytes.Equal(data.WebAuthn.ID, credentialID) {
+				device = data
+				break
+			}
+		}
+	}
+	if device == nil {
+		unlockProfile()
+		metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, false)
+		http.Error(w, "unknown credential", http.StatusUnauthorized)
+		return
+	}
+	credential, _, err := device.webauthnCredential()
+	if err == nil {
+		device.Counter, err = rp.VerifyUserVerifiedAssertion(challenge,
+			credential, assertion)
+	}
+	if err != nil {
+		unlockProfile()
+		metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, false)
+		logger.Printf("passkey assertion error for %s: %v", username, err)
+		http.Error(w, "error verifying response", http.StatusUnauthorized)
+		return
+	}
+	device.LastUsedAt = time.Now()
+	device.LastUsedAddr = r.RemoteAddr
+	if !fromCache {
+		if err := state.SaveUserProfile(username, profile); err != nil {
+			// Not fatal: the authentication itself succeeded.
+			logger.Printf("Saving profile error: %v", err)
+		}
+	}
+	unlockProfile()
+	if !state.checkAccountStatus(w, r, username) {
+		return
+	}
+	metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, true)
+	_, err = state.setNewAuthCookie(w, username,
+		AuthTypePassword|AuthTypeU2F)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError,
+			"error internal")
+		return
+	}
+	logger.Debugf(1, "Valid passkey login for %s", username)
+	eventNotifier.PublishAuthEvent(eventmon.AuthTypeU2F, username)
+	eventNotifier.PublishWebLoginEvent(username)
+	w.Write([]byte("success"))
+}
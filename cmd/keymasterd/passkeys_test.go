@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+)
+
+// Authenticator data flags.
+const (
+	testFlagUserPresent  = 0x01
+	testFlagUserVerified = 0x04
+)
+
+func TestPasskeyChallengeCache(t *testing.T) {
+	var cache passkeyChallengeCache
+	now := time.Now()
+	if err := cache.add([]byte("first"), now); err != nil {
+		t.Fatal(err)
+	}
+	if !cache.take([]byte("first"), now) {
+		t.Fatal("challenge not found")
+	}
+	if cache.take([]byte("first"), now) {
+		t.Fatal("challenge used twice")
+	}
+	if err := cache.add([]byte("second"), now); err != nil {
+		t.Fatal(err)
+	}
+	if cache.take([]byte("second"), now.Add(webauthnTimeout+time.Second)) {
+		t.Fatal("expired challenge accepted")
+	}
+	for i := 0; i < maxPasskeyChallenges; i++ {
+		if err := cache.add([]byte{byte(i), byte(i >> 8)}, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := cache.add([]byte("full"), now)
+	if err != errTooManyPasskeyChallenges {
+		t.Fatalf("expected errTooManyPasskeyChallenges, got: %v", err)
+	}
+	if err := cache.add([]byte("full"),
+		now.Add(webauthnTimeout+time.Second)); err != nil {
+		t.Fatalf("expired challenges not removed: %v", err)
+	}
+}
+
+func makePasskeyLoginResponse(t *testing.T, privateKey *ecdsa.PrivateKey,
+	userHandle []byte, challenge string, flags byte) []byte {
+	clientDataJSON, err := json.Marshal(map[string]string{
+		"type":      "webauthn.get",
+		"challenge": challenge,
+		"origin":    defaultU2FAppID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpIDHash := sha256.Sum256([]byte("www.example.com"))
+	authenticatorData := append(rpIDHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(authenticatorData[33:], 1)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := sha256.Sum256(append(append([]byte(nil),
+		authenticatorData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, privateKey, signed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp webauthnPasskeyLoginResponse
+	resp.ID = encodeBase64URL([]byte("passkey"))
+	resp.ClientDataJSON = encodeBase64URL(clientDataJSON)
+	resp.AuthenticatorData = encodeBase64URL(authenticatorData)
+	resp.Signature = encodeBase64URL(signature)
+	resp.Challenge = challenge
+	resp.UserHandle = encodeBase64URL(userHandle)
+	body, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	tmpdir, err := ioutil.TempDir("", "keymasterd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	state.Config.Base.DataDirectory = tmpdir
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.U2F.AppID = defaultU2FAppID
+	if err := state.setupU2FIdentity(); err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := &userProfile{U2fAuthData: map[int64]*u2fAuthData{
+		1: {
+			Enabled: true,
+			WebAuthn: &webauthnCredentialData{
+				ID:           []byte("passkey"),
+				PublicKey:    publicKey,
+				Discoverable: true,
+			},
+		},
+	}}
+	userHandle, err := getWebAuthnUserHandle(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userHandle) != webauthnUserHandleSize {
+		t.Fatalf("unexpected user handle: %x", userHandle)
+	}
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+	err = state.saveWebAuthnUserHandle("username", userHandle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getChallenge := func(expectedCode int) string {
+		req := httptest.NewRequest("GET", webauthnPasskeyLoginRequestPath,
+			nil)
+		recorder := httptest.NewRecorder()
+		w := &instrumentedwriter.LoggingWriter{ResponseWriter: recorder}
+		state.webauthnPasskeyLoginRequest(w, req)
+		if recorder.Code != expectedCode {
+			t.Fatalf("expected: %d, got: %d", expectedCode, recorder.Code)
+		}
+		var options webauthnSignRequest
+		json.NewDecoder(recorder.Body).Decode(&options)
+		return options.Challenge
+	}
+	login := func(body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", webauthnPasskeyLoginResponsePath,
+			bytes.NewReader(body))
+		recorder := httptest.NewRecorder()
+		w := &instrumentedwriter.LoggingWriter{ResponseWriter: recorder}
+		state.webauthnPasskeyLoginResponse(w, req)
+		return recorder
+	}
+	getChallenge(http.StatusNotFound)
+	state.Config.U2F.PasskeyLogin = true
+	// The authenticator must verify the user.
+	body := makePasskeyLoginResponse(t, privateKey, userHandle,
+		getChallenge(http.StatusOK), testFlagUserPresent)
+	if recorder := login(body); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("accepted without user verification: %d", recorder.Code)
+	}
+	body = makePasskeyLoginResponse(t, privateKey, userHandle,
+		getChallenge(http.StatusOK), testFlagUserPresent|testFlagUserVerified)
+	recorder := login(body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("login failed: %d: %s", recorder.Code, recorder.Body)
+	}
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != authCookieName {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+	info, err := state.getAuthInfoFromAuthJWT(cookies[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Username != "username" ||
+		info.AuthType != AuthTypePassword|AuthTypeU2F {
+		t.Fatalf("unexpected auth info: %+v", info)
+	}
+	// Challenges cannot be replayed.
+	if recorder := login(body); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("replayed login accepted: %d", recorder.Code)
+	}
+	// Deleting the profile forgets the passkey.
+	if err := state.DeleteUserProfile("username"); err != nil {
+		t.Fatal(err)
+	}
+	username, err := state.getUsernameForWebAuthnUserHandle(userHandle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "" {
+		t.Fatalf("user handle of deleted profile found: %s", username)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/faultinjection"
+	"github.com/Cloud-Foundations/keymaster/lib/authenticators/okta"
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth"
+)
+
+// passwordBackendConfig selects a password backend registered with
+// pwauth.Register, typically one compiled in by a downstream build.
+type passwordBackendConfig struct {
+	Name    string            `yaml:"name"`
+	Options map[string]string `yaml:"options"`
+}
+
+// setupPasswordBackend creates the configured registered password backend,
+// if any. It is the only password authentication method when configured.
+func (state *RuntimeState) setupPasswordBackend() error {
+	backendConfig := state.Config.PasswordBackend
+	if backendConfig.Name == "" {
+		return nil
+	}
+	if state.passwordChecker != nil {
+		return errors.New(
+			"password_backend cannot be combined with other password backends")
+	}
+	passwordChecker, err := pwauth.New(backendConfig.Name,
+		backendConfig.Options, state.logger)
+	if err != nil {
+		return fmt.Errorf("password_backend: %s (registered: %v)", err,
+			pwauth.Registered())
+	}
+	state.passwordChecker = passwordChecker
+	state.logger.Debugf(1, "passwordChecker= %+v", state.passwordChecker)
+	return nil
+}
+
+// passwordCheckerUsesStorage returns true if the password checker should be
+// given the state as its storage once the database is available.
+func (state *RuntimeState) passwordCheckerUsesStorage() bool {
+	if state.Config.PasswordBackend.Name != "" {
+		return true
+	}
+	return state.Config.Ldap.enabled() && !state.Config.Ldap.DisablePasswordCache
+}
+
+// passwordServiceName returns the name the latency of the password checker
+// is reported under, or "" if it is not an external service.
+func (state *RuntimeState) passwordServiceName() string {
+	if name := state.Config.PasswordBackend.Name; name != "" {
+		return name
+	}
+	if state.Config.Ldap.enabled() {
+		return "ldap"
+	}
+	switch state.passwordChecker.(type) {
+	case *okta.PasswordAuthenticator:
+		return "okta-passwd"
+	}
+	return ""
+}
+
+// checkUserPassword checks the password of username with the configured
+// password checker. It returns false if there is no password checker.
+func (state *RuntimeState) checkUserPassword(username string, password string,
+	r *http.Request) (bool, error) {
+	if state.isHoneytoken(username) {
+		state.checkHoneytokenPassword(username, password, r)
+		return false, nil
+	}
+	clientType := getClientType(r)
+	if state.passwordChecker == nil {
+		metricLogAuthOperation(clientType, "password", false)
+		return false, nil
+	}
+	logger.Debugf(3, "checking auth with passwordChecker")
+	start := time.Now()
+	if state.Config.Ldap.enabled() {
+		if err := state.faultInjector.Inject(
+			faultinjection.TargetLDAP); err != nil {
+			return false, err
+		}
+	}
+	valid, err := state.passwordChecker.PasswordAuthenticate(username,
+		[]byte(password))
+	if err != nil {
+		return false, err
+	}
+	if service := state.passwordServiceName(); service != "" {
+		metricLogExternalServiceDuration(service, time.Since(start))
+	}
+	logger.Debugf(3, "pwdChecker output = %d", valid)
+	metricLogAuthOperation(clientType, "password", valid)
+	return valid, nil
+}
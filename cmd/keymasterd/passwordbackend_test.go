@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth"
+)
+
+func init() {
+	pwauth.Register("keymasterd-test",
+		func(options map[string]string, logger log.DebugLogger) (
+			pwauth.PasswordAuthenticator, error) {
+			return &testPasswordChanger{passwords: options}, nil
+		})
+}
+
+func TestSetupPasswordBackend(t *testing.T) {
+	state := &RuntimeState{logger: testlogger.New(t)}
+	if err := state.setupPasswordBackend(); err != nil {
+		t.Fatal(err)
+	}
+	if state.passwordChecker != nil {
+		t.Fatal("password checker created without configuration")
+	}
+	state.Config.PasswordBackend = passwordBackendConfig{
+		Name:    "keymasterd-test",
+		Options: map[string]string{"alice": "secret"},
+	}
+	if err := state.setupPasswordBackend(); err != nil {
+		t.Fatal(err)
+	}
+	if !state.passwordCheckerUsesStorage() {
+		t.Fatal("registered backend not given storage")
+	}
+	valid, err := state.checkUserPassword("alice", "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid {
+		t.Fatal("correct password rejected")
+	}
+	valid, err = state.checkUserPassword("alice", "wrong", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid {
+		t.Fatal("wrong password accepted")
+	}
+	// Only one password backend may be configured.
+	if err := state.setupPasswordBackend(); err == nil {
+		t.Fatal("combined password backends accepted")
+	}
+	state = &RuntimeState{logger: testlogger.New(t)}
+	state.Config.PasswordBackend.Name = "no-such-backend"
+	if err := state.setupPasswordBackend(); err == nil {
+		t.Fatal("unknown password backend accepted")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth"
+)
+
+const (
+	passwordPath    = "/password/"
+	passwordAPIPath = "/api/v0/password"
+
+	maxPasswordLength = 512
+)
+
+// passwordChangeConfig enables the page where users change their directory
+// password. Only password backends which can change passwords (LDAP) are
+// supported.
+type passwordChangeConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// getPasswordChanger returns the password backend if users may change their
+// password through it.
+func (state *RuntimeState) getPasswordChanger() (pwauth.PasswordChanger,
+	bool) {
+	if !state.Config.PasswordChange.Enabled {
+		return nil, false
+	}
+	changer, ok := state.passwordChecker.(pwauth.PasswordChanger)
+	return changer, ok
+}
+
+// passwordHandler renders the page where users change their own password.
+func (state *RuntimeState) passwordHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if _, ok := state.getPasswordChanger(); !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authData, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authData.Username)
+	state.writePasswordPage(w, r, authData, http.StatusOK, "", "")
+}
+
+func (state *RuntimeState) writePasswordPage(w http.ResponseWriter,
+	r *http.Request, authData *authInfo, code int, errorMessage,
+	successMessage string) {
+	language := state.getLanguage(w, r)
+	displayData := passwordPageTemplateData{
+		Title:          state.pageTitle(state.translate(language, "Password")),
+		AuthUsername:   authData.Username,
+		Language:       language,
+		ErrorMessage:   errorMessage,
+		SuccessMessage: successMessage,
+		SecondFactorRequired: authData.SessionID == "" ||
+			(authData.AuthType&secondFactorAuthTypes) == 0,
+	}
+	setSecurityHeaders(w)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	err := state.htmlTemplate.ExecuteTemplate(w, "passwordPage", displayData)
+	if err != nil {
+		logger.Printf("Failed to execute %v", err)
+	}
+}
+
+// passwordAPIHandler changes the password of the authenticated user. It
+// takes the form values: old_password, new_password and optionally
+// new_password_confirm. The session must have been authenticated with a
+// second factor, and the old password is checked by the directory.
+func (state *RuntimeState) passwordAPIHandler(w http.ResponseWriter,
+	r *http.Request) {
+	changer, ok := state.getPasswordChanger()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authData, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authData.Username)
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	writeError := func(code int, message string) {
+		if getPreferredAcceptType(r) == "text/html" {
+			state.writePasswordPage(w, r, authData, code, message, "")
+			return
+		}
+		state.writeFailureResponse(w, r, code, message)
+	}
+	if authData.SessionID == "" ||
+		(authData.AuthType&secondFactorAuthTypes) == 0 {
+		writeError(http.StatusForbidden,
+			"Second factor authentication required")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		logger.Println(err)
+		writeError(http.StatusBadRequest, "Error parsing form")
+		return
+	}
+	oldPassword := r.Form.Get("old_password")
+	newPassword := r.Form.Get("new_password")
+	if oldPassword == "" || newPassword == "" {
+		writeError(http.StatusBadRequest, "Missing password")
+		return
+	}
+	if len(newPassword) > maxPasswordLength {
+		writeError(http.StatusBadRequest, "New password too long")
+		return
+	}
+	if _, ok := r.Form["new_password_confirm"]; ok &&
+		r.Form.Get("new_password_confirm") != newPassword {
+		writeError(http.StatusBadRequest, "New passwords do not match")
+		return
+	}
+	if newPassword == oldPassword {
+		writeError(http.StatusBadRequest,
+			"New password must differ from the old password")
+		return
+	}
+	err = changer.ChangePassword(authData.Username, []byte(oldPassword),
+		[]byte(newPassword))
+	switch {
+	case err == nil:
+	case err == pwauth.ErrIncorrectPassword:
+		logger.Printf("password change for: %s failed: incorrect password",
+			authData.Username)
+		writeError(http.StatusForbidden, "Incorrect password")
+		return
+	case errors.Is(err, pwauth.ErrPasswordRejected):
+		logger.Printf("password change for: %s rejected: %s",
+			authData.Username, err)
+		writeError(http.StatusBadRequest,
+			"The new password does not meet the password policy")
+		return
+	default:
+		logger.Printf("password change for: %s failed: %s",
+			authData.Username, err)
+		writeError(http.StatusInternalServerError,
+			"Password could not be changed")
+		return
+	}
+	logger.Printf("changed password of: %s from: %s", authData.Username,
+		r.RemoteAddr)
+	state.notifyPasswordChanged(authData.Username, r.RemoteAddr)
+	if getPreferredAcceptType(r) == "text/html" {
+		state.writePasswordPage(w, r, authData, http.StatusOK, "",
+			"Your password has been changed.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
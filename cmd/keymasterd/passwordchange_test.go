@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth"
+	"github.com/Cloud-Foundations/keymaster/lib/simplestorage"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+)
+
+type testPasswordChanger struct {
+	passwords map[string]string
+}
+
+func (pc *testPasswordChanger) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return pc.passwords[username] == string(password), nil
+}
+
+func (pc *testPasswordChanger) UpdateStorage(
+	storage simplestorage.SimpleStore) error {
+	return nil
+}
+
+func (pc *testPasswordChanger) ChangePassword(username string,
+	oldPassword, newPassword []byte) error {
+	if pc.passwords[username] != string(oldPassword) {
+		return pwauth.ErrIncorrectPassword
+	}
+	if len(newPassword) < 8 {
+		return fmt.Errorf("%w: too short", pwauth.ErrPasswordRejected)
+	}
+	pc.passwords[username] = string(newPassword)
+	return nil
+}
+
+func TestPasswordAPIHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypePassword, proto.AuthTypeU2F}
+	newRequest := func(authType int, form url.Values) *http.Request {
+		cookieVal, err := state.setNewAuthCookie(nil, "username", authType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", passwordAPIPath,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		return req
+	}
+	goodForm := url.Values{
+		"old_password":         {"password"},
+		"new_password":         {"new password"},
+		"new_password_confirm": {"new password"},
+	}
+	// The htpasswd backend cannot change passwords.
+	state.Config.PasswordChange.Enabled = true
+	_, err = checkRequestHandlerCode(newRequest(AuthTypeU2F, goodForm),
+		state.passwordAPIHandler, http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changer := &testPasswordChanger{
+		passwords: map[string]string{"username": "password"}}
+	state.passwordChecker = changer
+	state.Config.PasswordChange.Enabled = false
+	_, err = checkRequestHandlerCode(newRequest(AuthTypeU2F, goodForm),
+		state.passwordAPIHandler, http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.PasswordChange.Enabled = true
+	// Password alone is not enough.
+	_, err = checkRequestHandlerCode(newRequest(AuthTypePassword, goodForm),
+		state.passwordAPIHandler, http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		form url.Values
+		code int
+	}{
+		{url.Values{"old_password": {"password"}}, http.StatusBadRequest},
+		{url.Values{"old_password": {"password"},
+			"new_password":         {"new password"},
+			"new_password_confirm": {"other password"}},
+			http.StatusBadRequest},
+		{url.Values{"old_password": {"wrong"},
+			"new_password": {"new password"}}, http.StatusForbidden},
+		{url.Values{"old_password": {"password"},
+			"new_password": {"short"}}, http.StatusBadRequest},
+	} {
+		_, err = checkRequestHandlerCode(newRequest(AuthTypeU2F, test.form),
+			state.passwordAPIHandler, test.code)
+		if err != nil {
+			t.Fatalf("form: %v: %s", test.form, err)
+		}
+	}
+	if changer.passwords["username"] != "password" {
+		t.Fatal("password changed by failed request")
+	}
+	_, err = checkRequestHandlerCode(newRequest(AuthTypeU2F, goodForm),
+		state.passwordAPIHandler, http.StatusNoContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changer.passwords["username"] != "new password" {
+		t.Fatal("password not changed")
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tstranex/u2f"
+)
+
+// storedUserProfile is the JSON form in which user profiles are stored. Every
+// field is mapped explicitly, so that changes to the u2f library types (which
+// used to be gob encoded as they were) cannot break stored profiles.
+type storedUserProfile struct {
+	SchemaVersion              uint32                        `json:"schema_version"`
+	U2FDevices                 map[int64]storedU2FDevice     `json:"u2f_devices,omitempty"`
+	RegistrationChallenge      *storedU2FChallenge           `json:"registration_challenge,omitempty"`
+	PendingTOTPSecret          [][]byte                      `json:"pending_totp_secret,omitempty"`
+	LastSuccessfulTOTPCounter  int64                         `json:"last_successful_totp_counter,omitempty"`
+	TOTPDevices                map[int64]storedTOTPDevice    `json:"totp_devices,omitempty"`
+	BootstrapOTP               storedBootstrapOTP            `json:"bootstrap_otp"`
+	UserHasRegistered2ndFactor bool                          `json:"user_has_registered_2nd_factor,omitempty"`
+	KnownCertSourceAddrs       map[string]time.Time          `json:"known_cert_source_addrs,omitempty"`
+	TrustedDevices             map[int64]storedTrustedDevice `json:"trusted_devices,omitempty"`
+	WebAuthnUserHandle         []byte                        `json:"webauthn_user_handle,omitempty"`
+}
+
+type storedU2FDevice struct {
+	Enabled          bool      `json:"enabled"`
+	CreatedAt        time.Time `json:"created_at"`
+	CreatorAddr      string    `json:"creator_addr"`
+	CreatorUserAgent string    `json:"creator_user_agent,omitempty"`
+	Counter          uint32    `json:"counter"`
+	Name             string    `json:"name"`
+	// Raw registration message from the token.
+	Registration      []byte    `json:"registration,omitempty"`
+	LastUsedAt        time.Time `json:"last_used_at"`
+	LastUsedAddr      string    `json:"last_used_addr,omitempty"`
+	WebAuthnID        []byte    `json:"webauthn_id,omitempty"`
+	WebAuthnPublicKey []byte    `json:"webauthn_public_key,omitempty"`
+	// A passkey, which may be used without a username.
+	WebAuthnDiscoverable bool `json:"webauthn_discoverable,omitempty"`
+}
+
+type storedU2FChallenge struct {
+	Challenge     []byte    `json:"challenge"`
+	Timestamp     time.Time `json:"timestamp"`
+	AppID         string    `json:"app_id"`
+	TrustedFacets []string  `json:"trusted_facets,omitempty"`
+}
+
+type storedTOTPDevice struct {
+	Enabled          bool      `json:"enabled"`
+	CreatedAt        time.Time `json:"created_at"`
+	Name             string    `json:"name"`
+	EncryptedSecret  [][]byte  `json:"encrypted_secret"`
+	TOTPType         int       `json:"totp_type"`
+	ValidatorAddr    string    `json:"validator_addr"`
+	CreatorUserAgent string    `json:"creator_user_agent,omitempty"`
+	LastUsedAt       time.Time `json:"last_used_at"`
+	LastUsedAddr     string    `json:"last_used_addr,omitempty"`
+}
+
+type storedBootstrapOTP struct {
+	ExpiresAt  time.Time `json:"expires_at"`
+	Sha512Hash []byte    `json:"sha512_hash,omitempty"`
+}
+
+type storedTrustedDevice struct {
+	CreatedAt        time.Time `json:"created_at"`
+	CreatorAddr      string    `json:"creator_addr"`
+	CreatorUserAgent string    `json:"creator_user_agent,omitempty"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	Name             string    `json:"name"`
+	LastUsedAt       time.Time `json:"last_used_at"`
+	LastUsedAddr     string    `json:"last_used_addr,omitempty"`
+}
+
+// isLegacyProfile returns true if data is a gob encoded profile. Stored JSON
+// profiles are always objects.
+func isLegacyProfile(data []byte) bool {
+	return len(data) > 0 && data[0] != '{'
+}
+
+// encodeUserProfile returns the stored form of profile.
+func encodeUserProfile(profile *userProfile) ([]byte, error) {
+	stored := storedUserProfile{
+		SchemaVersion:              profile.SchemaVersion,
+		LastSuccessfulTOTPCounter:  profile.LastSuccessfullTOTPCounter,
+		BootstrapOTP:               storedBootstrapOTP(profile.BootstrapOTP),
+		UserHasRegistered2ndFactor: profile.UserHasRegistered2ndFactor,
+		KnownCertSourceAddrs:       profile.KnownCertSourceAddrs,
+		WebAuthnUserHandle:         profile.WebAuthnUserHandle,
+	}
+	if profile.PendingTOTPSecret != nil {
+		stored.PendingTOTPSecret = *profile.PendingTOTPSecret
+	}
+	if challenge := profile.RegistrationChallenge; challenge != nil {
+		stored.RegistrationChallenge = &storedU2FChallenge{
+			Challenge:     challenge.Challenge,
+			Timestamp:     challenge.Timestamp,
+			AppID:         challenge.AppID,
+			TrustedFacets: challenge.TrustedFacets,
+		}
+	}
+	if len(profile.U2fAuthData) > 0 {
+		stored.U2FDevices = make(map[int64]storedU2FDevice,
+			len(profile.U2fAuthData))
+	}
+	for index, device := range profile.U2fAuthData {
+		storedDevice := storedU2FDevice{
+			Enabled:          device.Enabled,
+			CreatedAt:        device.CreatedAt,
+			CreatorAddr:      device.CreatorAddr,
+			CreatorUserAgent: device.CreatorUserAgent,
+			Counter:          device.Counter,
+			Name:             device.Name,
+			LastUsedAt:       device.LastUsedAt,
+			LastUsedAddr:     device.LastUsedAddr,
+		}
+		if device.Registration != nil {
+			raw, err := device.Registration.MarshalBinary()
+			if err != nil {
+				return nil, fmt.Errorf("U2F device %d: %s", index, err)
+			}
+			storedDevice.Registration = raw
+		}
+		if device.WebAuthn != nil {
+			storedDevice.WebAuthnID = device.WebAuthn.ID
+			storedDevice.WebAuthnPublicKey = device.WebAuthn.PublicKey
+			storedDevice.WebAuthnDiscoverable = device.WebAuthn.Discoverable
+		}
+		stored.U2FDevices[index] = storedDevice
+	}
+	if len(profile.TOTPAuthData) > 0 {
+		stored.TOTPDevices = make(map[int64]storedTOTPDevice,
+			len(profile.TOTPAuthData))
+	}
+	for index, device := range profile.TOTPAuthData {
+		stored.TOTPDevices[index] = storedTOTPDevice(*device)
+	}
+	if len(profile.TrustedDevices) > 0 {
+		stored.TrustedDevices = make(map[int64]storedTrustedDevice,
+			len(profile.TrustedDevices))
+	}
+	for index, device := range profile.TrustedDevices {
+		stored.TrustedDevices[index] = storedTrustedDevice(*device)
+	}
+	return json.Marshal(stored)
+}
+
+// decodeUserProfile decodes a stored profile into profile, which may already
+// hold defaults. Legacy gob encoded profiles are also accepted.
+func decodeUserProfile(data []byte, profile *userProfile) error {
+	if isLegacyProfile(data) {
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(profile)
+	}
+	var stored storedUserProfile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		// The length prefix of a gob stream may happen to be a '{'.
+		if gob.NewDecoder(bytes.NewReader(data)).Decode(profile) == nil {
+			return nil
+		}
+		return err
+	}
+	return stored.decode(profile)
+}
+
+func (stored *storedUserProfile) decode(profile *userProfile) error {
+	profile.SchemaVersion = stored.SchemaVersion
+	profile.LastSuccessfullTOTPCounter = stored.LastSuccessfulTOTPCounter
+	profile.BootstrapOTP = bootstrapOTPData(stored.BootstrapOTP)
+	profile.UserHasRegistered2ndFactor = stored.UserHasRegistered2ndFactor
+	profile.KnownCertSourceAddrs = stored.KnownCertSourceAddrs
+	profile.WebAuthnUserHandle = stored.WebAuthnUserHandle
+	if stored.PendingTOTPSecret != nil {
+		profile.PendingTOTPSecret = &stored.PendingTOTPSecret
+	}
+	if challenge := stored.RegistrationChallenge; challenge != nil {
+		profile.RegistrationChallenge = &u2f.Challenge{
+			Challenge:     challenge.Challenge,
+			Timestamp:     challenge.Timestamp,
+			AppID:         challenge.AppID,
+			TrustedFacets: challenge.TrustedFacets,
+		}
+	}
+	profile.U2fAuthData = make(map[int64]*u2fAuthData, len(stored.U2FDevices))
+	for index, device := range stored.U2FDevices {
+		data := &u2fAuthData{
+			Enabled:          device.Enabled,
+			CreatedAt:        device.CreatedAt,
+			CreatorAddr:      device.CreatorAddr,
+			CreatorUserAgent: device.CreatorUserAgent,
+			Counter:          device.Counter,
+			Name:             device.Name,
+			LastUsedAt:       device.LastUsedAt,
+			LastUsedAddr:     device.LastUsedAddr,
+		}
+		if len(device.Registration) > 0 {
+			data.Registration = new(u2f.Registration)
+			err := data.Registration.UnmarshalBinary(device.Registration)
+			if err != nil {
+				return fmt.Errorf("U2F device %d: %s", index, err)
+			}
+		}
+		if len(device.WebAuthnID) > 0 {
+			data.WebAuthn = &webauthnCredentialData{
+				ID:           device.WebAuthnID,
+				PublicKey:    device.WebAuthnPublicKey,
+				Discoverable: device.WebAuthnDiscoverable,
+			}
+		}
+		profile.U2fAuthData[index] = data
+	}
+	profile.TOTPAuthData = make(map[int64]*totpAuthData,
+		len(stored.TOTPDevices))
+	for index, device := range stored.TOTPDevices {
+		data := totpAuthData(device)
+		profile.TOTPAuthData[index] = &data
+	}
+	if len(stored.TrustedDevices) > 0 {
+		profile.TrustedDevices = make(map[int64]*trustedDeviceData,
+			len(stored.TrustedDevices))
+	}
+	for index, device := range stored.TrustedDevices {
+		data := trustedDeviceData(device)
+		profile.TrustedDevices[index] = &data
+	}
+	return nil
+}
+
+var convertLegacyProfileStmt = map[string]string{
+	"sqlite":   "update user_profile set profile_data = ? where username = ? and profile_data = ?",
+	"postgres": "update user_profile set profile_data = $1 where username = $2 and profile_data = $3",
+}
+
+// convertLegacyProfiles rewrites the gob encoded profiles in the database in
+// the JSON format. A profile which changes while it is converted is left for
+// the next startup. Failures are logged, since legacy profiles can still be
+// loaded.
+func (state *RuntimeState) convertLegacyProfiles() {
+	rows, err := state.db.Query(
+		"select username, profile_data from user_profile")
+	if err != nil {
+		logger.Printf("error reading profiles to convert: %s", err)
+		return
+	}
+	legacyProfiles := make(map[string][]byte)
+	for rows.Next() {
+		var username string
+		var data []byte
+		if err := rows.Scan(&username, &data); err != nil {
+			logger.Printf("error reading profiles to convert: %s", err)
+			rows.Close()
+			return
+		}
+		if isLegacyProfile(data) {
+			legacyProfiles[username] = data
+		}
+	}
+	rows.Close()
+	var numConverted int
+	for username, data := range legacyProfiles {
+		var profile userProfile
+		if err := decodeUserProfile(data, &profile); err != nil {
+			logger.Printf("cannot decode legacy profile of %s: %s",
+				username, err)
+			continue
+		}
+		newData, err := encodeUserProfile(&profile)
+		if err != nil {
+			logger.Printf("cannot encode profile of %s: %s", username, err)
+			continue
+		}
+		_, err = state.db.Exec(convertLegacyProfileStmt[state.dbType],
+			newData, username, data)
+		if err != nil {
+			logger.Printf("error converting profile of %s: %s", username, err)
+			continue
+		}
+		numConverted++
+	}
+	if len(legacyProfiles) > 0 {
+		logger.Printf("converted %d of %d legacy profiles to JSON",
+			numConverted, len(legacyProfiles))
+	}
+}
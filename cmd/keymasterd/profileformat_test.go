@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func makeTestProfile() *userProfile {
+	now := time.Unix(1700000000, 0).UTC()
+	secret := [][]byte{[]byte("pending")}
+	return &userProfile{
+		U2fAuthData: map[int64]*u2fAuthData{
+			1: {
+				Enabled:     true,
+				CreatedAt:   now,
+				CreatorAddr: "10.0.0.1",
+				Name:        "phone",
+				WebAuthn: &webauthnCredentialData{
+					ID:           []byte("credential"),
+					PublicKey:    []byte("public key"),
+					Discoverable: true,
+				},
+			},
+		},
+		PendingTOTPSecret:          &secret,
+		LastSuccessfullTOTPCounter: 42,
+		TOTPAuthData: map[int64]*totpAuthData{
+			2: {
+				Enabled:         true,
+				CreatedAt:       now,
+				Name:            "authenticator",
+				EncryptedSecret: [][]byte{[]byte("encrypted")},
+				LastUsedAt:      now,
+			},
+		},
+		BootstrapOTP: bootstrapOTPData{
+			ExpiresAt:  now,
+			Sha512Hash: []byte("hash"),
+		},
+		UserHasRegistered2ndFactor: true,
+		KnownCertSourceAddrs:       map[string]time.Time{"10.0.0.1": now},
+		TrustedDevices: map[int64]*trustedDeviceData{
+			3: {CreatedAt: now, ExpiresAt: now, Name: "laptop"},
+		},
+		WebAuthnUserHandle: []byte("user handle"),
+		SchemaVersion:      userProfileSchemaVersion,
+	}
+}
+
+func TestUserProfileFormat(t *testing.T) {
+	profile := makeTestProfile()
+	data, err := encodeUserProfile(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isLegacyProfile(data) {
+		t.Fatalf("encoded profile is not JSON: %s", data)
+	}
+	var decoded userProfile
+	if err := decodeUserProfile(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, profile) {
+		t.Fatalf("round trip changed profile:\n%+v\n%+v", decoded, *profile)
+	}
+	var gobBuffer bytes.Buffer
+	if err := gob.NewEncoder(&gobBuffer).Encode(profile); err != nil {
+		t.Fatal(err)
+	}
+	if !isLegacyProfile(gobBuffer.Bytes()) {
+		t.Fatal("gob profile not detected as legacy")
+	}
+	decoded = userProfile{}
+	if err := decodeUserProfile(gobBuffer.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, profile) {
+		t.Fatalf("legacy profile changed:\n%+v\n%+v", decoded, *profile)
+	}
+}
+
+func TestConvertLegacyProfiles(t *testing.T) {
+	state, tmpdir, err := newTestingState(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	profile := makeTestProfile()
+	var gobBuffer bytes.Buffer
+	if err := gob.NewEncoder(&gobBuffer).Encode(profile); err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec(saveUserProfileStmt[state.dbType], "username",
+		gobBuffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.convertLegacyProfiles()
+	var data []byte
+	err = state.db.QueryRow(loadUserProfileStmt[state.dbType],
+		"username").Scan(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isLegacyProfile(data) {
+		t.Fatal("profile not converted")
+	}
+	loaded, ok, _, err := state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || !reflect.DeepEqual(loaded, profile) {
+		t.Fatalf("converted profile changed:\n%+v\n%+v", *loaded, *profile)
+	}
+}
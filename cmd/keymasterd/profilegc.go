@@ -0,0 +1,371 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/authutil"
+)
+
+const (
+	profileGCPath = "/admin/profileGC"
+
+	defaultProfileGCInterval = 24 * time.Hour
+	userSeenUpdateInterval   = time.Hour
+)
+
+// The only attribute "1.1" means no attributes: the search is only an
+// existence check.
+var ldapNoAttributes = []string{"1.1"}
+
+// profileGCConfig enables removing the profiles of users who have not
+// logged in or been issued a certificate for MaxIdle, or who are no longer
+// in any LDAP userinfo source.
+type profileGCConfig struct {
+	MaxIdle            time.Duration `yaml:"max_idle"` // 0: keep idle profiles.
+	RemoveMissingUsers bool          `yaml:"remove_missing_users"`
+	ArchiveDirectory   string        `yaml:"archive_directory"`
+	Interval           time.Duration `yaml:"interval"` // Default: 24h.
+	DryRun             bool          `yaml:"dry_run"`
+}
+
+// profileGCResult lists the profiles which were (or, for a dry run, would
+// be) removed.
+type profileGCResult struct {
+	DryRun       bool     `json:"dry_run"`
+	Idle         []string `json:"idle"`
+	MissingUsers []string `json:"missing_users"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// userSeenCache limits how often the last seen time of a user is written.
+// The zero value is ready to use.
+type userSeenCache struct {
+	mutex    sync.Mutex
+	recorded map[string]time.Time
+}
+
+var getUserLastSeenStmt = map[string]string{
+	"sqlite":   "select username, last_seen_epoch from user_last_seen",
+	"postgres": "select username, last_seen_epoch from user_last_seen",
+}
+
+var setUserLastSeenStmt = map[string]string{
+	"sqlite":   "insert or replace into user_last_seen(username, last_seen_epoch) values(?, ?)",
+	"postgres": "insert into user_last_seen(username, last_seen_epoch) values($1, $2) on CONFLICT(username) DO UPDATE set last_seen_epoch = excluded.last_seen_epoch",
+}
+
+var deleteUserLastSeenStmt = map[string]string{
+	"sqlite":   "delete from user_last_seen where username = ?",
+	"postgres": "delete from user_last_seen where username = $1",
+}
+
+func (config *profileGCConfig) enabled() bool {
+	return config.MaxIdle > 0 || config.RemoveMissingUsers
+}
+
+func (config *profileGCConfig) getInterval() time.Duration {
+	if config.Interval > 0 {
+		return config.Interval
+	}
+	return defaultProfileGCInterval
+}
+
+func (config *profileGCConfig) check() error {
+	if config.MaxIdle < 0 {
+		return errors.New("profile_gc: negative max_idle")
+	}
+	if config.Interval < 0 {
+		return errors.New("profile_gc: negative interval")
+	}
+	if config.ArchiveDirectory != "" {
+		fi, err := os.Stat(config.ArchiveDirectory)
+		if err != nil {
+			return fmt.Errorf("profile_gc: %s", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("profile_gc: %s is not a directory",
+				config.ArchiveDirectory)
+		}
+	}
+	return nil
+}
+
+// shouldRecord returns true if the last seen time of username was not
+// written recently, and notes that it is about to be.
+func (cache *userSeenCache) shouldRecord(username string,
+	now time.Time) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.recorded == nil {
+		cache.recorded = make(map[string]time.Time)
+	}
+	if last, ok := cache.recorded[username]; ok &&
+		now.Sub(last) < userSeenUpdateInterval {
+		return false
+	}
+	for name, last := range cache.recorded {
+		if now.Sub(last) >= userSeenUpdateInterval {
+			delete(cache.recorded, name)
+		}
+	}
+	cache.recorded[username] = now
+	return true
+}
+
+func (cache *userSeenCache) forget(username string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	delete(cache.recorded, username)
+}
+
+// recordUserSeen notes that username logged in or was issued a certificate.
+func (state *RuntimeState) recordUserSeen(username string) {
+	if state.db == nil {
+		return
+	}
+	now := time.Now()
+	if !state.userSeen.shouldRecord(username, now) {
+		return
+	}
+	_, err := state.db.Exec(setUserLastSeenStmt[state.dbType], username,
+		now.Unix())
+	if err != nil {
+		state.userSeen.forget(username)
+		logger.Printf("error recording last seen time of %s: %s",
+			username, err)
+	}
+}
+
+func (state *RuntimeState) getUsersLastSeen() (map[string]time.Time, error) {
+	rows, err := state.db.Query(getUserLastSeenStmt[state.dbType])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lastSeen := make(map[string]time.Time)
+	for rows.Next() {
+		var username string
+		var epoch int64
+		if err := rows.Scan(&username, &epoch); err != nil {
+			return nil, err
+		}
+		lastSeen[username] = time.Unix(epoch, 0)
+	}
+	return lastSeen, rows.Err()
+}
+
+func (state *RuntimeState) getProfileUsernames() ([]string, error) {
+	rows, err := state.db.Query(getUsersStmt[state.dbType])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var usernames []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames, rows.Err()
+}
+
+// isMissingFromDirectory returns true only if every LDAP userinfo source
+// which could hold username answered that it does not. Users are never
+// considered missing if there are no sources or one cannot be reached.
+func (state *RuntimeState) isMissingFromDirectory(username string) (
+	bool, error) {
+	queries := state.getLdapUserInfoQueries(username)
+	if len(queries) < 1 {
+		return false, nil
+	}
+	for _, query := range queries {
+		_, err := state.getLdapAttributesFromSource(query.source,
+			query.username, ldapNoAttributes)
+		if err == authutil.ErrLDAPUserNotFound {
+			continue
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// archiveUserProfile writes the stored profile of username into the archive
+// directory, if configured.
+func (state *RuntimeState) archiveUserProfile(username string,
+	now time.Time) error {
+	directory := state.Config.ProfileGC.ArchiveDirectory
+	if directory == "" {
+		return nil
+	}
+	var profileBytes []byte
+	err := state.db.QueryRow(loadUserProfileStmt[state.dbType],
+		username).Scan(&profileBytes)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(directory, fmt.Sprintf("%s-%d.profile",
+		url.PathEscape(username), now.Unix()))
+	return os.WriteFile(filename, profileBytes, 0600)
+}
+
+func (state *RuntimeState) removeStaleProfile(username string,
+	now time.Time) error {
+	unlock := state.lockUserProfile(username)
+	defer unlock()
+	if err := state.archiveUserProfile(username, now); err != nil {
+		return fmt.Errorf("cannot archive profile: %s", err)
+	}
+	if err := state.DeleteUserProfile(username); err != nil {
+		return err
+	}
+	_, err := state.db.Exec(deleteUserLastSeenStmt[state.dbType], username)
+	state.userSeen.forget(username)
+	return err
+}
+
+// collectStaleProfiles removes the profiles of idle and missing users.
+// Profiles which were never seen are given the full idle period, starting
+// now, since they predate last seen tracking.
+func (state *RuntimeState) collectStaleProfiles(dryRun bool) (
+	*profileGCResult, error) {
+	config := state.Config.ProfileGC
+	usernames, err := state.getProfileUsernames()
+	if err != nil {
+		return nil, err
+	}
+	lastSeen, err := state.getUsersLastSeen()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	result := &profileGCResult{
+		DryRun:       dryRun,
+		Idle:         make([]string, 0),
+		MissingUsers: make([]string, 0),
+	}
+	for _, username := range usernames {
+		seen, ok := lastSeen[username]
+		if !ok && !dryRun {
+			_, err := state.db.Exec(setUserLastSeenStmt[state.dbType],
+				username, now.Unix())
+			if err != nil {
+				return nil, err
+			}
+		}
+		var stale *[]string
+		if config.MaxIdle > 0 && ok && now.Sub(seen) > config.MaxIdle {
+			stale = &result.Idle
+		} else if config.RemoveMissingUsers {
+			missing, err := state.isMissingFromDirectory(username)
+			if err != nil {
+				logger.Debugf(1, "cannot look up %s in directory: %s",
+					username, err)
+			} else if missing {
+				stale = &result.MissingUsers
+			}
+		}
+		if stale == nil {
+			continue
+		}
+		if !dryRun {
+			if err := state.removeStaleProfile(username, now); err != nil {
+				result.Errors = append(result.Errors,
+					fmt.Sprintf("%s: %s", username, err))
+				continue
+			}
+		}
+		*stale = append(*stale, username)
+	}
+	return result, nil
+}
+
+func (state *RuntimeState) runProfileGC(dryRun bool) (*profileGCResult,
+	error) {
+	result, err := state.collectStaleProfiles(dryRun)
+	if err != nil {
+		return nil, err
+	}
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	for _, username := range result.Idle {
+		logger.Printf("profile GC: %s idle profile of: %s", verb, username)
+	}
+	for _, username := range result.MissingUsers {
+		logger.Printf("profile GC: %s profile of missing user: %s",
+			verb, username)
+	}
+	for _, message := range result.Errors {
+		logger.Printf("profile GC: error removing profile: %s", message)
+	}
+	return result, nil
+}
+
+func (state *RuntimeState) profileGCLoop() {
+	config := state.Config.ProfileGC
+	for {
+		time.Sleep(config.getInterval())
+		if _, err := state.runProfileGC(config.DryRun); err != nil {
+			logger.Printf("error collecting stale profiles: %s", err)
+		}
+	}
+}
+
+// profileGCHandler lists the profiles which would be removed (GET) or
+// removes them (POST, unless dry_run=true).
+func (state *RuntimeState) profileGCHandler(w http.ResponseWriter,
+	r *http.Request) {
+	failure, authUser := state.sendFailureToClientIfNonAdmin(w, r)
+	if failure {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		state.logger.Printf("error parsing err=%s", err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "")
+		return
+	}
+	var dryRun bool
+	switch r.Method {
+	case "GET":
+		dryRun = true
+	case "POST":
+		dryRun = r.Form.Get("dry_run") == "true"
+	default:
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if !state.Config.ProfileGC.enabled() {
+		state.writeFailureResponse(w, r, http.StatusPreconditionFailed,
+			"Profile garbage collection is not configured")
+		return
+	}
+	if !dryRun {
+		state.logger.Printf("%s: started profile garbage collection\n",
+			authUser)
+	}
+	result, err := state.runProfileGC(dryRun)
+	if err != nil {
+		state.logger.Printf("error collecting stale profiles: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		state.logger.Printf("json encoding error: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUserSeenCache(t *testing.T) {
+	var cache userSeenCache
+	now := time.Now()
+	if !cache.shouldRecord("alice", now) {
+		t.Fatal("first sighting not recorded")
+	}
+	if cache.shouldRecord("alice", now.Add(time.Minute)) {
+		t.Fatal("recent sighting recorded again")
+	}
+	if !cache.shouldRecord("bob", now) {
+		t.Fatal("other user not recorded")
+	}
+	if !cache.shouldRecord("alice", now.Add(userSeenUpdateInterval)) {
+		t.Fatal("old sighting not recorded again")
+	}
+	cache.forget("alice")
+	if !cache.shouldRecord("alice", now.Add(userSeenUpdateInterval)) {
+		t.Fatal("forgotten sighting not recorded again")
+	}
+}
+
+func TestCollectStaleProfiles(t *testing.T) {
+	state, tmpdir, err := newTestingState(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	archiveDir := filepath.Join(tmpdir, "archive")
+	if err := os.Mkdir(archiveDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.ProfileGC = profileGCConfig{
+		MaxIdle:          30 * 24 * time.Hour,
+		ArchiveDirectory: archiveDir,
+	}
+	if err := state.Config.ProfileGC.check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"active", "idle", "new"} {
+		profile := &userProfile{}
+		if err := state.SaveUserProfile(username, profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	for username, seen := range map[string]time.Time{
+		"active": now.Add(-time.Hour),
+		"idle":   now.Add(-31 * 24 * time.Hour),
+	} {
+		_, err := state.db.Exec(setUserLastSeenStmt[state.dbType], username,
+			seen.Unix())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := state.collectStaleProfiles(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Idle) != 1 || result.Idle[0] != "idle" {
+		t.Fatalf("unexpected dry run result: %+v", result)
+	}
+	if _, ok, _, err := state.LoadUserProfile("idle"); err != nil || !ok {
+		t.Fatalf("profile removed by dry run: %v", err)
+	}
+	result, err = state.collectStaleProfiles(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Idle) != 1 || len(result.Errors) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	usernames, err := state.getProfileUsernames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usernames) != 2 || usernames[0] != "active" ||
+		usernames[1] != "new" {
+		t.Fatalf("unexpected remaining profiles: %v", usernames)
+	}
+	archived, err := filepath.Glob(filepath.Join(archiveDir, "idle-*.profile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 1 {
+		t.Fatalf("unexpected archived profiles: %v", archived)
+	}
+	// Profiles which predate last seen tracking get the full idle period.
+	lastSeen, err := state.getUsersLastSeen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lastSeen["idle"]; ok {
+		t.Fatal("last seen time of removed profile kept")
+	}
+	if seen, ok := lastSeen["new"]; !ok || time.Since(seen) > time.Minute {
+		t.Fatalf("unexpected last seen time of new profile: %v", seen)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// userProfileSchemaVersion is the version of the userProfile layout written
+// by this keymasterd. Increment it and append to userProfileMigrations when
+// a change to the layout needs stored profiles to be converted.
+const userProfileSchemaVersion = 1
+
+var errProfileTooNew = errors.New(
+	"profile written by a newer keymasterd, not saving")
+
+// userProfileMigrations[i] converts a profile from version i to version i+1.
+var userProfileMigrations = []func(profile *userProfile) error{
+	migrateUserProfileV0,
+}
+
+// migrateUserProfile converts profile to the current schema version. Profiles
+// from a newer version are loaded as they are, since gob skips unknown
+// fields, but are marked read-only so that saving them cannot discard the
+// data this version does not know about.
+func migrateUserProfile(profile *userProfile) error {
+	if profile.SchemaVersion > userProfileSchemaVersion {
+		profile.readOnly = true
+		return nil
+	}
+	for profile.SchemaVersion < userProfileSchemaVersion {
+		err := userProfileMigrations[profile.SchemaVersion](profile)
+		if err != nil {
+			return fmt.Errorf("migrating profile from version %d: %s",
+				profile.SchemaVersion, err)
+		}
+		profile.SchemaVersion++
+	}
+	return nil
+}
+
+// migrateUserProfileV0 handles profiles saved before they were versioned:
+// maps which were added later may be missing, and profiles with devices
+// registered before UserHasRegistered2ndFactor existed do not have it set.
+func migrateUserProfileV0(profile *userProfile) error {
+	if profile.U2fAuthData == nil {
+		profile.U2fAuthData = make(map[int64]*u2fAuthData)
+	}
+	if profile.TOTPAuthData == nil {
+		profile.TOTPAuthData = make(map[int64]*totpAuthData)
+	}
+	if len(profile.U2fAuthData) > 0 || len(profile.TOTPAuthData) > 0 {
+		profile.UserHasRegistered2ndFactor = true
+	}
+	return nil
+}
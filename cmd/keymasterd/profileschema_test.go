@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMigrateUserProfile(t *testing.T) {
+	profile := &userProfile{
+		TOTPAuthData: map[int64]*totpAuthData{1: {Enabled: true}},
+	}
+	if err := migrateUserProfile(profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.SchemaVersion != userProfileSchemaVersion ||
+		profile.U2fAuthData == nil || !profile.UserHasRegistered2ndFactor ||
+		profile.readOnly {
+		t.Fatalf("unexpected migrated profile: %+v", profile)
+	}
+	profile = &userProfile{SchemaVersion: userProfileSchemaVersion + 1}
+	if err := migrateUserProfile(profile); err != nil {
+		t.Fatal(err)
+	}
+	if !profile.readOnly {
+		t.Fatal("profile from newer version not read-only")
+	}
+}
+
+func TestUserProfileSchemaVersion(t *testing.T) {
+	state, tmpdir, err := newTestingState(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	// Profiles saved before versioning have no version.
+	profile := &userProfile{
+		TOTPAuthData: map[int64]*totpAuthData{1: {Enabled: true}},
+	}
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.SchemaVersion != userProfileSchemaVersion {
+		t.Fatalf("saved with version: %d", profile.SchemaVersion)
+	}
+	// Write a profile as a newer keymasterd would.
+	profile.SchemaVersion = userProfileSchemaVersion + 1
+	profileData, err := encodeUserProfile(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.db.Exec(saveUserProfileStmt[state.dbType], "username",
+		profileData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok, _, err := state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || !loaded.readOnly || len(loaded.TOTPAuthData) != 1 {
+		t.Fatalf("unexpected profile: %+v", loaded)
+	}
+	if err := state.SaveUserProfile("username", loaded); err != errProfileTooNew {
+		t.Fatalf("expected errProfileTooNew, got: %v", err)
+	}
+}
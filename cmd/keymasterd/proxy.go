@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/proxyprotocol"
+)
+
+const proxyProtocolHeaderTimeout = 5 * time.Second
+
+func (state *RuntimeState) parseTrustedProxies() error {
+	for _, cidr := range state.Config.Base.TrustedProxies {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("bad trusted_proxies entry: %s", err)
+		}
+		state.trustedProxies = append(state.trustedProxies, ipNet)
+	}
+	if state.Config.Base.EnableProxyProtocol && len(state.trustedProxies) < 1 {
+		return fmt.Errorf("enable_proxy_protocol requires trusted_proxies")
+	}
+	return nil
+}
+
+func (state *RuntimeState) isTrustedProxy(ip net.IP) bool {
+	for _, ipNet := range state.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// getForwardedClientIP walks the X-Forwarded-For chain from the nearest hop,
+// skipping trusted proxies. The first address not belonging to a trusted
+// proxy is the client; anything before it may have been forged.
+func (state *RuntimeState) getForwardedClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	peerIP := net.ParseIP(host)
+	if peerIP == nil || !state.isTrustedProxy(peerIP) {
+		return nil
+	}
+	var hops []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	var clientIP net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		clientIP = ip
+		if !state.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return clientIP
+}
+
+// newForwardedForHandler returns a handler which replaces the remote address
+// of requests relayed by trusted proxies with the address of the client.
+func (state *RuntimeState) newForwardedForHandler(
+	handler http.Handler) http.Handler {
+	if len(state.trustedProxies) < 1 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if clientIP := state.getForwardedClientIP(r); clientIP != nil {
+			r.RemoteAddr = net.JoinHostPort(clientIP.String(), "0")
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// listenAndServe is like http.Server.ListenAndServe{,TLS} except that the
+// PROXY protocol is accepted from trusted proxies when enabled.
+func (state *RuntimeState) listenAndServe(server *http.Server,
+	useTLS bool) error {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	if state.Config.Base.EnableProxyProtocol {
+		listener = proxyprotocol.NewListener(listener, state.isTrustedProxy,
+			proxyProtocolHeaderTimeout)
+	}
+	if !useTLS {
+		return server.Serve(listener)
+	}
+	state.configureHTTP2(server)
+	return server.ServeTLS(listener, "", "")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetForwardedClientIP(t *testing.T) {
+	state := RuntimeState{}
+	state.Config.Base.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1"}
+	if err := state.parseTrustedProxies(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remoteAddr    string
+		forwardedFor  []string
+		expectedValue string
+	}{
+		// Untrusted peers cannot set the client address.
+		{"203.0.113.5:1234", []string{"198.51.100.1"}, ""},
+		{"10.1.2.3:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		// A forged address to the left of the real client is ignored.
+		{"10.1.2.3:1234", []string{"6.6.6.6, 198.51.100.1, 10.9.9.9"},
+			"198.51.100.1"},
+		{"192.168.1.1:1234", []string{"6.6.6.6", "198.51.100.1"},
+			"198.51.100.1"},
+		{"10.1.2.3:1234", nil, ""},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = test.remoteAddr
+		for _, value := range test.forwardedFor {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		clientIP := state.getForwardedClientIP(req)
+		value := ""
+		if clientIP != nil {
+			value = clientIP.String()
+		}
+		if value != test.expectedValue {
+			t.Errorf("%s %v: expected \"%s\", got \"%s\"", test.remoteAddr,
+				test.forwardedFor, test.expectedValue, value)
+		}
+	}
+}
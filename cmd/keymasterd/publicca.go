@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	caTypeSSH  = "ssh"
+	caTypeX509 = "x509"
+)
+
+// caInfo describes a CA key in the CA metadata document.
+type caInfo struct {
+	Type        string     `json:"type"`
+	Algorithm   string     `json:"algorithm"`
+	Fingerprint string     `json:"fingerprint"`
+	PublicKey   string     `json:"public_key"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	Current     bool       `json:"current"`
+	// The CA signed by previous CAs, in PEM format.
+	CrossSigned string `json:"cross_signed,omitempty"`
+}
+
+type caMetadata struct {
+	Issuer                 string   `json:"issuer"`
+	CertificateAuthorities []caInfo `json:"certificate_authorities"`
+}
+
+// sshCAPublicKey is an SSH CA key. Keys which are not current are only
+// trusted for verification, typically during a key rotation.
+type sshCAPublicKey struct {
+	key     ssh.PublicKey
+	current bool
+}
+
+// getSSHCAPublicKeys returns the keys used to sign SSH certificates followed
+// by the other trusted keymaster keys.
+func (state *RuntimeState) getSSHCAPublicKeys() ([]sshCAPublicKey, error) {
+	var keys []sshCAPublicKey
+	seen := make(map[string]struct{})
+	addKey := func(publicKey crypto.PublicKey, current bool) error {
+		sshKey, err := ssh.NewPublicKey(publicKey)
+		if err != nil {
+			return err
+		}
+		fingerprint := ssh.FingerprintSHA256(sshKey)
+		if _, ok := seen[fingerprint]; ok {
+			return nil
+		}
+		seen[fingerprint] = struct{}{}
+		keys = append(keys, sshCAPublicKey{key: sshKey, current: current})
+		return nil
+	}
+	state.Mutex.RLock()
+	signer := state.Signer
+	ed25519Signer := state.Ed25519Signer
+	state.Mutex.RUnlock()
+	if signer != nil {
+		if err := addKey(signer.Public(), true); err != nil {
+			return nil, err
+		}
+	}
+	if ed25519Signer != nil {
+		if err := addKey(ed25519Signer.Public(), true); err != nil {
+			return nil, err
+		}
+	}
+	for _, publicKey := range state.KeymasterPublicKeys {
+		if err := addKey(publicKey, false); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func (state *RuntimeState) getCAMetadata() (*caMetadata, error) {
+	metadata := &caMetadata{Issuer: state.HostIdentity}
+	sshKeys, err := state.getSSHCAPublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, sshKey := range sshKeys {
+		metadata.CertificateAuthorities = append(
+			metadata.CertificateAuthorities, caInfo{
+				Type:        caTypeSSH,
+				Algorithm:   sshKey.key.Type(),
+				Fingerprint: ssh.FingerprintSHA256(sshKey.key),
+				PublicKey: strings.TrimSpace(string(
+					ssh.MarshalAuthorizedKey(sshKey.key))),
+				Current: sshKey.current,
+			})
+	}
+	state.Mutex.RLock()
+	caCert := state.caCert
+	state.Mutex.RUnlock()
+	if caCert == nil {
+		return nil, errors.New("CA certificate not loaded")
+	}
+	fingerprint := sha256.Sum256(caCert.Raw)
+	metadata.CertificateAuthorities = append(metadata.CertificateAuthorities,
+		caInfo{
+			Type:        caTypeX509,
+			Algorithm:   caCert.PublicKeyAlgorithm.String(),
+			Fingerprint: "SHA256:" + hex.EncodeToString(fingerprint[:]),
+			PublicKey: string(pem.EncodeToMemory(
+				&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})),
+			NotBefore: &caCert.NotBefore,
+			NotAfter:  &caCert.NotAfter,
+			Current:   true,
+			CrossSigned: encodeCertificatesPEM(
+				state.getCrossSignedCACerts(caCert)),
+		})
+	return metadata, nil
+}
+
+// getCAMetadataJSON returns the encoded metadata document. The encoding is
+// deterministic so that the detached signature matches a separately fetched
+// document.
+func (state *RuntimeState) getCAMetadataJSON() ([]byte, error) {
+	metadata, err := state.getCAMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(metadata, "", "  ")
+}
+
+func getJOSESignatureAlgorithm(signer crypto.Signer) jose.SignatureAlgorithm {
+	if _, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		return jose.ES256
+	}
+	return jose.RS256
+}
+
+// signDetached returns a JWS with detached payload over a public document,
+// signed with the CA key.
+func (state *RuntimeState) signDetached(document []byte) (string, error) {
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: getJOSESignatureAlgorithm(state.Signer),
+		Key:       state.Signer,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	jws, err := signer.Sign(document)
+	if err != nil {
+		return "", err
+	}
+	return jws.DetachedCompactSerialize()
+}
+
+// writeWithETag writes body with a strong ETag derived from its contents, so
+// that clients polling for changes can use If-None-Match.
+func writeWithETag(w http.ResponseWriter, r *http.Request, contentType string,
+	body []byte) {
+	hash := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimSpace(match)
+		if match == etag || match == "W/"+etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// writeSSHCAPublicKeys writes the SSH CA keys in the requested format:
+// "authorized_keys" adds the cert-authority option, "known_hosts" produces
+// @cert-authority marker lines for the hosts pattern (default "*"). The
+// default is plain keys, as used by the sshd TrustedUserCAKeys file.
+func (state *RuntimeState) writeSSHCAPublicKeys(w http.ResponseWriter,
+	r *http.Request) {
+	var prefix string
+	switch r.FormValue("format") {
+	case "":
+	case "authorized_keys":
+		prefix = "cert-authority "
+	case "known_hosts":
+		hosts := r.FormValue("hosts")
+		if hosts == "" {
+			hosts = "*"
+		}
+		if strings.ContainsAny(hosts, " \t\r\n") {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Invalid hosts pattern")
+			return
+		}
+		prefix = "@cert-authority " + hosts + " "
+	default:
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Unrecognized format")
+		return
+	}
+	lines, err := state.formatSSHCAPublicKeys(prefix)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	writeWithETag(w, r, "text/plain; charset=utf-8", lines)
+}
+
+// formatSSHCAPublicKeys returns one line per SSH CA key: the key with prefix
+// and the host identity as the comment.
+func (state *RuntimeState) formatSSHCAPublicKeys(prefix string) (
+	[]byte, error) {
+	keys, err := state.getSSHCAPublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	for _, key := range keys {
+		line := prefix +
+			strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key.key)))
+		if state.HostIdentity != "" {
+			line += " " + state.HostIdentity
+		}
+		fmt.Fprintln(&buffer, line)
+	}
+	return buffer.Bytes(), nil
+}
+
+// writeClientTrustBundle writes what client machines should trust: the SSH
+// CA keys as known_hosts lines for the configured hosts pattern, and the
+// X.509 CA certificate.
+func (state *RuntimeState) writeClientTrustBundle(w http.ResponseWriter,
+	r *http.Request) {
+	hosts := state.Config.HostCertificates.KnownHostsPattern
+	if hosts == "" {
+		hosts = "*"
+	}
+	knownHosts, err := state.formatSSHCAPublicKeys(
+		"@cert-authority " + hosts + " ")
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	state.Mutex.RLock()
+	caCert := state.caCert
+	state.Mutex.RUnlock()
+	if caCert == nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	body, err := json.MarshalIndent(proto.ClientTrustBundle{
+		Issuer:     state.HostIdentity,
+		KnownHosts: string(knownHosts),
+		X509CABundle: string(pem.EncodeToMemory(
+			&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})),
+	}, "", "  ")
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	writeWithETag(w, r, "application/json", body)
+}
+
+func (state *RuntimeState) writeCAMetadata(w http.ResponseWriter,
+	r *http.Request, signature bool) {
+	document, err := state.getCAMetadataJSON()
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if !signature {
+		writeWithETag(w, r, "application/json", document)
+		return
+	}
+	jws, err := state.signDetached(document)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/jose")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, jws)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestPublicSSHCA(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	state.HostIdentity = "keymaster.example.com"
+	expectedPrefixes := map[string]string{
+		"":                                 "ssh-rsa ",
+		"?format=authorized_keys":          "cert-authority ssh-rsa ",
+		"?format=known_hosts":              "@cert-authority * ssh-rsa ",
+		"?format=known_hosts&hosts=*.corp": "@cert-authority *.corp ssh-rsa ",
+	}
+	for query, prefix := range expectedPrefixes {
+		req, err := http.NewRequest("GET", "/public/sshca"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := checkRequestHandlerCode(req, state.publicPathHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		if len(lines) != 1 || !strings.HasPrefix(lines[0], prefix) ||
+			!strings.HasSuffix(lines[0], " keymaster.example.com") {
+			t.Fatalf("unexpected response for %q: %s", query, rr.Body)
+		}
+	}
+	req, err := http.NewRequest("GET", "/public/sshca?format=pem", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublicClientTrust(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	state.Config.HostCertificates.KnownHostsPattern = "*.example.com"
+	req, err := http.NewRequest("GET", proto.ClientTrustPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle proto.ClientTrustBundle
+	if err := json.NewDecoder(rr.Body).Decode(&bundle); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(bundle.KnownHosts,
+		"@cert-authority *.example.com ssh-rsa ") {
+		t.Errorf("unexpected known_hosts: %s", bundle.KnownHosts)
+	}
+	if !strings.HasPrefix(bundle.X509CABundle,
+		"-----BEGIN CERTIFICATE-----") {
+		t.Errorf("unexpected CA bundle: %s", bundle.X509CABundle)
+	}
+}
+
+func TestPublicCAMetadata(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	req, err := http.NewRequest("GET", "/public/caMetadata", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := rr.Body.Bytes()
+	var metadata caMetadata
+	if err := json.Unmarshal(document, &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.CertificateAuthorities) != 2 {
+		t.Fatalf("expected 2 CAs, got: %s", document)
+	}
+	sshCA := metadata.CertificateAuthorities[0]
+	if sshCA.Type != caTypeSSH || sshCA.Algorithm != "ssh-rsa" ||
+		!sshCA.Current || !strings.HasPrefix(sshCA.Fingerprint, "SHA256:") {
+		t.Fatalf("bad SSH CA: %+v", sshCA)
+	}
+	x509CA := metadata.CertificateAuthorities[1]
+	if x509CA.Type != caTypeX509 || x509CA.NotAfter == nil {
+		t.Fatalf("bad X.509 CA: %+v", x509CA)
+	}
+	// The detached signature must verify against the document.
+	req, err = http.NewRequest("GET", "/public/caMetadata.jws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimSpace(rr.Body.String()), ".")
+	if len(parts) != 3 || parts[1] != "" {
+		t.Fatalf("not a detached JWS: %s", rr.Body)
+	}
+	parts[1] = base64.RawURLEncoding.EncodeToString(document)
+	jws, err := jose.ParseSigned(strings.Join(parts, "."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jws.Verify(state.Signer.Public()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublicSSHRevokedKeys(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	_, err = state.revokeCertificate(revokedCertRecord{
+		CertType:  revocationTypeSSH,
+		Serial:    "42",
+		RevokedBy: "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", "/public/sshRevokedKeys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := rr.Body.Bytes()
+	if !bytes.HasPrefix(body, []byte("SSHKRL\n\x00")) {
+		t.Fatal("response is not a KRL")
+	}
+	serial := make([]byte, 8)
+	binary.BigEndian.PutUint64(serial, 42)
+	if !bytes.Contains(body, serial) {
+		t.Fatal("revoked serial missing from KRL")
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("missing ETag")
+	}
+	req.Header.Set("If-None-Match", etag)
+	_, err = checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusNotModified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A new revocation changes the ETag.
+	_, err = state.revokeCertificate(revokedCertRecord{
+		CertType:  revocationTypeSSH,
+		Serial:    "43",
+		RevokedBy: "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"github.com/Cloud-Foundations/keymaster/lib/fsutil"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	remoteConfigTimeout     = 30 * time.Second
+	remoteConfigMaxSize     = 16 << 20
+	remoteManifestSuffix    = ".manifest"
+	remoteManifestMaxSize   = 8192
+	remoteFilePermissions   = 0640
+	configSigningKeyPEMType = "PUBLIC KEY"
+)
+
+// remoteFileConfig is a file referenced by a remote configuration, such as
+// an htpasswd file or a CA certificate. It is downloaded and verified with
+// the configuration and written to Filename, where the configuration refers
+// to it.
+type remoteFileConfig struct {
+	URL      string `yaml:"url"`
+	Filename string `yaml:"filename"`
+}
+
+// remoteManifest describes a file served from Location. Signing it rather
+// than the file binds the content to its location, so that one signed file
+// cannot be served in place of another, and the serial and expiry keep
+// older versions from being replayed.
+type remoteManifest struct {
+	Location  string    `json:"location"`
+	SHA256    string    `json:"sha256"` // Hex encoded digest of the content.
+	Serial    uint64    `json:"serial"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// signedRemoteManifest is the content of a manifest file, which is at the
+// URL of the file it describes with a ".manifest" suffix. The manifest is
+// kept as the exact JSON which was signed.
+type signedRemoteManifest struct {
+	Manifest  string `json:"manifest"`
+	Signature []byte `json:"signature"`
+}
+
+// remoteFetcher downloads files from HTTPS and S3 URLs and verifies their
+// signed manifests.
+type remoteFetcher struct {
+	httpClient *http.Client
+	publicKey  crypto.PublicKey
+	s3Client   *s3.S3            // Created on first use.
+	serials    map[string]uint64 // Location: highest serial accepted.
+}
+
+func isRemoteConfig(location string) bool {
+	return strings.HasPrefix(location, "https://") ||
+		strings.HasPrefix(location, "s3://")
+}
+
+// loadConfigSigningKey reads the bootstrap public key which configurations
+// are signed with, a PEM encoded PKIX public key.
+func loadConfigSigningKey(filename string) (crypto.PublicKey, error) {
+	if filename == "" {
+		return nil, errors.New("no configuration signing key specified")
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != configSigningKeyPEMType {
+		return nil, fmt.Errorf("no %s PEM block in: %s",
+			configSigningKeyPEMType, filename)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// loadVerifyRemoteConfig downloads the configuration at location and the
+// files it references, verifies them with the public key in
+// signingKeyFilename and loads the configuration. The highest serials seen
+// are kept in serialsFilename.
+func loadVerifyRemoteConfig(location, signingKeyFilename,
+	serialsFilename string, logger log.DebugLogger) (*RuntimeState, error) {
+	publicKey, err := loadConfigSigningKey(signingKeyFilename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load configuration signing key: %s",
+			err)
+	}
+	serials, err := loadRemoteConfigSerials(serialsFilename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load configuration serials: %s", err)
+	}
+	fetcher := &remoteFetcher{
+		httpClient: &http.Client{Timeout: remoteConfigTimeout},
+		publicKey:  publicKey,
+		serials:    serials,
+	}
+	source, err := fetcher.fetchRemoteConfig(location, logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveRemoteConfigSerials(serialsFilename, serials); err != nil {
+		return nil, fmt.Errorf("cannot save configuration serials: %s", err)
+	}
+	return loadVerifyConfig(source, logger)
+}
+
+// loadRemoteConfigSerials returns the highest serials accepted so far, by
+// location. There are none before the first remote configuration is loaded.
+func loadRemoteConfigSerials(filename string) (map[string]uint64, error) {
+	serials := make(map[string]uint64)
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return serials, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &serials); err != nil {
+		return nil, err
+	}
+	return serials, nil
+}
+
+func saveRemoteConfigSerials(filename string,
+	serials map[string]uint64) error {
+	data, err := json.MarshalIndent(serials, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomically(filename, append(data, '\n'),
+		remoteFilePermissions)
+}
+
+// fetchRemoteConfig returns the verified configuration at location, after
+// writing the files it references. Nothing is written unless all of them
+// are verified.
+func (fetcher *remoteFetcher) fetchRemoteConfig(location string,
+	logger log.DebugLogger) ([]byte, error) {
+	source, err := fetcher.fetchSigned(location)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch config: %s", err)
+	}
+	var config AppConfigFile
+	if err := yaml.Unmarshal(source, &config); err != nil {
+		return nil, fmt.Errorf("cannot parse config file: %s", err)
+	}
+	files := make(map[string][]byte, len(config.RemoteFiles))
+	for _, file := range config.RemoteFiles {
+		if !isRemoteConfig(file.URL) {
+			return nil, fmt.Errorf("remote_files: unsupported URL: %s",
+				file.URL)
+		}
+		if !filepath.IsAbs(file.Filename) {
+			return nil, fmt.Errorf("remote_files: filename: %s is not absolute",
+				file.Filename)
+		}
+		data, err := fetcher.fetchSigned(file.URL)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch: %s: %s", file.URL, err)
+		}
+		files[file.Filename] = data
+	}
+	for _, file := range config.RemoteFiles {
+		err := fsutil.WriteFileAtomically(file.Filename,
+			files[file.Filename], remoteFilePermissions)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debugf(1, "wrote: %s from: %s\n", file.Filename, file.URL)
+	}
+	logger.Printf("loaded configuration from: %s\n", location)
+	return source, nil
+}
+
+// fetchSigned returns the content at location if its manifest is signed and
+// matches it, has not expired and is not older than the last one accepted.
+// The serial of the manifest is then recorded.
+func (fetcher *remoteFetcher) fetchSigned(location string) ([]byte, error) {
+	data, err := fetcher.fetch(location, remoteConfigMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	rawManifest, err := fetcher.fetch(location+remoteManifestSuffix,
+		remoteManifestMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := fetcher.verifyManifest(rawManifest, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("manifest of: %s: %s", location, err)
+	}
+	if manifest.Location != location {
+		return nil, fmt.Errorf("manifest of: %s is for: %s",
+			location, manifest.Location)
+	}
+	digest := sha256.Sum256(data)
+	if manifest.SHA256 != hex.EncodeToString(digest[:]) {
+		return nil, fmt.Errorf("content of: %s does not match its manifest",
+			location)
+	}
+	if lastSerial := fetcher.serials[location]; manifest.Serial < lastSerial {
+		return nil, fmt.Errorf("serial: %d of: %s is older than: %d",
+			manifest.Serial, location, lastSerial)
+	}
+	fetcher.serials[location] = manifest.Serial
+	return data, nil
+}
+
+// verifyManifest returns the manifest in rawManifest if it is validly signed
+// and has not expired.
+func (fetcher *remoteFetcher) verifyManifest(rawManifest []byte,
+	now time.Time) (*remoteManifest, error) {
+	var signed signedRemoteManifest
+	if err := json.Unmarshal(rawManifest, &signed); err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(signed.Manifest))
+	err := verifyDigestSignature(fetcher.publicKey, digest[:],
+		signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify signature: %s", err)
+	}
+	var manifest remoteManifest
+	if err := json.Unmarshal([]byte(signed.Manifest), &manifest); err != nil {
+		return nil, err
+	}
+	if !now.Before(manifest.ExpiresAt) {
+		return nil, fmt.Errorf("expired at: %s",
+			manifest.ExpiresAt.Format(time.RFC3339))
+	}
+	return &manifest, nil
+}
+
+func (fetcher *remoteFetcher) fetch(location string,
+	maxSize int64) ([]byte, error) {
+	parsedURL, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	switch parsedURL.Scheme {
+	case "https":
+		data, err = fetcher.fetchHTTPS(location, maxSize)
+	case "s3":
+		data, err = fetcher.fetchS3(parsedURL.Host,
+			strings.TrimPrefix(parsedURL.Path, "/"), maxSize)
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme: %s", parsedURL.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", location, maxSize)
+	}
+	return data, nil
+}
+
+func (fetcher *remoteFetcher) fetchHTTPS(location string,
+	maxSize int64) ([]byte, error) {
+	resp, err := fetcher.httpClient.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting %s: %s", location, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+}
+
+func (fetcher *remoteFetcher) fetchS3(bucket, key string,
+	maxSize int64) ([]byte, error) {
+	if bucket == "" || key == "" {
+		return nil, errors.New("S3 URL needs a bucket and a key")
+	}
+	if fetcher.s3Client == nil {
+		awsSession, err := newAwsSession()
+		if err != nil {
+			return nil, err
+		}
+		fetcher.s3Client = s3.New(awsSession)
+	}
+	output, err := fetcher.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return ioutil.ReadAll(io.LimitReader(output.Body, maxSize+1))
+}
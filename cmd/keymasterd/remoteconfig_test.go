@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+func TestFetchRemoteConfig(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "keymaster-remote-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	htpasswdFilename := filepath.Join(dir, "passfile.htpass")
+	files := make(map[string][]byte)
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			data, ok := files[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		}))
+	defer server.Close()
+	expiresAt := time.Now().Add(time.Hour)
+	serve := func(path string, data []byte, location string, serial uint64,
+		expiresAt time.Time) {
+		manifest, err := makeRemoteManifest(privateKey, data, location,
+			serial, expiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[path] = data
+		files[path+remoteManifestSuffix] = manifest
+	}
+	configURL := server.URL + "/config.yml"
+	htpasswdURL := server.URL + "/passfile.htpass"
+	htpasswd := []byte("alice:$2y$05$D4qQmZbWYqfgtGtez2EGdO\n")
+	serve("/passfile.htpass", htpasswd, htpasswdURL, 1, expiresAt)
+	config := []byte("base:\n  htpasswd_filename: " + htpasswdFilename +
+		"\nremote_files:\n  - url: " + htpasswdURL +
+		"\n    filename: " + htpasswdFilename + "\n")
+	serve("/config.yml", config, configURL, 2, expiresAt)
+	fetcher := &remoteFetcher{
+		httpClient: server.Client(),
+		publicKey:  publicKey,
+		serials:    map[string]uint64{configURL: 2},
+	}
+	fetch := func() error {
+		_, err := fetcher.fetchRemoteConfig(configURL, testlogger.New(t))
+		return err
+	}
+	if err := fetch(); err != nil {
+		t.Fatal(err)
+	}
+	written, err := ioutil.ReadFile(htpasswdFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != string(htpasswd) {
+		t.Fatalf("unexpected file content: %s", written)
+	}
+	if fetcher.serials[configURL] != 2 || fetcher.serials[htpasswdURL] != 1 {
+		t.Fatalf("unexpected serials: %v", fetcher.serials)
+	}
+	// Another signed file cannot be served in place of the configuration.
+	files["/config.yml"] = htpasswd
+	files["/config.yml.manifest"] = files["/passfile.htpass.manifest"]
+	if err := fetch(); err == nil {
+		t.Fatal("file for another location accepted")
+	}
+	// Nor can an older version.
+	serve("/config.yml", config, configURL, 1, expiresAt)
+	if err := fetch(); err == nil {
+		t.Fatal("older serial accepted")
+	}
+	serve("/config.yml", config, configURL, 3, time.Now().Add(-time.Minute))
+	if err := fetch(); err == nil {
+		t.Fatal("expired manifest accepted")
+	}
+	// A configuration signed with another key is refused.
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := makeRemoteManifest(otherKey, config, configURL, 3,
+		expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["/config.yml.manifest"] = manifest
+	if err := fetch(); err == nil {
+		t.Fatal("config with bad signature accepted")
+	}
+	// So is an unsigned one.
+	delete(files, "/config.yml.manifest")
+	if err := fetch(); err == nil {
+		t.Fatal("unsigned config accepted")
+	}
+	if fetcher.serials[configURL] != 2 {
+		t.Fatalf("serial changed by refused configs: %d",
+			fetcher.serials[configURL])
+	}
+}
+
+func TestRemoteConfigSerials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keymaster-remote-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "serials.json")
+	serials, err := loadRemoteConfigSerials(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serials) != 0 {
+		t.Fatalf("unexpected serials: %v", serials)
+	}
+	serials["s3://keymaster-config/prod/config.yml"] = 7
+	if err := saveRemoteConfigSerials(filename, serials); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadRemoteConfigSerials(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded["s3://keymaster-config/prod/config.yml"] != 7 {
+		t.Fatalf("unexpected serials: %v", loaded)
+	}
+}
+
+func TestLoadConfigSigningKey(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := ioutil.TempFile("", "keymaster-signing-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	err = pem.Encode(file, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedKey, err := loadConfigSigningKey(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !publicKey.Equal(loadedKey) {
+		t.Fatal("loaded key differs")
+	}
+	if _, err := loadConfigSigningKey(""); err == nil {
+		t.Fatal("empty filename accepted")
+	}
+}
+
+func TestSignConfig(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "keymaster-sign-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFilename := filepath.Join(dir, "signing.key")
+	err = ioutil.WriteFile(keyFilename,
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFilename := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(configFilename, []byte("base:\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	location := "s3://keymaster-config/prod/config.yml"
+	err = signConfig([]string{"-key", keyFilename, "-location", location,
+		"-serial", "5", configFilename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawManifest, err := ioutil.ReadFile(configFilename + remoteManifestSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher := &remoteFetcher{publicKey: publicKey}
+	manifest, err := fetcher.verifyManifest(rawManifest, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Location != location || manifest.Serial != 5 {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+	if err := signConfig([]string{"-key", keyFilename, "-location", location,
+		configFilename}); err == nil {
+		t.Fatal("manifest without serial signed")
+	}
+}
+
+func TestIsRemoteConfig(t *testing.T) {
+	tests := map[string]bool{
+		"/etc/keymaster/config.yml":             false,
+		"http://config.example.com/config.yml":  false,
+		"https://config.example.com/config.yml": true,
+		"s3://keymaster-config/prod/config.yml": true,
+	}
+	for location, expected := range tests {
+		if isRemoteConfig(location) != expected {
+			t.Errorf("%s: expected: %v", location, expected)
+		}
+	}
+}
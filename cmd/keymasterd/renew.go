@@ -0,0 +1,418 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	certRenewPath = certgenPath + "renew"
+
+	renewalMaxClockSkew  = 5 * time.Minute
+	renewalAuthMethodSSH = "KeymasterSSH"
+)
+
+var (
+	auditIDSuffixRE = regexp.MustCompile(`_[0-9a-f]{12}$`)
+
+	// The extensions of X.509 user certificates which renewal reproduces.
+	renewableX509Extensions = []asn1.ObjectIdentifier{
+		{2, 5, 29, 15},                      // Key usage.
+		{2, 5, 29, 17},                      // Subject alternative names.
+		{2, 5, 29, 19},                      // Basic constraints.
+		{2, 5, 29, 35},                      // Authority key ID.
+		{2, 5, 29, 37},                      // Extended key usage.
+		{1, 3, 6, 1, 4, 1, 9586, 100, 7, 2}, // Group list.
+		certgen.AuditIDOID,
+		certgen.ClaimsOID,
+		certgen.IssuanceContextOID,
+	}
+	groupListOID = renewableX509Extensions[5]
+)
+
+// renewalSignedData returns what SSH clients sign with the key of their
+// certificate, to prove that they have it.
+func renewalSignedData(hostIdentity string, timestamp int64,
+	certBlob []byte) []byte {
+	hash := sha256.Sum256(certBlob)
+	return []byte(fmt.Sprintf("keymaster-renew\n%s\n%d\n%x\n", hostIdentity,
+		timestamp, hash))
+}
+
+func sameStrings(left, right []string) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	left = append([]string(nil), left...)
+	right = append([]string(nil), right...)
+	sort.Strings(left)
+	sort.Strings(right)
+	for index := range left {
+		if left[index] != right[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// getRenewalDuration returns the lifetime of the certificate being renewed,
+// within the limit of new certificates.
+func getRenewalDuration(notBefore, notAfter time.Time) time.Duration {
+	duration := notAfter.Sub(notBefore)
+	if duration > maxCertificateLifetime {
+		return maxCertificateLifetime
+	}
+	return duration
+}
+
+// getUsernameFromSSHKeyID returns the username in the key ID of an SSH
+// certificate issued by keymaster, which looks like
+// <host identity>_<username>[_<audit ID>].
+func getUsernameFromSSHKeyID(hostIdentity, keyID string) string {
+	if !strings.HasPrefix(keyID, hostIdentity+"_") {
+		return ""
+	}
+	return auditIDSuffixRE.ReplaceAllString(
+		strings.TrimPrefix(keyID, hostIdentity+"_"), "")
+}
+
+// getX509CertificateGroups returns the groups listed in cert, or nil if it
+// has no group list.
+func getX509CertificateGroups(cert *x509.Certificate) ([]string, error) {
+	for _, extension := range cert.Extensions {
+		if extension.Id.Equal(groupListOID) {
+			var groups []string
+			if _, err := asn1.Unmarshal(extension.Value, &groups); err != nil {
+				return nil, err
+			}
+			return groups, nil
+		}
+	}
+	return nil, nil
+}
+
+func isRenewableX509Extension(id asn1.ObjectIdentifier) bool {
+	for _, renewable := range renewableX509Extensions {
+		if id.Equal(renewable) {
+			return true
+		}
+	}
+	return false
+}
+
+// certRenewHandler issues a fresh certificate with the same principals,
+// groups and lifetime as a keymaster certificate the client proves it has.
+// X.509 certificates are presented as TLS client certificates. SSH
+// certificates are posted in the certificate form field, with a signature
+// of renewalSignedData for the current time made with the certificate key.
+// Renewal must be enabled with allow_cert_renewal.
+func (state *RuntimeState) certRenewHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if !state.Config.Base.AllowCertRenewal {
+		http.NotFound(w, r)
+		return
+	}
+	var keySigner crypto.Signer
+	state.Mutex.RLock()
+	keySigner = state.Signer
+	state.Mutex.RUnlock()
+	if keySigner == nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		logger.Printf("Signer not loaded")
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	if r.Form.Get("certificate") == "" {
+		state.renewX509Certificate(w, r, keySigner)
+	} else {
+		state.renewSSHCertificate(w, r)
+	}
+}
+
+// checkRenewal returns false, after writing a failure response, if username
+// may not renew the certificate.
+func (state *RuntimeState) checkRenewal(w http.ResponseWriter,
+	r *http.Request, username, revocationType, serial,
+	certType string) bool {
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(username)
+	revoked, err := state.isCertificateRevoked(revocationType, serial)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return false
+	}
+	if revoked {
+		logger.Printf("refusing to renew revoked certificate: %s of: %s",
+			serial, username)
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Certificate revoked")
+		return false
+	}
+	if _, err := state.getUserGroups(username); err != nil {
+		logger.Printf("cannot verify user: %s for renewal: %s", username, err)
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Cannot verify user")
+		return false
+	}
+	if !state.checkAccountStatus(w, r, username) {
+		return false
+	}
+	return state.checkDevicePosture(w, r, username, certType)
+}
+
+func (state *RuntimeState) writeRenewalRefused(w http.ResponseWriter,
+	r *http.Request, username, reason string) {
+	logger.Printf("refusing to renew certificate of: %s: %s", username, reason)
+	state.writeFailureResponse(w, r, http.StatusForbidden,
+		"Certificate cannot be renewed: "+reason)
+}
+
+func (state *RuntimeState) renewX509Certificate(w http.ResponseWriter,
+	r *http.Request, keySigner crypto.Signer) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) < 1 {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Client certificate required")
+		return
+	}
+	username, _, err := state.getUsernameIfKeymasterSigned(
+		r.TLS.VerifiedChains)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if username == "" {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Not a keymaster certificate")
+		return
+	}
+	userCert := r.TLS.VerifiedChains[0][0]
+	kubernetesHack := len(userCert.Subject.Organization) != 1 ||
+		userCert.Subject.Organization[0] != "keymaster"
+	certType := "x509"
+	if kubernetesHack {
+		certType = "x509-kubernetes"
+	}
+	if !state.checkRenewal(w, r, username, revocationTypeX509,
+		userCert.SerialNumber.String(), certType) {
+		return
+	}
+	if !state.checkIssuanceQuota(w, r, username) {
+		return
+	}
+	go state.recordUserSeen(username)
+	for _, extension := range userCert.Extensions {
+		if !isRenewableX509Extension(extension.Id) {
+			state.writeRenewalRefused(w, r, username,
+				"unsupported extension: "+extension.Id.String())
+			return
+		}
+	}
+	oldGroups, err := getX509CertificateGroups(userCert)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid group list")
+		return
+	}
+	addGroups := oldGroups != nil
+	identity, err := state.getCertificateIdentity(username)
+	if err != nil {
+		logger.Printf("error getting certificate identity: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if !sameStrings(userCert.EmailAddresses, identity.EmailAddresses) {
+		state.writeRenewalRefused(w, r, username, "e-mail addresses changed")
+		return
+	}
+	if kubernetesHack || addGroups {
+		groups, err := state.getUserGroups(username)
+		if err != nil {
+			logger.Println(err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
+			return
+		}
+		if kubernetesHack &&
+			!sameStrings(groups, userCert.Subject.Organization) {
+			state.writeRenewalRefused(w, r, username, "groups changed")
+			return
+		}
+		if addGroups && !sameStrings(groups, oldGroups) {
+			state.writeRenewalRefused(w, r, username, "groups changed")
+			return
+		}
+	}
+	err = certgen.CheckPublicKeyStrength(userCert.PublicKey,
+		state.Config.Base.MinRSAKeyBits)
+	if err != nil {
+		state.writeRenewalRefused(w, r, username, err.Error())
+		return
+	}
+	issuance, err := newIssuanceContext(r,
+		getAuthMethod(AuthTypeKeymasterX509))
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	issuance.authStrength, issuance.authTime =
+		getRenewalAuthentication(userCert)
+	logger.Debugf(1, "renewing certificate: %s of: %s",
+		userCert.SerialNumber, username)
+	state.writeX509Certificate(w, r, username, keySigner, userCert.PublicKey,
+		getRenewalDuration(userCert.NotBefore, userCert.NotAfter),
+		kubernetesHack, addGroups, issuance)
+}
+
+// isKeymasterSSHCA returns true if key is one of the SSH CA keys of this
+// keymaster.
+func (state *RuntimeState) isKeymasterSSHCA(key ssh.PublicKey) (bool,
+	error) {
+	keys, err := state.getSSHCAPublicKeys()
+	if err != nil {
+		return false, err
+	}
+	for _, caKey := range keys {
+		if ssh.FingerprintSHA256(caKey.key) == ssh.FingerprintSHA256(key) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// verifySSHProofOfPossession returns an error for the client if the request
+// does not carry a recent signature made with the key of cert.
+func (state *RuntimeState) verifySSHProofOfPossession(r *http.Request,
+	cert *ssh.Certificate) error {
+	timestamp, err := strconv.ParseInt(r.Form.Get("timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp")
+	}
+	skew := time.Since(time.Unix(timestamp, 0))
+	if skew > renewalMaxClockSkew || skew < -renewalMaxClockSkew {
+		return fmt.Errorf("timestamp too far from the current time")
+	}
+	sigBlob, err := base64.StdEncoding.DecodeString(r.Form.Get("signature"))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding")
+	}
+	signature := new(ssh.Signature)
+	if err := ssh.Unmarshal(sigBlob, signature); err != nil {
+		return fmt.Errorf("invalid signature encoding")
+	}
+	err = cert.Key.Verify(
+		renewalSignedData(state.HostIdentity, timestamp, cert.Marshal()),
+		signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func (state *RuntimeState) renewSSHCertificate(w http.ResponseWriter,
+	r *http.Request) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(
+		[]byte(r.Form.Get("certificate")))
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid certificate")
+		return
+	}
+	cert, ok := publicKey.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert ||
+		len(cert.ValidPrincipals) < 1 {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Not an SSH user certificate")
+		return
+	}
+	isKeymasterCA, err := state.isKeymasterSSHCA(cert.SignatureKey)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	username := getUsernameFromSSHKeyID(state.HostIdentity, cert.KeyId)
+	if !isKeymasterCA || username == "" {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Not a keymaster certificate")
+		return
+	}
+	// Checks the signature, validity period and critical options.
+	checker := ssh.CertChecker{}
+	if err := checker.CheckCert(cert.ValidPrincipals[0], cert); err != nil {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Invalid certificate: "+err.Error())
+		return
+	}
+	if err := state.verifySSHProofOfPossession(r, cert); err != nil {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Proof of possession failed: "+err.Error())
+		return
+	}
+	if !state.checkRenewal(w, r, username, revocationTypeSSH,
+		strconv.FormatUint(cert.Serial, 10), "ssh") {
+		return
+	}
+	if !state.checkIssuanceQuota(w, r, username) {
+		return
+	}
+	go state.recordUserSeen(username)
+	identity, err := state.getCertificateIdentity(username)
+	if err != nil {
+		logger.Printf("error getting certificate identity: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if !sameStrings(cert.ValidPrincipals, identity.GetSSHPrincipals()) {
+		state.writeRenewalRefused(w, r, username, "principals changed")
+		return
+	}
+	userPubKey := string(ssh.MarshalAuthorizedKey(cert.Key))
+	sshUserPublicKey, userErr, err := getValidSSHPublicKey(userPubKey,
+		state.Config.Base.MinRSAKeyBits)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if userErr != nil {
+		state.writeRenewalRefused(w, r, username, userErr.Error())
+		return
+	}
+	issuance, err := newIssuanceContext(r, renewalAuthMethodSSH)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	logger.Debugf(1, "renewing SSH certificate: %d of: %s", cert.Serial,
+		username)
+	state.writeSSHCertificate(w, r, username, userPubKey, sshUserPublicKey,
+		getRenewalDuration(time.Unix(int64(cert.ValidAfter), 0),
+			time.Unix(int64(cert.ValidBefore), 0)),
+		issuance)
+}
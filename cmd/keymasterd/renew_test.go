@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGetUsernameFromSSHKeyID(t *testing.T) {
+	tests := map[string]string{
+		"keymaster.example.com_alice":                   "alice",
+		"keymaster.example.com_alice_0123456789ab":      "alice",
+		"keymaster.example.com_first_last_0123456789ab": "first_last",
+		"other.example.com_alice":                       "",
+	}
+	for keyID, want := range tests {
+		got := getUsernameFromSSHKeyID("keymaster.example.com", keyID)
+		if got != want {
+			t.Errorf("%s: expected: %q, got: %q", keyID, want, got)
+		}
+	}
+}
+
+func TestCertRenewHandler(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	state.HostIdentity = "keymaster.example.com"
+	newRequest := func(form url.Values) *http.Request {
+		req, err := http.NewRequest("POST", certRenewPath,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	_, err = checkRequestHandlerCode(newRequest(nil), state.certRenewHandler,
+		http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.AllowCertRenewal = true
+	// X.509 certificates are presented with TLS.
+	_, err = checkRequestHandlerCode(newRequest(nil), state.certRenewHandler,
+		http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := newRequest(nil)
+	req.TLS, err = testMakeConnectionState("testdata/alice.pem",
+		"testdata/KeymasterCA.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.certRenewHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rr.Body.String(), "BEGIN CERTIFICATE") {
+		t.Fatal("no X.509 certificate returned")
+	}
+	// SSH certificates are posted with a proof of possession.
+	userKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userSigner, err := ssh.NewSignerFromKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certString, cert, err := certgen.GenSSHCertFileStringForIdentity(
+		certgen.UserIdentity{Username: "alice"},
+		string(ssh.MarshalAuthorizedKey(userSigner.PublicKey())),
+		state.sshSigner, state.HostIdentity, "0123456789ab", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSSHForm := func(timestamp time.Time, signer ssh.Signer) url.Values {
+		signature, err := signer.Sign(rand.Reader, renewalSignedData(
+			state.HostIdentity, timestamp.Unix(), cert.Marshal()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return url.Values{
+			"certificate": {certString},
+			"timestamp":   {strconv.FormatInt(timestamp.Unix(), 10)},
+			"signature": {base64.StdEncoding.EncodeToString(
+				ssh.Marshal(signature))},
+		}
+	}
+	_, err = checkRequestHandlerCode(
+		newRequest(newSSHForm(time.Now().Add(-time.Hour), userSigner)),
+		state.certRenewHandler, http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(
+		newRequest(newSSHForm(time.Now(), state.sshSigner)),
+		state.certRenewHandler, http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(
+		newRequest(newSSHForm(time.Now(), userSigner)),
+		state.certRenewHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed, ok := publicKey.(*ssh.Certificate)
+	if !ok {
+		t.Fatal("no SSH certificate returned")
+	}
+	if renewed.Serial == cert.Serial ||
+		!sameStrings(renewed.ValidPrincipals, cert.ValidPrincipals) ||
+		string(renewed.Key.Marshal()) != string(cert.Key.Marshal()) {
+		t.Fatalf("renewed certificate differs: %+v", renewed)
+	}
+	// A revoked certificate cannot be renewed.
+	_, err = state.revokeCertificate(revokedCertRecord{
+		CertType:  revocationTypeSSH,
+		Serial:    strconv.FormatUint(cert.Serial, 10),
+		RevokedBy: "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(
+		newRequest(newSSHForm(time.Now(), userSigner)),
+		state.certRenewHandler, http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
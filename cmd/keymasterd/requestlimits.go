@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/requestlimiter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var requestLimitedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "keymaster_requests_limited_total",
+		Help: "Expensive requests rejected because too many were in flight.",
+	},
+	[]string{"operation", "reason"},
+)
+
+func init() {
+	prometheus.MustRegister(requestLimitedCounter)
+}
+
+// acquireRequestSlot reserves a slot for an expensive operation on behalf of
+// username. If too many operations are in flight for the user or overall, a
+// 429 response asking the client to retry later is written and false is
+// returned. Otherwise the returned function must be called once the
+// operation is done.
+func (state *RuntimeState) acquireRequestSlot(w http.ResponseWriter,
+	r *http.Request, username, operation string) (func(), bool) {
+	release, err := state.requestLimiter.Acquire(username)
+	if err == nil {
+		return release, true
+	}
+	reason := "total"
+	if errors.Is(err, requestlimiter.ErrUserLimit) {
+		reason = "user"
+	}
+	requestLimitedCounter.WithLabelValues(operation, reason).Inc()
+	logger.Debugf(1, "%s for %s rejected: %s", operation, username, err)
+	w.Header().Set("Retry-After", "1")
+	state.writeFailureResponse(w, r, http.StatusTooManyRequests,
+		"Too many concurrent requests, retry later")
+	return nil, false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/requestlimiter"
+)
+
+func TestAcquireRequestSlot(t *testing.T) {
+	state := RuntimeState{
+		requestLimiter: requestlimiter.New(
+			requestlimiter.Config{MaxPerUser: 1}),
+	}
+	req := httptest.NewRequest("POST", certgenPath+"alice", nil)
+	release, ok := state.acquireRequestSlot(httptest.NewRecorder(), req,
+		"alice", "certgen")
+	if !ok {
+		t.Fatal("first request rejected")
+	}
+	rr := httptest.NewRecorder()
+	if _, ok := state.acquireRequestSlot(rr, req, "alice", "certgen"); ok {
+		t.Fatal("concurrent request not rejected")
+	}
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Fatal("no Retry-After header")
+	}
+	release()
+	release, ok = state.acquireRequestSlot(httptest.NewRecorder(), req,
+		"alice", "certgen")
+	if !ok {
+		t.Fatal("request rejected after release")
+	}
+	release()
+}
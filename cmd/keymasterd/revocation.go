@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	revocationTypeSSH  = "ssh"
+	revocationTypeX509 = "x509"
+)
+
+type revokedCertRecord struct {
+	CertType  string    `json:"cert_type"`
+	Serial    string    `json:"serial"`
+	Username  string    `json:"username,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedBy string    `json:"revoked_by"`
+}
+
+var insertRevokedCertStmt = map[string]string{
+	"sqlite":   "insert or ignore into revoked_certificate(cert_type, serial, username, revoked_epoch, expiration_epoch, reason, revoked_by) values(?, ?, ?, ?, ?, ?, ?)",
+	"postgres": "insert into revoked_certificate(cert_type, serial, username, revoked_epoch, expiration_epoch, reason, revoked_by) values($1, $2, $3, $4, $5, $6, $7) on conflict do nothing",
+}
+
+var getRevokedSerialsForUserStmt = map[string]string{
+	"sqlite":   "select cert_type, serial from revoked_certificate where username = ?",
+	"postgres": "select cert_type, serial from revoked_certificate where username = $1",
+}
+
+var getRevokedSerialsByTypeStmt = map[string]string{
+	"sqlite":   "select serial, revoked_epoch from revoked_certificate where cert_type = ? and expiration_epoch >= ?",
+	"postgres": "select serial, revoked_epoch from revoked_certificate where cert_type = $1 and expiration_epoch >= $2",
+}
+
+var countRevokedSerialStmt = map[string]string{
+	"sqlite":   "select count(*) from revoked_certificate where cert_type = ? and serial = ?",
+	"postgres": "select count(*) from revoked_certificate where cert_type = $1 and serial = $2",
+}
+
+var getIssuedCertBySerialStmt = map[string]string{
+	"sqlite":   "select username, expiration_epoch from issued_certificate where serial = ? and cert_type like ? order by issued_epoch desc limit 1",
+	"postgres": "select username, expiration_epoch from issued_certificate where serial = $1 and cert_type like $2 order by issued_epoch desc limit 1",
+}
+
+// getRevocationType maps the type of an issued certificate to the type used
+// for revocation. Certificates issued by the same CA share serial numbers, so
+// they share a revocation type.
+func getRevocationType(certType string) string {
+	if certType == revocationTypeSSH {
+		return revocationTypeSSH
+	}
+	return revocationTypeX509
+}
+
+// revokeCertificate records the revocation. If the certificate was issued
+// by this service, the username and expiration are taken from its record.
+func (state *RuntimeState) revokeCertificate(record revokedCertRecord) (
+	revokedCertRecord, error) {
+	if record.CertType != revocationTypeSSH &&
+		record.CertType != revocationTypeX509 {
+		return record, fmt.Errorf("unknown certificate type: %s",
+			record.CertType)
+	}
+	record.RevokedAt = time.Now()
+	record.ExpiresAt = record.RevokedAt.Add(maxCertificateLifetime)
+	var username string
+	var expirationEpoch int64
+	err := state.db.QueryRow(getIssuedCertBySerialStmt[state.dbType],
+		record.Serial, record.CertType+"%").Scan(&username, &expirationEpoch)
+	switch err {
+	case nil:
+		record.Username = username
+		record.ExpiresAt = time.Unix(expirationEpoch, 0)
+	case sql.ErrNoRows:
+	default:
+		return record, err
+	}
+	_, err = state.db.Exec(insertRevokedCertStmt[state.dbType],
+		record.CertType, record.Serial, record.Username,
+		record.RevokedAt.Unix(), record.ExpiresAt.Unix(), record.Reason,
+		record.RevokedBy)
+	if err != nil {
+		return record, err
+	}
+	state.revocationPublisher.Trigger()
+	return record, nil
+}
+
+// markRevokedCertificates sets the Revoked field of the records which have
+// been revoked. All the records must belong to username.
+func (state *RuntimeState) markRevokedCertificates(username string,
+	records []issuedCertRecord) error {
+	rows, err := state.db.Query(getRevokedSerialsForUserStmt[state.dbType],
+		username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	revoked := make(map[string]struct{})
+	for rows.Next() {
+		var certType, serial string
+		if err := rows.Scan(&certType, &serial); err != nil {
+			return err
+		}
+		revoked[certType+":"+serial] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for index, record := range records {
+		key := getRevocationType(record.CertType) + ":" + record.Serial
+		if _, ok := revoked[key]; ok {
+			records[index].Revoked = true
+		}
+	}
+	return nil
+}
+
+// isCertificateRevoked returns true if the certificate of the given
+// revocation type and serial has been revoked.
+func (state *RuntimeState) isCertificateRevoked(certType, serial string) (
+	bool, error) {
+	var count int
+	err := state.db.QueryRow(countRevokedSerialStmt[state.dbType],
+		certType, serial).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// getRevokedCertificates returns the serials and revocation times of the
+// unexpired revoked certificates of the given revocation type.
+func (state *RuntimeState) getRevokedCertificates(certType string) (
+	[]revokedCertRecord, error) {
+	rows, err := state.db.Query(getRevokedSerialsByTypeStmt[state.dbType],
+		certType, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []revokedCertRecord
+	for rows.Next() {
+		var serial string
+		var revokedEpoch int64
+		if err := rows.Scan(&serial, &revokedEpoch); err != nil {
+			return nil, err
+		}
+		records = append(records, revokedCertRecord{
+			CertType:  certType,
+			Serial:    serial,
+			RevokedAt: time.Unix(revokedEpoch, 0),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// getRevokedSerials returns the serials of the unexpired revoked
+// certificates of the given revocation type and the time of the latest
+// revocation.
+func (state *RuntimeState) getRevokedSerials(certType string) (
+	[]string, time.Time, error) {
+	records, err := state.getRevokedCertificates(certType)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	serials := make([]string, 0, len(records))
+	var latest time.Time
+	for _, record := range records {
+		serials = append(serials, record.Serial)
+		if record.RevokedAt.After(latest) {
+			latest = record.RevokedAt
+		}
+	}
+	return serials, latest, nil
+}
+
+// cleanupRevokedCertificates forgets revocations of certificates which have
+// expired, since they are no longer accepted anyway.
+func cleanupRevokedCertificates(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(
+		"DELETE from revoked_certificate WHERE expiration_epoch < %d",
+		time.Now().Unix()))
+	return err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/publisher"
+)
+
+const (
+	crlFilename = "keymaster.crl"
+	crlLifetime = 24 * time.Hour
+	krlFilename = "revoked_keys"
+)
+
+// getX509RevocationList returns a DER encoded CRL of the unexpired revoked
+// X.509 certificates, signed by the CA. Since the CRL expires it must be
+// regenerated periodically.
+func (state *RuntimeState) getX509RevocationList() ([]byte, error) {
+	records, err := state.getRevokedCertificates(revocationTypeX509)
+	if err != nil {
+		return nil, err
+	}
+	state.Mutex.RLock()
+	keySigner := state.Signer
+	caCert := state.caCert
+	state.Mutex.RUnlock()
+	if keySigner == nil {
+		return nil, errors.New("signer not loaded")
+	}
+	revokedCerts := make([]pkix.RevokedCertificate, 0, len(records))
+	for _, record := range records {
+		serial, ok := new(big.Int).SetString(record.Serial, 10)
+		if !ok {
+			logger.Printf("ignoring invalid revoked X.509 serial: %s",
+				record.Serial)
+			continue
+		}
+		revokedCerts = append(revokedCerts, pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: record.RevokedAt,
+		})
+	}
+	now := time.Now()
+	return x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(now.Unix()),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(crlLifetime),
+		RevokedCertificates: revokedCerts,
+		SignatureAlgorithm:  caCert.SignatureAlgorithm,
+	}, caCert, keySigner)
+}
+
+// getRevocationListFiles returns the KRL and CRL for publishing.
+func (state *RuntimeState) getRevocationListFiles() ([]publisher.File, error) {
+	revocationList, err := state.getSSHRevocationList()
+	if err != nil {
+		return nil, err
+	}
+	crl, err := state.getX509RevocationList()
+	if err != nil {
+		return nil, err
+	}
+	return []publisher.File{
+		{
+			Name:        krlFilename,
+			ContentType: "application/octet-stream",
+			Data:        revocationList.Marshal(),
+		},
+		{
+			Name:        crlFilename,
+			ContentType: "application/pkix-crl",
+			Data:        crl,
+		},
+	}, nil
+}
+
+// startRevocationPublisher starts publishing the revocation lists to the
+// configured destinations. It must be called once the signer is ready.
+func (state *RuntimeState) startRevocationPublisher() error {
+	var err error
+	state.revocationPublisher, err = publisher.New(
+		state.Config.RevocationPublishing, state.getRevocationListFiles,
+		logger)
+	return err
+}
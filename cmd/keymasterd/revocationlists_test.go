@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/x509"
+	"os"
+	"testing"
+)
+
+func TestGetRevocationListFiles(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	_, err = state.revokeCertificate(revokedCertRecord{
+		CertType:  revocationTypeX509,
+		Serial:    "123456789012345678901234567890",
+		RevokedBy: "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := state.getRevocationListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != krlFilename ||
+		files[1].Name != crlFilename {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	crl, err := x509.ParseCRL(files[1].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(state.caCertDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := caCert.CheckCRLSignature(crl); err != nil {
+		t.Fatal(err)
+	}
+	revoked := crl.TBSCertList.RevokedCertificates
+	if len(revoked) != 1 ||
+		revoked[0].SerialNumber.String() != "123456789012345678901234567890" {
+		t.Fatalf("unexpected revoked certificates: %+v", revoked)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultRouteStatsLogInterval = 5 * time.Minute
+	maxRouteLatencySamples       = 1024
+	unmatchedRoute               = "unmatched"
+)
+
+// userRoutes are the subtree routes whose remainder is a username, which is
+// replaced by "{user}" in route names. The remainder of other subtree
+// routes is replaced by "*".
+var userRoutes = map[string]struct{}{
+	adminUserPath:                {},
+	certgenPath:                  {},
+	profilePath:                  {},
+	u2fRegisterRequesponsePath:   {},
+	u2fRegustisterRequestPath:    {},
+	webauthnRegisterRequestPath:  {},
+	webauthnRegisterResponsePath: {},
+}
+
+var httpRouteDurationHistogram = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "keymaster_http_route_duration_seconds",
+		Help:    "Duration of HTTP requests by listener, route and status code.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	},
+	[]string{"listener", "route", "code"},
+)
+
+func init() {
+	prometheus.MustRegister(httpRouteDurationHistogram)
+}
+
+type routeKey struct {
+	listener string
+	route    string
+}
+
+type routeStats struct {
+	count    uint64
+	codes    map[int]uint64
+	samples  []time.Duration // A uniform sample of at most the maximum.
+	duration time.Duration   // Total.
+}
+
+// routeStatsRecorder accumulates the per-route statistics which are logged
+// periodically, since they were last logged.
+type routeStatsRecorder struct {
+	mutex sync.Mutex
+	stats map[routeKey]*routeStats
+}
+
+// routeStatusWriter records the status of a response. Hijacked connections,
+// such as eventmon CONNECT sessions, are not recorded.
+type routeStatusWriter struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (w *routeStatusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *routeStatusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *routeStatusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *routeStatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf(
+			"ResponseWriter doesn't support Hijacker interface")
+	}
+	w.hijacked = true
+	return hijacker.Hijack()
+}
+
+// normalizeRoute returns the name of the route of r in mux: the pattern it
+// matched, with usernames and other path remainders replaced so that the
+// number of routes is bounded.
+func normalizeRoute(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	if pattern == "" {
+		return unmatchedRoute
+	}
+	if !strings.HasSuffix(pattern, "/") || r.URL.Path == pattern {
+		return pattern
+	}
+	if pattern == "/" {
+		return unmatchedRoute
+	}
+	if _, ok := userRoutes[pattern]; ok {
+		return pattern + "{user}"
+	}
+	return pattern + "*"
+}
+
+func (recorder *routeStatsRecorder) record(key routeKey, status int,
+	duration time.Duration) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if recorder.stats == nil {
+		recorder.stats = make(map[routeKey]*routeStats)
+	}
+	stats := recorder.stats[key]
+	if stats == nil {
+		stats = &routeStats{codes: make(map[int]uint64)}
+		recorder.stats[key] = stats
+	}
+	stats.count++
+	stats.codes[status]++
+	stats.duration += duration
+	if len(stats.samples) < maxRouteLatencySamples {
+		stats.samples = append(stats.samples, duration)
+	} else if index := rand.Int63n(int64(stats.count)); index <
+		maxRouteLatencySamples {
+		stats.samples[index] = duration
+	}
+}
+
+// takeStats returns the statistics since the last call.
+func (recorder *routeStatsRecorder) takeStats() map[routeKey]*routeStats {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	stats := recorder.stats
+	recorder.stats = nil
+	return stats
+}
+
+// getPercentile returns the percentile of sorted durations.
+func getPercentile(sorted []time.Duration, percentile int) time.Duration {
+	if len(sorted) < 1 {
+		return 0
+	}
+	index := (len(sorted)*percentile + 99) / 100
+	if index > 0 {
+		index--
+	}
+	return sorted[index]
+}
+
+// formatRouteStats returns the log line of a route.
+func formatRouteStats(key routeKey, stats *routeStats) string {
+	sort.Slice(stats.samples, func(i, j int) bool {
+		return stats.samples[i] < stats.samples[j]
+	})
+	codes := make([]int, 0, len(stats.codes))
+	for code := range stats.codes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	codeCounts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		codeCounts = append(codeCounts,
+			strconv.Itoa(code)+"="+strconv.FormatUint(stats.codes[code], 10))
+	}
+	return fmt.Sprintf(
+		"route stats: %s %s: requests=%d mean=%s p50=%s p90=%s p99=%s codes: %s",
+		key.listener, key.route, stats.count,
+		stats.duration/time.Duration(stats.count),
+		getPercentile(stats.samples, 50), getPercentile(stats.samples, 90),
+		getPercentile(stats.samples, 99), strings.Join(codeCounts, " "))
+}
+
+func (state *RuntimeState) logRouteStats() {
+	stats := state.routeStats.takeStats()
+	keys := make([]routeKey, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].listener != keys[j].listener {
+			return keys[i].listener < keys[j].listener
+		}
+		return keys[i].route < keys[j].route
+	})
+	for _, key := range keys {
+		state.logger.Println(formatRouteStats(key, stats[key]))
+	}
+}
+
+// newRouteMetricsHandler returns a handler which records the latency and
+// status of the requests to handler by route, looked up in mux. It must wrap
+// the access logging handler, whose writer the handlers depend on.
+func (state *RuntimeState) newRouteMetricsHandler(listener string,
+	mux *http.ServeMux, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := routeKey{listener: listener, route: normalizeRoute(mux, r)}
+		writer := &routeStatusWriter{ResponseWriter: w}
+		startTime := time.Now()
+		handler.ServeHTTP(writer, r)
+		if writer.hijacked {
+			return
+		}
+		duration := time.Since(startTime)
+		status := writer.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRouteDurationHistogram.WithLabelValues(listener, key.route,
+			strconv.Itoa(status)).Observe(duration.Seconds())
+		state.routeStats.record(key, status, duration)
+	})
+}
+
+// startRouteStatsLogger logs the route statistics periodically, unless it is
+// disabled.
+func (state *RuntimeState) startRouteStatsLogger() {
+	config := state.Config.HTTPServer
+	if config.DisableRouteStatsLog {
+		return
+	}
+	interval := durationOrDefault(config.RouteStatsLogInterval,
+		defaultRouteStatsLogInterval)
+	go func() {
+		for range time.Tick(interval) {
+			state.logRouteStats()
+		}
+	}()
+}
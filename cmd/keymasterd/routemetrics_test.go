@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+func TestNormalizeRoute(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux := http.NewServeMux()
+	mux.Handle(certgenPath, handler)
+	mux.Handle("/static/", handler)
+	mux.Handle(logoutPath, handler)
+	mux.Handle("/", handler)
+	tests := map[string]string{
+		"/certgen/alice":        "/certgen/{user}",
+		"/certgen/":             "/certgen/",
+		"/static/keymaster.css": "/static/*",
+		logoutPath:              logoutPath,
+		"/":                     "/",
+		"/no/such/path":         unmatchedRoute,
+	}
+	for path, expected := range tests {
+		route := normalizeRoute(mux, httptest.NewRequest("GET", path, nil))
+		if route != expected {
+			t.Errorf("%s: expected: %s, got: %s", path, expected, route)
+		}
+	}
+}
+
+func TestRouteMetricsHandler(t *testing.T) {
+	state := &RuntimeState{logger: testlogger.New(t)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(certgenPath, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/bob") {
+			http.Error(w, "", http.StatusUnauthorized)
+		}
+	})
+	handler := state.newRouteMetricsHandler(handlerSetService, mux, mux)
+	for _, user := range []string{"alice", "alice", "bob"} {
+		handler.ServeHTTP(httptest.NewRecorder(),
+			httptest.NewRequest("GET", certgenPath+user, nil))
+	}
+	stats := state.routeStats.takeStats()
+	routeStats := stats[routeKey{handlerSetService, "/certgen/{user}"}]
+	if len(stats) != 1 || routeStats == nil {
+		t.Fatalf("unexpected routes: %v", stats)
+	}
+	if routeStats.count != 3 || routeStats.codes[http.StatusOK] != 2 ||
+		routeStats.codes[http.StatusUnauthorized] != 1 {
+		t.Fatalf("unexpected stats: %+v", routeStats)
+	}
+	if len(state.routeStats.takeStats()) != 0 {
+		t.Fatal("statistics not reset")
+	}
+}
+
+func TestRouteStatsPercentiles(t *testing.T) {
+	var recorder routeStatsRecorder
+	key := routeKey{handlerSetAdmin, statusPath}
+	for i := 1; i <= 2*maxRouteLatencySamples; i++ {
+		recorder.record(key, http.StatusOK, time.Millisecond)
+	}
+	stats := recorder.takeStats()[key]
+	if len(stats.samples) != maxRouteLatencySamples {
+		t.Fatalf("expected %d samples, got: %d",
+			maxRouteLatencySamples, len(stats.samples))
+	}
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if p := getPercentile(sorted, 50); p != 5 {
+		t.Errorf("p50: expected: 5, got: %d", p)
+	}
+	if p := getPercentile(sorted, 99); p != 10 {
+		t.Errorf("p99: expected: 10, got: %d", p)
+	}
+	line := formatRouteStats(key, stats)
+	if !strings.Contains(line, "requests=2048") ||
+		!strings.Contains(line, "p99=1ms") ||
+		!strings.Contains(line, "codes: 200=2048") {
+		t.Fatalf("unexpected log line: %s", line)
+	}
+}
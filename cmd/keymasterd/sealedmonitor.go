@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/alerting"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultSealedAlertGracePeriod = 10 * time.Minute
+	sealedCheckInterval           = 10 * time.Second
+)
+
+var (
+	signerSealedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keymaster_signer_sealed",
+			Help: "1 while the CA signer is sealed, else 0.",
+		},
+		[]string{"keymaster"},
+	)
+	signerSealedSecondsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keymaster_signer_sealed_seconds",
+			Help: "Seconds since start while the CA signer is sealed, else 0.",
+		},
+		[]string{"keymaster"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(signerSealedGauge)
+	prometheus.MustRegister(signerSealedSecondsGauge)
+}
+
+// sealedAlertConfig configures the signer_sealed notification, sent when the
+// signer is still sealed GracePeriod after start. Default: 10m.
+type sealedAlertConfig struct {
+	GracePeriod time.Duration `yaml:"grace_period"`
+}
+
+// monitorSealedState updates the sealed metrics until the signer is
+// unsealed, notifying once if that takes longer than the grace period.
+func (state *RuntimeState) monitorSealedState() {
+	alerted := false
+	for {
+		var sealed bool
+		sealed, alerted = state.checkSealedState(time.Now(), alerted)
+		if !sealed {
+			return
+		}
+		time.Sleep(sealedCheckInterval)
+	}
+}
+
+// checkSealedState updates the sealed metrics as of now and sends the
+// signer_sealed notification unless alerted is true. It returns whether the
+// signer is sealed and whether the notification has been sent.
+func (state *RuntimeState) checkSealedState(now time.Time,
+	alerted bool) (bool, bool) {
+	gauge := signerSealedGauge.WithLabelValues(state.HostIdentity)
+	secondsGauge := signerSealedSecondsGauge.WithLabelValues(
+		state.HostIdentity)
+	if state.isUnsealed() {
+		gauge.Set(0)
+		secondsGauge.Set(0)
+		return false, alerted
+	}
+	sealedFor := now.Sub(processStartTime)
+	gauge.Set(1)
+	secondsGauge.Set(sealedFor.Seconds())
+	gracePeriod := state.Config.SealedAlert.GracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = defaultSealedAlertGracePeriod
+	}
+	if alerted || sealedFor < gracePeriod {
+		return true, alerted
+	}
+	logger.Printf("signer still sealed %s after start",
+		sealedFor.Round(time.Second))
+	state.alerter.Send(alerting.Event{
+		Type: alerting.EventSignerSealed,
+		Summary: fmt.Sprintf("Signer still sealed %s after start",
+			sealedFor.Round(time.Second)),
+	})
+	return true, true
+}
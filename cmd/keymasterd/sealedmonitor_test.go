@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCheckSealedState(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	signer := state.Signer
+	state.Signer = nil
+	sealed, alerted := state.checkSealedState(
+		processStartTime.Add(time.Minute), false)
+	if !sealed || alerted {
+		t.Fatalf("sealed: %v, alerted: %v within the grace period",
+			sealed, alerted)
+	}
+	sealed, alerted = state.checkSealedState(
+		processStartTime.Add(defaultSealedAlertGracePeriod), false)
+	if !sealed || !alerted {
+		t.Fatalf("sealed: %v, alerted: %v after the grace period",
+			sealed, alerted)
+	}
+	state.Signer = signer
+	sealed, _ = state.checkSealedState(time.Now(), alerted)
+	if sealed {
+		t.Fatal("unsealed signer reported as sealed")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Account security events which users may be notified of by email.
+const (
+	securityEventDeviceRegistered = "device_registered"
+	securityEventDevicesReset     = "devices_reset"
+	securityEventNewSourceAddress = "new_source_address"
+	securityEventPasswordChanged  = "password_changed"
+
+	maxKnownCertSourceAddrs = 64
+)
+
+var securityEmailTemplateNames = map[string]string{
+	securityEventDeviceRegistered: "Device Registered Email",
+	securityEventDevicesReset:     "Devices Reset Email",
+	securityEventNewSourceAddress: "New Source Address Email",
+	securityEventPasswordChanged:  "Password Changed Email",
+}
+
+const emailSecurityTemplateData = `
+{{define "Device Registered Email"}}
+From: {{.FromAddr}}
+To: {{.UserAddr}}
+Subject: Keymaster: new second factor registered
+
+Hi, {{.Username}}. {{.DeviceName}} was registered as a second factor for
+your Keymaster account{{if .Actor}} by {{.Actor}}{{end}} at {{.Time}} from
+{{.SourceAddr}}.
+
+If this was not you, please contact your administrators immediately. Your
+devices may be reviewed at: https://{{.HostIdentity}}/profile/
+{{end}}
+
+{{define "Devices Reset Email"}}
+From: {{.FromAddr}}
+To: {{.UserAddr}}
+Subject: Keymaster: second factors reset
+
+Hi, {{.Username}}. {{.Actor}} removed all the second factor devices from your
+Keymaster account at {{.Time}}. You will need to register a device again the
+next time you log in to: https://{{.HostIdentity}}/
+
+If you did not ask for this, please contact your administrators immediately.
+{{end}}
+
+{{define "New Source Address Email"}}
+From: {{.FromAddr}}
+To: {{.UserAddr}}
+Subject: Keymaster: certificate issued to a new address
+
+Hi, {{.Username}}. A {{.CertType}} certificate was issued for your account at
+{{.Time}} to {{.SourceAddr}}, an address which has not requested a certificate
+for you before.
+
+If this was not you, please contact your administrators immediately.
+{{end}}
+
+{{define "Password Changed Email"}}
+From: {{.FromAddr}}
+To: {{.UserAddr}}
+Subject: Keymaster: password changed
+
+Hi, {{.Username}}. The password of your account was changed through
+Keymaster at {{.Time}} from {{.SourceAddr}}.
+
+If this was not you, please contact your administrators immediately.
+{{end}}
+`
+
+type securityEmailData struct {
+	Actor        string
+	CertType     string
+	DeviceName   string
+	FromAddr     string
+	HostIdentity string
+	SourceAddr   string
+	Time         string
+	UserAddr     string
+	Username     string
+}
+
+// checkSecurityEmailConfig checks that the events users are notified of are
+// known.
+func (state *RuntimeState) checkSecurityEmailConfig() error {
+	for _, event := range state.Config.Email.NotifyUsersOf {
+		if _, ok := securityEmailTemplateNames[event]; !ok {
+			return fmt.Errorf("unknown security event: %s", event)
+		}
+	}
+	return nil
+}
+
+func (state *RuntimeState) notifiesUsersOf(event string) bool {
+	if state.emailManager == nil {
+		return false
+	}
+	for _, notifiedEvent := range state.Config.Email.NotifyUsersOf {
+		if notifiedEvent == event {
+			return true
+		}
+	}
+	return false
+}
+
+// sendSecurityEmail emails the user about the event in the background, if
+// users are notified of it.
+func (state *RuntimeState) sendSecurityEmail(event string,
+	data securityEmailData) {
+	if !state.notifiesUsersOf(event) {
+		return
+	}
+	data.FromAddr = state.Config.Email.From
+	if data.FromAddr == "" {
+		data.FromAddr = "keymaster@" + state.Config.Email.Domain
+	}
+	data.HostIdentity = state.Config.Base.HostIdentity
+	data.Time = time.Now().Format(time.RFC1123)
+	data.UserAddr = data.Username + "@" + state.Config.Email.Domain
+	buffer := &bytes.Buffer{}
+	err := state.textTemplates.ExecuteTemplate(buffer,
+		securityEmailTemplateNames[event], data)
+	if err != nil {
+		logger.Printf("error rendering %s email: %s", event, err)
+		return
+	}
+	go func() {
+		err := state.sendMail(data.FromAddr, []string{data.UserAddr},
+			buffer.Bytes(), emailTimeout)
+		if err != nil {
+			logger.Printf("error sending %s email to %s: %s", event,
+				data.UserAddr, err)
+		}
+	}()
+}
+
+// notifyDeviceRegistered emails the user when a second factor device was
+// registered for them.
+func (state *RuntimeState) notifyDeviceRegistered(authUser, targetUser,
+	deviceName, sourceAddr string) {
+	data := securityEmailData{
+		DeviceName: deviceName,
+		SourceAddr: getSourceIP(sourceAddr),
+		Username:   targetUser,
+	}
+	if authUser != targetUser {
+		data.Actor = authUser
+	}
+	state.sendSecurityEmail(securityEventDeviceRegistered, data)
+}
+
+// notifyDevicesReset emails the user when an admin reset their devices.
+func (state *RuntimeState) notifyDevicesReset(authUser, targetUser string) {
+	state.sendSecurityEmail(securityEventDevicesReset, securityEmailData{
+		Actor:    authUser,
+		Username: targetUser,
+	})
+}
+
+// notifyPasswordChanged emails the user when they changed their password.
+func (state *RuntimeState) notifyPasswordChanged(username, sourceAddr string) {
+	state.sendSecurityEmail(securityEventPasswordChanged, securityEmailData{
+		SourceAddr: getSourceIP(sourceAddr),
+		Username:   username,
+	})
+}
+
+func getSourceIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// recordCertSourceAddress remembers the address certificates for the user
+// are issued to and emails the user the first time a new address is seen.
+// The first address recorded for a user is not reported, since every user
+// starts with one.
+func (state *RuntimeState) recordCertSourceAddress(username, certType,
+	remoteAddr string) {
+	if !state.notifiesUsersOf(securityEventNewSourceAddress) {
+		return
+	}
+	sourceIP := getSourceIP(remoteAddr)
+	defer state.lockUserProfile(username)()
+	profile, ok, fromCache, err := state.LoadUserProfile(username)
+	if err != nil {
+		logger.Printf("error loading profile of %s: %s", username, err)
+		return
+	}
+	if !ok || fromCache {
+		return
+	}
+	if _, ok := profile.KnownCertSourceAddrs[sourceIP]; ok {
+		return
+	}
+	isFirst := len(profile.KnownCertSourceAddrs) < 1
+	if profile.KnownCertSourceAddrs == nil {
+		profile.KnownCertSourceAddrs = make(map[string]time.Time)
+	}
+	if len(profile.KnownCertSourceAddrs) >= maxKnownCertSourceAddrs {
+		var oldestAddr string
+		var oldestTime time.Time
+		for addr, seenAt := range profile.KnownCertSourceAddrs {
+			if oldestAddr == "" || seenAt.Before(oldestTime) {
+				oldestAddr = addr
+				oldestTime = seenAt
+			}
+		}
+		delete(profile.KnownCertSourceAddrs, oldestAddr)
+	}
+	profile.KnownCertSourceAddrs[sourceIP] = time.Now()
+	if err := state.SaveUserProfile(username, profile); err != nil {
+		logger.Printf("error saving profile of %s: %s", username, err)
+		return
+	}
+	if isFirst {
+		return
+	}
+	state.sendSecurityEmail(securityEventNewSourceAddress, securityEmailData{
+		CertType:   certType,
+		SourceAddr: sourceIP,
+		Username:   username,
+	})
+}
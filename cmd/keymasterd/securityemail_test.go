@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	texttemplate "text/template"
+	"time"
+)
+
+type testEmailManager struct {
+	mutex    sync.Mutex
+	messages []string
+	sent     chan struct{}
+}
+
+func (m *testEmailManager) SendMail(from string, to []string,
+	msg []byte) error {
+	m.mutex.Lock()
+	m.messages = append(m.messages, strings.Join(to, ",")+"\n"+string(msg))
+	m.mutex.Unlock()
+	m.sent <- struct{}{}
+	return nil
+}
+
+func (m *testEmailManager) waitForMessage(t *testing.T) string {
+	select {
+	case <-m.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for email")
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.messages[len(m.messages)-1]
+}
+
+func TestRecordCertSourceAddress(t *testing.T) {
+	state, tmpdir, err := newTestingState(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	state.textTemplates, err = texttemplate.New("text").Parse(
+		emailSecurityTemplateData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailManager := &testEmailManager{sent: make(chan struct{}, 10)}
+	state.emailManager = emailManager
+	state.Config.Email.Domain = "example.com"
+	state.Config.Email.NotifyUsersOf = []string{securityEventNewSourceAddress}
+	profile, _, _, err := state.LoadUserProfile("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.SaveUserProfile("alice", profile); err != nil {
+		t.Fatal(err)
+	}
+	// The first address is remembered without sending email.
+	state.recordCertSourceAddress("alice", "ssh", "192.0.2.1:1234")
+	state.recordCertSourceAddress("alice", "ssh", "192.0.2.1:5678")
+	if len(emailManager.messages) != 0 {
+		t.Fatalf("unexpected email: %v", emailManager.messages)
+	}
+	state.recordCertSourceAddress("alice", "x509", "198.51.100.7:1234")
+	message := emailManager.waitForMessage(t)
+	if !strings.HasPrefix(message, "alice@example.com\n") ||
+		!strings.Contains(message, "From: keymaster@example.com") ||
+		!strings.Contains(message, "x509 certificate") ||
+		!strings.Contains(message, "198.51.100.7,") {
+		t.Fatalf("unexpected email: %s", message)
+	}
+	profile, _, _, err = state.LoadUserProfile("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.KnownCertSourceAddrs) != 2 {
+		t.Fatalf("unexpected known addresses: %v",
+			profile.KnownCertSourceAddrs)
+	}
+}
+
+func TestCheckSecurityEmailConfig(t *testing.T) {
+	state := &RuntimeState{}
+	state.Config.Email.NotifyUsersOf = []string{securityEventDevicesReset,
+		"lunch"}
+	if err := state.checkSecurityEmailConfig(); err == nil {
+		t.Fatal("no error for unknown event")
+	}
+}
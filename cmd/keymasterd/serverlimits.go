@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultReadHeaderTimeout  = 5 * time.Second
+	defaultReadTimeout        = 5 * time.Second
+	defaultWriteTimeout       = 10 * time.Second
+	defaultIdleTimeout        = 120 * time.Second
+	defaultMaxHeaderBytes     = 64 << 10
+	defaultMaxRequestBodySize = 1 << 20
+	smallRequestBodySize      = 64 << 10
+)
+
+// defaultEndpointBodyLimits are the body size limits for endpoints which
+// only ever receive a public key or a second factor response.
+var defaultEndpointBodyLimits = map[string]int64{
+	certgenPath:                      smallRequestBodySize,
+	hostCertgenPath:                  smallRequestBodySize,
+	hostCertStatusPath:               smallRequestBodySize,
+	u2fRegisterRequesponsePath:       smallRequestBodySize,
+	u2fSignResponsePath:              smallRequestBodySize,
+	webauthnRegisterResponsePath:     smallRequestBodySize,
+	webauthnSignResponsePath:         smallRequestBodySize,
+	webauthnPasskeyLoginResponsePath: smallRequestBodySize,
+}
+
+// httpServerConfig configures the timeouts and request limits of all the
+// HTTP servers. Zero values select the defaults. RequestTimeout is the
+// deadline of the request context passed to handlers and defaults to the
+// write timeout.
+type httpServerConfig struct {
+	ReadHeaderTimeout  time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout        time.Duration `yaml:"read_timeout"`
+	WriteTimeout       time.Duration `yaml:"write_timeout"`
+	IdleTimeout        time.Duration `yaml:"idle_timeout"`
+	RequestTimeout     time.Duration `yaml:"request_timeout"`
+	MaxHeaderBytes     int           `yaml:"max_header_bytes"`
+	MaxRequestBodySize int64         `yaml:"max_request_body_size"`
+	// Paths ending in "/" limit all paths below them, like http.ServeMux.
+	EndpointBodyLimits    map[string]int64 `yaml:"endpoint_body_limits"`
+	RouteStatsLogInterval time.Duration    `yaml:"route_stats_log_interval"` // Default: 5m.
+	DisableRouteStatsLog  bool             `yaml:"disable_route_stats_log"`
+}
+
+func (state *RuntimeState) validateHTTPServerConfig() error {
+	config := &state.Config.HTTPServer
+	if config.ReadHeaderTimeout < 0 || config.ReadTimeout < 0 ||
+		config.WriteTimeout < 0 || config.IdleTimeout < 0 ||
+		config.RequestTimeout < 0 || config.RouteStatsLogInterval < 0 {
+		return fmt.Errorf("http_server: negative timeout")
+	}
+	if config.MaxHeaderBytes < 0 || config.MaxRequestBodySize < 0 {
+		return fmt.Errorf("http_server: negative size limit")
+	}
+	for path, limit := range config.EndpointBodyLimits {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("http_server: endpoint path: %s must be absolute",
+				path)
+		}
+		if limit <= 0 {
+			return fmt.Errorf("http_server: endpoint %s: limit must be positive",
+				path)
+		}
+	}
+	return nil
+}
+
+func durationOrDefault(value, defaultValue time.Duration) time.Duration {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// newHTTPServer returns a server for handler with the configured timeouts.
+func (state *RuntimeState) newHTTPServer(addr string,
+	handler http.Handler) *http.Server {
+	config := state.Config.HTTPServer
+	maxHeaderBytes := config.MaxHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = defaultMaxHeaderBytes
+	}
+	return &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		ReadHeaderTimeout: durationOrDefault(config.ReadHeaderTimeout,
+			defaultReadHeaderTimeout),
+		ReadTimeout:    durationOrDefault(config.ReadTimeout, defaultReadTimeout),
+		WriteTimeout:   durationOrDefault(config.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:    durationOrDefault(config.IdleTimeout, defaultIdleTimeout),
+		MaxHeaderBytes: maxHeaderBytes,
+	}
+}
+
+// getRequestBodyLimit returns the body size limit for path: the limit of the
+// longest matching endpoint, else the global limit.
+func getRequestBodyLimit(limits map[string]int64, defaultLimit int64,
+	path string) int64 {
+	limit := defaultLimit
+	matchLength := 0
+	for pattern, patternLimit := range limits {
+		if len(pattern) <= matchLength {
+			continue
+		}
+		if pattern == path || (strings.HasSuffix(pattern, "/") &&
+			strings.HasPrefix(path, pattern)) {
+			limit = patternLimit
+			matchLength = len(pattern)
+		}
+	}
+	return limit
+}
+
+// newBodyLimitHandler returns a handler which rejects requests whose body is
+// larger than the limit for their path. Requests which do not declare their
+// length fail when the handler reads past the limit.
+func (state *RuntimeState) newBodyLimitHandler(
+	handler http.Handler) http.Handler {
+	limits := make(map[string]int64, len(defaultEndpointBodyLimits)+
+		len(state.Config.HTTPServer.EndpointBodyLimits))
+	for path, limit := range defaultEndpointBodyLimits {
+		limits[path] = limit
+	}
+	for path, limit := range state.Config.HTTPServer.EndpointBodyLimits {
+		limits[path] = limit
+	}
+	defaultLimit := state.Config.HTTPServer.MaxRequestBodySize
+	if defaultLimit <= 0 {
+		defaultLimit = defaultMaxRequestBodySize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := getRequestBodyLimit(limits, defaultLimit, r.URL.Path)
+		if r.ContentLength > limit {
+			state.writeFailureResponse(w, r,
+				http.StatusRequestEntityTooLarge, "")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetRequestBodyLimit(t *testing.T) {
+	limits := map[string]int64{
+		"/certgen/":      10,
+		"/certgen/admin": 20,
+		"/api/v0/thing":  30,
+	}
+	tests := map[string]int64{
+		"/certgen/alice":   10,
+		"/certgen/admin":   20,
+		"/certgen/":        10,
+		"/api/v0/thing":    30,
+		"/api/v0/thing/x":  100,
+		"/something/else":  100,
+		"/certgenerations": 100,
+	}
+	for path, expected := range tests {
+		if limit := getRequestBodyLimit(limits, 100, path); limit != expected {
+			t.Errorf("%s: expected %d, got %d", path, expected, limit)
+		}
+	}
+}
+
+func TestBodyLimitHandler(t *testing.T) {
+	state := RuntimeState{}
+	state.Config.HTTPServer.MaxRequestBodySize = 100
+	state.Config.HTTPServer.EndpointBodyLimits = map[string]int64{
+		"/small": 10,
+	}
+	handler := state.newBodyLimitHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if _, err := ioutil.ReadAll(r.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		}))
+	tests := []struct {
+		path           string
+		size           int
+		chunked        bool
+		expectedStatus int
+	}{
+		{"/small", 10, false, http.StatusOK},
+		{"/small", 11, false, http.StatusRequestEntityTooLarge},
+		{"/small", 11, true, http.StatusBadRequest},
+		{"/large", 100, false, http.StatusOK},
+		{"/large", 101, false, http.StatusRequestEntityTooLarge},
+		{certgenPath + "alice", smallRequestBodySize + 1, false,
+			http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		var body io.Reader = strings.NewReader(strings.Repeat("x", test.size))
+		if test.chunked {
+			// Hide the length so that it is only found when reading.
+			body = ioutil.NopCloser(body)
+		}
+		req := httptest.NewRequest("POST", test.path, body)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s (%d bytes): expected %d, got %d", test.path,
+				test.size, test.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func TestValidateHTTPServerConfig(t *testing.T) {
+	state := RuntimeState{}
+	state.Config.HTTPServer.EndpointBodyLimits = map[string]int64{
+		"certgen": 10,
+	}
+	if err := state.validateHTTPServerConfig(); err == nil {
+		t.Fatal("relative endpoint path accepted")
+	}
+	state.Config.HTTPServer.EndpointBodyLimits = nil
+	state.Config.HTTPServer.WriteTimeout = -1
+	if err := state.validateHTTPServerConfig(); err == nil {
+		t.Fatal("negative timeout accepted")
+	}
+}
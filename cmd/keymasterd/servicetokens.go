@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	serviceTokenJWKSPath           = "/.well-known/jwks.json"
+	serviceTokenType               = "keymaster_service_token"
+	defaultServiceTokenLifetime    = 5 * time.Minute
+	defaultMaxServiceTokenLifetime = time.Hour
+)
+
+// serviceTokenConfig configures the short-lived JWTs which users and
+// services may obtain to prove their identity to other internal services.
+type serviceTokenConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Tokens may only be issued for these audiences.
+	Audiences   []string      `yaml:"audiences"`
+	MaxLifetime time.Duration `yaml:"max_lifetime"`
+}
+
+func (config *serviceTokenConfig) getMaxLifetime() time.Duration {
+	if config.MaxLifetime > 0 {
+		return config.MaxLifetime
+	}
+	return defaultMaxServiceTokenLifetime
+}
+
+func (config *serviceTokenConfig) allowsAudience(audience string) bool {
+	for _, allowed := range config.Audiences {
+		if audience == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+type serviceTokenClaims struct {
+	Issuer     string   `json:"iss"`
+	Subject    string   `json:"sub"`
+	Audience   []string `json:"aud"`
+	Expiration int64    `json:"exp"`
+	NotBefore  int64    `json:"nbf"`
+	IssuedAt   int64    `json:"iat"`
+	ID         string   `json:"jti"`
+	Groups     []string `json:"groups,omitempty"`
+	TokenType  string   `json:"token_type"`
+}
+
+// genNewSerializedServiceToken signs the claims with the CA key. The kid
+// header names the key in the JWKS.
+func (state *RuntimeState) genNewSerializedServiceToken(
+	claims serviceTokenClaims) (string, error) {
+	state.Mutex.RLock()
+	keySigner := state.Signer
+	state.Mutex.RUnlock()
+	kid, err := getKeyFingerprint(keySigner.Public())
+	if err != nil {
+		return "", err
+	}
+	signerOptions := (&jose.SignerOptions{}).WithType("JWT").
+		WithHeader("kid", kid)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256,
+		Key: keySigner}, signerOptions)
+	if err != nil {
+		return "", err
+	}
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
+
+// serviceTokenHandler issues a JWT for the audience form value, with the
+// groups of the user. Clients authenticate with a keymaster certificate or a
+// session with a second factor.
+func (state *RuntimeState) serviceTokenHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if !state.Config.ServiceTokens.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authData, err := state.checkAuth(w, r,
+		AuthTypeKeymasterX509|secondFactorAuthTypes)
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authData.Username)
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	expiresAt := authData.ExpiresAt
+	if authData.AuthType == AuthTypeKeymasterX509 {
+		userCert := r.TLS.VerifiedChains[0][0]
+		revoked, err := state.isCertificateRevoked(revocationTypeX509,
+			userCert.SerialNumber.String())
+		if err != nil {
+			logger.Println(err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"")
+			return
+		}
+		if revoked {
+			state.writeFailureResponse(w, r, http.StatusForbidden,
+				"Certificate revoked")
+			return
+		}
+		expiresAt = userCert.NotAfter
+	}
+	if err := r.ParseForm(); err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	audience := r.Form.Get("audience")
+	if !state.Config.ServiceTokens.allowsAudience(audience) {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid audience")
+		return
+	}
+	duration := defaultServiceTokenLifetime
+	if formDuration := r.Form.Get("duration"); formDuration != "" {
+		duration, err = time.ParseDuration(formDuration)
+		if err != nil || duration <= 0 {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Error parsing form (duration)")
+			return
+		}
+	}
+	maxLifetime := state.Config.ServiceTokens.getMaxLifetime()
+	if duration > maxLifetime {
+		duration = maxLifetime
+	}
+	now := time.Now()
+	if !expiresAt.IsZero() && expiresAt.Before(now.Add(duration)) {
+		duration = expiresAt.Sub(now)
+	}
+	groups, err := state.getUserGroups(authData.Username)
+	if err != nil {
+		logger.Printf("cannot get groups of: %s: %s", authData.Username, err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	id, err := genRandomString()
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	claims := serviceTokenClaims{
+		Issuer:     state.idpGetIssuer(),
+		Subject:    authData.Username,
+		Audience:   []string{audience},
+		Expiration: now.Add(duration).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		ID:         id,
+		Groups:     groups,
+		TokenType:  serviceTokenType,
+	}
+	token, err := state.genNewSerializedServiceToken(claims)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	logger.Printf("issued service token: %s to: %s for: %s", id,
+		authData.Username, audience)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(proto.ServiceTokenResponse{
+		Token:     token,
+		ExpiresAt: claims.Expiration,
+	})
+	if err != nil {
+		logger.Printf("json encoding error: %v", err)
+	}
+}
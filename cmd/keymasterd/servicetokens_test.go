@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestServiceTokenHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	newTokenRequest := func(authType int, audience string) *http.Request {
+		cookieVal, err := state.setNewAuthCookie(nil, "username", authType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{"audience": {audience}, "duration": {"2h"}}
+		req, err := http.NewRequest("POST", proto.ServiceTokenPath,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		return req
+	}
+	_, err = checkRequestHandlerCode(newTokenRequest(AuthTypeU2F, "billing"),
+		state.serviceTokenHandler, http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.ServiceTokens.Enabled = true
+	state.Config.ServiceTokens.Audiences = []string{"billing"}
+	_, err = checkRequestHandlerCode(newTokenRequest(AuthTypePassword,
+		"billing"), state.serviceTokenHandler, http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(newTokenRequest(AuthTypeU2F, "payroll"),
+		state.serviceTokenHandler, http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(newTokenRequest(AuthTypeU2F,
+		"billing"), state.serviceTokenHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response proto.ServiceTokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := jwt.ParseSigned(response.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, err := getKeyFingerprint(state.Signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tok.Headers) != 1 || tok.Headers[0].KeyID != kid {
+		t.Errorf("unexpected headers: %+v", tok.Headers)
+	}
+	var claims serviceTokenClaims
+	if err := tok.Claims(state.Signer.Public(), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "username" || len(claims.Audience) != 1 ||
+		claims.Audience[0] != "billing" ||
+		claims.TokenType != serviceTokenType {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	lifetime := time.Duration(claims.Expiration-claims.IssuedAt) * time.Second
+	if lifetime != defaultMaxServiceTokenLifetime {
+		t.Errorf("unexpected lifetime: %s", lifetime)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sessionRevocationSyncInterval is how long revocations made by another
+// replica may take to apply here.
+const sessionRevocationSyncInterval = 10 * time.Second
+
+// A revoked_session row with an empty session_id revokes all the sessions of
+// the user issued before revoked_epoch. API tokens carry the ID and issue
+// time of their session, so they are revoked with it.
+var insertRevokedSessionStmt = map[string]string{
+	"sqlite":   "insert into revoked_session(session_id, username, revoked_epoch, expiration_epoch, revoked_by) values(?, ?, ?, ?, ?)",
+	"postgres": "insert into revoked_session(session_id, username, revoked_epoch, expiration_epoch, revoked_by) values($1, $2, $3, $4, $5)",
+}
+
+var getRevokedSessionsStmt = map[string]string{
+	"sqlite":   "select session_id, username, revoked_epoch, expiration_epoch from revoked_session where expiration_epoch >= ?",
+	"postgres": "select session_id, username, revoked_epoch, expiration_epoch from revoked_session where expiration_epoch >= $1",
+}
+
+// recordSessionRevocation persists the revocation of the session with the
+// specified ID, or of all sessions of username if sessionID is empty, so
+// that it survives restarts and reaches the other replicas.
+func (state *RuntimeState) recordSessionRevocation(username, sessionID,
+	revokedBy string) error {
+	now := time.Now()
+	_, err := state.db.Exec(insertRevokedSessionStmt[state.dbType],
+		sessionID, username, now.Truncate(time.Second).Unix(),
+		now.Add(maxAgeSecondsAuthCookie*time.Second).Unix(), revokedBy)
+	return err
+}
+
+// loadSessionRevocations merges the unexpired revocations from the database
+// into the session registry.
+func (state *RuntimeState) loadSessionRevocations() error {
+	rows, err := state.db.Query(getRevokedSessionsStmt[state.dbType],
+		time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	ids := make(map[string]time.Time)
+	usernames := make(map[string]time.Time)
+	for rows.Next() {
+		var sessionID, username string
+		var revokedEpoch, expirationEpoch int64
+		err := rows.Scan(&sessionID, &username, &revokedEpoch,
+			&expirationEpoch)
+		if err != nil {
+			return err
+		}
+		if sessionID != "" {
+			ids[sessionID] = time.Unix(expirationEpoch, 0)
+			continue
+		}
+		revokedBefore := time.Unix(revokedEpoch, 0)
+		if revokedBefore.After(usernames[username]) {
+			usernames[username] = revokedBefore
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	state.sessions.addRevocations(ids, usernames)
+	return nil
+}
+
+func (state *RuntimeState) syncSessionRevocations() {
+	for {
+		if err := state.loadSessionRevocations(); err != nil {
+			logger.Printf("error loading session revocations: %s", err)
+		}
+		time.Sleep(sessionRevocationSyncInterval)
+	}
+}
+
+func cleanupRevokedSessions(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(
+		"DELETE from revoked_session WHERE expiration_epoch < %d",
+		time.Now().Unix()))
+	return err
+}
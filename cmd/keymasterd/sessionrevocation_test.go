@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSessionRevocationsAreShared(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	cookieValue, err := state.setNewAuthCookie(nil, "target", AuthTypePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := state.getAuthInfoFromAuthJWT(cookieValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.recordSessionRevocation("target", info.SessionID,
+		"alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.recordSessionRevocation("other", "", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	// Another replica (or this one after a restart) only knows the database.
+	state.sessions = sessionRegistry{}
+	if _, err := state.getAuthInfoFromAuthJWT(cookieValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.loadSessionRevocations(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.getAuthInfoFromAuthJWT(cookieValue); err == nil {
+		t.Fatal("revoked session still accepted")
+	}
+	if !state.sessions.isSessionRevoked("", "other",
+		time.Now().Add(-time.Minute)) {
+		t.Fatal("sessions of other not revoked")
+	}
+	if err := cleanupRevokedSessions(state.db); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultMaxSessions = 100000
+
+var (
+	sessionsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "keymaster_sessions",
+		Help: "Number of sessions in the session registry.",
+	})
+	sessionEvictionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keymaster_session_evictions_total",
+			Help: "Sessions removed from the session registry.",
+		},
+		[]string{"reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(sessionsGauge)
+	prometheus.MustRegister(sessionEvictionsCounter)
+}
+
+// sessionInfo describes a web session, which is backed by an auth cookie.
+type sessionInfo struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	AuthType  int       `json:"auth_type"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// sessionRegistry tracks the sessions issued by this instance so that admins
+// can list and revoke them. Sessions are still validated from the signed
+// cookie alone, so the registry is advisory except for revocations. It is
+// kept in memory: sessions issued by other replicas (or before a restart)
+// are not listed. At most capacity sessions are kept; when full, expired
+// sessions and then the least recently used ones are forgotten. Revocations
+// are never evicted before they expire. The zero value is ready to use.
+// Revocations made by other replicas are merged in by addRevocations.
+type sessionRegistry struct {
+	capacity int // Default: defaultMaxSessions.
+	mutex    sync.Mutex
+	sessions map[string]*list.Element // Key: session ID.
+	lru      list.List                // Of sessionInfo, most recent first.
+	revoked  map[string]time.Time     // Key: session ID, value: expiration.
+	// Key: username, value: sessions issued before then are revoked.
+	revokedBefore map[string]time.Time
+}
+
+// removeLocked forgets the session in element, counting it as evicted for
+// reason unless reason is empty.
+func (registry *sessionRegistry) removeLocked(element *list.Element,
+	reason string) {
+	session := registry.lru.Remove(element).(sessionInfo)
+	delete(registry.sessions, session.ID)
+	if reason != "" {
+		sessionEvictionsCounter.WithLabelValues(reason).Inc()
+	}
+	sessionsGauge.Set(float64(registry.lru.Len()))
+}
+
+// makeRoomLocked evicts sessions until there is room for one more.
+func (registry *sessionRegistry) makeRoomLocked(now time.Time) {
+	capacity := registry.capacity
+	if capacity < 1 {
+		capacity = defaultMaxSessions
+	}
+	if registry.lru.Len() < capacity {
+		return
+	}
+	registry.removeExpiredLocked(now)
+	for registry.lru.Len() >= capacity {
+		registry.removeLocked(registry.lru.Back(), "capacity")
+	}
+}
+
+func (registry *sessionRegistry) removeExpiredLocked(now time.Time) {
+	for element := registry.lru.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(sessionInfo).ExpiresAt.Before(now) {
+			registry.removeLocked(element, "expired")
+		}
+		element = next
+	}
+}
+
+func (registry *sessionRegistry) add(session sessionInfo) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.sessions == nil {
+		registry.sessions = make(map[string]*list.Element)
+	}
+	if element, ok := registry.sessions[session.ID]; ok {
+		registry.removeLocked(element, "")
+	}
+	registry.makeRoomLocked(time.Now())
+	registry.sessions[session.ID] = registry.lru.PushFront(session)
+	sessionsGauge.Set(float64(registry.lru.Len()))
+}
+
+func (registry *sessionRegistry) updateAuthType(id string, authType int) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if element, ok := registry.sessions[id]; ok {
+		session := element.Value.(sessionInfo)
+		session.AuthType = authType
+		element.Value = session
+		registry.lru.MoveToFront(element)
+	}
+}
+
+func (registry *sessionRegistry) isRevoked(id string) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if _, revoked := registry.revoked[id]; revoked {
+		return true
+	}
+	if element, ok := registry.sessions[id]; ok {
+		registry.lru.MoveToFront(element)
+	}
+	return false
+}
+
+// isSessionRevoked returns true if the session with the specified ID was
+// revoked, or if username revoked all sessions after issuedAt.
+func (registry *sessionRegistry) isSessionRevoked(id, username string,
+	issuedAt time.Time) bool {
+	if id != "" && registry.isRevoked(id) {
+		return true
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	revokedBefore, ok := registry.revokedBefore[username]
+	return ok && issuedAt.Before(revokedBefore)
+}
+
+func (registry *sessionRegistry) revokeBeforeLocked(username string,
+	revokedBefore time.Time) {
+	if registry.revokedBefore == nil {
+		registry.revokedBefore = make(map[string]time.Time)
+	}
+	if revokedBefore.After(registry.revokedBefore[username]) {
+		registry.revokedBefore[username] = revokedBefore
+	}
+}
+
+// addRevocations merges revocations of session IDs (value: expiration) and
+// of all sessions of users (value: sessions issued before then).
+func (registry *sessionRegistry) addRevocations(ids map[string]time.Time,
+	usernames map[string]time.Time) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	for id, expiresAt := range ids {
+		if _, ok := registry.revoked[id]; ok {
+			continue
+		}
+		if element, ok := registry.sessions[id]; ok {
+			registry.removeLocked(element, "")
+		}
+		if registry.revoked == nil {
+			registry.revoked = make(map[string]time.Time)
+		}
+		registry.revoked[id] = expiresAt
+	}
+	for username, revokedBefore := range usernames {
+		registry.revokeBeforeLocked(username, revokedBefore)
+	}
+}
+
+func (registry *sessionRegistry) revokeLocked(id string) {
+	var expiresAt time.Time
+	if element, ok := registry.sessions[id]; ok {
+		expiresAt = element.Value.(sessionInfo).ExpiresAt
+		registry.removeLocked(element, "")
+	}
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(maxAgeSecondsAuthCookie * time.Second)
+	}
+	if registry.revoked == nil {
+		registry.revoked = make(map[string]time.Time)
+	}
+	registry.revoked[id] = expiresAt
+}
+
+// revoke revokes the session with the specified ID, provided it belongs to
+// username. It returns false if the session is unknown.
+func (registry *sessionRegistry) revoke(username, id string) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if element, ok := registry.sessions[id]; !ok ||
+		element.Value.(sessionInfo).Username != username {
+		return false
+	}
+	registry.revokeLocked(id)
+	return true
+}
+
+// revokeUser revokes all sessions of username issued until now, including
+// those issued by other replicas, and returns how many known sessions were
+// revoked.
+func (registry *sessionRegistry) revokeUser(username string) int {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.revokeBeforeLocked(username, time.Now().Truncate(time.Second))
+	var ids []string
+	for element := registry.lru.Front(); element != nil; element = element.Next() {
+		if session := element.Value.(sessionInfo); session.Username == username {
+			ids = append(ids, session.ID)
+		}
+	}
+	for _, id := range ids {
+		registry.revokeLocked(id)
+	}
+	return len(ids)
+}
+
+// list returns the active sessions of username (or of all users if username
+// is empty), newest first.
+func (registry *sessionRegistry) list(username string) []sessionInfo {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	now := time.Now()
+	var sessions []sessionInfo
+	for element := registry.lru.Front(); element != nil; element = element.Next() {
+		session := element.Value.(sessionInfo)
+		if username != "" && session.Username != username {
+			continue
+		}
+		if session.ExpiresAt.Before(now) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].IssuedAt.After(sessions[j].IssuedAt)
+	})
+	return sessions
+}
+
+func (registry *sessionRegistry) countByUser() map[string]int {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	now := time.Now()
+	counts := make(map[string]int)
+	for element := registry.lru.Front(); element != nil; element = element.Next() {
+		if session := element.Value.(sessionInfo); session.ExpiresAt.After(now) {
+			counts[session.Username]++
+		}
+	}
+	return counts
+}
+
+// cleanup forgets expired sessions and revocations, since the cookies they
+// refer to are no longer accepted anyway.
+func (registry *sessionRegistry) cleanup() {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	now := time.Now()
+	registry.removeExpiredLocked(now)
+	for id, expiresAt := range registry.revoked {
+		if expiresAt.Before(now) {
+			delete(registry.revoked, id)
+		}
+	}
+	oldest := now.Add(-maxAgeSecondsAuthCookie * time.Second)
+	for username, revokedBefore := range registry.revokedBefore {
+		if revokedBefore.Before(oldest) {
+			delete(registry.revokedBefore, username)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSessionRegistryEviction(t *testing.T) {
+	registry := sessionRegistry{capacity: 3}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		registry.add(sessionInfo{
+			ID:        fmt.Sprintf("session%d", i),
+			Username:  "user",
+			ExpiresAt: now.Add(time.Hour),
+		})
+	}
+	// Using session0 makes session1 the least recently used.
+	if registry.isRevoked("session0") {
+		t.Fatal("session0 revoked")
+	}
+	registry.add(sessionInfo{ID: "session3", Username: "user",
+		ExpiresAt: now.Add(time.Hour)})
+	if _, ok := registry.sessions["session1"]; ok {
+		t.Fatal("least recently used session not evicted")
+	}
+	if len(registry.list("user")) != 3 {
+		t.Fatalf("expected 3 sessions, got %d", len(registry.list("user")))
+	}
+	// Revocations survive eviction of the session.
+	if !registry.revoke("user", "session0") {
+		t.Fatal("session0 not revoked")
+	}
+	for i := 5; i < 10; i++ {
+		registry.add(sessionInfo{ID: fmt.Sprintf("session%d", i),
+			Username: "user", ExpiresAt: now.Add(time.Hour)})
+	}
+	if !registry.isRevoked("session0") {
+		t.Fatal("revocation of session0 lost")
+	}
+	if registry.lru.Len() != 3 || len(registry.sessions) != 3 {
+		t.Fatalf("registry holds %d/%d sessions", registry.lru.Len(),
+			len(registry.sessions))
+	}
+	if count := registry.revokeUser("user"); count != 3 {
+		t.Fatalf("expected 3 revocations, got %d", count)
+	}
+	// Expired sessions are evicted before the least recently used one.
+	registry = sessionRegistry{capacity: 2}
+	registry.add(sessionInfo{ID: "expired", ExpiresAt: now.Add(-time.Hour)})
+	registry.add(sessionInfo{ID: "old", ExpiresAt: now.Add(time.Hour)})
+	registry.updateAuthType("expired", AuthTypeU2F)
+	registry.add(sessionInfo{ID: "new", ExpiresAt: now.Add(time.Hour)})
+	for id, expected := range map[string]bool{
+		"expired": false, "old": true, "new": true} {
+		if _, ok := registry.sessions[id]; ok != expected {
+			t.Errorf("%s: present=%v, expected %v", id, ok, expected)
+		}
+	}
+}
+
+func TestSessionRegistryRevokedBefore(t *testing.T) {
+	var registry sessionRegistry
+	now := time.Now()
+	registry.addRevocations(map[string]time.Time{"remote": now.Add(time.Hour)},
+		map[string]time.Time{"user": now})
+	if !registry.isSessionRevoked("remote", "other", now) {
+		t.Error("remote session not revoked")
+	}
+	if !registry.isSessionRevoked("old", "user", now.Add(-time.Minute)) {
+		t.Error("session issued before revocation not revoked")
+	}
+	if registry.isSessionRevoked("new", "user", now.Add(time.Minute)) {
+		t.Error("session issued after revocation revoked")
+	}
+	if registry.isSessionRevoked("old", "other", now.Add(-time.Minute)) {
+		t.Error("session of other user revoked")
+	}
+	// Older revocations do not undo newer ones.
+	registry.addRevocations(nil,
+		map[string]time.Time{"user": now.Add(-time.Hour)})
+	if !registry.isSessionRevoked("old", "user", now.Add(-time.Minute)) {
+		t.Error("revocation undone")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+)
+
+const defaultRemoteManifestLifetime = 30 * 24 * time.Hour
+
+// makeRemoteManifest returns the signed manifest for data, served from
+// location.
+func makeRemoteManifest(signer crypto.Signer, data []byte, location string,
+	serial uint64, expiresAt time.Time) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	manifest, err := json.Marshal(remoteManifest{
+		Location:  location,
+		SHA256:    hex.EncodeToString(digest[:]),
+		Serial:    serial,
+		ExpiresAt: expiresAt.UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifestDigest := sha256.Sum256(manifest)
+	signature, err := signDigest(signer, manifestDigest[:])
+	if err != nil {
+		return nil, err
+	}
+	signed, err := json.MarshalIndent(signedRemoteManifest{
+		Manifest:  string(manifest),
+		Signature: signature,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(signed, '\n'), nil
+}
+
+// signConfig implements "keymasterd signconfig", which writes the signed
+// manifest of a file to be served as, or referenced by, a remote
+// configuration.
+func signConfig(args []string) error {
+	flagSet := flag.NewFlagSet("signconfig", flag.ContinueOnError)
+	keyFilename := flagSet.String("key", "",
+		"The PEM private key of the configuration signing key")
+	location := flagSet.String("location", "",
+		"The HTTPS or S3 URL the file is served from")
+	serial := flagSet.Uint64("serial", 0,
+		"The serial number, which must not decrease between versions")
+	lifetime := flagSet.Duration("lifetime", defaultRemoteManifestLifetime,
+		"How long the manifest is valid")
+	output := flagSet.String("output", "",
+		"The manifest file to write. Default: the file with .manifest appended")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 1 {
+		return fmt.Errorf("expected one file to sign, got: %s",
+			strings.Join(flagSet.Args(), " "))
+	}
+	if *keyFilename == "" {
+		return errors.New("no -key specified")
+	}
+	if !isRemoteConfig(*location) {
+		return fmt.Errorf("-location: %s is not an HTTPS or S3 URL",
+			*location)
+	}
+	if *serial < 1 {
+		return errors.New("no -serial specified")
+	}
+	if *lifetime <= 0 {
+		return errors.New("-lifetime must be positive")
+	}
+	filename := flagSet.Arg(0)
+	if *output == "" {
+		*output = filename + remoteManifestSuffix
+	}
+	keyData, err := ioutil.ReadFile(*keyFilename)
+	if err != nil {
+		return err
+	}
+	signer, err := certgen.GetSignerFromPEMBytes(keyData)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(*lifetime)
+	manifest, err := makeRemoteManifest(signer, data, *location, *serial,
+		expiresAt)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*output, manifest, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote manifest for: %s, serial: %d, expires: %s to: %s\n",
+		*location, *serial, expiresAt.Format(time.RFC3339), *output)
+	return nil
+}
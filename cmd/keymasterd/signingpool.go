@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto"
+	"errors"
+	"net/http"
+
+	"github.com/Cloud-Foundations/keymaster/keymasterd/signingpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var signingRejectedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "keymaster_signing_rejected_total",
+		Help: "Signing requests rejected because the signing pool was overloaded.",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	prometheus.MustRegister(signingRejectedCounter)
+}
+
+// getRequestSigner returns a signer which signs with keySigner using the
+// signing pool, bounded by the lifetime of the request.
+func (state *RuntimeState) getRequestSigner(r *http.Request,
+	keySigner crypto.Signer) crypto.Signer {
+	return state.signingPool.Signer(r.Context(),
+		state.faultInjector.Signer(keySigner))
+}
+
+// writeSigningFailureResponse responds to a failed signing operation. If the
+// signing pool was overloaded the client is asked to retry later.
+func (state *RuntimeState) writeSigningFailureResponse(w http.ResponseWriter,
+	r *http.Request, err error) {
+	if !signingpool.IsOverloaded(err) {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	reason := "timeout"
+	if errors.Is(err, signingpool.ErrQueueFull) {
+		reason = "queue_full"
+	}
+	signingRejectedCounter.WithLabelValues(reason).Inc()
+	w.Header().Set("Retry-After", "1")
+	state.writeFailureResponse(w, r, http.StatusServiceUnavailable,
+		"Signing capacity exceeded, retry later")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+)
+
+const (
+	defaultSourceAddressIPv4PrefixLength = 32
+	defaultSourceAddressIPv6PrefixLength = 64
+)
+
+// sshSourceAddressConfig pins SSH user certificates to the address the
+// request came from, with the source-address critical option, so that a
+// stolen certificate cannot be used from elsewhere. Clients in the excluded
+// networks, typically NAT ranges whose hosts reach the SSH servers from
+// other addresses, get certificates which are not pinned.
+type sshSourceAddressConfig struct {
+	Enabled          bool     `yaml:"enabled"`
+	IPv4PrefixLength int      `yaml:"ipv4_prefix_length"` // Default: 32.
+	IPv6PrefixLength int      `yaml:"ipv6_prefix_length"` // Default: 64.
+	ExcludedNetworks []string `yaml:"excluded_networks"`  // CIDRs.
+}
+
+func (config *sshSourceAddressConfig) check() error {
+	if config.IPv4PrefixLength < 0 || config.IPv4PrefixLength > 32 {
+		return fmt.Errorf("ssh_source_address: invalid ipv4_prefix_length: %d",
+			config.IPv4PrefixLength)
+	}
+	if config.IPv6PrefixLength < 0 || config.IPv6PrefixLength > 128 {
+		return fmt.Errorf("ssh_source_address: invalid ipv6_prefix_length: %d",
+			config.IPv6PrefixLength)
+	}
+	for _, cidr := range config.ExcludedNetworks {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("ssh_source_address: %s", err)
+		}
+	}
+	return nil
+}
+
+// getSourceAddress returns the value of the source-address critical option
+// for a client at remoteAddr, or "" if the certificate is not pinned.
+func (config *sshSourceAddressConfig) getSourceAddress(
+	remoteAddr string) string {
+	if !config.Enabled ||
+		addressInCIDRs(remoteAddr, config.ExcludedNetworks) {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	var mask net.IPMask
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		prefixLength := config.IPv4PrefixLength
+		if prefixLength < 1 {
+			prefixLength = defaultSourceAddressIPv4PrefixLength
+		}
+		mask = net.CIDRMask(prefixLength, 32)
+	} else {
+		prefixLength := config.IPv6PrefixLength
+		if prefixLength < 1 {
+			prefixLength = defaultSourceAddressIPv6PrefixLength
+		}
+		mask = net.CIDRMask(prefixLength, 128)
+	}
+	network := net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	return network.String()
+}
+
+// addSSHSourceAddress pins the SSH certificate of identity to the address
+// of the client which sent r, if so configured. The remote address of
+// requests relayed by trusted proxies is already that of the client.
+func (state *RuntimeState) addSSHSourceAddress(
+	identity certgen.UserIdentity, r *http.Request) certgen.UserIdentity {
+	config := state.Config.SSHSourceAddress
+	sourceAddress := config.getSourceAddress(r.RemoteAddr)
+	if sourceAddress == "" {
+		if config.Enabled {
+			logger.Debugf(1, "not pinning SSH certificate of %s to: %s",
+				identity.Username, r.RemoteAddr)
+		}
+		return identity
+	}
+	identity.SSHCriticalOptions = map[string]string{
+		"source-address": sourceAddress,
+	}
+	return identity
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSSHSourceAddressConfigCheck(t *testing.T) {
+	for _, config := range []sshSourceAddressConfig{
+		{IPv4PrefixLength: 33},
+		{IPv6PrefixLength: -1},
+		{ExcludedNetworks: []string{"10.0.0.1"}},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("invalid configuration accepted: %+v", config)
+		}
+	}
+}
+
+func TestGetSourceAddress(t *testing.T) {
+	config := sshSourceAddressConfig{
+		Enabled:          true,
+		ExcludedNetworks: []string{"100.64.0.0/10"},
+	}
+	for _, test := range []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{"203.0.113.7:5000", "203.0.113.7/32"},
+		{"[2001:db8:1:2:3:4:5:6]:5000", "2001:db8:1:2::/64"},
+		{"[::ffff:203.0.113.7]:5000", "203.0.113.7/32"},
+		{"100.64.1.2:5000", ""}, // Excluded.
+		{"@", ""},
+	} {
+		if sourceAddress := config.getSourceAddress(
+			test.remoteAddr); sourceAddress != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.remoteAddr,
+				test.expected, sourceAddress)
+		}
+	}
+	config.IPv4PrefixLength = 24
+	if sourceAddress := config.getSourceAddress(
+		"203.0.113.7:5000"); sourceAddress != "203.0.113.0/24" {
+		t.Errorf("unexpected source address: %s", sourceAddress)
+	}
+	config.Enabled = false
+	if sourceAddress := config.getSourceAddress(
+		"203.0.113.7:5000"); sourceAddress != "" {
+		t.Errorf("pinned while disabled: %s", sourceAddress)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Cloud-Foundations/keymaster/lib/krl"
+)
+
+// getSSHRevocationList returns a KRL revoking the unexpired revoked SSH
+// certificates. The serials are revoked for every trusted CA key, including
+// the ones which are no longer used for signing but whose certificates may
+// still be valid. The version is the time of the latest revocation, so it
+// only changes when the list does.
+func (state *RuntimeState) getSSHRevocationList() (*krl.KRL, error) {
+	serialStrings, latest, err := state.getRevokedSerials(revocationTypeSSH)
+	if err != nil {
+		return nil, err
+	}
+	serials := make([]uint64, 0, len(serialStrings))
+	for _, serialString := range serialStrings {
+		serial, err := strconv.ParseUint(serialString, 10, 64)
+		if err != nil {
+			logger.Printf("ignoring invalid revoked SSH serial: %s",
+				serialString)
+			continue
+		}
+		serials = append(serials, serial)
+	}
+	revocationList := &krl.KRL{
+		GeneratedAt: latest,
+		Comment:     state.HostIdentity,
+	}
+	if !latest.IsZero() {
+		revocationList.Version = uint64(latest.Unix())
+	}
+	keys, err := state.getSSHCAPublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		revocationList.CertificateSections = append(
+			revocationList.CertificateSections, krl.CertificateSection{
+				CA:      key.key,
+				Serials: serials,
+			})
+	}
+	return revocationList, nil
+}
+
+// writeSSHRevokedKeys writes the KRL for the sshd RevokedKeys option.
+func (state *RuntimeState) writeSSHRevokedKeys(w http.ResponseWriter,
+	r *http.Request) {
+	revocationList, err := state.getSSHRevocationList()
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Disposition",
+		`attachment; filename="revoked_keys"`)
+	writeWithETag(w, r, "application/octet-stream", revocationList.Marshal())
+}
@@ -0,0 +1,58 @@
+// Logs in with a passkey from the login page, without a username. Uses the
+// helpers in keymaster-webauthn.js. Scripts on the login page load
+// asynchronously, so jQuery is not used.
+
+function passkeyLoginFailed(message) {
+  console.log(message);
+  alert('Passkey login failed: ' + message);
+}
+
+function passkeyLogin() {
+  fetch('/webauthn/PasskeyLoginRequest').then(function(response) {
+    if (!response.ok) {
+      throw new Error('server error code ' + response.status);
+    }
+    return response.json();
+  }).then(function(options) {
+    var challenge = options.challenge;
+    options.challenge = base64urlToBuffer(options.challenge);
+    return navigator.credentials.get({publicKey: options}).then(function(assertion) {
+      var resp = {
+        id: bufferToBase64url(assertion.rawId),
+        clientDataJSON: bufferToBase64url(assertion.response.clientDataJSON),
+        authenticatorData: bufferToBase64url(assertion.response.authenticatorData),
+        signature: bufferToBase64url(assertion.response.signature),
+        userHandle: bufferToBase64url(assertion.response.userHandle || new ArrayBuffer(0)),
+        challenge: challenge
+      };
+      return fetch('/webauthn/PasskeyLoginResponse', {
+        method: 'POST',
+        credentials: 'same-origin',
+        body: JSON.stringify(resp)
+      });
+    });
+  }).then(function(response) {
+    if (!response.ok) {
+      throw new Error('server error code ' + response.status);
+    }
+    var destination = document.getElementById('passkey_login_destination').textContent;
+    window.location.href = destination || '/';
+  }).catch(function(err) {
+    passkeyLoginFailed(err.name + ': ' + err.message);
+  });
+}
+
+function setupPasskeyLogin() {
+  var button = document.getElementById('passkey_login_button');
+  // keymaster-webauthn.js may not have loaded yet.
+  if (!button || window.PublicKeyCredential === undefined) {
+    return;
+  }
+  button.addEventListener('click', passkeyLogin);
+}
+
+if (document.readyState === 'loading') {
+  document.addEventListener('DOMContentLoaded', setupPasskeyLogin);
+} else {
+  setupPasskeyLogin();
+}
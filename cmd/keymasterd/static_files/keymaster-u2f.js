@@ -36,9 +36,26 @@ function checkError(resp) {
       location.reload();
     }).fail(serverError);
   }
+  function webauthnFailed(err) {
+    ['register_action_text', 'auth_action_text'].forEach(function(id) {
+      var element = document.getElementById(id);
+      if (element) {
+        element.style.display="none";
+      }
+    });
+    console.log(err);
+    alert('Security key error: ' + err.name + ': ' + err.message);
+  }
   function register() {
     var username = document.getElementById('username').textContent;
     document.getElementById('register_action_text').style.display="block";
+    if (webauthnSupported()) {
+      webauthnRegister(username, function() {
+        alert('Success');
+        location.reload();
+      }, webauthnFailed);
+      return;
+    }
     $.getJSON('/u2f/RegisterRequest/' + username).done(function(req) {
       console.log(req);
       if (req.registeredKeys == null) {
@@ -47,6 +64,14 @@ function checkError(resp) {
       u2f.register(req.appId, req.registerRequests, req.registeredKeys, u2fRegistered, 30);
     }).fail(serverError);
   }
+  function registerPasskey() {
+    var username = document.getElementById('username').textContent;
+    document.getElementById('register_action_text').style.display="block";
+    webauthnRegister(username, function() {
+      alert('Success');
+      location.reload();
+    }, webauthnFailed, true);
+  }
   function u2fSigned(resp) {
     document.getElementById('auth_action_text').style.display="none";
     console.log(resp);
@@ -59,6 +84,13 @@ function checkError(resp) {
   }
   function sign() {
      document.getElementById('auth_action_text').style.display="block";
+    if (webauthnSupported()) {
+      webauthnSign(function() {
+        document.getElementById('auth_action_text').style.display="none";
+        alert('Success');
+      }, webauthnFailed);
+      return;
+    }
     $.getJSON('/u2f/SignRequest').done(function(req) {
       console.log(req);
       u2f.sign(req.appId, req.challenge, req.registeredKeys, u2fSigned, 30);
@@ -68,5 +100,9 @@ function checkError(resp) {
 document.addEventListener('DOMContentLoaded', function () {
 	  document.getElementById('auth_button').addEventListener('click', sign);
 	  document.getElementById('register_button').addEventListener('click', register);
+	  var passkeyButton = document.getElementById('register_passkey_button');
+	  if (passkeyButton) {
+	    passkeyButton.addEventListener('click', registerPasskey);
+	  }
 	  //  main();
 });
@@ -0,0 +1,82 @@
+// Helpers for the WebAuthn API, which replaces the U2F API and is the only
+// one available on phones and tablets. The server sends binary values
+// base64url encoded.
+
+function webauthnSupported() {
+  return window.PublicKeyCredential !== undefined &&
+      navigator.credentials !== undefined;
+}
+
+function base64urlToBuffer(value) {
+  var base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+  while (base64.length % 4) {
+    base64 += '=';
+  }
+  var binary = atob(base64);
+  var bytes = new Uint8Array(binary.length);
+  for (var i = 0; i < binary.length; i++) {
+    bytes[i] = binary.charCodeAt(i);
+  }
+  return bytes.buffer;
+}
+
+function bufferToBase64url(buffer) {
+  var bytes = new Uint8Array(buffer);
+  var binary = '';
+  for (var i = 0; i < bytes.length; i++) {
+    binary += String.fromCharCode(bytes[i]);
+  }
+  return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+function webauthnServerError(data) {
+  console.log(data);
+  alert('Server error code ' + data.status + ': ' + data.responseText);
+}
+
+// webauthnRegister registers a new credential for username. If passkey is
+// true, the credential is stored on the authenticator so that it can be used
+// to log in without a username.
+function webauthnRegister(username, onSuccess, onFailure, passkey) {
+  var query = passkey ? '?passkey=true' : '';
+  $.getJSON('/webauthn/RegisterRequest/' + username + query).done(function(options) {
+    options.challenge = base64urlToBuffer(options.challenge);
+    options.user.id = base64urlToBuffer(options.user.id);
+    options.excludeCredentials.forEach(function(credential) {
+      credential.id = base64urlToBuffer(credential.id);
+    });
+    navigator.credentials.create({publicKey: options}).then(function(credential) {
+      var resp = {
+        id: credential.id,
+        clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+        attestationObject: bufferToBase64url(credential.response.attestationObject)
+      };
+      $.post('/webauthn/RegisterResponse/' + username + query, JSON.stringify(resp))
+          .done(onSuccess).fail(webauthnServerError);
+    }).catch(onFailure);
+  }).fail(webauthnServerError);
+}
+
+// webauthnSign authenticates with any of the registered credentials.
+// Browsers may refuse to start unless called from a user gesture, in which
+// case onFailure receives a NotAllowedError.
+function webauthnSign(onSuccess, onFailure) {
+  $.getJSON('/webauthn/SignRequest').done(function(options) {
+    options.challenge = base64urlToBuffer(options.challenge);
+    options.allowCredentials.forEach(function(credential) {
+      credential.id = base64urlToBuffer(credential.id);
+    });
+    navigator.credentials.get({publicKey: options}).then(function(assertion) {
+      var extensions = assertion.getClientExtensionResults();
+      var resp = {
+        id: bufferToBase64url(assertion.rawId),
+        clientDataJSON: bufferToBase64url(assertion.response.clientDataJSON),
+        authenticatorData: bufferToBase64url(assertion.response.authenticatorData),
+        signature: bufferToBase64url(assertion.response.signature),
+        appid: extensions.appid === true
+      };
+      $.post('/webauthn/SignResponse', JSON.stringify(resp))
+          .done(onSuccess).fail(webauthnServerError);
+    }).catch(onFailure);
+  }).fail(webauthnServerError);
+}
@@ -49,8 +49,24 @@ function checkError(resp) {
       window.location.href = destination;
     }).fail(serverError);
   }
+  function webauthnSigned() {
+    hideAllU2FElements();
+    var destination = document.getElementById("u2f_login_destination").innerHTML;
+    window.location.href = destination;
+  }
+  function webauthnFailed(err) {
+    console.log(err);
+    // Mobile browsers only allow WebAuthn from a user gesture, so offer a
+    // button to retry.
+    document.getElementById('webauthn_button').style.display="inline";
+  }
   function sign() {
      document.getElementById('auth_action_text').style.display="block";
+    if (webauthnSupported()) {
+      document.getElementById('webauthn_button').style.display="none";
+      webauthnSign(webauthnSigned, webauthnFailed);
+      return;
+    }
     $.getJSON('/u2f/SignRequest').done(function(req) {
       console.log(req);
       u2f.sign(req.appId, req.challenge, req.registeredKeys, u2fSigned, 45);
@@ -59,5 +75,6 @@ function checkError(resp) {
 
 document.addEventListener('DOMContentLoaded', function () {
 	  //document.getElementById('auth_button').addEventListener('click', sign);
+	  document.getElementById('webauthn_button').addEventListener('click', sign);
 	  sign();
 });
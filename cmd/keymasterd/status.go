@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// statusPath is served by the admin listener. It reports whether keymaster
+// is unsealed and its FIPS compliance, for monitoring and audits.
+const statusPath = "/status"
+
+type serverStatus struct {
+	Version string     `json:"version,omitempty"`
+	Ready   bool       `json:"ready"`
+	FIPS    fipsStatus `json:"fips"`
+}
+
+func (state *RuntimeState) getServerStatus() serverStatus {
+	state.Mutex.RLock()
+	ready := state.Signer != nil
+	state.Mutex.RUnlock()
+	return serverStatus{
+		Version: Version,
+		Ready:   ready,
+		FIPS:    state.getFIPSStatus(),
+	}
+}
+
+func (state *RuntimeState) statusHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state.getServerStatus()); err != nil {
+		state.logger.Println(err)
+	}
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"os"
@@ -13,6 +11,7 @@ import (
 
 	"github.com/Cloud-Foundations/golib/pkg/awsutil/metadata"
 	"github.com/Cloud-Foundations/golib/pkg/awsutil/secretsmgr"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/faultinjection"
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -108,15 +107,20 @@ func initDB(state *RuntimeState) (err error) {
 	switch splitString[0] {
 	case "sqlite":
 		logger.Printf("doing sqlite")
-		return initDBSQlite(state)
+		err = initDBSQlite(state)
 	case "postgresql":
 		logger.Printf("doing postgres")
-		return initDBPostgres(state)
+		err = initDBPostgres(state)
 	default:
 		logger.Printf("invalid storage url string")
 		err := errors.New("Bad storage url string")
 		return err
 	}
+	if err != nil {
+		return err
+	}
+	state.convertLegacyProfiles()
+	return nil
 }
 
 func initDBPostgres(state *RuntimeState) (err error) {
@@ -139,6 +143,88 @@ func initDBPostgres(state *RuntimeState) (err error) {
 			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
 			return err
 		}
+		sqlStmt = `create table if not exists issued_certificate(id serial not null primary key, username text not null, cert_type text not null, serial text not null, key_id text not null, fingerprint text not null, issued_epoch bigint not null, expiration_epoch bigint not null, source_address text not null);`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		sqlStmt = `create index if not exists issued_certificate_username on issued_certificate(username, issued_epoch);`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		sqlStmt = `create table if not exists revoked_certificate(id serial not null primary key, cert_type text not null, serial text not null, username text not null, revoked_epoch bigint not null, expiration_epoch bigint not null, reason text not null, revoked_by text not null, UNIQUE(cert_type,serial));`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		sqlStmt = `create table if not exists automation_token(id serial not null primary key, token_id text not null, token_hash text not null, principal text not null, cert_types text not null, max_lifetime_secs bigint not null, source_cidrs text not null, description text not null, created_epoch bigint not null, created_by text not null, expiration_epoch bigint not null, revoked_epoch bigint not null, revoked_by text not null, UNIQUE(token_id));`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		sqlStmt = `create table if not exists issuance_quota_override(id serial not null primary key, username text not null, max_certificates bigint not null, reset_epoch bigint not null, expiration_epoch bigint not null, set_by text not null, UNIQUE(username));`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		sqlStmt = `create table if not exists user_last_seen(id serial not null primary key, username text not null, last_seen_epoch bigint not null, UNIQUE(username));`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		sqlStmt = `create table if not exists webauthn_user_handle(id serial not null primary key, user_handle text not null, username text not null, UNIQUE(user_handle));`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		sqlStmt = `create table if not exists revoked_session(id serial not null primary key, session_id text not null, username text not null, revoked_epoch bigint not null, expiration_epoch bigint not null, revoked_by text not null);`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		sqlStmt = `create table if not exists host_cert_status(id serial not null primary key, host_name text not null, hostnames text not null, status text not null, error text not null, expiration_epoch bigint not null, key_created_epoch bigint not null, reported_epoch bigint not null, source_address text not null, UNIQUE(host_name));`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		sqlStmt = `create table if not exists audit_anchor(id serial not null primary key, anchored_epoch bigint not null, record_id bigint not null, chain_hash text not null, signature text not null);`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		sqlStmt = `create table if not exists escrowed_key(id serial not null primary key, username text not null, profile text not null, audit_id text not null, serial text not null, fingerprint text not null, escrowed_epoch bigint not null, expiration_epoch bigint not null, encrypted_key text not null);`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		for _, column := range issuedCertAuditColumns {
+			sqlStmt = `alter table issued_certificate add column if not exists ` +
+				column + ` text not null default ''`
+			_, err = state.db.Exec(sqlStmt)
+			if err != nil {
+				logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+				return err
+			}
+		}
+		for _, sqlStmt := range issuedCertIndexStatements {
+			_, err = state.db.Exec(sqlStmt)
+			if err != nil {
+				logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+				return err
+			}
+		}
 	}
 	// Ensure that broken connections are replaced.
 	state.db.SetConnMaxLifetime(state.Config.ProfileStorage.ConnectionLifetime)
@@ -161,6 +247,32 @@ func initDBSQlite(state *RuntimeState) (err error) {
 var sqliteinitializationStatements = []string{
 	`create table if not exists user_profile (id integer not null primary key, username text unique, profile_data blob);`,
 	`create table if not exists expiring_signed_user_data(id integer not null primary key, username text not null, jws_data text not null, type integer not null, expiration_epoch integer not null, update_epoch integer no null, UNIQUE(username,type));`,
+	`create table if not exists issued_certificate(id integer not null primary key, username text not null, cert_type text not null, serial text not null, key_id text not null, fingerprint text not null, issued_epoch integer not null, expiration_epoch integer not null, source_address text not null);`,
+	`create index if not exists issued_certificate_username on issued_certificate(username, issued_epoch);`,
+	`create table if not exists revoked_certificate(id integer not null primary key, cert_type text not null, serial text not null, username text not null, revoked_epoch integer not null, expiration_epoch integer not null, reason text not null, revoked_by text not null, UNIQUE(cert_type,serial));`,
+	`create table if not exists automation_token(id integer not null primary key, token_id text not null, token_hash text not null, principal text not null, cert_types text not null, max_lifetime_secs integer not null, source_cidrs text not null, description text not null, created_epoch integer not null, created_by text not null, expiration_epoch integer not null, revoked_epoch integer not null, revoked_by text not null, UNIQUE(token_id));`,
+	`create table if not exists issuance_quota_override(id integer not null primary key, username text not null, max_certificates integer not null, reset_epoch integer not null, expiration_epoch integer not null, set_by text not null, UNIQUE(username));`,
+	`create table if not exists user_last_seen(id integer not null primary key, username text not null, last_seen_epoch integer not null, UNIQUE(username));`,
+	`create table if not exists webauthn_user_handle(id integer not null primary key, user_handle text not null, username text not null, UNIQUE(user_handle));`,
+	`create table if not exists revoked_session(id integer not null primary key, session_id text not null, username text not null, revoked_epoch integer not null, expiration_epoch integer not null, revoked_by text not null);`,
+	`create table if not exists host_cert_status(id integer not null primary key, host_name text not null, hostnames text not null, status text not null, error text not null, expiration_epoch integer not null, key_created_epoch integer not null, reported_epoch integer not null, source_address text not null, UNIQUE(host_name));`,
+	`create table if not exists audit_anchor(id integer not null primary key, anchored_epoch integer not null, record_id integer not null, chain_hash text not null, signature text not null);`,
+	`create table if not exists escrowed_key(id integer not null primary key, username text not null, profile text not null, audit_id text not null, serial text not null, fingerprint text not null, escrowed_epoch integer not null, expiration_epoch integer not null, encrypted_key text not null);`,
+}
+
+// issuedCertAuditColumns were added to issued_certificate after it was
+// created, so existing databases are migrated on startup.
+var issuedCertAuditColumns = []string{
+	"audit_id", "auth_method", "user_agent", "client_version", "chain_hash",
+	"certificate", "tombstone"}
+
+// issuedCertIndexStatements index the columns issued certificates are looked
+// up by. They run after the columns are migrated.
+var issuedCertIndexStatements = []string{
+	`create index if not exists issued_certificate_audit_id on issued_certificate(audit_id);`,
+	`create index if not exists issued_certificate_serial on issued_certificate(serial);`,
+	`create index if not exists issued_certificate_fingerprint on issued_certificate(fingerprint);`,
+	`create index if not exists issued_certificate_issued_epoch on issued_certificate(issued_epoch);`,
 }
 
 func initializeSQLitetables(db *sql.DB) error {
@@ -172,6 +284,22 @@ func initializeSQLitetables(db *sql.DB) error {
 			return err
 		}
 	}
+	// sqlite does not support "add column if not exists".
+	for _, column := range issuedCertAuditColumns {
+		sqlStmt := `alter table issued_certificate add column ` + column +
+			` text not null default ''`
+		_, err := db.Exec(sqlStmt)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			logger.Printf("%s: %q\n", err, sqlStmt)
+			return err
+		}
+	}
+	for _, sqlStmt := range issuedCertIndexStatements {
+		if _, err := db.Exec(sqlStmt); err != nil {
+			logger.Printf("%s: %q\n", err, sqlStmt)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -219,6 +347,25 @@ func (state *RuntimeState) BackgroundDBCopy(initialSleep time.Duration) {
 		}
 		cleanupDBData(state.db)
 		cleanupDBData(state.cacheDB)
+		if err := cleanupIssuedCertificates(state.db,
+			state.Config.IssuedCertificates.getRetention()); err != nil {
+			logger.Printf("err='%s'", err)
+		}
+		if err := cleanupRevokedCertificates(state.db); err != nil {
+			logger.Printf("err='%s'", err)
+		}
+		if err := cleanupAutomationTokens(state.db); err != nil {
+			logger.Printf("err='%s'", err)
+		}
+		if err := cleanupIssuanceQuotaOverrides(state.db); err != nil {
+			logger.Printf("err='%s'", err)
+		}
+		if err := cleanupRevokedSessions(state.db); err != nil {
+			logger.Printf("err='%s'", err)
+		}
+		if err := cleanupHostCertStatus(state.db); err != nil {
+			logger.Printf("err='%s'", err)
+		}
 		time.Sleep(state.Config.ProfileStorage.SyncInterval)
 	}
 }
@@ -439,7 +586,18 @@ func (state *RuntimeState) LoadUserProfile(username string) (
 	defaultProfile.TOTPAuthData = make(map[int64]*totpAuthData)
 	ch := make(chan loadUserProfileData, 1)
 	start := time.Now()
+	defer func() { observeProfileLoad(start, ok, fromCache, err) }()
+	// While the primary DB is failing, go straight to the cache.
+	breaker := state.backendBreakers.Get(storageBackendName)
+	usePrimary := breaker.Allow()
+	timeout := state.remoteDBQueryTimeout
+	if !usePrimary {
+		timeout = 0
+	}
 	go func(username string) { //loads profile from DB
+		if !usePrimary {
+			return
+		}
 		var profileMessage loadUserProfileData
 		stmtText := loadUserProfileStmt[state.dbType]
 		stmt, err := state.db.Prepare(stmtText)
@@ -455,6 +613,11 @@ func (state *RuntimeState) LoadUserProfile(username string) (
 		if state.remoteDBQueryTimeout == 0 {
 			time.Sleep(10 * time.Millisecond)
 		}
+		err = state.faultInjector.Inject(faultinjection.TargetStorage)
+		if err != nil {
+			ch <- loadUserProfileData{Err: err}
+			return
+		}
 		profileMessage.Err = stmt.QueryRow(username).Scan(
 			&profileMessage.ProfileBytes)
 		ch <- profileMessage
@@ -466,17 +629,23 @@ func (state *RuntimeState) LoadUserProfile(username string) (
 		err = dbMessage.Err
 		if err != nil {
 			if err.Error() == "sql: no rows in result set" {
+				breaker.Success()
 				logger.Printf("err='%s'", err)
 				return &defaultProfile, false, fromCache, nil
 			} else {
+				breaker.Failure()
 				logger.Printf("Problem with db ='%s'", err)
 				return nil, false, fromCache, err
 			}
 		}
+		breaker.Success()
 		metricLogExternalServiceDuration("storage-read", time.Since(start))
 		profileBytes = dbMessage.ProfileBytes
-	case <-time.After(state.remoteDBQueryTimeout):
-		logger.Println("LoadUserProfile: timed out on primary DB")
+	case <-time.After(timeout):
+		if usePrimary {
+			breaker.Failure()
+			logger.Println("LoadUserProfile: timed out on primary DB")
+		}
 		fromCache = true
 		// load from cache
 		stmtText := loadUserProfileStmt["sqlite"]
@@ -500,12 +669,18 @@ func (state *RuntimeState) LoadUserProfile(username string) (
 		logger.Println("LoadUserProfile: got data from DB cache")
 	}
 	logger.Debugf(10, "profile bytes len=%d", len(profileBytes))
-	//gobReader := bytes.NewReader(fileBytes)
-	gobReader := bytes.NewReader(profileBytes)
-	decoder := gob.NewDecoder(gobReader)
-	err = decoder.Decode(&defaultProfile)
+	err = decodeUserProfile(profileBytes, &defaultProfile)
 	if err != nil {
-		return nil, false, fromCache, err
+		return nil, false, fromCache,
+			fmt.Errorf("cannot decode profile of %s: %s", username, err)
+	}
+	if err := migrateUserProfile(&defaultProfile); err != nil {
+		return nil, false, fromCache,
+			fmt.Errorf("profile of %s: %s", username, err)
+	}
+	if defaultProfile.readOnly {
+		logger.Printf("profile of %s has schema version %d, newer than %d",
+			username, defaultProfile.SchemaVersion, userProfileSchemaVersion)
 	}
 	logger.Debugf(1, "loaded profile=%+v", defaultProfile)
 	return &defaultProfile, true, fromCache, nil
@@ -517,13 +692,27 @@ var saveUserProfileStmt = map[string]string{
 }
 
 func (state *RuntimeState) SaveUserProfile(username string,
-	profile *userProfile) error {
-	var gobBuffer bytes.Buffer
-	encoder := gob.NewEncoder(&gobBuffer)
-	if err := encoder.Encode(profile); err != nil {
+	profile *userProfile) (err error) {
+	if profile.readOnly {
+		return errProfileTooNew
+	}
+	profile.SchemaVersion = userProfileSchemaVersion
+	profileData, err := encodeUserProfile(profile)
+	if err != nil {
 		return err
 	}
 	start := time.Now()
+	defer func() {
+		outcome := outcomeOK
+		if err != nil {
+			outcome = outcomeError
+		}
+		observeOperation(operationProfileSave, outcome, start)
+	}()
+	err = state.faultInjector.Inject(faultinjection.TargetStorage)
+	if err != nil {
+		return err
+	}
 	//insert into DB
 	tx, err := state.db.Begin()
 	if err != nil {
@@ -536,7 +725,7 @@ func (state *RuntimeState) SaveUserProfile(username string,
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(username, gobBuffer.Bytes())
+	_, err = stmt.Exec(username, profileData)
 	if err != nil {
 		return err
 	}
@@ -554,6 +743,10 @@ var deleteUserProfileStmt = map[string]string{
 }
 
 func (state *RuntimeState) DeleteUserProfile(username string) error {
+	err := state.faultInjector.Inject(faultinjection.TargetStorage)
+	if err != nil {
+		return err
+	}
 	//delete from DB
 	tx, err := state.db.Begin()
 	if err != nil {
@@ -570,6 +763,11 @@ func (state *RuntimeState) DeleteUserProfile(username string) error {
 	if err != nil {
 		return err
 	}
+	// Passkeys of the user can no longer be used to log in.
+	_, err = tx.Exec(deleteWebAuthnUserHandleStmt[state.dbType], username)
+	if err != nil {
+		return err
+	}
 	err = tx.Commit()
 	if err != nil {
 		return err
@@ -622,7 +820,16 @@ func (state *RuntimeState) GetSigned(username string,
 	//var jwsData string
 	ch := make(chan getSignedData, 1)
 	//start := time.Now()
+	breaker := state.backendBreakers.Get(storageBackendName)
+	usePrimary := breaker.Allow()
+	timeout := state.remoteDBQueryTimeout
+	if !usePrimary {
+		timeout = 0
+	}
 	go func(username string, dataType int) { //loads profile from DB
+		if !usePrimary {
+			return
+		}
 		var signedDataMessage getSignedData
 		stmtText := getSignedUserDataStmt[state.dbType]
 		stmt, err := state.db.Prepare(stmtText)
@@ -647,16 +854,22 @@ func (state *RuntimeState) GetSigned(username string,
 		if err != nil {
 			err = dbMessage.Err
 			if err.Error() == "sql: no rows in result set" {
+				breaker.Success()
 				logger.Printf("err='%s'", err)
 				return false, "", nil
 			} else {
+				breaker.Failure()
 				logger.Printf("Problem with db ='%s'", err)
 				return false, "", err
 			}
 		}
+		breaker.Success()
 		jwsData = dbMessage.JWSData
-	case <-time.After(state.remoteDBQueryTimeout):
-		logger.Println("GetSigned: timed out on primary DB")
+	case <-time.After(timeout):
+		if usePrimary {
+			breaker.Failure()
+			logger.Println("GetSigned: timed out on primary DB")
+		}
 		// load from cache
 		stmtText := getSignedUserDataStmt["sqlite"]
 		stmt, err := state.cacheDB.Prepare(stmtText)
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"database/sql"
 	"io/ioutil"
 	stdlog "log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/Cloud-Foundations/Dominator/lib/log/debuglogger"
 	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
@@ -102,3 +108,184 @@ func TestFetchFromCache(t *testing.T) {
 		t.Fatal("This should have failed for invalid user")
 	}
 }
+
+func TestIssuedCertificates(t *testing.T) {
+	state, tmpdir, err := newTestingState(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, expiresAt := range []time.Time{
+		now.Add(-issuedCertRetention - time.Hour),
+		now.Add(time.Hour),
+	} {
+		state.recordIssuedCertificate(issuedCertRecord{
+			Username:    "username",
+			CertType:    "ssh",
+			Serial:      strconv.Itoa(i),
+			Fingerprint: "SHA256:test",
+			IssuedAt:    now.Add(time.Duration(i) * time.Second),
+			ExpiresAt:   expiresAt,
+			SourceAddr:  "127.0.0.1:1234",
+		})
+	}
+	records, err := state.getIssuedCertificates("username", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Serial != "1" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if err := cleanupIssuedCertificates(state.db, issuedCertRetention); err != nil {
+		t.Fatal(err)
+	}
+	records, err = state.getIssuedCertificates("username", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Serial != "1" {
+		t.Fatalf("expired record not removed: %+v", records)
+	}
+}
+
+func TestIssuedCertificateAuditContext(t *testing.T) {
+	state, tmpdir, err := newTestingState(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	// Databases created before the audit columns existed must be migrated.
+	db, err := sql.Open("sqlite3", filepath.Join(tmpdir, profileDBFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`create table issued_certificate(id integer not null primary key, username text not null, cert_type text not null, serial text not null, key_id text not null, fingerprint text not null, issued_epoch integer not null, expiration_epoch integer not null, source_address text not null);`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	state.recordIssuedCertificate(issuedCertRecord{
+		Username:  "username",
+		CertType:  "ssh",
+		Serial:    "1",
+		KeyID:     "keymaster_username_0123456789ab",
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+		issuanceContext: issuanceContext{
+			AuditID:       "0123456789ab",
+			AuthMethod:    getAuthMethod(AuthTypePassword | AuthTypeU2F),
+			UserAgent:     "keymaster/1.2.3 (linux amd64)",
+			ClientVersion: "1.2.3",
+		},
+	})
+	records, err := state.getIssuedCertificatesForAuditID("0123456789ab", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Serial != "1" ||
+		records[0].AuthMethod != "password+U2F" ||
+		records[0].ClientVersion != "1.2.3" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	records, err = state.getIssuedCertificatesForAuditID("ba9876543210", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+}
+
+func TestSearchIssuedCertificates(t *testing.T) {
+	state, tmpdir, err := newTestingState(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Second)
+	for i, certType := range []string{"ssh", "x509", "ssh"} {
+		state.recordIssuedCertificate(issuedCertRecord{
+			Username:    "user" + strconv.Itoa(i%2),
+			CertType:    certType,
+			Serial:      strconv.Itoa(i),
+			Fingerprint: "SHA256:test" + strconv.Itoa(i),
+			IssuedAt:    now.Add(time.Duration(i) * time.Hour),
+			ExpiresAt:   now.Add(24 * time.Hour),
+			Certificate: "cert" + strconv.Itoa(i),
+		})
+	}
+	for _, test := range []struct {
+		query   string
+		serials []string
+	}{
+		{"cert_type=ssh", []string{"2", "0"}},
+		{"cert_type=ssh&username=user0", []string{"2", "0"}},
+		{"cert_type=x509&username=user0", nil},
+		{"serial=1", []string{"1"}},
+		{"fingerprint=SHA256:test2", []string{"2"}},
+		{"issued_after=" + now.Add(time.Hour).Format(time.RFC3339),
+			[]string{"2", "1"}},
+		{"issued_before=" + now.Add(time.Hour).Format(time.RFC3339),
+			[]string{"0"}},
+	} {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, search, err := getIssuedCertFilter(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !search {
+			t.Fatalf("%s: not a search", test.query)
+		}
+		records, err := state.searchIssuedCertificates(filter, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var serials []string
+		for _, record := range records {
+			serials = append(serials, record.Serial)
+			if record.Certificate != "cert"+record.Serial {
+				t.Fatalf("%s: bad certificate: %s", test.query,
+					record.Certificate)
+			}
+		}
+		if !reflect.DeepEqual(serials, test.serials) {
+			t.Fatalf("%s: got %v, expected %v", test.query, serials,
+				test.serials)
+		}
+	}
+	query, _ := url.ParseQuery("username=user0")
+	if _, search, _ := getIssuedCertFilter(query); search {
+		t.Fatal("username alone should not be a search")
+	}
+	query, _ = url.ParseQuery("issued_after=yesterday")
+	if _, _, err := getIssuedCertFilter(query); err == nil {
+		t.Fatal("invalid time accepted")
+	}
+}
+
+func TestGetClientVersion(t *testing.T) {
+	for userAgent, version := range map[string]string{
+		"keymaster/1.2.3 (linux amd64)": "1.2.3",
+		"keymaster/0.0":                 "0.0",
+		"Mozilla/5.0 (X11; Linux)":      "",
+		"":                              "",
+	} {
+		if got := getClientVersion(userAgent); got != version {
+			t.Errorf("getClientVersion(%q)=%q, expected %q", userAgent, got,
+				version)
+		}
+	}
+}
@@ -10,8 +10,10 @@ const headerTemplateText = `
 <div class="header">
 <table style="width:100%;border-collapse: separate;border-spacing: 0;">
 <tr>
-<th style="text-align:left;"> <div class="header_extra">{{template "header_extra"}}</div></th>
-<th style="text-align:right;padding-right: .5em;">  {{if .AuthUsername}} <b> {{.AuthUsername}} </b> <a href="/api/v0/logout" >Logout </a> {{end}}</th>
+<th style="text-align:left;">
+{{if logoURL}}<img class="header_logo" src="{{logoURL}}" alt="{{productName}}">{{end}}
+<div class="header_extra">{{template "header_extra"}}</div></th>
+<th style="text-align:right;padding-right: .5em;">  {{if .AuthUsername}} <b> {{.AuthUsername}} </b> <a href="/api/v0/logout" >{{T .Language "Logout"}} </a> {{end}}</th>
 </tr>
 </table>
 </div>
@@ -25,6 +27,7 @@ const footerTemplateText = `
 <div class="footer">
 <hr>
 <center>
+{{if footerText}}{{footerText}}<br>{{end}}
 Copright 2017-2019 Symantec Corporation; 2019-2020 Cloud-Foundations.org.
 {{template "footer_extra"}}
 </center>
@@ -33,22 +36,30 @@ Copright 2017-2019 Symantec Corporation; 2019-2020 Cloud-Foundations.org.
 `
 
 type loginPageTemplateData struct {
-	Title            string
-	AuthUsername     string
-	JSSources        []string
-	ShowOauth2       bool
-	LoginDestination string
-	ErrorMessage     string
+	Title              string
+	AuthUsername       string
+	Language           string
+	JSSources          []string
+	ShowOauth2         bool
+	LoginDestination   string
+	ErrorMessage       string
+	CaptchaSiteKey     string
+	CaptchaWidgetClass string
+	ShowPasskeyLogin   bool
 }
 
 //Should be a template
 const loginFormText = `
 {{define "loginPage"}}
 <!DOCTYPE html>
-<html style="height:100%; padding:0;border:0;margin:0">
+<html lang="{{.Language}}" style="height:100%; padding:0;border:0;margin:0">
     <head>
         <meta charset="UTF-8">
+        <meta name="viewport" content="width=device-width, initial-scale=1">
         <title>{{.Title}}</title>
+        {{- range .JSSources }}
+        <script type="text/javascript" src="{{.}}" async defer></script>
+        {{- end}}
 	<link rel="stylesheet" type="text/css" href="//fonts.googleapis.com/css?family=Droid+Sans" />
 	<link rel="stylesheet" type="text/css" href="/custom_static/customization.css">
         <link rel="stylesheet" type="text/css" href="/static/keymaster.css">
@@ -57,22 +68,29 @@ const loginFormText = `
     <div style="min-height:100%;position:relative;">
     {{template "header" .}}
         <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
-        <h2> Keymaster Login </h2>
+        <h2> {{productName}} {{T .Language "Login"}} </h2>
 	{{if .ErrorMessage}}
-	<p style="color:red;">{{.ErrorMessage}} </p>
+	<p style="color:red;">{{T .Language .ErrorMessage}} </p>
 	{{end}}
 	{{if .ShowOauth2}}
 	<p>
-	<a href="/auth/oauth2/login"> Oauth2 Login </a>
+	<a href="/auth/oauth2/login?login_destination={{.LoginDestination}}"> {{T .Language "Oauth2 Login"}} </a>
 	</p>
         {{end}}
 	{{template "login_pre_password" .}}
         <form enctype="application/x-www-form-urlencoded" action="/api/v0/login" method="post">
-            <p>Username: <INPUT TYPE="text" NAME="username" SIZE=18></p>
-            <p>Password: <INPUT TYPE="password" NAME="password" SIZE=18  autocomplete="off"></p>
+            <p>{{T .Language "Username:"}} <INPUT TYPE="text" NAME="username" SIZE=18></p>
+            <p>{{T .Language "Password:"}} <INPUT TYPE="password" NAME="password" SIZE=18  autocomplete="off"></p>
 	    <INPUT TYPE="hidden" NAME="login_destination" VALUE={{.LoginDestination}}>
-            <p><input type="submit" value="Submit" /></p>
+	    {{if .CaptchaSiteKey}}
+	    <div class="{{.CaptchaWidgetClass}}" data-sitekey="{{.CaptchaSiteKey}}"></div>
+	    {{end}}
+            <p><input type="submit" value="{{T .Language "Submit"}}" /></p>
         </form>
+	{{if .ShowPasskeyLogin}}
+	<div id="passkey_login_destination" style="display: none;">{{.LoginDestination}}</div>
+	<p><button id="passkey_login_button" type="button">{{T .Language "Sign in with a passkey"}}</button></p>
+	{{end}}
 	{{template "login_form_footer" .}}
 	</div>
     {{template "footer" . }}
@@ -85,6 +103,7 @@ const loginFormText = `
 type secondFactorAuthTemplateData struct {
 	Title            string
 	AuthUsername     string
+	Language         string
 	JSSources        []string
 	ShowBootstrapOTP bool
 	ShowVIP          bool
@@ -100,6 +119,7 @@ const secondFactorAuthFormText = `
 <html style="height:100%; padding:0;border:0;margin:0">
     <head>
         <meta charset="UTF-8">
+        <meta name="viewport" content="width=device-width, initial-scale=1">
         <title>{{.Title}}</title>
         {{if .JSSources -}}
         {{- range .JSSources }}
@@ -114,7 +134,7 @@ const secondFactorAuthFormText = `
         <div  style="min-height:100%;position:relative;">
 	{{template "header" .}}
 	<div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
-        <h2> Keymaster second factor authentication </h2>
+        <h2> {{productName}} second factor authentication </h2>
 	{{if .ShowBootstrapOTP}}
 	<div id="bootstrap_otp_login_destination" style="display: none;">{{.LoginDestination}}</div>
         <form enctype="application/x-www-form-urlencoded" action="/api/v0/bootstrapOtpAuth" method="post">
@@ -149,6 +169,7 @@ const secondFactorAuthFormText = `
 	<p>
 	       <div id="u2f_login_destination" style="display: none;">{{.LoginDestination}}</div>
                <div id="auth_action_text" > Authenticate by touching a blinking registered U2F device (insert if not inserted yet)</div>
+               <button id="webauthn_button" style="display: none;">Use security key</button>
         </p>
         {{if .ShowVIP}}
 	<div id="manual_start_vip_div">
@@ -200,10 +221,13 @@ const secondFactorAuthFormText = `
 `
 
 type usersPageTemplateData struct {
-	Title        string
-	AuthUsername string
-	JSSources    []string
-	Users        []string
+	Title         string
+	AuthUsername  string
+	Language      string
+	JSSources     []string
+	Users         []string
+	Search        string
+	SessionCounts map[string]int
 }
 
 const usersHTML = `
@@ -211,6 +235,7 @@ const usersHTML = `
 <!DOCTYPE html>
 <html style="height:100%; padding:0;border:0;margin:0">
   <head>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
     <title>{{.Title}}</title>
     {{if .JSSources -}}
     {{- range .JSSources }}
@@ -229,12 +254,21 @@ const usersHTML = `
     {{template "header" .}}
     <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
 
+    {{with $top := . }}
     <h1>{{.Title}}</h1>
+    <form action="/users/" method="get">
+       <p>Search: <INPUT TYPE="text" NAME="q" VALUE="{{.Search}}" SIZE=18>
+       <input type="submit" value="Search" /></p>
+    </form>
     <ul>
     {{range .Users}}
-       <li><a href="/profile/{{.}}">{{.}}</a></li>
+       <li><a href="/admin/user/{{.}}">{{.}}</a>
+       (<a href="/profile/{{.}}">profile</a>{{with index $top.SessionCounts .}}, {{.}} active sessions{{end}})</li>
+    {{else}}
+       <li>No matching users</li>
     {{end}}
     </ul>
+    {{end}}
     <br>
     <h3>Manage Users </h3>
     <form enctype="application/x-www-form-urlencoded" action="/admin/addUser" method="post">
@@ -243,6 +277,16 @@ const usersHTML = `
        <p><input type="submit" value="Delete User" formaction="/admin/deleteUser" /> </p>
        <p><input type="submit" value="Generate BootstrapOTP" formaction="/admin/newBoostrapOTP" /> </p>
     </form>
+    <h3>Revoke a certificate</h3>
+    <form enctype="application/x-www-form-urlencoded" action="/admin/revokeCertificate" method="post">
+       <p>Type: <select name="cert_type">
+         <option value="ssh">SSH</option>
+         <option value="x509">X.509</option>
+       </select></p>
+       <p>Serial number: <INPUT TYPE="text" NAME="serial" SIZE=24 autocomplete="off"></p>
+       <p>Reason: <INPUT TYPE="text" NAME="reason" SIZE=40></p>
+       <p><input type="submit" value="Revoke" /> </p>
+    </form>
 
     </div>
     {{template "footer" . }}
@@ -275,23 +319,28 @@ type bootstrapOtpTemplateData struct {
 type profilePageTemplateData struct {
 	Title                string
 	AuthUsername         string
+	Language             string
 	Username             string
 	JSSources            []string
 	BootstrapOTP         *bootstrapOtpTemplateData
 	ShowU2F              bool
+	ShowPasskey          bool
 	ShowTOTP             bool
 	ReadOnlyMsg          string
 	UsersLink            bool
+	PasswordLink         bool
 	RegisteredU2FToken   []registeredU2FTokenDisplayInfo
 	RegisteredTOTPDevice []registeredTOTPTDeviceDisplayInfo
+	RecentCertificates   []issuedCertRecord
 }
 
 //{{ .Date | formatAsDate}} {{ printf "%-20s" .Description }} {{.AmountInCents | formatAsDollars -}}
 const profileHTML = `
 {{define "userProfilePage"}}
 <!DOCTYPE html>
-<html style="height:100%; padding:0;border:0;margin:0">
+<html lang="{{.Language}}" style="height:100%; padding:0;border:0;margin:0">
   <head>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
     <title>{{.Title}}</title>
     {{if .JSSources -}}
     {{- range .JSSources }}
@@ -311,19 +360,25 @@ const profileHTML = `
     <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
 
     {{with $top := . }}
-    <h1>Keymaster User Profile</h1>
+    <h1>{{productName}} {{T .Language "User Profile"}}</h1>
     <h2 id="username">{{.Username}}</h2>
-    {{.ReadOnlyMsg}}
+    {{T .Language .ReadOnlyMsg}}
     <ul>
-      <li><a href="/api/v0/logout" >Logout </a></li>
+      <li><a href="/api/v0/logout" >{{T .Language "Logout"}} </a></li>
+    {{if eq .Username .AuthUsername}}
+      <li><a href="/devices/">{{T .Language "Manage devices"}}</a></li>
+      {{if .PasswordLink}}
+      <li><a href="/password/">{{T .Language "Change password"}}</a></li>
+      {{end}}
+    {{end}}
     {{if .UsersLink}}
-      <li><a href="/users/">Users</a></li>
+      <li><a href="/users/">{{T .Language "Users"}}</a></li>
     {{end}}
     </ul>
     <div id="bootstrap-otp">
     {{if .BootstrapOTP}}
-    Bootstrap OTP fingerprint: <code>{{printf "%x" .BootstrapOTP.Fingerprint}}</code>
-    expires at: {{.BootstrapOTP.ExpiresAt}}<p>
+    {{T .Language "Bootstrap OTP fingerprint:"}} <code>{{printf "%x" .BootstrapOTP.Fingerprint}}</code>
+    {{T .Language "expires at:"}} {{.BootstrapOTP.ExpiresAt}}<p>
     {{end}}
     <div id="u2f-tokens">
     <h3>U2F</h3>
@@ -331,25 +386,30 @@ const profileHTML = `
        {{if .ShowU2F}}
        {{if not .ReadOnlyMsg}}
       <li>
-         <a id="register_button" href="#">Register token</a>
-         <div id="register_action_text" style="color: blue;background-color: yellow; display: none;"> Please Touch the blinking device to register(insert if not inserted yet) </div>
+         <a id="register_button" href="#">{{T .Language "Register token"}}</a>
+         <div id="register_action_text" style="color: blue;background-color: yellow; display: none;"> {{T .Language "Please Touch the blinking device to register(insert if not inserted yet)"}} </div>
+      </li>
+      {{if .ShowPasskey}}
+      <li>
+         <a id="register_passkey_button" href="#">{{T .Language "Register passkey"}}</a>
       </li>
       {{end}}
-      <li><a id="auth_button" href="#">Authenticate</a>
-      <div id="auth_action_text" style="color: blue;background-color: yellow; display: none;"> Please Touch the blinking device to authenticate(insert if not inserted yet) </div>
+      {{end}}
+      <li><a id="auth_button" href="#">{{T .Language "Authenticate"}}</a>
+      <div id="auth_action_text" style="color: blue;background-color: yellow; display: none;"> {{T .Language "Please Touch the blinking device to authenticate(insert if not inserted yet)"}} </div>
       </li>
       {{else}}
-      <div id="auth_action_text" style="color: blue;background-color: yellow;"> Your browser does not support U2F. However you can still Enable/Disable/Delete U2F tokens </div>
+      <div id="auth_action_text" style="color: blue;background-color: yellow;"> {{T .Language "Your browser does not support U2F. However you can still Enable/Disable/Delete U2F tokens"}} </div>
       {{end}}
     </ul>
     <div style="margin-left: 40px">
     {{if .RegisteredU2FToken -}}
-        <p>Your U2F Token(s):</p>
+        <p>{{T .Language "Your U2F Token(s):"}}</p>
         <table>
 	    <tr>
-	    <th>Name</th>
-	    <th>Device Data</th>
-	    <th>Actions</th>
+	    <th>{{T .Language "Name"}}</th>
+	    <th>{{T .Language "Device Data"}}</th>
+	    <th>{{T .Language "Actions"}}</th>
 	    </tr>
 	    {{- range .RegisteredU2FToken }}
             <tr>
@@ -360,12 +420,12 @@ const profileHTML = `
 	     <td> {{ .DeviceData}} </td>
 	     <td>
 	         {{if not $top.ReadOnlyMsg}}
-	         <input type="submit" name="action" value="Update" {{if not .Enabled}} disabled {{end}}/>
+	         <button type="submit" name="action" value="Update" {{if not .Enabled}} disabled {{end}}>{{T $top.Language "Update"}}</button>
 		 {{if .Enabled}}
-		 <input type="submit" name="action" value="Disable"/>
+		 <button type="submit" name="action" value="Disable">{{T $top.Language "Disable"}}</button>
 		 {{ else }}
-		 <input type="submit" name="action" value="Enable"/>
-		 <input type="submit" name="action" value="Delete" {{if .Enabled}} disabled {{end}}/>
+		 <button type="submit" name="action" value="Enable">{{T $top.Language "Enable"}}</button>
+		 <button type="submit" name="action" value="Delete" {{if .Enabled}} disabled {{end}}>{{T $top.Language "Delete"}}</button>
 		 {{ end }}
 		 {{end}}
 	     </td>
@@ -374,7 +434,7 @@ const profileHTML = `
 	    {{- end}}
 	</table>
     {{- else}}
-	You Dont have any registered tokens.
+	{{T .Language "You Dont have any registered tokens."}}
     {{- end}}
     </div>
     </div> <!-- end of u2f div -->
@@ -382,24 +442,24 @@ const profileHTML = `
     {{if .ShowTOTP}}
        <h3>TOTP</h3>
        <ul>
-          <li><a href="/totp/GenerateNew/">Generate New TOTP</a></li>
+          <li><a href="/totp/GenerateNew/">{{T .Language "Add a TOTP device"}}</a></li>
 	  <li>
               <form enctype="application/x-www-form-urlencoded" action="/api/v0/VerifyTOTP" method="post">
                   <p>
-                  Authenticate TOTP: <INPUT TYPE="text" NAME="OTP" SIZE=8  autocomplete="off">
+                  {{T .Language "Authenticate TOTP:"}} <INPUT TYPE="text" NAME="OTP" SIZE=8  autocomplete="off">
                   <INPUT TYPE="hidden" NAME="login_destination" VALUE="/">
-                  <input type="submit" value="Submit" />
+                  <input type="submit" value="{{T .Language "Submit"}}" />
                   </p>
               </form>
 	  </li>
        </ul>
        {{if .RegisteredTOTPDevice -}}
        <div style="margin-left: 40px">
-       <p> Your registered totp device(s) </p>
+       <p> {{T .Language "Your registered totp device(s)"}} </p>
        <table>
             <tr>
-               <th>Name</th>
-               <th>Actions</th>
+               <th>{{T .Language "Name"}}</th>
+               <th>{{T .Language "Actions"}}</th>
             </tr>
 	    {{- range .RegisteredTOTPDevice }}
 	    <tr>
@@ -409,12 +469,12 @@ const profileHTML = `
                   <td> <input type="text" name="name" value="{{ .Name}}" SIZE=18  {{if $top.ReadOnlyMsg}} readonly{{end}} > </td>
                   <td>
                   {{if not $top.ReadOnlyMsg}}
-                     <input type="submit" name="action" value="Update" {{if not .Enabled}} disabled {{end}}/>
+                     <button type="submit" name="action" value="Update" {{if not .Enabled}} disabled {{end}}>{{T $top.Language "Update"}}</button>
                   {{if .Enabled}}
-                     <input type="submit" name="action" value="Disable"/>
+                     <button type="submit" name="action" value="Disable">{{T $top.Language "Disable"}}</button>
                   {{ else }}
-                     <input type="submit" name="action" value="Enable"/>
-                     <input type="submit" name="action" value="Delete" {{if .Enabled}} disabled {{end}}/>
+                     <button type="submit" name="action" value="Enable">{{T $top.Language "Enable"}}</button>
+                     <button type="submit" name="action" value="Delete" {{if .Enabled}} disabled {{end}}>{{T $top.Language "Delete"}}</button>
                   {{ end }}
                   {{end}}
                   </td>
@@ -426,6 +486,32 @@ const profileHTML = `
        {{end}}
     {{end}}
     </div> <!-- end of totp div -->
+    <div id="recent-certificates">
+    <h3>{{T .Language "Recent certificates"}}</h3>
+    {{if .RecentCertificates -}}
+    <table>
+      <tr>
+        <th>{{T .Language "Type"}}</th>
+        <th>{{T .Language "Key fingerprint"}}</th>
+        <th>{{T .Language "Issued"}}</th>
+        <th>{{T .Language "Expires"}}</th>
+        <th>{{T .Language "Source address"}}</th>
+      </tr>
+      {{- range .RecentCertificates}}
+      <tr>
+        <td>{{.CertType}}</td>
+        <td><code>{{.Fingerprint}}</code></td>
+        <td>{{.IssuedAt.Format "2006-01-02 15:04 MST"}}</td>
+        <td>{{.ExpiresAt.Format "2006-01-02 15:04 MST"}}</td>
+        <td>{{.SourceAddr}}</td>
+      </tr>
+      {{- end}}
+    </table>
+    <p>{{T .Language "If you did not request one of these certificates, contact your administrator."}}</p>
+    {{- else}}
+    {{T .Language "No certificates have been issued recently."}}
+    {{- end}}
+    </div> <!-- end of recent certificates div -->
     {{end}}
     </div>
     {{template "footer" . }}
@@ -438,8 +524,11 @@ const profileHTML = `
 type newTOTPPageTemplateData struct {
 	Title           string
 	AuthUsername    string
+	Language        string
 	JSSources       []string
 	ErrorMessage    string
+	Step            int
+	DeviceName      string
 	TOTPBase64Image template.HTML
 	TOTPSecret      string
 }
@@ -449,6 +538,7 @@ const newTOTPHTML = `
 <!DOCTYPE html>
 <html style="height:100%; padding:0;border:0;margin:0">
   <head>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
     <title>{{.Title}}</title>
     {{if .JSSources -}}
     {{- range .JSSources }}
@@ -467,25 +557,48 @@ const newTOTPHTML = `
     {{template "header" .}}
     <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
 
-    <h1>{{.Title}}</h1>
+    <h1>Add a TOTP device</h1>
+    <ol class="wizard_steps">
+      <li {{if eq .Step 1}}class="wizard_current"{{end}}>1. Get ready</li>
+      <li {{if eq .Step 2}}class="wizard_current"{{end}}>2. Scan and confirm</li>
+      <li {{if eq .Step 3}}class="wizard_current"{{end}}>3. Done</li>
+    </ol>
 
     {{if .ErrorMessage}}
     <p style="color:red;">{{.ErrorMessage}} </p>
     {{end}}
 
+    {{if eq .Step 1}}
+    <p>You will need an authenticator app, such as Google Authenticator or
+    FreeOTP, installed on your phone.</p>
+    <form enctype="application/x-www-form-urlencoded" action="/totp/GenerateNew/" method="post">
+            <p>Device name (optional): <INPUT TYPE="text" NAME="name" SIZE=18></p>
+            <p><input type="submit" value="Next" /></p>
+    </form>
+    {{else if eq .Step 3}}
+    <p>Your TOTP device {{.DeviceName}} has been saved and can now be used to
+    log in.</p>
+    <p><a href="/profile/">Return to your profile</a> or
+    <a href="/devices/">manage your devices</a>.</p>
+    {{else}}
     <div>
-
     {{if .TOTPBase64Image}}
-    New TOTP:
+    <p>Scan this QR code with your authenticator app:</p>
     {{.TOTPBase64Image}}
     {{ end }}
+    {{if .TOTPSecret}}
+    <p>If you cannot scan it, enter this key instead: <code>{{.TOTPSecret}}</code></p>
+    {{end}}
     </div>
+    <p>Then enter the code shown by the app to confirm that it works.</p>
     <form enctype="application/x-www-form-urlencoded" action="/totp/ValidateNew/" method="post">
+            <INPUT TYPE="hidden" NAME="name" VALUE="{{.DeviceName}}">
             <p>
             Enter OTP token value: <INPUT TYPE="text" NAME="OTP" SIZE=18  autocomplete="off">
             <input type="submit" value="Validate" />
             </p>
     </form>
+    {{end}}
     </div>
     {{template "footer" . }}
     </div>
@@ -497,6 +610,7 @@ const newTOTPHTML = `
 type newBootstrapOTPPPageTemplateData struct {
 	Title             string
 	AuthUsername      string
+	Language          string
 	JSSources         []string
 	ErrorMessage      string
 	Username          string
@@ -510,6 +624,7 @@ const newBootstrapOTPPHTML = `
 <!DOCTYPE html>
 <html style="height:100%; padding:0;border:0;margin:0">
   <head>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
     <title>{{.Title}}</title>
     {{if .JSSources -}}
     {{- range .JSSources }}
@@ -548,3 +663,322 @@ const newBootstrapOTPPHTML = `
 </html>
 {{end}}
 `
+
+type errorPageTemplateData struct {
+	Title        string
+	AuthUsername string
+	Language     string
+	JSSources    []string
+	Code         int
+	StatusText   string
+	Message      string
+}
+
+const errorPageHTML = `
+{{define "errorPage"}}
+<!DOCTYPE html>
+<html lang="{{.Language}}" style="height:100%; padding:0;border:0;margin:0">
+  <head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Title}}</title>
+    <link rel="stylesheet" type="text/css" href="//fonts.googleapis.com/css?family=Droid+Sans" />
+    <link rel="stylesheet" type="text/css" href="/custom_static/customization.css">
+    <link rel="stylesheet" type="text/css" href="/static/keymaster.css">
+  </head>
+  <body>
+    <div style="min-height:100%;position:relative;">
+    {{template "header" .}}
+    <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
+    <h2>{{.Code}} {{T .Language .StatusText}}</h2>
+    {{if .Message}}
+    <p class="error_message">{{T .Language .Message}}</p>
+    {{end}}
+    <p><a href="/">{{T .Language "Back to"}} {{productName}}</a></p>
+    </div>
+    {{template "footer" . }}
+    </div>
+  </body>
+</html>
+{{end}}
+`
+
+type devicesPageTemplateData struct {
+	Title        string
+	AuthUsername string
+	Language     string
+	JSSources    []string
+	ReadOnlyMsg  string
+	Devices      []userDeviceInfo
+	// The session was authenticated with a second factor.
+	ShowTrustDevice bool
+}
+
+const devicesHTML = `
+{{define "devicesPage"}}
+<!DOCTYPE html>
+<html style="height:100%; padding:0;border:0;margin:0">
+  <head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Title}}</title>
+    <link rel="stylesheet" type="text/css" href="//fonts.googleapis.com/css?family=Droid+Sans" />
+    <link rel="stylesheet" type="text/css" href="/custom_static/customization.css">
+    <link rel="stylesheet" type="text/css" href="/static/keymaster.css">
+  </head>
+  <body>
+    <div style="min-height:100%;position:relative;">
+    {{template "header" .}}
+    <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
+    {{with $top := . }}
+    <h1>{{.Title}}</h1>
+    {{if .ReadOnlyMsg}}<p>{{.ReadOnlyMsg}}</p>{{end}}
+    <ul>
+      <li><a href="/profile/">Back to profile</a></li>
+    </ul>
+    {{if .Devices}}
+    <table>
+      <tr>
+        <th>Type</th>
+        <th>Name</th>
+        <th>Registered</th>
+        <th>Last used</th>
+        <th>Actions</th>
+      </tr>
+      {{- range .Devices}}
+      <tr>
+        <form enctype="application/x-www-form-urlencoded" action="/api/v0/devices" method="post">
+        <input type="hidden" name="type" value="{{.Type}}">
+        <input type="hidden" name="index" value="{{.Index}}">
+        <td>{{.Type}}{{if not .Enabled}} ({{if .ExpiresAt}}expired{{else}}disabled{{end}}){{end}}</td>
+        <td><input type="text" name="name" value="{{.Name}}" SIZE=18 {{if $top.ReadOnlyMsg}} readonly{{end}}></td>
+        <td title="{{.CreatorUserAgent}}">{{.CreatedAt.Format "2006-01-02 15:04 MST"}}{{if .CreatorAddr}} from {{.CreatorAddr}}{{end}}</td>
+        <td>{{if .LastUsedAt}}{{.LastUsedAt.Format "2006-01-02 15:04 MST"}}{{if .LastUsedAddr}} from {{.LastUsedAddr}}{{end}}{{else}}never{{end}}{{if .ExpiresAt}}, expires {{.ExpiresAt.Format "2006-01-02 15:04 MST"}}{{end}}</td>
+        <td>
+        {{if not $top.ReadOnlyMsg}}
+          <input type="submit" name="action" value="Rename"/>
+          <input type="submit" name="action" value="Delete"/>
+        {{end}}
+        </td>
+        </form>
+      </tr>
+      {{- end}}
+    </table>
+    {{else}}
+    <p>You do not have any registered devices.</p>
+    {{end}}
+    {{if and .ShowTrustDevice (not .ReadOnlyMsg)}}
+    <form enctype="application/x-www-form-urlencoded" action="/api/v0/devices" method="post">
+      <p>Skip the second factor when logging in from this browser:
+      <input type="submit" name="action" value="Trust"/></p>
+    </form>
+    {{end}}
+    {{end}}
+    </div>
+    {{template "footer" . }}
+    </div>
+  </body>
+</html>
+{{end}}
+`
+
+type passwordPageTemplateData struct {
+	Title                string
+	AuthUsername         string
+	Language             string
+	JSSources            []string
+	ErrorMessage         string
+	SuccessMessage       string
+	SecondFactorRequired bool
+}
+
+const passwordHTML = `
+{{define "passwordPage"}}
+<!DOCTYPE html>
+<html lang="{{.Language}}" style="height:100%; padding:0;border:0;margin:0">
+  <head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Title}}</title>
+    <link rel="stylesheet" type="text/css" href="//fonts.googleapis.com/css?family=Droid+Sans" />
+    <link rel="stylesheet" type="text/css" href="/custom_static/customization.css">
+    <link rel="stylesheet" type="text/css" href="/static/keymaster.css">
+  </head>
+  <body>
+    <div style="min-height:100%;position:relative;">
+    {{template "header" .}}
+    <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
+    <h1>{{.Title}}</h1>
+    {{if .ErrorMessage}}<p style="color:red;">{{T .Language .ErrorMessage}}</p>{{end}}
+    {{if .SuccessMessage}}<p>{{T .Language .SuccessMessage}}</p>{{end}}
+    <ul>
+      <li><a href="/profile/">{{T .Language "Back to profile"}}</a></li>
+    </ul>
+    {{if .SecondFactorRequired}}
+    <p>{{T .Language "You need to log in with a second factor to change your password."}}</p>
+    {{else}}
+    <form enctype="application/x-www-form-urlencoded" action="/api/v0/password" method="post">
+      <p>{{T .Language "Current password"}}: <input type="password" name="old_password" autocomplete="current-password" required></p>
+      <p>{{T .Language "New password"}}: <input type="password" name="new_password" autocomplete="new-password" required></p>
+      <p>{{T .Language "Confirm new password"}}: <input type="password" name="new_password_confirm" autocomplete="new-password" required></p>
+      <p><input type="submit" value="{{T .Language "Change password"}}"/></p>
+    </form>
+    {{end}}
+    </div>
+    {{template "footer" . }}
+    </div>
+  </body>
+</html>
+{{end}}
+`
+
+type adminUserPageTemplateData struct {
+	Title        string
+	AuthUsername string
+	Language     string
+	JSSources    []string
+	ReadOnlyMsg  string
+	Username     string
+	Devices      []userDeviceInfo
+	Sessions     []sessionInfo
+	Certificates []issuedCertRecord
+}
+
+const adminUserHTML = `
+{{define "adminUserPage"}}
+<!DOCTYPE html>
+<html style="height:100%; padding:0;border:0;margin:0">
+  <head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Title}}</title>
+    <link rel="stylesheet" type="text/css" href="//fonts.googleapis.com/css?family=Droid+Sans" />
+    <link rel="stylesheet" type="text/css" href="/custom_static/customization.css">
+    <link rel="stylesheet" type="text/css" href="/static/keymaster.css">
+  </head>
+  <body>
+    <div style="min-height:100%;position:relative;">
+    {{template "header" .}}
+    <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
+    {{with $top := . }}
+    <h1>{{.Username}}</h1>
+    {{if .ReadOnlyMsg}}<p>{{.ReadOnlyMsg}}</p>{{end}}
+    <ul>
+      <li><a href="/users/">Back to users</a></li>
+      <li><a href="/profile/{{.Username}}">Profile</a></li>
+    </ul>
+
+    <h3>Devices</h3>
+    {{if .Devices}}
+    <table>
+      <tr>
+        <th>Type</th>
+        <th>Name</th>
+        <th>Registered</th>
+        <th>Registered from</th>
+        <th>Registration user agent</th>
+        <th>Last used</th>
+        <th>Last used from</th>
+      </tr>
+      {{- range .Devices}}
+      <tr>
+        <td>{{.Type}}{{if not .Enabled}} (disabled){{end}}</td>
+        <td>{{.Name}}</td>
+        <td>{{.CreatedAt.Format "2006-01-02 15:04 MST"}}</td>
+        <td>{{.CreatorAddr}}</td>
+        <td>{{.CreatorUserAgent}}</td>
+        <td>{{if .LastUsedAt}}{{.LastUsedAt.Format "2006-01-02 15:04 MST"}}{{else}}never{{end}}</td>
+        <td>{{.LastUsedAddr}}</td>
+      </tr>
+      {{- end}}
+    </table>
+    {{if not .ReadOnlyMsg}}
+    <form enctype="application/x-www-form-urlencoded" action="/admin/resetDevices" method="post">
+      <input type="hidden" name="username" value="{{.Username}}">
+      <p><input type="submit" value="Reset all devices" /></p>
+    </form>
+    {{end}}
+    {{else}}
+    <p>No registered devices.</p>
+    {{end}}
+
+    <h3>Active sessions</h3>
+    {{if .Sessions}}
+    <table>
+      <tr>
+        <th>Issued</th>
+        <th>Expires</th>
+        <th>Actions</th>
+      </tr>
+      {{- range .Sessions}}
+      <tr>
+        <td>{{.IssuedAt.Format "2006-01-02 15:04 MST"}}</td>
+        <td>{{.ExpiresAt.Format "2006-01-02 15:04 MST"}}</td>
+        <td>
+        <form enctype="application/x-www-form-urlencoded" action="/admin/revokeSessions" method="post">
+          <input type="hidden" name="username" value="{{$top.Username}}">
+          <input type="hidden" name="session_id" value="{{.ID}}">
+          <input type="submit" value="Revoke" />
+        </form>
+        </td>
+      </tr>
+      {{- end}}
+    </table>
+    <form enctype="application/x-www-form-urlencoded" action="/admin/revokeSessions" method="post">
+      <input type="hidden" name="username" value="{{.Username}}">
+      <p><input type="submit" value="Revoke all sessions" /></p>
+    </form>
+    {{else}}
+    <p>No active sessions on this server.</p>
+    {{end}}
+
+    <h3>Recent certificates</h3>
+    {{if .Certificates}}
+    <table>
+      <tr>
+        <th>Type</th>
+        <th>Serial</th>
+        <th>Audit ID</th>
+        <th>Key fingerprint</th>
+        <th>Issued</th>
+        <th>Expires</th>
+        <th>Source address</th>
+        <th>Auth method</th>
+        <th>Actions</th>
+      </tr>
+      {{- range .Certificates}}
+      <tr>
+        <td>{{.CertType}}</td>
+        <td>{{.Serial}}</td>
+        <td><code>{{.AuditID}}</code></td>
+        <td><code>{{.Fingerprint}}</code></td>
+        <td>{{.IssuedAt.Format "2006-01-02 15:04 MST"}}</td>
+        <td>{{.ExpiresAt.Format "2006-01-02 15:04 MST"}}</td>
+        <td title="{{.UserAgent}}">{{.SourceAddr}}</td>
+        <td>{{.AuthMethod}}</td>
+        <td>
+        {{if .Revoked}}
+          revoked
+        {{else}}
+        <form enctype="application/x-www-form-urlencoded" action="/admin/revokeCertificate" method="post">
+          <input type="hidden" name="cert_type" value="{{if eq .CertType "ssh"}}ssh{{else}}x509{{end}}">
+          <input type="hidden" name="serial" value="{{.Serial}}">
+          <input type="text" name="reason" placeholder="Reason" SIZE=18>
+          <input type="submit" value="Revoke" />
+        </form>
+        {{end}}
+        </td>
+      </tr>
+      {{- end}}
+    </table>
+    {{else}}
+    <p>No certificates have been issued recently.</p>
+    {{end}}
+    {{end}}
+    </div>
+    {{template "footer" . }}
+    </div>
+  </body>
+</html>
+{{end}}
+`
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+// Followed by the name of the tenant.
+const (
+	tenantReadyzPath         = readyzPath + "/"
+	tenantSecretInjectorPath = secretInjectorPath + "/"
+)
+
+var validTenantNameRE = regexp.MustCompile(`^[a-z0-9][-a-z0-9_]*$`)
+
+// tenantConfig is another keymaster served by this one. It has its own
+// configuration file and so its own CA keys, authentication backends,
+// policies and data directory. Requests are sent to it by TLS server name
+// and Host header.
+type tenantConfig struct {
+	Name       string   `yaml:"name"`
+	Hostnames  []string `yaml:"hostnames"`
+	ConfigFile string   `yaml:"config_file"`
+}
+
+type tenant struct {
+	name      string
+	state     *RuntimeState
+	handler   http.Handler
+	tlsConfig *tls.Config
+}
+
+// loadTenants loads the configurations of the tenants. It must be called
+// before the service listeners are started.
+func (state *RuntimeState) loadTenants(logger log.DebugLogger) error {
+	if len(state.Config.Tenants) < 1 {
+		return nil
+	}
+	state.tenants = make(map[string]*tenant)
+	names := make(map[string]struct{})
+	dataDirectories := map[string]string{
+		filepath.Clean(state.Config.Base.DataDirectory): "the main keymaster",
+	}
+	for _, config := range state.Config.Tenants {
+		if !validTenantNameRE.MatchString(config.Name) {
+			return fmt.Errorf("invalid tenant name: %q", config.Name)
+		}
+		if _, ok := names[config.Name]; ok {
+			return fmt.Errorf("duplicate tenant: %s", config.Name)
+		}
+		names[config.Name] = struct{}{}
+		if config.ConfigFile == "" || len(config.Hostnames) < 1 {
+			return fmt.Errorf("tenant: %s needs a config file and hostnames",
+				config.Name)
+		}
+		tenantState, err := loadVerifyConfigFile(config.ConfigFile, logger)
+		if err != nil {
+			return fmt.Errorf("error loading tenant: %s: %s", config.Name, err)
+		}
+		if len(tenantState.Config.Tenants) > 0 {
+			return fmt.Errorf("tenant: %s may not have tenants", config.Name)
+		}
+		dataDirectory := filepath.Clean(tenantState.Config.Base.DataDirectory)
+		if owner, ok := dataDirectories[dataDirectory]; ok {
+			return fmt.Errorf("tenant: %s has the data directory of %s",
+				config.Name, owner)
+		}
+		dataDirectories[dataDirectory] = "tenant: " + config.Name
+		if tenantState.ClientCAPool == nil {
+			tenantState.ClientCAPool = x509.NewCertPool()
+		}
+		t := &tenant{
+			name:      config.Name,
+			state:     tenantState,
+			handler:   tenantState.newServiceMux(),
+			tlsConfig: tenantState.newServiceTLSConfig(),
+		}
+		for _, hostname := range config.Hostnames {
+			hostname = strings.ToLower(hostname)
+			if hostname == strings.ToLower(state.HostIdentity) {
+				return fmt.Errorf("tenant: %s uses the main hostname: %s",
+					config.Name, hostname)
+			}
+			if other, ok := state.tenants[hostname]; ok {
+				return fmt.Errorf("hostname: %s used by tenants: %s and %s",
+					hostname, other.name, config.Name)
+			}
+			state.tenants[hostname] = t
+		}
+		logger.Printf("loaded tenant: %s for: %s\n", config.Name,
+			strings.Join(config.Hostnames, ", "))
+	}
+	return nil
+}
+
+// startTenants finishes setting up each tenant once its signer is unsealed.
+func (state *RuntimeState) startTenants() {
+	started := make(map[*tenant]struct{})
+	for _, t := range state.tenants {
+		if _, ok := started[t]; ok {
+			continue
+		}
+		started[t] = struct{}{}
+		go t.start()
+	}
+}
+
+func (t *tenant) start() {
+	state := t.state
+	if isReady := <-state.SignerIsReady; !isReady {
+		logger.Printf("tenant: %s: got bad signer ready data\n", t.name)
+		return
+	}
+	if err := state.startRevocationPublisher(); err != nil {
+		logger.Printf("tenant: %s: %s\n", t.name, err)
+	}
+	if state.passwordCheckerUsesStorage() {
+		if err := state.passwordChecker.UpdateStorage(state); err != nil {
+			logger.Printf("tenant: %s: cannot update password checker: %s\n",
+				t.name, err)
+		}
+	}
+	state.ClientCAPool.AddCert(state.caCert)
+	logger.Printf("tenant: %s is ready\n", t.name)
+}
+
+// getTenant returns the tenant serving host, or nil for the main keymaster.
+func (state *RuntimeState) getTenant(host string) *tenant {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return state.tenants[strings.ToLower(host)]
+}
+
+func (state *RuntimeState) getTenantByName(name string) *tenant {
+	for _, t := range state.tenants {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// getTenantTLSConfig is the GetConfigForClient function of the service
+// listener, so that each tenant presents its own certificate and accepts
+// certificates from its own CA.
+func (state *RuntimeState) getTenantTLSConfig(hello *tls.ClientHelloInfo) (
+	*tls.Config, error) {
+	if t := state.getTenant(hello.ServerName); t != nil {
+		return t.tlsConfig, nil
+	}
+	return nil, nil
+}
+
+// newTenantHandler sends requests for tenants to them and the others to
+// handler.
+func (state *RuntimeState) newTenantHandler(
+	handler http.Handler) http.Handler {
+	if len(state.tenants) < 1 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := state.getTenant(r.Host)
+		// Client certificates were verified against the CA of the tenant
+		// selected by the server name, or the main CA if there was none, so
+		// the Host must select the same tenant.
+		var serverName string
+		if r.TLS != nil {
+			serverName = r.TLS.ServerName
+		}
+		if state.getTenant(serverName) != t {
+			http.Error(w, "Misdirected Request",
+				http.StatusMisdirectedRequest)
+			return
+		}
+		if t == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		t.handler.ServeHTTP(w, r)
+	})
+}
+
+// getTenantFromPath returns the tenant named at the end of the request path
+// after prefix. If there is none, a failure response is written.
+func (state *RuntimeState) getTenantFromPath(w http.ResponseWriter,
+	r *http.Request, prefix string) *tenant {
+	name := strings.TrimPrefix(r.URL.Path, prefix)
+	t := state.getTenantByName(name)
+	if t == nil {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		logger.Printf("unknown tenant: %s\n", name)
+	}
+	return t
+}
+
+// tenantReadyzHandler reports whether the CA of the tenant named in the path
+// is unsealed.
+func (state *RuntimeState) tenantReadyzHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if t := state.getTenantFromPath(w, r, tenantReadyzPath); t != nil {
+		t.state.readyzHandler(w, r)
+	}
+}
+
+// tenantSecretInjectorHandler unseals the CA of the tenant named in the
+// path.
+func (state *RuntimeState) tenantSecretInjectorHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if t := state.getTenantFromPath(w, r, tenantSecretInjectorPath); t != nil {
+		t.state.secretInjectorHandler(w, r)
+	}
+}
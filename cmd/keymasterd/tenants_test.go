@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+func newNamedHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	})
+}
+
+func TestTenantHandler(t *testing.T) {
+	state := &RuntimeState{}
+	mainHandler := newNamedHandler("main")
+	if state.newTenantHandler(mainHandler) == nil {
+		t.Fatal("no handler without tenants")
+	}
+	tenantA := &tenant{name: "a", handler: newNamedHandler("a")}
+	tenantB := &tenant{name: "b", handler: newNamedHandler("b")}
+	state.tenants = map[string]*tenant{
+		"a.example.com":       tenantA,
+		"keymaster-a.example": tenantA,
+		"b.example.com":       tenantB,
+	}
+	handler := state.newTenantHandler(mainHandler)
+	tests := []struct {
+		host       string
+		serverName string
+		code       int
+		body       string
+	}{
+		{"keymaster.example.com", "", http.StatusOK, "main"},
+		// Without SNI, client certificates are from the main CA.
+		{"a.example.com", "", http.StatusMisdirectedRequest, ""},
+		{"A.Example.com:443", "a.example.com", http.StatusOK, "a"},
+		{"keymaster-a.example", "a.example.com", http.StatusOK, "a"},
+		{"b.example.com", "b.example.com", http.StatusOK, "b"},
+		{"b.example.com", "a.example.com", http.StatusMisdirectedRequest, ""},
+		{"keymaster.example.com", "b.example.com",
+			http.StatusMisdirectedRequest, ""},
+		{"b.example.com", "keymaster.example.com",
+			http.StatusMisdirectedRequest, ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = test.host
+		req.TLS = &tls.ConnectionState{ServerName: test.serverName}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != test.code {
+			t.Errorf("%s (%s): expected code: %d, got: %d", test.host,
+				test.serverName, test.code, rr.Code)
+			continue
+		}
+		if test.body != "" && rr.Body.String() != test.body {
+			t.Errorf("%s (%s): served by: %s, expected: %s", test.host,
+				test.serverName, rr.Body.String(), test.body)
+		}
+	}
+	// Nor may a cleartext request reach a tenant.
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "a.example.com"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusMisdirectedRequest {
+		t.Errorf("cleartext request for tenant: %d", rr.Code)
+	}
+	hello := &tls.ClientHelloInfo{ServerName: "b.example.com"}
+	tenantB.tlsConfig = &tls.Config{}
+	if config, _ := state.getTenantTLSConfig(hello); config != tenantB.tlsConfig {
+		t.Error("wrong TLS config for tenant")
+	}
+	hello.ServerName = "keymaster.example.com"
+	if config, _ := state.getTenantTLSConfig(hello); config != nil {
+		t.Error("tenant TLS config for main hostname")
+	}
+}
+
+func TestLoadTenantsRejectsBadConfig(t *testing.T) {
+	badConfigs := [][]tenantConfig{
+		{{Name: "Bad Name", Hostnames: []string{"a.example.com"},
+			ConfigFile: "a.yml"}},
+		{{Name: "a", ConfigFile: "a.yml"}},
+		{{Name: "a", Hostnames: []string{"a.example.com"}}},
+		{{Name: "a", Hostnames: []string{"a.example.com"},
+			ConfigFile: "/does/not/exist.yml"}},
+	}
+	for _, config := range badConfigs {
+		state := &RuntimeState{}
+		state.Config.Tenants = config
+		if err := state.loadTenants(testlogger.New(t)); err == nil {
+			t.Errorf("accepted bad config: %+v", config)
+		}
+	}
+}
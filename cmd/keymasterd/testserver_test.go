@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+	"github.com/Cloud-Foundations/keymaster/lib/instrumentedwriter"
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/yaml.v2"
+)
+
+// testServer is keymasterd serving in process from a httptest server, with
+// the throwaway configuration of the development mode. It is used for end to
+// end tests of the login and certificate flows through HTTP.
+type testServer struct {
+	*httptest.Server
+	state  *RuntimeState
+	client *http.Client // Trusts the server and keeps the cookies.
+	t      *testing.T
+}
+
+// newTestServer starts a test server. If configure is not nil, it can change
+// the configuration before it is loaded. The server is stopped and its files
+// are removed when the test completes.
+func newTestServer(t *testing.T,
+	configure func(config *AppConfigFile)) *testServer {
+	dir, err := ioutil.TempDir("", "keymasterd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	configFilename, err := generateDevConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configure != nil {
+		configText, err := ioutil.ReadFile(configFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var config AppConfigFile
+		if err := yaml.Unmarshal(configText, &config); err != nil {
+			t.Fatal(err)
+		}
+		configure(&config)
+		configText, err = yaml.Marshal(&config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(configFilename, configText, 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	testLogger := testlogger.New(t)
+	state, err := loadVerifyConfigFile(configFilename, testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.passwordCheckerUsesStorage() {
+		if err := state.passwordChecker.UpdateStorage(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewTLSServer(instrumentedwriter.NewLoggingHandler(
+		state.newMiddlewareHandler(state.newServiceMux()),
+		httpLogger{AccessLogger: testLogger}))
+	t.Cleanup(server.Close)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := server.Client()
+	client.Jar = jar
+	return &testServer{Server: server, state: state, client: client, t: t}
+}
+
+// do sends a request with the cookies of the earlier responses.
+func (ts *testServer) do(method, path string, body []byte,
+	contentType string) *http.Response {
+	req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return resp
+}
+
+// getJSON decodes the response to a GET of path into value and fails the
+// test unless the request succeeds.
+func (ts *testServer) getJSON(path string, value interface{}) {
+	resp := ts.do("GET", path, nil, "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		ts.t.Fatalf("GET %s: %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+		ts.t.Fatal(err)
+	}
+}
+
+// postJSON posts value as JSON to path and returns the status code.
+func (ts *testServer) postJSON(path string, value interface{}) int {
+	body, err := json.Marshal(value)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	resp := ts.do("POST", path, body, "application/json")
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// login logs in with a password and returns the status code.
+func (ts *testServer) login(username, password string) int {
+	form := url.Values{"username": {username}, "password": {password}}
+	resp := ts.do("POST", proto.LoginPath, []byte(form.Encode()),
+		"application/x-www-form-urlencoded")
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// getX509Cert requests a X.509 certificate for username. It returns the
+// certificate, or nil and the status code if the request failed.
+func (ts *testServer) getX509Cert(username string) (*x509.Certificate, int) {
+	req, err := createKeyBodyRequest("POST",
+		ts.URL+"/certgen/"+username+"?type=x509", testUserPEMPublicKey, "")
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	block, _ := pem.Decode(body)
+	if block == nil {
+		ts.t.Fatalf("no certificate in response: %s",
+			strings.TrimSpace(string(body)))
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	if err := cert.CheckSignatureFrom(ts.state.caCert); err != nil {
+		ts.t.Fatal(err)
+	}
+	return cert, resp.StatusCode
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	trustedDeviceCookieName = "trusted_device"
+	trustedDeviceTokenType  = "keymaster_trusted_device"
+
+	defaultTrustedDeviceLifetime = 30 * 24 * time.Hour
+	defaultMaxTrustedDevices     = 10
+)
+
+// trustedDevicesConfig lets users skip the second factor when logging in to
+// the web UI from a browser which they chose to trust after logging in with
+// one. Certificates, factor registration, password changes and admin
+// actions still require the second factor (see getWebUILoginAuthLevel).
+type trustedDevicesConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Lifetime   time.Duration `yaml:"lifetime"`    // Default: 30 days.
+	MaxDevices int           `yaml:"max_devices"` // Per user. Default: 10.
+	// Unless set, admins always need a second factor.
+	IncludeAdmins bool `yaml:"include_admins"`
+}
+
+// trustedDeviceData is a browser trusted by the user. The browser holds a
+// signed cookie naming it, which stops working when the entry is removed.
+type trustedDeviceData struct {
+	CreatedAt        time.Time
+	CreatorAddr      string
+	CreatorUserAgent string
+	ExpiresAt        time.Time
+	Name             string
+	LastUsedAt       time.Time
+	LastUsedAddr     string
+}
+
+type trustedDeviceJWT struct {
+	Issuer     string   `json:"iss,omitempty"`
+	Subject    string   `json:"sub,omitempty"`
+	Audience   []string `json:"aud,omitempty"`
+	Expiration int64    `json:"exp,omitempty"`
+	NotBefore  int64    `json:"nbf,omitempty"`
+	IssuedAt   int64    `json:"iat,omitempty"`
+	TokenType  string   `json:"token_type"`
+	ID         string   `json:"jti,omitempty"`
+}
+
+func (config *trustedDevicesConfig) getLifetime() time.Duration {
+	if config.Lifetime > 0 {
+		return config.Lifetime
+	}
+	return defaultTrustedDeviceLifetime
+}
+
+func (config *trustedDevicesConfig) getMaxDevices() int {
+	if config.MaxDevices > 0 {
+		return config.MaxDevices
+	}
+	return defaultMaxTrustedDevices
+}
+
+// canTrustDevices returns true if username may use trusted devices.
+func (state *RuntimeState) canTrustDevices(username string) bool {
+	config := state.Config.TrustedDevices
+	if !config.Enabled {
+		return false
+	}
+	return config.IncludeAdmins || !state.IsAdminUser(username)
+}
+
+// removeStaleTrustedDevices removes the expired devices of the profile, and
+// then the oldest ones until there is room for one more.
+func removeStaleTrustedDevices(profile *userProfile, maxDevices int,
+	now time.Time) {
+	for index, device := range profile.TrustedDevices {
+		if !device.ExpiresAt.After(now) {
+			delete(profile.TrustedDevices, index)
+		}
+	}
+	for len(profile.TrustedDevices) >= maxDevices {
+		var oldestIndex int64
+		var oldest *trustedDeviceData
+		for index, device := range profile.TrustedDevices {
+			if oldest == nil || device.CreatedAt.Before(oldest.CreatedAt) {
+				oldestIndex = index
+				oldest = device
+			}
+		}
+		delete(profile.TrustedDevices, oldestIndex)
+	}
+}
+
+// trustDevice remembers the browser making the request, so that username can
+// later log in from it without a second factor. The session must have been
+// authenticated with one. It returns false if a failure response was
+// written.
+func (state *RuntimeState) trustDevice(w http.ResponseWriter,
+	r *http.Request, authData *authInfo) bool {
+	username := authData.Username
+	if !state.canTrustDevices(username) {
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Trusted devices are not enabled")
+		return false
+	}
+	if authData.SessionID == "" ||
+		(authData.AuthType&secondFactorAuthTypes) == 0 {
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Second factor authentication required")
+		return false
+	}
+	config := state.Config.TrustedDevices
+	now := time.Now()
+	device := trustedDeviceData{
+		CreatedAt:        now,
+		CreatorAddr:      r.RemoteAddr,
+		CreatorUserAgent: getTruncatedUserAgent(r),
+		ExpiresAt:        now.Add(config.getLifetime()),
+		Name:             "Browser",
+	}
+	defer state.lockUserProfile(username)()
+	profile, _, fromCache, err := state.LoadUserProfile(username)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return false
+	}
+	if fromCache {
+		http.Error(w, "db backend is offline for writes",
+			http.StatusServiceUnavailable)
+		return false
+	}
+	if profile.TrustedDevices == nil {
+		profile.TrustedDevices = make(map[int64]*trustedDeviceData)
+	}
+	removeStaleTrustedDevices(profile, config.getMaxDevices(), now)
+	index := now.Unix()
+	for ; profile.TrustedDevices[index] != nil; index++ {
+	}
+	profile.TrustedDevices[index] = &device
+	if err := state.SaveUserProfile(username, profile); err != nil {
+		logger.Printf("Saving profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return false
+	}
+	cookieVal, err := state.genTrustedDeviceJWT(username, index,
+		device.ExpiresAt)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return false
+	}
+	http.SetCookie(w, state.newTrustedDeviceCookie(cookieVal,
+		device.ExpiresAt))
+	logger.Printf("%s: trusted device %d from: %s", username, index,
+		r.RemoteAddr)
+	return true
+}
+
+func (state *RuntimeState) genTrustedDeviceJWT(username string, index int64,
+	expiresAt time.Time) (string, error) {
+	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256,
+		Key: state.Signer}, signerOptions)
+	if err != nil {
+		return "", err
+	}
+	issuer := state.idpGetIssuer()
+	token := trustedDeviceJWT{Issuer: issuer, Subject: username,
+		Audience: []string{issuer}, TokenType: trustedDeviceTokenType,
+		ID: strconv.FormatInt(index, 10)}
+	token.NotBefore = time.Now().Unix()
+	token.IssuedAt = token.NotBefore
+	token.Expiration = expiresAt.Unix()
+	return jwt.Signed(signer).Claims(token).CompactSerialize()
+}
+
+// getTrustedDeviceIndex returns the index of the trusted device of username
+// named by the signed cookie value.
+func (state *RuntimeState) getTrustedDeviceIndex(serializedToken,
+	username string) (int64, error) {
+	tok, err := jwt.ParseSigned(serializedToken)
+	if err != nil {
+		return 0, err
+	}
+	inboundJWT := trustedDeviceJWT{}
+	if err := state.JWTClaims(tok, &inboundJWT); err != nil {
+		return 0, err
+	}
+	issuer := state.idpGetIssuer()
+	now := time.Now().Unix()
+	if inboundJWT.Issuer != issuer ||
+		inboundJWT.TokenType != trustedDeviceTokenType ||
+		len(inboundJWT.Audience) < 1 || inboundJWT.Audience[0] != issuer ||
+		inboundJWT.NotBefore > now || inboundJWT.Expiration <= now {
+		return 0, errors.New("invalid JWT values")
+	}
+	if inboundJWT.Subject != username {
+		return 0, errors.New("trusted device of another user")
+	}
+	return strconv.ParseInt(inboundJWT.ID, 10, 64)
+}
+
+// isTrustedDevice returns true if the request comes from a browser which
+// username trusted and has not revoked.
+func (state *RuntimeState) isTrustedDevice(r *http.Request,
+	username string) bool {
+	if !state.canTrustDevices(username) {
+		return false
+	}
+	cookie, err := r.Cookie(trustedDeviceCookieName)
+	if err != nil {
+		return false
+	}
+	index, err := state.getTrustedDeviceIndex(cookie.Value, username)
+	if err != nil {
+		logger.Debugf(1, "ignoring trusted device cookie of: %s: %s",
+			username, err)
+		return false
+	}
+	defer state.lockUserProfile(username)()
+	profile, _, fromCache, err := state.LoadUserProfile(username)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		return false
+	}
+	device, ok := profile.TrustedDevices[index]
+	if !ok || !device.ExpiresAt.After(time.Now()) {
+		logger.Printf("%s: trusted device %d has been revoked or expired",
+			username, index)
+		return false
+	}
+	if !fromCache {
+		device.LastUsedAt = time.Now()
+		device.LastUsedAddr = r.RemoteAddr
+		if err := state.SaveUserProfile(username, profile); err != nil {
+			logger.Printf("Saving profile error: %v", err)
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webapi/v0/proto"
+)
+
+func TestRemoveStaleTrustedDevices(t *testing.T) {
+	now := time.Now()
+	profile := &userProfile{
+		TrustedDevices: map[int64]*trustedDeviceData{
+			1: {CreatedAt: now.Add(-3 * time.Hour), ExpiresAt: now},
+			2: {CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour)},
+			3: {CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		},
+	}
+	removeStaleTrustedDevices(profile, 2, now)
+	if len(profile.TrustedDevices) != 1 || profile.TrustedDevices[3] == nil {
+		t.Fatalf("unexpected devices left: %+v", profile.TrustedDevices)
+	}
+}
+
+func TestTrustDevice(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypeU2F}
+	newRequest := func(authType int, form url.Values) *http.Request {
+		cookieVal, err := state.setNewAuthCookie(nil, "username", authType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", devicesAPIPath,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		return req
+	}
+	trustForm := url.Values{"action": {"Trust"}}
+	_, err = checkRequestHandlerCode(newRequest(AuthTypeU2F, trustForm),
+		state.devicesAPIHandler, http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.TrustedDevices.Enabled = true
+	if state.getWebUILoginAuthLevel()&AuthTypeTrustedDevice == 0 {
+		t.Fatal("trusted devices not accepted for the web UI")
+	}
+	if state.getRequiredWebUIAuthLevel()&AuthTypeTrustedDevice != 0 {
+		t.Fatal("trusted devices accepted for factor registration")
+	}
+	// A trusted device session cannot change devices.
+	_, err = checkRequestHandlerCode(
+		newRequest(AuthTypePassword|AuthTypeTrustedDevice, trustForm),
+		state.devicesAPIHandler, http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(newRequest(AuthTypePassword, trustForm),
+		state.devicesAPIHandler, http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(newRequest(AuthTypeU2F, trustForm),
+		state.devicesAPIHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deviceCookie *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == trustedDeviceCookieName {
+			deviceCookie = cookie
+		}
+	}
+	if deviceCookie == nil {
+		t.Fatal("no trusted device cookie set")
+	}
+	loginRequest, err := http.NewRequest("POST", proto.LoginPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginRequest.AddCookie(deviceCookie)
+	if !state.isTrustedDevice(loginRequest, "username") {
+		t.Fatal("device not trusted")
+	}
+	if state.isTrustedDevice(loginRequest, "otheruser") {
+		t.Fatal("device trusted for another user")
+	}
+	profile, _, _, err := state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.TrustedDevices) != 1 {
+		t.Fatalf("expected 1 trusted device, got: %d",
+			len(profile.TrustedDevices))
+	}
+	for index, device := range profile.TrustedDevices {
+		if device.LastUsedAt.IsZero() {
+			t.Fatal("last use not recorded")
+		}
+		revokeForm := url.Values{
+			"type":   {deviceTypeTrusted},
+			"index":  {strconv.FormatInt(index, 10)},
+			"action": {"Delete"},
+		}
+		_, err = checkRequestHandlerCode(newRequest(AuthTypeU2F, revokeForm),
+			state.devicesAPIHandler, http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state.isTrustedDevice(loginRequest, "username") {
+		t.Fatal("revoked device still trusted")
+	}
+}
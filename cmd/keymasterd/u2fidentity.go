@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webauthn"
+	"github.com/tstranex/u2f"
+)
+
+// u2fConfig sets how keymaster identifies itself to security keys. The
+// defaults suit a server which users reach directly at its host identity and
+// HTTP address. Keys registered under one app ID or relying party ID cannot
+// be used under another, so these should not change once set.
+type u2fConfig struct {
+	AppID string `yaml:"app_id"` // Default: https://<host identity>[:port].
+	// The WebAuthn relying party ID. Default: the host name of app_id.
+	RPID string `yaml:"rp_id"`
+	// The origins users load the web UI from. Default: app_id.
+	Origins []string `yaml:"origins"`
+	// The origins U2F responses may come from. Default: origins and app_id.
+	TrustedFacets []string `yaml:"trusted_facets"`
+	// Allow logging in with a passkey alone, without a username or password.
+	PasskeyLogin bool `yaml:"passkey_login"`
+}
+
+// Used until setupU2FIdentity is called.
+const defaultU2FAppID = "https://www.example.com:33443"
+
+// u2fIdentity is how keymaster identifies itself to security keys.
+type u2fIdentity struct {
+	appID         string
+	trustedFacets []string
+	relyingParty  *webauthn.RelyingParty
+}
+
+func (state *RuntimeState) getU2FIdentity() *u2fIdentity {
+	if state.u2fIdentity.appID == "" {
+		return &u2fIdentity{appID: defaultU2FAppID,
+			trustedFacets: []string{defaultU2FAppID}}
+	}
+	return &state.u2fIdentity
+}
+
+func (state *RuntimeState) newU2FChallenge() (*u2f.Challenge, error) {
+	identity := state.getU2FIdentity()
+	return u2f.NewChallenge(identity.appID, identity.trustedFacets)
+}
+
+// publicURL returns the URL which users reach keymaster at.
+func (identity *u2fIdentity) publicURL() string {
+	if identity.relyingParty != nil && len(identity.relyingParty.Origins) > 0 {
+		return identity.relyingParty.Origins[0]
+	}
+	return identity.appID
+}
+
+// hostInDomain returns true if hostname is domain or a subdomain of it.
+func hostInDomain(hostname, domain string) bool {
+	return hostname == domain || strings.HasSuffix(hostname, "."+domain)
+}
+
+// parseHTTPSOrigin returns value as an origin, such as
+// https://keymaster.example.com, and its host name.
+func parseHTTPSOrigin(value string) (string, string, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "https" || u.Hostname() == "" || (u.Path != "" &&
+		u.Path != "/") || u.RawQuery != "" {
+		return "", "", fmt.Errorf("invalid origin: %s", value)
+	}
+	return u.Scheme + "://" + u.Host, u.Hostname(), nil
+}
+
+// setupU2FIdentity sets the U2F app ID, trusted facets and WebAuthn relying
+// party from the config, or from the host identity and HTTP address.
+func (state *RuntimeState) setupU2FIdentity() error {
+	config := state.Config.U2F
+	appID := config.AppID
+	if appID == "" {
+		// TODO: This assumes httpAddress is just the port..
+		appID = "https://" + state.HostIdentity
+		if state.Config.Base.HttpAddress != ":443" {
+			appID = appID + state.Config.Base.HttpAddress
+		}
+	}
+	appIDURL, err := url.Parse(appID)
+	if err != nil {
+		return fmt.Errorf("invalid U2F app_id: %s", err)
+	}
+	if appIDURL.Scheme != "https" || appIDURL.Hostname() == "" {
+		return fmt.Errorf("U2F app_id: %s is not a https URL", appID)
+	}
+	rpID := config.RPID
+	if rpID == "" {
+		rpID = appIDURL.Hostname()
+	}
+	if !hostInDomain(appIDURL.Hostname(), rpID) &&
+		!hostInDomain(rpID, appIDURL.Hostname()) {
+		return fmt.Errorf("U2F app_id: %s does not match rp_id: %s",
+			appID, rpID)
+	}
+	var origins []string
+	if len(config.Origins) > 0 {
+		origins = config.Origins
+	} else {
+		origin, _, err := parseHTTPSOrigin(appID)
+		if err != nil {
+			return fmt.Errorf("U2F origins must be set for app_id: %s",
+				appID)
+		}
+		origins = []string{origin}
+	}
+	rp, err := webauthn.NewWithOrigins(rpID, origins, appID)
+	if err != nil {
+		return fmt.Errorf("invalid U2F origins: %s", err)
+	}
+	trustedFacets := config.TrustedFacets
+	if len(trustedFacets) < 1 {
+		trustedFacets = append(trustedFacets, rp.Origins...)
+		// The CLI uses the app ID as the facet.
+		if !stringInList(appID, trustedFacets) {
+			trustedFacets = append(trustedFacets, appID)
+		}
+	} else {
+		for _, facet := range trustedFacets {
+			if _, _, err := parseHTTPSOrigin(facet); err != nil {
+				return fmt.Errorf("invalid U2F trusted facet: %s", err)
+			}
+		}
+	}
+	state.u2fIdentity = u2fIdentity{
+		appID:         appID,
+		trustedFacets: trustedFacets,
+		relyingParty:  rp,
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetupU2FIdentity(t *testing.T) {
+	state := &RuntimeState{HostIdentity: "keymaster.example.com"}
+	state.Config.Base.HttpAddress = ":443"
+	if err := state.setupU2FIdentity(); err != nil {
+		t.Fatal(err)
+	}
+	identity := state.u2fIdentity
+	if identity.appID != "https://keymaster.example.com" ||
+		identity.relyingParty.ID != "keymaster.example.com" {
+		t.Fatalf("bad defaults: %s, %s", identity.appID,
+			identity.relyingParty.ID)
+	}
+	if !reflect.DeepEqual(identity.trustedFacets,
+		[]string{"https://keymaster.example.com"}) {
+		t.Fatalf("bad default facets: %v", identity.trustedFacets)
+	}
+	state.Config.Base.HttpAddress = ":33443"
+	if err := state.setupU2FIdentity(); err != nil {
+		t.Fatal(err)
+	}
+	if state.u2fIdentity.appID != "https://keymaster.example.com:33443" {
+		t.Fatalf("bad default app ID: %s", state.u2fIdentity.appID)
+	}
+	// Behind a load balancer on another port, within a shared domain.
+	state.Config.U2F = u2fConfig{
+		AppID: "https://keymaster.example.com",
+		RPID:  "example.com",
+		Origins: []string{"https://keymaster.example.com",
+			"https://keymaster-west.example.com"},
+	}
+	if err := state.setupU2FIdentity(); err != nil {
+		t.Fatal(err)
+	}
+	identity = state.u2fIdentity
+	if identity.relyingParty.ID != "example.com" ||
+		len(identity.relyingParty.Origins) != 2 {
+		t.Fatalf("bad relying party: %+v", identity.relyingParty)
+	}
+	if len(identity.trustedFacets) != 2 {
+		t.Fatalf("bad facets: %v", identity.trustedFacets)
+	}
+	if identity.publicURL() != "https://keymaster.example.com" {
+		t.Fatalf("bad public URL: %s", identity.publicURL())
+	}
+	badConfigs := []u2fConfig{
+		{AppID: "http://keymaster.example.com"},
+		{AppID: "https://keymaster.example.com", RPID: "other.com"},
+		{AppID: "https://keymaster.example.com",
+			Origins: []string{"https://keymaster.evil.com"}},
+		{AppID: "https://example.com/facets.json"},
+		{AppID: "https://keymaster.example.com",
+			TrustedFacets: []string{"keymaster.example.com"}},
+	}
+	for _, config := range badConfigs {
+		state.Config.U2F = config
+		if err := state.setupU2FIdentity(); err == nil {
+			t.Errorf("accepted bad config: %+v", config)
+		}
+	}
+}
@@ -11,8 +11,8 @@ import (
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/armor"
 
-	"github.com/Cloud-Foundations/golib/pkg/awsutil/metadata"
 	"github.com/Cloud-Foundations/golib/pkg/awsutil/secretsmgr"
+	"github.com/Cloud-Foundations/keymaster/keymasterd/alerting"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 )
 
@@ -35,20 +35,21 @@ func (state *RuntimeState) readyzHandler(w http.ResponseWriter,
 
 func (state *RuntimeState) secretInjectorHandler(w http.ResponseWriter,
 	r *http.Request) {
-	// checks this is only allowed when using TLS client certs.. all other authn
-	// mechanisms are considered invalid... for now no authz mechanisms are in
-	// place i.e. Any user with a valid cert can use this handler
-	if r.TLS == nil {
+	// checks this is only allowed when using TLS client certs or the admin
+	// socket.. all other authn mechanisms are considered invalid... for now no
+	// authz mechanisms are in place i.e. Any user with a valid cert can use
+	// this handler
+	if r.TLS == nil && !isAdminSocketRequest(r) {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		logger.Printf("We require TLS\n")
 		return
 	}
-	if len(r.TLS.VerifiedChains) < 1 {
+	clientName := getAdminClientName(r)
+	if clientName == "" {
 		state.writeFailureResponse(w, r, http.StatusForbidden, "")
 		logger.Printf("Forbidden\n")
 		return
 	}
-	clientName := r.TLS.VerifiedChains[0][0].Subject.CommonName
 	logger.Printf("Got connection from %s", clientName)
 	r.ParseForm()
 	sshCAPassword, ok := r.Form["ssh_ca_password"]
@@ -58,6 +59,11 @@ func (state *RuntimeState) secretInjectorHandler(w http.ResponseWriter,
 		logger.Printf("missing ssh_ca_password")
 		return
 	}
+	if state.Config.DualControlUnseal.enabled() {
+		state.dualControlSecretInjector(w, r, clientName,
+			[]byte(sshCAPassword[0]))
+		return
+	}
 	if err := state.unsealCA([]byte(sshCAPassword[0]), clientName); err != nil {
 		state.writeFailureResponse(w, r, http.StatusBadRequest,
 			"Invalid Post, "+err.Error())
@@ -69,26 +75,43 @@ func (state *RuntimeState) secretInjectorHandler(w http.ResponseWriter,
 	//fmt.Fprintf(w, "%+v\n", r.TLS)
 }
 
-func (state *RuntimeState) beginAutoUnseal() {
-	go state.autoUnsealAwsLoop()
-}
-
-func (state *RuntimeState) autoUnsealAwsLoop() {
-	if state.Config.Base.AutoUnseal.AwsSecretId == "" {
+func (state *RuntimeState) dualControlSecretInjector(w http.ResponseWriter,
+	r *http.Request, clientName string, part []byte) {
+	if state.Config.DualControlUnseal.getGroup(clientName) == "" {
+		state.writeFailureResponse(w, r, http.StatusForbidden, "")
+		logger.Printf("%s not in any unseal group", clientName)
 		return
 	}
-	metadataClient, err := metadata.GetMetadataClient()
+	unsealed, message, err := state.unsealWithPart(clientName, part)
 	if err != nil {
-		state.logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid Post, "+err.Error())
+		logger.Println(err)
+		return
+	}
+	if !unsealed {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Accepted, %s\n", message)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "OK, %s\n", message)
+}
+
+func (state *RuntimeState) beginAutoUnseal() {
+	go state.autoUnsealLoop()
+}
+
+func (state *RuntimeState) autoUnsealLoop() {
+	if !state.Config.Base.AutoUnseal.enabled() {
 		return
 	}
 	for {
 		if state.isUnsealed() {
 			return
 		}
-		if err := state.tryAwsUnseal(metadataClient); err != nil {
-			state.logger.Printf(
-				"error unsealing with AWS Secrets Manager: %s\n", err)
+		if err := state.tryAutoUnseal(); err != nil {
+			state.logger.Printf("error auto unsealing: %s\n", err)
 			state.logger.Println("will try again")
 			time.Sleep(time.Minute * 5)
 		} else {
@@ -98,8 +121,8 @@ func (state *RuntimeState) autoUnsealAwsLoop() {
 }
 
 func (state *RuntimeState) isUnsealed() bool {
-	state.Mutex.Lock()
-	defer state.Mutex.Unlock()
+	state.Mutex.RLock()
+	defer state.Mutex.RUnlock()
 	return state.Signer != nil
 }
 
@@ -175,6 +198,11 @@ func (state *RuntimeState) unsealCA(password []byte, clientName string) error {
 	if sendMessage {
 		state.SignerIsReady <- true
 	}
+	state.alerter.Send(alerting.Event{
+		Type:    alerting.EventSignerUnsealed,
+		Summary: "Signer unsealed by " + clientName,
+		Actor:   clientName,
+	})
 	// TODO... make success a goroutine
 	return nil
 }
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const deleteUserDataPath = "/admin/deleteUserData"
+
+// userDeletionReceipt records what was purged for a user. The tombstone
+// replaces the username in the audit records, so that they can still be
+// referred to.
+type userDeletionReceipt struct {
+	Username               string    `json:"username"`
+	DeletedAt              time.Time `json:"deleted_at"`
+	DeletedBy              string    `json:"deleted_by"`
+	Profile                bool      `json:"profile"`
+	Devices                int       `json:"devices"`
+	SessionsRevoked        int       `json:"sessions_revoked"`
+	AutomationTokens       int64     `json:"automation_tokens_revoked"`
+	AuditTombstone         string    `json:"audit_tombstone,omitempty"`
+	AuditRecords           int64     `json:"audit_records_tombstoned"`
+	RevocationsTombstoned  int64     `json:"revocations_tombstoned"`
+	IssuanceQuotaOverrides int64     `json:"issuance_quota_overrides"`
+}
+
+// signedUserDeletionReceipt carries the receipt as signed, since the
+// signature covers its exact encoding.
+type signedUserDeletionReceipt struct {
+	Receipt   json.RawMessage `json:"receipt"`
+	Signature []byte          `json:"signature"`
+}
+
+var deleteAllSignedUserDataStmt = map[string]string{
+	"sqlite":   "delete from expiring_signed_user_data where username = ?",
+	"postgres": "delete from expiring_signed_user_data where username = $1",
+}
+
+var revokeUserAutomationTokensStmt = map[string]string{
+	"sqlite":   "update automation_token set revoked_epoch = ?, revoked_by = ? where principal = ? and revoked_epoch = 0",
+	"postgres": "update automation_token set revoked_epoch = $1, revoked_by = $2 where principal = $3 and revoked_epoch = 0",
+}
+
+var tombstoneIssuedCertsStmt = map[string]string{
+	"sqlite":   "update issued_certificate set username = ?, key_id = '', source_address = '', user_agent = '', certificate = '', tombstone = ? where username = ?",
+	"postgres": "update issued_certificate set username = $1, key_id = '', source_address = '', user_agent = '', certificate = '', tombstone = $2 where username = $3",
+}
+
+var tombstoneRevokedCertsStmt = map[string]string{
+	"sqlite":   "update revoked_certificate set username = ? where username = ?",
+	"postgres": "update revoked_certificate set username = $1 where username = $2",
+}
+
+func userDeletionReceiptDigest(receipt []byte) []byte {
+	hasher := sha256.New()
+	hasher.Write([]byte("keymaster user deletion receipt\n"))
+	hasher.Write(receipt)
+	return hasher.Sum(nil)
+}
+
+func newAuditTombstone() (string, error) {
+	buffer := make([]byte, 8)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return "deleted-" + hex.EncodeToString(buffer), nil
+}
+
+func countProfileDevices(profile *userProfile) int {
+	return len(profile.U2fAuthData) + len(profile.TOTPAuthData) +
+		len(profile.TrustedDevices)
+}
+
+// deleteUserProfileData removes the profile of username, which holds its
+// U2F, WebAuthn and TOTP registrations and its trusted devices, and its
+// signed data, from both the primary and the cache databases.
+func (state *RuntimeState) deleteUserProfileData(username string,
+	receipt *userDeletionReceipt) error {
+	defer state.lockUserProfile(username)()
+	profile, ok, _, err := state.LoadUserProfile(username)
+	if err != nil {
+		return err
+	}
+	if ok {
+		receipt.Profile = true
+		receipt.Devices = countProfileDevices(profile)
+	}
+	if err := state.DeleteUserProfile(username); err != nil {
+		return err
+	}
+	_, err = state.db.Exec(deleteAllSignedUserDataStmt[state.dbType], username)
+	if err != nil {
+		return err
+	}
+	if state.cacheDB == nil {
+		return nil
+	}
+	_, err = state.cacheDB.Exec(deleteUserProfileStmt["sqlite"], username)
+	if err != nil {
+		return err
+	}
+	_, err = state.cacheDB.Exec(deleteAllSignedUserDataStmt["sqlite"],
+		username)
+	return err
+}
+
+// tombstoneUserAuditRecords replaces username and the personal data in its
+// issued and revoked certificate records with a random tombstone. The
+// records stay in the audit chain.
+func (state *RuntimeState) tombstoneUserAuditRecords(username string,
+	receipt *userDeletionReceipt) error {
+	tombstone, err := newAuditTombstone()
+	if err != nil {
+		return err
+	}
+	state.auditChainMutex.Lock()
+	defer state.auditChainMutex.Unlock()
+	result, err := state.db.Exec(tombstoneIssuedCertsStmt[state.dbType],
+		tombstone, tombstone, username)
+	if err != nil {
+		return err
+	}
+	receipt.AuditTombstone = tombstone
+	if receipt.AuditRecords, err = result.RowsAffected(); err != nil {
+		return err
+	}
+	result, err = state.db.Exec(tombstoneRevokedCertsStmt[state.dbType],
+		tombstone, username)
+	if err != nil {
+		return err
+	}
+	receipt.RevocationsTombstoned, err = result.RowsAffected()
+	return err
+}
+
+// purgeUserData revokes the sessions and automation tokens of username and
+// deletes its stored data. Each step can be repeated, so a failed purge may
+// be retried.
+func (state *RuntimeState) purgeUserData(username, deletedBy string,
+	tombstoneAudit bool) (*userDeletionReceipt, error) {
+	receipt := &userDeletionReceipt{
+		Username:  username,
+		DeletedAt: time.Now().Truncate(time.Second),
+		DeletedBy: deletedBy,
+	}
+	receipt.SessionsRevoked = state.sessions.revokeUser(username)
+	err := state.recordSessionRevocation(username, "", deletedBy)
+	if err != nil {
+		return nil, fmt.Errorf("cannot revoke sessions: %s", err)
+	}
+	result, err := state.db.Exec(revokeUserAutomationTokensStmt[state.dbType],
+		receipt.DeletedAt.Unix(), deletedBy, username)
+	if err != nil {
+		return nil, fmt.Errorf("cannot revoke automation tokens: %s", err)
+	}
+	if receipt.AutomationTokens, err = result.RowsAffected(); err != nil {
+		return nil, err
+	}
+	if err := state.deleteUserProfileData(username, receipt); err != nil {
+		return nil, fmt.Errorf("cannot delete profile: %s", err)
+	}
+	result, err = state.db.Exec(deleteIssuanceQuotaOverrideStmt[state.dbType],
+		username)
+	if err != nil {
+		return nil, fmt.Errorf("cannot delete issuance quota: %s", err)
+	}
+	if receipt.IssuanceQuotaOverrides, err = result.RowsAffected(); err != nil {
+		return nil, err
+	}
+	_, err = state.db.Exec(deleteUserLastSeenStmt[state.dbType], username)
+	if err != nil {
+		return nil, fmt.Errorf("cannot delete last seen time: %s", err)
+	}
+	state.userSeen.forget(username)
+	if tombstoneAudit {
+		err := state.tombstoneUserAuditRecords(username, receipt)
+		if err != nil {
+			return nil, fmt.Errorf("cannot tombstone audit records: %s", err)
+		}
+	}
+	return receipt, nil
+}
+
+func (state *RuntimeState) signUserDeletionReceipt(
+	receipt *userDeletionReceipt) (*signedUserDeletionReceipt, error) {
+	state.Mutex.RLock()
+	signer := state.Signer
+	state.Mutex.RUnlock()
+	if signer == nil {
+		return nil, errors.New("signer not loaded")
+	}
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signDigest(signer, userDeletionReceiptDigest(data))
+	if err != nil {
+		return nil, err
+	}
+	return &signedUserDeletionReceipt{Receipt: data, Signature: signature}, nil
+}
+
+// deleteUserDataHandler purges the data of the user given by the username
+// form value and returns a receipt signed with the CA key. Audit records
+// are tombstoned if tombstone_audit=true.
+func (state *RuntimeState) deleteUserDataHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	failure, authUser := state.sendFailureToClientIfNonAdmin(w, r)
+	if failure {
+		return
+	}
+	username := state.ensurePostAndGetUsername(w, r)
+	if username == "" {
+		return
+	}
+	receipt, err := state.purgeUserData(username, authUser,
+		r.Form.Get("tombstone_audit") == "true")
+	if err != nil {
+		state.logger.Printf("error deleting data of %s: %s", username, err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	signedReceipt, err := state.signUserDeletionReceipt(receipt)
+	if err != nil {
+		state.logger.Printf("error signing deletion receipt: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	state.logger.Printf(
+		"%s: deleted data of: %s, audit tombstone: %q, receipt: %s\n",
+		authUser, username, receipt.AuditTombstone,
+		hex.EncodeToString(userDeletionReceiptDigest(signedReceipt.Receipt)))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(signedReceipt); err != nil {
+		state.logger.Printf("json encoding error: %v", err)
+	}
+}
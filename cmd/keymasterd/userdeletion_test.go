@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPurgeUserData(t *testing.T) {
+	state, tmpdir, err := testCreateRuntimeStateWithBothCAs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	testRecordChainedCertificates(state, 2)
+	now := time.Now()
+	state.recordIssuedCertificate(issuedCertRecord{
+		Username:    "bob",
+		CertType:    "ssh",
+		Serial:      "100",
+		KeyID:       "bob",
+		Fingerprint: "SHA256:bob",
+		IssuedAt:    now,
+		ExpiresAt:   now.Add(time.Hour),
+		SourceAddr:  "127.0.0.1:1234",
+		issuanceContext: issuanceContext{
+			UserAgent: "keymaster",
+		},
+	})
+	testRecordChainedCertificates(state, 1)
+	if _, err := state.anchorAuditChain(0); err != nil {
+		t.Fatal(err)
+	}
+	profile := &userProfile{
+		TOTPAuthData: map[int64]*totpAuthData{1: {Enabled: true}},
+	}
+	if err := state.SaveUserProfile("bob", profile); err != nil {
+		t.Fatal(err)
+	}
+	receipt, err := state.purgeUserData("bob", "alice", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !receipt.Profile || receipt.Devices != 1 ||
+		receipt.AuditRecords != 1 || receipt.AuditTombstone == "" {
+		t.Fatalf("unexpected receipt: %+v", receipt)
+	}
+	if _, ok, _, err := state.LoadUserProfile("bob"); err != nil || ok {
+		t.Fatalf("profile not deleted: %v", err)
+	}
+	records, err := state.getIssuedCertificates("bob", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("audit records not tombstoned: %+v", records)
+	}
+	records, err = state.getIssuedCertificates(receipt.AuditTombstone, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].SourceAddr != "" ||
+		records[0].Serial != "100" {
+		t.Fatalf("unexpected tombstoned records: %+v", records)
+	}
+	// Tombstoned records do not break the chain.
+	status, err := state.verifyAuditChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Error != "" || status.Records != 4 || status.Tombstoned != 1 ||
+		status.VerifiedAnchors != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	// Purging again is harmless.
+	if _, err := state.purgeUserData("bob", "alice", true); err != nil {
+		t.Fatal(err)
+	}
+	signedReceipt, err := state.signUserDeletionReceipt(receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifyDigestSignature(state.Signer.Public(),
+		userDeletionReceiptDigest(signedReceipt.Receipt),
+		signedReceipt.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded userDeletionReceipt
+	if err := json.Unmarshal(signedReceipt.Receipt, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Username != "bob" || decoded.DeletedBy != "alice" {
+		t.Fatalf("unexpected decoded receipt: %+v", decoded)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+)
+
+type userLock struct {
+	mutex   sync.Mutex
+	waiters uint
+}
+
+// userLocks is a set of per-user mutexes. Entries are removed once nobody
+// holds or waits for them, so the zero value is ready to use and the set
+// does not grow with the number of users ever seen.
+type userLocks struct {
+	mutex sync.Mutex
+	locks map[string]*userLock
+}
+
+// lock acquires the mutex for username and returns the function which
+// releases it.
+func (ul *userLocks) lock(username string) func() {
+	ul.mutex.Lock()
+	if ul.locks == nil {
+		ul.locks = make(map[string]*userLock)
+	}
+	entry, ok := ul.locks[username]
+	if !ok {
+		entry = &userLock{}
+		ul.locks[username] = entry
+	}
+	entry.waiters++
+	ul.mutex.Unlock()
+	entry.mutex.Lock()
+	return func() {
+		entry.mutex.Unlock()
+		ul.mutex.Lock()
+		entry.waiters--
+		if entry.waiters == 0 {
+			delete(ul.locks, username)
+		}
+		ul.mutex.Unlock()
+	}
+}
+
+// lockUserProfile serialises load-modify-save sequences on the profile of
+// username. Callers must not hold another profile lock.
+func (state *RuntimeState) lockUserProfile(username string) func() {
+	return state.profileLocks.lock(username)
+}
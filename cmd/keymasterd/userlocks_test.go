@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestUserLocks(t *testing.T) {
+	var locks userLocks
+	var wg sync.WaitGroup
+	// Each user has their own counter, so only goroutines for the same user
+	// may race.
+	counters := map[string]*int{"alice": new(int), "bob": new(int)}
+	for i := 0; i < 100; i++ {
+		for username, counter := range counters {
+			wg.Add(1)
+			go func(username string, counter *int) {
+				defer wg.Done()
+				defer locks.lock(username)()
+				*counter++
+			}(username, counter)
+		}
+	}
+	wg.Wait()
+	for username, counter := range counters {
+		if *counter != 100 {
+			t.Errorf("lost updates for %s: %d", username, *counter)
+		}
+	}
+	if len(locks.locks) != 0 {
+		t.Fatalf("%d locks not released", len(locks.locks))
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// usernameConfig configures how the usernames presented by users and
+// identity providers are mapped to the canonical username, which is used for
+// profiles, policy and certificate principals.
+type usernameConfig struct {
+	StripRealm  bool              `yaml:"strip_realm"`  // user@REALM -> user
+	StripDomain bool              `yaml:"strip_domain"` // DOMAIN\user -> user
+	Aliases     map[string]string `yaml:"aliases"`      // Alias -> canonical.
+}
+
+func (config *usernameConfig) stripQualifiers(username string) string {
+	if config.StripDomain {
+		if index := strings.LastIndex(username, `\`); index >= 0 {
+			username = username[index+1:]
+		}
+	}
+	if config.StripRealm {
+		if index := strings.Index(username, "@"); index >= 0 {
+			username = username[:index]
+		}
+	}
+	return username
+}
+
+// normalizeUsername strips qualifiers and folds the case of username,
+// according to the configuration.
+func (state *RuntimeState) normalizeUsername(username string) string {
+	username = state.Config.Usernames.stripQualifiers(username)
+	if !state.Config.Base.DisableUsernameNormalization {
+		username = strings.ToLower(username)
+	}
+	return username
+}
+
+// resolveUsernameAlias returns the canonical username if username is an
+// alias, else username unchanged.
+func (state *RuntimeState) resolveUsernameAlias(username string) string {
+	if canonical, ok := state.usernameAliases[state.normalizeUsername(
+		username)]; ok {
+		return canonical
+	}
+	return username
+}
+
+// canonicalUsername returns the canonical form of a username which is known
+// to be valid, such as one from the configuration.
+func (state *RuntimeState) canonicalUsername(username string) string {
+	return state.resolveUsernameAlias(state.normalizeUsername(username))
+}
+
+func (state *RuntimeState) canonicalUsernames(usernames []string) []string {
+	canonical := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		canonical = append(canonical, state.canonicalUsername(username))
+	}
+	return canonical
+}
+
+// setupUsernameNormalization loads the alias map and rewrites the usernames
+// in the configuration to their canonical form, so that they match the
+// usernames of authenticated users.
+func (state *RuntimeState) setupUsernameNormalization() error {
+	state.usernameAliases = make(map[string]string,
+		len(state.Config.Usernames.Aliases))
+	for alias, canonical := range state.Config.Usernames.Aliases {
+		canonical = state.normalizeUsername(canonical)
+		if !validUsernameRE.MatchString(canonical) {
+			return fmt.Errorf("invalid canonical username: %q for alias: %q",
+				canonical, alias)
+		}
+		state.usernameAliases[state.normalizeUsername(alias)] = canonical
+	}
+	for alias, canonical := range state.usernameAliases {
+		if alias == canonical {
+			delete(state.usernameAliases, alias)
+			continue
+		}
+		if _, ok := state.usernameAliases[canonical]; ok {
+			return fmt.Errorf("alias: %q maps to another alias: %q",
+				alias, canonical)
+		}
+	}
+	baseConfig := &state.Config.Base
+	baseConfig.AdminUsers = state.canonicalUsernames(baseConfig.AdminUsers)
+	baseConfig.AutomationUsers = state.canonicalUsernames(
+		baseConfig.AutomationUsers)
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReprocessUsername(t *testing.T) {
+	state := &RuntimeState{}
+	state.Config.Usernames = usernameConfig{
+		StripRealm:  true,
+		StripDomain: true,
+		Aliases:     map[string]string{"John.Smith": "JSmith"},
+	}
+	state.Config.Base.AdminUsers = []string{"JSmith@CORP", "john.smith"}
+	if err := state.setupUsernameNormalization(); err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{
+		"JSmith@CORP", "jsmith", `CORP\jsmith`, "john.smith", "John.Smith@CORP",
+	} {
+		if output := state.reprocessUsername(input); output != "jsmith" {
+			t.Errorf("input: %q, output: %q != jsmith", input, output)
+		}
+	}
+	if output := state.reprocessUsername("other"); output != "other" {
+		t.Errorf("output: %q != other", output)
+	}
+	for _, adminUser := range state.Config.Base.AdminUsers {
+		if adminUser != "jsmith" {
+			t.Errorf("admin user not canonical: %q", adminUser)
+		}
+	}
+	// Admins may refer to users by alias, but other input is left alone.
+	if output := state.resolveUsernameAlias("john.smith"); output != "jsmith" {
+		t.Errorf("alias not resolved: %q", output)
+	}
+	if output := state.resolveUsernameAlias("Other"); output != "Other" {
+		t.Errorf("output: %q != Other", output)
+	}
+}
+
+func TestReprocessUsernameDefaults(t *testing.T) {
+	state := &RuntimeState{}
+	if err := state.setupUsernameNormalization(); err != nil {
+		t.Fatal(err)
+	}
+	for input, expected := range map[string]string{
+		"JSmith":      "jsmith",
+		"jsmith@CORP": "jsmith@corp",
+		`CORP\jsmith`: `corp\jsmith`,
+	} {
+		if output := state.reprocessUsername(input); output != expected {
+			t.Errorf("input: %q, output: %q != %q", input, output, expected)
+		}
+	}
+	state.Config.Base.DisableUsernameNormalization = true
+	if output := state.reprocessUsername("JSmith"); output != "JSmith" {
+		t.Errorf("output: %q != JSmith", output)
+	}
+}
+
+func TestSetupUsernameNormalizationErrors(t *testing.T) {
+	for _, aliases := range []map[string]string{
+		{"a": "b", "b": "c"},
+		{"a": "bad user"},
+	} {
+		state := &RuntimeState{}
+		state.Config.Usernames.Aliases = aliases
+		if err := state.setupUsernameNormalization(); err == nil {
+			t.Errorf("no error for aliases: %v", aliases)
+		}
+	}
+	state := &RuntimeState{}
+	state.Config.Usernames.Aliases = map[string]string{"A": "a"}
+	if err := state.setupUsernameNormalization(); err != nil {
+		t.Fatal(err)
+	}
+}
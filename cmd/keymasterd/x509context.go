@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/x509"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+)
+
+// x509ContextConfig adds the issuance context extension to X.509
+// user certificates, so that relying parties can see how strongly and how
+// recently the user authenticated.
+type x509ContextConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// getAuthStrength rates authType. A trusted device skips the second factor,
+// so it does not count as one.
+func getAuthStrength(authType int) int {
+	if authType&AuthTypeU2F != 0 {
+		return certgen.AuthStrengthPhishingResistant
+	}
+	const otherSecondFactors = AuthTypeSymantecVIP | AuthTypeTOTP |
+		AuthTypeOkta2FA | AuthTypeBootstrapOTP
+	if authType&otherSecondFactors != 0 {
+		return certgen.AuthStrengthMultiFactor
+	}
+	return certgen.AuthStrengthSingleFactor
+}
+
+// getRenewalAuthentication returns the authentication strength and time for
+// a certificate issued in exchange for cert. The holder of cert only proved
+// possession of its key, so the authentication it was issued for carries
+// over; without an issuance context this is a single factor, no later than
+// the issuance of cert.
+func getRenewalAuthentication(cert *x509.Certificate) (int, time.Time) {
+	context, err := certgen.GetX509IssuanceContext(cert)
+	if err != nil || context == nil {
+		return certgen.AuthStrengthSingleFactor, cert.NotBefore
+	}
+	return context.AuthStrength, context.AuthTime
+}
+
+// getX509IssuanceContext returns the issuance context to embed in an X.509
+// certificate, or nil if it is not enabled.
+func (state *RuntimeState) getX509IssuanceContext(
+	issuance issuanceContext) *certgen.X509IssuanceContext {
+	if !state.Config.X509IssuanceContext.Enabled {
+		return nil
+	}
+	return &certgen.X509IssuanceContext{
+		AuditID:      issuance.AuditID,
+		AuthMethod:   issuance.AuthMethod,
+		AuthStrength: issuance.authStrength,
+		AuthTime:     issuance.authTime,
+		DeviceID:     issuance.deviceID,
+	}
+}
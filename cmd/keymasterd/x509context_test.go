@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+)
+
+func TestGetAuthStrength(t *testing.T) {
+	for _, test := range []struct {
+		authType int
+		expected int
+	}{
+		{AuthTypePassword, certgen.AuthStrengthSingleFactor},
+		{AuthTypePassword | AuthTypeTrustedDevice,
+			certgen.AuthStrengthSingleFactor},
+		{AuthTypeKeymasterX509, certgen.AuthStrengthSingleFactor},
+		{AuthTypePassword | AuthTypeTOTP, certgen.AuthStrengthMultiFactor},
+		{AuthTypePassword | AuthTypeOkta2FA, certgen.AuthStrengthMultiFactor},
+		{AuthTypePassword | AuthTypeU2F,
+			certgen.AuthStrengthPhishingResistant},
+		{AuthTypeU2F, certgen.AuthStrengthPhishingResistant},
+	} {
+		if strength := getAuthStrength(test.authType); strength !=
+			test.expected {
+			t.Errorf("%s: expected %d, got %d", getAuthMethod(test.authType),
+				test.expected, strength)
+		}
+	}
+}
+
+func TestGetRenewalAuthentication(t *testing.T) {
+	authTime := time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+	value, err := asn1.Marshal(certgen.X509IssuanceContext{
+		AuthMethod:   "password+U2F",
+		AuthStrength: certgen.AuthStrengthPhishingResistant,
+		AuthTime:     authTime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &x509.Certificate{Extensions: []pkix.Extension{
+		{Id: certgen.IssuanceContextOID, Value: value},
+	}}
+	strength, renewalAuthTime := getRenewalAuthentication(cert)
+	if strength != certgen.AuthStrengthPhishingResistant {
+		t.Errorf("unexpected strength: %d", strength)
+	}
+	if !renewalAuthTime.Equal(authTime) {
+		t.Errorf("unexpected auth time: %s", renewalAuthTime)
+	}
+	notBefore := time.Now().Add(-time.Hour)
+	strength, renewalAuthTime = getRenewalAuthentication(
+		&x509.Certificate{NotBefore: notBefore})
+	if strength != certgen.AuthStrengthSingleFactor {
+		t.Errorf("unexpected strength: %d", strength)
+	}
+	if !renewalAuthTime.Equal(notBefore) {
+		t.Errorf("unexpected auth time: %s", renewalAuthTime)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Cloud-Foundations/keymaster/lib/certgen"
+)
+
+var validDNSNameRE = regexp.MustCompile(
+	`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// x509SANConfig controls the subject alternative names of X.509 user
+// certificates. The e-mail addresses come from the directory, see
+// LDAPCertificateAttributes. In the patterns %s is replaced by the username.
+type x509SANConfig struct {
+	OmitKerberosPrincipal bool   `yaml:"omit_kerberos_principal"`
+	OmitEmailAddresses    bool   `yaml:"omit_email_addresses"`
+	UserPrincipalName     string `yaml:"user_principal_name"`
+	// Only for automation users, which are services rather than people.
+	ServiceDNSNames []string `yaml:"service_dns_names"`
+	URIs            []string `yaml:"uris"`
+}
+
+func expandSANPattern(pattern, username string) string {
+	return strings.Replace(pattern, "%s", username, -1)
+}
+
+func (config *x509SANConfig) check() error {
+	for _, pattern := range config.ServiceDNSNames {
+		if !validDNSNameRE.MatchString(expandSANPattern(pattern, "service")) {
+			return fmt.Errorf("x509_user_sans: invalid DNS name: %s", pattern)
+		}
+	}
+	for _, pattern := range config.URIs {
+		uri, err := url.Parse(expandSANPattern(pattern, "username"))
+		if err != nil {
+			return fmt.Errorf("x509_user_sans: %s", err)
+		}
+		if uri.Scheme == "" {
+			return fmt.Errorf("x509_user_sans: URI without scheme: %s",
+				pattern)
+		}
+	}
+	return nil
+}
+
+// addX509SANs applies the SAN configuration to the identity of username.
+func (state *RuntimeState) addX509SANs(identity certgen.UserIdentity) (
+	certgen.UserIdentity, error) {
+	config := state.Config.X509UserSANs
+	username := identity.Username
+	identity.OmitKerberosPrincipal = config.OmitKerberosPrincipal
+	if config.OmitEmailAddresses {
+		identity.EmailAddresses = nil
+	}
+	if identity.UserPrincipalName == "" && config.UserPrincipalName != "" {
+		identity.UserPrincipalName = expandSANPattern(config.UserPrincipalName,
+			username)
+	}
+	for _, pattern := range config.URIs {
+		uri, err := url.Parse(expandSANPattern(pattern,
+			url.PathEscape(username)))
+		if err != nil {
+			return identity, err
+		}
+		identity.URIs = append(identity.URIs, uri)
+	}
+	if len(config.ServiceDNSNames) < 1 {
+		return identity, nil
+	}
+	isService, err := state.isAutomationUser(username)
+	if err != nil {
+		return identity, err
+	}
+	if !isService {
+		return identity, nil
+	}
+	for _, pattern := range config.ServiceDNSNames {
+		dnsName := expandSANPattern(pattern, username)
+		if !validDNSNameRE.MatchString(dnsName) {
+			logger.Printf("ignoring invalid DNS name: %q of: %s",
+				dnsName, username)
+			continue
+		}
+		identity.DNSNames = append(identity.DNSNames, dnsName)
+	}
+	return identity, nil
+}
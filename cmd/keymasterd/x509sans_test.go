@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestX509SANConfigCheck(t *testing.T) {
+	for _, config := range []x509SANConfig{
+		{ServiceDNSNames: []string{"%s.bad_domain.example.com"}},
+		{ServiceDNSNames: []string{"%s..example.com"}},
+		{URIs: []string{"example.com/user/%s"}},
+		{URIs: []string{"spiffe://%zz/%s"}},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("invalid configuration accepted: %+v", config)
+		}
+	}
+	config := x509SANConfig{
+		UserPrincipalName: "%s@corp.example.com",
+		ServiceDNSNames:   []string{"%s.svc.example.com"},
+		URIs:              []string{"spiffe://example.com/user/%s"},
+	}
+	if err := config.check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetCertificateIdentitySANs(t *testing.T) {
+	state := &RuntimeState{}
+	state.Config.Base.AutomationUsers = []string{"build_bot", "deployer"}
+	state.Config.X509UserSANs = x509SANConfig{
+		OmitKerberosPrincipal: true,
+		UserPrincipalName:     "%s@corp.example.com",
+		ServiceDNSNames:       []string{"%s.svc.example.com"},
+		URIs:                  []string{"spiffe://example.com/user/%s"},
+	}
+	identity, err := state.getCertificateIdentity("jsmith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identity.OmitKerberosPrincipal ||
+		identity.UserPrincipalName != "jsmith@corp.example.com" ||
+		len(identity.DNSNames) != 0 || len(identity.URIs) != 1 ||
+		identity.URIs[0].String() != "spiffe://example.com/user/jsmith" {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+	identity, err = state.getCertificateIdentity("deployer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identity.DNSNames) != 1 ||
+		identity.DNSNames[0] != "deployer.svc.example.com" {
+		t.Fatalf("unexpected DNS names: %v", identity.DNSNames)
+	}
+	// Usernames which are not valid host names get no DNS names.
+	identity, err = state.getCertificateIdentity("build_bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identity.DNSNames) != 0 {
+		t.Fatalf("unexpected DNS names: %v", identity.DNSNames)
+	}
+}
@@ -33,11 +33,15 @@ make
 %{__install} -Dp -m0755 ~/go/bin/keymasterd %{buildroot}%{_sbindir}/keymasterd
 %{__install} -Dp -m0755 ~/go/bin/keymaster %{buildroot}%{_bindir}/keymaster
 %{__install} -Dp -m0755 ~/go/bin/keymaster-unlocker %{buildroot}%{_bindir}/keymaster-unlocker
+%{__install} -Dp -m0755 ~/go/bin/keymaster-hostagent %{buildroot}%{_bindir}/keymaster-hostagent
+%{__install} -Dp -m0755 ~/go/bin/keymaster-vault-plugin %{buildroot}%{_libexecdir}/keymaster/keymaster-vault-plugin
 install -d %{buildroot}/usr/lib/systemd/system
 install -p -m 0644 misc/startup/keymaster.service %{buildroot}/usr/lib/systemd/system/keymaster.service
+install -p -m 0644 misc/startup/keymaster-hostagent.service %{buildroot}/usr/lib/systemd/system/keymaster-hostagent.service
 install -d %{buildroot}/%{_datarootdir}/keymasterd/static_files/
 install -p -m 0644 cmd/keymasterd/static_files/u2f-api.js  %{buildroot}/%{_datarootdir}/keymasterd/static_files/u2f-api.js
 install -p -m 0644 cmd/keymasterd/static_files/keymaster-u2f.js  %{buildroot}/%{_datarootdir}/keymasterd/static_files/keymaster-u2f.js
+install -p -m 0644 cmd/keymasterd/static_files/keymaster-webauthn.js  %{buildroot}/%{_datarootdir}/keymasterd/static_files/keymaster-webauthn.js
 install -p -m 0644 cmd/keymasterd/static_files/webui-2fa-u2f.js  %{buildroot}/%{_datarootdir}/keymasterd/static_files/webui-2fa-u2f.js
 install -p -m 0644 cmd/keymasterd/static_files/webui-2fa-okta-push.js %{buildroot}/%{_datarootdir}/keymasterd/static_files/webui-2fa-okta-push.js
 install -p -m 0644 cmd/keymasterd/static_files/webui-2fa-symc-vip.js  %{buildroot}/%{_datarootdir}/keymasterd/static_files/webui-2fa-symc-vip.js
@@ -48,6 +52,8 @@ install -d %{buildroot}/%{_datarootdir}/keymasterd/customization_data/templates
 install -p -m 0644 cmd/keymasterd/customization_data/templates/header_extra.tmpl %{buildroot}/%{_datarootdir}/keymasterd/customization_data/templates/header_extra.tmpl
 install -p -m 0644 cmd/keymasterd/customization_data/templates/footer_extra.tmpl %{buildroot}/%{_datarootdir}/keymasterd/customization_data/templates/footer_extra.tmpl
 install -p -m 0644 cmd/keymasterd/customization_data/templates/login_extra.tmpl %{buildroot}/%{_datarootdir}/keymasterd/customization_data/templates/login_extra.tmpl
+install -d %{buildroot}/%{_datarootdir}/keymasterd/customization_data/messages
+install -p -m 0644 cmd/keymasterd/customization_data/messages/de.yml %{buildroot}/%{_datarootdir}/keymasterd/customization_data/messages/de.yml
 install -d %{buildroot}/%{_datarootdir}/keymasterd/customization_data/web_resources
 install -p -m 0644 cmd/keymasterd/customization_data/web_resources/customization.css %{buildroot}/%{_datarootdir}/keymasterd/customization_data/web_resources/customization.css
 %pre
@@ -68,10 +74,14 @@ systemctl daemon-reload
 %{_sbindir}/keymasterd
 %{_bindir}/keymaster
 %{_bindir}/keymaster-unlocker
+%{_bindir}/keymaster-hostagent
+%{_libexecdir}/keymaster/keymaster-vault-plugin
 /usr/lib/systemd/system/keymaster.service
+/usr/lib/systemd/system/keymaster-hostagent.service
 %{_datarootdir}/keymasterd/static_files/*
 %config(noreplace) %{_datarootdir}/keymasterd/customization_data/web_resources/*
 %config(noreplace) %{_datarootdir}/keymasterd/customization_data/templates/*
+%config(noreplace) %{_datarootdir}/keymasterd/customization_data/messages/*
 %changelog
 
 
@@ -0,0 +1,125 @@
+package alerting
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+type recorder struct {
+	mutex    sync.Mutex
+	requests map[string][][]byte
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rec.mutex.Lock()
+	rec.requests[r.URL.Path] = append(rec.requests[r.URL.Path], body)
+	rec.mutex.Unlock()
+	if r.URL.Path == "/broken" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestNoDestinations(t *testing.T) {
+	n, err := New(Config{}, "keymaster.example.com", testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != nil {
+		t.Fatal("expected nil Notifier")
+	}
+	n.Send(Event{Type: EventSignerUnsealed}) // Must not panic.
+}
+
+func TestBadConfig(t *testing.T) {
+	badConfigs := []Config{
+		{Slack: []SlackConfig{{}}},
+		{Slack: []SlackConfig{{WebhookURL: "http://localhost/",
+			Events: []string{"lunch"}}}},
+		{PagerDuty: []PagerDutyConfig{{}}},
+		{PagerDuty: []PagerDutyConfig{{RoutingKey: "key",
+			Severity: "dire"}}},
+	}
+	for _, config := range badConfigs {
+		if _, err := New(config, "", testlogger.New(t)); err == nil {
+			t.Errorf("no error for: %+v", config)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	rec := &recorder{requests: make(map[string][][]byte)}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+	n, err := newNotifier(Config{
+		Slack: []SlackConfig{
+			{WebhookURL: server.URL + "/slack"},
+			{WebhookURL: server.URL + "/broken"},
+		},
+		PagerDuty: []PagerDutyConfig{{
+			RoutingKey: "routing-key",
+			EventsURL:  server.URL + "/pagerduty",
+			Events:     []string{EventSignerUnsealed},
+		}},
+	}, "keymaster.example.com", testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.deliver(Event{
+		Type:    EventDevicesReset,
+		Summary: "admin reset the devices of alice",
+		Actor:   "admin",
+		Target:  "alice",
+	})
+	n.deliver(Event{
+		Type:    EventSignerUnsealed,
+		Summary: "signer unsealed",
+		Actor:   "operator",
+	})
+	if len(rec.requests["/slack"]) != 3 {
+		t.Fatalf("expected 3 Slack messages, got %d",
+			len(rec.requests["/slack"]))
+	}
+	var message slackMessage
+	if err := json.Unmarshal(rec.requests["/slack"][0], &message); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(message.Text, "admin reset the devices of alice") ||
+		!strings.Contains(message.Text, "target: alice") {
+		t.Fatalf("unexpected Slack message: %s", message.Text)
+	}
+	n.deliver(Event{
+		Type:     EventSignerUnsealed,
+		Summary:  "signer unsealed again",
+		Severity: "critical",
+	})
+	// PagerDuty is only interested in the unseal events.
+	if len(rec.requests["/pagerduty"]) != 2 {
+		t.Fatalf("expected 2 PagerDuty events, got %d",
+			len(rec.requests["/pagerduty"]))
+	}
+	var event pagerDutyEvent
+	if err := json.Unmarshal(rec.requests["/pagerduty"][0], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.RoutingKey != "routing-key" || event.EventAction != "trigger" ||
+		event.Payload.Severity != defaultPagerDutySeverity ||
+		event.Payload.Class != EventSignerUnsealed ||
+		event.Payload.Source != "keymaster.example.com" ||
+		event.Payload.CustomDetails["actor"] != "operator" {
+		t.Fatalf("unexpected PagerDuty event: %+v", event)
+	}
+	if err := json.Unmarshal(rec.requests["/pagerduty"][1], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Payload.Severity != "critical" {
+		t.Fatalf("severity not overridden: %+v", event)
+	}
+}
@@ -0,0 +1,78 @@
+// Package alerting sends notifications of high-value events, such as the
+// signer being unsealed, to Slack incoming webhooks and the PagerDuty Events
+// API.
+package alerting
+
+import (
+	"net/http"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+// Event types.
+const (
+	EventAdminImpersonation = "admin_impersonation"
+	EventCertificateRevoked = "certificate_revoked"
+	EventDevicesReset       = "devices_reset"
+	EventHoneytoken         = "honeytoken"
+	EventSignerSealed       = "signer_sealed"
+	EventSignerUnsealed     = "signer_unsealed"
+)
+
+// Event describes something which happened.
+type Event struct {
+	Type     string
+	Summary  string
+	Actor    string // User which caused the event.
+	Target   string // User affected by the event, if any.
+	Severity string // Overrides the PagerDuty severity, if set.
+	Details  map[string]string
+}
+
+// SlackConfig configures a Slack incoming webhook.
+type SlackConfig struct {
+	WebhookURL string   `yaml:"webhook_url"`
+	Events     []string `yaml:"events"` // All events if empty.
+}
+
+// PagerDutyConfig configures a PagerDuty Events API v2 integration.
+type PagerDutyConfig struct {
+	RoutingKey string   `yaml:"routing_key"`
+	Severity   string   `yaml:"severity"`   // Default: "warning".
+	EventsURL  string   `yaml:"events_url"` // Default: the public API.
+	Events     []string `yaml:"events"`     // All events if empty.
+}
+
+// Config configures the notification destinations.
+type Config struct {
+	Slack     []SlackConfig     `yaml:"slack"`
+	PagerDuty []PagerDutyConfig `yaml:"pagerduty"`
+}
+
+// Notifier delivers events to the configured destinations.
+type Notifier struct {
+	source       string
+	destinations []destination
+	client       *http.Client
+	queue        chan Event
+	logger       log.DebugLogger
+}
+
+// ValidSeverity returns true if severity is a PagerDuty severity.
+func ValidSeverity(severity string) bool {
+	return validSeverity(severity)
+}
+
+// New creates a Notifier. Events are reported as coming from source,
+// typically the host identity. New returns nil if no destinations are
+// configured.
+func New(config Config, source string, logger log.DebugLogger) (
+	*Notifier, error) {
+	return newNotifier(config, source, logger)
+}
+
+// Send queues an event for delivery. It does not block: if the queue is
+// full the event is logged and dropped. If n is nil, Send is a no-op.
+func (n *Notifier) Send(event Event) {
+	n.send(event)
+}
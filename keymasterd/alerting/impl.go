@@ -0,0 +1,254 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+const (
+	defaultPagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	defaultPagerDutySeverity  = "warning"
+	queueLength               = 64
+)
+
+var pagerDutySeverities = map[string]struct{}{
+	"critical": {},
+	"error":    {},
+	"warning":  {},
+	"info":     {},
+}
+
+type destination struct {
+	name    string
+	url     string
+	events  map[string]struct{} // nil means all events.
+	payload func(event Event, source string) interface{}
+}
+
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp"`
+	Component     string            `json:"component"`
+	Class         string            `json:"class"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+var knownEvents = map[string]struct{}{
+	EventAdminImpersonation: {},
+	EventCertificateRevoked: {},
+	EventDevicesReset:       {},
+	EventHoneytoken:         {},
+	EventSignerSealed:       {},
+	EventSignerUnsealed:     {},
+}
+
+func validSeverity(severity string) bool {
+	_, ok := pagerDutySeverities[severity]
+	return ok
+}
+
+func makeEventSet(events []string) (map[string]struct{}, error) {
+	if len(events) < 1 {
+		return nil, nil
+	}
+	eventSet := make(map[string]struct{}, len(events))
+	for _, event := range events {
+		if _, ok := knownEvents[event]; !ok {
+			return nil, fmt.Errorf("unknown event: %s", event)
+		}
+		eventSet[event] = struct{}{}
+	}
+	return eventSet, nil
+}
+
+// getDetails returns the event details including the actor and target.
+func getDetails(event Event) map[string]string {
+	details := make(map[string]string, len(event.Details)+2)
+	for key, value := range event.Details {
+		details[key] = value
+	}
+	if event.Actor != "" {
+		details["actor"] = event.Actor
+	}
+	if event.Target != "" {
+		details["target"] = event.Target
+	}
+	return details
+}
+
+func slackPayload(event Event, source string) interface{} {
+	text := fmt.Sprintf("*%s*: %s", source, event.Summary)
+	if event.Severity != "" {
+		text = fmt.Sprintf("*%s* [%s]: %s", source, event.Severity,
+			event.Summary)
+	}
+	details := getDetails(event)
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		text += fmt.Sprintf("\n• %s: %s", key, details[key])
+	}
+	return slackMessage{Text: text}
+}
+
+func makePagerDutyPayload(routingKey, severity string) func(Event,
+	string) interface{} {
+	return func(event Event, source string) interface{} {
+		severity := severity
+		if event.Severity != "" {
+			severity = event.Severity
+		}
+		return pagerDutyEvent{
+			RoutingKey:  routingKey,
+			EventAction: "trigger",
+			Payload: pagerDutyPayload{
+				Summary:       event.Summary,
+				Source:        source,
+				Severity:      severity,
+				Timestamp:     time.Now().UTC().Format(time.RFC3339),
+				Component:     "keymaster",
+				Class:         event.Type,
+				CustomDetails: getDetails(event),
+			},
+		}
+	}
+}
+
+func newNotifier(config Config, source string, logger log.DebugLogger) (
+	*Notifier, error) {
+	var destinations []destination
+	for _, slack := range config.Slack {
+		if slack.WebhookURL == "" {
+			return nil, errors.New("slack webhook_url not specified")
+		}
+		events, err := makeEventSet(slack.Events)
+		if err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, destination{
+			name:    "slack",
+			url:     slack.WebhookURL,
+			events:  events,
+			payload: slackPayload,
+		})
+	}
+	for _, pagerDuty := range config.PagerDuty {
+		if pagerDuty.RoutingKey == "" {
+			return nil, errors.New("pagerduty routing_key not specified")
+		}
+		severity := pagerDuty.Severity
+		if severity == "" {
+			severity = defaultPagerDutySeverity
+		}
+		if !validSeverity(severity) {
+			return nil, fmt.Errorf("invalid pagerduty severity: %s", severity)
+		}
+		events, err := makeEventSet(pagerDuty.Events)
+		if err != nil {
+			return nil, err
+		}
+		eventsURL := pagerDuty.EventsURL
+		if eventsURL == "" {
+			eventsURL = defaultPagerDutyEventsURL
+		}
+		destinations = append(destinations, destination{
+			name:    "pagerduty",
+			url:     eventsURL,
+			events:  events,
+			payload: makePagerDutyPayload(pagerDuty.RoutingKey, severity),
+		})
+	}
+	if len(destinations) < 1 {
+		return nil, nil
+	}
+	n := &Notifier{
+		source:       source,
+		destinations: destinations,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		queue:        make(chan Event, queueLength),
+		logger:       logger,
+	}
+	go n.loop()
+	return n, nil
+}
+
+func (n *Notifier) send(event Event) {
+	if n == nil {
+		return
+	}
+	select {
+	case n.queue <- event:
+	default:
+		n.logger.Printf("alerting: queue full, dropping %s event: %s",
+			event.Type, event.Summary)
+	}
+}
+
+func (n *Notifier) loop() {
+	for event := range n.queue {
+		n.deliver(event)
+	}
+}
+
+// deliver sends the event to each interested destination, logging failures.
+func (n *Notifier) deliver(event Event) {
+	for _, dest := range n.destinations {
+		if dest.events != nil {
+			if _, ok := dest.events[event.Type]; !ok {
+				continue
+			}
+		}
+		if err := n.post(dest.url, dest.payload(event, n.source)); err != nil {
+			n.logger.Printf("alerting: error sending %s event to %s: %s",
+				event.Type, dest.name, err)
+		}
+	}
+}
+
+func (n *Notifier) post(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// Webhook URLs are secrets, so do not include them in the error.
+		if urlErr, ok := err.(*neturl.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status,
+			strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Package captcha verifies reCAPTCHA and hCaptcha responses, and decides
+// when a client must solve one by counting the recent login failures of its
+// IP address. Clients only see a challenge once they have failed often
+// enough to look like a credential stuffing source.
+package captcha
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	ProviderHCaptcha  = "hcaptcha"
+	ProviderReCaptcha = "recaptcha"
+)
+
+var (
+	// ErrMissingResponse is returned when no CAPTCHA response was submitted.
+	ErrMissingResponse = errors.New("missing CAPTCHA response")
+	// ErrRejected is returned when the provider did not accept the response.
+	ErrRejected = errors.New("CAPTCHA response rejected")
+)
+
+// Config configures the verifier. A CAPTCHA is required once an address
+// has FailureThreshold failures, until it has had none for FailureWindow.
+type Config struct {
+	Provider         string        `yaml:"provider"` // recaptcha or hcaptcha.
+	SiteKey          string        `yaml:"site_key"`
+	SecretKey        string        `yaml:"secret_key"`
+	FailureThreshold uint          `yaml:"failure_threshold"` // Default: 3.
+	FailureWindow    time.Duration `yaml:"failure_window"`    // Default: 1h.
+	VerifyURL        string        `yaml:"verify_url"`        // Default: provider's.
+	MaxAddresses     int           `yaml:"max_addresses"`     // Default: 100000.
+}
+
+// Verifier tracks failures and verifies CAPTCHA responses.
+type Verifier struct {
+	config     Config
+	provider   provider
+	httpClient *http.Client
+	mutex      sync.Mutex // Protect everything below.
+	failures   map[string]*failureRecord
+}
+
+type failureRecord struct {
+	count       uint
+	lastFailure time.Time
+}
+
+type provider struct {
+	cspSources  string
+	formField   string
+	scriptURL   string
+	verifyURL   string
+	widgetClass string
+}
+
+// New creates a Verifier. It returns nil if no provider is configured.
+func New(config Config) (*Verifier, error) {
+	return newVerifier(config)
+}
+
+// Required returns true if a client at address must solve a CAPTCHA. If v
+// is nil, CAPTCHAs are never required.
+func (v *Verifier) Required(address string) bool {
+	return v.required(address, time.Now())
+}
+
+// RecordFailure counts a failed login from address.
+func (v *Verifier) RecordFailure(address string) {
+	v.recordFailure(address, time.Now())
+}
+
+// RecordSuccess forgets the failures of address.
+func (v *Verifier) RecordSuccess(address string) {
+	v.recordSuccess(address)
+}
+
+// Verify checks the CAPTCHA response submitted by a client at address with
+// the provider. It returns ErrMissingResponse or ErrRejected if the client
+// did not solve the CAPTCHA, and other errors if the provider could not be
+// asked.
+func (v *Verifier) Verify(ctx context.Context, response,
+	address string) error {
+	return v.verify(ctx, response, address)
+}
+
+// ContentSecurityPolicySources returns the sources the widget loads scripts,
+// frames and styles from, to be allowed by the Content-Security-Policy of
+// the page.
+func (v *Verifier) ContentSecurityPolicySources() string {
+	return v.provider.cspSources
+}
+
+// FormField returns the name of the form field holding the response.
+func (v *Verifier) FormField() string {
+	return v.provider.formField
+}
+
+// ScriptURL returns the URL of the JavaScript which renders the widget.
+func (v *Verifier) ScriptURL() string {
+	return v.provider.scriptURL
+}
+
+// SiteKey returns the public key identifying the site to the provider.
+func (v *Verifier) SiteKey() string {
+	return v.config.SiteKey
+}
+
+// WidgetClass returns the class of the element the widget is rendered in.
+func (v *Verifier) WidgetClass() string {
+	return v.provider.widgetClass
+}
@@ -0,0 +1,118 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestVerifier(t *testing.T, config Config) *Verifier {
+	config.Provider = ProviderHCaptcha
+	config.SiteKey = "site"
+	config.SecretKey = "secret"
+	v, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestNew(t *testing.T) {
+	if v, err := New(Config{}); err != nil || v != nil {
+		t.Fatalf("unconfigured verifier: %v, %v", v, err)
+	}
+	var v *Verifier
+	if v.Required("192.0.2.1") {
+		t.Fatal("nil verifier requires CAPTCHA")
+	}
+	v.RecordFailure("192.0.2.1")
+	if _, err := New(Config{Provider: "other"}); err == nil {
+		t.Fatal("unknown provider accepted")
+	}
+	if _, err := New(Config{Provider: ProviderReCaptcha}); err == nil {
+		t.Fatal("missing keys accepted")
+	}
+	v = newTestVerifier(t, Config{})
+	if v.FormField() != "h-captcha-response" || v.SiteKey() != "site" {
+		t.Fatalf("unexpected verifier: %+v", v)
+	}
+}
+
+func TestFailures(t *testing.T) {
+	v := newTestVerifier(t, Config{FailureThreshold: 2, MaxAddresses: 3})
+	now := time.Now()
+	v.recordFailure("192.0.2.1", now)
+	if v.required("192.0.2.1", now) {
+		t.Fatal("required below threshold")
+	}
+	v.recordFailure("192.0.2.1", now)
+	if !v.required("192.0.2.1", now) {
+		t.Fatal("not required at threshold")
+	}
+	if v.required("192.0.2.2", now) {
+		t.Fatal("required for other address")
+	}
+	if v.required("192.0.2.1", now.Add(defaultFailureWindow)) {
+		t.Fatal("required after window")
+	}
+	v.recordFailure("192.0.2.1", now)
+	v.recordFailure("192.0.2.1", now)
+	v.RecordSuccess("192.0.2.1")
+	if v.required("192.0.2.1", now) {
+		t.Fatal("required after success")
+	}
+	// Addresses in the same IPv6 /64 share their failures.
+	v.recordFailure("2001:db8::1", now)
+	v.recordFailure("2001:db8::2", now)
+	if !v.required("2001:db8::3", now) {
+		t.Fatal("not required for IPv6 /64")
+	}
+	if v.required("2001:db8:0:1::1", now) {
+		t.Fatal("required for other IPv6 /64")
+	}
+	// When the table is full, new addresses are challenged.
+	v.recordFailure("192.0.2.3", now)
+	v.recordFailure("192.0.2.4", now)
+	v.recordFailure("192.0.2.5", now)
+	if !v.required("192.0.2.6", now) {
+		t.Fatal("not required with full table")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.FormValue("secret") != "secret" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if r.FormValue("response") == "good" &&
+				r.FormValue("remoteip") == "192.0.2.1" {
+				fmt.Fprintln(w, `{"success": true}`)
+				return
+			}
+			fmt.Fprintln(w,
+				`{"success": false, "error-codes": ["invalid-input-response"]}`)
+		}))
+	defer server.Close()
+	v := newTestVerifier(t, Config{VerifyURL: server.URL})
+	ctx := context.Background()
+	if err := v.Verify(ctx, "good", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(ctx, "", "192.0.2.1"); err != ErrMissingResponse {
+		t.Fatalf("expected ErrMissingResponse, got: %v", err)
+	}
+	if err := v.Verify(ctx, "bad", "192.0.2.1"); !errors.Is(err, ErrRejected) {
+		t.Fatalf("expected ErrRejected, got: %v", err)
+	}
+	v.config.SecretKey = "wrong"
+	err := v.Verify(ctx, "good", "192.0.2.1")
+	if err == nil || errors.Is(err, ErrRejected) {
+		t.Fatalf("expected provider error, got: %v", err)
+	}
+}
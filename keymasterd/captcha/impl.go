@@ -0,0 +1,182 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultFailureWindow    = time.Hour
+	defaultMaxAddresses     = 100000
+	verifyTimeout           = 10 * time.Second
+)
+
+var providers = map[string]provider{
+	ProviderHCaptcha: {
+		cspSources:  "https://hcaptcha.com https://*.hcaptcha.com",
+		formField:   "h-captcha-response",
+		scriptURL:   "https://js.hcaptcha.com/1/api.js",
+		verifyURL:   "https://api.hcaptcha.com/siteverify",
+		widgetClass: "h-captcha",
+	},
+	ProviderReCaptcha: {
+		cspSources: "https://www.google.com/recaptcha/ " +
+			"https://www.gstatic.com/recaptcha/ " +
+			"https://recaptcha.google.com/recaptcha/",
+		formField:   "g-recaptcha-response",
+		scriptURL:   "https://www.google.com/recaptcha/api.js",
+		verifyURL:   "https://www.google.com/recaptcha/api/siteverify",
+		widgetClass: "g-recaptcha",
+	},
+}
+
+type verifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func newVerifier(config Config) (*Verifier, error) {
+	if config.Provider == "" {
+		return nil, nil
+	}
+	provider, ok := providers[config.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown CAPTCHA provider: %s", config.Provider)
+	}
+	if config.SiteKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("%s: site_key and secret_key are required",
+			config.Provider)
+	}
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = defaultFailureThreshold
+	}
+	if config.FailureWindow <= 0 {
+		config.FailureWindow = defaultFailureWindow
+	}
+	if config.MaxAddresses < 1 {
+		config.MaxAddresses = defaultMaxAddresses
+	}
+	if config.VerifyURL != "" {
+		provider.verifyURL = config.VerifyURL
+	}
+	return &Verifier{
+		config:     config,
+		provider:   provider,
+		httpClient: &http.Client{Timeout: verifyTimeout},
+		failures:   make(map[string]*failureRecord),
+	}, nil
+}
+
+// addressKey groups IPv6 addresses by /64, since a single client usually
+// has a whole /64 to rotate through.
+func addressKey(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() != nil {
+		return address
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+func (v *Verifier) required(address string, now time.Time) bool {
+	if v == nil {
+		return false
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	record, ok := v.failures[addressKey(address)]
+	if !ok {
+		// When the table is full, every new address is challenged.
+		return len(v.failures) >= v.config.MaxAddresses
+	}
+	if now.Sub(record.lastFailure) >= v.config.FailureWindow {
+		delete(v.failures, addressKey(address))
+		return false
+	}
+	return record.count >= v.config.FailureThreshold
+}
+
+func (v *Verifier) recordFailure(address string, now time.Time) {
+	if v == nil {
+		return
+	}
+	address = addressKey(address)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	record, ok := v.failures[address]
+	if ok && now.Sub(record.lastFailure) >= v.config.FailureWindow {
+		record.count = 0
+	}
+	if !ok {
+		if len(v.failures) >= v.config.MaxAddresses {
+			v.expireLocked(now)
+		}
+		if len(v.failures) >= v.config.MaxAddresses {
+			return
+		}
+		record = &failureRecord{}
+		v.failures[address] = record
+	}
+	record.count++
+	record.lastFailure = now
+}
+
+func (v *Verifier) expireLocked(now time.Time) {
+	for address, record := range v.failures {
+		if now.Sub(record.lastFailure) >= v.config.FailureWindow {
+			delete(v.failures, address)
+		}
+	}
+}
+
+func (v *Verifier) recordSuccess(address string) {
+	if v == nil {
+		return
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	delete(v.failures, addressKey(address))
+}
+
+func (v *Verifier) verify(ctx context.Context, response,
+	address string) error {
+	if response == "" {
+		return ErrMissingResponse
+	}
+	form := url.Values{
+		"secret":   {v.config.SecretKey},
+		"response": {response},
+		"remoteip": {address},
+		"sitekey":  {v.config.SiteKey},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", v.provider.verifyURL,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s verification failed: %s", v.config.Provider,
+			resp.Status)
+	}
+	var result verifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("%w: %s", ErrRejected,
+			strings.Join(result.ErrorCodes, ","))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Package certreloader serves a TLS certificate from a pair of files and
+// picks up new versions of those files without a restart.
+package certreloader
+
+import (
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+// GetCertificateFunc matches the signature of tls.Config.GetCertificate.
+type GetCertificateFunc func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+// Reloader serves the certificate in the watched files once they have been
+// changed. Until then, requests are passed to the fallback.
+type Reloader struct {
+	certFilename  string
+	keyFilename   string
+	checkInterval time.Duration
+	fallback      GetCertificateFunc
+	logger        log.DebugLogger
+	mutex         sync.Mutex
+	// Protected by lock.
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+// New creates a Reloader which checks the certificate and key files for
+// changes at most once every checkInterval. Until the files change, the
+// certificate is obtained from fallback (typically the certificate manager
+// which loaded the files at startup).
+func New(certFilename, keyFilename string, checkInterval time.Duration,
+	fallback GetCertificateFunc, logger log.DebugLogger) *Reloader {
+	return newReloader(certFilename, keyFilename, checkInterval, fallback,
+		logger)
+}
+
+// GetCertificate may be used as the tls.Config.GetCertificate function.
+func (r *Reloader) GetCertificate(hello *tls.ClientHelloInfo) (
+	*tls.Certificate, error) {
+	return r.getCertificate(hello)
+}
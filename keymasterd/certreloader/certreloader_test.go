@@ -0,0 +1,113 @@
+package certreloader
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+var errFallback = errors.New("fallback called")
+
+func fallback(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return nil, errFallback
+}
+
+func writeKeyPair(t *testing.T, certFilename, keyFilename string,
+	serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	derCert, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(certFilename,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derCert}),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFilename,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: derKey}),
+		0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{certFilename, keyFilename} {
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func getSerial(t *testing.T, r *Reloader) int64 {
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.SerialNumber.Int64()
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certreloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFilename := filepath.Join(dir, "cert.pem")
+	keyFilename := filepath.Join(dir, "key.pem")
+	startTime := time.Now().Add(-time.Hour)
+	writeKeyPair(t, certFilename, keyFilename, 1, startTime)
+	r := New(certFilename, keyFilename, 0, fallback, testlogger.New(t))
+	// Unchanged files are served by the fallback.
+	if _, err := r.GetCertificate(nil); err != errFallback {
+		t.Fatalf("expected fallback, got: %v", err)
+	}
+	writeKeyPair(t, certFilename, keyFilename, 2,
+		startTime.Add(time.Minute))
+	if serial := getSerial(t, r); serial != 2 {
+		t.Fatalf("expected serial 2, got: %d", serial)
+	}
+	// A broken update must not replace the working certificate.
+	err = ioutil.WriteFile(keyFilename, []byte("garbage"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial := getSerial(t, r); serial != 2 {
+		t.Fatalf("expected serial 2, got: %d", serial)
+	}
+	writeKeyPair(t, certFilename, keyFilename, 3,
+		startTime.Add(2*time.Minute))
+	if serial := getSerial(t, r); serial != 3 {
+		t.Fatalf("expected serial 3, got: %d", serial)
+	}
+}
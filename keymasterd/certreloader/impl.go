@@ -0,0 +1,69 @@
+package certreloader
+
+import (
+	"crypto/tls"
+	"os"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+func newReloader(certFilename, keyFilename string, checkInterval time.Duration,
+	fallback GetCertificateFunc, logger log.DebugLogger) *Reloader {
+	r := &Reloader{
+		certFilename:  certFilename,
+		keyFilename:   keyFilename,
+		checkInterval: checkInterval,
+		fallback:      fallback,
+		logger:        logger,
+		lastCheck:     time.Now(),
+	}
+	r.certModTime, r.keyModTime = r.getModTimes()
+	return r
+}
+
+func getModTime(filename string) time.Time {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func (r *Reloader) getModTimes() (time.Time, time.Time) {
+	return getModTime(r.certFilename), getModTime(r.keyFilename)
+}
+
+func (r *Reloader) getCertificate(hello *tls.ClientHelloInfo) (
+	*tls.Certificate, error) {
+	r.mutex.Lock()
+	if time.Since(r.lastCheck) >= r.checkInterval {
+		r.lastCheck = time.Now()
+		r.checkForChanges()
+	}
+	certificate := r.certificate
+	r.mutex.Unlock()
+	if certificate != nil {
+		return certificate, nil
+	}
+	return r.fallback(hello)
+}
+
+// checkForChanges must be called with the lock held.
+func (r *Reloader) checkForChanges() {
+	certModTime, keyModTime := r.getModTimes()
+	if certModTime.Equal(r.certModTime) && keyModTime.Equal(r.keyModTime) {
+		return
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certFilename, r.keyFilename)
+	if err != nil {
+		// The files may be part way through being replaced, try again at the
+		// next check and keep serving the current certificate.
+		r.logger.Printf("error reloading TLS certificate: %s\n", err)
+		return
+	}
+	r.certificate = &certificate
+	r.certModTime = certModTime
+	r.keyModTime = keyModTime
+	r.logger.Printf("reloaded TLS certificate from: %s\n", r.certFilename)
+}
@@ -0,0 +1,91 @@
+// Package certstream streams an event for each issued SSH certificate to
+// syslog and Kafka, so that a SIEM can correlate the sessions logged by sshd
+// with the issuance of the certificates used for them. The events contain the
+// key ID, serial number and fingerprints in the format sshd logs them in:
+//
+//	ED25519-CERT SHA256:... ID alice (serial 1234) CA RSA SHA256:...
+package certstream
+
+import (
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// Certificate types.
+const (
+	CertTypeHost = "host"
+	CertTypeUser = "user"
+)
+
+// SyslogConfig configures sending events to syslog.
+type SyslogConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Network  string `yaml:"network"`  // "udp" or "tcp". Default: local.
+	Address  string `yaml:"address"`  // Required if Network is set.
+	Facility string `yaml:"facility"` // Default: "auth".
+	Tag      string `yaml:"tag"`      // Default: "keymasterd".
+}
+
+// KafkaConfig configures sending events to a Kafka topic. The events are
+// JSON encoded and keyed by username.
+type KafkaConfig struct {
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+	TLS     bool     `yaml:"tls"`
+}
+
+// Config configures the destinations of the events.
+type Config struct {
+	Syslog SyslogConfig `yaml:"syslog"`
+	Kafka  KafkaConfig  `yaml:"kafka"`
+}
+
+// Event describes an issued SSH certificate.
+type Event struct {
+	CertType      string    `json:"cert_type"`
+	Username      string    `json:"username"`
+	KeyID         string    `json:"key_id"`
+	Serial        uint64    `json:"serial,string"`
+	KeyType       string    `json:"key_type"` // As logged by sshd.
+	Fingerprint   string    `json:"fingerprint"`
+	CAKeyType     string    `json:"ca_key_type"`
+	CAFingerprint string    `json:"ca_fingerprint"`
+	Principals    []string  `json:"principals"`
+	ValidAfter    time.Time `json:"valid_after"`
+	ValidBefore   time.Time `json:"valid_before"`
+	IssuedAt      time.Time `json:"issued_at"`
+	SourceAddr    string    `json:"source_address,omitempty"`
+	AuditID       string    `json:"audit_id,omitempty"`
+}
+
+// Streamer sends events to the configured destinations.
+type Streamer struct {
+	sinks  []sink
+	queue  chan Event
+	logger log.DebugLogger
+}
+
+// New creates a Streamer. New returns nil if no destinations are configured.
+func New(config Config, logger log.DebugLogger) (*Streamer, error) {
+	return newStreamer(config, logger)
+}
+
+// NewSSHEvent returns the event for cert, issued for username. The caller
+// may add the source address and audit ID.
+func NewSSHEvent(username string, cert *ssh.Certificate) Event {
+	return newSSHEvent(username, cert)
+}
+
+// Emit queues an event for delivery. It does not block: if the queue is full
+// the event is logged and dropped. If s is nil, Emit is a no-op.
+func (s *Streamer) Emit(event Event) {
+	s.emit(event)
+}
+
+// String returns the event as a log line, with the certificate described the
+// same way as in the "Accepted publickey" lines logged by sshd.
+func (e Event) String() string {
+	return e.string()
+}
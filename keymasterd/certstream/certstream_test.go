@@ -0,0 +1,151 @@
+package certstream
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+	"golang.org/x/crypto/ssh"
+)
+
+type channelSink chan Event
+
+func (s channelSink) name() string {
+	return "channel"
+}
+
+func (s channelSink) write(event Event) error {
+	s <- event
+	return nil
+}
+
+func makeCertificate(t *testing.T) *ssh.Certificate {
+	userPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, caPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey, err := ssh.NewPublicKey(userPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.NewSignerFromSigner(caPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             userKey,
+		CertType:        ssh.UserCert,
+		KeyId:           "alice",
+		Serial:          1234,
+		ValidPrincipals: []string{"alice"},
+		ValidAfter:      1700000000,
+		ValidBefore:     1700086400,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestSSHEvent(t *testing.T) {
+	cert := makeCertificate(t)
+	event := NewSSHEvent("alice", cert)
+	event.AuditID = "abcd"
+	line := event.String()
+	// This is what sshd logs for a login with the certificate.
+	expected := "ED25519-CERT " + ssh.FingerprintSHA256(cert.Key) +
+		" ID alice (serial 1234) CA ED25519 " +
+		ssh.FingerprintSHA256(cert.SignatureKey)
+	if !strings.Contains(line, expected) {
+		t.Fatalf("%q does not contain %q", line, expected)
+	}
+	for _, field := range []string{
+		"principals=alice", "valid_before=2023-11-15T22:13:20Z",
+		"audit_id=abcd",
+	} {
+		if !strings.Contains(line, field) {
+			t.Fatalf("%q does not contain %q", line, field)
+		}
+	}
+	if event.CertType != CertTypeUser {
+		t.Fatalf("unexpected certificate type: %s", event.CertType)
+	}
+}
+
+func TestStreamer(t *testing.T) {
+	if s, err := New(Config{}, testlogger.New(t)); err != nil {
+		t.Fatal(err)
+	} else if s != nil {
+		t.Fatal("streamer created without destinations")
+	}
+	var s *Streamer
+	s.Emit(Event{}) // Must not panic.
+	events := make(channelSink, 1)
+	s = startStreamer([]sink{events}, testlogger.New(t))
+	s.Emit(NewSSHEvent("alice", makeCertificate(t)))
+	select {
+	case event := <-events:
+		if event.KeyID != "alice" || event.Serial != 1234 {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = New(Config{Syslog: SyslogConfig{
+		Enabled:  true,
+		Network:  "udp",
+		Address:  conn.LocalAddr().String(),
+		Facility: "no-such-facility",
+	}}, testlogger.New(t))
+	if err == nil {
+		t.Fatal("unknown facility accepted")
+	}
+	s, err := New(Config{Syslog: SyslogConfig{
+		Enabled: true,
+		Network: "udp",
+		Address: conn.LocalAddr().String(),
+	}}, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Emit(NewSSHEvent("alice", makeCertificate(t)))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 4096)
+	length, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := string(buffer[:length])
+	// Priority 38 is LOG_AUTH|LOG_INFO.
+	if !strings.HasPrefix(message, "<38>") {
+		t.Fatalf("unexpected priority: %q", message)
+	}
+	if !strings.Contains(message, "keymasterd") ||
+		!strings.Contains(message, "ID alice (serial 1234)") {
+		t.Fatalf("unexpected message: %q", message)
+	}
+}
+
+func TestKafkaConfig(t *testing.T) {
+	_, err := New(Config{Kafka: KafkaConfig{Brokers: []string{"kafka:9092"}}},
+		testlogger.New(t))
+	if err == nil {
+		t.Fatal("missing topic accepted")
+	}
+}
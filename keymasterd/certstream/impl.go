@@ -0,0 +1,129 @@
+package certstream
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"golang.org/x/crypto/ssh"
+)
+
+const queueLength = 256
+
+// sink is a destination of events.
+type sink interface {
+	name() string
+	write(event Event) error
+}
+
+// sshdKeyTypes maps SSH key algorithms to the names sshd logs them with.
+var sshdKeyTypes = map[string]string{
+	ssh.KeyAlgoRSA:        "RSA",
+	ssh.KeyAlgoDSA:        "DSA",
+	ssh.KeyAlgoECDSA256:   "ECDSA",
+	ssh.KeyAlgoECDSA384:   "ECDSA",
+	ssh.KeyAlgoECDSA521:   "ECDSA",
+	ssh.KeyAlgoSKECDSA256: "ECDSA-SK",
+	ssh.KeyAlgoED25519:    "ED25519",
+	ssh.KeyAlgoSKED25519:  "ED25519-SK",
+}
+
+func sshdKeyType(key ssh.PublicKey) string {
+	if name, ok := sshdKeyTypes[key.Type()]; ok {
+		return name
+	}
+	return key.Type()
+}
+
+func newSSHEvent(username string, cert *ssh.Certificate) Event {
+	certType := CertTypeUser
+	if cert.CertType == ssh.HostCert {
+		certType = CertTypeHost
+	}
+	return Event{
+		CertType: certType,
+		Username: username,
+		KeyID:    cert.KeyId,
+		Serial:   cert.Serial,
+		KeyType:  sshdKeyType(cert.Key) + "-CERT",
+		// Like sshd, fingerprint the certified key, not the certificate.
+		Fingerprint:   ssh.FingerprintSHA256(cert.Key),
+		CAKeyType:     sshdKeyType(cert.SignatureKey),
+		CAFingerprint: ssh.FingerprintSHA256(cert.SignatureKey),
+		Principals:    cert.ValidPrincipals,
+		ValidAfter:    time.Unix(int64(cert.ValidAfter), 0).UTC(),
+		ValidBefore:   time.Unix(int64(cert.ValidBefore), 0).UTC(),
+		IssuedAt:      time.Now().UTC(),
+	}
+}
+
+func (e Event) string() string {
+	line := fmt.Sprintf(
+		"issued ssh %s certificate for %s: %s %s ID %s (serial %d) CA %s %s",
+		e.CertType, e.Username, e.KeyType, e.Fingerprint, e.KeyID, e.Serial,
+		e.CAKeyType, e.CAFingerprint)
+	line += fmt.Sprintf(" principals=%s valid_before=%s",
+		strings.Join(e.Principals, ","), e.ValidBefore.Format(time.RFC3339))
+	if e.SourceAddr != "" {
+		line += " from=" + e.SourceAddr
+	}
+	if e.AuditID != "" {
+		line += " audit_id=" + e.AuditID
+	}
+	return line
+}
+
+func newStreamer(config Config, logger log.DebugLogger) (*Streamer, error) {
+	var sinks []sink
+	if config.Syslog.Enabled {
+		sink, err := newSyslogSink(config.Syslog)
+		if err != nil {
+			return nil, fmt.Errorf("syslog: %s", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(config.Kafka.Brokers) > 0 {
+		sink, err := newKafkaSink(config.Kafka)
+		if err != nil {
+			return nil, fmt.Errorf("kafka: %s", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	return startStreamer(sinks, logger), nil
+}
+
+func startStreamer(sinks []sink, logger log.DebugLogger) *Streamer {
+	if len(sinks) < 1 {
+		return nil
+	}
+	s := &Streamer{
+		sinks:  sinks,
+		queue:  make(chan Event, queueLength),
+		logger: logger,
+	}
+	go s.loop()
+	return s
+}
+
+func (s *Streamer) emit(event Event) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.queue <- event:
+	default:
+		s.logger.Printf("certstream: queue full, dropping event: %s", event)
+	}
+}
+
+func (s *Streamer) loop() {
+	for event := range s.queue {
+		for _, sink := range s.sinks {
+			if err := sink.write(event); err != nil {
+				s.logger.Printf("certstream: error sending event to %s: %s",
+					sink.name(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package certstream
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+const kafkaWriteTimeout = 10 * time.Second
+
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+// kafkaMessage is an Event with the log line, for SIEMs which only index
+// the message.
+type kafkaMessage struct {
+	Event
+	Message string `json:"message"`
+}
+
+func newKafkaSink(config KafkaConfig) (*kafkaSink, error) {
+	if config.Topic == "" {
+		return nil, errors.New("topic not specified")
+	}
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(config.Brokers...),
+		Topic:        config.Topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+	if config.TLS {
+		writer.Transport = &kafka.Transport{
+			TLS: &tls.Config{MinVersion: tls.VersionTLS12},
+		}
+	}
+	return &kafkaSink{writer: writer}, nil
+}
+
+func (s *kafkaSink) name() string {
+	return "kafka"
+}
+
+func (s *kafkaSink) write(event Event) error {
+	value, err := json.Marshal(kafkaMessage{
+		Event:   event,
+		Message: event.String(),
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(),
+		kafkaWriteTimeout)
+	defer cancel()
+	return s.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(event.Username),
+		Value: value,
+	})
+}
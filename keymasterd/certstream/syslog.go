@@ -0,0 +1,61 @@
+package certstream
+
+import (
+	"errors"
+	"fmt"
+	"log/syslog"
+)
+
+const defaultSyslogTag = "keymasterd"
+
+var syslogFacilities = map[string]syslog.Priority{
+	"auth":     syslog.LOG_AUTH,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"daemon":   syslog.LOG_DAEMON,
+	"user":     syslog.LOG_USER,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink(config SyslogConfig) (*syslogSink, error) {
+	if config.Network != "" && config.Address == "" {
+		return nil, errors.New("address not specified")
+	}
+	facility := syslog.LOG_AUTH
+	if config.Facility != "" {
+		var ok bool
+		facility, ok = syslogFacilities[config.Facility]
+		if !ok {
+			return nil, fmt.Errorf("unknown facility: %s", config.Facility)
+		}
+	}
+	tag := config.Tag
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+	// The writer reconnects by itself if the connection is lost.
+	writer, err := syslog.Dial(config.Network, config.Address,
+		facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) name() string {
+	return "syslog"
+}
+
+func (s *syslogSink) write(event Event) error {
+	return s.writer.Info(event.String())
+}
@@ -0,0 +1,63 @@
+// Package deviceposture asks an external service, typically an adapter for
+// an MDM system, whether the device requesting a certificate is compliant
+// with the device policy (disk encryption, management, patch level).
+package deviceposture
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+// Config configures the posture check webhook. The webhook receives a
+// Request as JSON and must respond with a Result as JSON.
+type Config struct {
+	WebhookURL  string        `yaml:"webhook_url"`
+	BearerToken string        `yaml:"bearer_token"`
+	CertTypes   []string      `yaml:"cert_types"` // All types if empty.
+	FailOpen    bool          `yaml:"fail_open"`  // Allow if the check fails.
+	Timeout     time.Duration `yaml:"timeout"`    // Default: 5s.
+}
+
+// Request describes the device requesting a certificate.
+type Request struct {
+	Username   string `json:"username"`
+	CertType   string `json:"cert_type"`
+	DeviceID   string `json:"device_id,omitempty"`
+	RemoteAddr string `json:"remote_addr"`
+	UserAgent  string `json:"user_agent,omitempty"`
+}
+
+// Result is the posture of a device. Checks holds the individual results,
+// such as "disk_encryption", "managed" and "patched".
+type Result struct {
+	Compliant bool            `json:"compliant"`
+	Checks    map[string]bool `json:"checks,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+}
+
+// Checker checks device posture.
+type Checker struct {
+	config    Config
+	certTypes map[string]struct{}
+	client    *http.Client
+	logger    log.DebugLogger
+}
+
+// New creates a Checker. New returns nil if no webhook is configured.
+func New(config Config, logger log.DebugLogger) (*Checker, error) {
+	return newChecker(config, logger)
+}
+
+// Check returns the posture of the device. If c is nil or the certificate
+// type is not checked, Check returns nil. If the webhook fails and the
+// Checker fails open, the Result is compliant and the Reason says why.
+func (c *Checker) Check(request Request) (*Result, error) {
+	return c.check(request)
+}
+
+// String returns a summary of the result, suitable for logging.
+func (r *Result) String() string {
+	return r.string()
+}
@@ -0,0 +1,109 @@
+package deviceposture
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var request Request
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Error(err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			result := Result{
+				Compliant: request.DeviceID == "good-laptop",
+				Checks: map[string]bool{
+					"disk_encryption": true,
+					"patched":         request.DeviceID == "good-laptop",
+				},
+			}
+			json.NewEncoder(w).Encode(result)
+		}))
+}
+
+func TestNotConfigured(t *testing.T) {
+	c, err := New(Config{}, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Check(Request{Username: "alice"})
+	if err != nil || result != nil {
+		t.Fatalf("unexpected result: %v, %v", result, err)
+	}
+	if _, err := New(Config{CertTypes: []string{"ssh"}},
+		testlogger.New(t)); err == nil {
+		t.Fatal("no error without webhook_url")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+	c, err := New(Config{
+		WebhookURL:  server.URL,
+		BearerToken: "secret",
+		CertTypes:   []string{"ssh"},
+	}, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Check(Request{Username: "alice", CertType: "ssh",
+		DeviceID: "good-laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Compliant ||
+		result.String() != "compliant=true disk_encryption=true patched=true" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+	result, err = c.Check(Request{Username: "alice", CertType: "ssh",
+		DeviceID: "old-laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Compliant {
+		t.Fatalf("unexpected result: %s", result)
+	}
+	// Other certificate types are not checked.
+	result, err = c.Check(Request{Username: "alice", CertType: "x509"})
+	if err != nil || result != nil {
+		t.Fatalf("unexpected result: %v, %v", result, err)
+	}
+}
+
+func TestFailOpen(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+	config := Config{WebhookURL: server.URL, BearerToken: "wrong"}
+	c, err := New(config, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Check(Request{Username: "alice"}); err == nil {
+		t.Fatal("no error for failed check")
+	}
+	config.FailOpen = true
+	c, err = New(config, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Check(Request{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Compliant || result.Reason == "" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+}
@@ -0,0 +1,117 @@
+package deviceposture
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+const defaultTimeout = 5 * time.Second
+
+func newChecker(config Config, logger log.DebugLogger) (*Checker, error) {
+	if config.WebhookURL == "" {
+		if config.BearerToken != "" || len(config.CertTypes) > 0 {
+			return nil, errors.New("device posture webhook_url not specified")
+		}
+		return nil, nil
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	c := &Checker{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		logger: logger,
+	}
+	if len(config.CertTypes) > 0 {
+		c.certTypes = make(map[string]struct{}, len(config.CertTypes))
+		for _, certType := range config.CertTypes {
+			c.certTypes[certType] = struct{}{}
+		}
+	}
+	return c, nil
+}
+
+func (c *Checker) check(request Request) (*Result, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.certTypes != nil {
+		if _, ok := c.certTypes[request.CertType]; !ok {
+			return nil, nil
+		}
+	}
+	result, err := c.query(request)
+	if err != nil {
+		if !c.config.FailOpen {
+			return nil, err
+		}
+		c.logger.Printf("device posture check failed for %s, allowing: %s",
+			request.Username, err)
+		return &Result{
+			Compliant: true,
+			Reason:    "check failed (fail open): " + err.Error(),
+		}, nil
+	}
+	return result, nil
+}
+
+func (c *Checker) query(request Request) (*Result, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", c.config.WebhookURL,
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.config.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.BearerToken)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status,
+			strings.TrimSpace(string(message)))
+	}
+	var result Result
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, 1<<16))
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *Result) string() string {
+	if r == nil {
+		return "not checked"
+	}
+	text := fmt.Sprintf("compliant=%t", r.Compliant)
+	names := make([]string, 0, len(r.Checks))
+	for name := range r.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		text += fmt.Sprintf(" %s=%t", name, r.Checks[name])
+	}
+	if r.Reason != "" {
+		text += fmt.Sprintf(" reason=%q", r.Reason)
+	}
+	return text
+}
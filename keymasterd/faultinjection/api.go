@@ -0,0 +1,68 @@
+// Package faultinjection injects artificial latency and errors into calls to
+// the dependencies of keymasterd, so that operators can check that failover,
+// timeouts and alerting behave as expected before a real outage does it for
+// them. It is a debugging aid and must not be enabled in production.
+package faultinjection
+
+import (
+	"crypto"
+	"errors"
+	"time"
+)
+
+// Targets of fault injection.
+const (
+	TargetLDAP    = "ldap"
+	TargetSigning = "signing"
+	TargetStorage = "storage"
+)
+
+// ErrInjected is returned by calls which failed because of fault injection.
+var ErrInjected = errors.New("injected fault")
+
+// FaultConfig configures the faults injected into calls to one dependency.
+type FaultConfig struct {
+	Latency   time.Duration `yaml:"latency"`    // Added to every call.
+	ErrorRate float64       `yaml:"error_rate"` // Fraction of failed calls.
+}
+
+// Config configures fault injection. Nothing is injected unless Enabled is
+// true.
+type Config struct {
+	Enabled bool        `yaml:"enabled"`
+	LDAP    FaultConfig `yaml:"ldap"`
+	Signing FaultConfig `yaml:"signing"`
+	Storage FaultConfig `yaml:"storage"`
+}
+
+// Injector injects faults into calls.
+type Injector struct {
+	faults map[string]FaultConfig
+	sleep  func(time.Duration)
+	random func() float64
+}
+
+// New creates an Injector. If fault injection is not enabled, it returns nil,
+// which is a valid Injector which never injects a fault.
+func New(config Config) (*Injector, error) {
+	return newInjector(config)
+}
+
+// Inject is called before a call to the target dependency. It waits for the
+// configured latency and then returns ErrInjected if the call should fail.
+// If i is nil, Inject returns nil immediately.
+func (i *Injector) Inject(target string) error {
+	return i.inject(target)
+}
+
+// Signer returns a crypto.Signer which injects the faults configured for
+// TargetSigning before each signature made with signer. If i is nil, signer
+// is returned.
+func (i *Injector) Signer(signer crypto.Signer) crypto.Signer {
+	return i.signer(signer)
+}
+
+// String returns a summary of the injected faults, for logging.
+func (i *Injector) String() string {
+	return i.string()
+}
@@ -0,0 +1,106 @@
+package faultinjection
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestInjector(t *testing.T, config Config, random float64) (
+	*Injector, *time.Duration) {
+	config.Enabled = true
+	injector, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var slept time.Duration
+	injector.sleep = func(duration time.Duration) { slept += duration }
+	injector.random = func() float64 { return random }
+	return injector, &slept
+}
+
+func TestDisabled(t *testing.T) {
+	injector, err := New(Config{LDAP: FaultConfig{ErrorRate: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if injector != nil {
+		t.Fatal("injector created while disabled")
+	}
+	if err := injector.Inject(TargetLDAP); err != nil {
+		t.Fatal(err)
+	}
+	_, signer, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := injector.Signer(signer).(ed25519.PrivateKey); !ok {
+		t.Fatal("signer wrapped while disabled")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, config := range []Config{
+		{Enabled: true, LDAP: FaultConfig{ErrorRate: 1.5}},
+		{Enabled: true, Storage: FaultConfig{ErrorRate: -0.1}},
+		{Enabled: true, Signing: FaultConfig{Latency: -time.Second}},
+	} {
+		if _, err := New(config); err == nil {
+			t.Fatalf("%+v: expected an error", config)
+		}
+	}
+}
+
+func TestInject(t *testing.T) {
+	config := Config{
+		LDAP:    FaultConfig{Latency: time.Second, ErrorRate: 0.5},
+		Storage: FaultConfig{ErrorRate: 0.2},
+	}
+	injector, slept := newTestInjector(t, config, 0.3)
+	if err := injector.Inject(TargetLDAP); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected an injected fault, got: %v", err)
+	}
+	if *slept != time.Second {
+		t.Fatalf("expected 1s of latency, got: %s", *slept)
+	}
+	if err := injector.Inject(TargetStorage); err != nil {
+		t.Fatal(err)
+	}
+	if err := injector.Inject(TargetSigning); err != nil {
+		t.Fatal(err)
+	}
+	if *slept != time.Second {
+		t.Fatalf("unexpected latency: %s", *slept)
+	}
+}
+
+func TestSigner(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		Signing: FaultConfig{Latency: time.Millisecond, ErrorRate: 0.5}}
+	injector, slept := newTestInjector(t, config, 0.9)
+	signer := injector.Signer(privateKey)
+	message := []byte("message")
+	signature, err := signer.Sign(rand.Reader, message, crypto.Hash(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(privateKey.Public().(ed25519.PublicKey), message,
+		signature) {
+		t.Fatal("invalid signature")
+	}
+	if *slept != time.Millisecond {
+		t.Fatalf("expected 1ms of latency, got: %s", *slept)
+	}
+	injector.random = func() float64 { return 0.1 }
+	_, err = signer.Sign(rand.Reader, message, crypto.Hash(0))
+	if !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected an injected fault, got: %v", err)
+	}
+}
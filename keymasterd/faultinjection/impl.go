@@ -0,0 +1,94 @@
+package faultinjection
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+type injectingSigner struct {
+	injector *Injector
+	signer   crypto.Signer
+}
+
+func newInjector(config Config) (*Injector, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	faults := map[string]FaultConfig{
+		TargetLDAP:    config.LDAP,
+		TargetSigning: config.Signing,
+		TargetStorage: config.Storage,
+	}
+	for target, fault := range faults {
+		if fault.Latency < 0 {
+			return nil, fmt.Errorf("%s: negative latency: %s",
+				target, fault.Latency)
+		}
+		if fault.ErrorRate < 0 || fault.ErrorRate > 1 {
+			return nil, fmt.Errorf("%s: error_rate: %g not between 0 and 1",
+				target, fault.ErrorRate)
+		}
+	}
+	return &Injector{
+		faults: faults,
+		sleep:  time.Sleep,
+		random: rand.Float64,
+	}, nil
+}
+
+func (i *Injector) inject(target string) error {
+	if i == nil {
+		return nil
+	}
+	fault := i.faults[target]
+	if fault.Latency > 0 {
+		i.sleep(fault.Latency)
+	}
+	if fault.ErrorRate > 0 && i.random() < fault.ErrorRate {
+		return fmt.Errorf("%s: %w", target, ErrInjected)
+	}
+	return nil
+}
+
+func (i *Injector) signer(signer crypto.Signer) crypto.Signer {
+	if i == nil {
+		return signer
+	}
+	return &injectingSigner{injector: i, signer: signer}
+}
+
+func (i *Injector) string() string {
+	if i == nil {
+		return "disabled"
+	}
+	var targets []string
+	for target, fault := range i.faults {
+		if fault.Latency > 0 || fault.ErrorRate > 0 {
+			targets = append(targets, fmt.Sprintf(
+				"%s: latency=%s error_rate=%g",
+				target, fault.Latency, fault.ErrorRate))
+		}
+	}
+	if len(targets) < 1 {
+		return "no faults"
+	}
+	sort.Strings(targets)
+	return strings.Join(targets, ", ")
+}
+
+func (s *injectingSigner) Public() crypto.PublicKey {
+	return s.signer.Public()
+}
+
+func (s *injectingSigner) Sign(rand io.Reader, digest []byte,
+	opts crypto.SignerOpts) ([]byte, error) {
+	if err := s.injector.inject(TargetSigning); err != nil {
+		return nil, err
+	}
+	return s.signer.Sign(rand, digest, opts)
+}
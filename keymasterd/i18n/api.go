@@ -0,0 +1,52 @@
+// Package i18n provides a message catalog for translating the web UI and
+// negotiation of the language to use from the Accept-Language header.
+//
+// Messages are keyed by their English text, so untranslated messages (and
+// the English "translation") need no catalog entries.
+package i18n
+
+// BaseLanguage is the language of the message keys.
+const BaseLanguage = "en"
+
+// Catalog holds the translations for a set of languages. It must not be
+// modified after it has been shared between goroutines.
+type Catalog struct {
+	defaultLanguage string
+	messages        map[string]map[string]string // Key: language tag.
+}
+
+// New creates an empty catalog. The default language is used when none of
+// the languages accepted by a client are available. If it is empty,
+// BaseLanguage is used.
+func New(defaultLanguage string) *Catalog {
+	return newCatalog(defaultLanguage)
+}
+
+// AddMessages adds translations (keyed by the English text) for language.
+func (c *Catalog) AddMessages(language string, messages map[string]string) {
+	c.addMessages(language, messages)
+}
+
+// LoadDirectory loads the translations from the YAML files named
+// <language>.yml in dirname. Each file contains a map from the English text
+// to its translation.
+func (c *Catalog) LoadDirectory(dirname string) error {
+	return c.loadDirectory(dirname)
+}
+
+// Languages returns the sorted list of available languages.
+func (c *Catalog) Languages() []string {
+	return c.languages()
+}
+
+// Negotiate returns the available language which best matches the value of
+// an Accept-Language header. If c is nil, BaseLanguage is returned.
+func (c *Catalog) Negotiate(acceptLanguage string) string {
+	return c.negotiate(acceptLanguage)
+}
+
+// Translate returns the translation of message into language, or message
+// itself if there is none. If c is nil, message is returned.
+func (c *Catalog) Translate(language, message string) string {
+	return c.translate(language, message)
+}
@@ -0,0 +1,80 @@
+package i18n
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeTestCatalog() *Catalog {
+	catalog := New("")
+	catalog.AddMessages("de", map[string]string{"Password": "Passwort"})
+	catalog.AddMessages("fr-CA", map[string]string{"Password": "Mot de passe"})
+	return catalog
+}
+
+func TestNegotiate(t *testing.T) {
+	catalog := makeTestCatalog()
+	tests := map[string]string{
+		"":                          BaseLanguage,
+		"de":                        "de",
+		"de-CH, en;q=0.5":           "de",
+		"es, fr-CA;q=0.8, de;q=0.7": "fr-ca",
+		"es, de;q=0.7, fr-CA;q=0.8": "fr-ca",
+		"en-US, de;q=0.9":           "en",
+		"de;q=0, *":                 BaseLanguage,
+		"ja":                        BaseLanguage,
+		"de;q=invalid, fr-ca;q=0.1": "fr-ca",
+	}
+	for acceptLanguage, expected := range tests {
+		if language := catalog.Negotiate(acceptLanguage); language != expected {
+			t.Errorf("%q: expected %s, got %s", acceptLanguage, expected,
+				language)
+		}
+	}
+	if language := New("de").Negotiate("ja"); language != "de" {
+		t.Errorf("default language not used, got %s", language)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	catalog := makeTestCatalog()
+	if text := catalog.Translate("de", "Password"); text != "Passwort" {
+		t.Errorf("expected Passwort, got %s", text)
+	}
+	if text := catalog.Translate("de", "Username"); text != "Username" {
+		t.Errorf("untranslated message changed to %s", text)
+	}
+	if text := catalog.Translate("en", "Password"); text != "Password" {
+		t.Errorf("base language message changed to %s", text)
+	}
+	var nilCatalog *Catalog
+	if text := nilCatalog.Translate("de", "Password"); text != "Password" {
+		t.Errorf("nil catalog changed message to %s", text)
+	}
+}
+
+func TestLoadDirectory(t *testing.T) {
+	dirname, err := ioutil.TempDir("", "i18n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirname)
+	err = ioutil.WriteFile(filepath.Join(dirname, "es.yml"),
+		[]byte("Password: Contraseña\n\"Log out\": Cerrar sesión\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog := New("")
+	if err := catalog.LoadDirectory(dirname); err != nil {
+		t.Fatal(err)
+	}
+	if text := catalog.Translate("es", "Log out"); text != "Cerrar sesión" {
+		t.Errorf("expected Cerrar sesión, got %s", text)
+	}
+	languages := catalog.Languages()
+	if len(languages) != 2 || languages[0] != "en" || languages[1] != "es" {
+		t.Errorf("unexpected languages: %v", languages)
+	}
+}
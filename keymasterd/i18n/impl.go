@@ -0,0 +1,141 @@
+package i18n
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type acceptedLanguage struct {
+	tag     string
+	quality float64
+}
+
+func newCatalog(defaultLanguage string) *Catalog {
+	if defaultLanguage == "" {
+		defaultLanguage = BaseLanguage
+	}
+	return &Catalog{
+		defaultLanguage: strings.ToLower(defaultLanguage),
+		messages:        make(map[string]map[string]string),
+	}
+}
+
+func (c *Catalog) addMessages(language string, messages map[string]string) {
+	language = strings.ToLower(language)
+	languageMessages := c.messages[language]
+	if languageMessages == nil {
+		languageMessages = make(map[string]string, len(messages))
+		c.messages[language] = languageMessages
+	}
+	for key, value := range messages {
+		languageMessages[key] = value
+	}
+}
+
+func (c *Catalog) loadDirectory(dirname string) error {
+	filenames, err := filepath.Glob(filepath.Join(dirname, "*.yml"))
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if err := yaml.Unmarshal(data, &messages); err != nil {
+			return err
+		}
+		c.addMessages(strings.TrimSuffix(filepath.Base(filename), ".yml"),
+			messages)
+	}
+	return nil
+}
+
+func (c *Catalog) hasLanguage(language string) bool {
+	if language == BaseLanguage {
+		return true
+	}
+	_, ok := c.messages[language]
+	return ok
+}
+
+func (c *Catalog) languages() []string {
+	languages := []string{BaseLanguage}
+	if c == nil {
+		return languages
+	}
+	for language := range c.messages {
+		if language != BaseLanguage {
+			languages = append(languages, language)
+		}
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// parseAcceptLanguage returns the accepted languages, most preferred first.
+func parseAcceptLanguage(acceptLanguage string) []acceptedLanguage {
+	var accepted []acceptedLanguage
+	for _, field := range strings.Split(acceptLanguage, ",") {
+		parts := strings.Split(field, ";")
+		tag := strings.ToLower(strings.TrimSpace(parts[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			value, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil {
+				value = 0
+			}
+			quality = value
+		}
+		if quality <= 0 {
+			continue
+		}
+		accepted = append(accepted, acceptedLanguage{tag, quality})
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].quality > accepted[j].quality
+	})
+	return accepted
+}
+
+func (c *Catalog) negotiate(acceptLanguage string) string {
+	if c == nil {
+		return BaseLanguage
+	}
+	for _, accepted := range parseAcceptLanguage(acceptLanguage) {
+		if c.hasLanguage(accepted.tag) {
+			return accepted.tag
+		}
+		// Fall back from a regional variant (de-ch) to the language (de).
+		if index := strings.Index(accepted.tag, "-"); index > 0 {
+			if base := accepted.tag[:index]; c.hasLanguage(base) {
+				return base
+			}
+		}
+	}
+	return c.defaultLanguage
+}
+
+func (c *Catalog) translate(language, message string) string {
+	if c == nil {
+		return message
+	}
+	if translation, ok := c.messages[language][message]; ok &&
+		translation != "" {
+		return translation
+	}
+	return message
+}
@@ -0,0 +1,93 @@
+// Package instanceidentity verifies the identity documents which cloud
+// providers make available to their VMs, so that newly launched instances
+// can authenticate without a pre-shared secret.
+package instanceidentity
+
+import (
+	"crypto/x509"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// Providers.
+const (
+	ProviderAWS = "aws"
+	ProviderGCP = "gcp"
+)
+
+// AWSConfig configures verification of AWS instance identity documents.
+// CertificateFilename contains the PEM encoded AWS public certificates for
+// the regions of the instances, as published in the EC2 documentation.
+type AWSConfig struct {
+	AllowedAccountIDs   []string `yaml:"allowed_account_ids"`
+	CertificateFilename string   `yaml:"certificate_filename"`
+}
+
+// GCPConfig configures verification of GCP instance identity tokens. The
+// tokens must be requested with format=full and the configured audience.
+type GCPConfig struct {
+	AllowedProjectIDs []string `yaml:"allowed_project_ids"`
+	Audience          string   `yaml:"audience"`
+	CertsURL          string   `yaml:"certs_url"` // Default: Google's JWKS.
+}
+
+// Config configures the Verifier. A provider is enabled if it has at least
+// one allowed account or project.
+type Config struct {
+	AWS AWSConfig `yaml:"aws"`
+	GCP GCPConfig `yaml:"gcp"`
+}
+
+// Identity is a verified instance identity.
+type Identity struct {
+	Provider   string
+	Account    string // AWS account ID or GCP project ID.
+	Location   string // AWS region or GCP zone.
+	InstanceID string
+	Hostnames  []string // Names and addresses the instance is known by.
+}
+
+// Verifier verifies instance identities.
+type Verifier struct {
+	awsAccounts     map[string]struct{}
+	awsCertificates []*x509.Certificate
+	gcpProjects     map[string]struct{}
+	gcpAudience     string
+	gcpCertsURL     string
+	client          *http.Client
+	logger          log.DebugLogger
+	mutex           sync.Mutex
+	// Protected by lock.
+	gcpKeys        *jose.JSONWebKeySet
+	gcpKeysFetched time.Time
+}
+
+// Enabled returns true if any provider is configured.
+func (c Config) Enabled() bool {
+	return len(c.AWS.AllowedAccountIDs) > 0 ||
+		len(c.GCP.AllowedProjectIDs) > 0
+}
+
+// New creates a Verifier.
+func New(config Config, logger log.DebugLogger) (*Verifier, error) {
+	return newVerifier(config, logger)
+}
+
+// VerifyAWS verifies an AWS instance identity document and its base64
+// encoded RSA-SHA256 signature, as served by the instance metadata service
+// at /latest/dynamic/instance-identity/document and .../signature.
+func (v *Verifier) VerifyAWS(document []byte, signature string) (
+	*Identity, error) {
+	return v.verifyAWS(document, signature)
+}
+
+// VerifyGCP verifies a GCP instance identity token, as served by the
+// metadata server at
+// /computeMetadata/v1/instance/service-accounts/default/identity.
+func (v *Verifier) VerifyGCP(token string) (*Identity, error) {
+	return v.verifyGCP(token)
+}
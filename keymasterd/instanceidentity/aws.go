@@ -0,0 +1,70 @@
+package instanceidentity
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type awsIdentityDocument struct {
+	AccountID  string `json:"accountId"`
+	InstanceID string `json:"instanceId"`
+	PrivateIP  string `json:"privateIp"`
+	Region     string `json:"region"`
+}
+
+// awsPrivateDNSName returns the IP-based private DNS name of an instance.
+func awsPrivateDNSName(privateIP, region string) string {
+	name := "ip-" + strings.Replace(privateIP, ".", "-", -1)
+	if region == "us-east-1" {
+		return name + ".ec2.internal"
+	}
+	return name + "." + region + ".compute.internal"
+}
+
+func (v *Verifier) verifyAWS(document []byte, signature string) (
+	*Identity, error) {
+	if v.awsAccounts == nil {
+		return nil, errors.New("AWS instance identities not enabled")
+	}
+	rawSignature, err := base64.StdEncoding.DecodeString(
+		strings.TrimSpace(signature))
+	if err != nil {
+		return nil, err
+	}
+	verified := false
+	for _, cert := range v.awsCertificates {
+		err := cert.CheckSignature(x509.SHA256WithRSA, document, rawSignature)
+		if err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid AWS identity document signature")
+	}
+	var doc awsIdentityDocument
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, err
+	}
+	if _, ok := v.awsAccounts[doc.AccountID]; !ok {
+		return nil, fmt.Errorf("AWS account: %s not allowed", doc.AccountID)
+	}
+	if doc.InstanceID == "" || doc.PrivateIP == "" || doc.Region == "" {
+		return nil, errors.New("incomplete AWS identity document")
+	}
+	return &Identity{
+		Provider:   ProviderAWS,
+		Account:    doc.AccountID,
+		Location:   doc.Region,
+		InstanceID: doc.InstanceID,
+		Hostnames: []string{
+			awsPrivateDNSName(doc.PrivateIP, doc.Region),
+			doc.InstanceID,
+			doc.PrivateIP,
+		},
+	}, nil
+}
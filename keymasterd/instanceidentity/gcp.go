@@ -0,0 +1,134 @@
+package instanceidentity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	gcpKeysMaxAge      = time.Hour
+	gcpKeysMinInterval = time.Minute
+)
+
+var gcpIssuers = map[string]struct{}{
+	"https://accounts.google.com": {},
+	"accounts.google.com":         {},
+}
+
+type gcpComputeEngine struct {
+	InstanceID   string `json:"instance_id"`
+	InstanceName string `json:"instance_name"`
+	ProjectID    string `json:"project_id"`
+	Zone         string `json:"zone"`
+}
+
+type gcpClaims struct {
+	jwt.Claims
+	Google struct {
+		ComputeEngine gcpComputeEngine `json:"compute_engine"`
+	} `json:"google"`
+}
+
+// getGCPKey returns the Google signing key with the given ID, fetching the
+// key set if it is stale or does not contain the key.
+func (v *Verifier) getGCPKey(keyID string) (*jose.JSONWebKey, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	age := time.Since(v.gcpKeysFetched)
+	if v.gcpKeys != nil && age < gcpKeysMaxAge {
+		if keys := v.gcpKeys.Key(keyID); len(keys) > 0 {
+			return &keys[0], nil
+		}
+	}
+	if v.gcpKeys == nil || age >= gcpKeysMinInterval {
+		keys, err := v.fetchGCPKeys()
+		if err != nil {
+			if v.gcpKeys == nil {
+				return nil, err
+			}
+			v.logger.Printf("error refreshing GCP keys: %s", err)
+		} else {
+			v.gcpKeys = keys
+			v.gcpKeysFetched = time.Now()
+		}
+	}
+	if keys := v.gcpKeys.Key(keyID); len(keys) > 0 {
+		return &keys[0], nil
+	}
+	return nil, fmt.Errorf("unknown GCP signing key: %s", keyID)
+}
+
+func (v *Verifier) fetchGCPKeys() (*jose.JSONWebKeySet, error) {
+	resp, err := v.client.Get(v.gcpCertsURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("error fetching GCP keys: %s", resp.Status)
+	}
+	var keys jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return nil, err
+	}
+	v.logger.Debugf(1, "fetched %d GCP keys\n", len(keys.Keys))
+	return &keys, nil
+}
+
+func (v *Verifier) verifyGCP(token string) (*Identity, error) {
+	if v.gcpProjects == nil {
+		return nil, errors.New("GCP instance identities not enabled")
+	}
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(tok.Headers) != 1 ||
+		tok.Headers[0].Algorithm != string(jose.RS256) {
+		return nil, errors.New("unsupported GCP token signature")
+	}
+	key, err := v.getGCPKey(tok.Headers[0].KeyID)
+	if err != nil {
+		return nil, err
+	}
+	var claims gcpClaims
+	if err := tok.Claims(key, &claims); err != nil {
+		return nil, err
+	}
+	if _, ok := gcpIssuers[claims.Claims.Issuer]; !ok {
+		return nil, fmt.Errorf("invalid GCP token issuer: %s",
+			claims.Claims.Issuer)
+	}
+	err = claims.Claims.ValidateWithLeeway(jwt.Expected{
+		Audience: jwt.Audience{v.gcpAudience},
+		Time:     time.Now(),
+	}, time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	gce := claims.Google.ComputeEngine
+	if gce.InstanceID == "" || gce.InstanceName == "" || gce.Zone == "" {
+		return nil, errors.New(
+			"GCP token has no instance details: request format=full")
+	}
+	if _, ok := v.gcpProjects[gce.ProjectID]; !ok {
+		return nil, fmt.Errorf("GCP project: %s not allowed", gce.ProjectID)
+	}
+	return &Identity{
+		Provider:   ProviderGCP,
+		Account:    gce.ProjectID,
+		Location:   gce.Zone,
+		InstanceID: gce.InstanceID,
+		Hostnames: []string{
+			gce.InstanceName + "." + gce.Zone + ".c." + gce.ProjectID +
+				".internal",
+			gce.InstanceName,
+			gce.InstanceID,
+		},
+	}, nil
+}
@@ -0,0 +1,83 @@
+package instanceidentity
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+const defaultGCPCertsURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+func makeSet(values []string) map[string]struct{} {
+	if len(values) < 1 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+	return set
+}
+
+func loadCertificates(filename string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, cert)
+	}
+	if len(certificates) < 1 {
+		return nil, fmt.Errorf("no certificates in: %s", filename)
+	}
+	return certificates, nil
+}
+
+func newVerifier(config Config, logger log.DebugLogger) (*Verifier, error) {
+	v := &Verifier{
+		awsAccounts: makeSet(config.AWS.AllowedAccountIDs),
+		gcpProjects: makeSet(config.GCP.AllowedProjectIDs),
+		gcpAudience: config.GCP.Audience,
+		gcpCertsURL: config.GCP.CertsURL,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		logger:      logger,
+	}
+	if v.awsAccounts != nil {
+		if config.AWS.CertificateFilename == "" {
+			return nil, errors.New("aws certificate_filename not specified")
+		}
+		certificates, err := loadCertificates(config.AWS.CertificateFilename)
+		if err != nil {
+			return nil, err
+		}
+		v.awsCertificates = certificates
+	}
+	if v.gcpProjects != nil {
+		if v.gcpAudience == "" {
+			return nil, errors.New("gcp audience not specified")
+		}
+		if v.gcpCertsURL == "" {
+			v.gcpCertsURL = defaultGCPCertsURL
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,168 @@
+package instanceidentity
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const testAWSDocument = `{
+  "accountId" : "123456789012",
+  "availabilityZone" : "us-west-2a",
+  "instanceId" : "i-0123456789abcdef0",
+  "privateIp" : "10.1.2.3",
+  "region" : "us-west-2"
+}`
+
+func writeTestCertificate(t *testing.T, key *rsa.PrivateKey) string {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Amazon Web Services LLC"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := ioutil.TempFile("", "aws-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	err = pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func TestVerifyAWS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFilename := writeTestCertificate(t, key)
+	defer os.Remove(certFilename)
+	v, err := New(Config{AWS: AWSConfig{
+		AllowedAccountIDs:   []string{"123456789012"},
+		CertificateFilename: certFilename,
+	}}, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := []byte(testAWSDocument)
+	hash := sha256.Sum256(document)
+	rawSignature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256,
+		hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := base64.StdEncoding.EncodeToString(rawSignature)
+	identity, err := v.VerifyAWS(document, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Provider != ProviderAWS ||
+		identity.InstanceID != "i-0123456789abcdef0" ||
+		identity.Hostnames[0] != "ip-10-1-2-3.us-west-2.compute.internal" {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+	// A tampered document must be rejected.
+	document[len(document)-2] = ' '
+	if _, err := v.VerifyAWS(document, signature); err == nil {
+		t.Fatal("tampered document verified")
+	}
+	// So must documents from other accounts.
+	v.awsAccounts = makeSet([]string{"210987654321"})
+	if _, err := v.VerifyAWS([]byte(testAWSDocument), signature); err == nil {
+		t.Fatal("document from disallowed account verified")
+	}
+	if _, err := v.VerifyGCP("token"); err == nil {
+		t.Fatal("GCP verified when not enabled")
+	}
+}
+
+func TestVerifyGCP(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := jose.JSONWebKey{Key: &key.PublicKey, KeyID: "test-key",
+		Algorithm: string(jose.RS256), Use: "sig"}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+				Keys: []jose.JSONWebKey{jwk}})
+		}))
+	defer server.Close()
+	v, err := New(Config{GCP: GCPConfig{
+		AllowedProjectIDs: []string{"my-project"},
+		Audience:          "https://keymaster.example.com",
+		CertsURL:          server.URL,
+	}}, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256,
+		Key: jose.JSONWebKey{Key: key, KeyID: "test-key"}},
+		(&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeToken := func(audience, project string) string {
+		claims := gcpClaims{Claims: jwt.Claims{
+			Issuer:   "https://accounts.google.com",
+			Audience: jwt.Audience{audience},
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}}
+		claims.Google.ComputeEngine = gcpComputeEngine{
+			InstanceID:   "1234567890",
+			InstanceName: "web-1",
+			ProjectID:    project,
+			Zone:         "us-central1-a",
+		}
+		token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	identity, err := v.VerifyGCP(
+		makeToken("https://keymaster.example.com", "my-project"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Provider != ProviderGCP || identity.InstanceID != "1234567890" ||
+		identity.Hostnames[0] != "web-1.us-central1-a.c.my-project.internal" {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+	_, err = v.VerifyGCP(makeToken("https://other.example.com", "my-project"))
+	if err == nil {
+		t.Fatal("token for other audience verified")
+	}
+	_, err = v.VerifyGCP(
+		makeToken("https://keymaster.example.com", "other-project"))
+	if err == nil {
+		t.Fatal("token from disallowed project verified")
+	}
+}
@@ -0,0 +1,69 @@
+// Package kubesigner implements a Kubernetes external signer. It signs the
+// approved CertificateSigningRequests which name the configured signer and
+// writes the certificates back through the apiserver.
+package kubesigner
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+// Config configures the signer. If APIServerURL is empty, the in-cluster
+// service account configuration is used.
+type Config struct {
+	APIServerURL  string        `yaml:"api_server_url"`
+	CAFilename    string        `yaml:"ca_filename"`
+	TokenFilename string        `yaml:"token_filename"`
+	SignerName    string        `yaml:"signer_name"`
+	PollInterval  time.Duration `yaml:"poll_interval"`
+}
+
+// Request describes an approved CertificateSigningRequest.
+type Request struct {
+	Name      string        // Name of the CertificateSigningRequest object.
+	Username  string        // Common name of the requested subject.
+	Requestor string        // User which created the request.
+	PublicKey interface{}   // Public key from the request.
+	Duration  time.Duration // Requested lifetime, zero if not specified.
+}
+
+// ErrRefused is wrapped by the errors of a SignFunc which refuses to sign a
+// request, for example because the account is disabled. The request is
+// marked as Failed.
+var ErrRefused = errors.New("request refused")
+
+// SignFunc returns a DER encoded certificate for an approved request. Errors
+// other than ErrRefused are treated as transient: the request is retried at
+// the next poll.
+type SignFunc func(request Request) ([]byte, error)
+
+// Signer polls the apiserver for requests to sign.
+type Signer struct {
+	apiServerURL  string
+	tokenFilename string
+	signerName    string
+	pollInterval  time.Duration
+	client        *http.Client
+	sign          SignFunc
+	logger        log.DebugLogger
+}
+
+// Enabled returns true if a signer name is configured.
+func (c Config) Enabled() bool {
+	return c.SignerName != ""
+}
+
+// New creates a Signer and starts polling in the background.
+func New(config Config, sign SignFunc, logger log.DebugLogger) (
+	*Signer, error) {
+	return newSigner(config, sign, logger)
+}
+
+// Poll processes the pending requests once. It is called periodically by the
+// background goroutine.
+func (s *Signer) Poll() error {
+	return s.poll()
+}
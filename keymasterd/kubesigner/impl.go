@@ -0,0 +1,297 @@
+package kubesigner
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+const (
+	csrAPIPath          = "/apis/certificates.k8s.io/v1/certificatesigningrequests"
+	defaultPollInterval = 30 * time.Second
+	serviceAccountDir   = "/var/run/secrets/kubernetes.io/serviceaccount"
+	failureReason       = "KeymasterSigningFailed"
+)
+
+// Usages which may be requested: keymaster only issues client certificates.
+var allowedUsages = map[string]struct{}{
+	"client auth":       {},
+	"digital signature": {},
+	"key encipherment":  {},
+}
+
+type csrCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+type certificateSigningRequest struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Request           []byte   `json:"request"`
+		ExpirationSeconds int64    `json:"expirationSeconds"`
+		Usages            []string `json:"usages"`
+		Username          string   `json:"username"`
+	} `json:"spec"`
+	Status struct {
+		Certificate []byte         `json:"certificate"`
+		Conditions  []csrCondition `json:"conditions"`
+	} `json:"status"`
+}
+
+type csrList struct {
+	Items []json.RawMessage `json:"items"`
+}
+
+func makeSigner(config Config, sign SignFunc, logger log.DebugLogger) (
+	*Signer, error) {
+	if config.SignerName == "" {
+		return nil, errors.New("no signer_name specified")
+	}
+	apiServerURL := config.APIServerURL
+	caFilename := config.CAFilename
+	tokenFilename := config.TokenFilename
+	if apiServerURL == "" {
+		host := os.Getenv("KUBERNETES_SERVICE_HOST")
+		port := os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New(
+				"no api_server_url specified and not running in a cluster")
+		}
+		apiServerURL = "https://" + net.JoinHostPort(host, port)
+		if caFilename == "" {
+			caFilename = serviceAccountDir + "/ca.crt"
+		}
+		if tokenFilename == "" {
+			tokenFilename = serviceAccountDir + "/token"
+		}
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFilename != "" {
+		caData, err := ioutil.ReadFile(caFilename)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates in: %s", caFilename)
+		}
+	}
+	pollInterval := config.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	return &Signer{
+		apiServerURL:  strings.TrimSuffix(apiServerURL, "/"),
+		tokenFilename: tokenFilename,
+		signerName:    config.SignerName,
+		pollInterval:  pollInterval,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		sign:   sign,
+		logger: logger,
+	}, nil
+}
+
+func newSigner(config Config, sign SignFunc, logger log.DebugLogger) (
+	*Signer, error) {
+	s, err := makeSigner(config, sign, logger)
+	if err != nil {
+		return nil, err
+	}
+	go s.loop()
+	return s, nil
+}
+
+func (s *Signer) loop() {
+	for ; ; time.Sleep(s.pollInterval) {
+		if err := s.poll(); err != nil {
+			s.logger.Printf("kubesigner: %s", err)
+		}
+	}
+}
+
+// doRequest sends a request to the apiserver, authenticating with the
+// service account token. The token file is read each time since projected
+// tokens are rotated.
+func (s *Signer) doRequest(method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, s.apiServerURL+path,
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.tokenFilename != "" {
+		token, err := ioutil.ReadFile(s.tokenFilename)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization",
+			"Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status,
+			strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
+
+func (s *Signer) poll() error {
+	query := url.Values{}
+	query.Set("fieldSelector", "spec.signerName="+s.signerName)
+	body, err := s.doRequest("GET", csrAPIPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	var list csrList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return err
+	}
+	for _, rawCSR := range list.Items {
+		if err := s.processCSR(rawCSR); err != nil {
+			s.logger.Printf("kubesigner: %s", err)
+		}
+	}
+	return nil
+}
+
+func hasCondition(csr *certificateSigningRequest, conditionType string) bool {
+	for _, condition := range csr.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRequest returns the request to sign, or an error describing why the
+// CertificateSigningRequest can never be signed.
+func parseRequest(csr *certificateSigningRequest) (Request, error) {
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return Request{}, errors.New("request is not a PEM certificate request")
+	}
+	certRequest, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return Request{}, err
+	}
+	if err := certRequest.CheckSignature(); err != nil {
+		return Request{}, err
+	}
+	if certRequest.Subject.CommonName == "" {
+		return Request{}, errors.New("request has no common name")
+	}
+	for _, usage := range csr.Spec.Usages {
+		if _, ok := allowedUsages[usage]; !ok {
+			return Request{}, fmt.Errorf("usage not allowed: %s", usage)
+		}
+	}
+	return Request{
+		Name:      csr.Metadata.Name,
+		Username:  certRequest.Subject.CommonName,
+		Requestor: csr.Spec.Username,
+		PublicKey: certRequest.PublicKey,
+		Duration:  time.Duration(csr.Spec.ExpirationSeconds) * time.Second,
+	}, nil
+}
+
+func (s *Signer) processCSR(rawCSR json.RawMessage) error {
+	var csr certificateSigningRequest
+	if err := json.Unmarshal(rawCSR, &csr); err != nil {
+		return err
+	}
+	if len(csr.Status.Certificate) > 0 || !hasCondition(&csr, "Approved") ||
+		hasCondition(&csr, "Denied") || hasCondition(&csr, "Failed") {
+		return nil
+	}
+	request, err := parseRequest(&csr)
+	if err != nil {
+		s.logger.Printf("kubesigner: rejecting %s: %s", csr.Metadata.Name, err)
+		return s.updateStatus(rawCSR, nil, err)
+	}
+	derCert, err := s.sign(request)
+	if errors.Is(err, ErrRefused) {
+		s.logger.Printf("kubesigner: refusing %s: %s", csr.Metadata.Name, err)
+		return s.updateStatus(rawCSR, nil, err)
+	}
+	if err != nil {
+		return fmt.Errorf("error signing %s: %s", csr.Metadata.Name, err)
+	}
+	s.logger.Printf("kubesigner: signed %s for %s, requested by %s",
+		csr.Metadata.Name, request.Username, request.Requestor)
+	return s.updateStatus(rawCSR, derCert, nil)
+}
+
+// updateStatus writes either the certificate or a Failed condition to the
+// status of the CertificateSigningRequest. The object is updated as a
+// generic map so that fields unknown to this package are preserved.
+func (s *Signer) updateStatus(rawCSR json.RawMessage, derCert []byte,
+	signErr error) error {
+	var object map[string]interface{}
+	if err := json.Unmarshal(rawCSR, &object); err != nil {
+		return err
+	}
+	metadata, _ := object["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	if name == "" {
+		return errors.New("request has no name")
+	}
+	status, _ := object["status"].(map[string]interface{})
+	if status == nil {
+		status = make(map[string]interface{})
+		object["status"] = status
+	}
+	if signErr == nil {
+		status["certificate"] = base64.StdEncoding.EncodeToString(
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+				Bytes: derCert}))
+	} else {
+		conditions, _ := status["conditions"].([]interface{})
+		now := time.Now().UTC().Format(time.RFC3339)
+		status["conditions"] = append(conditions, map[string]interface{}{
+			"type":               "Failed",
+			"status":             "True",
+			"reason":             failureReason,
+			"message":            signErr.Error(),
+			"lastUpdateTime":     now,
+			"lastTransitionTime": now,
+		})
+	}
+	body, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	_, err = s.doRequest("PUT",
+		csrAPIPath+"/"+url.PathEscape(name)+"/status", body)
+	return err
+}
@@ -0,0 +1,178 @@
+package kubesigner
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+const testSignerName = "keymaster.example.com/user"
+
+type fakeAPIServer struct {
+	t       *testing.T
+	items   []map[string]interface{}
+	mutex   sync.Mutex
+	updates map[string]certificateSigningRequest
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if r.URL.Path != csrAPIPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("fieldSelector") !=
+			"spec.signerName="+testSignerName {
+			f.t.Errorf("bad fieldSelector: %s", r.URL.RawQuery)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": f.items})
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		var csr certificateSigningRequest
+		if err := json.Unmarshal(body, &csr); err != nil {
+			f.t.Error(err)
+		}
+		if r.URL.Path != csrAPIPath+"/"+csr.Metadata.Name+"/status" {
+			f.t.Errorf("bad update path: %s", r.URL.Path)
+		}
+		f.mutex.Lock()
+		f.updates[csr.Metadata.Name] = csr
+		f.mutex.Unlock()
+		w.Write(body)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func makeCSRObject(t *testing.T, name, commonName string,
+	conditions ...string) map[string]interface{} {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader,
+		&x509.CertificateRequest{
+			Subject: pkix.Name{CommonName: commonName,
+				Organization: []string{"system:masters"}},
+		}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conditionList []interface{}
+	for _, condition := range conditions {
+		conditionList = append(conditionList,
+			map[string]interface{}{"type": condition, "status": "True"})
+	}
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":            name,
+			"resourceVersion": "42",
+		},
+		"spec": map[string]interface{}{
+			"request": pem.EncodeToMemory(&pem.Block{
+				Type: "CERTIFICATE REQUEST", Bytes: der}),
+			"signerName":        testSignerName,
+			"expirationSeconds": 3600,
+			"usages":            []string{"client auth"},
+			"username":          "kubectl-user",
+		},
+		"status": map[string]interface{}{"conditions": conditionList},
+	}
+}
+
+func TestPoll(t *testing.T) {
+	apiServer := &fakeAPIServer{
+		t: t,
+		items: []map[string]interface{}{
+			makeCSRObject(t, "approved", "alice", "Approved"),
+			makeCSRObject(t, "pending", "bob"),
+			makeCSRObject(t, "denied", "carol", "Denied"),
+			makeCSRObject(t, "nocn", "", "Approved"),
+			makeCSRObject(t, "transient", "dave", "Approved"),
+			makeCSRObject(t, "refused", "eve", "Approved"),
+		},
+		updates: make(map[string]certificateSigningRequest),
+	}
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+	tokenFile, err := ioutil.TempFile("", "kubesigner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tokenFile.Close()
+	if _, err := tokenFile.WriteString("secret\n"); err != nil {
+		t.Fatal(err)
+	}
+	var signed []Request
+	sign := func(request Request) ([]byte, error) {
+		if request.Username == "dave" {
+			return nil, errors.New("signer sealed")
+		}
+		if request.Username == "eve" {
+			return nil, fmt.Errorf("account disabled: %w", ErrRefused)
+		}
+		signed = append(signed, request)
+		return []byte("not really DER"), nil
+	}
+	signer, err := makeSigner(Config{
+		APIServerURL:  server.URL,
+		TokenFilename: tokenFile.Name(),
+		SignerName:    testSignerName,
+	}, sign, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(signed) != 1 {
+		t.Fatalf("signed %d requests, expected 1", len(signed))
+	}
+	if signed[0].Name != "approved" || signed[0].Username != "alice" ||
+		signed[0].Requestor != "kubectl-user" ||
+		signed[0].Duration != time.Hour {
+		t.Fatalf("unexpected request: %+v", signed[0])
+	}
+	if len(apiServer.updates) != 3 {
+		t.Fatalf("%d updates, expected 3", len(apiServer.updates))
+	}
+	block, _ := pem.Decode(apiServer.updates["approved"].Status.Certificate)
+	if block == nil || string(block.Bytes) != "not really DER" {
+		t.Fatal("certificate not written to status")
+	}
+	if !hasCondition(&certificateSigningRequest{
+		Status: apiServer.updates["nocn"].Status}, "Failed") {
+		t.Fatal("request without common name not marked as failed")
+	}
+	if !hasCondition(&certificateSigningRequest{
+		Status: apiServer.updates["refused"].Status}, "Failed") {
+		t.Fatal("refused request not marked as failed")
+	}
+}
+
+func TestMissingSignerName(t *testing.T) {
+	_, err := makeSigner(Config{APIServerURL: "https://localhost"}, nil,
+		testlogger.New(t))
+	if err == nil {
+		t.Fatal("expected error without signer name")
+	}
+}
@@ -0,0 +1,25 @@
+// Package proxyprotocol implements a net.Listener which understands the
+// HAProxy PROXY protocol (versions 1 and 2) from trusted load balancers, so
+// that RemoteAddr reports the real client address.
+package proxyprotocol
+
+import (
+	"net"
+	"time"
+)
+
+// IsTrustedFunc reports whether the PROXY header may be accepted from a peer.
+type IsTrustedFunc func(ip net.IP) bool
+
+// NewListener wraps listener. Connections from peers for which isTrusted
+// returns true may start with a PROXY header, which is consumed and used to
+// set the remote address of the connection. Connections without a header are
+// passed through unchanged. The header must arrive within headerTimeout.
+func NewListener(listener net.Listener, isTrusted IsTrustedFunc,
+	headerTimeout time.Duration) net.Listener {
+	return &proxyListener{
+		Listener:      listener,
+		isTrusted:     isTrusted,
+		headerTimeout: headerTimeout,
+	}
+}
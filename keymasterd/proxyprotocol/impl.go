@@ -0,0 +1,191 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	v1Prefix       = "PROXY "
+	v1MaxLength    = 107
+	v2CommandLocal = 0x0
+	v2CommandProxy = 0x1
+	v2FamilyTCP4   = 0x11
+	v2FamilyTCP6   = 0x21
+)
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+type proxyListener struct {
+	net.Listener
+	isTrusted     IsTrustedFunc
+	headerTimeout time.Duration
+}
+
+type proxyConn struct {
+	net.Conn
+	headerTimeout time.Duration
+	isTrusted     IsTrustedFunc
+	once          sync.Once
+	reader        *bufio.Reader
+	remoteAddr    net.Addr
+	err           error
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{
+		Conn:          conn,
+		headerTimeout: l.headerTimeout,
+		isTrusted:     l.isTrusted,
+		reader:        bufio.NewReader(conn),
+		remoteAddr:    conn.RemoteAddr(),
+	}, nil
+}
+
+// The header is read lazily so that a slow peer does not block Accept.
+func (c *proxyConn) readHeaderOnce() {
+	c.once.Do(func() {
+		tcpAddr, ok := c.Conn.RemoteAddr().(*net.TCPAddr)
+		if !ok || !c.isTrusted(tcpAddr.IP) {
+			return
+		}
+		if c.headerTimeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		addr, err := readHeader(c.reader)
+		if err != nil {
+			c.err = err
+			return
+		}
+		if addr != nil {
+			c.remoteAddr = addr
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeaderOnce()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeaderOnce()
+	return c.remoteAddr
+}
+
+// readHeader consumes a PROXY header if one is present. It returns a nil
+// address if there is no header or the header does not carry an address.
+func readHeader(reader *bufio.Reader) (net.Addr, error) {
+	prefix, err := reader.Peek(len(v1Prefix))
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if string(prefix) == v1Prefix {
+		return readV1Header(reader)
+	}
+	prefix, err = reader.Peek(len(v2Signature))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.Equal(prefix, v2Signature) {
+		return readV2Header(reader)
+	}
+	return nil, nil
+}
+
+func readV1Header(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxyprotocol: malformed v1 header")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("proxyprotocol: bad v1 header: %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, fmt.Errorf("proxyprotocol: bad source address: %s",
+			fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxyprotocol: bad source port: %s", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readV2Header(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(v2Signature)+4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	versionCommand := header[12]
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:16])
+	if versionCommand>>4 != 2 {
+		return nil, errors.New("proxyprotocol: unsupported version")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	switch versionCommand & 0xf {
+	case v2CommandLocal:
+		return nil, nil
+	case v2CommandProxy:
+	default:
+		return nil, errors.New("proxyprotocol: unsupported command")
+	}
+	switch family {
+	case v2FamilyTCP4:
+		if len(payload) < 12 {
+			return nil, errors.New("proxyprotocol: short v2 TCP4 address")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case v2FamilyTCP6:
+		if len(payload) < 36 {
+			return nil, errors.New("proxyprotocol: short v2 TCP6 address")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	}
+	return nil, nil
+}
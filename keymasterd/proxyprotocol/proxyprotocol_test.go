@@ -0,0 +1,96 @@
+package proxyprotocol
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func trustAll(net.IP) bool  { return true }
+func trustNone(net.IP) bool { return false }
+
+func v2Header(ip net.IP, port uint16) []byte {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x21, v2FamilyTCP4, 0, 12)
+	header = append(header, ip.To4()...)
+	header = append(header, 10, 0, 0, 1)
+	portBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBytes, port)
+	header = append(header, portBytes...)
+	return append(header, 0, 80)
+}
+
+func sendAndAccept(t *testing.T, isTrusted IsTrustedFunc,
+	data []byte) (net.Addr, string) {
+	rawListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := NewListener(rawListener, isTrusted, time.Second)
+	defer listener.Close()
+	go func() {
+		conn, err := net.Dial("tcp", rawListener.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write(data)
+		conn.Close()
+	}()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	remoteAddr := conn.RemoteAddr()
+	body, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return remoteAddr, string(body)
+}
+
+func TestV1Header(t *testing.T) {
+	addr, body := sendAndAccept(t, trustAll,
+		[]byte("PROXY TCP4 192.0.2.7 10.0.0.1 5555 443\r\nGET / HTTP/1.0\r\n"))
+	if addr.String() != "192.0.2.7:5555" {
+		t.Fatalf("unexpected address: %s", addr)
+	}
+	if body != "GET / HTTP/1.0\r\n" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
+
+func TestV2Header(t *testing.T) {
+	data := append(v2Header(net.ParseIP("198.51.100.9"), 4242),
+		[]byte("hello")...)
+	addr, body := sendAndAccept(t, trustAll, data)
+	if addr.String() != "198.51.100.9:4242" {
+		t.Fatalf("unexpected address: %s", addr)
+	}
+	if body != "hello" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
+
+func TestNoHeader(t *testing.T) {
+	addr, body := sendAndAccept(t, trustAll, []byte("GET / HTTP/1.0\r\n"))
+	if addr.(*net.TCPAddr).IP.String() != "127.0.0.1" {
+		t.Fatalf("unexpected address: %s", addr)
+	}
+	if body != "GET / HTTP/1.0\r\n" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
+
+func TestUntrustedPeerIgnored(t *testing.T) {
+	header := "PROXY TCP4 192.0.2.7 10.0.0.1 5555 443\r\n"
+	addr, body := sendAndAccept(t, trustNone, []byte(header))
+	if addr.(*net.TCPAddr).IP.String() != "127.0.0.1" {
+		t.Fatalf("untrusted peer was able to set address: %s", addr)
+	}
+	if body != header {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
@@ -0,0 +1,56 @@
+// Package publisher pushes files, such as certificate revocation lists, to
+// destinations outside keymasterd: S3 buckets, web servers accepting HTTP PUT
+// and local directories.
+package publisher
+
+import (
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+// DestinationConfig configures a destination. The URL is s3://bucket/prefix/
+// for S3, an http(s) URL to which the file names are appended for HTTP PUT,
+// or file:///path/to/directory or an absolute path for a local directory.
+type DestinationConfig struct {
+	URL         string `yaml:"url"`
+	BearerToken string `yaml:"bearer_token"` // For HTTP destinations.
+	Region      string `yaml:"region"`       // For S3. Default: from the environment.
+}
+
+// Config configures the Publisher. Files are published whenever Trigger is
+// called and every Interval, so that lists with an expiry stay fresh.
+type Config struct {
+	Destinations []DestinationConfig `yaml:"destinations"`
+	Interval     time.Duration       `yaml:"interval"` // Default: 1h.
+}
+
+// File is a file to publish.
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Publisher publishes the generated files to the destinations.
+type Publisher struct {
+	destinations []destination
+	generate     func() ([]File, error)
+	interval     time.Duration
+	trigger      chan struct{}
+	logger       log.DebugLogger
+}
+
+// New creates a Publisher which calls generate to get the files to publish
+// and publishes them immediately. New returns nil if no destinations are
+// configured.
+func New(config Config, generate func() ([]File, error),
+	logger log.DebugLogger) (*Publisher, error) {
+	return newPublisher(config, generate, logger)
+}
+
+// Trigger requests that the files be generated and published again. It does
+// not block. If p is nil, Trigger is a no-op.
+func (p *Publisher) Trigger() {
+	p.triggerPublish()
+}
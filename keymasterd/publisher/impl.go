@@ -0,0 +1,190 @@
+package publisher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+const defaultInterval = time.Hour
+
+type destination interface {
+	String() string
+	put(file File) error
+}
+
+type directoryDestination struct {
+	directory string
+}
+
+type httpDestination struct {
+	baseURL     string
+	bearerToken string
+	client      *http.Client
+}
+
+func newDestination(config DestinationConfig) (destination, error) {
+	if strings.HasPrefix(config.URL, "/") {
+		return &directoryDestination{config.URL}, nil
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		return &directoryDestination{u.Path}, nil
+	case "http", "https":
+		baseURL := config.URL
+		if !strings.HasSuffix(baseURL, "/") {
+			baseURL += "/"
+		}
+		return &httpDestination{
+			baseURL:     baseURL,
+			bearerToken: config.BearerToken,
+			client:      &http.Client{Timeout: time.Minute},
+		}, nil
+	case "s3":
+		return newS3Destination(u.Host, strings.TrimPrefix(u.Path, "/"),
+			config.Region)
+	default:
+		return nil, fmt.Errorf("unsupported destination: %s", config.URL)
+	}
+}
+
+func newPublisher(config Config, generate func() ([]File, error),
+	logger log.DebugLogger) (*Publisher, error) {
+	if len(config.Destinations) < 1 {
+		return nil, nil
+	}
+	p := &Publisher{
+		generate: generate,
+		interval: config.Interval,
+		trigger:  make(chan struct{}, 1),
+		logger:   logger,
+	}
+	if p.interval <= 0 {
+		p.interval = defaultInterval
+	}
+	for _, destinationConfig := range config.Destinations {
+		if destinationConfig.URL == "" {
+			return nil, errors.New("publishing destination url not specified")
+		}
+		dest, err := newDestination(destinationConfig)
+		if err != nil {
+			return nil, err
+		}
+		p.destinations = append(p.destinations, dest)
+	}
+	p.triggerPublish()
+	go p.loop()
+	return p, nil
+}
+
+func (p *Publisher) triggerPublish() {
+	if p == nil {
+		return
+	}
+	select {
+	case p.trigger <- struct{}{}:
+	default: // A publish is already pending.
+	}
+}
+
+func (p *Publisher) loop() {
+	ticker := time.NewTicker(p.interval)
+	for {
+		select {
+		case <-p.trigger:
+		case <-ticker.C:
+		}
+		p.publish()
+	}
+}
+
+// publish generates the files and puts them to all destinations, logging
+// failures. It returns the number of failures.
+func (p *Publisher) publish() int {
+	files, err := p.generate()
+	if err != nil {
+		p.logger.Printf("publisher: error generating files: %s", err)
+		return 1
+	}
+	var numFailures int
+	for _, dest := range p.destinations {
+		for _, file := range files {
+			if err := dest.put(file); err != nil {
+				p.logger.Printf("publisher: error publishing %s to %s: %s",
+					file.Name, dest, err)
+				numFailures++
+				continue
+			}
+			p.logger.Debugf(1, "publisher: published %s to %s\n",
+				file.Name, dest)
+		}
+	}
+	return numFailures
+}
+
+func (d *directoryDestination) String() string {
+	return d.directory
+}
+
+// put writes the file atomically so that readers never see partial content.
+func (d *directoryDestination) put(file File) error {
+	filename := filepath.Join(d.directory, file.Name)
+	tmpFile, err := ioutil.TempFile(d.directory, "."+file.Name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(file.Data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Chmod(0644); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filename)
+}
+
+func (d *httpDestination) String() string {
+	return d.baseURL
+}
+
+func (d *httpDestination) put(file File) error {
+	req, err := http.NewRequest("PUT", d.baseURL+file.Name,
+		bytes.NewReader(file.Data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", file.ContentType)
+	if d.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+d.bearerToken)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status,
+			strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+type putRecorder struct {
+	mutex sync.Mutex
+	files map[string]string
+}
+
+func (rec *putRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" || r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	rec.mutex.Lock()
+	rec.files[r.URL.Path] = string(body)
+	rec.mutex.Unlock()
+}
+
+func TestNoDestinations(t *testing.T) {
+	p, err := New(Config{}, nil, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != nil {
+		t.Fatal("expected nil Publisher")
+	}
+	p.Trigger() // Must not panic.
+}
+
+func TestBadConfig(t *testing.T) {
+	badConfigs := []Config{
+		{Destinations: []DestinationConfig{{}}},
+		{Destinations: []DestinationConfig{{URL: "ftp://example.com/"}}},
+		{Destinations: []DestinationConfig{{URL: "relative/path"}}},
+	}
+	for _, config := range badConfigs {
+		if _, err := newPublisher(config, nil, testlogger.New(t)); err == nil {
+			t.Errorf("no error for: %+v", config)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	rec := &putRecorder{files: make(map[string]string)}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	generate := func() ([]File, error) {
+		return []File{
+			{Name: "revoked_keys", Data: []byte("krl")},
+			{Name: "keymaster.crl", Data: []byte("crl")},
+		}, nil
+	}
+	p := &Publisher{generate: generate, logger: testlogger.New(t)}
+	for _, url := range []string{server.URL + "/crl", "file://" + dir} {
+		dest, err := newDestination(DestinationConfig{URL: url,
+			BearerToken: "token"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.destinations = append(p.destinations, dest)
+	}
+	if numFailures := p.publish(); numFailures != 0 {
+		t.Fatalf("%d failures", numFailures)
+	}
+	if rec.files["/crl/revoked_keys"] != "krl" ||
+		rec.files["/crl/keymaster.crl"] != "crl" {
+		t.Fatalf("unexpected HTTP files: %v", rec.files)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "keymaster.crl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "crl" {
+		t.Fatalf("unexpected file content: %s", data)
+	}
+	// Failures are counted per file and destination.
+	p.destinations[0].(*httpDestination).bearerToken = "wrong"
+	if numFailures := p.publish(); numFailures != 2 {
+		t.Fatalf("expected 2 failures, got %d", numFailures)
+	}
+}
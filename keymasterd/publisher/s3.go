@@ -0,0 +1,52 @@
+package publisher
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+type s3Destination struct {
+	bucket string
+	prefix string
+	client *s3.S3
+}
+
+func newS3Destination(bucket, prefix, region string) (destination, error) {
+	if bucket == "" {
+		return nil, errors.New("no S3 bucket specified")
+	}
+	awsConfig := aws.NewConfig()
+	if region != "" {
+		awsConfig = awsConfig.WithRegion(region)
+	}
+	awsSession, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Destination{
+		bucket: bucket,
+		prefix: prefix,
+		client: s3.New(awsSession),
+	}, nil
+}
+
+func (d *s3Destination) String() string {
+	return "s3://" + d.bucket + "/" + d.prefix
+}
+
+func (d *s3Destination) put(file File) error {
+	_, err := d.client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(d.bucket),
+		Key:         aws.String(d.prefix + file.Name),
+		Body:        bytes.NewReader(file.Data),
+		ContentType: aws.String(file.ContentType),
+	})
+	return err
+}
@@ -0,0 +1,56 @@
+// Package requestlimiter bounds the number of in-flight expensive operations,
+// such as LDAP binds and certificate signings, per user and overall. Excess
+// requests are rejected immediately rather than queued, so that a single
+// misbehaving client cannot starve everyone else.
+package requestlimiter
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrUserLimit is returned when the user has too many requests in
+	// flight.
+	ErrUserLimit = errors.New("too many concurrent requests for user")
+	// ErrTotalLimit is returned when there are too many requests in flight
+	// overall.
+	ErrTotalLimit = errors.New("too many concurrent requests")
+)
+
+// Config configures the limiter. Zero values select the defaults.
+type Config struct {
+	MaxPerUser int `yaml:"max_per_user"` // Default: 4.
+	MaxTotal   int `yaml:"max_total"`    // Default: 256.
+}
+
+// Limiter counts the in-flight requests per user and overall.
+type Limiter struct {
+	config Config
+	mutex  sync.Mutex     // Protect everything below.
+	total  int            // Requests in flight.
+	users  map[string]int // Requests in flight per user.
+}
+
+// New creates a Limiter.
+func New(config Config) *Limiter {
+	return newLimiter(config)
+}
+
+// Acquire reserves a request slot for username. The returned function must
+// be called to release the slot once the operation is done. If no slot is
+// available, ErrUserLimit or ErrTotalLimit is returned. If l is nil, requests
+// are not limited.
+func (l *Limiter) Acquire(username string) (func(), error) {
+	return l.acquire(username)
+}
+
+// InFlight returns the number of requests in flight overall.
+func (l *Limiter) InFlight() int {
+	return l.inFlight()
+}
+
+// IsLimited returns true if err was caused by a request limit.
+func IsLimited(err error) bool {
+	return errors.Is(err, ErrUserLimit) || errors.Is(err, ErrTotalLimit)
+}
@@ -0,0 +1,59 @@
+package requestlimiter
+
+const (
+	defaultMaxPerUser = 4
+	defaultMaxTotal   = 256
+)
+
+func newLimiter(config Config) *Limiter {
+	if config.MaxPerUser < 1 {
+		config.MaxPerUser = defaultMaxPerUser
+	}
+	if config.MaxTotal < 1 {
+		config.MaxTotal = defaultMaxTotal
+	}
+	return &Limiter{
+		config: config,
+		users:  make(map[string]int),
+	}
+}
+
+func (l *Limiter) acquire(username string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.users[username] >= l.config.MaxPerUser {
+		return nil, ErrUserLimit
+	}
+	if l.total >= l.config.MaxTotal {
+		return nil, ErrTotalLimit
+	}
+	l.users[username]++
+	l.total++
+	released := false
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		if released {
+			return
+		}
+		released = true
+		l.total--
+		if l.users[username] <= 1 {
+			delete(l.users, username)
+		} else {
+			l.users[username]--
+		}
+	}, nil
+}
+
+func (l *Limiter) inFlight() int {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.total
+}
@@ -0,0 +1,61 @@
+package requestlimiter
+
+import (
+	"testing"
+)
+
+func TestUserLimit(t *testing.T) {
+	limiter := New(Config{MaxPerUser: 2, MaxTotal: 10})
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := limiter.Acquire("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+	if _, err := limiter.Acquire("alice"); err != ErrUserLimit {
+		t.Fatalf("expected ErrUserLimit, got: %v", err)
+	}
+	release, err := limiter.Acquire("bob")
+	if err != nil {
+		t.Fatalf("other user limited: %s", err)
+	}
+	release()
+	releases[0]()
+	releases[0]() // Releasing twice must not free another slot.
+	if _, err := limiter.Acquire("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limiter.Acquire("alice"); err != ErrUserLimit {
+		t.Fatalf("expected ErrUserLimit, got: %v", err)
+	}
+	if n := limiter.InFlight(); n != 2 {
+		t.Fatalf("expected 2 requests in flight, got: %d", n)
+	}
+}
+
+func TestTotalLimit(t *testing.T) {
+	limiter := New(Config{MaxPerUser: 2, MaxTotal: 3})
+	for _, username := range []string{"alice", "bob", "carol"} {
+		if _, err := limiter.Acquire(username); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := limiter.Acquire("dave")
+	if err != ErrTotalLimit {
+		t.Fatalf("expected ErrTotalLimit, got: %v", err)
+	}
+	if !IsLimited(err) {
+		t.Fatal("IsLimited returned false")
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var limiter *Limiter
+	release, err := limiter.Acquire("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
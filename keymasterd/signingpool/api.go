@@ -0,0 +1,65 @@
+// Package signingpool limits the number of concurrent CA signing operations.
+// Signing with large RSA keys is CPU bound, so under burst load an unbounded
+// number of signing goroutines only makes every request slower. The pool
+// runs a fixed number of workers fed from a bounded queue; requests which
+// cannot be queued, or which are not signed before their deadline, fail
+// quickly so that clients can retry.
+package signingpool
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when the signing queue is full.
+	ErrQueueFull = errors.New("signing queue full")
+	// ErrTimeout is returned when a signing request was not completed
+	// before its deadline.
+	ErrTimeout = errors.New("signing request timed out")
+)
+
+// Config configures the pool. Zero values select the defaults.
+type Config struct {
+	Workers     int           `yaml:"workers"`      // Default: number of CPUs.
+	QueueLength int           `yaml:"queue_length"` // Default: 16 per worker.
+	Timeout     time.Duration `yaml:"timeout"`      // Default: 10s.
+}
+
+// Stats is a snapshot of the pool.
+type Stats struct {
+	Workers     int
+	QueueLength int // Capacity of the queue.
+	Queued      int // Requests waiting for a worker.
+}
+
+// Pool is a pool of signing workers.
+type Pool struct {
+	config   Config
+	requests chan *request
+}
+
+// New creates a Pool and starts its workers.
+func New(config Config) *Pool {
+	return newPool(config)
+}
+
+// Signer returns a crypto.Signer which signs with signer using a worker from
+// the pool. Each call to Sign waits at most until the deadline of ctx or the
+// configured timeout, whichever is sooner, and fails with ErrQueueFull or
+// ErrTimeout if the pool is overloaded. If p is nil, signer is returned.
+func (p *Pool) Signer(ctx context.Context, signer crypto.Signer) crypto.Signer {
+	return p.signer(ctx, signer)
+}
+
+// Stats returns the current statistics of the pool.
+func (p *Pool) Stats() Stats {
+	return p.stats()
+}
+
+// IsOverloaded returns true if err was caused by the pool being overloaded.
+func IsOverloaded(err error) bool {
+	return errors.Is(err, ErrQueueFull) || errors.Is(err, ErrTimeout)
+}
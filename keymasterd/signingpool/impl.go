@@ -0,0 +1,116 @@
+package signingpool
+
+import (
+	"context"
+	"crypto"
+	"io"
+	"runtime"
+	"time"
+)
+
+const (
+	defaultQueueLengthPerWorker = 16
+	defaultTimeout              = 10 * time.Second
+)
+
+type request struct {
+	ctx     context.Context
+	signer  crypto.Signer
+	rand    io.Reader
+	digest  []byte
+	opts    crypto.SignerOpts
+	resultC chan result // Buffered so that workers never block.
+}
+
+type result struct {
+	signature []byte
+	err       error
+}
+
+type poolSigner struct {
+	pool   *Pool
+	ctx    context.Context
+	signer crypto.Signer
+}
+
+func newPool(config Config) *Pool {
+	if config.Workers < 1 {
+		config.Workers = runtime.NumCPU()
+	}
+	if config.QueueLength < 1 {
+		config.QueueLength = config.Workers * defaultQueueLengthPerWorker
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	p := &Pool{
+		config:   config,
+		requests: make(chan *request, config.QueueLength),
+	}
+	for i := 0; i < config.Workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *Pool) worker() {
+	for req := range p.requests {
+		// Do not waste CPU on requests whose caller has given up.
+		if req.ctx.Err() != nil {
+			req.resultC <- result{err: ErrTimeout}
+			continue
+		}
+		signature, err := req.signer.Sign(req.rand, req.digest, req.opts)
+		req.resultC <- result{signature: signature, err: err}
+	}
+}
+
+func (p *Pool) signer(ctx context.Context,
+	signer crypto.Signer) crypto.Signer {
+	if p == nil {
+		return signer
+	}
+	return &poolSigner{pool: p, ctx: ctx, signer: signer}
+}
+
+func (p *Pool) stats() Stats {
+	return Stats{
+		Workers:     p.config.Workers,
+		QueueLength: p.config.QueueLength,
+		Queued:      len(p.requests),
+	}
+}
+
+func (p *Pool) sign(ctx context.Context, signer crypto.Signer,
+	rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+	req := &request{
+		ctx:     ctx,
+		signer:  signer,
+		rand:    rand,
+		digest:  digest,
+		opts:    opts,
+		resultC: make(chan result, 1),
+	}
+	select {
+	case p.requests <- req:
+	default:
+		return nil, ErrQueueFull
+	}
+	select {
+	case result := <-req.resultC:
+		return result.signature, result.err
+	case <-ctx.Done():
+		return nil, ErrTimeout
+	}
+}
+
+func (s *poolSigner) Public() crypto.PublicKey {
+	return s.signer.Public()
+}
+
+func (s *poolSigner) Sign(rand io.Reader, digest []byte,
+	opts crypto.SignerOpts) ([]byte, error) {
+	return s.pool.sign(s.ctx, s.signer, rand, digest, opts)
+}
@@ -0,0 +1,188 @@
+package signingpool
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// blockingSigner blocks in Sign until release is closed.
+type blockingSigner struct {
+	crypto.Signer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSigner) Sign(rand io.Reader, digest []byte,
+	opts crypto.SignerOpts) ([]byte, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func newBlockingSigner(t testing.TB) *blockingSigner {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &blockingSigner{
+		Signer:  privateKey,
+		started: make(chan struct{}, 16),
+		release: make(chan struct{}),
+	}
+}
+
+func TestSign(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := New(Config{Workers: 2})
+	signer := pool.Signer(context.Background(), privateKey)
+	if !publicKey.Equal(signer.Public()) {
+		t.Fatal("public key mismatch")
+	}
+	message := []byte("message")
+	signature, err := signer.Sign(rand.Reader, message, crypto.Hash(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(publicKey, message, signature) {
+		t.Fatal("signature does not verify")
+	}
+}
+
+func TestNilPool(t *testing.T) {
+	var pool *Pool
+	signer := newBlockingSigner(t)
+	if pool.Signer(context.Background(), signer) != crypto.Signer(signer) {
+		t.Fatal("nil pool did not return the signer")
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	blocking := newBlockingSigner(t)
+	defer close(blocking.release)
+	pool := New(Config{Workers: 1, QueueLength: 1, Timeout: time.Minute})
+	signer := pool.Signer(context.Background(), blocking)
+	errC := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := signer.Sign(rand.Reader, []byte("m"), crypto.Hash(0))
+			errC <- err
+		}()
+		if i == 0 {
+			<-blocking.started // The worker is now busy.
+		}
+	}
+	// Wait for the second request to be queued.
+	for pool.Stats().Queued < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err := signer.Sign(rand.Reader, []byte("m"), crypto.Hash(0))
+	if err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got: %v", err)
+	}
+	if !IsOverloaded(fmt.Errorf("signing: %w", err)) {
+		t.Fatal("wrapped ErrQueueFull not detected")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	blocking := newBlockingSigner(t)
+	defer close(blocking.release)
+	pool := New(Config{Workers: 1, Timeout: 10 * time.Millisecond})
+	signer := pool.Signer(context.Background(), blocking)
+	_, err := signer.Sign(rand.Reader, []byte("m"), crypto.Hash(0))
+	if err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got: %v", err)
+	}
+	// The request deadline applies even if it is shorter than the timeout.
+	pool = New(Config{Workers: 1, Timeout: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	_, err = pool.Signer(ctx, blocking).Sign(rand.Reader, []byte("m"),
+		crypto.Hash(0))
+	if err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got: %v", err)
+	}
+}
+
+type namedSigner struct {
+	name   string
+	signer crypto.Signer
+}
+
+func benchmarkSigners(b *testing.B) []namedSigner {
+	var signers []namedSigner
+	for _, bits := range []int{2048, 4096} {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			b.Fatal(err)
+		}
+		signers = append(signers, namedSigner{fmt.Sprintf("RSA%d", bits), key})
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	signers = append(signers, namedSigner{"P256", ecKey})
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	signers = append(signers, namedSigner{"Ed25519", edKey})
+	return signers
+}
+
+func benchmarkSign(b *testing.B, signer crypto.Signer) {
+	digest := sha256.Sum256([]byte("message"))
+	message := digest[:]
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		opts = crypto.Hash(0)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := signer.Sign(rand.Reader, message, opts); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkSign measures signing throughput with parallel callers, directly
+// and through pools of various sizes. Use -cpu to vary the number of callers
+// when estimating the capacity of a server.
+func BenchmarkSign(b *testing.B) {
+	for _, key := range benchmarkSigners(b) {
+		b.Run(key.name+"/direct", func(b *testing.B) {
+			benchmarkSign(b, key.signer)
+		})
+		for _, workers := range []int{1, 4, 16} {
+			pool := New(Config{
+				Workers:     workers,
+				QueueLength: 1024,
+				Timeout:     time.Minute,
+			})
+			b.Run(fmt.Sprintf("%s/pool-%d", key.name, workers),
+				func(b *testing.B) {
+					benchmarkSign(b,
+						pool.Signer(context.Background(), key.signer))
+				})
+		}
+	}
+}
@@ -3,7 +3,6 @@ package authutil
 import (
 	"crypto/rand"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -37,6 +36,10 @@ const argon2dPrefix = "$argon2d$"
 
 const randomStringEntropyBytes = 32
 
+// ErrLDAPUserNotFound is returned by GetLDAPUserAttributes if the search did
+// not find exactly one entry for the user.
+var ErrLDAPUserNotFound = errors.New("user not found or too many users found")
+
 func genRandomString() (string, error) {
 	size := randomStringEntropyBytes
 	rb := make([]byte, size)
@@ -95,9 +98,16 @@ func CheckHtpasswdUserPassword(username string, password string, htpasswdBytes [
 	if !ok {
 		return false, nil
 	}
-	// only understand bcrypt
-	if !strings.HasPrefix(hash, "$2y$") {
-		err := errors.New("Can only use bcrypt for htpasswd")
+	// only understand bcrypt and SHA-crypt
+	if isSHACryptHash(hash) {
+		if err := shaCryptCompareHashAndPassword(hash, []byte(password)); err != nil {
+			return false, nil
+		}
+		return true, nil
+	}
+	if !strings.HasPrefix(hash, "$2y$") && !strings.HasPrefix(hash, "$2a$") &&
+		!strings.HasPrefix(hash, "$2b$") {
+		err := errors.New("Can only use bcrypt or SHA-crypt for htpasswd")
 		return false, err
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
@@ -108,50 +118,81 @@ func CheckHtpasswdUserPassword(username string, password string, htpasswdBytes [
 
 }
 
-func getLDAPConnection(u url.URL, timeoutSecs uint, rootCAs *x509.CertPool) (*ldap.Conn, string, error) {
-	if u.Scheme != "ldaps" {
-		err := errors.New("Invalid ldap scheme (we only support ldaps")
+// getLDAPConnection returns a started connection to the server of u. For
+// ldap:// URLs the connection is upgraded with StartTLS, so that credentials
+// are never sent in the clear. If tlsConfig is nil, the system trust store is
+// used.
+func getLDAPConnection(u url.URL, timeoutSecs uint, tlsConfig *tls.Config) (*ldap.Conn, string, error) {
+	var port string
+	switch u.Scheme {
+	case "ldaps":
+		port = "636"
+	case "ldap":
+		port = "389"
+	default:
+		err := errors.New("Invalid ldap scheme (we only support ldaps and ldap with StartTLS)")
 		return nil, "", err
 	}
-	//hostnamePort := server + ":636"
 	serverPort := strings.Split(u.Host, ":")
-	port := "636"
 	if len(serverPort) == 2 {
 		port = serverPort[1]
 	}
 	server := serverPort[0]
 	hostnamePort := server + ":" + port
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = server
+	}
 
 	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
 	start := time.Now()
-	tlsConn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", hostnamePort,
-		&tls.Config{ServerName: server, RootCAs: rootCAs})
-	if err != nil {
-		errorTime := time.Since(start).Seconds() * 1000
-		log.Printf("connction failure for:%s (%s)(time(ms)=%v)", server, err.Error(), errorTime)
-		return nil, "", err
+	var conn *ldap.Conn
+	if u.Scheme == "ldaps" {
+		tlsConn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", hostnamePort,
+			tlsConfig)
+		if err != nil {
+			errorTime := time.Since(start).Seconds() * 1000
+			log.Printf("connction failure for:%s (%s)(time(ms)=%v)", server, err.Error(), errorTime)
+			return nil, "", err
+		}
+		// we dont close the tls connection directly  close defer to the new ldap connection
+		conn = ldap.NewConn(tlsConn, true)
+	} else {
+		rawConn, err := net.DialTimeout("tcp", hostnamePort, timeout)
+		if err != nil {
+			errorTime := time.Since(start).Seconds() * 1000
+			log.Printf("connction failure for:%s (%s)(time(ms)=%v)", server, err.Error(), errorTime)
+			return nil, "", err
+		}
+		conn = ldap.NewConn(rawConn, false)
+	}
+	conn.SetTimeout(timeout)
+	conn.Start()
+	if u.Scheme == "ldap" {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			log.Printf("StartTLS failure for:%s (%s)", server, err.Error())
+			return nil, "", err
+		}
 	}
-
-	// we dont close the tls connection directly  close defer to the new ldap connection
-	conn := ldap.NewConn(tlsConn, true)
 	return conn, server, nil
 }
 
-func CheckLDAPConnection(u url.URL, timeoutSecs uint, rootCAs *x509.CertPool) error {
-	conn, _, err := getLDAPConnection(u, timeoutSecs, rootCAs)
+func CheckLDAPConnection(u url.URL, timeoutSecs uint, tlsConfig *tls.Config) error {
+	conn, _, err := getLDAPConnection(u, timeoutSecs, tlsConfig)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
-	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
-	conn.SetTimeout(timeout)
-	conn.Start()
 	return nil
 }
 
-func CheckLDAPUserPassword(u url.URL, bindDN string, bindPassword string, timeoutSecs uint, rootCAs *x509.CertPool) (bool, error) {
-	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
-	conn, server, err := getLDAPConnection(u, timeoutSecs, rootCAs)
+func CheckLDAPUserPassword(u url.URL, bindDN string, bindPassword string, timeoutSecs uint, tlsConfig *tls.Config) (bool, error) {
+	conn, server, err := getLDAPConnection(u, timeoutSecs, tlsConfig)
 	if err != nil {
 		return false, err
 	}
@@ -159,8 +200,6 @@ func CheckLDAPUserPassword(u url.URL, bindDN string, bindPassword string, timeou
 
 	//connectionTime := time.Since(start).Seconds() * 1000
 
-	conn.SetTimeout(timeout)
-	conn.Start()
 	err = conn.Bind(bindDN, bindPassword)
 	if err != nil {
 		log.Printf("Bind failure for server:%s bindDN:'%s' (%s)", server, bindDN, err.Error())
@@ -172,16 +211,52 @@ func CheckLDAPUserPassword(u url.URL, bindDN string, bindPassword string, timeou
 	return true, nil
 }
 
+// ChangeLDAPUserPassword binds as bindDN with oldPassword and then changes
+// the password to newPassword with the Password Modify extended operation
+// (RFC 3062). It returns false and no error if oldPassword is not valid. Use
+// IsLDAPPasswordRejected to check if an error is due to the password policy
+// of the directory.
+func ChangeLDAPUserPassword(u url.URL, bindDN string, oldPassword string,
+	newPassword string, timeoutSecs uint, tlsConfig *tls.Config) (bool, error) {
+	conn, server, err := getLDAPConnection(u, timeoutSecs, tlsConfig)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	err = conn.Bind(bindDN, oldPassword)
+	if err != nil {
+		log.Printf("Bind failure for server:%s bindDN:'%s' (%s)", server, bindDN, err.Error())
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, err = conn.PasswordModify(
+		ldap.NewPasswordModifyRequest("", oldPassword, newPassword))
+	if err != nil {
+		log.Printf("Password modify failure for server:%s bindDN:'%s' (%s)", server, bindDN, err.Error())
+		return false, err
+	}
+	return true, nil
+}
+
+// IsLDAPPasswordRejected returns true if err means that the directory does
+// not accept the new password given to ChangeLDAPUserPassword.
+func IsLDAPPasswordRejected(err error) bool {
+	return ldap.IsErrorWithCode(err, ldap.LDAPResultConstraintViolation) ||
+		ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform)
+}
+
 func ParseLDAPURL(ldapUrl string) (*url.URL, error) {
 	u, err := url.Parse(ldapUrl)
 	if err != nil {
 		return nil, err
 	}
-	if u.Scheme != "ldaps" {
-		err := errors.New("Invalid ldap scheme (we only support ldaps")
+	if u.Scheme != "ldaps" && u.Scheme != "ldap" {
+		err := errors.New("Invalid ldap scheme (we only support ldaps and ldap with StartTLS)")
 		return nil, err
 	}
-	//extract port if any... and if NIL then set it to 636
+	//extract port if any... and if NIL then set it to 636 (389 for StartTLS)
 	return u, nil
 }
 
@@ -235,8 +310,7 @@ func getSimpleUserAttributes(conn *ldap.Conn, UserSearchBaseDNs []string,
 		}
 		return m, nil
 	}
-	err = errors.New("user not found or too many users found")
-	return nil, err
+	return nil, ErrLDAPUserNotFound
 }
 
 func extractCNFromDNString(input []string) (output []string, err error) {
@@ -292,19 +366,16 @@ func getUserGroupsRFC2307(conn *ldap.Conn, GroupSearchBaseDNs []string,
 }
 
 func GetLDAPUserGroups(u url.URL, bindDN string, bindPassword string,
-	timeoutSecs uint, rootCAs *x509.CertPool,
+	timeoutSecs uint, tlsConfig *tls.Config,
 	username string,
 	UserSearchBaseDNs []string, UserSearchFilter string,
 	GroupSearchBaseDNs []string, GroupSearchFilter string) ([]string, error) {
-	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
-	conn, _, err := getLDAPConnection(u, timeoutSecs, rootCAs)
+	conn, _, err := getLDAPConnection(u, timeoutSecs, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 
-	conn.SetTimeout(timeout)
-	conn.Start()
 	err = conn.Bind(bindDN, bindPassword)
 	if err != nil {
 		return nil, err
@@ -332,20 +403,17 @@ func GetLDAPUserGroups(u url.URL, bindDN string, bindPassword string,
 }
 
 func GetLDAPUserAttributes(u url.URL, bindDN string, bindPassword string,
-	timeoutSecs uint, rootCAs *x509.CertPool,
+	timeoutSecs uint, tlsConfig *tls.Config,
 	username string,
 	UserSearchBaseDNs []string, UserSearchFilter string,
 	attributes []string) (map[string][]string, error) {
 
-	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
-	conn, _, err := getLDAPConnection(u, timeoutSecs, rootCAs)
+	conn, _, err := getLDAPConnection(u, timeoutSecs, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 
-	conn.SetTimeout(timeout)
-	conn.Start()
 	err = conn.Bind(bindDN, bindPassword)
 	if err != nil {
 		return nil, err
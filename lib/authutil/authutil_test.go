@@ -3,6 +3,7 @@ package authutil
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"log"
 	"net"
 	"net/url"
@@ -116,6 +117,10 @@ const userdbContent = `username:$2y$05$D4qQmZbWYqfgtGtez2EGdOkcNne40EdEznOqMvZeg
 // This DB has user 'username' with password 'password'
 const aprUserDBContent = `username:$apr1$9gzRPctr$.5JlM3HCKcMbiwDEuvsB40`
 
+// This DB has users 'sha512user' and 'sha256user' with password 'password'
+const shaCryptUserDBContent = `sha512user:$6$GbQhnXLyTH0P7jZe$Y1XdPeAba2GCNxIGFsLWIgqAYi.bKK8vZFnoYLsg78XzyycbSo85yHfBL9MveAMnq3BKO2zmrsPgNM.qu.ZET/
+sha256user:$5$w9ChjNxK7wGOeO8c$IPJsYJ0.QzazD81AKcqjyP7I5xZQV0J7oG8dHQRXIU6`
+
 // getTLSconfig returns a tls configuration used
 // to build a TLSlistener for TLS or StartTLS
 func getTLSconfig() (*tls.Config, error) {
@@ -148,6 +153,19 @@ func handleBind(w ldap.ResponseWriter, m *ldap.Message) {
 	w.Write(res)
 }
 
+func handleStartTLS(w ldap.ResponseWriter, m *ldap.Message) {
+	config, _ := getTLSconfig()
+	tlsConn := tls.Server(m.Client.GetConn(), config)
+	res := ldap.NewExtendedResponse(ldap.LDAPResultSuccess)
+	res.SetResponseName(ldap.NoticeOfStartTLS)
+	w.Write(res)
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("StartTLS Handshake error %v", err)
+		return
+	}
+	m.Client.SetConn(tlsConn)
+}
+
 func handleSearchGroup(w ldap.ResponseWriter, m *ldap.Message) {
 	r := m.GetSearchRequest()
 	log.Printf("Request BaseDn=%s", r.BaseObject())
@@ -221,6 +239,15 @@ func init() {
 	}
 	go server.ListenAndServe("127.0.0.1:10636", secureConn)
 
+	//and a plain listener which only continues with StartTLS
+	startTLSRoutes := ldap.NewRouteMux()
+	startTLSRoutes.Extended(handleStartTLS).
+		RequestName(ldap.NoticeOfStartTLS).Label("StartTLS")
+	startTLSRoutes.Bind(handleBind)
+	startTLSServer := ldap.NewServer()
+	startTLSServer.Handle(startTLSRoutes)
+	go startTLSServer.ListenAndServe("127.0.0.1:10389")
+
 	//we also make a simple tls listener
 	//
 	config, _ := getTLSconfig()
@@ -279,6 +306,25 @@ func TestCheckHtpasswdUserPasswordFailInvalidPasswordFileContent(t *testing.T) {
 	}
 }
 
+func TestCheckHtpasswdUserPasswordSHACryptSuccess(t *testing.T) {
+	for _, username := range []string{"sha512user", "sha256user"} {
+		ok, err := CheckHtpasswdUserPassword(username, "password", []byte(shaCryptUserDBContent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != true {
+			t.Fatalf("%s considered false", username)
+		}
+		ok, err = CheckHtpasswdUserPassword(username, "Incorrectpassword", []byte(shaCryptUserDBContent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != false {
+			t.Fatalf("%s logged in with bad password", username)
+		}
+	}
+}
+
 func TestCheckHtpasswdUserPasswordFailInvalidNonBcryptHashes(t *testing.T) {
 	_, err := CheckHtpasswdUserPassword("username", "password", []byte(aprUserDBContent))
 	if err == nil {
@@ -293,14 +339,19 @@ func TestParseLDAPURLSuccess(t *testing.T) {
 	}
 }
 
-func TestParseLDAPURLFail(t *testing.T) {
-
-	_, err := ParseLDAPURL(testLdapURL)
-	if err == nil {
-		t.Logf("Failed to fail '%s'", testLdapURL)
+func TestParseLDAPURLStartTLSSuccess(t *testing.T) {
+	u, err := ParseLDAPURL(testLdapURL)
+	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = ParseLDAPURL(testHttpURL)
+	if u.Scheme != "ldap" {
+		t.Fatalf("unexpected scheme: %s", u.Scheme)
+	}
+}
+
+func TestParseLDAPURLFail(t *testing.T) {
+
+	_, err := ParseLDAPURL(testHttpURL)
 	if err == nil {
 		t.Logf("Failed to fail '%s'", testHttpURL)
 		t.Fatal(err)
@@ -318,7 +369,7 @@ func TestCheckLDAPConnectionSuccess(t *testing.T) {
 		t.Logf("Failed to parse url")
 		t.Fatal(err)
 	}
-	err = CheckLDAPConnection(*ldapURL, 2, certPool)
+	err = CheckLDAPConnection(*ldapURL, 2, &tls.Config{RootCAs: certPool})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -335,7 +386,7 @@ func TestCheckLDAPUserPasswordSuccess(t *testing.T) {
 		t.Logf("Failed to parse url")
 		t.Fatal(err)
 	}
-	ok, err = CheckLDAPUserPassword(*ldapURL, "username", "password", 2, certPool)
+	ok, err = CheckLDAPUserPassword(*ldapURL, "username", "password", 2, &tls.Config{RootCAs: certPool})
 	if err != nil {
 		t.Logf("Connect to server")
 		t.Fatal(err)
@@ -356,7 +407,7 @@ func TestCheckLDAPGetLDAPUserGroupsSuccess(t *testing.T) {
 		t.Logf("Failed to parse url")
 		t.Fatal(err)
 	}
-	userGroups, err := GetLDAPUserGroups(*ldapURL, "username", "password", 2, certPool, "username-to-search",
+	userGroups, err := GetLDAPUserGroups(*ldapURL, "username", "password", 2, &tls.Config{RootCAs: certPool}, "username-to-search",
 		[]string{"some user endpoint"}, "(uid=%s)",
 		[]string{"o=group,o=My Company,c=US"}, "(member=%s)")
 	if err != nil {
@@ -377,6 +428,79 @@ func TestCheckLDAPGetLDAPUserGroupsSuccess(t *testing.T) {
 	}
 }
 
+func TestCheckLDAPUserPasswordStartTLSSuccess(t *testing.T) {
+	certPool := x509.NewCertPool()
+	ok := certPool.AppendCertsFromPEM([]byte(rootCAPem))
+	if !ok {
+		t.Fatal("cannot add certs to certpool")
+	}
+	ldapURL, err := ParseLDAPURL("ldap://localhost:10389")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err = CheckLDAPUserPassword(*ldapURL, "username", "password", 2, &tls.Config{RootCAs: certPool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != true {
+		t.Fatal("userame not accepted")
+	}
+}
+
+func TestCheckLDAPUserPasswordStartTLSFailUntrustedHost(t *testing.T) {
+	ldapURL, err := ParseLDAPURL("ldap://localhost:10389")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CheckLDAPUserPassword(*ldapURL, "username", "password", 2, nil)
+	if err == nil {
+		t.Fatal("Should have borked on untrusted Host")
+	}
+}
+
+func TestCheckLDAPConnectionPinnedPublicKey(t *testing.T) {
+	certPool := x509.NewCertPool()
+	ok := certPool.AppendCertsFromPEM([]byte(rootCAPem))
+	if !ok {
+		t.Fatal("cannot add certs to certpool")
+	}
+	cert, err := tls.X509KeyPair([]byte(localhostCertPem), []byte(localhostKeyPem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldapURL, err := ParseLDAPURL("ldaps://localhost:10636")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := NewLDAPTLSConfig(certPool, []string{GetPublicKeyPin(leaf)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckLDAPConnection(*ldapURL, 2, tlsConfig); err != nil {
+		t.Fatal(err)
+	}
+	otherPin := "sha256//" + base64.StdEncoding.EncodeToString(make([]byte, 32))
+	tlsConfig, err = NewLDAPTLSConfig(certPool, []string{otherPin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckLDAPConnection(*ldapURL, 2, tlsConfig); err == nil {
+		t.Fatal("Should have borked on unpinned public key")
+	}
+}
+
+func TestNewLDAPTLSConfigFailBadPin(t *testing.T) {
+	for _, pin := range []string{"sha1//AAAA", "sha256//AAAA", "sha256//!!"} {
+		if _, err := NewLDAPTLSConfig(nil, []string{pin}); err == nil {
+			t.Errorf("no error for pin: %s", pin)
+		}
+	}
+}
+
 func TestCheckLDAPUserPasswordFailUntrustedHost(t *testing.T) {
 	ldapURL, err := ParseLDAPURL("ldaps://localhost:10636")
 	if err != nil {
@@ -400,7 +524,7 @@ func TestCheckLDAPUserPasswordFailInvalidUser(t *testing.T) {
 		t.Logf("Failed to parse url")
 		t.Fatal(err)
 	}
-	ok, err = CheckLDAPUserPassword(*ldapURL, "InvalidUsername", "password", 2, certPool)
+	ok, err = CheckLDAPUserPassword(*ldapURL, "InvalidUsername", "password", 2, &tls.Config{RootCAs: certPool})
 	if err != nil {
 		t.Logf("Connect to server")
 		t.Fatal(err)
@@ -422,7 +546,7 @@ func TestCheckLDAPUserPasswordFailNonLDAPEndpoint(t *testing.T) {
 		t.Fatal(err)
 	}
 	// TODO: actually check the returned value
-	_, err = CheckLDAPUserPassword(*ldapURL, "username", "password", 2, certPool)
+	_, err = CheckLDAPUserPassword(*ldapURL, "username", "password", 2, &tls.Config{RootCAs: certPool})
 	if err == nil {
 		//t.Logf("Connect to server")
 		//t.Fatal(err)
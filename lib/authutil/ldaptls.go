@@ -0,0 +1,64 @@
+package authutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const publicKeyPinPrefix = "sha256//"
+
+// GetPublicKeyPin returns the pin of the public key of cert, in the
+// "sha256//<base64 SHA-256 of the SubjectPublicKeyInfo>" format also used by
+// curl --pinnedpubkey.
+func GetPublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return publicKeyPinPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// NewLDAPTLSConfig returns a TLS configuration for connecting to LDAP
+// servers. If rootCAs is nil, the system trust store is used. If
+// pinnedPublicKeys is not empty, the verified chain of the server must also
+// contain a certificate with one of these public key pins.
+func NewLDAPTLSConfig(rootCAs *x509.CertPool,
+	pinnedPublicKeys []string) (*tls.Config, error) {
+	config := &tls.Config{
+		RootCAs:    rootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(pinnedPublicKeys) < 1 {
+		return config, nil
+	}
+	pins := make(map[string]struct{}, len(pinnedPublicKeys))
+	for _, pin := range pinnedPublicKeys {
+		if !strings.HasPrefix(pin, publicKeyPinPrefix) {
+			return nil, fmt.Errorf("public key pin: %s does not start with %s",
+				pin, publicKeyPinPrefix)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(
+			pin[len(publicKeyPinPrefix):])
+		if err != nil {
+			return nil, fmt.Errorf("bad public key pin: %s: %s", pin, err)
+		}
+		if len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("bad public key pin length: %s", pin)
+		}
+		pins[pin] = struct{}{}
+	}
+	config.VerifyPeerCertificate = func(rawCerts [][]byte,
+		verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				if _, ok := pins[GetPublicKeyPin(cert)]; ok {
+					return nil
+				}
+			}
+		}
+		return errors.New("no pinned public key in the LDAP server chain")
+	}
+	return config, nil
+}
@@ -0,0 +1,204 @@
+package authutil
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt, as used by glibc and htpasswd -2/-5, is specified at
+// https://www.akkadia.org/drepper/SHA-crypt.txt
+
+const (
+	shaCryptB64Alphabet   = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	shaCryptDefaultRounds = 5000
+	shaCryptMaxRounds     = 999999999
+	shaCryptMaxSaltLength = 16
+	shaCryptMinRounds     = 1000
+	shaCryptRoundsPrefix  = "rounds="
+)
+
+type shaCryptVariant struct {
+	prefix  string
+	newHash func() hash.Hash
+	// Groups of 3 digest bytes, each encoded as 4 characters. The last group
+	// is shorter, and its first index may be -1 for a zero byte.
+	encodeOrder [][]int
+}
+
+var (
+	sha256CryptVariant = shaCryptVariant{
+		prefix:  "$5$",
+		newHash: sha256.New,
+		encodeOrder: [][]int{{0, 10, 20}, {21, 1, 11}, {12, 22, 2},
+			{3, 13, 23}, {24, 4, 14}, {15, 25, 5}, {6, 16, 26}, {27, 7, 17},
+			{18, 28, 8}, {9, 19, 29}, {-1, 31, 30}},
+	}
+	sha512CryptVariant = shaCryptVariant{
+		prefix:  "$6$",
+		newHash: sha512.New,
+		encodeOrder: [][]int{{0, 21, 42}, {22, 43, 1}, {44, 2, 23},
+			{3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48}, {28, 49, 7},
+			{50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32},
+			{12, 33, 54}, {34, 55, 13}, {56, 14, 35}, {15, 36, 57},
+			{37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+			{62, 20, 41}, {-1, -1, 63}},
+	}
+)
+
+func isSHACryptHash(hash string) bool {
+	return strings.HasPrefix(hash, sha256CryptVariant.prefix) ||
+		strings.HasPrefix(hash, sha512CryptVariant.prefix)
+}
+
+// shaCryptCompareHashAndPassword returns nil if password matches the
+// SHA-256-crypt or SHA-512-crypt hash.
+func shaCryptCompareHashAndPassword(hash string, password []byte) error {
+	var variant shaCryptVariant
+	switch {
+	case strings.HasPrefix(hash, sha256CryptVariant.prefix):
+		variant = sha256CryptVariant
+	case strings.HasPrefix(hash, sha512CryptVariant.prefix):
+		variant = sha512CryptVariant
+	default:
+		return errors.New("not a SHA-crypt hash")
+	}
+	fields := strings.Split(hash[len(variant.prefix):], "$")
+	rounds := shaCryptDefaultRounds
+	customRounds := false
+	if len(fields) == 3 && strings.HasPrefix(fields[0], shaCryptRoundsPrefix) {
+		value, err := strconv.ParseUint(
+			fields[0][len(shaCryptRoundsPrefix):], 10, 32)
+		if err != nil {
+			return errors.New("invalid SHA-crypt rounds")
+		}
+		rounds = int(value)
+		if rounds < shaCryptMinRounds {
+			rounds = shaCryptMinRounds
+		} else if rounds > shaCryptMaxRounds {
+			rounds = shaCryptMaxRounds
+		}
+		customRounds = true
+		fields = fields[1:]
+	}
+	if len(fields) != 2 {
+		return errors.New("invalid SHA-crypt hash")
+	}
+	salt := fields[0]
+	if len(salt) > shaCryptMaxSaltLength {
+		salt = salt[:shaCryptMaxSaltLength]
+	}
+	computed := variant.crypt(password, []byte(salt), rounds, customRounds)
+	if subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) != 1 {
+		return errors.New("invalid password")
+	}
+	return nil
+}
+
+func (variant shaCryptVariant) crypt(password, salt []byte, rounds int,
+	customRounds bool) string {
+	h := variant.newHash()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	digestB := h.Sum(nil)
+
+	h.Reset()
+	h.Write(password)
+	h.Write(salt)
+	writeRepeated(h, digestB, len(password))
+	for length := len(password); length > 0; length >>= 1 {
+		if length&1 != 0 {
+			h.Write(digestB)
+		} else {
+			h.Write(password)
+		}
+	}
+	digestA := h.Sum(nil)
+
+	h.Reset()
+	for i := 0; i < len(password); i++ {
+		h.Write(password)
+	}
+	sequenceP := repeatToLength(h.Sum(nil), len(password))
+
+	h.Reset()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		h.Write(salt)
+	}
+	sequenceS := repeatToLength(h.Sum(nil), len(salt))
+
+	digestC := digestA
+	for round := 0; round < rounds; round++ {
+		h.Reset()
+		if round&1 != 0 {
+			h.Write(sequenceP)
+		} else {
+			h.Write(digestC)
+		}
+		if round%3 != 0 {
+			h.Write(sequenceS)
+		}
+		if round%7 != 0 {
+			h.Write(sequenceP)
+		}
+		if round&1 != 0 {
+			h.Write(digestC)
+		} else {
+			h.Write(sequenceP)
+		}
+		digestC = h.Sum(nil)
+	}
+
+	var builder strings.Builder
+	builder.WriteString(variant.prefix)
+	if customRounds {
+		builder.WriteString(shaCryptRoundsPrefix)
+		builder.WriteString(strconv.Itoa(rounds))
+		builder.WriteString("$")
+	}
+	builder.Write(salt)
+	builder.WriteString("$")
+	for index, group := range variant.encodeOrder {
+		var value uint
+		for _, digestIndex := range group {
+			value <<= 8
+			if digestIndex >= 0 {
+				value |= uint(digestC[digestIndex])
+			}
+		}
+		numChars := 4
+		if index == len(variant.encodeOrder)-1 {
+			numChars = (len(digestC)%3)*4/3 + 1
+		}
+		for i := 0; i < numChars; i++ {
+			builder.WriteByte(shaCryptB64Alphabet[value&0x3f])
+			value >>= 6
+		}
+	}
+	return builder.String()
+}
+
+// writeRepeated writes length bytes of data, repeated as needed, to h.
+func writeRepeated(h hash.Hash, data []byte, length int) {
+	for ; length > len(data); length -= len(data) {
+		h.Write(data)
+	}
+	h.Write(data[:length])
+}
+
+func repeatToLength(data []byte, length int) []byte {
+	result := make([]byte, 0, length)
+	for len(result) < length {
+		remaining := length - len(result)
+		if remaining > len(data) {
+			remaining = len(data)
+		}
+		result = append(result, data[:remaining]...)
+	}
+	return result
+}
@@ -0,0 +1,46 @@
+package authutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSHACryptCompareHashAndPassword(t *testing.T) {
+	tests := []struct {
+		hash     string
+		password string
+	}{
+		// From the specification.
+		{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+			"Hello world!"},
+		{"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+			"Hello world!"},
+		{"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA",
+			"Hello world!"},
+		{"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.",
+			"Hello world!"},
+		{"$6$rounds=1000$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX.",
+			"the minimum number is still observed"},
+		// From glibc crypt(3).
+		{"$5$rounds=1234$longsaltlongsalt$.zvwzDwbmeg38b1YxqhMCDGQ9l1MroaY4Kuy1GXfR41",
+			strings.Repeat("x", 200)},
+		{"$6$rounds=77777$short$8cA9/gVoX5WuVrB5SNfz8QVWm1iFkQ/5Lkqbvny7PtIyG9dz3SPVOqbrjfy39nBy8SEAQNcslGv7Buuks7d5K/",
+			""},
+	}
+	for _, test := range tests {
+		if err := shaCryptCompareHashAndPassword(test.hash,
+			[]byte(test.password)); err != nil {
+			t.Errorf("%s: %s", test.hash, err)
+		}
+		if err := shaCryptCompareHashAndPassword(test.hash,
+			[]byte(test.password+"x")); err == nil {
+			t.Errorf("%s: accepted bad password", test.hash)
+		}
+	}
+	for _, hash := range []string{"$6$", "$6$salt", "$6$rounds=x$salt$hash",
+		"$1$salt$hash"} {
+		if err := shaCryptCompareHashAndPassword(hash, nil); err == nil {
+			t.Errorf("%s: accepted invalid hash", hash)
+		}
+	}
+}
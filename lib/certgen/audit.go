@@ -0,0 +1,78 @@
+package certgen
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuditIDOID identifies the extension carrying the audit ID of an X.509
+// certificate. The value is a DER encoded UTF8String.
+var AuditIDOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 9586, 100, 8, 1}
+
+const auditIDLength = 6
+
+// NewAuditID returns a short random identifier which links a certificate to
+// the record of its issuance.
+func NewAuditID() (string, error) {
+	buf := make([]byte, auditIDLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// GenSSHCertFileStringWithAuditID is like GenSSHCertFileString, but appends
+// auditID to the key ID so that it shows up in the sshd logs of the hosts
+// the certificate is used on.
+func GenSSHCertFileStringWithAuditID(username string, userPubKey string,
+	signer ssh.Signer, hostIdentity string, auditID string,
+	duration time.Duration) (string, ssh.Certificate, error) {
+	return GenSSHCertFileStringForIdentity(UserIdentity{Username: username},
+		userPubKey, signer, hostIdentity, auditID, duration)
+}
+
+// GenUserX509CertWithAuditID is like GenUserX509Cert, but adds auditID in a
+// non-critical extension.
+func GenUserX509CertWithAuditID(userName string, userPub interface{},
+	caCert *x509.Certificate, caPriv crypto.Signer,
+	kerberosRealm *string, duration time.Duration,
+	groups []string, organizations []string, auditID string) ([]byte, error) {
+	return GenUserX509CertForIdentity(UserIdentity{Username: userName},
+		userPub, caCert, caPriv, kerberosRealm, duration, groups,
+		organizations, auditID)
+}
+
+func getAuditIDExtensions(auditID string) ([]pkix.Extension, error) {
+	if auditID == "" {
+		return nil, nil
+	}
+	encodedValue, err := asn1.MarshalWithParams(auditID, "utf8")
+	if err != nil {
+		return nil, err
+	}
+	return []pkix.Extension{{Id: AuditIDOID, Value: encodedValue}}, nil
+}
+
+// GetX509AuditID returns the audit ID of cert, or the empty string if it has
+// none.
+func GetX509AuditID(cert *x509.Certificate) string {
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(AuditIDOID) {
+			continue
+		}
+		var auditID string
+		if _, err := asn1.UnmarshalWithParams(extension.Value, &auditID,
+			"utf8"); err != nil {
+			return ""
+		}
+		return auditID
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package certgen
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNewAuditID(t *testing.T) {
+	auditID, err := NewAuditID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auditID) != auditIDLength*2 {
+		t.Fatalf("unexpected audit ID length: %s", auditID)
+	}
+	otherAuditID, err := NewAuditID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auditID == otherAuditID {
+		t.Fatal("audit IDs are not unique")
+	}
+}
+
+func TestGenSSHCertFileStringWithAuditID(t *testing.T) {
+	signer, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cert, err := GenSSHCertFileStringWithAuditID("foo", testUserPublicKey,
+		signer, "bar", "0123456789ab", testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.KeyId != "bar_foo_0123456789ab" {
+		t.Fatalf("unexpected key ID: %s", cert.KeyId)
+	}
+	_, cert, err = GenSSHCertFileStringWithAuditID("foo", testUserPublicKey,
+		signer, "bar", "", testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.KeyId != "bar_foo" {
+		t.Fatalf("unexpected key ID: %s", cert.KeyId)
+	}
+}
+
+func TestGenUserX509CertWithAuditID(t *testing.T) {
+	userPub, caCert, caPriv := setupX509Generator(t)
+	derCert, err := GenUserX509CertWithAuditID("username", userPub, caCert,
+		caPriv, nil, testDuration, nil, nil, "0123456789ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auditID := GetX509AuditID(cert); auditID != "0123456789ab" {
+		t.Fatalf("unexpected audit ID: %s", auditID)
+	}
+	derCert, err = GenUserX509Cert("username", userPub, caCert, caPriv, nil,
+		testDuration, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auditID := GetX509AuditID(cert); auditID != "" {
+		t.Fatalf("unexpected audit ID: %s", auditID)
+	}
+}
+
+func TestGenSSHHostCertFileStringWithAuditID(t *testing.T) {
+	signer, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cert, err := GenSSHHostCertFileStringWithAuditID(testUserPublicKey,
+		signer, "bar", []string{"host.example.com"}, "0123456789ab",
+		testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.KeyId != "bar_host.example.com_0123456789ab" {
+		t.Fatalf("unexpected key ID: %s", cert.KeyId)
+	}
+}
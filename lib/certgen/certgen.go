@@ -6,6 +6,7 @@ package certgen
 import (
 	"bytes"
 	"crypto"
+	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rand"
@@ -46,48 +47,74 @@ func goCertToFileString(c ssh.Certificate, username string) (string, error) {
 
 // gen_user_cert a username and key, returns a short lived cert for that user
 func GenSSHCertFileString(username string, userPubKey string, signer ssh.Signer, host_identity string, duration time.Duration) (certString string, cert ssh.Certificate, err error) {
-	userKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(userPubKey))
+	return genSSHCertFileString(username, []string{username}, nil, nil,
+		userPubKey, signer, host_identity+"_"+username, duration)
+}
+
+func genSSHCertFileString(username string, principals []string,
+	extensions, criticalOptions map[string]string, userPubKey string,
+	signer ssh.Signer, keyIdentity string, duration time.Duration) (
+	certString string, cert ssh.Certificate, err error) {
+	cert, err = newSSHUserCert(principals, extensions, criticalOptions,
+		userPubKey, signer.PublicKey(), keyIdentity, duration)
+	if err != nil {
+		return "", cert, err
+	}
+	err = cert.SignCert(bytes.NewReader(cert.Marshal()), signer)
+	if err != nil {
+		return "", cert, err
+	}
+	certString, err = goCertToFileString(cert, username)
 	if err != nil {
 		return "", cert, err
 	}
-	keyIdentity := host_identity + "_" + username
+	return certString, cert, nil
+}
+
+// newSSHUserCert returns the unsigned user certificate for userPubKey.
+// The extensions are added to the default permissions.
+func newSSHUserCert(principals []string,
+	extensions, criticalOptions map[string]string, userPubKey string,
+	signatureKey ssh.PublicKey, keyIdentity string,
+	duration time.Duration) (ssh.Certificate, error) {
+	userKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(userPubKey))
+	if err != nil {
+		return ssh.Certificate{}, err
+	}
 
 	currentEpoch := uint64(time.Now().Unix())
 	expireEpoch := currentEpoch + uint64(duration.Seconds())
 
 	nBig, err := rand.Int(rand.Reader, big.NewInt(0xFFFFFFFF))
 	if err != nil {
-		return "", cert, err
+		return ssh.Certificate{}, err
 	}
 	serial := (currentEpoch << 32) | nBig.Uint64()
 
 	// The values of the permissions are taken from the default values used
 	// by ssh-keygen
-	cert = ssh.Certificate{
+	permissions := map[string]string{
+		"permit-X11-forwarding":   "",
+		"permit-agent-forwarding": "",
+		"permit-port-forwarding":  "",
+		"permit-pty":              "",
+		"permit-user-rc":          ""}
+	for name, value := range extensions {
+		permissions[name] = value
+	}
+	return ssh.Certificate{
 		Key:             userKey,
 		CertType:        ssh.UserCert,
-		SignatureKey:    signer.PublicKey(),
-		ValidPrincipals: []string{username},
+		SignatureKey:    signatureKey,
+		ValidPrincipals: principals,
 		KeyId:           keyIdentity,
 		ValidAfter:      currentEpoch,
 		ValidBefore:     expireEpoch,
 		Serial:          serial,
-		Permissions: ssh.Permissions{Extensions: map[string]string{
-			"permit-X11-forwarding":   "",
-			"permit-agent-forwarding": "",
-			"permit-port-forwarding":  "",
-			"permit-pty":              "",
-			"permit-user-rc":          ""}}}
-
-	err = cert.SignCert(bytes.NewReader(cert.Marshal()), signer)
-	if err != nil {
-		return "", cert, err
-	}
-	certString, err = goCertToFileString(cert, username)
-	if err != nil {
-		return "", cert, err
-	}
-	return certString, cert, nil
+		Permissions: ssh.Permissions{
+			CriticalOptions: criticalOptions,
+			Extensions:      permissions,
+		}}, nil
 }
 
 func GenSSHCertFileStringFromSSSDPublicKey(userName string, signer ssh.Signer, hostIdentity string, duration time.Duration) (certString string, cert ssh.Certificate, err error) {
@@ -175,31 +202,49 @@ func publicKey(priv interface{}) interface{} {
 	}
 }
 
+// DefaultMinRSAKeyBits is the smallest RSA key size accepted by default.
+const DefaultMinRSAKeyBits = 2048
+
 // ValidatePublicKeyStrenght checks if the "strength" of the key is good enough to be considered secure
 // At this moment it checks for sizes of parameters only. For RSA it means bits>=2041 && exponent>=65537,
 // For EC curves it means bitsize>=256. ec25519 is considered secure. All other public keys are not
 // considered secure.
 func ValidatePublicKeyStrength(pub interface{}) (bool, error) {
+	return CheckPublicKeyStrength(pub, DefaultMinRSAKeyBits) == nil, nil
+}
+
+// CheckPublicKeyStrength returns an error describing why pub is too weak.
+// RSA keys must have a modulus of at least minRSABits bits, rounded to bytes
+// (DefaultMinRSAKeyBits if zero), and an exponent of at least 65537. ECDSA
+// keys must be on a curve of at least 256 bits. DSA keys are refused.
+func CheckPublicKeyStrength(pub interface{}, minRSABits int) error {
+	if minRSABits < 1 {
+		minRSABits = DefaultMinRSAKeyBits
+	}
 	switch k := pub.(type) {
 	case *rsa.PublicKey:
-		if k.Size() < 256 { //ksize is in bytes
-			return false, nil
+		if k.Size()*8 < minRSABits {
+			return fmt.Errorf(
+				"RSA key of %d bits is smaller than the minimum of %d bits",
+				k.N.BitLen(), minRSABits)
 		}
-
 		if k.E < 65537 {
-			return false, nil
+			return fmt.Errorf("RSA key exponent %d is smaller than 65537", k.E)
 		}
-		return true, nil
+		return nil
 	case *ecdsa.PublicKey:
-		// TODO: check for the actual curves used
-		if k.Curve.Params().BitSize < 255 {
-			return false, nil
+		if bitSize := k.Curve.Params().BitSize; bitSize < 255 {
+			return fmt.Errorf(
+				"ECDSA key on a %d bit curve is smaller than 256 bits",
+				bitSize)
 		}
-		return true, nil
+		return nil
 	case *ed25519.PublicKey, ed25519.PublicKey:
-		return true, nil
+		return nil
+	case *dsa.PublicKey:
+		return errors.New("DSA keys are not accepted")
 	default:
-		return false, nil
+		return fmt.Errorf("unsupported key type: %T", pub)
 	}
 }
 
@@ -217,6 +262,19 @@ func derBytesCertToCertAndPem(derBytes []byte) (*x509.Certificate, string, error
 // return both an internal representation an the pem representation of the string
 // As long as the issuer value matches THEN the serial number can be different every time
 func GenSelfSignedCACert(commonName string, organization string, caPriv crypto.Signer) ([]byte, error) {
+	return GenSelfSignedCACertWithAlgorithm(commonName, organization, caPriv,
+		x509.UnknownSignatureAlgorithm)
+}
+
+// GenSelfSignedCACertWithAlgorithm is like GenSelfSignedCACert, but signs
+// with algorithm (x509.UnknownSignatureAlgorithm selects the default for the
+// key). Certificates issued by the CA are signed with the same algorithm.
+func GenSelfSignedCACertWithAlgorithm(commonName string, organization string,
+	caPriv crypto.Signer, algorithm x509.SignatureAlgorithm) ([]byte, error) {
+	err := CheckX509SignatureAlgorithm(algorithm, publicKey(caPriv))
+	if err != nil {
+		return nil, err
+	}
 	//// Now do the actual work...
 	notBefore := time.Now()
 	notAfter := notBefore.Add(24 * 365 * 8 * time.Hour)
@@ -242,10 +300,11 @@ func GenSelfSignedCACert(commonName string, organization string, caPriv crypto.S
 		},
 		NotBefore: notBefore,
 		NotAfter:  notAfter,
-		KeyUsage:  x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		KeyUsage:  x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		//ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 		IsCA:                  true,
+		SignatureAlgorithm:    algorithm,
 	}
 
 	return x509.CreateCertificate(rand.Reader, &template, &template, publicKey(caPriv), caPriv)
@@ -268,6 +327,14 @@ type PKInitSANAnotherName struct {
 	Value KRB5PrincipalName `asn1:"explicit,tag:0"`
 }
 
+// The Microsoft user principal name, used for smart card logon.
+type upnSANOtherName struct {
+	Id    asn1.ObjectIdentifier
+	Value string `asn1:"utf8,explicit,tag:0"`
+}
+
+var upnOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+
 // Since currently asn1 cannot mashal into GeneralString (https://github.com/golang/go/issues/18832)
 // We make this hack since we know the positions of the items we want to change
 func changePrintableStringToGeneralString(kerberosRealm string, inString []byte) []byte {
@@ -280,32 +347,69 @@ func changePrintableStringToGeneralString(kerberosRealm string, inString []byte)
 	return inString
 }
 
-func genSANExtension(userName string, kerberosRealm *string) (*pkix.Extension, error) {
-	if kerberosRealm == nil {
+// genSANExtension returns the subject alternative names of identity. If
+// there are no other names (Kerberos or UPN principals), which the x509
+// package cannot encode, nil is returned and the names must be set in the
+// template instead.
+func genSANExtension(identity UserIdentity, kerberosRealm *string) (
+	*pkix.Extension, error) {
+	// inspired by marshalSANs in x509.go
+	var rawValues []asn1.RawValue
+	if kerberosRealm != nil && !identity.OmitKerberosPrincipal {
+		krbRealm := *kerberosRealm
+
+		//1.3.6.1.5.2.2
+		krbSanAnotherName := PKInitSANAnotherName{
+			Id: []int{1, 3, 6, 1, 5, 2, 2},
+			Value: KRB5PrincipalName{
+				Realm: krbRealm,
+				Principal: KerberosPrincipal{Len: 1,
+					Principal: []string{identity.getKerberosPrincipal()}},
+			},
+		}
+		krbSanAnotherNameDer, err := asn1.Marshal(krbSanAnotherName)
+		if err != nil {
+			return nil, err
+		}
+		//fmt.Printf("ext: %+x\n", krbSanAnotherNameDer)
+		krbSanAnotherNameDer = changePrintableStringToGeneralString(krbRealm, krbSanAnotherNameDer)
+		krbSanAnotherNameDer[0] = 0xA0
+		//fmt.Printf("ext: %+x\n", krbSanAnotherNameDer)
+		rawValues = append(rawValues,
+			asn1.RawValue{FullBytes: krbSanAnotherNameDer})
+	}
+	if identity.UserPrincipalName != "" {
+		upnDer, err := asn1.Marshal(upnSANOtherName{
+			Id:    upnOID,
+			Value: identity.UserPrincipalName,
+		})
+		if err != nil {
+			return nil, err
+		}
+		upnDer[0] = 0xA0 // otherName [0]
+		rawValues = append(rawValues, asn1.RawValue{FullBytes: upnDer})
+	}
+	if len(rawValues) < 1 {
 		return nil, nil
 	}
-	krbRealm := *kerberosRealm
-
-	//1.3.6.1.5.2.2
-	krbSanAnotherName := PKInitSANAnotherName{
-		Id: []int{1, 3, 6, 1, 5, 2, 2},
-		Value: KRB5PrincipalName{
-			Realm:     krbRealm,
-			Principal: KerberosPrincipal{Len: 1, Principal: []string{userName}},
-		},
+	for _, emailAddress := range identity.EmailAddresses {
+		// rfc822Name [1] IA5String
+		rawValues = append(rawValues, asn1.RawValue{
+			Tag: 1, Class: asn1.ClassContextSpecific,
+			Bytes: []byte(emailAddress)})
 	}
-	krbSanAnotherNameDer, err := asn1.Marshal(krbSanAnotherName)
-	if err != nil {
-		return nil, err
+	for _, dnsName := range identity.DNSNames {
+		// dNSName [2] IA5String
+		rawValues = append(rawValues, asn1.RawValue{
+			Tag: 2, Class: asn1.ClassContextSpecific,
+			Bytes: []byte(dnsName)})
+	}
+	for _, uri := range identity.URIs {
+		// uniformResourceIdentifier [6] IA5String
+		rawValues = append(rawValues, asn1.RawValue{
+			Tag: 6, Class: asn1.ClassContextSpecific,
+			Bytes: []byte(uri.String())})
 	}
-	//fmt.Printf("ext: %+x\n", krbSanAnotherNameDer)
-	krbSanAnotherNameDer = changePrintableStringToGeneralString(krbRealm, krbSanAnotherNameDer)
-	krbSanAnotherNameDer[0] = 0xA0
-	//fmt.Printf("ext: %+x\n", krbSanAnotherNameDer)
-
-	// inspired by marshalSANs in x509.go
-	var rawValues []asn1.RawValue
-	rawValues = append(rawValues, asn1.RawValue{FullBytes: krbSanAnotherNameDer})
 
 	rawSan, err := asn1.Marshal(rawValues)
 	if err != nil {
@@ -343,6 +447,30 @@ func GenUserX509Cert(userName string, userPub interface{},
 	caCert *x509.Certificate, caPriv crypto.Signer,
 	kerberosRealm *string, duration time.Duration,
 	groups []string, organizations []string) ([]byte, error) {
+	return genUserX509Cert(UserIdentity{Username: userName}, userPub, caCert,
+		caPriv, kerberosRealm, duration, groups, organizations, nil)
+}
+
+func genUserX509Cert(identity UserIdentity, userPub interface{},
+	caCert *x509.Certificate, caPriv crypto.Signer,
+	kerberosRealm *string, duration time.Duration,
+	groups []string, organizations []string,
+	extraExtensions []pkix.Extension) ([]byte, error) {
+	template, err := newUserX509Template(identity, kerberosRealm, duration,
+		groups, organizations, extraExtensions)
+	if err != nil {
+		return nil, err
+	}
+	template.SignatureAlgorithm = caCert.SignatureAlgorithm
+	return x509.CreateCertificate(rand.Reader, template, caCert, userPub,
+		caPriv)
+}
+
+// newUserX509Template returns the template of a user certificate.
+func newUserX509Template(identity UserIdentity, kerberosRealm *string,
+	duration time.Duration, groups []string, organizations []string,
+	extraExtensions []pkix.Extension) (*x509.Certificate, error) {
+	userName := identity.Username
 	//// Now do the actual work...
 	notBefore := time.Now()
 	notAfter := notBefore.Add(duration)
@@ -353,7 +481,7 @@ func GenUserX509Cert(userName string, userPub interface{},
 		return nil, err
 	}
 
-	sanExtension, err := genSANExtension(userName, kerberosRealm)
+	sanExtension, err := genSANExtension(identity, kerberosRealm)
 	if err != nil {
 		return nil, err
 	}
@@ -380,6 +508,11 @@ func GenUserX509Cert(userName string, userPub interface{},
 		BasicConstraintsValid: true,
 		IsCA:                  false,
 	}
+	if sanExtension == nil {
+		template.EmailAddresses = identity.EmailAddresses
+		template.DNSNames = identity.DNSNames
+		template.URIs = identity.URIs
+	}
 	if groupListExtension != nil {
 		template.ExtraExtensions = append(template.ExtraExtensions,
 			*groupListExtension)
@@ -388,6 +521,24 @@ func GenUserX509Cert(userName string, userPub interface{},
 		template.ExtraExtensions = append(template.ExtraExtensions,
 			*sanExtension)
 	}
-
-	return x509.CreateCertificate(rand.Reader, &template, caCert, userPub, caPriv)
+	claimsExtension, err := getClaimsExtension(identity.X509Claims)
+	if err != nil {
+		return nil, err
+	}
+	if claimsExtension != nil {
+		template.ExtraExtensions = append(template.ExtraExtensions,
+			*claimsExtension)
+	}
+	contextExtension, err := getIssuanceContextExtension(
+		identity.X509IssuanceContext)
+	if err != nil {
+		return nil, err
+	}
+	if contextExtension != nil {
+		template.ExtraExtensions = append(template.ExtraExtensions,
+			*contextExtension)
+	}
+	template.ExtraExtensions = append(template.ExtraExtensions,
+		extraExtensions...)
+	return &template, nil
 }
@@ -2,6 +2,9 @@ package certgen
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
@@ -293,6 +296,35 @@ func TestGenSSHCertFileStringGenerateFailBadPublicKey(t *testing.T) {
 	}
 }
 
+func TestGenSSHHostCertFileString(t *testing.T) {
+	goodSigner, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	principals := []string{"host.example.com", "10.0.0.1"}
+	certString, cert, err := GenSSHHostCertFileString(testUserPublicKey,
+		goodSigner, "bar", principals, testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(certString, "ssh-rsa-cert-v01@openssh.com ") {
+		t.Fatalf("wrong prefix: %s", certString)
+	}
+	if cert.CertType != ssh.HostCert || len(cert.ValidPrincipals) != 2 ||
+		len(cert.Permissions.Extensions) != 0 {
+		t.Fatalf("invalid host cert: %+v", cert)
+	}
+	checker := ssh.CertChecker{}
+	if err := checker.CheckCert("10.0.0.1", &cert); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = GenSSHHostCertFileString(testUserPublicKey, goodSigner, "bar",
+		nil, testDuration)
+	if err == nil {
+		t.Fatal("no error for empty principals")
+	}
+}
+
 func TestGetUserPubKeyFromSSSD(t *testing.T) {
 	username, err := canDoSSSDTests()
 	if err != nil {
@@ -586,3 +618,33 @@ func TestGetPubKeyFromPem(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCheckPublicKeyStrength(t *testing.T) {
+	userPub, err := getPubKeyFromPem(testUserPEMPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPublicKeyStrength(userPub, 0); err != nil {
+		t.Fatal(err)
+	}
+	err = CheckPublicKeyStrength(userPub, 8192)
+	if err == nil || !strings.Contains(err.Error(), "minimum of 8192 bits") {
+		t.Fatalf("expected a minimum size error, got: %v", err)
+	}
+	userSSH, _, _, _, err := ssh.ParseAuthorizedKey([]byte(dsaPublicSSH))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckPublicKeyStrength(
+		userSSH.(ssh.CryptoPublicKey).CryptoPublicKey(), 0)
+	if err == nil || !strings.Contains(err.Error(), "DSA") {
+		t.Fatalf("expected a DSA error, got: %v", err)
+	}
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPublicKeyStrength(&p224Key.PublicKey, 0); err == nil {
+		t.Fatal("P-224 key accepted")
+	}
+}
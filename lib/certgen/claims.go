@@ -0,0 +1,44 @@
+package certgen
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+// ClaimsOID identifies the extension carrying the claims of an X.509 user
+// certificate. The value is a DER encoded SEQUENCE OF Claim.
+var ClaimsOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 9586, 100, 8, 2}
+
+// Claim is an attribute of the user, such as an employee ID, which is
+// embedded into certificates for authorization systems to consume.
+type Claim struct {
+	Name   string
+	Values []string
+}
+
+func getClaimsExtension(claims []Claim) (*pkix.Extension, error) {
+	if len(claims) < 1 {
+		return nil, nil
+	}
+	encodedValue, err := asn1.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &pkix.Extension{Id: ClaimsOID, Value: encodedValue}, nil
+}
+
+// GetX509Claims returns the claims in cert, or nil if it has none.
+func GetX509Claims(cert *x509.Certificate) ([]Claim, error) {
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(ClaimsOID) {
+			continue
+		}
+		var claims []Claim
+		if _, err := asn1.Unmarshal(extension.Value, &claims); err != nil {
+			return nil, err
+		}
+		return claims, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,72 @@
+package certgen
+
+import (
+	"crypto/x509"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenUserX509CertWithClaims(t *testing.T) {
+	userPub, caCert, caPriv := setupX509Generator(t)
+	claims := []Claim{
+		{Name: "employeeNumber", Values: []string{"1234"}},
+		{Name: "mail", Values: []string{"a@example.com", "b@example.com"}},
+	}
+	derCert, err := GenUserX509CertForIdentity(
+		UserIdentity{Username: "username", X509Claims: claims}, userPub,
+		caCert, caPriv, nil, testDuration, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certClaims, err := GetX509Claims(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(certClaims, claims) {
+		t.Fatalf("claims: %v, expected: %v", certClaims, claims)
+	}
+	derCert, err = GenUserX509CertForIdentity(
+		UserIdentity{Username: "username"}, userPub, caCert, caPriv, nil,
+		testDuration, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certClaims, err := GetX509Claims(cert); err != nil {
+		t.Fatal(err)
+	} else if certClaims != nil {
+		t.Fatalf("unexpected claims: %v", certClaims)
+	}
+}
+
+func TestGenSSHCertFileStringWithExtensions(t *testing.T) {
+	signer, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := UserIdentity{
+		Username:      "foo",
+		SSHExtensions: map[string]string{"employee-id@example.com": "1234"},
+	}
+	_, cert, err := GenSSHCertFileStringForIdentity(identity,
+		testUserPublicKey, signer, "bar", "", testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extensions := cert.Permissions.Extensions
+	if value := extensions["employee-id@example.com"]; value != "1234" {
+		t.Fatalf("unexpected employee-id@example.com: %q", value)
+	}
+	if _, ok := extensions["permit-pty"]; !ok {
+		t.Fatal("default permit-pty extension missing")
+	}
+}
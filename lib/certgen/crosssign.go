@@ -0,0 +1,50 @@
+package certgen
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// GenCrossSignedCACert returns caCert signed by issuerCert, so that
+// certificates issued by caCert also chain to issuerCert. It keeps the
+// subject, public key and key ID of caCert, and is not valid past either
+// certificate. This is used to migrate relying parties from one CA to
+// another.
+func GenCrossSignedCACert(caCert *x509.Certificate,
+	issuerCert *x509.Certificate, issuerSigner crypto.Signer) ([]byte, error) {
+	if !caCert.IsCA {
+		return nil, errors.New("certificate is not for a CA")
+	}
+	notBefore := time.Now()
+	notAfter := caCert.NotAfter
+	if issuerCert.NotAfter.Before(notAfter) {
+		notAfter = issuerCert.NotAfter
+	}
+	if !notAfter.After(notBefore) {
+		return nil, errors.New("certificate or issuer has expired")
+	}
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		RawSubject:            caCert.RawSubject,
+		SubjectKeyId:          caCert.SubjectKeyId,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              caCert.KeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            caCert.MaxPathLen,
+		MaxPathLenZero:        caCert.MaxPathLenZero,
+		SignatureAlgorithm:    issuerCert.SignatureAlgorithm,
+	}
+	return x509.CreateCertificate(rand.Reader, &template, issuerCert,
+		caCert.PublicKey, issuerSigner)
+}
@@ -0,0 +1,85 @@
+package certgen
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+)
+
+func TestGenCrossSignedCACert(t *testing.T) {
+	oldPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCADer, err := GenSelfSignedCACert("some hostname", "some organization",
+		oldPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCACert, err := x509.ParseCertificate(oldCADer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPriv, err := GetSignerFromPEMBytes([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCADer, err := GenSelfSignedCACert("some hostname", "some organization",
+		newPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCACert, err := x509.ParseCertificate(newCADer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crossDer, err := GenCrossSignedCACert(newCACert, oldCACert, oldPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crossCert, err := x509.ParseCertificate(crossDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(crossCert.RawSubject, newCACert.RawSubject) ||
+		!bytes.Equal(crossCert.RawIssuer, oldCACert.RawSubject) {
+		t.Fatal("cross-signed certificate has the wrong names")
+	}
+	if crossCert.NotAfter.After(oldCACert.NotAfter) {
+		t.Fatal("cross-signed certificate outlives its issuer")
+	}
+	userPub, err := getPubKeyFromPem(testUserPEMPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userDer, err := GenUserX509Cert("username", userPub, newCACert, newPriv,
+		nil, testDuration, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userCert, err := x509.ParseCertificate(userDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(crossCert)
+	for _, root := range []*x509.Certificate{oldCACert, newCACert} {
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+		_, err := userCert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	userCert.Raw = nil
+	if _, err := GenCrossSignedCACert(userCert, oldCACert, oldPriv); err == nil {
+		t.Fatal("cross-signed a certificate which is not for a CA")
+	}
+}
@@ -0,0 +1,34 @@
+package certgen
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+)
+
+// CheckFIPSPublicKey returns an error if pub is not of a key type and size
+// approved by FIPS 186-4: RSA of at least 2048 bits with a public exponent
+// of at least 65537, or ECDSA on P-256, P-384 or P-521.
+func CheckFIPSPublicKey(pub interface{}) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return fmt.Errorf("RSA key of %d bits not FIPS approved",
+				k.N.BitLen())
+		}
+		if k.E < 65537 {
+			return fmt.Errorf("RSA exponent %d not FIPS approved", k.E)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+			return nil
+		}
+		return fmt.Errorf("ECDSA curve %s not FIPS approved",
+			k.Curve.Params().Name)
+	default:
+		return fmt.Errorf("key type %T not FIPS approved", pub)
+	}
+}
@@ -0,0 +1,51 @@
+package certgen
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestCheckFIPSPublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallRSAKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		pub      interface{}
+		approved bool
+	}{
+		{"RSA 2048", &rsaKey.PublicKey, true},
+		{"RSA 1024", &smallRSAKey.PublicKey, false},
+		{"P-256", &p256Key.PublicKey, true},
+		{"P-224", &p224Key.PublicKey, false},
+		{"Ed25519", edPub, false},
+	}
+	for _, test := range tests {
+		err := CheckFIPSPublicKey(test.pub)
+		if (err == nil) != test.approved {
+			t.Errorf("%s: expected approved: %v, got: %v", test.name,
+				test.approved, err)
+		}
+	}
+}
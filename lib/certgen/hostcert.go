@@ -0,0 +1,64 @@
+package certgen
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// GenSSHHostCertFileString returns a host certificate for hostPubKey (in
+// authorized_keys format) valid for the given principals, which are the
+// names that clients use to connect to the host.
+func GenSSHHostCertFileString(hostPubKey string, signer ssh.Signer,
+	hostIdentity string, principals []string, duration time.Duration) (
+	certString string, cert ssh.Certificate, err error) {
+	return GenSSHHostCertFileStringWithAuditID(hostPubKey, signer,
+		hostIdentity, principals, "", duration)
+}
+
+// GenSSHHostCertFileStringWithAuditID is like GenSSHHostCertFileString, but
+// appends auditID to the key ID.
+func GenSSHHostCertFileStringWithAuditID(hostPubKey string, signer ssh.Signer,
+	hostIdentity string, principals []string, auditID string,
+	duration time.Duration) (
+	certString string, cert ssh.Certificate, err error) {
+	if len(principals) < 1 {
+		return "", cert, errors.New("no principals for host certificate")
+	}
+	keyIdentity := hostIdentity + "_" + principals[0]
+	if auditID != "" {
+		keyIdentity += "_" + auditID
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostPubKey))
+	if err != nil {
+		return "", cert, err
+	}
+	currentEpoch := uint64(time.Now().Unix())
+	expireEpoch := currentEpoch + uint64(duration.Seconds())
+	nBig, err := rand.Int(rand.Reader, big.NewInt(0xFFFFFFFF))
+	if err != nil {
+		return "", cert, err
+	}
+	cert = ssh.Certificate{
+		Key:             hostKey,
+		CertType:        ssh.HostCert,
+		SignatureKey:    signer.PublicKey(),
+		ValidPrincipals: principals,
+		KeyId:           keyIdentity,
+		ValidAfter:      currentEpoch,
+		ValidBefore:     expireEpoch,
+		Serial:          (currentEpoch << 32) | nBig.Uint64(),
+	}
+	err = cert.SignCert(bytes.NewReader(cert.Marshal()), signer)
+	if err != nil {
+		return "", cert, err
+	}
+	certString = cert.Type() + " " +
+		base64.StdEncoding.EncodeToString(cert.Marshal()) + " " + principals[0]
+	return certString, cert, nil
+}
@@ -0,0 +1,77 @@
+package certgen
+
+import (
+	"crypto"
+	"crypto/x509"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// UserIdentity describes who a user certificate is issued to, when the
+// names a user is known by elsewhere differ from the keymaster username.
+type UserIdentity struct {
+	Username          string   // The X.509 common name and SSH key ID.
+	SSHPrincipals     []string // Defaults to Username.
+	EmailAddresses    []string // X.509 SANs.
+	KerberosPrincipal string   // Defaults to Username.
+	// Leave the Kerberos principal out, even if there is a Kerberos realm.
+	OmitKerberosPrincipal bool
+	UserPrincipalName     string     // Microsoft UPN SAN, if not empty.
+	DNSNames              []string   // X.509 SANs, for service identities.
+	URIs                  []*url.URL // X.509 SANs.
+	X509Claims            []Claim    // Added in the ClaimsOID extension.
+	// Added in the IssuanceContextOID extension, if not nil.
+	X509IssuanceContext *X509IssuanceContext
+	// Added to the extensions of SSH certificates.
+	SSHExtensions map[string]string
+	// The critical options of SSH certificates, such as source-address.
+	SSHCriticalOptions map[string]string
+}
+
+// GetSSHPrincipals returns the principals of SSH certificates issued to
+// identity.
+func (identity UserIdentity) GetSSHPrincipals() []string {
+	if len(identity.SSHPrincipals) > 0 {
+		return identity.SSHPrincipals
+	}
+	return []string{identity.Username}
+}
+
+func (identity UserIdentity) getKerberosPrincipal() string {
+	if identity.KerberosPrincipal != "" {
+		return identity.KerberosPrincipal
+	}
+	return identity.Username
+}
+
+// GenSSHCertFileStringForIdentity is like GenSSHCertFileStringWithAuditID,
+// but the principals are taken from identity.
+func GenSSHCertFileStringForIdentity(identity UserIdentity,
+	userPubKey string, signer ssh.Signer, hostIdentity string,
+	auditID string, duration time.Duration) (
+	string, ssh.Certificate, error) {
+	keyIdentity := hostIdentity + "_" + identity.Username
+	if auditID != "" {
+		keyIdentity += "_" + auditID
+	}
+	return genSSHCertFileString(identity.Username,
+		identity.GetSSHPrincipals(), identity.SSHExtensions,
+		identity.SSHCriticalOptions, userPubKey, signer, keyIdentity,
+		duration)
+}
+
+// GenUserX509CertForIdentity is like GenUserX509CertWithAuditID, but the
+// subject alternative names are taken from identity.
+func GenUserX509CertForIdentity(identity UserIdentity, userPub interface{},
+	caCert *x509.Certificate, caPriv crypto.Signer,
+	kerberosRealm *string, duration time.Duration,
+	groups []string, organizations []string, auditID string) ([]byte, error) {
+	extensions, err := getAuditIDExtensions(auditID)
+	if err != nil {
+		return nil, err
+	}
+	return genUserX509Cert(identity, userPub, caCert, caPriv, kerberosRealm,
+		duration, groups, organizations, extensions)
+}
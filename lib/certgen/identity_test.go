@@ -0,0 +1,139 @@
+package certgen
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"net/url"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenSSHCertFileStringForIdentity(t *testing.T) {
+	signer, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := UserIdentity{
+		Username:           "foo",
+		SSHPrincipals:      []string{"foo", "foo.bar"},
+		SSHCriticalOptions: map[string]string{"source-address": "10.0.0.1/32"},
+	}
+	_, cert, err := GenSSHCertFileStringForIdentity(identity,
+		testUserPublicKey, signer, "bar", "", testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.ValidPrincipals) != 2 || cert.ValidPrincipals[1] != "foo.bar" {
+		t.Fatalf("unexpected principals: %v", cert.ValidPrincipals)
+	}
+	if cert.KeyId != "bar_foo" {
+		t.Fatalf("unexpected key ID: %s", cert.KeyId)
+	}
+	sourceAddress := cert.CriticalOptions["source-address"]
+	if sourceAddress != "10.0.0.1/32" {
+		t.Fatalf("unexpected source-address: %s", sourceAddress)
+	}
+}
+
+func TestGenUserX509CertForIdentity(t *testing.T) {
+	userPub, caCert, caPriv := setupX509Generator(t)
+	identity := UserIdentity{
+		Username:          "username",
+		EmailAddresses:    []string{"user@example.com"},
+		KerberosPrincipal: "User",
+	}
+	realm := "EXAMPLE.COM"
+	for _, kerberosRealm := range []*string{nil, &realm} {
+		derCert, err := GenUserX509CertForIdentity(identity, userPub, caCert,
+			caPriv, kerberosRealm, testDuration, nil, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(derCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Subject.CommonName != "username" {
+			t.Fatalf("unexpected common name: %s", cert.Subject.CommonName)
+		}
+		if len(cert.EmailAddresses) != 1 ||
+			cert.EmailAddresses[0] != "user@example.com" {
+			t.Fatalf("unexpected email addresses: %v", cert.EmailAddresses)
+		}
+	}
+}
+
+// getOtherNameOIDs returns the types of the other names in the subject
+// alternative names of cert.
+func getOtherNameOIDs(t *testing.T, cert *x509.Certificate) []string {
+	var oids []string
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(asn1.ObjectIdentifier{2, 5, 29, 17}) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(extension.Value, &names); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+			var oid asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(name.Bytes, &oid); err != nil {
+				t.Fatal(err)
+			}
+			oids = append(oids, oid.String())
+		}
+	}
+	return oids
+}
+
+func TestGenUserX509CertSANs(t *testing.T) {
+	userPub, caCert, caPriv := setupX509Generator(t)
+	uri, err := url.Parse("spiffe://example.com/user/username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := UserIdentity{
+		Username:              "username",
+		EmailAddresses:        []string{"user@example.com"},
+		OmitKerberosPrincipal: true,
+		UserPrincipalName:     "username@corp.example.com",
+		DNSNames:              []string{"username.svc.example.com"},
+		URIs:                  []*url.URL{uri},
+	}
+	realm := "EXAMPLE.COM"
+	for _, upn := range []string{"", "username@corp.example.com"} {
+		identity.UserPrincipalName = upn
+		derCert, err := GenUserX509CertForIdentity(identity, userPub, caCert,
+			caPriv, &realm, testDuration, nil, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(derCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cert.EmailAddresses) != 1 ||
+			cert.EmailAddresses[0] != "user@example.com" {
+			t.Fatalf("unexpected email addresses: %v", cert.EmailAddresses)
+		}
+		if len(cert.DNSNames) != 1 ||
+			cert.DNSNames[0] != "username.svc.example.com" {
+			t.Fatalf("unexpected DNS names: %v", cert.DNSNames)
+		}
+		if len(cert.URIs) != 1 || cert.URIs[0].String() != uri.String() {
+			t.Fatalf("unexpected URIs: %v", cert.URIs)
+		}
+		otherNames := getOtherNameOIDs(t, cert)
+		if upn == "" && len(otherNames) != 0 {
+			t.Fatalf("unexpected other names: %v", otherNames)
+		}
+		if upn != "" && (len(otherNames) != 1 ||
+			otherNames[0] != upnOID.String()) {
+			t.Fatalf("unexpected other names: %v", otherNames)
+		}
+	}
+}
@@ -146,6 +146,7 @@ func GenIPRestrictedX509Cert(userName string, userPub interface{},
 		OCSPServer:            OCPServer,
 		BasicConstraintsValid: true,
 		IsCA: false,
+		SignatureAlgorithm:    caCert.SignatureAlgorithm,
 	}
 	if ipDelegationExtension != nil {
 		template.ExtraExtensions = append(template.ExtraExtensions,
@@ -0,0 +1,69 @@
+package certgen
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"time"
+)
+
+// IssuanceContextOID identifies the extension carrying the issuance context
+// of an X.509 user certificate. The value is a DER encoded SEQUENCE with the
+// fields of X509IssuanceContext in order.
+var IssuanceContextOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 9586, 100,
+	8, 3}
+
+// Authentication strengths, from weakest to strongest.
+const (
+	// AuthStrengthSingleFactor is a password, a federated login or another
+	// single factor.
+	AuthStrengthSingleFactor = 1
+	// AuthStrengthMultiFactor adds a one-time password or push second
+	// factor.
+	AuthStrengthMultiFactor = 2
+	// AuthStrengthPhishingResistant adds a U2F or WebAuthn second factor,
+	// or is a passkey login.
+	AuthStrengthPhishingResistant = 3
+)
+
+// X509IssuanceContext describes how the user authenticated to get a
+// certificate, so that relying parties can require a stronger or more
+// recent authentication for some operations without asking keymaster.
+type X509IssuanceContext struct {
+	AuditID      string    `asn1:"utf8"`
+	AuthMethod   string    `asn1:"utf8"` // Such as "password+U2F".
+	AuthStrength int       // One of the AuthStrength constants.
+	AuthTime     time.Time `asn1:"generalized"`
+	DeviceID     string    `asn1:"utf8"` // As reported, empty if unknown.
+}
+
+func getIssuanceContextExtension(context *X509IssuanceContext) (
+	*pkix.Extension, error) {
+	if context == nil {
+		return nil, nil
+	}
+	value := *context
+	value.AuthTime = value.AuthTime.UTC().Truncate(time.Second)
+	encodedValue, err := asn1.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return &pkix.Extension{Id: IssuanceContextOID, Value: encodedValue}, nil
+}
+
+// GetX509IssuanceContext returns the issuance context in cert, or nil if it
+// has none.
+func GetX509IssuanceContext(cert *x509.Certificate) (*X509IssuanceContext,
+	error) {
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(IssuanceContextOID) {
+			continue
+		}
+		var context X509IssuanceContext
+		if _, err := asn1.Unmarshal(extension.Value, &context); err != nil {
+			return nil, err
+		}
+		return &context, nil
+	}
+	return nil, nil
+}
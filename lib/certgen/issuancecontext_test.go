@@ -0,0 +1,58 @@
+package certgen
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestGenUserX509CertWithIssuanceContext(t *testing.T) {
+	userPub, caCert, caPriv := setupX509Generator(t)
+	context := X509IssuanceContext{
+		AuditID:      "0123456789ab",
+		AuthMethod:   "password+U2F",
+		AuthStrength: AuthStrengthPhishingResistant,
+		AuthTime:     time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC),
+		DeviceID:     "laptop-42",
+	}
+	derCert, err := GenUserX509CertForIdentity(
+		UserIdentity{Username: "username", X509IssuanceContext: &context},
+		userPub, caCert, caPriv, nil, testDuration, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certContext, err := GetX509IssuanceContext(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certContext == nil {
+		t.Fatal("no issuance context")
+	}
+	if !certContext.AuthTime.Equal(context.AuthTime) {
+		t.Fatalf("auth time: %s, expected: %s", certContext.AuthTime,
+			context.AuthTime)
+	}
+	certContext.AuthTime = context.AuthTime
+	if *certContext != context {
+		t.Fatalf("context: %+v, expected: %+v", *certContext, context)
+	}
+	derCert, err = GenUserX509CertForIdentity(
+		UserIdentity{Username: "username"}, userPub, caCert, caPriv, nil,
+		testDuration, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certContext, err := GetX509IssuanceContext(cert); err != nil {
+		t.Fatal(err)
+	} else if certContext != nil {
+		t.Fatalf("unexpected issuance context: %+v", *certContext)
+	}
+}
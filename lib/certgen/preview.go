@@ -0,0 +1,47 @@
+package certgen
+
+import (
+	"crypto/x509"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// PreviewSSHCertForIdentity returns the certificate which
+// GenSSHCertFileStringForIdentity would issue for the same arguments, without
+// signing it. The serial number is random and differs from the one an issued
+// certificate would get.
+func PreviewSSHCertForIdentity(identity UserIdentity, userPubKey string,
+	signatureKey ssh.PublicKey, hostIdentity string, auditID string,
+	duration time.Duration) (ssh.Certificate, error) {
+	keyIdentity := hostIdentity + "_" + identity.Username
+	if auditID != "" {
+		keyIdentity += "_" + auditID
+	}
+	return newSSHUserCert(identity.GetSSHPrincipals(),
+		identity.SSHExtensions, identity.SSHCriticalOptions, userPubKey,
+		signatureKey, keyIdentity, duration)
+}
+
+// PreviewUserX509CertForIdentity returns the template from which
+// GenUserX509CertForIdentity would create a certificate for the same
+// arguments, with the issuer and public key filled in. The extensions added
+// by keymaster are in ExtraExtensions.
+func PreviewUserX509CertForIdentity(identity UserIdentity,
+	userPub interface{}, caCert *x509.Certificate, kerberosRealm *string,
+	duration time.Duration, groups []string, organizations []string,
+	auditID string) (*x509.Certificate, error) {
+	extensions, err := getAuditIDExtensions(auditID)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newUserX509Template(identity, kerberosRealm, duration,
+		groups, organizations, extensions)
+	if err != nil {
+		return nil, err
+	}
+	template.Issuer = caCert.Subject
+	template.SignatureAlgorithm = caCert.SignatureAlgorithm
+	template.PublicKey = userPub
+	return template, nil
+}
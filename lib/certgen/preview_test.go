@@ -0,0 +1,63 @@
+package certgen
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPreviewSSHCertForIdentity(t *testing.T) {
+	signer, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := UserIdentity{
+		Username:      "foo",
+		SSHPrincipals: []string{"foo", "foo.bar"},
+	}
+	_, issued, err := GenSSHCertFileStringForIdentity(identity,
+		testUserPublicKey, signer, "bar", "0123456789ab", testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview, err := PreviewSSHCertForIdentity(identity, testUserPublicKey,
+		signer.PublicKey(), "bar", "0123456789ab", testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Signature != nil {
+		t.Fatal("preview is signed")
+	}
+	if preview.KeyId != issued.KeyId ||
+		len(preview.ValidPrincipals) != len(issued.ValidPrincipals) ||
+		len(preview.Permissions.Extensions) !=
+			len(issued.Permissions.Extensions) ||
+		preview.ValidBefore-preview.ValidAfter !=
+			issued.ValidBefore-issued.ValidAfter ||
+		!bytes.Equal(preview.SignatureKey.Marshal(),
+			issued.SignatureKey.Marshal()) {
+		t.Fatalf("preview differs from issued certificate: %+v", preview)
+	}
+}
+
+func TestPreviewUserX509CertForIdentity(t *testing.T) {
+	userPub, caCert, _ := setupX509Generator(t)
+	identity := UserIdentity{Username: "username"}
+	preview, err := PreviewUserX509CertForIdentity(identity, userPub, caCert,
+		nil, testDuration, []string{"group"}, []string{"keymaster"},
+		"0123456789ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Subject.CommonName != "username" ||
+		preview.Issuer.String() != caCert.Subject.String() ||
+		preview.PublicKey != userPub {
+		t.Fatalf("unexpected preview: %+v", preview)
+	}
+	// The group list and audit ID extensions.
+	if len(preview.ExtraExtensions) != 2 ||
+		!preview.ExtraExtensions[1].Id.Equal(AuditIDOID) {
+		t.Fatalf("unexpected extensions: %v", preview.ExtraExtensions)
+	}
+}
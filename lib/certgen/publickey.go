@@ -0,0 +1,40 @@
+package certgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ParseSSHPublicKey parses a single public key in authorized_keys format, as
+// submitted for a certificate. Every key type known to x/crypto/ssh is
+// accepted, including FIDO security keys, provided the key is strong enough
+// (see CheckPublicKeyStrength). Key options, certificates and additional keys
+// are refused.
+func ParseSSHPublicKey(authorizedKey []byte, minRSABits int) (
+	ssh.PublicKey, error) {
+	publicKey, _, options, rest, err := ssh.ParseAuthorizedKey(authorizedKey)
+	if err != nil {
+		return nil, errors.New("unparseable public key")
+	}
+	if len(options) > 0 {
+		return nil, errors.New("key options not allowed")
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, errors.New("more than one key")
+	}
+	if _, ok := publicKey.(*ssh.Certificate); ok {
+		return nil, errors.New("certificates not allowed")
+	}
+	cryptoPublicKey, ok := publicKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type: %s", publicKey.Type())
+	}
+	err = CheckPublicKeyStrength(cryptoPublicKey.CryptoPublicKey(), minRSABits)
+	if err != nil {
+		return nil, fmt.Errorf("weak key: %s, generate a new key", err)
+	}
+	return publicKey, nil
+}
@@ -0,0 +1,54 @@
+package certgen
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseSSHPublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skKeyBlob := ssh.Marshal(struct {
+		Name        string
+		ID          string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKECDSA256, "nistp256",
+		elliptic.Marshal(elliptic.P256(), ecKey.X, ecKey.Y), "ssh:"})
+	skKey := ssh.KeyAlgoSKECDSA256 + " " +
+		base64.StdEncoding.EncodeToString(skKeyBlob) + " user@host\n"
+	validKeys := []string{
+		testUserPublicKey,
+		ecdsaPublicSSH,
+		ed25519PublicSSH,
+		skKey,
+		"# comment\n" + ed25519PublicSSH + "\n\n",
+	}
+	for _, key := range validKeys {
+		if _, err := ParseSSHPublicKey([]byte(key), 0); err != nil {
+			t.Errorf("key: %q rejected: %s", key, err)
+		}
+	}
+	invalidKeys := []string{
+		"",
+		"ssh-rsa AAAA",
+		dsaPublicSSH,
+		`command="/bin/sh" ` + ed25519PublicSSH,
+		ed25519PublicSSH + "\n" + ecdsaPublicSSH,
+	}
+	for _, key := range invalidKeys {
+		if _, err := ParseSSHPublicKey([]byte(key), 0); err == nil {
+			t.Errorf("key: %q was not rejected", key)
+		}
+	}
+	if _, err := ParseSSHPublicKey([]byte(testUserPublicKey), 8192); err == nil {
+		t.Error("RSA key smaller than the minimum was not rejected")
+	}
+}
@@ -0,0 +1,61 @@
+package certgen
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultSSHSignatureAlgorithm is the algorithm RSA CA keys sign SSH
+// certificates with. OpenSSH 8.8 and later refuse ssh-rsa (SHA-1) signatures.
+const DefaultSSHSignatureAlgorithm = ssh.SigAlgoRSASHA2512
+
+// sshAlgorithmSigner signs with a fixed algorithm. It deliberately does not
+// implement ssh.AlgorithmSigner, so that SignCert uses Sign rather than
+// picking an algorithm itself.
+type sshAlgorithmSigner struct {
+	signer    ssh.AlgorithmSigner
+	algorithm string
+}
+
+// CheckSSHSignatureAlgorithm returns an error if algorithm may not be used
+// to sign SSH certificates with an RSA key. An empty algorithm selects
+// DefaultSSHSignatureAlgorithm.
+func CheckSSHSignatureAlgorithm(algorithm string) error {
+	switch algorithm {
+	case "", ssh.SigAlgoRSA, ssh.SigAlgoRSASHA2256, ssh.SigAlgoRSASHA2512:
+		return nil
+	}
+	return fmt.Errorf("unsupported SSH signature algorithm: %s", algorithm)
+}
+
+// NewSSHSigner returns a signer which signs with algorithm if signer has an
+// RSA key. Other keys have a single signature algorithm, so signer is
+// returned unchanged. An empty algorithm selects
+// DefaultSSHSignatureAlgorithm.
+func NewSSHSigner(signer ssh.Signer, algorithm string) (ssh.Signer, error) {
+	if err := CheckSSHSignatureAlgorithm(algorithm); err != nil {
+		return nil, err
+	}
+	if signer.PublicKey().Type() != ssh.KeyAlgoRSA {
+		return signer, nil
+	}
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("signer cannot select signature algorithm")
+	}
+	if algorithm == "" {
+		algorithm = DefaultSSHSignatureAlgorithm
+	}
+	return &sshAlgorithmSigner{algorithmSigner, algorithm}, nil
+}
+
+func (signer *sshAlgorithmSigner) PublicKey() ssh.PublicKey {
+	return signer.signer.PublicKey()
+}
+
+func (signer *sshAlgorithmSigner) Sign(rand io.Reader, data []byte) (
+	*ssh.Signature, error) {
+	return signer.signer.SignWithAlgorithm(rand, data, signer.algorithm)
+}
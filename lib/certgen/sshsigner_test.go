@@ -0,0 +1,76 @@
+package certgen
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNewSSHSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSigner, err := ssh.NewSignerFromSigner(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		algorithm string
+		expected  string
+	}{
+		{"", ssh.SigAlgoRSASHA2512},
+		{ssh.SigAlgoRSASHA2256, ssh.SigAlgoRSASHA2256},
+		{ssh.SigAlgoRSA, ssh.SigAlgoRSA},
+	} {
+		signer, err := NewSSHSigner(rsaSigner, test.algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := newSSHUserCert([]string{"username"}, nil, nil,
+			string(ssh.MarshalAuthorizedKey(userKey)), signer.PublicKey(),
+			"username", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cert.SignCert(bytes.NewReader(cert.Marshal()),
+			signer); err != nil {
+			t.Fatal(err)
+		}
+		if cert.Signature.Format != test.expected {
+			t.Fatalf("expected: %s, got: %s", test.expected,
+				cert.Signature.Format)
+		}
+		checker := ssh.CertChecker{}
+		if err := checker.CheckCert("username", &cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := NewSSHSigner(rsaSigner, "ssh-dss"); err == nil {
+		t.Fatal("unsupported algorithm accepted")
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSigner, err := ssh.NewSignerFromSigner(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSSHSigner(edSigner, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer != edSigner {
+		t.Fatal("Ed25519 signer was wrapped")
+	}
+}
@@ -0,0 +1,68 @@
+package certgen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// x509SignatureAlgorithms are the algorithms which may be selected for
+// signing X.509 certificates, with the type of key each requires.
+var x509SignatureAlgorithms = map[x509.SignatureAlgorithm]x509.PublicKeyAlgorithm{
+	x509.SHA256WithRSA:    x509.RSA,
+	x509.SHA384WithRSA:    x509.RSA,
+	x509.SHA512WithRSA:    x509.RSA,
+	x509.SHA256WithRSAPSS: x509.RSA,
+	x509.SHA384WithRSAPSS: x509.RSA,
+	x509.SHA512WithRSAPSS: x509.RSA,
+	x509.ECDSAWithSHA256:  x509.ECDSA,
+	x509.ECDSAWithSHA384:  x509.ECDSA,
+	x509.ECDSAWithSHA512:  x509.ECDSA,
+}
+
+// ParseX509SignatureAlgorithm returns the X.509 signature algorithm with the
+// given name, as printed by x509.SignatureAlgorithm.String (for example
+// SHA384-RSAPSS or ECDSA-SHA384). An empty name returns
+// x509.UnknownSignatureAlgorithm, which lets the x509 package pick the
+// default for the key.
+func ParseX509SignatureAlgorithm(name string) (x509.SignatureAlgorithm,
+	error) {
+	if name == "" {
+		return x509.UnknownSignatureAlgorithm, nil
+	}
+	for algorithm := range x509SignatureAlgorithms {
+		if algorithm.String() == name {
+			return algorithm, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm,
+		fmt.Errorf("unsupported X.509 signature algorithm: %s", name)
+}
+
+// CheckX509SignatureAlgorithm returns an error if algorithm cannot be used
+// to sign with the private key of pub.
+func CheckX509SignatureAlgorithm(algorithm x509.SignatureAlgorithm,
+	pub crypto.PublicKey) error {
+	if algorithm == x509.UnknownSignatureAlgorithm {
+		return nil
+	}
+	keyAlgorithm, ok := x509SignatureAlgorithms[algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported X.509 signature algorithm: %s",
+			algorithm)
+	}
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		if keyAlgorithm == x509.RSA {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if keyAlgorithm == x509.ECDSA {
+			return nil
+		}
+	}
+	return fmt.Errorf("X.509 signature algorithm %s cannot be used with %T key",
+		algorithm, pub)
+}
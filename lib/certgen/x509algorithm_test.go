@@ -0,0 +1,88 @@
+package certgen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+)
+
+func TestParseX509SignatureAlgorithm(t *testing.T) {
+	algorithm, err := ParseX509SignatureAlgorithm("")
+	if err != nil || algorithm != x509.UnknownSignatureAlgorithm {
+		t.Fatalf("unexpected default: %s, %v", algorithm, err)
+	}
+	algorithm, err = ParseX509SignatureAlgorithm("SHA384-RSAPSS")
+	if err != nil || algorithm != x509.SHA384WithRSAPSS {
+		t.Fatalf("unexpected algorithm: %s, %v", algorithm, err)
+	}
+	for _, name := range []string{"SHA1-RSA", "MD5-RSA", "Ed25519", "bogus"} {
+		if _, err := ParseX509SignatureAlgorithm(name); err == nil {
+			t.Fatalf("%s accepted", name)
+		}
+	}
+}
+
+func TestGenSelfSignedCACertWithAlgorithm(t *testing.T) {
+	rsaPriv, err := GetSignerFromPEMBytes([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = GenSelfSignedCACertWithAlgorithm("some hostname",
+		"some organization", ecdsaPriv, x509.SHA256WithRSAPSS)
+	if err == nil {
+		t.Fatal("RSA algorithm accepted for ECDSA key")
+	}
+	_, err = GenSelfSignedCACertWithAlgorithm("some hostname",
+		"some organization", rsaPriv, x509.ECDSAWithSHA384)
+	if err == nil {
+		t.Fatal("ECDSA algorithm accepted for RSA key")
+	}
+	userPub, err := getPubKeyFromPem(testUserPEMPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		priv      crypto.Signer
+		algorithm x509.SignatureAlgorithm
+	}{
+		{rsaPriv, x509.SHA384WithRSAPSS},
+		{ecdsaPriv, x509.ECDSAWithSHA384},
+	} {
+		caDer, err := GenSelfSignedCACertWithAlgorithm("some hostname",
+			"some organization", test.priv, test.algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		caCert, err := x509.ParseCertificate(caDer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if caCert.SignatureAlgorithm != test.algorithm {
+			t.Fatalf("CA signed with %s, expected %s",
+				caCert.SignatureAlgorithm, test.algorithm)
+		}
+		userDer, err := GenUserX509Cert("username", userPub, caCert,
+			test.priv, nil, testDuration, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		userCert, err := x509.ParseCertificate(userDer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userCert.SignatureAlgorithm != test.algorithm {
+			t.Fatalf("user certificate signed with %s, expected %s",
+				userCert.SignatureAlgorithm, test.algorithm)
+		}
+		if err := userCert.CheckSignatureFrom(caCert); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
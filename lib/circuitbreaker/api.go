@@ -0,0 +1,78 @@
+// Package circuitbreaker tracks the consecutive failures of backends, such as
+// LDAP servers and databases, and stops sending requests to a failing backend
+// for a backoff period. Callers can then skip straight to the next server or
+// a cache instead of waiting for a timeout on every request.
+package circuitbreaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+// Config configures the breakers. Zero values select the defaults.
+type Config struct {
+	// Consecutive failures which open the circuit. Default: 3.
+	FailureThreshold uint `yaml:"failure_threshold"`
+	// How long the circuit stays open the first time. It doubles each time
+	// the trial request after a backoff fails. Default: 30s.
+	OpenDuration time.Duration `yaml:"open_duration"`
+	// The maximum backoff. Default: 5m.
+	MaxOpenDuration time.Duration `yaml:"max_open_duration"`
+}
+
+// Set holds a Breaker for each backend, created on first use.
+type Set struct {
+	config        Config
+	logger        log.DebugLogger
+	onStateChange func(name string, open bool)
+	mutex         sync.Mutex          // Protect everything below.
+	breakers      map[string]*Breaker // Key: backend name.
+}
+
+// Breaker tracks the failures of one backend.
+type Breaker struct {
+	name      string
+	set       *Set
+	mutex     sync.Mutex // Protect everything below.
+	failures  uint       // Consecutive failures.
+	opens     uint       // Consecutive times the circuit opened.
+	openUntil time.Time  // When to allow a trial request.
+}
+
+// New creates a Set. If onStateChange is not nil, it is called whenever the
+// circuit of a backend opens or closes.
+func New(config Config, logger log.DebugLogger,
+	onStateChange func(name string, open bool)) *Set {
+	return newSet(config, logger, onStateChange)
+}
+
+// Get returns the Breaker for the named backend. If s is nil, nil is
+// returned, which allows all requests.
+func (s *Set) Get(name string) *Breaker {
+	return s.get(name)
+}
+
+// Allow returns true if a request may be sent to the backend: the circuit is
+// closed, or the backoff has expired and this is the trial request. Callers
+// which are allowed must report the outcome with Success or Failure.
+func (b *Breaker) Allow() bool {
+	return b.allow()
+}
+
+// Failure records a failed request, opening the circuit once the failure
+// threshold is reached or if the trial request failed.
+func (b *Breaker) Failure() {
+	b.failure()
+}
+
+// IsOpen returns true if requests to the backend are currently refused.
+func (b *Breaker) IsOpen() bool {
+	return b.isOpen()
+}
+
+// Success records a successful request and closes the circuit.
+func (b *Breaker) Success() {
+	b.success()
+}
@@ -0,0 +1,76 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	var changes []bool
+	set := New(Config{FailureThreshold: 2, OpenDuration: time.Hour}, nil,
+		func(name string, open bool) {
+			if name != "ldap" {
+				t.Fatalf("unexpected backend: %s", name)
+			}
+			changes = append(changes, open)
+		})
+	breaker := set.Get("ldap")
+	if set.Get("ldap") != breaker {
+		t.Fatal("Get returned a different breaker")
+	}
+	breaker.Failure()
+	if !breaker.Allow() || breaker.IsOpen() {
+		t.Fatal("circuit opened before the threshold")
+	}
+	breaker.Failure()
+	if breaker.Allow() || !breaker.IsOpen() {
+		t.Fatal("circuit not opened at the threshold")
+	}
+	if !set.Get("other").Allow() {
+		t.Fatal("other backend refused")
+	}
+	// Expire the backoff: one trial request is allowed.
+	breaker.mutex.Lock()
+	breaker.openUntil = time.Now()
+	breaker.mutex.Unlock()
+	if !breaker.Allow() {
+		t.Fatal("trial request refused")
+	}
+	if breaker.Allow() {
+		t.Fatal("second trial request allowed")
+	}
+	breaker.Success()
+	if !breaker.Allow() || breaker.IsOpen() {
+		t.Fatal("circuit not closed by success")
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Fatalf("unexpected state changes: %v", changes)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	set := New(Config{OpenDuration: time.Second,
+		MaxOpenDuration: 4 * time.Second}, nil, nil)
+	for opens, expected := range map[uint]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		10: 4 * time.Second,
+	} {
+		backoff := set.backoff(opens)
+		if backoff < expected*8/10 || backoff > expected*12/10 {
+			t.Fatalf("backoff after %d: %s, expected about %s", opens,
+				backoff, expected)
+		}
+	}
+}
+
+func TestNilBreaker(t *testing.T) {
+	var set *Set
+	breaker := set.Get("ldap")
+	breaker.Failure()
+	if !breaker.Allow() || breaker.IsOpen() {
+		t.Fatal("nil breaker refused request")
+	}
+	breaker.Success()
+}
@@ -0,0 +1,139 @@
+package circuitbreaker
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultOpenDuration     = 30 * time.Second
+	defaultMaxOpenDuration  = 5 * time.Minute
+)
+
+func newSet(config Config, logger log.DebugLogger,
+	onStateChange func(name string, open bool)) *Set {
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = defaultFailureThreshold
+	}
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = defaultOpenDuration
+	}
+	if config.MaxOpenDuration <= 0 {
+		config.MaxOpenDuration = defaultMaxOpenDuration
+	}
+	if config.MaxOpenDuration < config.OpenDuration {
+		config.MaxOpenDuration = config.OpenDuration
+	}
+	return &Set{
+		config:        config,
+		logger:        logger,
+		onStateChange: onStateChange,
+		breakers:      make(map[string]*Breaker),
+	}
+}
+
+func (s *Set) get(name string) *Breaker {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	breaker, ok := s.breakers[name]
+	if !ok {
+		breaker = &Breaker{name: name, set: s}
+		s.breakers[name] = breaker
+	}
+	return breaker
+}
+
+// backoff returns the open duration after opens consecutive openings, with
+// up to 20% of jitter either way so that keymasterd instances sharing a
+// backend do not all retry it at once.
+func (s *Set) backoff(opens uint) time.Duration {
+	duration := s.config.OpenDuration
+	for i := uint(1); i < opens && duration < s.config.MaxOpenDuration; i++ {
+		duration *= 2
+	}
+	if duration > s.config.MaxOpenDuration {
+		duration = s.config.MaxOpenDuration
+	}
+	jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(duration))
+	return duration + jitter
+}
+
+func (b *Breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.opens < 1 {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return false
+	}
+	// Allow one trial request. If its outcome is never reported, another is
+	// allowed after the next backoff.
+	b.openUntil = now.Add(b.set.backoff(b.opens))
+	return true
+}
+
+func (b *Breaker) failure() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	b.failures++
+	if b.opens < 1 && b.failures < b.set.config.FailureThreshold {
+		b.mutex.Unlock()
+		return
+	}
+	b.opens++
+	opens := b.opens
+	failures := b.failures
+	backoff := b.set.backoff(opens)
+	b.openUntil = time.Now().Add(backoff)
+	b.mutex.Unlock()
+	if b.set.logger != nil {
+		b.set.logger.Printf("%s: circuit open for %s after %d failures",
+			b.name, backoff.Round(time.Second), failures)
+	}
+	if opens == 1 && b.set.onStateChange != nil {
+		b.set.onStateChange(b.name, true)
+	}
+}
+
+func (b *Breaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.opens > 0 && time.Now().Before(b.openUntil)
+}
+
+func (b *Breaker) success() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	wasOpen := b.opens > 0
+	b.failures = 0
+	b.opens = 0
+	b.openUntil = time.Time{}
+	b.mutex.Unlock()
+	if !wasOpen {
+		return
+	}
+	if b.set.logger != nil {
+		b.set.logger.Printf("%s: circuit closed", b.name)
+	}
+	if b.set.onStateChange != nil {
+		b.set.onStateChange(b.name, false)
+	}
+}
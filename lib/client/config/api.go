@@ -11,11 +11,43 @@ type BaseConfig struct {
 	Username      string `yaml:"username"`
 	FilePrefix    string `yaml:"file_prefix"`
 	AddGroups     bool   `yaml:"add_groups"`
+	// If set, the SSH and X.509 CAs are not added to the trust stores.
+	DisableTrustInstall bool `yaml:"disable_trust_install"`
+	// If set, the client does not check for a newer release.
+	DisableUpdateCheck bool `yaml:"disable_update_check"`
+	// If set, a newer release published by keymaster is installed.
+	AutoUpdate bool `yaml:"auto_update"`
+	// If set, the SSH keys are also written in PuTTY format.
+	PuTTYKeys bool `yaml:"putty_keys"`
+	// If set, the session is not cached in the OS keychain, so every run
+	// logs in again.
+	DisableKeychain bool `yaml:"disable_keychain"`
+}
+
+// KubernetesConfig describes a cluster which accepts the x509-kubernetes
+// certificate. If Server is set, a kubeconfig file using the certificate is
+// written.
+type KubernetesConfig struct {
+	ClusterName string `yaml:"cluster_name"`
+	Server      string `yaml:"server"`
+	CAFilename  string `yaml:"ca_filename"`
+}
+
+// ProxyConfig describes the proxy used to reach keymaster. URL may be an
+// http, https or socks5 URL; if it has a username but no password, the
+// password is prompted for. NoProxy is a comma separated list of host names,
+// domains and CIDR blocks reached directly. Empty values are taken from the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+type ProxyConfig struct {
+	URL     string `yaml:"url"`
+	NoProxy string `yaml:"no_proxy"`
 }
 
 // AppConfigFile represents a keymaster client configuration file
 type AppConfigFile struct {
-	Base BaseConfig
+	Base       BaseConfig
+	Kubernetes KubernetesConfig `yaml:"kubernetes"`
+	Proxy      ProxyConfig      `yaml:"proxy"`
 }
 
 // LoadVerifyConfigFile reads, verifies, and returns the contents of
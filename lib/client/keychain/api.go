@@ -0,0 +1,28 @@
+// Package keychain stores small secrets, such as session cookies, in the
+// credential store of the operating system: the Keychain on macOS, the
+// Credential Manager on Windows and the Secret Service (through the
+// secret-tool command of libsecret) elsewhere.
+package keychain
+
+import (
+	"errors"
+)
+
+// ErrNotFound is returned by Get if no secret is stored.
+var ErrNotFound = errors.New("secret not found in keychain")
+
+// Get returns the secret stored for service and account, or ErrNotFound.
+func Get(service, account string) ([]byte, error) {
+	return get(service, account)
+}
+
+// Set stores secret for service and account, replacing any previous secret.
+func Set(service, account string, secret []byte) error {
+	return set(service, account, secret)
+}
+
+// Delete removes the secret stored for service and account. It is not an
+// error if there is none.
+func Delete(service, account string) error {
+	return del(service, account)
+}
@@ -0,0 +1,61 @@
+package keychain
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// errSecItemNotFound is the exit status of the security command when the
+// item does not exist.
+const errSecItemNotFound = 44
+
+func isNotFound(err error) bool {
+	exitErr, ok := err.(*exec.ExitError)
+	return ok && exitErr.ExitCode() == errSecItemNotFound
+}
+
+func get(service, account string) ([]byte, error) {
+	output, err := exec.Command("security", "find-generic-password",
+		"-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(output)))
+}
+
+func set(service, account string, secret []byte) error {
+	if strings.ContainsAny(service+account, "\"\n") {
+		return fmt.Errorf("invalid service or account name")
+	}
+	// The command is read from standard input, so that the secret does not
+	// show up in the process list.
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf(
+		"add-generic-password -U -s \"%s\" -a \"%s\" -w \"%s\"\n",
+		service, account, base64.StdEncoding.EncodeToString(secret)))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return err
+	}
+	// Interactive mode does not fail when a command does, but the command
+	// prints nothing when it succeeds.
+	if output = bytes.TrimSpace(output); len(output) > 0 {
+		return fmt.Errorf("error storing secret: %s", output)
+	}
+	return nil
+}
+
+func del(service, account string) error {
+	err := exec.Command("security", "delete-generic-password",
+		"-s", service, "-a", account).Run()
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
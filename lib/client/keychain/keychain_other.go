@@ -0,0 +1,58 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package keychain
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// secretToolError returns err with the error message of secret-tool, or
+// ErrNotFound if secret-tool failed without one, which is how it reports a
+// missing secret.
+func secretToolError(err error) error {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return err
+	}
+	if message := strings.TrimSpace(string(exitErr.Stderr)); message != "" {
+		return fmt.Errorf("secret-tool: %s", message)
+	}
+	return ErrNotFound
+}
+
+func get(service, account string) ([]byte, error) {
+	output, err := exec.Command("secret-tool", "lookup",
+		"service", service, "account", account).Output()
+	if err != nil {
+		return nil, secretToolError(err)
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(output)))
+}
+
+func set(service, account string, secret []byte) error {
+	// secret-tool reads the secret from standard input.
+	cmd := exec.Command("secret-tool", "store",
+		"--label="+service+" ("+account+")",
+		"service", service, "account", account)
+	cmd.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString(secret))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool: %s: %s", err,
+			strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func del(service, account string) error {
+	_, err := exec.Command("secret-tool", "clear",
+		"service", service, "account", account).Output()
+	if err != nil {
+		if err := secretToolError(err); err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
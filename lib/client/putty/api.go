@@ -0,0 +1,13 @@
+// Package putty writes SSH keys in the PuTTY private key (.ppk) format, for
+// PuTTY, Pageant and WinSCP on Windows.
+package putty
+
+// MarshalPrivateKey returns the unencrypted private key in PPK version 3
+// format, as used by PuTTY 0.75 and later. The privateKey must be an
+// *rsa.PrivateKey or an ed25519.PrivateKey. The certificate of the key is
+// kept in a separate OpenSSH format file, which PuTTY 0.78 and later load
+// with the "Certificate to use with the private key" setting.
+func MarshalPrivateKey(privateKey interface{}, comment string) (
+	[]byte, error) {
+	return marshalPrivateKey(privateKey, comment)
+}
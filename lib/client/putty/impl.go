@@ -0,0 +1,128 @@
+package putty
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	encryptionNone = "none"
+	fileVersion    = 3
+	lineLength     = 64
+)
+
+type rsaPrivateBlob struct {
+	D    *big.Int
+	P    *big.Int
+	Q    *big.Int
+	IQMP *big.Int
+}
+
+// getPrivateBlob returns the private part of the key in the PuTTY wire
+// format.
+func getPrivateBlob(privateKey interface{}) ([]byte, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if len(key.Primes) != 2 {
+			return nil, errors.New("multi-prime RSA keys are not supported")
+		}
+		key.Precompute()
+		// PuTTY wants the inverse of q mod p, as does crypto/rsa.
+		return ssh.Marshal(rsaPrivateBlob{
+			D:    key.D,
+			P:    key.Primes[0],
+			Q:    key.Primes[1],
+			IQMP: key.Precomputed.Qinv,
+		}), nil
+	case ed25519.PrivateKey:
+		// The seed is stored as an unsigned little endian integer, without
+		// the most significant zero bytes.
+		seed := key.Seed()
+		length := len(seed)
+		for length > 0 && seed[length-1] == 0 {
+			length--
+		}
+		return ssh.Marshal(struct{ Seed []byte }{seed[:length]}), nil
+	case *ed25519.PrivateKey:
+		return getPrivateBlob(*key)
+	}
+	return nil, fmt.Errorf("unsupported key type: %T", privateKey)
+}
+
+func getPublicKey(privateKey interface{}) (ssh.PublicKey, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return ssh.NewPublicKey(&key.PublicKey)
+	case ed25519.PrivateKey:
+		return ssh.NewPublicKey(key.Public())
+	case *ed25519.PrivateKey:
+		return ssh.NewPublicKey(key.Public())
+	}
+	return nil, fmt.Errorf("unsupported key type: %T", privateKey)
+}
+
+// computeMAC returns the MAC of an unencrypted version 3 file, which is keyed
+// with an empty key.
+func computeMAC(algorithm, comment string, publicBlob,
+	privateBlob []byte) []byte {
+	mac := hmac.New(sha256.New, nil)
+	mac.Write(ssh.Marshal(struct {
+		Algorithm   string
+		Encryption  string
+		Comment     string
+		PublicBlob  []byte
+		PrivateBlob []byte
+	}{algorithm, encryptionNone, comment, publicBlob, privateBlob}))
+	return mac.Sum(nil)
+}
+
+func writeBase64Lines(buffer *bytes.Buffer, header string, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	numLines := (len(encoded) + lineLength - 1) / lineLength
+	fmt.Fprintf(buffer, "%s: %d\n", header, numLines)
+	for len(encoded) > lineLength {
+		buffer.WriteString(encoded[:lineLength])
+		buffer.WriteByte('\n')
+		encoded = encoded[lineLength:]
+	}
+	buffer.WriteString(encoded)
+	buffer.WriteByte('\n')
+}
+
+func marshalPrivateKey(privateKey interface{}, comment string) (
+	[]byte, error) {
+	if strings.ContainsAny(comment, "\r\n") {
+		return nil, errors.New("comment must be a single line")
+	}
+	publicKey, err := getPublicKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	privateBlob, err := getPrivateBlob(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	algorithm := publicKey.Type()
+	publicBlob := publicKey.Marshal()
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "PuTTY-User-Key-File-%d: %s\n", fileVersion,
+		algorithm)
+	fmt.Fprintf(&buffer, "Encryption: %s\n", encryptionNone)
+	fmt.Fprintf(&buffer, "Comment: %s\n", comment)
+	writeBase64Lines(&buffer, "Public-Lines", publicBlob)
+	writeBase64Lines(&buffer, "Private-Lines", privateBlob)
+	fmt.Fprintf(&buffer, "Private-MAC: %s\n", hex.EncodeToString(
+		computeMAC(algorithm, comment, publicBlob, privateBlob)))
+	return buffer.Bytes(), nil
+}
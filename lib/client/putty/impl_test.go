@@ -0,0 +1,148 @@
+package putty
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type parsedFile struct {
+	algorithm   string
+	comment     string
+	publicBlob  []byte
+	privateBlob []byte
+	mac         string
+}
+
+func parseFile(t *testing.T, data []byte) parsedFile {
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	getValue := func(header string) string {
+		if len(lines) < 1 || !strings.HasPrefix(lines[0], header+": ") {
+			t.Fatalf("missing %s in:\n%s", header, data)
+		}
+		value := lines[0][len(header)+2:]
+		lines = lines[1:]
+		return value
+	}
+	getBlob := func(header string) []byte {
+		numLines, err := strconv.Atoi(getValue(header))
+		if err != nil || numLines > len(lines) {
+			t.Fatalf("bad %s in:\n%s", header, data)
+		}
+		for _, line := range lines[:numLines-1] {
+			if len(line) != lineLength {
+				t.Fatalf("bad line length: %d", len(line))
+			}
+		}
+		blob, err := base64.StdEncoding.DecodeString(
+			strings.Join(lines[:numLines], ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = lines[numLines:]
+		return blob
+	}
+	var file parsedFile
+	file.algorithm = getValue("PuTTY-User-Key-File-3")
+	if encryption := getValue("Encryption"); encryption != "none" {
+		t.Fatalf("unexpected encryption: %s", encryption)
+	}
+	file.comment = getValue("Comment")
+	file.publicBlob = getBlob("Public-Lines")
+	file.privateBlob = getBlob("Private-Lines")
+	file.mac = getValue("Private-MAC")
+	if len(lines) > 0 {
+		t.Fatalf("trailing lines: %v", lines)
+	}
+	expectedMAC := hex.EncodeToString(computeMAC(file.algorithm,
+		file.comment, file.publicBlob, file.privateBlob))
+	if file.mac != expectedMAC {
+		t.Fatalf("MAC: %s != %s", file.mac, expectedMAC)
+	}
+	return file
+}
+
+func TestMarshalRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalPrivateKey(key, "keymaster-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := parseFile(t, data)
+	if file.algorithm != ssh.KeyAlgoRSA || file.comment != "keymaster-alice" {
+		t.Fatalf("unexpected header: %s %s", file.algorithm, file.comment)
+	}
+	publicKey, err := ssh.ParsePublicKey(file.publicBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPublicKey, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(publicKey.Marshal(), expectedPublicKey.Marshal()) {
+		t.Fatal("public key mismatch")
+	}
+	var privateBlob rsaPrivateBlob
+	if err := ssh.Unmarshal(file.privateBlob, &privateBlob); err != nil {
+		t.Fatal(err)
+	}
+	if privateBlob.D.Cmp(key.D) != 0 ||
+		privateBlob.P.Cmp(key.Primes[0]) != 0 ||
+		privateBlob.Q.Cmp(key.Primes[1]) != 0 {
+		t.Fatal("private key mismatch")
+	}
+	// iqmp * q = 1 mod p
+	product := privateBlob.IQMP.Mul(privateBlob.IQMP, privateBlob.Q)
+	if product.Mod(product, privateBlob.P).Int64() != 1 {
+		t.Fatal("bad iqmp")
+	}
+}
+
+func TestMarshalEd25519(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalPrivateKey(key, "keymaster-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := parseFile(t, data)
+	if file.algorithm != ssh.KeyAlgoED25519 {
+		t.Fatalf("unexpected algorithm: %s", file.algorithm)
+	}
+	var privateBlob struct{ Seed []byte }
+	if err := ssh.Unmarshal(file.privateBlob, &privateBlob); err != nil {
+		t.Fatal(err)
+	}
+	seed := make([]byte, ed25519.SeedSize)
+	copy(seed, privateBlob.Seed)
+	if !ed25519.NewKeyFromSeed(seed).Equal(key) {
+		t.Fatal("private key mismatch")
+	}
+}
+
+func TestMarshalInvalid(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MarshalPrivateKey(key, "two\nlines"); err == nil {
+		t.Fatal("multi-line comment not rejected")
+	}
+	if _, err := MarshalPrivateKey("key", "comment"); err == nil {
+		t.Fatal("unsupported key not rejected")
+	}
+}
@@ -50,6 +50,37 @@ func deleteDuplicateEntries(comment string, agentClient agent.ExtendedAgent, log
 	return deletedCount, nil
 }
 
+func listCerts(comment string, agentClient agent.ExtendedAgent) (
+	[]*ssh.Certificate, error) {
+	keyList, err := agentClient.List()
+	if err != nil {
+		return nil, err
+	}
+	var certs []*ssh.Certificate
+	for _, key := range keyList {
+		if key.Comment != comment {
+			continue
+		}
+		pubKey, err := ssh.ParsePublicKey(key.Marshal())
+		if err != nil {
+			continue
+		}
+		if cert, ok := pubKey.(*ssh.Certificate); ok {
+			certs = append(certs, cert)
+		}
+	}
+	return certs, nil
+}
+
+func listCertsInAgent(comment string) ([]*ssh.Certificate, error) {
+	conn, err := connectToDefaultSSHAgentLocation()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return listCerts(comment, agent.NewClient(conn))
+}
+
 func upsertCertIntoAgent(
 	certText []byte,
 	privateKey interface{},
@@ -154,3 +154,24 @@ func TestDeleteDuplicateEntries(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestListCerts(t *testing.T) {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(demoCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pubKey.(*ssh.Certificate)
+	agentClient := &MockExtendedAgent{keys: []*agent.Key{
+		{Format: cert.Type(), Blob: cert.Marshal(), Comment: "keymaster"},
+		{Format: cert.Type(), Blob: cert.Marshal(), Comment: "other"},
+		{Format: cert.Key.Type(), Blob: cert.Key.Marshal(),
+			Comment: "keymaster"},
+	}}
+	certs, err := listCerts("keymaster", agentClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || certs[0].Serial != cert.Serial {
+		t.Fatalf("expected one certificate, got: %d", len(certs))
+	}
+}
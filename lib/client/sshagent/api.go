@@ -2,6 +2,7 @@ package sshagent
 
 import (
 	"github.com/Cloud-Foundations/golib/pkg/log"
+	"golang.org/x/crypto/ssh"
 )
 
 func UpsertCertIntoAgent(
@@ -12,3 +13,9 @@ func UpsertCertIntoAgent(
 	logger log.Logger) error {
 	return upsertCertIntoAgent(certText, privateKey, comment, lifeTimeSecs, logger)
 }
+
+// ListCertsInAgent returns the certificates in the running SSH agent which
+// were added with comment.
+func ListCertsInAgent(comment string) ([]*ssh.Certificate, error) {
+	return listCertsInAgent(comment)
+}
@@ -38,6 +38,16 @@ func GetHttpClient(tlsConfig *tls.Config,
 	return getHttpClient(tlsConfig, dialer)
 }
 
+// SetProxy makes client, which must have been returned by GetHttpClient, use
+// the proxy at proxyURL except for hosts matching noProxy. proxyURL may be an
+// http, https or socks5 URL, with a username and password for authenticated
+// proxies. noProxy is a comma separated list of host names, domains and CIDR
+// blocks, as in the NO_PROXY environment variable. Empty values are taken from
+// the environment, which GetHttpClient uses by default.
+func SetProxy(client *http.Client, proxyURL, noProxy string) error {
+	return setProxy(client, proxyURL, noProxy)
+}
+
 // GenerateKey generates a random 2048 byte rsa key
 func GenerateKey() (*rsa.PrivateKey, error) {
 	return rsa.GenerateKey(rand.Reader, rsaKeySize)
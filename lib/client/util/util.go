@@ -8,6 +8,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -20,6 +21,7 @@ import (
 	"github.com/Cloud-Foundations/keymaster/lib/client/net"
 	"github.com/howeyc/gopass"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/http/httpproxy"
 	"golang.org/x/net/publicsuffix"
 )
 
@@ -102,15 +104,11 @@ func getHttpClient(tlsConfig *tls.Config,
 		DialContext:     dialer.DialContext,
 	}
 
-	// proxy env variables in ascending order of preference, lower case 'http_proxy' dominates
-	// just like curl
-	proxyEnvVariables := []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy"}
-	for _, proxyVar := range proxyEnvVariables {
-		httpProxy, err := getParseURLEnvVariable(proxyVar)
-		if err == nil && httpProxy != nil {
-			clientTransport.Proxy = http.ProxyURL(httpProxy)
-		}
+	proxyFunc, err := getProxyFunc("", "")
+	if err != nil {
+		return nil, err
 	}
+	clientTransport.Proxy = proxyFunc
 	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 	if err != nil {
 		return nil, err
@@ -133,3 +131,53 @@ func getParseURLEnvVariable(name string) (*url.URL, error) {
 
 	return envUrl, nil
 }
+
+// getProxyFunc returns the proxy selection function for proxyURL, which may
+// be an http, https or socks5 URL including credentials. Hosts matching
+// noProxy, a comma separated list of host names, domains and CIDR blocks, are
+// reached directly. Empty values are taken from the environment.
+func getProxyFunc(proxyURL, noProxy string) (
+	func(*http.Request) (*url.URL, error), error) {
+	config := httpproxy.FromEnvironment()
+	// Keymaster is only reached with https, but older clients used the
+	// http_proxy variables for it.
+	if config.HTTPSProxy == "" {
+		config.HTTPSProxy = config.HTTPProxy
+	}
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("no host in proxy URL: %s", proxyURL)
+		}
+		config.HTTPProxy = proxyURL
+		config.HTTPSProxy = proxyURL
+	}
+	if noProxy != "" {
+		config.NoProxy = noProxy
+	}
+	proxyFunc := config.ProxyFunc()
+	return func(r *http.Request) (*url.URL, error) {
+		return proxyFunc(r.URL)
+	}, nil
+}
+
+func setProxy(client *http.Client, proxyURL, noProxy string) error {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return errors.New("client does not use an http.Transport")
+	}
+	proxyFunc, err := getProxyFunc(proxyURL, noProxy)
+	if err != nil {
+		return err
+	}
+	transport.Proxy = proxyFunc
+	return nil
+}
@@ -0,0 +1,15 @@
+// Package fsutil contains file system utilities shared by the keymaster
+// commands.
+package fsutil
+
+import (
+	"os"
+)
+
+// WriteFileAtomically writes data to filename with the permissions perm, so
+// that readers see either the previous content or all of the new content,
+// never a partial file. The temporary file is created in the same directory,
+// so that it can be renamed over filename.
+func WriteFileAtomically(filename string, data []byte, perm os.FileMode) error {
+	return writeFileAtomically(filename, data, perm)
+}
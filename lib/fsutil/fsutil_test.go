@@ -0,0 +1,49 @@
+package fsutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "file")
+	for _, content := range []string{"first\n", "second\n"} {
+		err := WriteFileAtomically(filename, []byte(content), 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Fatalf("expected: %q, got: %q", content, data)
+		}
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0640 {
+		t.Fatalf("expected mode 0640, got: %o", perm)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %d entries", len(entries))
+	}
+	err = WriteFileAtomically(filepath.Join(dir, "missing", "file"),
+		[]byte("data"), 0640)
+	if err == nil {
+		t.Fatal("write to missing directory succeeded")
+	}
+}
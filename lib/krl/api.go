@@ -0,0 +1,34 @@
+// Package krl generates OpenSSH Key Revocation Lists, as used by the sshd
+// RevokedKeys option. Only revocation of certificates by serial number is
+// supported. The format is described in PROTOCOL.krl in the OpenSSH
+// distribution.
+package krl
+
+import (
+	"time"
+)
+
+// PublicKey is the subset of ssh.PublicKey needed to identify a CA.
+type PublicKey interface {
+	Marshal() []byte
+}
+
+// CertificateSection revokes certificates issued by a CA.
+type CertificateSection struct {
+	CA      PublicKey // If nil, the serials are revoked for any CA.
+	Serials []uint64
+}
+
+// KRL describes a Key Revocation List.
+type KRL struct {
+	Version             uint64 // Should increase each time the KRL changes.
+	GeneratedAt         time.Time
+	Comment             string
+	CertificateSections []CertificateSection
+}
+
+// Marshal returns the binary encoding of the KRL. Serials are sorted and
+// consecutive serials are encoded as ranges.
+func (krl *KRL) Marshal() []byte {
+	return krl.marshal()
+}
@@ -0,0 +1,102 @@
+package krl
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	krlMagic         = 0x5353484b524c0a00
+	krlFormatVersion = 1
+
+	sectionCertificates = 1
+
+	certSectionSerialList  = 0x20
+	certSectionSerialRange = 0x21
+)
+
+type encoder struct {
+	buffer []byte
+}
+
+func (e *encoder) byte(value byte) {
+	e.buffer = append(e.buffer, value)
+}
+
+func (e *encoder) uint32(value uint32) {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], value)
+	e.buffer = append(e.buffer, data[:]...)
+}
+
+func (e *encoder) uint64(value uint64) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], value)
+	e.buffer = append(e.buffer, data[:]...)
+}
+
+func (e *encoder) string(value []byte) {
+	e.uint32(uint32(len(value)))
+	e.buffer = append(e.buffer, value...)
+}
+
+func (e *encoder) section(sectionType byte, data []byte) {
+	e.byte(sectionType)
+	e.string(data)
+}
+
+// marshalSerials encodes the serials as a list of single serials and ranges
+// of consecutive serials.
+func marshalSerials(e *encoder, serials []uint64) {
+	sorted := append([]uint64(nil), serials...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var singles encoder
+	for index := 0; index < len(sorted); {
+		end := index
+		for end+1 < len(sorted) && sorted[end+1] <= sorted[end]+1 {
+			end++
+		}
+		if sorted[end] == sorted[index] {
+			singles.uint64(sorted[index])
+		} else {
+			var rangeData encoder
+			rangeData.uint64(sorted[index])
+			rangeData.uint64(sorted[end])
+			e.section(certSectionSerialRange, rangeData.buffer)
+		}
+		index = end + 1
+	}
+	if len(singles.buffer) > 0 {
+		e.section(certSectionSerialList, singles.buffer)
+	}
+}
+
+func (krl *KRL) marshal() []byte {
+	var e encoder
+	e.uint64(krlMagic)
+	e.uint32(krlFormatVersion)
+	e.uint64(krl.Version)
+	var generatedAt uint64
+	if !krl.GeneratedAt.IsZero() {
+		generatedAt = uint64(krl.GeneratedAt.Unix())
+	}
+	e.uint64(generatedAt)
+	e.uint64(0) // Flags.
+	e.string(nil)
+	e.string([]byte(krl.Comment))
+	for _, section := range krl.CertificateSections {
+		if len(section.Serials) < 1 {
+			continue
+		}
+		var sectionData encoder
+		if section.CA != nil {
+			sectionData.string(section.CA.Marshal())
+		} else {
+			sectionData.string(nil)
+		}
+		sectionData.string(nil)
+		marshalSerials(&sectionData, section.Serials)
+		e.section(sectionCertificates, sectionData.buffer)
+	}
+	return e.buffer
+}
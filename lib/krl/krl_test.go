@@ -0,0 +1,129 @@
+package krl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type rawKey []byte
+
+func (key rawKey) Marshal() []byte {
+	return key
+}
+
+func TestMarshalHeader(t *testing.T) {
+	krl := KRL{
+		Version:     3,
+		GeneratedAt: time.Unix(1000, 0),
+		Comment:     "test",
+		CertificateSections: []CertificateSection{
+			{CA: rawKey("key"), Serials: []uint64{5, 1, 2, 3}},
+			{CA: rawKey("unused")},
+		},
+	}
+	data := krl.Marshal()
+	if !bytes.HasPrefix(data, []byte("SSHKRL\n\x00")) {
+		t.Fatal("missing magic")
+	}
+	if version := binary.BigEndian.Uint64(data[12:]); version != 3 {
+		t.Fatalf("version: %d", version)
+	}
+	if date := binary.BigEndian.Uint64(data[20:]); date != 1000 {
+		t.Fatalf("generated date: %d", date)
+	}
+	if bytes.Contains(data, []byte("unused")) {
+		t.Fatal("empty section included")
+	}
+	// 1..3 is a range and 5 is a single serial.
+	var expected encoder
+	expected.string([]byte("key"))
+	expected.string(nil)
+	var serialRange encoder
+	serialRange.uint64(1)
+	serialRange.uint64(3)
+	expected.section(certSectionSerialRange, serialRange.buffer)
+	var serialList encoder
+	serialList.uint64(5)
+	expected.section(certSectionSerialList, serialList.buffer)
+	var section encoder
+	section.section(sectionCertificates, expected.buffer)
+	if !bytes.HasSuffix(data, section.buffer) {
+		t.Fatal("unexpected certificates section")
+	}
+}
+
+func runSSHKeygen(t *testing.T, dir string, args ...string) error {
+	cmd := exec.Command("ssh-keygen", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Logf("ssh-keygen %s: %s", strings.Join(args, " "), output)
+	}
+	return err
+}
+
+// TestSSHKeygen checks that ssh-keygen agrees with which certificates are
+// revoked.
+func TestSSHKeygen(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+	dir, err := ioutil.TempDir("", "krl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"ca", "user"} {
+		err := runSSHKeygen(t, dir, "-q", "-t", "ed25519", "-N", "", "-f",
+			name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	caLine, err := ioutil.ReadFile(filepath.Join(dir, "ca.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKey, err := base64.StdEncoding.DecodeString(
+		strings.Fields(string(caLine))[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, wildcard := range []bool{false, true} {
+		section := CertificateSection{Serials: []uint64{7, 8, 9, 20}}
+		if !wildcard {
+			section.CA = rawKey(caKey)
+		}
+		krl := KRL{Version: 1, CertificateSections: []CertificateSection{
+			section}}
+		krlFilename := filepath.Join(dir, "krl")
+		if err := ioutil.WriteFile(krlFilename, krl.Marshal(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for serial, revoked := range map[uint64]bool{
+			6: false, 8: true, 10: false, 20: true} {
+			err := runSSHKeygen(t, dir, "-q", "-s", "ca", "-I", "test", "-z",
+				strconv.FormatUint(serial, 10), "user.pub")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := exec.Command("ssh-keygen", "-Q", "-f", "krl",
+				"user-cert.pub")
+			cmd.Dir = dir
+			// ssh-keygen exits with an error if the certificate is revoked.
+			if err := cmd.Run(); (err != nil) != revoked {
+				t.Fatalf("serial %d (wildcard=%v): revoked=%v, expected %v",
+					serial, wildcard, err != nil, revoked)
+			}
+		}
+	}
+}
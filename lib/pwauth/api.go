@@ -1,9 +1,29 @@
 package pwauth
 
 import (
+	"errors"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
 	"github.com/Cloud-Foundations/keymaster/lib/simplestorage"
 )
 
+var (
+	// ErrIncorrectPassword is returned by ChangePassword if the old password
+	// is not correct.
+	ErrIncorrectPassword = errors.New("incorrect password")
+	// ErrPasswordRejected is returned by ChangePassword if the backend does
+	// not accept the new password, typically because of its password policy.
+	ErrPasswordRejected = errors.New("new password rejected")
+	// ErrUnknownBackend is returned by New if no backend was registered with
+	// the requested name.
+	ErrUnknownBackend = errors.New("unknown password backend")
+)
+
+// Factory creates a PasswordAuthenticator for a registered backend. The
+// options are the backend specific settings from the configuration.
+type Factory func(options map[string]string, logger log.DebugLogger) (
+	PasswordAuthenticator, error)
+
 // PasswordAuthenticator is an interface type that defines how to authenticate a
 // user with a username and password.
 type PasswordAuthenticator interface {
@@ -13,3 +33,31 @@ type PasswordAuthenticator interface {
 	PasswordAuthenticate(username string, password []byte) (bool, error)
 	UpdateStorage(storage simplestorage.SimpleStore) error
 }
+
+// PasswordChanger is implemented by password authenticators which can change
+// the password of a user.
+type PasswordChanger interface {
+	// ChangePassword changes the password of the user from oldPassword to
+	// newPassword. The old password is checked by the backend itself.
+	ChangePassword(username string, oldPassword, newPassword []byte) error
+}
+
+// New creates a PasswordAuthenticator using the backend registered with name.
+// If there is no such backend, ErrUnknownBackend is returned.
+func New(name string, options map[string]string, logger log.DebugLogger) (
+	PasswordAuthenticator, error) {
+	return newAuthenticator(name, options, logger)
+}
+
+// Register makes a backend available to New under name. It is intended to be
+// called from the init function of the package implementing the backend, so
+// that a binary only needs to import that package. Register panics if name is
+// empty, factory is nil or name is already registered.
+func Register(name string, factory Factory) {
+	register(name, factory)
+}
+
+// Registered returns the sorted names of the registered backends.
+func Registered() []string {
+	return registered()
+}
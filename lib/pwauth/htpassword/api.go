@@ -1,6 +1,9 @@
 package htpassword
 
 import (
+	"sync"
+	"time"
+
 	"github.com/Cloud-Foundations/golib/pkg/log"
 	"github.com/Cloud-Foundations/keymaster/lib/pwauth"
 	"github.com/Cloud-Foundations/keymaster/lib/simplestorage"
@@ -9,15 +12,20 @@ import (
 type PasswordAuthenticator struct {
 	filename string
 	logger   log.DebugLogger
+	mutex    sync.Mutex // Protect everything below.
+	content  []byte
+	modTime  time.Time
+	size     int64
 }
 
 // Static interface compatibility check.
 var _ = pwauth.PasswordAuthenticator(&PasswordAuthenticator{})
 
 // New creates a new PasswordAuthenticator. The htpassword file used to
-// authenticate the user is filename. Log messages are written to logger. A new
-// *PasswordAuthenticator is returned if the file exists, else an error is
-// returned.
+// authenticate the user is filename; it is reloaded when it changes. Entries
+// may use bcrypt, SHA-256-crypt or SHA-512-crypt hashes. Log messages are
+// written to logger. A new *PasswordAuthenticator is returned if the file
+// exists and can be parsed, else an error is returned.
 func New(filename string,
 	logger log.DebugLogger) (*PasswordAuthenticator, error) {
 	return newAuthenticator(filename, logger)
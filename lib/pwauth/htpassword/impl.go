@@ -7,6 +7,7 @@ import (
 
 	"github.com/Cloud-Foundations/golib/pkg/log"
 	"github.com/Cloud-Foundations/keymaster/lib/authutil"
+	"github.com/foomo/htpasswd"
 )
 
 func newAuthenticator(filename string,
@@ -16,19 +17,60 @@ func newAuthenticator(filename string,
 	} else if fi.Mode()&os.ModeType != 0 {
 		return nil, fmt.Errorf("%s is not a regular file", filename)
 	}
-	return &PasswordAuthenticator{
+	pa := &PasswordAuthenticator{
 		filename: filename,
 		logger:   logger,
-	}, nil
+	}
+	if err := pa.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return pa, nil
+}
+
+// reloadIfChanged reads the file again if its modification time or size
+// changed. If the new content cannot be parsed, the previous content is kept
+// so that a partially written file does not lock everyone out.
+func (pa *PasswordAuthenticator) reloadIfChanged() error {
+	fi, err := os.Stat(pa.filename)
+	if err != nil {
+		return err
+	}
+	if pa.content != nil && fi.ModTime().Equal(pa.modTime) &&
+		fi.Size() == pa.size {
+		return nil
+	}
+	content, err := ioutil.ReadFile(pa.filename)
+	if err != nil {
+		return err
+	}
+	if _, err := htpasswd.ParseHtpasswd(content); err != nil {
+		if pa.content == nil {
+			return fmt.Errorf("cannot parse %s: %s", pa.filename, err)
+		}
+		pa.logger.Printf("cannot parse %s, keeping previous content: %s\n",
+			pa.filename, err)
+		return nil
+	}
+	if pa.content != nil {
+		pa.logger.Printf("reloaded htpassword file: %s\n", pa.filename)
+	}
+	pa.content = content
+	pa.modTime = fi.ModTime()
+	pa.size = fi.Size()
+	return nil
 }
 
 func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
 	password []byte) (bool, error) {
 	pa.logger.Debugf(3, "checking %s in htpassword file\n", username)
-	buffer, err := ioutil.ReadFile(pa.filename)
+	pa.mutex.Lock()
+	err := pa.reloadIfChanged()
+	content := pa.content
+	pa.mutex.Unlock()
 	if err != nil {
-		return false, err
+		pa.logger.Printf("cannot reload %s, using previous content: %s\n",
+			pa.filename, err)
 	}
 	return authutil.CheckHtpasswdUserPassword(username, string(password),
-		buffer)
+		content)
 }
@@ -0,0 +1,82 @@
+package htpassword
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+)
+
+// Both users have password 'password'.
+const (
+	bcryptUser = `username:$2y$05$D4qQmZbWYqfgtGtez2EGdOkcNne40EdEznOqMvZegQypT8Jdz42Jy`
+	sha512User = `sha512user:$6$GbQhnXLyTH0P7jZe$Y1XdPeAba2GCNxIGFsLWIgqAYi.bKK8vZFnoYLsg78XzyycbSo85yHfBL9MveAMnq3BKO2zmrsPgNM.qu.ZET/`
+)
+
+func writeFile(t *testing.T, filename, content string, modTime time.Time) {
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filename, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkPassword(t *testing.T, pa *PasswordAuthenticator, username string,
+	expected bool) {
+	ok, err := pa.PasswordAuthenticate(username, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != expected {
+		t.Fatalf("%s: authenticated=%v, expected %v", username, ok, expected)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpassword_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "passfile.htpass")
+	modTime := time.Now().Add(-time.Hour)
+	writeFile(t, filename, bcryptUser, modTime)
+	pa, err := New(filename, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPassword(t, pa, "username", true)
+	checkPassword(t, pa, "sha512user", false)
+	modTime = modTime.Add(time.Minute)
+	writeFile(t, filename, sha512User, modTime)
+	checkPassword(t, pa, "username", false)
+	checkPassword(t, pa, "sha512user", true)
+	// A broken file does not replace the previous content.
+	modTime = modTime.Add(time.Minute)
+	writeFile(t, filename, "invalidfilecontents", modTime)
+	checkPassword(t, pa, "sha512user", true)
+	modTime = modTime.Add(time.Minute)
+	writeFile(t, filename, bcryptUser+"\n"+sha512User, modTime)
+	checkPassword(t, pa, "username", true)
+	checkPassword(t, pa, "sha512user", true)
+}
+
+func TestNewFailInvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpassword_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := New(dir, testlogger.New(t)); err == nil {
+		t.Fatal("no error for directory")
+	}
+	filename := filepath.Join(dir, "passfile.htpass")
+	writeFile(t, filename, "invalidfilecontents", time.Now())
+	if _, err := New(filename, testlogger.New(t)); err == nil {
+		t.Fatal("no error for invalid file")
+	}
+}
@@ -0,0 +1,51 @@
+package pwauth
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+)
+
+var (
+	factoriesMutex sync.RWMutex
+	factories      = make(map[string]Factory)
+)
+
+func newAuthenticator(name string, options map[string]string,
+	logger log.DebugLogger) (PasswordAuthenticator, error) {
+	factoriesMutex.RLock()
+	factory, ok := factories[name]
+	factoriesMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, name)
+	}
+	return factory(options, logger)
+}
+
+func register(name string, factory Factory) {
+	if name == "" {
+		panic("pwauth: Register with empty name")
+	}
+	if factory == nil {
+		panic("pwauth: Register with nil factory for " + name)
+	}
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("pwauth: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+func registered() []string {
+	factoriesMutex.RLock()
+	defer factoriesMutex.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,70 @@
+package pwauth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+	"github.com/Cloud-Foundations/keymaster/lib/simplestorage"
+)
+
+type staticAuthenticator struct {
+	password string
+}
+
+func (sa *staticAuthenticator) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return string(password) == sa.password, nil
+}
+
+func (sa *staticAuthenticator) UpdateStorage(
+	storage simplestorage.SimpleStore) error {
+	return nil
+}
+
+func newStaticAuthenticator(options map[string]string,
+	logger log.DebugLogger) (PasswordAuthenticator, error) {
+	if options["password"] == "" {
+		return nil, errors.New("no password option")
+	}
+	return &staticAuthenticator{password: options["password"]}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	logger := testlogger.New(t)
+	Register("static-test", newStaticAuthenticator)
+	found := false
+	for _, name := range Registered() {
+		if name == "static-test" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("static-test not in %v", Registered())
+	}
+	pa, err := New("static-test", map[string]string{"password": "secret"},
+		logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := pa.PasswordAuthenticate("user", []byte("secret")); !ok {
+		t.Fatal("correct password rejected")
+	}
+	if ok, _ := pa.PasswordAuthenticate("user", []byte("wrong")); ok {
+		t.Fatal("wrong password accepted")
+	}
+	if _, err := New("static-test", nil, logger); err == nil {
+		t.Fatal("factory error not returned")
+	}
+	if _, err := New("missing", nil, logger); !errors.Is(err,
+		ErrUnknownBackend) {
+		t.Fatalf("expected ErrUnknownBackend, got: %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate registration did not panic")
+		}
+	}()
+	Register("static-test", newStaticAuthenticator)
+}
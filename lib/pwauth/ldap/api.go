@@ -1,11 +1,13 @@
 package ldap
 
 import (
-	"crypto/x509"
+	"crypto/tls"
 	"net/url"
 	"time"
 
 	"github.com/Cloud-Foundations/golib/pkg/log"
+	"github.com/Cloud-Foundations/keymaster/lib/circuitbreaker"
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth"
 	"github.com/Cloud-Foundations/keymaster/lib/simplestorage"
 )
 
@@ -18,16 +20,40 @@ type PasswordAuthenticator struct {
 	ldapURL            []*url.URL
 	bindPattern        []string
 	timeoutSecs        uint
-	rootCAs            *x509.CertPool
+	tlsConfig          *tls.Config
 	logger             log.DebugLogger
 	expirationDuration time.Duration
 	storage            simplestorage.SimpleStore
 	cachedCredentials  map[string]cacheCredentialEntry
+	breakers           *circuitbreaker.Set
+	bindObserver       BindObserver
 }
 
-func New(url []string, bindPattern []string, timeoutSecs uint, rootCAs *x509.CertPool, storage simplestorage.SimpleStore, logger log.DebugLogger) (
+// BindObserver is called after each bind to check a password, with the
+// host:port of the server, how long the bind took and the error, if any. A
+// bind rejected for invalid credentials is not an error.
+type BindObserver func(server string, duration time.Duration, err error)
+
+// Static interface compatibility checks.
+var _ = pwauth.PasswordAuthenticator(&PasswordAuthenticator{})
+var _ = pwauth.PasswordChanger(&PasswordAuthenticator{})
+
+func New(url []string, bindPattern []string, timeoutSecs uint, tlsConfig *tls.Config, storage simplestorage.SimpleStore, logger log.DebugLogger) (
 	*PasswordAuthenticator, error) {
-	return newAuthenticator(url, bindPattern, timeoutSecs, rootCAs, storage, logger)
+	return newAuthenticator(url, bindPattern, timeoutSecs, tlsConfig, storage, logger)
+}
+
+// SetCircuitBreakers makes the authenticator skip servers whose circuit is
+// open. The breakers are named by the host:port of the servers.
+func (pa *PasswordAuthenticator) SetCircuitBreakers(
+	breakers *circuitbreaker.Set) {
+	pa.breakers = breakers
+}
+
+// SetBindObserver registers a function to be called after each bind, such
+// as to record latency metrics.
+func (pa *PasswordAuthenticator) SetBindObserver(observer BindObserver) {
+	pa.bindObserver = observer
 }
 
 func (pa *PasswordAuthenticator) UpdateStorage(storage simplestorage.SimpleStore) error {
@@ -44,3 +70,12 @@ func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
 	password []byte) (bool, error) {
 	return pa.passwordAuthenticate(username, password)
 }
+
+// ChangePassword changes the password of the user in the directory, after
+// binding with the old password. The cached password hash is updated.
+// It returns pwauth.ErrIncorrectPassword or pwauth.ErrPasswordRejected if the
+// directory did not accept the old or new password respectively.
+func (pa *PasswordAuthenticator) ChangePassword(username string,
+	oldPassword, newPassword []byte) error {
+	return pa.changePassword(username, oldPassword, newPassword)
+}
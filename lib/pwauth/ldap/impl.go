@@ -1,13 +1,14 @@
 package ldap
 
 import (
-	"crypto/x509"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/Cloud-Foundations/golib/pkg/log"
 	"github.com/Cloud-Foundations/keymaster/lib/authutil"
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth"
 	"github.com/Cloud-Foundations/keymaster/lib/simplestorage"
 )
 
@@ -16,7 +17,7 @@ const passwordDataType = 1
 const browserResponseTimeoutSeconds = 7
 
 func newAuthenticator(urllist []string, bindPattern []string,
-	timeoutSecs uint, rootCAs *x509.CertPool,
+	timeoutSecs uint, tlsConfig *tls.Config,
 	storage simplestorage.SimpleStore, logger log.DebugLogger) (
 	*PasswordAuthenticator, error) {
 	var authenticator PasswordAuthenticator
@@ -32,7 +33,7 @@ func newAuthenticator(urllist []string, bindPattern []string,
 	if timeoutSecs*uint(len(authenticator.ldapURL)) > uint(browserResponseTimeoutSeconds) {
 		authenticator.timeoutSecs = uint(browserResponseTimeoutSeconds) / uint(len(authenticator.ldapURL))
 	}
-	authenticator.rootCAs = rootCAs
+	authenticator.tlsConfig = tlsConfig
 	authenticator.logger = logger
 	authenticator.expirationDuration = defaultCacheDuration
 	authenticator.storage = storage
@@ -83,15 +84,29 @@ func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
 	password []byte) (valid bool, err error) {
 	valid = false
 	for _, u := range pa.ldapURL {
+		breaker := pa.breakers.Get(u.Host)
 		for _, bindPattern := range pa.bindPattern {
+			if !breaker.Allow() {
+				if pa.logger != nil {
+					pa.logger.Debugf(1, "Skipping LDAP server: %s, circuit open",
+						u.Host)
+				}
+				break
+			}
 			bindDN := convertToBindDN(username, bindPattern)
-			valid, err = authutil.CheckLDAPUserPassword(*u, bindDN, string(password), pa.timeoutSecs, pa.rootCAs)
+			start := time.Now()
+			valid, err = authutil.CheckLDAPUserPassword(*u, bindDN, string(password), pa.timeoutSecs, pa.tlsConfig)
+			if pa.bindObserver != nil {
+				pa.bindObserver(u.Host, time.Since(start), err)
+			}
 			if err != nil {
+				breaker.Failure()
 				if pa.logger != nil {
 					pa.logger.Debugf(1, "Error checking LDAP user password url= %s", u)
 				}
 				continue
 			}
+			breaker.Success()
 			err = pa.updateOrDeletePasswordHash(valid, username, password)
 			if err != nil && pa.logger != nil {
 				pa.logger.Debugf(0, "Updating local password hash for user %s", username)
@@ -119,3 +134,51 @@ func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
 
 	return false, nil
 }
+
+func (pa *PasswordAuthenticator) changePassword(username string,
+	oldPassword, newPassword []byte) error {
+	var lastErr error
+	for _, u := range pa.ldapURL {
+		breaker := pa.breakers.Get(u.Host)
+		for _, bindPattern := range pa.bindPattern {
+			if !breaker.Allow() {
+				lastErr = fmt.Errorf("%s: circuit open", u.Host)
+				break
+			}
+			bindDN := convertToBindDN(username, bindPattern)
+			changed, err := authutil.ChangeLDAPUserPassword(*u, bindDN,
+				string(oldPassword), string(newPassword), pa.timeoutSecs,
+				pa.tlsConfig)
+			if err != nil {
+				if authutil.IsLDAPPasswordRejected(err) {
+					breaker.Success()
+					return fmt.Errorf("%w: %s", pwauth.ErrPasswordRejected, err)
+				}
+				breaker.Failure()
+				if pa.logger != nil {
+					pa.logger.Debugf(1,
+						"Error changing LDAP user password url= %s", u)
+				}
+				lastErr = err
+				continue
+			}
+			breaker.Success()
+			if !changed {
+				return pwauth.ErrIncorrectPassword
+			}
+			if pa.storage != nil {
+				err := pa.updateOrDeletePasswordHash(true, username,
+					newPassword)
+				if err != nil && pa.logger != nil {
+					pa.logger.Debugf(0,
+						"Updating local password hash for user %s", username)
+				}
+			}
+			return nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no LDAP servers")
+	}
+	return lastErr
+}
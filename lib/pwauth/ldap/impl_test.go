@@ -109,11 +109,19 @@ func TestPasswordAuthetnicateSimple(t *testing.T) {
 	if !ok {
 		t.Fatal("cannot add certs to certpool")
 	}
-	authn, err := newAuthenticator([]string{localLDAPSURL}, []string{"%s"}, 0, certPool, nil, nil)
+	authn, err := newAuthenticator([]string{localLDAPSURL}, []string{"%s"}, 0, &tls.Config{RootCAs: certPool}, nil, nil)
 	//ok, err := CheckHtpasswdUserPassword("username", "password", []byte(userdbContent))
 	if err != nil {
 		t.Fatal(err)
 	}
+	var binds []string
+	authn.SetBindObserver(func(server string, duration time.Duration,
+		err error) {
+		if err != nil {
+			t.Errorf("bind to %s failed: %s", server, err)
+		}
+		binds = append(binds, server)
+	})
 
 	serverMmutex.Lock()
 	serverConfig.ValidUser = "username"
@@ -135,7 +143,9 @@ func TestPasswordAuthetnicateSimple(t *testing.T) {
 	if ok != false {
 		t.Fatal("User considerd true")
 	}
-
+	if len(binds) != 2 || binds[0] != "localhost:10640" {
+		t.Fatalf("unexpected binds: %v", binds)
+	}
 }
 
 func TestPasswordAuthetnicateCache(t *testing.T) {
@@ -145,7 +155,7 @@ func TestPasswordAuthetnicateCache(t *testing.T) {
 		t.Fatal("cannot add certs to certpool")
 	}
 	cache := memstore.New()
-	authn, err := newAuthenticator([]string{localLDAPSURL}, []string{"%s"}, 1, certPool, cache, nil)
+	authn, err := newAuthenticator([]string{localLDAPSURL}, []string{"%s"}, 1, &tls.Config{RootCAs: certPool}, cache, nil)
 	//ok, err := CheckHtpasswdUserPassword("username", "password", []byte(userdbContent))
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,48 @@
+// Package ldaptest provides an in-process LDAP server for tests of password
+// authentication against LDAP. The server listens on a random port of
+// 127.0.0.1 with LDAPS, using a self-signed certificate, and only answers
+// simple binds.
+package ldaptest
+
+import (
+	"sync"
+
+	"github.com/vjeantet/ldapserver"
+)
+
+// Server is a running LDAP server.
+type Server struct {
+	caPEM  []byte
+	server *ldapserver.Server
+	url    string
+	mutex  sync.Mutex        // Protect everything below.
+	users  map[string]string // Key: bind DN, value: password.
+}
+
+// New starts a server which accepts binds with the DNs and passwords in
+// users. It must be stopped with Close.
+func New(users map[string]string) (*Server, error) {
+	return newServer(users)
+}
+
+// CACertificatePEM returns the certificate of the server in PEM format, for
+// adding to the trusted CAs of the client.
+func (s *Server) CACertificatePEM() []byte {
+	return s.caPEM
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.server.Stop()
+}
+
+// SetPassword adds a user or changes the password of a user. An empty
+// password removes the user.
+func (s *Server) SetPassword(bindDN string, password string) {
+	s.setPassword(bindDN, password)
+}
+
+// URL returns the ldaps URL of the server.
+func (s *Server) URL() string {
+	return s.url
+}
@@ -0,0 +1,119 @@
+package ldaptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/vjeantet/ldapserver"
+)
+
+func newServer(users map[string]string) (*Server, error) {
+	certificate, caPEM, err := makeCertificate()
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		caPEM:  caPEM,
+		server: ldapserver.NewServer(),
+		users:  make(map[string]string, len(users)),
+	}
+	for bindDN, password := range users {
+		s.users[bindDN] = password
+	}
+	routes := ldapserver.NewRouteMux()
+	routes.Bind(s.handleBind)
+	s.server.Handle(routes)
+	addressChannel := make(chan string, 1)
+	errorChannel := make(chan error, 1)
+	go func() {
+		errorChannel <- s.server.ListenAndServe("127.0.0.1:0",
+			func(server *ldapserver.Server) {
+				addressChannel <- server.Listener.Addr().String()
+				server.Listener = tls.NewListener(server.Listener,
+					&tls.Config{
+						Certificates: []tls.Certificate{certificate},
+						MinVersion:   tls.VersionTLS12,
+					})
+			})
+	}()
+	select {
+	case address := <-addressChannel:
+		s.url = "ldaps://" + address
+		return s, nil
+	case err := <-errorChannel:
+		return nil, err
+	}
+}
+
+// makeCertificate returns a self-signed certificate for 127.0.0.1 and
+// localhost, and the certificate in PEM format.
+func makeCertificate() (tls.Certificate, []byte, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader,
+		new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "ldaptest"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	derCert, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		&privateKey.PublicKey, privateKey)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	certificate := tls.Certificate{
+		Certificate: [][]byte{derCert},
+		PrivateKey:  privateKey,
+	}
+	return certificate, pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: derCert}), nil
+}
+
+func (s *Server) handleBind(w ldapserver.ResponseWriter,
+	m *ldapserver.Message) {
+	r := m.GetBindRequest()
+	s.mutex.Lock()
+	password, ok := s.users[string(r.Name())]
+	s.mutex.Unlock()
+	if ok && password != "" &&
+		string(r.AuthenticationSimple()) == password {
+		w.Write(ldapserver.NewBindResponse(ldapserver.LDAPResultSuccess))
+		return
+	}
+	res := ldapserver.NewBindResponse(
+		ldapserver.LDAPResultInvalidCredentials)
+	res.SetDiagnosticMessage("invalid credentials")
+	w.Write(res)
+}
+
+func (s *Server) setPassword(bindDN string, password string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if password == "" {
+		delete(s.users, bindDN)
+	} else {
+		s.users[bindDN] = password
+	}
+}
@@ -0,0 +1,57 @@
+package ldaptest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth/ldap"
+)
+
+func TestServer(t *testing.T) {
+	server, err := New(map[string]string{
+		"uid=alice,dc=example,dc=com": "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(server.CACertificatePEM()) {
+		t.Fatal("cannot add certificate of server")
+	}
+	authenticator, err := ldap.New([]string{server.URL()},
+		[]string{"uid=%s,dc=example,dc=com"}, 2,
+		&tls.Config{RootCAs: rootCAs}, nil, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		username string
+		password string
+		valid    bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "wrong", false},
+		{"bob", "secret", false},
+	} {
+		valid, err := authenticator.PasswordAuthenticate(test.username,
+			[]byte(test.password))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != test.valid {
+			t.Fatalf("%s/%s: expected %v, got %v", test.username,
+				test.password, test.valid, valid)
+		}
+	}
+	server.SetPassword("uid=bob,dc=example,dc=com", "secret")
+	valid, err := authenticator.PasswordAuthenticate("bob", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid {
+		t.Fatal("added user rejected")
+	}
+}
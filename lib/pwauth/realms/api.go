@@ -0,0 +1,64 @@
+package realms
+
+import (
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth"
+	"github.com/Cloud-Foundations/keymaster/lib/simplestorage"
+)
+
+// Realm is a password backend for a subset of the users.
+type Realm struct {
+	Name string
+	// Usernames ending in one of these belong to the realm. The suffix is
+	// removed before the username is passed to the Authenticator. Usernames
+	// without a known suffix are tried against every realm in order.
+	UsernameSuffixes []string
+	Authenticator    pwauth.PasswordAuthenticator
+}
+
+type PasswordAuthenticator struct {
+	realms []Realm
+	logger log.DebugLogger
+}
+
+// Static interface compatibility checks.
+var _ = pwauth.PasswordAuthenticator(&PasswordAuthenticator{})
+var _ = pwauth.PasswordChanger(&PasswordAuthenticator{})
+
+// New creates a new PasswordAuthenticator which authenticates users against
+// the realm they belong to. Log messages are written to logger.
+func New(realms []Realm, logger log.DebugLogger) (
+	*PasswordAuthenticator, error) {
+	return newAuthenticator(realms, logger)
+}
+
+// PasswordAuthenticate will authenticate a user using the provided username and
+// password.
+// It returns true if the user is authenticated, else false (due to either
+// invalid username or incorrect password), and an error.
+func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return pa.passwordAuthenticate(username, password)
+}
+
+// ChangePassword changes the password of a user in the realm they belong to.
+// Without a suffix, the password is changed in the first realm which accepts
+// the old password. Realms whose authenticator cannot change passwords are
+// skipped.
+func (pa *PasswordAuthenticator) ChangePassword(username string,
+	oldPassword, newPassword []byte) error {
+	return pa.changePassword(username, oldPassword, newPassword)
+}
+
+// UpdateStorage passes storage on to the authenticators of all realms.
+func (pa *PasswordAuthenticator) UpdateStorage(
+	storage simplestorage.SimpleStore) error {
+	return pa.updateStorage(storage)
+}
+
+// SelectRealm returns the index of the realm username belongs to by its
+// suffix and the username without the suffix. If there is none, it returns
+// -1 and username unchanged.
+func SelectRealm(realms []Realm, username string) (int, string) {
+	return selectRealm(realms, username)
+}
@@ -0,0 +1,136 @@
+package realms
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Cloud-Foundations/golib/pkg/log"
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth"
+	"github.com/Cloud-Foundations/keymaster/lib/simplestorage"
+)
+
+func newAuthenticator(realms []Realm, logger log.DebugLogger) (
+	*PasswordAuthenticator, error) {
+	if len(realms) < 1 {
+		return nil, errors.New("no realms")
+	}
+	for _, realm := range realms {
+		if realm.Authenticator == nil {
+			return nil, errors.New("no authenticator for realm: " +
+				realm.Name)
+		}
+	}
+	return &PasswordAuthenticator{realms: realms, logger: logger}, nil
+}
+
+func selectRealm(realms []Realm, username string) (int, string) {
+	lowerUsername := strings.ToLower(username)
+	for index, realm := range realms {
+		for _, suffix := range realm.UsernameSuffixes {
+			if len(username) > len(suffix) &&
+				strings.HasSuffix(lowerUsername, strings.ToLower(suffix)) {
+				return index, username[:len(username)-len(suffix)]
+			}
+		}
+	}
+	return -1, username
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
+	password []byte) (bool, error) {
+	if index, realmUsername := selectRealm(pa.realms,
+		username); index >= 0 {
+		return pa.realms[index].Authenticator.PasswordAuthenticate(
+			realmUsername, password)
+	}
+	// Errors are only returned if no realm could give an answer.
+	var firstErr error
+	answered := false
+	for _, realm := range pa.realms {
+		valid, err := realm.Authenticator.PasswordAuthenticate(username,
+			password)
+		if err != nil {
+			if pa.logger != nil {
+				pa.logger.Debugf(1, "error checking password in realm: %s: %s",
+					realm.Name, err)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		answered = true
+		if valid {
+			if pa.logger != nil {
+				pa.logger.Debugf(1, "authenticated %s in realm: %s",
+					username, realm.Name)
+			}
+			return true, nil
+		}
+	}
+	if answered {
+		return false, nil
+	}
+	return false, firstErr
+}
+
+func (pa *PasswordAuthenticator) changePassword(username string,
+	oldPassword, newPassword []byte) error {
+	if index, realmUsername := selectRealm(pa.realms,
+		username); index >= 0 {
+		changer, ok := pa.realms[index].Authenticator.(pwauth.PasswordChanger)
+		if !ok {
+			return errors.New("cannot change passwords in realm: " +
+				pa.realms[index].Name)
+		}
+		return changer.ChangePassword(realmUsername, oldPassword, newPassword)
+	}
+	// As for authentication, other errors are only returned if no realm
+	// could check the old password.
+	var firstErr error
+	answered := false
+	for _, realm := range pa.realms {
+		changer, ok := realm.Authenticator.(pwauth.PasswordChanger)
+		if !ok {
+			continue
+		}
+		err := changer.ChangePassword(username, oldPassword, newPassword)
+		switch {
+		case err == nil:
+			if pa.logger != nil {
+				pa.logger.Debugf(1, "changed password of %s in realm: %s",
+					username, realm.Name)
+			}
+			return nil
+		case err == pwauth.ErrIncorrectPassword:
+			answered = true
+		case errors.Is(err, pwauth.ErrPasswordRejected):
+			return err
+		default:
+			if pa.logger != nil {
+				pa.logger.Debugf(1, "error changing password in realm: %s: %s",
+					realm.Name, err)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if answered {
+		return pwauth.ErrIncorrectPassword
+	}
+	if firstErr == nil {
+		return errors.New("cannot change passwords in any realm")
+	}
+	return firstErr
+}
+
+func (pa *PasswordAuthenticator) updateStorage(
+	storage simplestorage.SimpleStore) error {
+	for _, realm := range pa.realms {
+		if err := realm.Authenticator.UpdateStorage(storage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package realms
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Cloud-Foundations/golib/pkg/log/testlogger"
+	"github.com/Cloud-Foundations/keymaster/lib/pwauth"
+	"github.com/Cloud-Foundations/keymaster/lib/simplestorage"
+)
+
+type testAuthenticator struct {
+	users     map[string]string
+	err       error
+	attempted []string
+}
+
+func (ta *testAuthenticator) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	ta.attempted = append(ta.attempted, username)
+	if ta.err != nil {
+		return false, ta.err
+	}
+	return ta.users[username] == string(password), nil
+}
+
+func (ta *testAuthenticator) ChangePassword(username string,
+	oldPassword, newPassword []byte) error {
+	if ta.err != nil {
+		return ta.err
+	}
+	if ta.users[username] != string(oldPassword) {
+		return pwauth.ErrIncorrectPassword
+	}
+	if len(newPassword) < 2 {
+		return fmt.Errorf("%w: too short", pwauth.ErrPasswordRejected)
+	}
+	ta.users[username] = string(newPassword)
+	return nil
+}
+
+func (ta *testAuthenticator) UpdateStorage(
+	storage simplestorage.SimpleStore) error {
+	return nil
+}
+
+func TestPasswordAuthenticate(t *testing.T) {
+	acme := &testAuthenticator{users: map[string]string{"alice": "a"}}
+	widgets := &testAuthenticator{users: map[string]string{"bob": "b"}}
+	pa, err := New([]Realm{
+		{Name: "acme", UsernameSuffixes: []string{"@acme"},
+			Authenticator: acme},
+		{Name: "widgets", UsernameSuffixes: []string{"@widgets"},
+			Authenticator: widgets},
+	}, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		username string
+		password string
+		valid    bool
+	}{
+		{"alice@acme", "a", true},
+		{"alice@ACME", "a", true},
+		{"alice@widgets", "a", false},
+		{"bob@widgets", "b", true},
+		{"bob", "b", true},
+		{"bob", "a", false},
+		{"carol", "c", false},
+	}
+	for _, test := range tests {
+		valid, err := pa.PasswordAuthenticate(test.username,
+			[]byte(test.password))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != test.valid {
+			t.Errorf("%s: valid=%v, expected %v", test.username, valid,
+				test.valid)
+		}
+	}
+	// Suffixed usernames only go to their realm, with the suffix removed.
+	expected := []string{"alice", "alice", "bob", "bob", "carol"}
+	if len(acme.attempted) != len(expected) {
+		t.Fatalf("unexpected attempts: %v", acme.attempted)
+	}
+	for index, username := range acme.attempted {
+		if username != expected[index] {
+			t.Fatalf("unexpected attempts: %v", acme.attempted)
+		}
+	}
+}
+
+func TestPasswordAuthenticateErrors(t *testing.T) {
+	broken := &testAuthenticator{err: errors.New("unreachable")}
+	working := &testAuthenticator{users: map[string]string{"bob": "b"}}
+	pa, err := New([]Realm{
+		{Name: "broken", Authenticator: broken},
+		{Name: "working", Authenticator: working},
+	}, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := pa.PasswordAuthenticate("bob", []byte("b")); !valid {
+		t.Fatalf("not authenticated by later realm: %s", err)
+	}
+	if valid, err := pa.PasswordAuthenticate("bob", []byte("x")); valid ||
+		err != nil {
+		t.Fatalf("valid=%v, err=%v", valid, err)
+	}
+	pa, err = New([]Realm{{Name: "broken", Authenticator: broken}},
+		testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pa.PasswordAuthenticate("bob", []byte("b")); err == nil {
+		t.Fatal("no error when no realm could answer")
+	}
+	if _, err := New(nil, testlogger.New(t)); err == nil {
+		t.Fatal("no error for no realms")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	broken := &testAuthenticator{err: errors.New("unreachable")}
+	acme := &testAuthenticator{users: map[string]string{"alice": "a"}}
+	widgets := &testAuthenticator{users: map[string]string{"bob": "b"}}
+	pa, err := New([]Realm{
+		{Name: "broken", Authenticator: broken},
+		{Name: "acme", UsernameSuffixes: []string{"@acme"},
+			Authenticator: acme},
+		{Name: "widgets", Authenticator: widgets},
+	}, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pa.ChangePassword("bob", []byte("b"), []byte("bb")); err != nil {
+		t.Fatal(err)
+	}
+	if widgets.users["bob"] != "bb" {
+		t.Fatal("password not changed")
+	}
+	if err := pa.ChangePassword("bob", []byte("b"),
+		[]byte("bb")); err != pwauth.ErrIncorrectPassword {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := pa.ChangePassword("alice@acme", []byte("a"),
+		[]byte("x")); !errors.Is(err, pwauth.ErrPasswordRejected) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := pa.ChangePassword("alice@acme", []byte("a"),
+		[]byte("aa")); err != nil {
+		t.Fatal(err)
+	}
+	if acme.users["alice"] != "aa" {
+		t.Fatal("password not changed")
+	}
+	pa, err = New([]Realm{{Name: "broken", Authenticator: broken}},
+		testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pa.ChangePassword("bob", []byte("bb"),
+		[]byte("b")); err != broken.err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -17,3 +17,55 @@ type LoginResponse struct {
 	Message         string   `json:"message"`
 	CertAuthBackend []string `json:"auth_backend"`
 }
+
+const APITokenPath = "/api/v0/apiToken"
+
+// APITokenResponse is returned by APITokenPath. The token is sent in an
+// "Authorization: Bearer" header.
+type APITokenResponse struct {
+	Token     string   `json:"token"`
+	ExpiresAt int64    `json:"expires_at"`
+	Scopes    []string `json:"scopes"`
+}
+
+const ServiceTokenPath = "/api/v0/serviceToken"
+
+// ServiceTokenResponse is returned by ServiceTokenPath. The token is a JWT
+// which the audience verifies with the keys from the JWKS endpoint.
+type ServiceTokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+const ClientTrustPath = "/public/clientTrust"
+
+// ClientTrustBundle is returned by ClientTrustPath. KnownHosts contains
+// @cert-authority lines for the SSH CA keys; X509CABundle contains PEM
+// encoded CA certificates.
+type ClientTrustBundle struct {
+	Issuer       string `json:"issuer,omitempty"`
+	KnownHosts   string `json:"known_hosts"`
+	X509CABundle string `json:"x509_ca_bundle"`
+}
+
+const ClientUpdatePath = "/public/clientUpdate"
+
+// ClientUpdateManifest is returned by ClientUpdatePath and describes the
+// latest client release. A detached JWS over the document, signed with the
+// X.509 CA key, is returned by ClientUpdatePath + ".jws". Clients older than
+// MinimumVersion should not be used.
+type ClientUpdateManifest struct {
+	Version         string           `json:"version"`
+	MinimumVersion  string           `json:"minimum_version,omitempty"`
+	ReleaseNotesURL string           `json:"release_notes_url,omitempty"`
+	Downloads       []ClientDownload `json:"downloads"`
+}
+
+// ClientDownload is the client binary for an operating system and
+// architecture, as named by GOOS and GOARCH. SHA256 is hex encoded.
+type ClientDownload struct {
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
@@ -0,0 +1,83 @@
+// Package webauthn implements the relying party side of the Web
+// Authentication API, which browsers (including mobile ones) use to talk to
+// security keys and platform authenticators.
+//
+// The CBOR, authenticator data and COSE key formats are parsed with
+// github.com/go-webauthn/webauthn/protocol. Only ES256 (ECDSA P-256)
+// credentials are supported and attestation statements are not verified.
+// Credentials registered through the older U2F API may be used for
+// authentication by way of the appid extension.
+package webauthn
+
+import (
+	"crypto/ecdsa"
+)
+
+// AlgorithmES256 is the COSE identifier of the only supported algorithm.
+const AlgorithmES256 = -7
+
+// RelyingParty identifies the web site which credentials are scoped to.
+type RelyingParty struct {
+	ID string // The domain name, e.g. keymaster.example.com.
+	// The origins the pages may be served from, e.g.
+	// https://keymaster.example.com.
+	Origins []string
+	AppID   string // The U2F application ID of legacy credentials (optional).
+}
+
+// Credential is a public key credential which has been registered.
+type Credential struct {
+	ID        []byte
+	PublicKey *ecdsa.PublicKey
+	Counter   uint32
+}
+
+// Assertion is the response of an authenticator to an authentication
+// request, as returned by navigator.credentials.get().
+type Assertion struct {
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UsedAppID         bool // The appid extension output.
+}
+
+// New returns a RelyingParty for origin, which must be a https URL without a
+// path. The relying party ID is the host name of origin.
+func New(origin string, appID string) (*RelyingParty, error) {
+	return newRelyingParty(origin, appID)
+}
+
+// NewWithOrigins returns a RelyingParty with the ID id, for pages served
+// from any of origins. The host name of each origin must be id or a
+// subdomain of it.
+func NewWithOrigins(id string, origins []string, appID string) (
+	*RelyingParty, error) {
+	return newRelyingPartyWithOrigins(id, origins, appID)
+}
+
+// VerifyRegistration verifies the response to a registration request (as
+// returned by navigator.credentials.create()) against the challenge which
+// was sent to the client, and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte,
+	clientDataJSON []byte, attestationObject []byte) (*Credential, error) {
+	return rp.verifyRegistration(challenge, clientDataJSON, attestationObject)
+}
+
+// VerifyAssertion verifies that assertion was produced by credential in
+// response to challenge, and returns the new signature counter which should
+// be stored with the credential. If legacyU2F is true, the credential was
+// registered with the U2F API and is scoped to the U2F application ID.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte,
+	credential Credential, legacyU2F bool, assertion Assertion) (
+	uint32, error) {
+	return rp.verifyAssertion(challenge, credential, legacyU2F, false,
+		assertion)
+}
+
+// VerifyUserVerifiedAssertion is like VerifyAssertion, but also requires the
+// authenticator to have verified the user, e.g. with a PIN or a fingerprint.
+// This is needed when the credential is the only factor, as with passkeys.
+func (rp *RelyingParty) VerifyUserVerifiedAssertion(challenge []byte,
+	credential Credential, assertion Assertion) (uint32, error) {
+	return rp.verifyAssertion(challenge, credential, false, true, assertion)
+}
@@ -0,0 +1,247 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+
+	coseCurveP256        = 1
+	p256CoordinateLength = 32
+)
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// attestationObject is the part of the CBOR attestation object which is
+// used. Attestation statements are not verified.
+type attestationObject struct {
+	Format   string `cbor:"fmt"`
+	AuthData []byte `cbor:"authData"`
+}
+
+// parseOrigin returns origin in the form sent by browsers, and its host name.
+func parseOrigin(origin string) (string, string, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "https" || u.Hostname() == "" || (u.Path != "" &&
+		u.Path != "/") {
+		return "", "", fmt.Errorf("invalid origin: %s", origin)
+	}
+	return u.Scheme + "://" + u.Host, u.Hostname(), nil
+}
+
+func newRelyingParty(origin string, appID string) (*RelyingParty, error) {
+	origin, hostname, err := parseOrigin(origin)
+	if err != nil {
+		return nil, err
+	}
+	return &RelyingParty{
+		ID:      hostname,
+		Origins: []string{origin},
+		AppID:   appID,
+	}, nil
+}
+
+func newRelyingPartyWithOrigins(id string, origins []string,
+	appID string) (*RelyingParty, error) {
+	if id == "" || strings.ContainsAny(id, ":/") {
+		return nil, fmt.Errorf("invalid relying party ID: %s", id)
+	}
+	if len(origins) < 1 {
+		return nil, errors.New("no origins")
+	}
+	rp := &RelyingParty{ID: id, AppID: appID}
+	for _, origin := range origins {
+		origin, hostname, err := parseOrigin(origin)
+		if err != nil {
+			return nil, err
+		}
+		if hostname != id && !strings.HasSuffix(hostname, "."+id) {
+			return nil, fmt.Errorf("origin: %s is not within: %s", origin, id)
+		}
+		rp.Origins = append(rp.Origins, origin)
+	}
+	return rp, nil
+}
+
+// decodeBase64URL decodes the challenge as encoded by browsers, tolerating
+// padding.
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte,
+	ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return fmt.Errorf("error parsing client data: %s", err)
+	}
+	if data.Type != ceremony {
+		return fmt.Errorf("unexpected ceremony type: %s", data.Type)
+	}
+	receivedChallenge, err := decodeBase64URL(data.Challenge)
+	if err != nil {
+		return fmt.Errorf("error decoding challenge: %s", err)
+	}
+	if len(challenge) < 1 || !bytes.Equal(receivedChallenge, challenge) {
+		return errors.New("challenge mismatch")
+	}
+	for _, origin := range rp.Origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin mismatch: %s", data.Origin)
+}
+
+func parseAuthenticatorData(data []byte) (*protocol.AuthenticatorData,
+	error) {
+	var authData protocol.AuthenticatorData
+	if err := authData.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("error parsing authenticator data: %s", err)
+	}
+	if !authData.Flags.UserPresent() {
+		return nil, errors.New("user was not present")
+	}
+	return &authData, nil
+}
+
+func checkRPID(authData *protocol.AuthenticatorData, id string) error {
+	rpIDHash := sha256.Sum256([]byte(id))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return errors.New("relying party ID mismatch")
+	}
+	return nil
+}
+
+// parseCOSEKey parses an EC2 P-256 public key in COSE_Key format.
+func parseCOSEKey(data []byte) (*ecdsa.PublicKey, error) {
+	decoded, err := webauthncose.ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing public key: %s", err)
+	}
+	coseKey, ok := decoded.(webauthncose.EC2PublicKeyData)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type: %T", decoded)
+	}
+	if coseKey.Algorithm != AlgorithmES256 {
+		return nil, fmt.Errorf("unsupported algorithm: %d",
+			coseKey.Algorithm)
+	}
+	if coseKey.Curve != coseCurveP256 {
+		return nil, fmt.Errorf("unsupported curve: %d", coseKey.Curve)
+	}
+	if len(coseKey.XCoord) != p256CoordinateLength ||
+		len(coseKey.YCoord) != p256CoordinateLength {
+		return nil, errors.New("bad public key coordinates")
+	}
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(coseKey.XCoord),
+		Y:     new(big.Int).SetBytes(coseKey.YCoord),
+	}
+	if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, errors.New("public key is not on the curve")
+	}
+	return publicKey, nil
+}
+
+func (rp *RelyingParty) verifyRegistration(challenge []byte,
+	clientDataJSON []byte, rawAttestation []byte) (*Credential, error) {
+	err := rp.verifyClientData(clientDataJSON, ceremonyCreate, challenge)
+	if err != nil {
+		return nil, err
+	}
+	var attestation attestationObject
+	if err := webauthncbor.Unmarshal(rawAttestation, &attestation); err != nil {
+		return nil, fmt.Errorf("error decoding attestation object: %s", err)
+	}
+	if len(attestation.AuthData) < 1 {
+		return nil, errors.New("missing authenticator data")
+	}
+	authData, err := parseAuthenticatorData(attestation.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRPID(authData, rp.ID); err != nil {
+		return nil, err
+	}
+	if !authData.Flags.HasAttestedCredentialData() {
+		return nil, errors.New("missing attested credential data")
+	}
+	publicKey, err := parseCOSEKey(authData.AttData.CredentialPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{
+		ID:        append([]byte(nil), authData.AttData.CredentialID...),
+		PublicKey: publicKey,
+		Counter:   authData.Counter,
+	}, nil
+}
+
+func (rp *RelyingParty) verifyAssertion(challenge []byte,
+	credential Credential, legacyU2F bool, requireUserVerification bool,
+	assertion Assertion) (uint32, error) {
+	if credential.PublicKey == nil {
+		return 0, errors.New("credential has no public key")
+	}
+	err := rp.verifyClientData(assertion.ClientDataJSON, ceremonyGet,
+		challenge)
+	if err != nil {
+		return 0, err
+	}
+	authData, err := parseAuthenticatorData(assertion.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	rpID := rp.ID
+	if legacyU2F {
+		if !assertion.UsedAppID || rp.AppID == "" {
+			return 0, errors.New("U2F credential used without appid")
+		}
+		rpID = rp.AppID
+	}
+	if err := checkRPID(authData, rpID); err != nil {
+		return 0, err
+	}
+	if requireUserVerification && !authData.Flags.UserVerified() {
+		return 0, errors.New("user was not verified")
+	}
+	clientDataHash := sha256.Sum256(assertion.ClientDataJSON)
+	signed := sha256.New()
+	signed.Write(assertion.AuthenticatorData)
+	signed.Write(clientDataHash[:])
+	if !ecdsa.VerifyASN1(credential.PublicKey, signed.Sum(nil),
+		assertion.Signature) {
+		return 0, errors.New("signature verification failed")
+	}
+	// Authenticators without a counter always report zero.
+	if (authData.Counter != 0 || credential.Counter != 0) &&
+		authData.Counter <= credential.Counter {
+		return 0, errors.New("counter did not increase, authenticator may be cloned")
+	}
+	return authData.Counter, nil
+}
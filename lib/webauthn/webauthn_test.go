@@ -0,0 +1,296 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+)
+
+const testOrigin = "https://keymaster.example.com"
+
+var testCredentialID = []byte("test-credential")
+
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+)
+
+func makeCOSEKey(t *testing.T, publicKey *ecdsa.PublicKey) []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	publicKey.X.FillBytes(x)
+	publicKey.Y.FillBytes(y)
+	// kty: EC2, alg, crv, x and y.
+	data, err := webauthncbor.Marshal(map[int]interface{}{
+		1:  2,
+		3:  AlgorithmES256,
+		-1: coseCurveP256,
+		-2: x,
+		-3: y,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func makeAuthenticatorData(rpID string, flags byte, counter uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], counter)
+	return data
+}
+
+func makeClientData(t *testing.T, ceremony string, challenge []byte,
+	origin string) []byte {
+	data, err := json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func makeAttestationObject(t *testing.T, rpID string,
+	publicKey *ecdsa.PublicKey) []byte {
+	authData := makeAuthenticatorData(rpID,
+		flagUserPresent|flagAttestedCredentialData, 0)
+	authData = append(authData, make([]byte, 16)...) // AAGUID.
+	authData = append(authData, 0, byte(len(testCredentialID)))
+	authData = append(authData, testCredentialID...)
+	authData = append(authData, makeCOSEKey(t, publicKey)...)
+	data, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func makeAssertion(t *testing.T, privateKey *ecdsa.PrivateKey, rpID string,
+	challenge []byte, counter uint32) Assertion {
+	return makeAssertionWithFlags(t, privateKey, rpID, challenge, counter,
+		flagUserPresent)
+}
+
+func makeAssertionWithFlags(t *testing.T, privateKey *ecdsa.PrivateKey,
+	rpID string, challenge []byte, counter uint32, flags byte) Assertion {
+	assertion := Assertion{
+		ClientDataJSON: makeClientData(t, ceremonyGet, challenge,
+			testOrigin),
+		AuthenticatorData: makeAuthenticatorData(rpID, flags, counter),
+	}
+	clientDataHash := sha256.Sum256(assertion.ClientDataJSON)
+	signed := sha256.Sum256(append(append([]byte(nil),
+		assertion.AuthenticatorData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, privateKey, signed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertion.Signature = signature
+	return assertion
+}
+
+func TestRegisterAndAuthenticate(t *testing.T) {
+	rp, err := New(testOrigin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := []byte("registration challenge")
+	attestationObject := makeAttestationObject(t, rp.ID, &privateKey.PublicKey)
+	// Reject a response to another challenge or from another origin.
+	_, err = rp.VerifyRegistration([]byte("other challenge"),
+		makeClientData(t, ceremonyCreate, challenge, testOrigin),
+		attestationObject)
+	if err == nil {
+		t.Fatal("accepted registration for wrong challenge")
+	}
+	_, err = rp.VerifyRegistration(challenge,
+		makeClientData(t, ceremonyCreate, challenge, "https://evil.com"),
+		attestationObject)
+	if err == nil {
+		t.Fatal("accepted registration from wrong origin")
+	}
+	credential, err := rp.VerifyRegistration(challenge,
+		makeClientData(t, ceremonyCreate, challenge, testOrigin),
+		attestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(credential.ID, testCredentialID) {
+		t.Fatalf("bad credential ID: %s", credential.ID)
+	}
+	if credential.PublicKey.X.Cmp(privateKey.X) != 0 {
+		t.Fatal("public key mismatch")
+	}
+	challenge = []byte("authentication challenge")
+	counter, err := rp.VerifyAssertion(challenge, *credential, false,
+		makeAssertion(t, privateKey, rp.ID, challenge, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter != 5 {
+		t.Fatalf("expected counter 5, got %d", counter)
+	}
+	credential.Counter = counter
+	_, err = rp.VerifyAssertion(challenge, *credential, false,
+		makeAssertion(t, privateKey, rp.ID, challenge, 5))
+	if err == nil {
+		t.Fatal("accepted assertion with stale counter")
+	}
+	assertion := makeAssertion(t, privateKey, rp.ID, challenge, 6)
+	assertion.Signature[len(assertion.Signature)-1] ^= 1
+	if _, err := rp.VerifyAssertion(challenge, *credential, false,
+		assertion); err == nil {
+		t.Fatal("accepted bad signature")
+	}
+}
+
+func TestRegisterMalformed(t *testing.T) {
+	rp, err := New(testOrigin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := []byte("registration challenge")
+	clientDataJSON := makeClientData(t, ceremonyCreate, challenge, testOrigin)
+	valid := makeAttestationObject(t, rp.ID, &privateKey.PublicKey)
+	withoutAuthData, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":     "none",
+		"attStmt": map[string]interface{}{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutCredential, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": makeAuthenticatorData(rp.ID, flagUserPresent, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, attestationObject := range map[string][]byte{
+		"empty":              nil,
+		"truncated":          valid[:len(valid)-8],
+		"missing authData":   withoutAuthData,
+		"missing credential": withoutCredential,
+	} {
+		_, err := rp.VerifyRegistration(challenge, clientDataJSON,
+			attestationObject)
+		if err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestUserVerifiedAuthenticate(t *testing.T) {
+	rp, err := New(testOrigin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential := Credential{PublicKey: &privateKey.PublicKey}
+	challenge := []byte("authentication challenge")
+	if _, err := rp.VerifyUserVerifiedAssertion(challenge, credential,
+		makeAssertion(t, privateKey, rp.ID, challenge, 0)); err == nil {
+		t.Fatal("accepted assertion without user verification")
+	}
+	assertion := makeAssertionWithFlags(t, privateKey, rp.ID, challenge, 0,
+		flagUserPresent|flagUserVerified)
+	if _, err := rp.VerifyUserVerifiedAssertion(challenge, credential,
+		assertion); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyU2FAuthenticate(t *testing.T) {
+	rp, err := New(testOrigin+"/", testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential := Credential{PublicKey: &privateKey.PublicKey}
+	challenge := []byte("authentication challenge")
+	assertion := makeAssertion(t, privateKey, rp.AppID, challenge, 1)
+	if _, err := rp.VerifyAssertion(challenge, credential, true,
+		assertion); err == nil {
+		t.Fatal("accepted U2F credential without appid extension")
+	}
+	assertion.UsedAppID = true
+	if _, err := rp.VerifyAssertion(challenge, credential, true,
+		assertion); err != nil {
+		t.Fatal(err)
+	}
+	// A WebAuthn credential must be scoped to the relying party ID.
+	if _, err := rp.VerifyAssertion(challenge, credential, false,
+		assertion); err == nil {
+		t.Fatal("accepted assertion for the wrong relying party")
+	}
+}
+
+func TestNewWithOrigins(t *testing.T) {
+	_, err := NewWithOrigins("example.com",
+		[]string{"https://keymaster.evil.com"}, "")
+	if err == nil {
+		t.Fatal("accepted origin outside of the relying party ID")
+	}
+	_, err = NewWithOrigins("https://example.com", []string{testOrigin}, "")
+	if err == nil {
+		t.Fatal("accepted URL as relying party ID")
+	}
+	rp, err := NewWithOrigins("example.com",
+		[]string{testOrigin, "https://keymaster2.example.com:8443/"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := []byte("registration challenge")
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestationObject := makeAttestationObject(t, rp.ID, &privateKey.PublicKey)
+	_, err = rp.VerifyRegistration(challenge,
+		makeClientData(t, ceremonyCreate, challenge,
+			"https://keymaster2.example.com:8443"),
+		attestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rp.VerifyRegistration(challenge,
+		makeClientData(t, ceremonyCreate, challenge,
+			"https://keymaster3.example.com"),
+		attestationObject)
+	if err == nil {
+		t.Fatal("accepted registration from unlisted origin")
+	}
+}
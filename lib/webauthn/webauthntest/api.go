@@ -0,0 +1,73 @@
+// Package webauthntest provides a software WebAuthn authenticator, so that
+// the registration and authentication flows of a relying party can be tested
+// without a security key or a browser.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+)
+
+// Authenticator holds a single ES256 credential, like a security key
+// registered once. It is not safe for concurrent use.
+type Authenticator struct {
+	// Origin is reported in the client data, as a browser would report the
+	// origin of the page.
+	Origin string
+	// UserVerified makes the authenticator claim that it verified the user,
+	// as needed for passkeys.
+	UserVerified bool
+	credentialID []byte
+	privateKey   *ecdsa.PrivateKey
+	counter      uint32
+}
+
+// Registration is the response to a registration request, as returned by
+// navigator.credentials.create().
+type Registration struct {
+	CredentialID      []byte
+	ClientDataJSON    []byte
+	AttestationObject []byte
+}
+
+// Assertion is the response to an authentication request, as returned by
+// navigator.credentials.get().
+type Assertion struct {
+	CredentialID      []byte
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UserHandle        []byte
+}
+
+// New returns an authenticator with a new random credential, for pages
+// served from origin.
+func New(origin string) (*Authenticator, error) {
+	return newAuthenticator(origin)
+}
+
+// CredentialID returns the ID of the credential.
+func (a *Authenticator) CredentialID() []byte {
+	return a.credentialID
+}
+
+// PublicKey returns the public key of the credential.
+func (a *Authenticator) PublicKey() *ecdsa.PublicKey {
+	return &a.privateKey.PublicKey
+}
+
+// Register returns the response to a registration request with challenge
+// from the relying party with the ID rpID, using "none" attestation.
+func (a *Authenticator) Register(rpID string, challenge []byte) (
+	*Registration, error) {
+	return a.register(rpID, challenge)
+}
+
+// Sign returns the response to an authentication request with challenge
+// from the relying party with the ID rpID (or the U2F application ID for
+// credentials registered through the U2F API). The signature counter is
+// incremented each time. The userHandle is returned with the assertion, as
+// for discoverable credentials, and may be nil.
+func (a *Authenticator) Sign(rpID string, challenge []byte,
+	userHandle []byte) (*Assertion, error) {
+	return a.sign(rpID, challenge, userHandle)
+}
@@ -0,0 +1,142 @@
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+)
+
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+
+	coseKeyType    = 1
+	coseAlgorithm  = 3
+	coseEC2Curve   = -1
+	coseEC2X       = -2
+	coseEC2Y       = -3
+	coseKeyTypeEC2 = 2
+	coseCurveP256  = 1
+	coseES256      = -7
+)
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func newAuthenticator(origin string) (*Authenticator, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	credentialID := make([]byte, 32)
+	if _, err := rand.Read(credentialID); err != nil {
+		return nil, err
+	}
+	return &Authenticator{
+		Origin:       origin,
+		credentialID: credentialID,
+		privateKey:   privateKey,
+	}, nil
+}
+
+func (a *Authenticator) makeCOSEKey() ([]byte, error) {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.privateKey.X.FillBytes(x)
+	a.privateKey.Y.FillBytes(y)
+	return webauthncbor.Marshal(map[int]interface{}{
+		coseKeyType:   coseKeyTypeEC2,
+		coseAlgorithm: coseES256,
+		coseEC2Curve:  coseCurveP256,
+		coseEC2X:      x,
+		coseEC2Y:      y,
+	})
+}
+
+func (a *Authenticator) makeAuthenticatorData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if a.UserVerified {
+		flags |= flagUserVerified
+	}
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags|flagUserPresent, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.counter)
+	return data
+}
+
+func (a *Authenticator) makeClientData(ceremony string,
+	challenge []byte) ([]byte, error) {
+	return json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.Origin,
+	})
+}
+
+func (a *Authenticator) register(rpID string, challenge []byte) (
+	*Registration, error) {
+	clientDataJSON, err := a.makeClientData(ceremonyCreate, challenge)
+	if err != nil {
+		return nil, err
+	}
+	authData := a.makeAuthenticatorData(rpID, flagAttestedCredentialData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID.
+	authData = append(authData, byte(len(a.credentialID)>>8),
+		byte(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	coseKey, err := a.makeCOSEKey()
+	if err != nil {
+		return nil, err
+	}
+	authData = append(authData, coseKey...)
+	attestationObject, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Registration{
+		CredentialID:      a.credentialID,
+		ClientDataJSON:    clientDataJSON,
+		AttestationObject: attestationObject,
+	}, nil
+}
+
+func (a *Authenticator) sign(rpID string, challenge []byte,
+	userHandle []byte) (*Assertion, error) {
+	clientDataJSON, err := a.makeClientData(ceremonyGet, challenge)
+	if err != nil {
+		return nil, err
+	}
+	a.counter++
+	authenticatorData := a.makeAuthenticatorData(rpID, 0)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := sha256.Sum256(append(append([]byte(nil),
+		authenticatorData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.privateKey, signed[:])
+	if err != nil {
+		return nil, err
+	}
+	return &Assertion{
+		CredentialID:      a.credentialID,
+		ClientDataJSON:    clientDataJSON,
+		AuthenticatorData: authenticatorData,
+		Signature:         signature,
+		UserHandle:        userHandle,
+	}, nil
+}
@@ -0,0 +1,67 @@
+package webauthntest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Cloud-Foundations/keymaster/lib/webauthn"
+)
+
+const testOrigin = "https://keymaster.example.com"
+
+func TestRegisterAndSign(t *testing.T) {
+	rp, err := webauthn.New(testOrigin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := New(testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := []byte("registration challenge")
+	registration, err := authenticator.Register(rp.ID, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := rp.VerifyRegistration(challenge,
+		registration.ClientDataJSON, registration.AttestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(credential.ID, authenticator.CredentialID()) {
+		t.Fatal("credential ID mismatch")
+	}
+	for _, expectedCounter := range []uint32{1, 2} {
+		challenge := []byte("authentication challenge")
+		assertion, err := authenticator.Sign(rp.ID, challenge, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counter, err := rp.VerifyAssertion(challenge, *credential, false,
+			webauthn.Assertion{
+				ClientDataJSON:    assertion.ClientDataJSON,
+				AuthenticatorData: assertion.AuthenticatorData,
+				Signature:         assertion.Signature,
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counter != expectedCounter {
+			t.Fatalf("expected counter %d, got %d", expectedCounter, counter)
+		}
+		credential.Counter = counter
+	}
+	assertion, err := authenticator.Sign(rp.ID, challenge, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rp.VerifyUserVerifiedAssertion(challenge, *credential,
+		webauthn.Assertion{
+			ClientDataJSON:    assertion.ClientDataJSON,
+			AuthenticatorData: assertion.AuthenticatorData,
+			Signature:         assertion.Signature,
+		})
+	if err == nil {
+		t.Fatal("user verification claimed without UserVerified")
+	}
+}